@@ -0,0 +1,355 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package apply
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/skyscanner/turbolift/cmd/flags"
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/codemod"
+	"github.com/skyscanner/turbolift/internal/colors"
+	"github.com/skyscanner/turbolift/internal/git"
+	"github.com/skyscanner/turbolift/internal/interrupt"
+	"github.com/skyscanner/turbolift/internal/logging"
+	"github.com/skyscanner/turbolift/internal/messages"
+	"github.com/skyscanner/turbolift/internal/summary"
+)
+
+var g git.Git = git.NewRealGit()
+
+var (
+	patches  []string
+	expr     string
+	replace  string
+	globs    []string
+	repoFile string
+	threeWay bool
+	check    bool
+	dryRun   bool
+	shuffle  string
+	resume   bool
+)
+
+func NewApplyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "apply (--patch PATCH_FILE | --regex REGEX --replace REPLACEMENT [--glob GLOB])",
+		Short: "Applies a patch, or replaces the matches of a regular expression, in all working copies",
+		Long: `Applies a patch, or a series of patches, to all working copies using git apply, or replaces the matches of a
+regular expression in their files.
+
+Patches are unified diffs, such as those made by git diff or git format-patch, with paths relative to the root of
+each repo, or to the campaign's directory within it for campaigns over part of a monorepo. Give --patch more than
+once, or give it a directory, to apply a series in order; the .patch and .diff files in a directory are applied in
+name order. Patches that have already been applied to a working copy are skipped, so it can be run again safely.
+
+Repos that a patch does not apply to are reported with the files it did not apply to, and are left with whatever
+patches of the series applied before it. Use --3way to merge those patches instead, leaving conflict markers to
+resolve by hand where they cannot be merged, or --check to find out which repos they apply to without changing any.
+
+With --regex, every match of the regular expression in the files matching --glob is replaced with --replace, in
+which $1 or ${name} stand for the text of a group. Globs are relative to the root of each repo, and ** matches any
+number of directories, e.g. '**/Dockerfile'. Every file is searched if no glob is given. Use (?m) at the start of
+the expression for ^ and $ to match at the start and end of each line. Use --dry-run to see the replacements that
+would be made before making them.`,
+		Run: run,
+	}
+
+	cmd.Flags().StringSliceVar(&patches, "patch", nil, "A patch file, or a directory of .patch and .diff files, to apply (may be given more than once)")
+	cmd.Flags().StringVar(&expr, "regex", "", "A regular expression, in Go's syntax, whose matches are to be replaced")
+	cmd.Flags().StringVar(&replace, "replace", "", "The text to replace the matches of --regex with")
+	cmd.Flags().StringSliceVar(&globs, "glob", nil, "Only replace matches in the files matching this glob, e.g. '**/Dockerfile' (may be given more than once)")
+	cmd.Flags().StringVar(&repoFile, "repos", "repos.txt", "A file containing a list of repositories to clone.")
+	cmd.Flags().BoolVar(&threeWay, "3way", false, "Fall back to a three-way merge for patches that do not apply cleanly, leaving conflict markers where they cannot be merged")
+	cmd.Flags().BoolVar(&check, "check", false, "Only check whether the patches apply to each working copy, without changing any")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show what would be changed in each working copy, without changing any")
+	flags.AddShuffleFlag(cmd, &shuffle)
+	flags.AddResumeFlag(cmd, &resume)
+	flags.MarkMakesChanges(cmd)
+
+	return cmd
+}
+
+func run(c *cobra.Command, _ []string) {
+	ctx := c.Context()
+	logger := logging.NewLogger(c)
+
+	// checking whether patches apply is their dry run
+	dryRun = dryRun || check
+	if err := validateFlags(c); err != nil {
+		logger.Errorf("%s", err)
+		return
+	}
+
+	var patchFiles []string
+	var replacement *codemod.Replacement
+	var err error
+	if expr != "" {
+		replacement, err = codemod.New(expr, replace, globs)
+	} else {
+		patchFiles, err = findPatchFiles(patches)
+	}
+	if err != nil {
+		logger.Errorf("%s", err)
+		return
+	}
+
+	readCampaignActivity := logger.StartActivity("Reading campaign data (%s)", repoFile)
+	options := campaign.NewCampaignOptions()
+	options.RepoFilename = repoFile
+	options.Shuffle = shuffle
+	dir, err := campaign.OpenCampaign(options)
+	if err != nil {
+		readCampaignActivity.EndWithFailure(err)
+		return
+	}
+	readCampaignActivity.EndWithSuccess()
+	flags.LogShuffle(logger, dir)
+
+	command := "apply"
+	if dryRun {
+		command = "apply --dry-run"
+	}
+	progress, err := campaign.NewProgress(command, dir.BranchName, resume)
+	if err != nil {
+		logger.Errorf("%s", err)
+		return
+	}
+	if progress.Resumed() > 0 {
+		logger.Printf("Resuming the last run, so leaving alone the %d repos it had finished with", progress.Resumed())
+	}
+
+	sum := summary.New("apply")
+	conflicts := 0
+	replacements := 0
+	for i, repo := range dir.Repos {
+		if interrupt.Requested(ctx) {
+			logger.Warnf("Interrupted, so not applying patches in the remaining %d repos", len(dir.Repos)-i)
+			break
+		}
+		if progress.AlreadyCompleted(repo) {
+			continue
+		}
+
+		var activity *logging.Activity
+		if replacement != nil {
+			activity = logger.StartActivity("Replacing %s in %s", expr, repo.FullRepoName)
+		} else if dryRun {
+			activity = logger.StartActivity("Checking %s in %s", describePatches(patchFiles), repo.FullRepoName)
+		} else {
+			activity = logger.StartActivity("Applying %s in %s", describePatches(patchFiles), repo.FullRepoName)
+		}
+
+		// skip if the working copy does not exist
+		if _, err = os.Stat(repo.FullRepoPath()); os.IsNotExist(err) {
+			activity.EndWithWarningf("Directory %s does not exist - has it been cloned?", repo.FullRepoPath())
+			sum.RecordSkippedAs(repo, summary.SkippedNotCloned, "not cloned")
+			continue
+		}
+
+		if replacement != nil {
+			replaced, err := replaceInRepo(activity, replacement, repo)
+			if err != nil {
+				activity.EndWithFailure(err)
+				sum.RecordErrored(repo, err)
+				continue
+			}
+			if replaced == 0 {
+				activity.EndWithWarning("No matches - skipping")
+				sum.RecordSkipped(repo, "no matches")
+				progress.Complete(repo)
+				continue
+			}
+			replacements += replaced
+			activity.EndWithSuccessAndEmitLogs()
+			sum.RecordDone(repo)
+			progress.Complete(repo)
+			continue
+		}
+
+		applied, alreadyApplied, err := applyPatches(ctx, activity, repo, patchFiles)
+		if err != nil {
+			if _, ok := git.AsPatchConflict(err); ok {
+				conflicts++
+			}
+			activity.EndWithFailure(err)
+			sum.RecordErrored(repo, err)
+			continue
+		}
+		if applied == 0 {
+			activity.EndWithWarning("Already applied - skipping")
+			sum.RecordSkipped(repo, "already applied")
+			progress.Complete(repo)
+			continue
+		}
+		if alreadyApplied > 0 {
+			activity.Logf("%d of the patches had already been applied", alreadyApplied)
+			activity.EndWithSuccessAndEmitLogs()
+		} else {
+			activity.EndWithSuccess()
+		}
+		sum.RecordDone(repo)
+		progress.Complete(repo)
+	}
+
+	sum.Finish(ctx)
+	if interrupt.Requested(ctx) {
+		logger.Warnf("%s %s(%s, %s, %s)\n", dir.Messages.Format(messages.SummaryInterrupted, messages.Fields{"Command": "apply"}), colors.Normal(), colors.Green(sum.Done, " OK"), colors.Yellow(sum.Skipped, " skipped"), colors.Red(sum.Errored, " errored"))
+	} else if sum.Errored == 0 {
+		logger.Successf("%s %s(%s, %s)\n", dir.Messages.Format(messages.SummaryCompleted, messages.Fields{"Command": "apply"}), colors.Normal(), colors.Green(sum.Done, " OK"), colors.Yellow(sum.Skipped, " skipped"))
+	} else {
+		logger.Warnf("%s %s(%s, %s, %s)\n", dir.Messages.Format(messages.SummaryWithErrors, messages.Fields{"Command": "apply"}), colors.Normal(), colors.Green(sum.Done, " OK"), colors.Yellow(sum.Skipped, " skipped"), colors.Red(sum.Errored, " errored"))
+	}
+
+	if replacement != nil {
+		if dryRun {
+			logger.Printf("%d replacements would be made in %d repos. Run again without --dry-run to make them", replacements, sum.Done)
+		} else {
+			logger.Printf("%d replacements made in %d repos", replacements, sum.Done)
+		}
+	}
+	if conflicts > 0 {
+		if threeWay {
+			logger.Printf("Resolve the conflict markers left in %d repos, then commit them with %s", conflicts, colors.Cyan("turbolift commit"))
+		} else {
+			logger.Printf("The patches did not apply to %d repos. Try %s to merge them instead", conflicts, colors.Cyan(c.CommandPath()+" --3way"))
+		}
+	}
+
+	if err := progress.End(interrupt.Requested(ctx)); err != nil {
+		logger.Warnf("Unable to update the checkpoint in the campaign state: %s", err)
+	} else if interrupt.Requested(ctx) {
+		logger.Printf("To carry on from where it stopped, run %s again with the same options", colors.Cyan(c.CommandPath()+" --resume"))
+	}
+}
+
+// validateFlags checks that either patches or a regular expression have been given, along with only the flags that
+// go with them
+func validateFlags(c *cobra.Command) error {
+	switch {
+	case len(patches) == 0 && expr == "":
+		return errors.New("give the patch to apply with --patch, or the regular expression to replace with --regex")
+	case len(patches) > 0 && expr != "":
+		return errors.New("--patch and --regex cannot be used together")
+	case expr != "" && !c.Flags().Changed("replace"):
+		return errors.New("give the text to replace the matches of --regex with using --replace")
+	case expr == "" && (c.Flags().Changed("replace") || len(globs) > 0):
+		return errors.New("--replace and --glob can only be used with --regex")
+	case expr != "" && threeWay:
+		return errors.New("--3way can only be used with --patch")
+	case dryRun && threeWay:
+		return errors.New("--3way cannot be used with --check or --dry-run, as a three-way merge can only be tried by making it")
+	}
+	return nil
+}
+
+// replaceInRepo makes the replacement in the working copy, or in the campaign's directory within it, logging the
+// number of replacements in each file, and each replacement in a dry run
+func replaceInRepo(activity *logging.Activity, replacement *codemod.Replacement, repo campaign.Repo) (int, error) {
+	result, err := replacement.Run(repo.WorkingDir(), dryRun)
+	if err != nil {
+		return 0, err
+	}
+	for _, file := range result.Files {
+		if !dryRun {
+			activity.Logf("%s: %d replacements", file.Path, len(file.Changes))
+			continue
+		}
+		for _, change := range file.Changes {
+			activity.Logf("%s:%d: %s -> %s", file.Path, change.Line, change.Old, change.New)
+		}
+	}
+	if result.Replacements() > 0 {
+		activity.Logf("%d replacements in %d files", result.Replacements(), len(result.Files))
+	}
+	return result.Replacements(), nil
+}
+
+// applyPatches applies the patches to the repo's working copy in order, stopping at the first that does not apply.
+// Patches that do not apply because they have already been applied are counted, rather than stopping there.
+func applyPatches(ctx context.Context, activity *logging.Activity, repo campaign.Repo, patchFiles []string) (applied int, alreadyApplied int, err error) {
+	options := git.ApplyOptions{ThreeWay: threeWay, Check: dryRun, Directory: repo.Path}
+	for _, patchFile := range patchFiles {
+		if interrupt.Requested(ctx) {
+			return applied, alreadyApplied, errors.New("interrupted part way through the patches")
+		}
+		err := g.Apply(ctx, activity.Writer(), repo.FullRepoPath(), patchFile, options)
+		if err == nil {
+			applied++
+			continue
+		}
+		// a patch that can be undone has already been applied, by an earlier run or another patch of the series
+		if conflictErr, ok := git.AsPatchConflict(err); ok && !conflictErr.Conflicted {
+			reverse := git.ApplyOptions{Check: true, Reverse: true, Directory: repo.Path}
+			if g.Apply(ctx, activity.Writer(), repo.FullRepoPath(), patchFile, reverse) == nil {
+				alreadyApplied++
+				continue
+			}
+		}
+		return applied, alreadyApplied, err
+	}
+	return applied, alreadyApplied, nil
+}
+
+// findPatchFiles turns the patches given into the absolute paths of the files to apply, in order. Directories are
+// replaced by the .patch and .diff files in them, in name order.
+func findPatchFiles(patches []string) ([]string, error) {
+	var patchFiles []string
+	for _, patch := range patches {
+		absPatch, err := filepath.Abs(patch)
+		if err != nil {
+			return nil, err
+		}
+		info, err := os.Stat(absPatch)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read patch %s: %w", patch, err)
+		}
+		if !info.IsDir() {
+			patchFiles = append(patchFiles, absPatch)
+			continue
+		}
+
+		entries, err := os.ReadDir(absPatch)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read patches in %s: %w", patch, err)
+		}
+		found := false
+		for _, entry := range entries {
+			if !entry.IsDir() && (strings.HasSuffix(entry.Name(), ".patch") || strings.HasSuffix(entry.Name(), ".diff")) {
+				patchFiles = append(patchFiles, filepath.Join(absPatch, entry.Name()))
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no .patch or .diff files found in %s", patch)
+		}
+	}
+	return patchFiles, nil
+}
+
+func describePatches(patchFiles []string) string {
+	if len(patchFiles) == 1 {
+		return filepath.Base(patchFiles[0])
+	}
+	return fmt.Sprintf("%d patches", len(patchFiles))
+}
@@ -0,0 +1,243 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package apply
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/git"
+	"github.com/skyscanner/turbolift/internal/summary"
+	"github.com/skyscanner/turbolift/internal/testsupport"
+)
+
+func TestItAppliesThePatchToEveryWorkingCopy(t *testing.T) {
+	fakeGit := git.NewAlwaysSucceedsFakeGit()
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+	patch := writePatch("fix.patch")
+
+	out, err := runCommand("--patch", "fix.patch")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Applying fix.patch in org/repo1")
+	assert.Contains(t, out, "turbolift apply completed")
+	assert.Contains(t, out, "2 OK, 0 skipped")
+
+	fakeGit.AssertCalledWith(t, [][]string{
+		{"apply", "work/org/repo1", patch},
+		{"apply", "work/org/repo2", patch},
+	})
+}
+
+func TestItAppliesASeriesOfPatchesFromADirectoryInNameOrder(t *testing.T) {
+	fakeGit := git.NewAlwaysSucceedsFakeGit()
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+	_ = os.Mkdir("patches", 0o755)
+	second := writePatch(filepath.Join("patches", "0002-second.diff"))
+	first := writePatch(filepath.Join("patches", "0001-first.patch"))
+	_ = os.WriteFile(filepath.Join("patches", "README.txt"), []byte("not a patch"), 0o644)
+
+	out, err := runCommand("--patch", "patches", "--3way")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Applying 2 patches in org/repo1")
+
+	fakeGit.AssertCalledWith(t, [][]string{
+		{"apply", "work/org/repo1", first, "--3way"},
+		{"apply", "work/org/repo1", second, "--3way"},
+	})
+}
+
+func TestItReportsReposThatThePatchDoesNotApplyTo(t *testing.T) {
+	fakeGit := git.NewFakeGit(func(output io.Writer, call []string) (bool, error) {
+		if call[1] == "work/org/repo1" {
+			return false, &git.PatchConflictError{Patch: "fix.patch", Files: []string{"a.txt"}, Err: errors.New("exit status 1")}
+		}
+		return true, nil
+	})
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+	patch := writePatch("fix.patch")
+
+	out, sum, err := runCommandForSummary("--patch", "fix.patch")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "patch fix.patch does not apply to a.txt")
+	assert.Contains(t, out, "1 OK, 0 skipped, 1 errored")
+	assert.Contains(t, out, "The patches did not apply to 1 repos. Try apply --3way to merge them instead")
+	assert.Equal(t, summary.CategoryConflict, sum.Repos[0].Category)
+
+	fakeGit.AssertCalledWith(t, [][]string{
+		{"apply", "work/org/repo1", patch},
+		{"apply", "work/org/repo1", patch, "--check", "--reverse"},
+		{"apply", "work/org/repo2", patch},
+	})
+}
+
+func TestItSkipsReposThatThePatchHasAlreadyBeenAppliedTo(t *testing.T) {
+	fakeGit := git.NewFakeGit(func(output io.Writer, call []string) (bool, error) {
+		if len(call) == 3 {
+			return false, &git.PatchConflictError{Patch: "fix.patch", Files: []string{"a.txt"}, Err: errors.New("exit status 1")}
+		}
+		return true, nil
+	})
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+	writePatch("fix.patch")
+
+	out, err := runCommand("--patch", "fix.patch")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Already applied - skipping")
+	assert.Contains(t, out, "0 OK, 1 skipped")
+}
+
+func TestItChecksPatchesWithoutApplyingThem(t *testing.T) {
+	fakeGit := git.NewAlwaysSucceedsFakeGit()
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+	patch := writePatch("fix.patch")
+
+	out, err := runCommand("--patch", "fix.patch", "--check")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Checking fix.patch in org/repo1")
+
+	fakeGit.AssertCalledWith(t, [][]string{
+		{"apply", "work/org/repo1", patch, "--check"},
+	})
+}
+
+func TestItNeedsAPatchThatExists(t *testing.T) {
+	fakeGit := git.NewAlwaysSucceedsFakeGit()
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runCommand("--patch", "missing.patch")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "unable to read patch missing.patch")
+
+	fakeGit.AssertCalledWith(t, [][]string{})
+}
+
+func TestItReplacesMatchesInTheFilesMatchingTheGlob(t *testing.T) {
+	fakeGit := git.NewAlwaysSucceedsFakeGit()
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+	writeRepoFile("work/org/repo1/Dockerfile", "FROM ubuntu:18.04\n")
+	writeRepoFile("work/org/repo1/build/Dockerfile", "FROM ubuntu:18.04 AS build\n")
+	writeRepoFile("work/org/repo1/README.md", "FROM ubuntu:18.04\n")
+	writeRepoFile("work/org/repo2/Dockerfile", "FROM alpine:3.18\n")
+
+	out, err := runCommand("--regex", `FROM ubuntu:18\.04`, "--replace", "FROM ubuntu:22.04", "--glob", "**/Dockerfile")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "2 replacements in 2 files")
+	assert.Contains(t, out, "No matches - skipping")
+	assert.Contains(t, out, "1 OK, 1 skipped")
+	assert.Contains(t, out, "2 replacements made in 1 repos")
+
+	assert.Equal(t, "FROM ubuntu:22.04\n", readRepoFile("work/org/repo1/Dockerfile"))
+	assert.Equal(t, "FROM ubuntu:22.04 AS build\n", readRepoFile("work/org/repo1/build/Dockerfile"))
+	assert.Equal(t, "FROM ubuntu:18.04\n", readRepoFile("work/org/repo1/README.md"))
+
+	// replacements are made natively, not with git
+	fakeGit.AssertCalledWith(t, [][]string{})
+}
+
+func TestADryRunPreviewsReplacementsWithoutMakingThem(t *testing.T) {
+	g = git.NewAlwaysSucceedsFakeGit()
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+	writeRepoFile("work/org/repo1/Dockerfile", "# base image\nFROM ubuntu:18.04\n")
+
+	out, err := runCommand("--regex", `ubuntu:(\d+)\.04`, "--replace", "ubuntu:22.04", "--dry-run")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Dockerfile:2: ubuntu:18.04 -> ubuntu:22.04")
+	assert.Contains(t, out, "1 replacements would be made in 1 repos")
+	assert.Equal(t, "# base image\nFROM ubuntu:18.04\n", readRepoFile("work/org/repo1/Dockerfile"))
+}
+
+func TestItNeedsAReplacementForTheRegex(t *testing.T) {
+	g = git.NewAlwaysSucceedsFakeGit()
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runCommand("--regex", "FROM ubuntu")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "give the text to replace the matches of --regex with using --replace")
+
+	out, err = runCommand("--regex", "FROM ubuntu", "--replace", "", "--patch", "fix.patch")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "--patch and --regex cannot be used together")
+}
+
+func writeRepoFile(name string, contents string) {
+	_ = os.MkdirAll(filepath.Dir(name), 0o755)
+	if err := os.WriteFile(name, []byte(contents), 0o644); err != nil {
+		panic(err)
+	}
+}
+
+func readRepoFile(name string) string {
+	contents, err := os.ReadFile(name)
+	if err != nil {
+		panic(err)
+	}
+	return string(contents)
+}
+
+// writePatch creates a patch file in the campaign directory, returning its absolute path
+func writePatch(name string) string {
+	if err := os.WriteFile(name, []byte("--- a/a.txt\n+++ b/a.txt\n"), 0o644); err != nil {
+		panic(err)
+	}
+	abs, _ := filepath.Abs(name)
+	return abs
+}
+
+func runCommand(args ...string) (string, error) {
+	cmd := NewApplyCmd()
+	outBuffer := bytes.NewBufferString("")
+	cmd.SetOut(outBuffer)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	if err != nil {
+		return outBuffer.String(), err
+	}
+	return outBuffer.String(), nil
+}
+
+// runCommandForSummary runs the command as runCommand does, also returning the summary that it finished with
+func runCommandForSummary(args ...string) (string, *summary.Summary, error) {
+	ctx, collector := summary.WithCollector(context.Background())
+	cmd := NewApplyCmd()
+	outBuffer := bytes.NewBufferString("")
+	cmd.SetOut(outBuffer)
+	cmd.SetArgs(args)
+	err := cmd.ExecuteContext(ctx)
+	return outBuffer.String(), collector.Summary(), err
+}
@@ -0,0 +1,187 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package campaigns
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/rodaine/table"
+	"github.com/spf13/cobra"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/colors"
+	"github.com/skyscanner/turbolift/internal/logging"
+)
+
+var (
+	searchPath string
+	depth      int
+	repoFile   string
+)
+
+// campaignSummary is what is shown for each campaign found
+type campaignSummary struct {
+	name  string
+	repos int
+	// reposErr is why the campaign's repos could not be counted, if they could not
+	reposErr error
+	// latest is the campaign's most recent stats snapshot, if it has one
+	latest       *campaign.Snapshot
+	lastActivity time.Time
+}
+
+func NewCampaignsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "campaigns",
+		Short: "Work with all the campaigns in a directory",
+	}
+
+	cmd.AddCommand(newListCmd())
+
+	return cmd
+}
+
+func newListCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "Summarise each of the campaigns beneath a directory",
+		Long: `Summarise each of the campaigns found beneath a directory: how many repos it has, how many of its PRs
+are open and merged, and when it was last worked on.
+
+PR counts come from the latest snapshot recorded by turbolift stats in each campaign, so no GitHub
+API calls are made; run turbolift stats in a campaign to bring its counts up to date.`,
+		Run: runList,
+	}
+
+	cmd.Flags().StringVar(&searchPath, "path", ".", "Directory containing the campaigns to list")
+	cmd.Flags().IntVar(&depth, "depth", 3, "How many levels of directories beneath the path to search for campaigns")
+	cmd.Flags().StringVar(&repoFile, "repos", "repos.txt", "The file listing the repositories of each campaign")
+
+	return cmd
+}
+
+func runList(c *cobra.Command, _ []string) {
+	logger := logging.NewLogger(c)
+
+	findActivity := logger.StartActivity("Finding campaigns in %s", searchPath)
+	root, err := filepath.Abs(searchPath)
+	if err != nil {
+		findActivity.EndWithFailure(err)
+		return
+	}
+	campaignDirs, err := campaign.FindCampaignDirs(root, depth)
+	if err != nil {
+		findActivity.EndWithFailure(err)
+		return
+	}
+
+	var summaries []campaignSummary
+	for _, campaignDir := range campaignDirs {
+		summary, err := summarise(root, campaignDir)
+		if err != nil {
+			findActivity.Logf("Skipping %s: %s", campaignDir, err)
+			continue
+		}
+		if summary.reposErr != nil {
+			findActivity.Logf("Unable to count the repos of %s: %s", summary.name, summary.reposErr)
+		}
+		summaries = append(summaries, summary)
+	}
+	findActivity.EndWithSuccessAndEmitLogs()
+
+	logger.Println()
+
+	campaignsTable := table.New("Campaign", "Repos", "Open", "Merged", "Progress", "PRs checked", "Last activity")
+	campaignsTable.WithHeaderFormatter(color.New(color.Underline).SprintfFunc())
+	campaignsTable.WithFirstColumnFormatter(color.New(color.FgCyan).SprintfFunc())
+	campaignsTable.WithWriter(logger.Writer())
+
+	totalRepos := 0
+	unchecked := 0
+	for _, summary := range summaries {
+		repos := "?"
+		if summary.reposErr == nil {
+			repos = fmt.Sprint(summary.repos)
+			totalRepos += summary.repos
+		}
+		open, merged, progress, checked := "-", "-", "-", "never"
+		if summary.latest != nil {
+			open = fmt.Sprint(summary.latest.Open)
+			merged = fmt.Sprint(summary.latest.Merged)
+			progress = fmt.Sprintf("%d%%", summary.latest.Completion())
+			checked = formatTime(summary.latest.Taken)
+		} else {
+			unchecked++
+		}
+		campaignsTable.AddRow(summary.name, repos, open, merged, progress, checked, formatTime(summary.lastActivity))
+	}
+	campaignsTable.Print()
+
+	logger.Println()
+	if unchecked > 0 {
+		logger.Printf("The PRs of %d campaigns have not been checked yet: run %s in them to record how their PRs are doing", unchecked, colors.Cyan("turbolift stats"))
+	}
+	logger.Successf("turbolift campaigns list completed %s(%s campaigns listed, with %d repos)\n", colors.Normal(), colors.Green(len(summaries)), totalRepos)
+}
+
+// summarise reads what is shown for a campaign from its repos file and state, without calling GitHub
+func summarise(root string, campaignDir string) (campaignSummary, error) {
+	summary := campaignSummary{name: campaignName(root, campaignDir)}
+
+	state, err := campaign.ReadStateIn(campaignDir)
+	if err != nil {
+		return summary, err
+	}
+	if len(state.Snapshots) > 0 {
+		summary.latest = &state.Snapshots[len(state.Snapshots)-1]
+	}
+
+	reposFilename := filepath.Join(campaignDir, repoFile)
+	repos, err := campaign.ReadReposFile(reposFilename)
+	if err != nil {
+		summary.reposErr = err
+	}
+	summary.repos = len(repos)
+
+	summary.lastActivity = state.LastActivity()
+	// editing the campaign's files, and the commands that save its state, are activity too
+	for _, filename := range []string{reposFilename, filepath.Join(campaignDir, "README.md"), filepath.Join(campaignDir, campaign.StateDirectory, "state.json")} {
+		if info, err := os.Stat(filename); err == nil && info.ModTime().After(summary.lastActivity) {
+			summary.lastActivity = info.ModTime()
+		}
+	}
+	return summary, nil
+}
+
+// campaignName is the campaign's directory relative to where the campaigns were searched for, or its name if the
+// search started in the campaign itself
+func campaignName(root string, campaignDir string) string {
+	if relative, err := filepath.Rel(root, campaignDir); err == nil && relative != "." {
+		return filepath.ToSlash(relative)
+	}
+	return filepath.Base(campaignDir)
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
@@ -0,0 +1,97 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package campaigns
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/testsupport"
+)
+
+func init() {
+	// disable output colouring so that strings we want to do 'Contains' checks on do not have ANSI escape sequences in IDEs
+	_ = os.Setenv("NO_COLOR", "1")
+}
+
+func TestItListsCampaignsWithTheirLatestSnapshots(t *testing.T) {
+	testsupport.CreateAndEnterTempDirectory()
+
+	taken := time.Date(2021, 10, 1, 9, 0, 0, 0, time.UTC)
+	createCampaign("team-a/upgrade-go", []string{"org/repo1", "org/repo2", "org/repo3", "org/repo4"},
+		campaign.Snapshot{Taken: taken.AddDate(0, 0, -7), Open: 4},
+		campaign.Snapshot{Taken: taken, Merged: 3, Open: 1},
+	)
+	createCampaign("remove-flag", []string{"org/repo1"})
+	_ = os.Mkdir("not-a-campaign", 0o755)
+
+	out, err := runCommand("list")
+	assert.NoError(t, err)
+
+	assert.Regexp(t, `remove-flag\s+1\s+-\s+-\s+-\s+never`, out)
+	assert.Regexp(t, `team-a/upgrade-go\s+4\s+1\s+3\s+75%\s+`+taken.Local().Format("2006-01-02 15:04"), out)
+	assert.NotContains(t, out, "not-a-campaign")
+	assert.Contains(t, out, "The PRs of 1 campaigns have not been checked yet")
+	assert.Contains(t, out, "2 campaigns listed, with 5 repos")
+}
+
+func TestItListsCampaignsWhoseReposCannotBeCounted(t *testing.T) {
+	testsupport.CreateAndEnterTempDirectory()
+
+	createCampaign("no-repos-file", nil)
+	_ = os.Remove("no-repos-file/repos.txt")
+
+	out, err := runCommand("list")
+	assert.NoError(t, err)
+
+	assert.Contains(t, out, "Unable to count the repos of no-repos-file")
+	assert.Regexp(t, `no-repos-file\s+\?`, out)
+	assert.Contains(t, out, "1 campaigns listed, with 0 repos")
+}
+
+// createCampaign makes a campaign as turbolift init would, with the given repos and stats snapshots
+func createCampaign(dir string, repos []string, snapshots ...campaign.Snapshot) {
+	_ = os.MkdirAll(dir, 0o755)
+	cwd, _ := os.Getwd()
+	_ = os.Chdir(dir)
+	defer func() {
+		_ = os.Chdir(cwd)
+	}()
+
+	_ = os.WriteFile(campaign.MarkerFile, []byte("v2"), 0o644)
+	testsupport.CreateAnotherRepoFile("repos.txt", repos...)
+	if len(snapshots) > 0 {
+		state, _ := campaign.ReadState()
+		state.Snapshots = snapshots
+		if err := state.Save(); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func runCommand(args ...string) (string, error) {
+	cmd := NewCampaignsCmd()
+	outBuffer := bytes.NewBufferString("")
+	cmd.SetOut(outBuffer)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return outBuffer.String(), err
+}
@@ -0,0 +1,255 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package clean
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+
+	"github.com/spf13/cobra"
+
+	"github.com/skyscanner/turbolift/cmd/flags"
+	"github.com/skyscanner/turbolift/internal/bitbucket"
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/colors"
+	"github.com/skyscanner/turbolift/internal/confirm"
+	"github.com/skyscanner/turbolift/internal/forge"
+	"github.com/skyscanner/turbolift/internal/git"
+	"github.com/skyscanner/turbolift/internal/github"
+	"github.com/skyscanner/turbolift/internal/hooks"
+	"github.com/skyscanner/turbolift/internal/interrupt"
+	"github.com/skyscanner/turbolift/internal/logging"
+	"github.com/skyscanner/turbolift/internal/messages"
+	"github.com/skyscanner/turbolift/internal/prompt"
+	"github.com/skyscanner/turbolift/internal/summary"
+)
+
+var (
+	gh github.GitHub = github.NewRealGitHub()
+	bb github.GitHub = bitbucket.NewBitbucketServer()
+	g  git.Git       = git.NewRealGit()
+	hk hooks.Hooks   = hooks.NewRealHooks()
+	p  prompt.Prompt = prompt.NewRealPrompt()
+)
+
+var (
+	repoFile string
+	reclone  bool
+	yesFlag  bool
+	shuffle  string
+	resume   bool
+)
+
+func NewCleanCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "clean",
+		Short: "Reset each working copy to a fresh campaign branch",
+		Long: `Reset each working copy to a fresh campaign branch.
+
+For each repo, this discards any uncommitted changes and untracked files, then checks out the campaign
+branch afresh from the latest default branch (from upstream, for forks). Commits already made on the
+campaign branch are thrown away too, so use it to start again when a foreach script has gone wrong.
+
+Working copies that git can no longer work with are reported as errors, or deleted and cloned again
+with --reclone.`,
+		Run: run,
+	}
+
+	cmd.Flags().StringVar(&repoFile, "repos", "repos.txt", "A file containing a list of repositories to clean.")
+	cmd.Flags().BoolVar(&reclone, "reclone", false, "Delete and clone again any working copies that are broken")
+	cmd.Flags().BoolVar(&yesFlag, "yes", false, "Skips the confirmation prompt")
+	flags.AddShuffleFlag(cmd, &shuffle)
+	flags.AddResumeFlag(cmd, &resume)
+	flags.MarkMakesChanges(cmd)
+
+	return cmd
+}
+
+func run(c *cobra.Command, _ []string) {
+	ctx := c.Context()
+	logger := logging.NewLogger(c)
+
+	readCampaignActivity := logger.StartActivity("Reading campaign data (%s)", repoFile)
+	options := campaign.NewCampaignOptions()
+	options.RepoFilename = repoFile
+	options.Shuffle = shuffle
+	dir, err := campaign.OpenCampaign(options)
+	if err != nil {
+		readCampaignActivity.EndWithFailure(err)
+		return
+	}
+	readCampaignActivity.EndWithSuccess()
+	flags.LogShuffle(logger, dir)
+
+	progress, err := campaign.NewProgress("clean", dir.BranchName, resume)
+	if err != nil {
+		logger.Errorf("%s", err)
+		return
+	}
+	if progress.Resumed() > 0 {
+		logger.Printf("Resuming the last run, so leaving alone the %d repos it had finished with", progress.Resumed())
+	}
+
+	confirmed, err := confirm.Destructive(p, dir, "clean", len(dir.Repos)-progress.Resumed(), yesFlag,
+		dir.Messages.Format(messages.ConfirmClean, messages.Fields{"Branch": dir.BranchName, "ReposFile": repoFile}))
+	if err != nil {
+		logger.Errorf("%s", err)
+		return
+	}
+	if !confirmed {
+		return
+	}
+
+	sum := summary.New("clean")
+	for i, repo := range dir.Repos {
+		if interrupt.Requested(ctx) {
+			logger.Warnf("Interrupted, so not cleaning the remaining %d repos", len(dir.Repos)-i)
+			break
+		}
+		if progress.AlreadyCompleted(repo) {
+			continue
+		}
+		repoDirPath := repo.FullRepoPath()
+		cleanActivity := logger.StartActivity("Cleaning %s", repo.FullRepoName)
+
+		// skip if the working copy does not exist
+		if _, err = os.Stat(repoDirPath); os.IsNotExist(err) {
+			cleanActivity.EndWithWarningf("Directory %s does not exist - has it been cloned?", repoDirPath)
+			sum.RecordSkipped(repo, "not cloned")
+			continue
+		}
+
+		// a working copy that git cannot work with at all is broken, rather than merely in a mess
+		isFork, err := g.HasRemote(ctx, cleanActivity.Writer(), repoDirPath, "upstream")
+		if err == nil {
+			err = g.DiscardChanges(ctx, cleanActivity.Writer(), repoDirPath)
+		}
+		if err != nil {
+			if !reclone {
+				cleanActivity.EndWithFailuref("The working copy looks broken, so use --reclone to delete it and clone it again: %s", err)
+				sum.RecordErrored(repo, err)
+				continue
+			}
+			cleanActivity.EndWithWarningf("The working copy looks broken, so deleting it and cloning it again: %s", err)
+			if err := recloneRepo(ctx, logger, dir, repo); err != nil {
+				sum.RecordErrored(repo, err)
+				continue
+			}
+			sum.RecordDone(repo)
+			progress.Complete(repo)
+			continue
+		}
+
+		if err = resetBranch(ctx, cleanActivity.Writer(), dir, repo, isFork); err != nil {
+			cleanActivity.EndWithFailure(err)
+			sum.RecordErrored(repo, err)
+			continue
+		}
+		cleanActivity.EndWithSuccess()
+		sum.RecordDone(repo)
+		progress.Complete(repo)
+	}
+
+	sum.Finish(ctx)
+	if interrupt.Requested(ctx) {
+		logger.Warnf("%s %s(%s, %s, %s)\n", dir.Messages.Format(messages.SummaryInterrupted, messages.Fields{"Command": "clean"}), colors.Normal(), colors.Green(sum.Done, " OK"), colors.Yellow(sum.Skipped, " skipped"), colors.Red(sum.Errored, " errored"))
+	} else if sum.Errored == 0 {
+		logger.Successf("%s %s(%s, %s)\n", dir.Messages.Format(messages.SummaryCompleted, messages.Fields{"Command": "clean"}), colors.Normal(), colors.Green(sum.Done, " OK"), colors.Yellow(sum.Skipped, " skipped"))
+	} else {
+		logger.Warnf("%s %s(%s, %s, %s)\n", dir.Messages.Format(messages.SummaryWithErrors, messages.Fields{"Command": "clean"}), colors.Normal(), colors.Green(sum.Done, " OK"), colors.Yellow(sum.Skipped, " skipped"), colors.Red(sum.Errored, " errored"))
+	}
+
+	github.LogApiUsage(ctx, gh, logger, dir.Hosts())
+
+	if err := progress.End(interrupt.Requested(ctx)); err != nil {
+		logger.Warnf("Unable to update the checkpoint in the campaign state: %s", err)
+	} else if interrupt.Requested(ctx) {
+		logger.Printf("To carry on from where it stopped, run %s again with the same options", colors.Cyan(c.CommandPath()+" --resume"))
+	}
+}
+
+// resetBranch checks out the campaign branch afresh from the latest default branch, which forks take from upstream
+func resetBranch(ctx context.Context, output io.Writer, dir *campaign.Campaign, repo campaign.Repo, isFork bool) error {
+	remote := "origin"
+	if isFork {
+		remote = "upstream"
+	}
+
+	defaultBranch := repo.DefaultBranch
+	if defaultBranch == "" {
+		var err error
+		defaultBranch, err = forge.For(repo, gh, bb).GetDefaultBranchName(ctx, output, repo.FullRepoPath(), repo.FullRepoName)
+		if err != nil {
+			return err
+		}
+	}
+
+	return g.ResetBranch(ctx, output, repo.FullRepoPath(), remote, defaultBranch, dir.BranchName)
+}
+
+// recloneRepo replaces a broken working copy with a fresh clone on the campaign branch, forking the repo if the
+// user cannot push to it, as turbolift clone does. It returns an error if this was not possible.
+func recloneRepo(ctx context.Context, logger *logging.Logger, dir *campaign.Campaign, repo campaign.Repo) error {
+	repoDirPath := repo.FullRepoPath()
+	orgDirPath := path.Dir(repoDirPath)
+	if err := os.RemoveAll(repoDirPath); err != nil {
+		logger.Errorf("Unable to delete %s: %s", repoDirPath, err)
+		return err
+	}
+
+	fork := true
+	if pushable, err := forge.For(repo, gh, bb).IsPushable(ctx, logger.Writer(), repo.FullRepoName); err != nil {
+		logger.Warnf("Unable to determine if we can push to %s: %s", repo.FullRepoName, err)
+	} else {
+		fork = !pushable
+	}
+
+	var gitArgs []string
+	if repo.Path != "" {
+		gitArgs = git.SparseCloneArgs
+	}
+	var cloneActivity *logging.Activity
+	var err error
+	if fork {
+		cloneActivity = logger.StartActivity("Forking and cloning %s into %s", repo.FullRepoName, repoDirPath)
+		err = forge.For(repo, gh, bb).ForkAndClone(ctx, cloneActivity.Writer(), orgDirPath, repo.FullRepoName, gitArgs...)
+	} else {
+		cloneActivity = logger.StartActivity("Cloning %s into %s", repo.FullRepoName, repoDirPath)
+		err = forge.For(repo, gh, bb).Clone(ctx, cloneActivity.Writer(), orgDirPath, repo.FullRepoName, gitArgs...)
+	}
+	if err != nil {
+		cloneActivity.EndWithFailure(err)
+		return err
+	}
+	if repo.Path != "" {
+		if err := g.SparseCheckout(ctx, cloneActivity.Writer(), repoDirPath, repo.Path); err != nil {
+			cloneActivity.EndWithFailure(err)
+			return err
+		}
+	}
+	cloneActivity.EndWithSuccess()
+
+	createBranchActivity := logger.StartActivity("Creating branch %s in %s", dir.BranchName, repo.FullRepoName)
+	if err := resetBranch(ctx, createBranchActivity.Writer(), dir, repo, fork); err != nil {
+		createBranchActivity.EndWithFailure(err)
+		return err
+	}
+	createBranchActivity.EndWithSuccess()
+
+	return hooks.RunAsActivity(ctx, hk, logger, repoDirPath, campaign.PostCloneHook, dir, repo)
+}
@@ -0,0 +1,180 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package clean
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/git"
+	"github.com/skyscanner/turbolift/internal/github"
+	"github.com/skyscanner/turbolift/internal/hooks"
+	"github.com/skyscanner/turbolift/internal/prompt"
+	"github.com/skyscanner/turbolift/internal/testsupport"
+)
+
+func init() {
+	// disable output colouring so that strings we want to do 'Contains' checks on do not have ANSI escape sequences in IDEs
+	_ = os.Setenv("NO_COLOR", "1")
+}
+
+func TestItResetsWorkingCopiesFromTheRightRemote(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+	fakeGit := git.NewFakeGit(func(_ io.Writer, call []string) (bool, error) {
+		if call[0] == "hasRemote" {
+			return call[1] == "work/org/forked", nil
+		}
+		return true, nil
+	})
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(true, "org/forked", "org/owned")
+	testsupport.CreateAnotherRepoFile("repos.txt", "org/forked", "org/owned", "org/missing")
+
+	out, err := runCommand("--yes")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Directory work/org/missing does not exist")
+	assert.Contains(t, out, "turbolift clean completed (2 OK, 1 skipped)")
+
+	fakeGit.AssertCalledWith(t, [][]string{
+		{"hasRemote", "work/org/forked", "upstream"},
+		{"discardChanges", "work/org/forked"},
+		{"resetBranch", "work/org/forked", "upstream", "main", testsupport.Pwd()},
+		{"hasRemote", "work/org/owned", "upstream"},
+		{"discardChanges", "work/org/owned"},
+		{"resetBranch", "work/org/owned", "origin", "main", testsupport.Pwd()},
+	})
+}
+
+func TestItDoesNothingIfNotConfirmed(t *testing.T) {
+	fakeGit := git.NewAlwaysSucceedsFakeGit()
+	g = fakeGit
+	fakePrompt := prompt.NewFakePromptNo()
+	p = fakePrompt
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	_, err := runCommand()
+	assert.NoError(t, err)
+
+	fakePrompt.AssertCalledWith(t, "Discard all changes and commits on branch "+testsupport.Pwd()+" for all repos in repos.txt?")
+	fakeGit.AssertCalledWith(t, [][]string{})
+}
+
+func TestItNeedsTheCampaignNameTypedToCleanManyReposEvenWithYes(t *testing.T) {
+	fakeGit := git.NewAlwaysSucceedsFakeGit()
+	g = fakeGit
+	fakePrompt := prompt.NewFakePromptNo()
+	p = fakePrompt
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+	testsupport.CreateConfigFile("confirm:\n  threshold: 1\n")
+
+	_, err := runCommand("--yes")
+	assert.NoError(t, err)
+
+	fakePrompt.AssertCalledWith(t, "Discard all changes and commits on branch "+testsupport.Pwd()+" for all repos in repos.txt? This affects 2 repos, so type the campaign name ("+testsupport.Pwd()+") to confirm")
+	fakeGit.AssertCalledWith(t, [][]string{})
+}
+
+func TestItUsesMessagesFromTheConfigFile(t *testing.T) {
+	gh = github.NewAlwaysSucceedsFakeGitHub()
+	g = git.NewAlwaysSucceedsFakeGit()
+	fakePrompt := prompt.NewFakePromptNo()
+	p = fakePrompt
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+	testsupport.CreateConfigFile(`
+messages:
+  confirm-clean: "Start {{ .Branch }} again from scratch?"
+  summary-completed: "All done with {{ .Command }}"
+`)
+
+	_, err := runCommand()
+	assert.NoError(t, err)
+	fakePrompt.AssertCalledWith(t, "Start "+testsupport.Pwd()+" again from scratch?")
+
+	out, err := runCommand("--yes")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "All done with clean (1 OK, 0 skipped)")
+}
+
+func TestItReportsBrokenWorkingCopies(t *testing.T) {
+	gh = github.NewAlwaysSucceedsFakeGitHub()
+	g = git.NewFakeGit(func(_ io.Writer, call []string) (bool, error) {
+		if call[0] == "discardChanges" {
+			return false, errors.New("not a git repository")
+		}
+		return false, nil
+	})
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runCommand("--yes")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "use --reclone to delete it and clone it again: not a git repository")
+	assert.Contains(t, out, "1 errored")
+	assert.DirExists(t, path.Join("work", "org", "repo1"))
+}
+
+func TestItReclonesBrokenWorkingCopiesWhenAsked(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+	fakeGit := git.NewFakeGit(func(_ io.Writer, call []string) (bool, error) {
+		if call[0] == "hasRemote" {
+			return false, errors.New("not a git repository")
+		}
+		return true, nil
+	})
+	g = fakeGit
+	fakeHooks := hooks.NewAlwaysSucceedsFakeHooks()
+	hk = fakeHooks
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+	_ = os.WriteFile(path.Join("work", "org", "repo1", "leftover"), []byte("junk"), 0o644)
+
+	out, err := runCommand("--yes", "--reclone")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "deleting it and cloning it again")
+	assert.Contains(t, out, "turbolift clean completed (1 OK, 0 skipped)")
+
+	assert.NoFileExists(t, path.Join("work", "org", "repo1", "leftover"))
+	fakeGitHub.AssertCalledWith(t, [][]string{
+		{"user_can_push", "org/repo1"},
+		{"clone", "work/org", "org/repo1"},
+		{"get_default_branch", "work/org/repo1", "org/repo1"},
+	})
+	fakeGit.AssertCalledWith(t, [][]string{
+		{"hasRemote", "work/org/repo1", "upstream"},
+		{"resetBranch", "work/org/repo1", "origin", "main", testsupport.Pwd()},
+	})
+}
+
+func runCommand(args ...string) (string, error) {
+	cmd := NewCleanCmd()
+	outBuffer := bytes.NewBufferString("")
+	cmd.SetOut(outBuffer)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return outBuffer.String(), err
+}
@@ -16,26 +16,38 @@
 package clone
 
 import (
-	"os"
-	"path"
+	"context"
 
 	"github.com/spf13/cobra"
 
+	"github.com/skyscanner/turbolift/cmd/flags"
+	"github.com/skyscanner/turbolift/internal/bitbucket"
 	"github.com/skyscanner/turbolift/internal/campaign"
 	"github.com/skyscanner/turbolift/internal/colors"
 	"github.com/skyscanner/turbolift/internal/git"
 	"github.com/skyscanner/turbolift/internal/github"
+	"github.com/skyscanner/turbolift/internal/hooks"
+	"github.com/skyscanner/turbolift/internal/interrupt"
 	"github.com/skyscanner/turbolift/internal/logging"
+	"github.com/skyscanner/turbolift/internal/messages"
+	"github.com/skyscanner/turbolift/internal/operations"
+	"github.com/skyscanner/turbolift/internal/summary"
 )
 
 var (
 	gh github.GitHub = github.NewRealGitHub()
+	bb github.GitHub = bitbucket.NewBitbucketServer()
 	g  git.Git       = git.NewRealGit()
+	hk hooks.Hooks   = hooks.NewRealHooks()
 )
 
 var (
-	forceFork bool
-	repoFile  string
+	forceFork  bool
+	syncFork   bool
+	repoFile   string
+	branchName string
+	shuffle    string
+	resume     bool
 )
 
 func NewCloneCmd() *cobra.Command {
@@ -46,121 +58,185 @@ func NewCloneCmd() *cobra.Command {
 	}
 
 	cmd.Flags().BoolVar(&forceFork, "fork", false, "Force forking, instead of turbolift choosing whether to fork/branch based on permissions")
+	cmd.Flags().BoolVar(&syncFork, "sync-fork", false, "Fast-forward the default branch of each fork from upstream before creating the campaign branch")
 	cmd.Flags().StringVar(&repoFile, "repos", "repos.txt", "A file containing a list of repositories to clone.")
+	cmd.Flags().StringVar(&branchName, "branch", "", "The branch to use for the campaign (defaults to branch in turbolift.yaml, or the campaign name)")
+	flags.AddShuffleFlag(cmd, &shuffle)
+	flags.AddResumeFlag(cmd, &resume)
+	flags.MarkMakesChanges(cmd)
 
 	return cmd
 }
 
 func run(c *cobra.Command, _ []string) {
+	ctx := c.Context()
 	logger := logging.NewLogger(c)
 
+	if !expandOrgs(ctx, logger) {
+		return
+	}
+
 	readCampaignActivity := logger.StartActivity("Reading campaign data (%s)", repoFile)
 	options := campaign.NewCampaignOptions()
 	options.RepoFilename = repoFile
+	options.Shuffle = shuffle
+	options.BranchName = branchName
 	dir, err := campaign.OpenCampaign(options)
 	if err != nil {
 		readCampaignActivity.EndWithFailure(err)
 		return
 	}
 	readCampaignActivity.EndWithSuccess()
+	flags.LogShuffle(logger, dir)
 
-	var doneCount, skippedCount, errorCount int
-	for _, repo := range dir.Repos {
-		orgDirPath := path.Join("work", repo.OrgName)       // i.e. work/org
-		repoDirPath := path.Join(orgDirPath, repo.RepoName) // i.e. work/org/repo
-
-		var cloneActivity *logging.Activity
-
-		// Determine whether we need to fork or clone
-		var fork bool
+	progress, err := campaign.NewProgress("clone", dir.BranchName, resume)
+	if err != nil {
+		logger.Errorf("%s", err)
+		return
+	}
+	if progress.Resumed() > 0 {
+		logger.Printf("Resuming the last run, so leaving alone the %d repos it had finished with", progress.Resumed())
+	}
 
-		if forceFork {
-			fork = true
-		} else {
-			res, err := gh.IsPushable(logger.Writer(), repo.FullRepoName)
-			if err != nil {
-				logger.Warnf("Unable to determine if we can push to %s: %s", repo.FullRepoName, err)
-				fork = true
-			} else {
-				fork = !res
-			}
+	state, err := campaign.ReadState()
+	if err != nil {
+		logger.Errorf("%s", err)
+		return
+	}
+	// on a branch the campaign has not been run on before, existing working copies get the new branch rather than being skipped
+	cloneOptions := operations.CloneOptions{Fork: forceFork, SyncFork: syncFork, NewIteration: operations.NewIteration(state, dir)}
+	clients := operations.Clients{GitHub: gh, Bitbucket: bb, Git: g, Hooks: hk}
+
+	sum := summary.New("clone")
+	var sawExistingWorkingCopy bool
+	var renames []campaign.Rename
+	for i, repo := range dir.Repos {
+		if interrupt.Requested(ctx) {
+			logger.Warnf("Interrupted, so not cloning the remaining %d repos", len(dir.Repos)-i)
+			break
 		}
-
-		if fork {
-			cloneActivity = logger.StartActivity("Forking and cloning %s into %s/%s", repo.FullRepoName, orgDirPath, repo.RepoName)
-		} else {
-			cloneActivity = logger.StartActivity("Cloning %s into %s/%s", repo.FullRepoName, orgDirPath, repo.RepoName)
+		if progress.AlreadyCompleted(repo) {
+			continue
 		}
 
-		err := os.MkdirAll(orgDirPath, os.ModeDir|0o755)
-		if err != nil {
-			cloneActivity.EndWithFailuref("Unable to create org directory: %s", err)
-			errorCount++
-			break
+		outcome := operations.Clone(ctx, logger, clients, dir, repo, cloneOptions)
+		sawExistingWorkingCopy = sawExistingWorkingCopy || outcome.Existed
+		if outcome.Rename != nil {
+			renames = append(renames, *outcome.Rename)
 		}
+		outcome.Record(sum, repo)
+		if outcome.Finished {
+			progress.Complete(repo)
+		}
+	}
 
-		// skip if the working copy is already cloned
-		if _, err = os.Stat(repoDirPath); !os.IsNotExist(err) {
-			cloneActivity.EndWithWarningf("Directory already exists")
-			skippedCount++
-			continue
+	if err := operations.RecordClones(state, dir, sawExistingWorkingCopy, renames); err != nil {
+		logger.Warnf("Unable to record branch %s in the campaign state: %s", dir.BranchName, err)
+	}
+	if len(renames) > 0 {
+		if err := campaign.RenameReposInFile(repoFile, renames); err != nil {
+			logger.Warnf("Unable to update %s with the new names of renamed repos: %s", repoFile, err)
 		}
+	}
 
-		if fork {
-			err = gh.ForkAndClone(cloneActivity.Writer(), orgDirPath, repo.FullRepoName)
+	sum.Finish(ctx)
+	if interrupt.Requested(ctx) {
+		logger.Warnf("%s %s(%s repos cloned, %s repos skipped, %s repos errored)\n", dir.Messages.Format(messages.SummaryInterrupted, messages.Fields{"Command": "clone"}), colors.Normal(), colors.Green(sum.Done), colors.Yellow(sum.Skipped), colors.Red(sum.Errored))
+		logRenames(logger, renames)
+		if err := progress.End(true); err != nil {
+			logger.Warnf("Unable to update the checkpoint in the campaign state: %s", err)
 		} else {
-			err = gh.Clone(cloneActivity.Writer(), orgDirPath, repo.FullRepoName)
+			logger.Printf("To carry on from where it stopped, run %s again with the same options", colors.Cyan(c.CommandPath()+" --resume"))
 		}
+		return
+	}
+	if err := progress.End(false); err != nil {
+		logger.Warnf("Unable to update the checkpoint in the campaign state: %s", err)
+	}
 
-		if err != nil {
-			cloneActivity.EndWithFailure(err)
-			errorCount++
-			continue
-		}
+	if sum.Errored == 0 {
+		logger.Successf("%s %s(%s repos cloned, %s repos skipped)\n", dir.Messages.Format(messages.SummaryCompleted, messages.Fields{"Command": "clone"}), colors.Normal(), colors.Green(sum.Done), colors.Yellow(sum.Skipped))
+	} else {
+		logger.Warnf("%s %s(%s repos cloned, %s repos skipped, %s repos errored)\n", dir.Messages.Format(messages.SummaryWithErrors, messages.Fields{"Command": "clone"}), colors.Normal(), colors.Green(sum.Done), colors.Yellow(sum.Skipped), colors.Red(sum.Errored))
+		logger.Println("Please check errors above and fix if necessary")
+	}
+	logRenames(logger, renames)
 
-		cloneActivity.EndWithSuccess()
+	github.LogApiUsage(ctx, gh, logger, dir.Hosts())
 
-		createBranchActivity := logger.StartActivity("Creating branch %s in %s", dir.Name, repo.FullRepoName)
+	logger.Println("To continue:")
+	logger.Println("\t1. Make your changes in the cloned repositories within the", colors.Cyan("work"), "directory")
+	logger.Println("\t2. Add new files across all repos using", colors.Cyan(`turbolift foreach git add -A`))
+	logger.Println("\t3. Commit changes across all repos using", colors.Cyan(`turbolift commit --message "Your commit message"`))
+	logger.Println("\t4. Change the PR title and description in the", colors.Cyan(`README.md`), "of a campaign")
+}
 
-		err = g.Checkout(createBranchActivity.Writer(), repoDirPath, dir.Name)
-		if err != nil {
-			createBranchActivity.EndWithFailure(err)
-			errorCount++
+// logRenames notes in the summary the repos that were found to have been renamed
+func logRenames(logger *logging.Logger, renames []campaign.Rename) {
+	if len(renames) == 0 {
+		return
+	}
+	logger.Warnf("%d repos have been renamed or transferred, and %s now lists them under their new names:", len(renames), repoFile)
+	for _, rename := range renames {
+		logger.Printf("\t%s -> %s", rename.From, rename.To)
+	}
+}
+
+// expandOrgs lists the repos of each org given in the repos file, and records the ones that match its filters in the
+// campaign state, where every command finds them until the next clone. An org that cannot be listed keeps the repos
+// found last time, if it has been expanded before. It returns false if the clone cannot go ahead.
+func expandOrgs(ctx context.Context, logger *logging.Logger) bool {
+	patterns, err := campaign.OrgPatterns(repoFile)
+	if err != nil || len(patterns) == 0 {
+		// any problem with the repos file is reported when reading the campaign
+		return true
+	}
+	config, err := campaign.ReadConfig(campaign.NewCampaignOptions().ConfigFilename)
+	if err != nil {
+		return true
+	}
+	forge := gh
+	if config.Forge == campaign.BitbucketForge {
+		forge = bb
+	}
+	state, err := campaign.ReadState()
+	if err != nil {
+		logger.Errorf("%s", err)
+		return false
+	}
+
+	for _, pattern := range patterns {
+		expandActivity := logger.StartActivity("Listing the repos of %s", pattern.Line)
+		orgRepos, err := forge.ListOrgRepos(ctx, expandActivity.Writer(), pattern.Host, pattern.Org)
+		if err != nil && state.Expansion(pattern.Line) != nil {
+			expandActivity.EndWithWarningf("Unable to list the repos of %s, so using the ones found last time: %s", pattern.Org, err)
 			continue
+		} else if err != nil {
+			expandActivity.EndWithFailure(err)
+			return false
 		}
-		createBranchActivity.EndWithSuccess()
-
-		if fork {
-			pullFromUpstreamActivity := logger.StartActivity("Pulling latest changes from %s", repo.FullRepoName)
-			var defaultBranch string
-			defaultBranch, err = gh.GetDefaultBranchName(pullFromUpstreamActivity.Writer(), repoDirPath, repo.FullRepoName)
-			if err != nil {
-				pullFromUpstreamActivity.EndWithFailure(err)
-				errorCount++
-				continue
+
+		names := []string{}
+		for _, orgRepo := range orgRepos {
+			repo := campaign.OrgRepo{
+				FullRepoName: orgRepo.NameWithOwner,
+				Archived:     orgRepo.IsArchived,
+				Fork:         orgRepo.IsFork,
+				Language:     orgRepo.PrimaryLanguage.Name,
+				Topics:       orgRepo.Topics(),
 			}
-			err = g.Pull(pullFromUpstreamActivity.Writer(), repoDirPath, "upstream", defaultBranch)
-			if err != nil {
-				pullFromUpstreamActivity.EndWithFailure(err)
-				logger.Printf("\nWe weren't able to pull the latest upstream changes into your fork of %s. This is probably because you have a pre-existing fork with commits ahead of upstream. Please change this or delete your fork, and try again.\n", repo.FullRepoName)
-				errorCount++
-				continue
+			if pattern.Matches(repo) {
+				names = append(names, pattern.RepoName(repo))
 			}
-			pullFromUpstreamActivity.EndWithSuccess()
 		}
-
-		doneCount++
+		added := state.SetExpansion(pattern.Line, names)
+		expandActivity.EndWithSuccess()
+		logger.Printf("Found %d repos for %s, %d of them new", len(names), pattern.Line, added)
 	}
 
-	if errorCount == 0 {
-		logger.Successf("turbolift clone completed %s(%s repos cloned, %s repos skipped)\n", colors.Normal(), colors.Green(doneCount), colors.Yellow(skippedCount))
-	} else {
-		logger.Warnf("turbolift clone completed with %s %s(%s repos cloned, %s repos skipped, %s repos errored)\n", colors.Red("errors"), colors.Normal(), colors.Green(doneCount), colors.Yellow(skippedCount), colors.Red(errorCount))
-		logger.Println("Please check errors above and fix if necessary")
+	if err := state.Save(); err != nil {
+		logger.Errorf("%s", err)
+		return false
 	}
-	logger.Println("To continue:")
-	logger.Println("\t1. Make your changes in the cloned repositories within the", colors.Cyan("work"), "directory")
-	logger.Println("\t2. Add new files across all repos using", colors.Cyan(`turbolift foreach git add -A`))
-	logger.Println("\t3. Commit changes across all repos using", colors.Cyan(`turbolift commit --message "Your commit message"`))
-	logger.Println("\t4. Change the PR title and description in the", colors.Cyan(`README.md`), "of a campaign")
+	return true
 }
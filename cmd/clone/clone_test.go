@@ -18,14 +18,17 @@ package clone
 import (
 	"bytes"
 	"errors"
+	"io"
 	"os"
 	"path"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 
+	"github.com/skyscanner/turbolift/internal/campaign"
 	"github.com/skyscanner/turbolift/internal/git"
 	"github.com/skyscanner/turbolift/internal/github"
+	"github.com/skyscanner/turbolift/internal/hooks"
 	"github.com/skyscanner/turbolift/internal/testsupport"
 )
 
@@ -278,6 +281,33 @@ func TestItClonesReposFoundInReposFile(t *testing.T) {
 	})
 }
 
+func TestItMakesSparseCheckoutsOfReposScopedToAPath(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+	fakeGit := git.NewAlwaysSucceedsFakeGit()
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(false, "org/monorepo//services/foo", "org/repo2")
+
+	out, err := runCloneCommand()
+	assert.NoError(t, err)
+
+	assert.Contains(t, out, "Checking out services/foo in org/monorepo")
+	assert.Contains(t, out, "turbolift clone completed (2 repos cloned, 0 repos skipped)")
+
+	fakeGitHub.AssertCalledWith(t, [][]string{
+		{"user_can_push", "org/monorepo"},
+		{"clone", "work/org", "org/monorepo", "--filter=blob:none", "--sparse"},
+		{"user_can_push", "org/repo2"},
+		{"clone", "work/org", "org/repo2"},
+	})
+	fakeGit.AssertCalledWith(t, [][]string{
+		{"sparseCheckout", "work/org/monorepo", "services/foo"},
+		{"checkout", "work/org/monorepo", testsupport.Pwd()},
+		{"checkout", "work/org/repo2", testsupport.Pwd()},
+	})
+}
+
 func TestItClonesReposInMultipleOrgs(t *testing.T) {
 	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
 	gh = fakeGitHub
@@ -347,6 +377,259 @@ func TestItSkipsCloningIfAWorkingCopyAlreadyExists(t *testing.T) {
 	})
 }
 
+func TestItSkipsDeletedRepos(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub().WithGoneRepos("org/repo1")
+	gh = fakeGitHub
+	fakeGit := git.NewAlwaysSucceedsFakeGit()
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(false, "org/repo1", "org/repo2")
+
+	out, err := runCloneCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Skipping org/repo1: org/repo1 has been deleted or is no longer accessible")
+	assert.Contains(t, out, "turbolift clone completed (1 repos cloned, 1 repos skipped)")
+
+	fakeGitHub.AssertCalledWith(t, [][]string{
+		{"user_can_push", "org/repo2"},
+		{"clone", "work/org", "org/repo2"},
+	})
+}
+
+func TestItFollowsRenamedRepos(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub().WithRenames(map[string]string{"org/repo1": "neworg/renamed1"})
+	gh = fakeGitHub
+	fakeGit := git.NewAlwaysSucceedsFakeGit()
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(false, "org/repo1", "org/repo2")
+
+	out, err := runCloneCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "org/repo1 has been renamed to neworg/renamed1, so using its new name")
+	assert.Contains(t, out, "1 repos have been renamed or transferred, and repos.txt now lists them under their new names")
+	assert.Contains(t, out, "turbolift clone completed (2 repos cloned, 0 repos skipped)")
+
+	fakeGitHub.AssertCalledWith(t, [][]string{
+		{"user_can_push", "neworg/renamed1"},
+		{"clone", "work/neworg", "neworg/renamed1"},
+		{"user_can_push", "org/repo2"},
+		{"clone", "work/org", "org/repo2"},
+	})
+
+	reposFile, err := os.ReadFile("repos.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "neworg/renamed1\norg/repo2", string(reposFile))
+
+	state, err := campaign.ReadState()
+	assert.NoError(t, err)
+	assert.Len(t, state.Renames, 1)
+	assert.Equal(t, "org/repo1", state.Renames[0].From)
+	assert.Equal(t, "neworg/renamed1", state.Renames[0].To)
+}
+
+func TestItMovesTheWorkingCopiesOfRenamedRepos(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub().WithRenames(map[string]string{"org/repo1": "org/renamed1"})
+	gh = fakeGitHub
+	fakeGit := git.NewAlwaysSucceedsFakeGit()
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(false, "org/repo1")
+	_ = os.MkdirAll(path.Join("work", "org", "repo1"), os.ModeDir|0o755)
+
+	out, err := runCloneCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "org/repo1 has been renamed to org/renamed1, so moved its working copy to work/org/renamed1")
+	assert.Contains(t, out, "turbolift clone completed (0 repos cloned, 1 repos skipped)")
+
+	assert.NoDirExists(t, path.Join("work", "org", "repo1"))
+	assert.DirExists(t, path.Join("work", "org", "renamed1"))
+	fakeGitHub.AssertCalledWith(t, [][]string{
+		{"user_can_push", "org/renamed1"},
+	})
+}
+
+func TestItClonesBitbucketReposFromBitbucket(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+	fakeBitbucket := github.NewAlwaysSucceedsFakeGitHub()
+	bb = fakeBitbucket
+	fakeGit := git.NewAlwaysSucceedsFakeGit()
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(false)
+	testsupport.CreateAnotherRepoFile("repos.yaml", "- repo: org/repo1", "- repo: bitbucket.example.com/PROJ/repo2", "  forge: bitbucket")
+
+	out, err := runCloneCommand("--repos", "repos.yaml")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "turbolift clone completed (2 repos cloned, 0 repos skipped)")
+
+	fakeGitHub.AssertCalledWith(t, [][]string{
+		{"user_can_push", "org/repo1"},
+		{"clone", "work/org", "org/repo1"},
+	})
+	fakeBitbucket.AssertCalledWith(t, [][]string{
+		{"user_can_push", "bitbucket.example.com/PROJ/repo2"},
+		{"clone", "work/PROJ", "bitbucket.example.com/PROJ/repo2"},
+	})
+}
+
+func TestItClonesTheReposOfOrgsInReposFile(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub().WithOrgRepos(map[string][]github.OrgRepo{
+		"acme": {
+			orgRepo("acme/api", "Go"),
+			orgRepo("acme/web", "TypeScript"),
+			{NameWithOwner: "acme/old", IsArchived: true},
+		},
+	})
+	gh = fakeGitHub
+	fakeGit := git.NewAlwaysSucceedsFakeGit()
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(false)
+	testsupport.CreateAnotherRepoFile("repos.txt", "org:acme language:go", "other/repo1")
+
+	out, err := runCloneCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Found 1 repos for org:acme language:go, 1 of them new")
+	assert.Contains(t, out, "turbolift clone completed (2 repos cloned, 0 repos skipped)")
+
+	fakeGitHub.AssertCalledWith(t, [][]string{
+		{"list_org_repos", "", "acme"},
+		{"user_can_push", "acme/api"},
+		{"clone", "work/acme", "acme/api"},
+		{"user_can_push", "other/repo1"},
+		{"clone", "work/other", "other/repo1"},
+	})
+
+	// a repo created in the org since the last clone is picked up by the next one
+	gh = github.NewAlwaysSucceedsFakeGitHub().WithOrgRepos(map[string][]github.OrgRepo{
+		"acme": {orgRepo("acme/api", "Go"), orgRepo("acme/cli", "Go")},
+	})
+	out, err = runCloneCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Found 2 repos for org:acme language:go, 1 of them new")
+	assert.Contains(t, out, "Cloning acme/cli")
+
+	repos, err := campaign.ReadReposFile("repos.txt")
+	assert.NoError(t, err)
+	assert.Len(t, repos, 3)
+}
+
+func TestItUsesTheLastExpansionOfAnOrgThatCannotBeListed(t *testing.T) {
+	fakeGit := git.NewAlwaysSucceedsFakeGit()
+	g = fakeGit
+	gh = github.NewAlwaysFailsFakeGitHub()
+
+	testsupport.PrepareTempCampaign(false)
+	testsupport.CreateAnotherRepoFile("repos.txt", "acme/*")
+
+	out, err := runCloneCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Listing the repos of acme/*")
+	assert.Contains(t, out, "synthetic error")
+	assert.NotContains(t, out, "Reading campaign data")
+
+	state, _ := campaign.ReadState()
+	state.SetExpansion("acme/*", []string{"acme/api"})
+	assert.NoError(t, state.Save())
+
+	out, err = runCloneCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Unable to list the repos of acme, so using the ones found last time")
+	assert.Contains(t, out, "cloning acme/api into work/acme/api")
+}
+
+func orgRepo(name string, language string) github.OrgRepo {
+	repo := github.OrgRepo{NameWithOwner: name}
+	repo.PrimaryLanguage.Name = language
+	return repo
+}
+
+func TestItClonesOntoACustomBranch(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+	fakeGit := git.NewAlwaysSucceedsFakeGit()
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(false, "org/repo1")
+
+	out, err := runCloneCommand("--branch", "custom-branch")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Creating branch custom-branch in org/repo1")
+
+	fakeGit.AssertCalledWith(t, [][]string{
+		{"checkout", "work/org/repo1", "custom-branch"},
+	})
+
+	state, _ := campaign.ReadState()
+	assert.Equal(t, []string{"custom-branch"}, state.Branches)
+}
+
+func TestItCreatesTheNewBranchInExistingWorkingCopiesForANewIteration(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+	fakeGit := git.NewAlwaysSucceedsFakeGit()
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(false, "org/repo1", "org/repo2")
+	_ = os.MkdirAll(path.Join("work", "org", "repo1"), os.ModeDir|0o755)
+
+	out, err := runCloneCommand("--branch", "my-campaign-v2")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Directory already exists, so only creating branch my-campaign-v2")
+	assert.Contains(t, out, "turbolift clone completed (2 repos cloned, 0 repos skipped)")
+
+	fakeGitHub.AssertCalledWith(t, [][]string{
+		{"user_can_push", "org/repo1"},
+		{"user_can_push", "org/repo2"},
+		{"clone", "work/org", "org/repo2"},
+	})
+	fakeGit.AssertCalledWith(t, [][]string{
+		{"checkout", "work/org/repo1", "my-campaign-v2"},
+		{"checkout", "work/org/repo2", "my-campaign-v2"},
+	})
+
+	// the first iteration predates the campaign state, so its branch is recorded too
+	state, _ := campaign.ReadState()
+	assert.Equal(t, []string{testsupport.Pwd(), "my-campaign-v2"}, state.Branches)
+
+	// running clone again on the same branch skips existing working copies
+	_ = os.MkdirAll(path.Join("work", "org", "repo2"), os.ModeDir|0o755)
+	fakeGit = git.NewAlwaysSucceedsFakeGit()
+	g = fakeGit
+
+	out, err = runCloneCommand("--branch", "my-campaign-v2")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "turbolift clone completed (0 repos cloned, 2 repos skipped)")
+	fakeGit.AssertCalledWith(t, [][]string{})
+}
+
+func TestItUsesTheDefaultBranchFromTheReposFile(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+	fakeGit := git.NewAlwaysSucceedsFakeGit()
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(false)
+	_ = os.WriteFile("repos.json", []byte(`[{"repo": "org/repo1", "default-branch": "develop"}, {"repo": "org/repo2"}]`), 0o644)
+
+	_, err := runCloneCommandWithFork("--repos", "repos.json")
+	assert.NoError(t, err)
+
+	fakeGitHub.AssertCalledWith(t, [][]string{
+		{"fork_and_clone", "work/org", "org/repo1"},
+		{"fork_and_clone", "work/org", "org/repo2"},
+		{"get_default_branch", "work/org/repo2", "org/repo2"},
+	})
+	fakeGit.AssertCalledWith(t, [][]string{
+		{"checkout", "work/org/repo1", testsupport.Pwd()},
+		{"pull", "--ff-only", "work/org/repo1", "upstream", "develop"},
+		{"checkout", "work/org/repo2", testsupport.Pwd()},
+		{"pull", "--ff-only", "work/org/repo2", "upstream", "main"},
+	})
+}
+
 func TestItForksIfUserHasNoPushPermission(t *testing.T) {
 	fakeGitHub := github.NewFakeGitHub(func(command github.Command, args []string) (bool, error) {
 		switch command {
@@ -437,10 +720,101 @@ func TestItForksIfPermissionsCheckFails(t *testing.T) {
 	})
 }
 
-func runCloneCommand() (string, error) {
+func TestItRunsCloneHooks(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+	fakeGit := git.NewAlwaysSucceedsFakeGit()
+	g = fakeGit
+	fakeHooks := hooks.NewAlwaysSucceedsFakeHooks()
+	hk = fakeHooks
+
+	testsupport.PrepareTempCampaign(false, "org/repo1", "org/repo2")
+	testsupport.CreateConfigFile("hooks:\n  pre-clone: echo pre\n  post-clone: echo post\n")
+
+	out, err := runCloneCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Running pre-clone hook for org/repo1")
+	assert.Contains(t, out, "Running post-clone hook for org/repo1")
+	assert.Contains(t, out, "turbolift clone completed (2 repos cloned, 0 repos skipped)")
+
+	fakeHooks.AssertCalledWith(t, [][]string{
+		{"pre-clone", ".", "org/repo1"},
+		{"post-clone", "work/org/repo1", "org/repo1"},
+		{"pre-clone", ".", "org/repo2"},
+		{"post-clone", "work/org/repo2", "org/repo2"},
+	})
+}
+
+func TestItDoesNotCloneIfPreCloneHookFails(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+	fakeGit := git.NewAlwaysSucceedsFakeGit()
+	g = fakeGit
+	fakeHooks := hooks.NewAlwaysFailsFakeHooks()
+	hk = fakeHooks
+
+	testsupport.PrepareTempCampaign(false, "org/repo1", "org/repo2")
+	testsupport.CreateConfigFile("hooks:\n  pre-clone: exit 1\n")
+
+	out, err := runCloneCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "pre-clone hook failed")
+	assert.Contains(t, out, "2 repos errored")
+
+	fakeGitHub.AssertCalledWith(t, [][]string{
+		{"user_can_push", "org/repo1"},
+		{"user_can_push", "org/repo2"},
+	})
+	fakeGit.AssertCalledWith(t, [][]string{})
+}
+
+func TestItSyncsForksBeforeBranchingWhenAsked(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+	fakeGit := git.NewAlwaysSucceedsFakeGit()
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(false, "org1/repo1")
+
+	out, err := runCloneCommandWithFork("--sync-fork")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Syncing fork of org1/repo1 from upstream")
+	assert.Contains(t, out, "turbolift clone completed (1 repos cloned, 0 repos skipped)")
+
+	fakeGit.AssertCalledWith(t, [][]string{
+		{"syncFork", "work/org1/repo1", "main"},
+		{"checkout", "work/org1/repo1", testsupport.Pwd()},
+		{"pull", "--ff-only", "work/org1/repo1", "upstream", "main"},
+	})
+}
+
+func TestItDoesNotBranchFromAForkThatCannotBeSynced(t *testing.T) {
+	gh = github.NewAlwaysSucceedsFakeGitHub()
+	fakeGit := git.NewFakeGit(func(_ io.Writer, call []string) (bool, error) {
+		if call[0] == "syncFork" {
+			return false, errors.New("non-fast-forward")
+		}
+		return true, nil
+	})
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(false, "org1/repo1")
+
+	out, err := runCloneCommandWithFork("--sync-fork")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Unable to fast-forward main from upstream")
+	assert.Contains(t, out, "1 repos errored")
+
+	fakeGit.AssertCalledWith(t, [][]string{
+		{"syncFork", "work/org1/repo1", "main"},
+	})
+}
+
+func runCloneCommand(args ...string) (string, error) {
 	cmd := NewCloneCmd()
 	outBuffer := bytes.NewBufferString("")
 	cmd.SetOut(outBuffer)
+	cmd.SetArgs(args)
 	forceFork = false
 	err := cmd.Execute()
 	if err != nil {
@@ -449,10 +823,11 @@ func runCloneCommand() (string, error) {
 	return outBuffer.String(), nil
 }
 
-func runCloneCommandWithFork() (string, error) {
+func runCloneCommandWithFork(args ...string) (string, error) {
 	cmd := NewCloneCmd()
 	outBuffer := bytes.NewBufferString("")
 	cmd.SetOut(outBuffer)
+	cmd.SetArgs(args)
 	forceFork = true
 	err := cmd.Execute()
 	if err != nil {
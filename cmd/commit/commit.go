@@ -16,96 +16,225 @@
 package commit
 
 import (
-	"os"
-	"path"
+	"context"
+	"strings"
 
 	"github.com/spf13/cobra"
 
+	"github.com/skyscanner/turbolift/cmd/flags"
 	"github.com/skyscanner/turbolift/internal/campaign"
 	"github.com/skyscanner/turbolift/internal/colors"
 	"github.com/skyscanner/turbolift/internal/git"
+	"github.com/skyscanner/turbolift/internal/hooks"
+	"github.com/skyscanner/turbolift/internal/interrupt"
 	"github.com/skyscanner/turbolift/internal/logging"
+	"github.com/skyscanner/turbolift/internal/messages"
+	"github.com/skyscanner/turbolift/internal/operations"
+	"github.com/skyscanner/turbolift/internal/prompt"
+	"github.com/skyscanner/turbolift/internal/summary"
 )
 
-var g git.Git = git.NewRealGit()
+var (
+	g  git.Git       = git.NewRealGit()
+	hk hooks.Hooks   = hooks.NewRealHooks()
+	p  prompt.Prompt = prompt.NewRealPrompt()
+)
+
+// The choices offered for each repo by --interactive
+const (
+	choiceCommit    = "Commit"
+	choiceSkip      = "Skip this repo"
+	choiceCommitAll = "Commit this and all remaining repos"
+	choiceAbort     = "Abort"
+)
 
 var (
-	message  string
-	repoFile string
+	message        string
+	repoFile       string
+	gpgSign        bool
+	signoff        bool
+	authorName     string
+	authorEmail    string
+	committerName  string
+	committerEmail string
+	amend          bool
+	shuffle        string
+	resume         bool
+	interactive    bool
 )
 
 func NewCommitCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "commit",
 		Short: "Applies git commit -a -m '...' to all working copies, if they have changes",
-		Run:   run,
-	}
+		Long: `Applies git commit -a -m '...' to all working copies, if they have changes.
 
-	cmd.Flags().StringVarP(&message, "message", "m", "", "Commit message to apply")
-	cmd.Flags().StringVar(&repoFile, "repos", "repos.txt", "A file containing a list of repositories to clone.")
+Run it again after making more changes to add another commit to each campaign branch, giving each commit its own
+message. Use --amend to fold the changes into the last campaign commit instead, keeping its message unless another
+is given.
 
-	err := cmd.MarkFlagRequired("message")
-	if err != nil {
-		panic(err)
+Use --interactive to review the changes in each repo before they are committed: its diff is shown, and the commit
+can go ahead, be skipped, or be aborted along with the rest of the run.`,
+		Run: run,
 	}
 
+	cmd.Flags().StringVarP(&message, "message", "m", "", "Commit message to apply (required, unless amending)")
+	cmd.Flags().BoolVar(&amend, "amend", false, "Amend the last campaign commit in each repo rather than adding a new one")
+	cmd.Flags().StringVar(&repoFile, "repos", "repos.txt", "A file containing a list of repositories to clone.")
+	cmd.Flags().BoolVarP(&gpgSign, "gpg-sign", "S", false, "GPG-sign commits (defaults to commit.gpg-sign in turbolift.yaml)")
+	cmd.Flags().BoolVarP(&signoff, "signoff", "s", false, "Add a Signed-off-by trailer to commits (defaults to commit.signoff in turbolift.yaml)")
+	cmd.Flags().StringVar(&authorName, "author-name", "", "Override the commit author name")
+	cmd.Flags().StringVar(&authorEmail, "author-email", "", "Override the commit author email")
+	cmd.Flags().StringVar(&committerName, "committer-name", "", "Override the committer name")
+	cmd.Flags().StringVar(&committerEmail, "committer-email", "", "Override the committer email")
+	cmd.Flags().BoolVarP(&interactive, "interactive", "i", false, "Show the changes in each repo and ask whether to commit them")
+	flags.AddShuffleFlag(cmd, &shuffle)
+	flags.AddResumeFlag(cmd, &resume)
+	flags.MarkMakesChanges(cmd)
+
 	return cmd
 }
 
 func run(c *cobra.Command, _ []string) {
+	ctx := c.Context()
 	logger := logging.NewLogger(c)
 
+	if message == "" && !amend {
+		logger.Errorf("A commit message must be given with --message, unless amending the last commit with --amend")
+		return
+	}
+
 	readCampaignActivity := logger.StartActivity("Reading campaign data (%s)", repoFile)
 	options := campaign.NewCampaignOptions()
 	options.RepoFilename = repoFile
+	options.Shuffle = shuffle
 	dir, err := campaign.OpenCampaign(options)
 	if err != nil {
 		readCampaignActivity.EndWithFailure(err)
 		return
 	}
 	readCampaignActivity.EndWithSuccess()
+	flags.LogShuffle(logger, dir)
 
-	doneCount := 0
-	skippedCount := 0
-	errorCount := 0
-	for _, repo := range dir.Repos {
-		repoDirPath := path.Join("work", repo.OrgName, repo.RepoName) // i.e. work/org/repo
+	progress, err := campaign.NewProgress("commit", dir.BranchName, resume)
+	if err != nil {
+		logger.Errorf("%s", err)
+		return
+	}
+	if progress.Resumed() > 0 {
+		logger.Printf("Resuming the last run, so leaving alone the %d repos it had finished with", progress.Resumed())
+	}
 
-		commitActivity := logger.StartActivity("Committing changes in %s", repo.FullRepoName)
+	clients := operations.Clients{Git: g, Hooks: hk}
+	commitOptions := operations.CommitOptions{Message: message, Git: buildCommitOptions(dir.Config.Commit)}
 
-		// skip if the working copy does not exist
-		if _, err = os.Stat(repoDirPath); os.IsNotExist(err) {
-			commitActivity.EndWithWarningf("Directory %s does not exist - has it been cloned?", repoDirPath)
-			skippedCount++
-			continue
-		}
+	// once the rest are accepted in review, they are committed without being shown
+	reviewing := interactive
 
-		isChanged, err := g.IsRepoChanged(commitActivity.Writer(), repoDirPath)
-		if err != nil {
-			commitActivity.EndWithFailure(err)
-			errorCount++
+	sum := summary.New("commit")
+	for i, repo := range dir.Repos {
+		if interrupt.Requested(ctx) {
+			logger.Warnf("Interrupted, so not committing in the remaining %d repos", len(dir.Repos)-i)
+			break
+		}
+		if progress.AlreadyCompleted(repo) {
 			continue
 		}
 
-		if !isChanged {
-			commitActivity.EndWithWarning("No changes - skipping commit")
-			skippedCount++
-			continue
+		commitOptions.Review = nil
+		if reviewing {
+			commitOptions.Review = func(activity *logging.Activity) int {
+				switch review(ctx, logger, activity, dir, repo) {
+				case choiceCommit:
+					return operations.ReviewCommit
+				case choiceCommitAll:
+					reviewing = false
+					return operations.ReviewCommit
+				case choiceSkip:
+					return operations.ReviewSkip
+				}
+				// cancelling the prompt aborts too
+				return operations.ReviewAbort
+			}
 		}
 
-		err = g.Commit(commitActivity.Writer(), repoDirPath, message)
-		if err != nil {
-			commitActivity.EndWithFailure(err)
-			errorCount++
-		} else {
-			commitActivity.EndWithSuccess()
-			doneCount++
+		outcome := operations.Commit(ctx, logger, clients, dir, repo, commitOptions)
+		if outcome.Aborted {
+			logger.Warnf("Aborted, so not committing in %s or the remaining %d repos", repo.FullRepoName, len(dir.Repos)-i-1)
+			break
+		}
+		outcome.Record(sum, repo)
+		if outcome.Finished {
+			progress.Complete(repo)
 		}
 	}
 
-	if errorCount == 0 {
-		logger.Successf("turbolift commit completed %s(%s, %s)\n", colors.Normal(), colors.Green(doneCount, " OK"), colors.Yellow(skippedCount, " skipped"))
+	sum.Finish(ctx)
+	if interrupt.Requested(ctx) {
+		logger.Warnf("%s %s(%s, %s, %s)\n", dir.Messages.Format(messages.SummaryInterrupted, messages.Fields{"Command": "commit"}), colors.Normal(), colors.Green(sum.Done, " OK"), colors.Yellow(sum.Skipped, " skipped"), colors.Red(sum.Errored, " errored"))
+	} else if sum.Errored == 0 {
+		logger.Successf("%s %s(%s, %s)\n", dir.Messages.Format(messages.SummaryCompleted, messages.Fields{"Command": "commit"}), colors.Normal(), colors.Green(sum.Done, " OK"), colors.Yellow(sum.Skipped, " skipped"))
+	} else {
+		logger.Warnf("%s %s(%s, %s, %s)\n", dir.Messages.Format(messages.SummaryWithErrors, messages.Fields{"Command": "commit"}), colors.Normal(), colors.Green(sum.Done, " OK"), colors.Yellow(sum.Skipped, " skipped"), colors.Red(sum.Errored, " errored"))
+	}
+
+	if err := progress.End(interrupt.Requested(ctx)); err != nil {
+		logger.Warnf("Unable to update the checkpoint in the campaign state: %s", err)
+	} else if interrupt.Requested(ctx) {
+		logger.Printf("To carry on from where it stopped, run %s again with the same options", colors.Cyan(c.CommandPath()+" --resume"))
+	}
+	if amend && sum.Done > 0 {
+		logger.Printf("Amended commits that have already been pushed need pushing with %s", colors.Cyan("turbolift create-prs --update-existing --force-with-lease"))
+	}
+}
+
+// review shows the changes that are about to be committed to a repo, ending the activity that found them, and asks
+// what to do with them. It returns the choice made, which is empty if the prompt was cancelled.
+func review(ctx context.Context, logger *logging.Logger, activity *logging.Activity, dir *campaign.Campaign, repo campaign.Repo) string {
+	diff, err := g.UncommittedDiff(ctx, activity.Writer(), repo.FullRepoPath())
+	if err != nil {
+		activity.EndWithWarningf("Unable to read the changes, so they cannot be shown: %s", err)
 	} else {
-		logger.Warnf("turbolift commit completed with %s %s(%s, %s, %s)\n", colors.Red("errors"), colors.Normal(), colors.Green(doneCount, " OK"), colors.Yellow(skippedCount, " skipped"), colors.Red(errorCount, " errored"))
+		activity.EndWithSuccess()
+		logger.Println()
+		printDiff(logger, diff)
+		logger.Println()
 	}
+
+	return p.AskChoice(dir.Messages.Format(messages.ConfirmCommit, messages.Fields{"Repo": repo.FullRepoName}), []string{choiceCommit, choiceSkip, choiceCommitAll, choiceAbort})
+}
+
+// printDiff logs a diff with its added and removed lines coloured
+func printDiff(logger *logging.Logger, diff string) {
+	if strings.TrimSpace(diff) == "" {
+		logger.Println("No changes to tracked files")
+		return
+	}
+	for _, line := range strings.Split(strings.TrimRight(diff, "\n"), "\n") {
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+			logger.Println(line)
+		case strings.HasPrefix(line, "+"):
+			logger.Println(colors.Green(line))
+		case strings.HasPrefix(line, "-"):
+			logger.Println(colors.Red(line))
+		case strings.HasPrefix(line, "@@"):
+			logger.Println(colors.Cyan(line))
+		default:
+			logger.Println(line)
+		}
+	}
+}
+
+// buildCommitOptions combines the campaign's commit configuration with any overrides given as flags
+func buildCommitOptions(config campaign.CommitConfig) git.CommitOptions {
+	return operations.GitCommitOptions(config, git.CommitOptions{
+		GpgSign:        gpgSign,
+		Signoff:        signoff,
+		AuthorName:     authorName,
+		AuthorEmail:    authorEmail,
+		CommitterName:  committerName,
+		CommitterEmail: committerEmail,
+		Amend:          amend,
+	})
 }
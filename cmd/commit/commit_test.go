@@ -17,8 +17,15 @@ package commit
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"github.com/skyscanner/turbolift/cmd/flags"
+	"github.com/skyscanner/turbolift/internal/exitcode"
 	"github.com/skyscanner/turbolift/internal/git"
+	"github.com/skyscanner/turbolift/internal/hooks"
+	"github.com/skyscanner/turbolift/internal/interrupt"
+	"github.com/skyscanner/turbolift/internal/prompt"
+	"github.com/skyscanner/turbolift/internal/summary"
 	"github.com/skyscanner/turbolift/internal/testsupport"
 	"github.com/stretchr/testify/assert"
 	"io"
@@ -38,8 +45,10 @@ func TestItCommitsAllWithChanges(t *testing.T) {
 
 	fakeGit.AssertCalledWith(t, [][]string{
 		{"isRepoChanged", "work/org/repo1"},
+		{"campaignCommits", "work/org/repo1"},
 		{"commit", "work/org/repo1", "some test message"},
 		{"isRepoChanged", "work/org/repo2"},
+		{"campaignCommits", "work/org/repo2"},
 		{"commit", "work/org/repo2", "some test message"},
 	})
 }
@@ -64,10 +73,38 @@ func TestItSkipsReposWithoutChanges(t *testing.T) {
 	fakeGit.AssertCalledWith(t, [][]string{
 		{"isRepoChanged", "work/org/repo1"},
 		{"isRepoChanged", "work/org/repo2"},
+		{"campaignCommits", "work/org/repo2"},
 		{"commit", "work/org/repo2", "some test message"},
 	})
 }
 
+func TestItSummarisesWhatItDidToEachRepo(t *testing.T) {
+	fakeGit := git.NewFakeGit(func(output io.Writer, call []string) (bool, error) {
+		if call[0] == "isRepoChanged" && call[1] == "work/org/repo1" {
+			return false, nil
+		}
+		if call[0] == "commit" && call[1] == "work/org/repo3" {
+			return false, errors.New("synthetic error")
+		}
+		return true, nil
+	})
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2", "org/repo3")
+
+	_, sum, err := runCommandForSummary("some test message")
+	assert.NoError(t, err)
+
+	assert.Equal(t, "commit", sum.Command)
+	assert.Equal(t, 1, sum.Done)
+	assert.Equal(t, 1, sum.Skipped)
+	assert.Equal(t, 1, sum.Errored)
+	assert.Equal(t, []string{"org/repo1", "org/repo2", "org/repo3"}, []string{sum.Repos[0].Repo, sum.Repos[1].Repo, sum.Repos[2].Repo})
+	assert.Equal(t, "no changes", sum.Repos[0].Reason)
+	assert.Equal(t, summary.Done, sum.Repos[1].Outcome)
+	assert.Equal(t, "synthetic error", sum.Repos[2].Reason)
+}
+
 func TestItSkipsReposWhichErrorOnStatusChekc(t *testing.T) {
 	fakeGit := git.NewFakeGit(func(output io.Writer, call []string) (bool, error) {
 		if call[0] == "isRepoChanged" && call[1] == "work/org/repo1" {
@@ -88,10 +125,45 @@ func TestItSkipsReposWhichErrorOnStatusChekc(t *testing.T) {
 	fakeGit.AssertCalledWith(t, [][]string{
 		{"isRepoChanged", "work/org/repo1"},
 		{"isRepoChanged", "work/org/repo2"},
+		{"campaignCommits", "work/org/repo2"},
 		{"commit", "work/org/repo2", "some test message"},
 	})
 }
 
+func TestItStopsAfterTheFirstFailureWithFailFast(t *testing.T) {
+	fakeGit := git.NewFakeGit(func(output io.Writer, call []string) (bool, error) {
+		if call[0] == "isRepoChanged" && call[1] == "work/org/repo1" {
+			return false, errors.New("synthetic error")
+		}
+		return true, nil
+	})
+	g = fakeGit
+	flags.FailFast = true
+	defer func() { flags.FailFast = false }()
+	exitcode.Reset()
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+
+	ctx, requestStop := interrupt.WithGracefulStop(context.Background())
+	defer requestStop()
+	cmd := NewCommitCmd()
+	outBuffer := bytes.NewBufferString("")
+	cmd.SetOut(outBuffer)
+	cmd.SetArgs([]string{"--message", "some test message"})
+	err := cmd.ExecuteContext(ctx)
+	assert.NoError(t, err)
+
+	out := outBuffer.String()
+	assert.Contains(t, out, "Stopping after the first failure, as --fail-fast is set")
+	assert.Contains(t, out, "Interrupted, so not committing in the remaining 1 repos")
+	assert.Contains(t, out, "0 OK, 0 skipped, 1 errored")
+	assert.Equal(t, exitcode.Errored, exitcode.Code())
+
+	fakeGit.AssertCalledWith(t, [][]string{
+		{"isRepoChanged", "work/org/repo1"},
+	})
+}
+
 func TestItSkipsMissingRepos(t *testing.T) {
 	fakeGit := git.NewAlwaysSucceedsFakeGit()
 	g = fakeGit
@@ -102,16 +174,212 @@ func TestItSkipsMissingRepos(t *testing.T) {
 		panic(err)
 	}
 
+	exitcode.Reset()
+	out, err := runCommand("some test message", []string{}...)
+	assert.NoError(t, err)
+	assert.Contains(t, out, "1 OK, 1 skipped")
+	assert.Equal(t, exitcode.Skipped, exitcode.Code())
+
+	fakeGit.AssertCalledWith(t, [][]string{
+		{"isRepoChanged", "work/org/repo2"},
+		{"campaignCommits", "work/org/repo2"},
+		{"commit", "work/org/repo2", "some test message"},
+	})
+}
+
+func TestItRunsCommitHooks(t *testing.T) {
+	fakeGit := git.NewAlwaysSucceedsFakeGit()
+	g = fakeGit
+	fakeHooks := hooks.NewAlwaysSucceedsFakeHooks()
+	hk = fakeHooks
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+	testsupport.CreateConfigFile("hooks:\n  pre-commit: gofmt -w .\n  post-commit: git log -1\n")
+
 	out, err := runCommand("some test message", []string{}...)
 	assert.NoError(t, err)
+	assert.Contains(t, out, "Running pre-commit hook for org/repo1")
+	assert.Contains(t, out, "2 OK")
+
+	fakeHooks.AssertCalledWith(t, [][]string{
+		{"pre-commit", "work/org/repo1", "org/repo1"},
+		{"post-commit", "work/org/repo1", "org/repo1"},
+		{"pre-commit", "work/org/repo2", "org/repo2"},
+		{"post-commit", "work/org/repo2", "org/repo2"},
+	})
+}
+
+func TestItDoesNotCommitIfPreCommitHookFails(t *testing.T) {
+	fakeGit := git.NewAlwaysSucceedsFakeGit()
+	g = fakeGit
+	fakeHooks := hooks.NewAlwaysFailsFakeHooks()
+	hk = fakeHooks
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+	testsupport.CreateConfigFile("hooks:\n  pre-commit: exit 1\n")
+
+	out, err := runCommand("some test message", []string{}...)
+	assert.NoError(t, err)
+	assert.Contains(t, out, "pre-commit hook failed")
+	assert.Contains(t, out, "0 OK, 0 skipped, 2 errored")
+
+	fakeGit.AssertCalledWith(t, [][]string{})
+}
+
+func TestItSignsAndOverridesIdentityFromFlagsAndConfig(t *testing.T) {
+	fakeGit := git.NewAlwaysSucceedsFakeGit()
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+	testsupport.CreateConfigFile("commit:\n  signoff: true\n  author-name: Config Bot\n  author-email: config-bot@example.com\n")
+
+	out, err := runCommand("some test message", "--gpg-sign", "--author-name", "Flag Bot")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "1 OK")
+
+	fakeGit.AssertCalledWith(t, [][]string{
+		{"isRepoChanged", "work/org/repo1"},
+		{"campaignCommits", "work/org/repo1"},
+		{"commit", "work/org/repo1", "some test message", "--gpg-sign", "--signoff", "GIT_AUTHOR_EMAIL=config-bot@example.com", "GIT_AUTHOR_NAME=Flag Bot"},
+	})
+}
+
+func TestItSkipsReposWhereTheBranchAlreadyHasACommitWithTheMessage(t *testing.T) {
+	fakeGit := git.NewAlwaysSucceedsFakeGit().WithCampaignCommits("Bump the Go version", "some test message")
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runCommand("some test message\n\nWith a body")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "A commit with this message is already on the branch")
+	assert.Contains(t, out, "0 OK, 1 skipped")
+
+	fakeGit.AssertCalledWith(t, [][]string{
+		{"isRepoChanged", "work/org/repo1"},
+		{"campaignCommits", "work/org/repo1"},
+	})
+}
+
+func TestItAmendsTheLastCampaignCommitKeepingItsMessage(t *testing.T) {
+	fakeGit := git.NewAlwaysSucceedsFakeGit().WithCampaignCommits("some test message")
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runCommand("", "--amend")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "1 OK")
+	assert.Contains(t, out, "turbolift create-prs --update-existing --force-with-lease")
+
+	fakeGit.AssertCalledWith(t, [][]string{
+		{"isRepoChanged", "work/org/repo1"},
+		{"campaignCommits", "work/org/repo1"},
+		{"commit", "work/org/repo1", "", "--amend"},
+	})
+}
+
+func TestItDoesNotAmendCommitsThatAreNotPartOfTheCampaign(t *testing.T) {
+	fakeGit := git.NewAlwaysSucceedsFakeGit()
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runCommand("reworded", "--amend")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "No campaign commit to amend")
+	assert.Contains(t, out, "0 OK, 1 skipped")
+
+	fakeGit.AssertCalledWith(t, [][]string{
+		{"isRepoChanged", "work/org/repo1"},
+		{"campaignCommits", "work/org/repo1"},
+	})
+}
+
+func TestItNeedsAMessageUnlessAmending(t *testing.T) {
+	fakeGit := git.NewAlwaysSucceedsFakeGit()
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runCommand("")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "A commit message must be given with --message")
+
+	fakeGit.AssertCalledWith(t, [][]string{})
+}
+
+func TestItShowsEachDiffAndCommitsOrSkipsAsChosen(t *testing.T) {
+	fakeGit := git.NewAlwaysSucceedsFakeGit().WithDiff("--- a/go.mod\n+++ b/go.mod\n-go 1.16\n+go 1.21\n")
+	g = fakeGit
+	hk = hooks.NewAlwaysSucceedsFakeHooks()
+	fakePrompt := prompt.NewFakePromptChoices(choiceSkip, choiceCommit)
+	p = fakePrompt
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+
+	out, err := runCommand("some test message", "--interactive")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "+go 1.21")
 	assert.Contains(t, out, "1 OK, 1 skipped")
 
+	fakePrompt.AssertCalledWith(t, "Commit these changes to org/repo1?", "Commit these changes to org/repo2?")
+	fakeGit.AssertCalledWith(t, [][]string{
+		{"isRepoChanged", "work/org/repo1"},
+		{"campaignCommits", "work/org/repo1"},
+		{"uncommittedDiff", "work/org/repo1"},
+		{"isRepoChanged", "work/org/repo2"},
+		{"campaignCommits", "work/org/repo2"},
+		{"uncommittedDiff", "work/org/repo2"},
+		{"commit", "work/org/repo2", "some test message"},
+	})
+}
+
+func TestItCommitsTheRemainingReposWithoutReviewOnceAccepted(t *testing.T) {
+	fakeGit := git.NewAlwaysSucceedsFakeGit()
+	g = fakeGit
+	hk = hooks.NewAlwaysSucceedsFakeHooks()
+	fakePrompt := prompt.NewFakePromptChoices(choiceCommitAll)
+	p = fakePrompt
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+
+	out, err := runCommand("some test message", "--interactive")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "2 OK")
+
+	fakePrompt.AssertCalledWith(t, "Commit these changes to org/repo1?")
 	fakeGit.AssertCalledWith(t, [][]string{
+		{"isRepoChanged", "work/org/repo1"},
+		{"campaignCommits", "work/org/repo1"},
+		{"uncommittedDiff", "work/org/repo1"},
+		{"commit", "work/org/repo1", "some test message"},
 		{"isRepoChanged", "work/org/repo2"},
+		{"campaignCommits", "work/org/repo2"},
 		{"commit", "work/org/repo2", "some test message"},
 	})
 }
 
+func TestItStopsCommittingWhenAbortedInReview(t *testing.T) {
+	fakeGit := git.NewAlwaysSucceedsFakeGit()
+	g = fakeGit
+	hk = hooks.NewAlwaysSucceedsFakeHooks()
+	p = prompt.NewFakePromptChoices(choiceAbort)
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+
+	out, err := runCommand("some test message", "--interactive")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Aborted, so not committing in org/repo1 or the remaining 1 repos")
+	assert.Contains(t, out, "0 OK, 0 skipped")
+
+	fakeGit.AssertCalledWith(t, [][]string{
+		{"isRepoChanged", "work/org/repo1"},
+		{"campaignCommits", "work/org/repo1"},
+		{"uncommittedDiff", "work/org/repo1"},
+	})
+}
+
 func runCommand(m string, args ...string) (string, error) {
 	cmd := NewCommitCmd()
 	outBuffer := bytes.NewBufferString("")
@@ -129,3 +397,17 @@ func runCommand(m string, args ...string) (string, error) {
 	}
 	return outBuffer.String(), nil
 }
+
+// runCommandForSummary runs the command as runCommand does, also returning the summary that it finished with
+func runCommandForSummary(m string, args ...string) (string, *summary.Summary, error) {
+	ctx, collector := summary.WithCollector(context.Background())
+	cmd := NewCommitCmd()
+	outBuffer := bytes.NewBufferString("")
+	cmd.SetOut(outBuffer)
+	cmd.SetArgs(args)
+	if err := cmd.Flags().Set("message", m); err != nil {
+		panic(err)
+	}
+	err := cmd.ExecuteContext(ctx)
+	return outBuffer.String(), collector.Summary(), err
+}
@@ -16,34 +16,60 @@
 package create_prs
 
 import (
+	"context"
 	"fmt"
-	"os"
-	"path"
 	"strings"
 	"time"
 
+	"github.com/skyscanner/turbolift/internal/interrupt"
+	"github.com/skyscanner/turbolift/internal/messages"
+	"github.com/skyscanner/turbolift/internal/operations"
+	"github.com/skyscanner/turbolift/internal/prbody"
+	"github.com/skyscanner/turbolift/internal/preflight"
 	"github.com/skyscanner/turbolift/internal/prompt"
 
 	"github.com/spf13/cobra"
 
+	"github.com/skyscanner/turbolift/cmd/flags"
+	"github.com/skyscanner/turbolift/internal/bitbucket"
 	"github.com/skyscanner/turbolift/internal/campaign"
 	"github.com/skyscanner/turbolift/internal/colors"
 	"github.com/skyscanner/turbolift/internal/git"
 	"github.com/skyscanner/turbolift/internal/github"
+	"github.com/skyscanner/turbolift/internal/hooks"
 	"github.com/skyscanner/turbolift/internal/logging"
+	"github.com/skyscanner/turbolift/internal/preview"
+	"github.com/skyscanner/turbolift/internal/registry"
+	"github.com/skyscanner/turbolift/internal/summary"
 )
 
 var (
 	gh github.GitHub = github.NewRealGitHub()
+	bb github.GitHub = bitbucket.NewBitbucketServer()
 	g  git.Git       = git.NewRealGit()
 	p  prompt.Prompt = prompt.NewRealPrompt()
+	hk hooks.Hooks   = hooks.NewRealHooks()
+
+	openRegistry = registry.NewRegistry
 )
 
 var (
 	isDraft           bool
 	repoFile          string
+	branchName        string
 	prDescriptionFile string
 	sleep             time.Duration
+	diffSummary       string
+	autoMerge         string
+	shuffle           string
+	resume            bool
+	previewChanges    bool
+	largeDiffLines    int
+	updateExisting    bool
+	forceWithLease    bool
+	runPreflight      bool
+	baseBranch        string
+	yesFlag           bool
 )
 
 func NewCreatePRsCmd() *cobra.Command {
@@ -56,17 +82,43 @@ func NewCreatePRsCmd() *cobra.Command {
 	cmd.Flags().DurationVar(&sleep, "sleep", 0, "Fixed sleep in between PR creations (to spread load on CI infrastructure)")
 	cmd.Flags().BoolVar(&isDraft, "draft", false, "Creates the Pull Request as Draft PR")
 	cmd.Flags().StringVar(&repoFile, "repos", "repos.txt", "A file containing a list of repositories to clone.")
+	cmd.Flags().StringVar(&branchName, "branch", "", "The branch to use for the campaign (defaults to branch in turbolift.yaml, or the campaign name)")
 	cmd.Flags().StringVar(&prDescriptionFile, "description", "README.md", "A file containing the title and description for the PRs.")
+	cmd.Flags().StringVar(&autoMerge, "auto-merge", "", "Enable auto-merge on each PR, using the given merge strategy: merge, squash or rebase")
+	cmd.Flags().Lookup("auto-merge").NoOptDefVal = "squash"
+	cmd.Flags().StringVar(&diffSummary, "diff-summary", "", "Append a summary of the changes to each PR description: files (a list of changed files) or diff (the diff itself, truncated if long)")
+	cmd.Flags().BoolVar(&updateExisting, "update-existing", false, "Update the title and description of PRs that already exist for the campaign branch, rather than skipping them")
+	cmd.Flags().BoolVar(&forceWithLease, "force-with-lease", false, "Force-push campaign branches, e.g. after commit --amend, unless someone else has pushed to them since they were last fetched")
+	cmd.Flags().StringVar(&baseBranch, "base-branch", "", "Raise PRs against this branch rather than each repo's default branch, for repos that are not given a default-branch in the repos file")
+	cmd.Flags().BoolVar(&runPreflight, "preflight", false, "Check that every PR can be raised, as turbolift preflight does, and stop before pushing anything if not")
+	cmd.Flags().BoolVar(&previewChanges, "preview", false, "Show the size of each repo's changes first, and ask whether to include repos whose diff is empty or suspiciously large")
+	cmd.Flags().BoolVar(&yesFlag, "yes", false, "Go ahead without asking, even if the PR description looks unchanged, and with --preview include every repo")
+	cmd.Flags().IntVar(&largeDiffLines, "large-diff", preview.DefaultLargeDiffLines, "With --preview, flag diffs that insert or delete more than this many lines (0 to turn off)")
+	flags.AddShuffleFlag(cmd, &shuffle)
+	flags.AddResumeFlag(cmd, &resume)
+	flags.MarkMakesChanges(cmd)
 
 	return cmd
 }
 
 func run(c *cobra.Command, _ []string) {
+	ctx := c.Context()
 	logger := logging.NewLogger(c)
 
+	if diffSummary != "" && diffSummary != operations.DiffSummaryFiles && diffSummary != operations.DiffSummaryDiff {
+		logger.Errorf("Unknown diff summary %s: must be one of files, diff", diffSummary)
+		return
+	}
+	if autoMerge != "" && !github.IsMergeStrategy(autoMerge) {
+		logger.Errorf("Unknown merge strategy %s: must be one of %s", autoMerge, strings.Join(github.MergeStrategies, ", "))
+		return
+	}
+
 	readCampaignActivity := logger.StartActivity("Reading campaign data (%s, %s)", repoFile, prDescriptionFile)
 	options := campaign.NewCampaignOptions()
 	options.RepoFilename = repoFile
+	options.Shuffle = shuffle
+	options.BranchName = branchName
 	options.PrDescriptionFilename = prDescriptionFile
 	dir, err := campaign.OpenCampaign(options)
 	if err != nil {
@@ -74,74 +126,234 @@ func run(c *cobra.Command, _ []string) {
 		return
 	}
 	readCampaignActivity.EndWithSuccess()
+	flags.LogShuffle(logger, dir)
+	for i := range dir.Repos {
+		dir.Repos[i] = operations.WithBaseBranch(dir.Repos[i], baseBranch)
+	}
+
+	progress, err := campaign.NewProgress("create-prs", dir.BranchName, resume)
+	if err != nil {
+		logger.Errorf("%s", err)
+		return
+	}
+	if progress.Resumed() > 0 {
+		logger.Printf("Resuming the last run, so leaving alone the %d repos it had finished with", progress.Resumed())
+	}
 
 	// checking whether the description has changed
-	if prDescriptionUnchanged(dir) {
+	if !yesFlag && prDescriptionUnchanged(dir) {
 		if !p.AskConfirm(fmt.Sprintf("It looks like the PR title and/or description may not have been updated in %s. Are you sure you want to proceed?", prDescriptionFile)) {
 			return
 		}
 	}
 
-	doneCount := 0
-	skippedCount := 0
-	errorCount := 0
-	for _, repo := range dir.Repos {
+	var excluded map[string]bool
+	if previewChanges {
+		excluded = previewDiffs(ctx, logger, dir, progress)
+	}
+	if runPreflight && !checkPreflight(ctx, logger, dir, progress, excluded) {
+		return
+	}
+
+	siblingCampaigns := loadSiblingCampaigns(ctx, logger, dir)
+	clients := operations.Clients{GitHub: gh, Bitbucket: bb, Git: g, Hooks: hk}
+	prOptions := operations.CreatePROptions{
+		Pipeline:       prbody.ForCampaign(dir),
+		Draft:          isDraft,
+		ForceWithLease: forceWithLease,
+		DiffSummary:    diffSummary,
+		AutoMerge:      autoMerge,
+		UpdateExisting: updateExisting,
+	}
+
+	sum := summary.New("create-prs")
+	conflictCount := 0
+	rejected := &pushRejections{repos: map[git.Rejection][]string{}}
+	var tracked []campaign.TrackedPR
+	for i, repo := range dir.Repos {
+		if progress.AlreadyCompleted(repo) {
+			continue
+		}
+
 		if sleep > 0 {
 			logger.Successf("Sleeping for %s", sleep)
-			time.Sleep(sleep)
+			select {
+			case <-interrupt.Stopping(ctx).Done():
+			case <-time.After(sleep):
+			}
 		}
 
-		repoDirPath := path.Join("work", repo.OrgName, repo.RepoName) // i.e. work/org/repo
+		if interrupt.Requested(ctx) {
+			logger.Warnf("Interrupted, so not creating PRs for the remaining %d repos", len(dir.Repos)-i)
+			break
+		}
 
-		pushActivity := logger.StartActivity("Pushing changes in %s to origin", repo.FullRepoName)
-		// skip if the working copy does not exist
-		if _, err = os.Stat(repoDirPath); os.IsNotExist(err) {
-			pushActivity.EndWithWarningf("Directory %s does not exist - has it been cloned?", repoDirPath)
-			skippedCount++
+		if excluded[repo.FullRepoName] {
+			logger.Warnf("Leaving out %s, as its diff was not confirmed", repo.FullRepoName)
+			sum.RecordSkipped(repo, "diff not confirmed")
 			continue
 		}
 
-		err := g.Push(pushActivity.Writer(), repoDirPath, "origin", dir.Name)
-		if err != nil {
-			pushActivity.EndWithFailure(err)
-			errorCount++
-			continue
+		prOptions.Siblings = siblingCampaigns[repo.FullRepoName]
+		outcome := operations.CreatePR(ctx, logger, clients, dir, repo, prOptions)
+		if outcome.Rejection != nil {
+			rejected.add(*outcome.Rejection, repo.FullRepoName)
+		}
+		if outcome.Conflicting {
+			conflictCount++
+		}
+		if outcome.PR != nil {
+			tracked = append(tracked, *outcome.PR)
+		}
+		outcome.Record(sum, repo)
+		if outcome.Finished {
+			progress.Complete(repo)
+		}
+	}
+
+	sum.Finish(ctx)
+	if interrupt.Requested(ctx) {
+		logger.Warnf("%s %s(%s, %s, %s)\n", dir.Messages.Format(messages.SummaryInterrupted, messages.Fields{"Command": "create-prs"}), colors.Normal(), colors.Green(sum.Done, " OK"), colors.Yellow(sum.Skipped, " skipped"), colors.Red(sum.Errored, " errored"))
+	} else if sum.Errored == 0 {
+		logger.Successf("%s %s(%s, %s)\n", dir.Messages.Format(messages.SummaryCompleted, messages.Fields{"Command": "create-prs"}), colors.Normal(), colors.Green(sum.Done, " OK"), colors.Yellow(sum.Skipped, " skipped"))
+	} else {
+		logger.Warnf("%s %s(%s, %s, %s)\n", dir.Messages.Format(messages.SummaryWithErrors, messages.Fields{"Command": "create-prs"}), colors.Normal(), colors.Green(sum.Done, " OK"), colors.Yellow(sum.Skipped, " skipped"), colors.Red(sum.Errored, " errored"))
+	}
+
+	github.LogApiUsage(ctx, gh, logger, dir.Hosts())
+
+	if err := operations.RecordPRs(tracked); err != nil {
+		logger.Warnf("Unable to record the PRs in the campaign state: %s", err)
+	}
+	if err := progress.End(interrupt.Requested(ctx)); err != nil {
+		logger.Warnf("Unable to update the checkpoint in the campaign state: %s", err)
+	} else if interrupt.Requested(ctx) {
+		logger.Printf("To carry on from where it stopped, run %s again with the same options", colors.Cyan(c.CommandPath()+" --resume"))
+	}
+
+	if conflictCount > 0 {
+		logger.Warnf("%d repos have open PRs from other campaigns touching the same files - see warnings above", conflictCount)
+	}
+	rejected.log(logger)
+}
+
+// checkPreflight checks that PRs can be raised for the repos still to be processed, returning false if any cannot, so
+// that the problems can be fixed before anything is pushed
+func checkPreflight(ctx context.Context, logger *logging.Logger, dir *campaign.Campaign, progress *campaign.Progress, excluded map[string]bool) bool {
+	var repos []campaign.Repo
+	for _, repo := range dir.Repos {
+		if !progress.AlreadyCompleted(repo) && !excluded[repo.FullRepoName] {
+			repos = append(repos, repo)
 		}
-		pushActivity.EndWithSuccess()
+	}
 
-		var createPrActivity *logging.Activity
-		if isDraft {
-			createPrActivity = logger.StartActivity("Creating Draft PR in %s", repo.FullRepoName)
-		} else {
-			createPrActivity = logger.StartActivity("Creating PR in %s", repo.FullRepoName)
+	var failing []string
+	for _, check := range preflight.Run(ctx, g, logger, repos, dir.BranchName) {
+		if len(check.Problems) > 0 {
+			failing = append(failing, check.Repo.FullRepoName)
 		}
+	}
+	if interrupt.Requested(ctx) {
+		return false
+	}
+	if len(failing) > 0 {
+		logger.Errorf("PRs cannot be raised for %d repos (%s), so nothing has been pushed - fix the problems above, or leave the repos out of %s, and run again", len(failing), strings.Join(failing, ", "), repoFile)
+		return false
+	}
+	logger.Successf("Preflight checks found no problems in %d repos", len(repos))
+	return true
+}
+
+// pushRejections groups the repos whose pushes were rejected by why they were rejected, in the order first seen
+type pushRejections struct {
+	reasons []git.Rejection
+	repos   map[git.Rejection][]string
+}
+
+func (r *pushRejections) add(rejection git.Rejection, repo string) {
+	if _, seen := r.repos[rejection]; !seen {
+		r.reasons = append(r.reasons, rejection)
+	}
+	r.repos[rejection] = append(r.repos[rejection], repo)
+}
+
+// log summarises the rejected pushes, so that repos with the same problem can be fixed together
+func (r *pushRejections) log(logger *logging.Logger) {
+	if len(r.reasons) == 0 {
+		return
+	}
+
+	total := 0
+	for _, repos := range r.repos {
+		total += len(repos)
+	}
+	logger.Warnf("Pushes to %d repos were rejected by the remote:", total)
+	for _, rejection := range r.reasons {
+		repos := r.repos[rejection]
+		logger.Printf("  %s (%d repos): %s", rejection.Reason, len(repos), strings.Join(repos, ", "))
+		logger.Printf("    Fix: %s", rejection.Hint)
+	}
+}
+
+// loadSiblingCampaigns finds the other campaigns in the registry, if one is configured, that target each repo in this campaign
+func loadSiblingCampaigns(ctx context.Context, logger *logging.Logger, dir *campaign.Campaign) map[string][]string {
+	siblings := map[string][]string{}
+
+	r, err := openRegistry(dir.Config.Registry)
+	if err != nil {
+		// conflict detection is only possible with a registry
+		return siblings
+	}
 
-		pullRequest := github.PullRequest{
-			Title:        dir.PrTitle,
-			Body:         dir.PrBody,
-			UpstreamRepo: repo.FullRepoName,
-			IsDraft:      isDraft,
+	registryActivity := logger.StartActivity("Checking the registry for other campaigns targeting the same repos")
+	entries, err := r.List(ctx, registryActivity.Writer())
+	if err != nil {
+		registryActivity.EndWithWarningf("Unable to read the registry, so conflicts with other campaigns will not be detected: %s", err)
+		return siblings
+	}
+
+	targetRepos := map[string]bool{}
+	for _, repo := range dir.Repos {
+		targetRepos[repo.FullRepoName] = true
+	}
+	for _, entry := range entries {
+		if entry.Name == dir.Name {
+			continue
+		}
+		for _, repo := range entry.Repos {
+			if targetRepos[repo] {
+				siblings[repo] = append(siblings[repo], entry.Name)
+			}
 		}
+	}
+	registryActivity.EndWithSuccess()
+
+	return siblings
+}
 
-		didCreate, err := gh.CreatePullRequest(createPrActivity.Writer(), repoDirPath, pullRequest)
-
-		if err != nil {
-			createPrActivity.EndWithFailure(err)
-			errorCount++
-		} else if !didCreate {
-			createPrActivity.EndWithWarningf("No PR created in %s", repo.FullRepoName)
-			skippedCount++
-		} else {
-			createPrActivity.EndWithSuccess()
-			doneCount++
+// previewDiffs shows the size of the changes in each repo that still needs a PR, and asks whether to include those
+// whose diff is empty or suspiciously large. It returns the repos that should be left out.
+func previewDiffs(ctx context.Context, logger *logging.Logger, dir *campaign.Campaign, progress *campaign.Progress) map[string]bool {
+	var repos []campaign.Repo
+	for _, repo := range dir.Repos {
+		if !progress.AlreadyCompleted(repo) {
+			repos = append(repos, repo)
 		}
 	}
 
-	if errorCount == 0 {
-		logger.Successf("turbolift create-prs completed %s(%s, %s)\n", colors.Normal(), colors.Green(doneCount, " OK"), colors.Yellow(skippedCount, " skipped"))
-	} else {
-		logger.Warnf("turbolift create-prs completed with %s %s(%s, %s, %s)\n", colors.Red("errors"), colors.Normal(), colors.Green(doneCount, " OK"), colors.Yellow(skippedCount, " skipped"), colors.Red(errorCount, " errored"))
+	diffs := preview.Collect(ctx, g, logger, repos)
+	logger.Println()
+	preview.Print(logger, diffs, largeDiffLines)
+
+	excluded := map[string]bool{}
+	for _, diff := range diffs {
+		if description := diff.Describe(largeDiffLines); description != "" && !yesFlag {
+			if !p.AskConfirm(dir.Messages.Format(messages.ConfirmIncludeDiff, messages.Fields{"Repo": diff.Repo.FullRepoName, "Reason": description})) {
+				excluded[diff.Repo.FullRepoName] = true
+			}
+		}
 	}
+	return excluded
 }
 
 func prDescriptionUnchanged(dir *campaign.Campaign) bool {
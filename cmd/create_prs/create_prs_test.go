@@ -17,13 +17,24 @@ package create_prs
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 
+	"github.com/skyscanner/turbolift/internal/campaign"
 	"github.com/skyscanner/turbolift/internal/git"
 	"github.com/skyscanner/turbolift/internal/github"
+	"github.com/skyscanner/turbolift/internal/hooks"
+	"github.com/skyscanner/turbolift/internal/interrupt"
+	"github.com/skyscanner/turbolift/internal/operations"
 	"github.com/skyscanner/turbolift/internal/prompt"
+	"github.com/skyscanner/turbolift/internal/registry"
 	"github.com/skyscanner/turbolift/internal/testsupport"
 )
 
@@ -48,6 +59,25 @@ func TestItWarnsIfDescriptionFileTemplateIsUnchanged(t *testing.T) {
 	fakePrompt.AssertCalledWith(t, "It looks like the PR title and/or description may not have been updated in README.md. Are you sure you want to proceed?")
 }
 
+func TestItDoesNotAskAboutAnUnchangedDescriptionWithYes(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+	fakeGit := git.NewAlwaysSucceedsFakeGit()
+	g = fakeGit
+	fakePrompt := prompt.NewFakePromptNo()
+	p = fakePrompt
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+	testsupport.UseDefaultPrDescription()
+
+	out, err := runCommand("--yes")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "turbolift create-prs completed")
+	assert.Contains(t, out, "1 OK, 0 skipped")
+
+	fakePrompt.AssertCalledWith(t, "")
+}
+
 func TestItWarnsIfOnlyPrTitleIsUnchanged(t *testing.T) {
 	fakeGitHub := github.NewAlwaysFailsFakeGitHub()
 	gh = fakeGitHub
@@ -134,6 +164,27 @@ func TestItLogsCreatePrErrorsButContinuesToTryAll(t *testing.T) {
 	})
 }
 
+func TestItReportsApiUsageAndRateLimits(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub().WithRateLimits(map[string]*github.RateLimit{
+		"github.com": {
+			Core:    github.RateLimitResource{Limit: 5000, Remaining: 4321},
+			GraphQL: github.RateLimitResource{Limit: 5000, Remaining: 1234},
+		},
+		"mygitserver.com": nil,
+	})
+	gh = fakeGitHub
+	fakeGit := git.NewAlwaysSucceedsFakeGit()
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "other/repo2", "mygitserver.com/other/repo3")
+
+	out, err := runCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "GitHub API usage: 2 gh calls (org: 1, other: 1)")
+	assert.Contains(t, out, "github.com: 4321 of 5000 REST and 1234 of 5000 GraphQL calls remaining")
+	assert.Contains(t, out, "Unable to check the rate limits")
+}
+
 func TestItLogsCreatePrSkippedButContinuesToTryAll(t *testing.T) {
 	fakeGitHub := github.NewAlwaysReturnsFalseFakeGitHub()
 	gh = fakeGitHub
@@ -174,6 +225,67 @@ func TestItLogsCreatePrsSucceeds(t *testing.T) {
 	})
 }
 
+func TestItSavesCheckpointWhenInterruptedAndResumesFromIt(t *testing.T) {
+	ctx, requestStop := interrupt.WithGracefulStop(context.Background())
+	defer requestStop()
+	fakeGitHub := github.NewFakeGitHub(func(command github.Command, args []string) (bool, error) {
+		// a Ctrl-C arrives while the first PR is being created
+		requestStop()
+		return true, nil
+	}, func(workingDir string) (interface{}, error) {
+		return nil, nil
+	})
+	gh = fakeGitHub
+	fakeGit := git.NewAlwaysSucceedsFakeGit()
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2", "org/repo3")
+
+	out, err := runCommandWithContext(ctx)
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Interrupted, so not creating PRs for the remaining 2 repos")
+	assert.Contains(t, out, "turbolift create-prs was interrupted")
+	assert.Contains(t, out, "1 OK, 0 skipped, 0 errored")
+	assert.Contains(t, out, "To carry on from where it stopped, run create-prs --resume again with the same options")
+
+	state, _ := campaign.ReadState()
+	assert.Len(t, state.Checkpoints, 1)
+	assert.Equal(t, "create-prs", state.Checkpoints[0].Command)
+	assert.Equal(t, []string{"org/repo1"}, state.Checkpoints[0].Completed)
+
+	fakeGitHub = github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+
+	out, err = runCommand("--resume")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Resuming the last run, so leaving alone the 1 repos it had finished with")
+	assert.Contains(t, out, "turbolift create-prs completed")
+	assert.Contains(t, out, "2 OK, 0 skipped")
+
+	fakeGitHub.AssertCalledWith(t, [][]string{
+		{"create_pull_request", "work/org/repo2", "PR title"},
+		{"create_pull_request", "work/org/repo3", "PR title"},
+	})
+
+	state, _ = campaign.ReadState()
+	assert.Empty(t, state.Checkpoints)
+}
+
+func TestItRejectsResumeWithoutCheckpoint(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+	fakeGit := git.NewAlwaysSucceedsFakeGit()
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runCommand("--resume")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "there is no interrupted run of create-prs on branch")
+
+	fakeGitHub.AssertCalledWith(t, [][]string{})
+}
+
 func TestItLogsCreateDraftPr(t *testing.T) {
 	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
 	gh = fakeGitHub
@@ -220,11 +332,665 @@ func TestItCreatesPrsFromAlternativeDescriptionFile(t *testing.T) {
 	})
 }
 
-func runCommand() (string, error) {
+func TestItRunsCreatePrHooks(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+	fakeGit := git.NewAlwaysSucceedsFakeGit()
+	g = fakeGit
+	fakeHooks := hooks.NewAlwaysSucceedsFakeHooks()
+	hk = fakeHooks
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+	testsupport.CreateConfigFile("hooks:\n  pre-create-pr: make lint\n  post-create-pr: echo done\n")
+
+	out, err := runCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Running post-create-pr hook for org/repo2")
+	assert.Contains(t, out, "2 OK, 0 skipped")
+
+	fakeHooks.AssertCalledWith(t, [][]string{
+		{"pre-create-pr", "work/org/repo1", "org/repo1"},
+		{"post-create-pr", "work/org/repo1", "org/repo1"},
+		{"pre-create-pr", "work/org/repo2", "org/repo2"},
+		{"post-create-pr", "work/org/repo2", "org/repo2"},
+	})
+}
+
+func TestItWarnsAboutConflictingCampaignPRs(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub().WithOpenPRs(map[string][]github.OpenPR{
+		"org/repo1": {
+			{Url: "https://github.com/org/repo1/pull/7", Body: github.WithCampaignMarker("body", "other-campaign"), Files: []github.PrFile{{Path: "Dockerfile"}}},
+			{Url: "https://github.com/org/repo1/pull/8", Body: "a PR raised by hand", Files: []github.PrFile{{Path: "Dockerfile"}}},
+		},
+		"org/repo2": {
+			{Url: "https://github.com/org/repo2/pull/9", Body: github.WithCampaignMarker("body", "other-campaign"), Files: []github.PrFile{{Path: "README.md"}}},
+		},
+	})
+	gh = fakeGitHub
+	fakeGit := git.NewAlwaysSucceedsFakeGit().WithChangedFiles("Dockerfile")
+	g = fakeGit
+	openRegistry = func(campaign.RegistryConfig) (registry.Registry, error) {
+		return registry.NewFakeRegistry(
+			registry.Entry{Name: "other-campaign", Repos: []string{"org/repo1", "org/repo2"}},
+			registry.Entry{Name: "unrelated-campaign", Repos: []string{"org/repo3"}},
+		), nil
+	}
+	defer func() {
+		openRegistry = registry.NewRegistry
+	}()
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+
+	out, err := runCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "org/repo1 already has an open campaign PR touching Dockerfile from campaign other-campaign (https://github.com/org/repo1/pull/7)")
+	assert.NotContains(t, out, "pull/8")
+	assert.NotContains(t, out, "pull/9")
+	assert.Contains(t, out, "1 repos have open PRs from other campaigns touching the same files")
+	assert.Contains(t, out, "2 OK, 0 skipped")
+
+	fakeGitHub.AssertCalledWith(t, [][]string{
+		{"list_open_prs", "work/org/repo1", "org/repo1"},
+		{"create_pull_request", "work/org/repo1", "PR title"},
+		{"list_open_prs", "work/org/repo2", "org/repo2"},
+		{"create_pull_request", "work/org/repo2", "PR title"},
+	})
+}
+
+func TestItLabelsPrsByChangeCategory(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+	fakeGit := git.NewAlwaysSucceedsFakeGit().WithChangedFiles(".github/workflows/ci.yml", "Dockerfile")
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+	testsupport.CreateConfigFile(`
+labels:
+  ci: [".github/workflows/**"]
+  dependencies: [go.mod]
+  docker: [Dockerfile]
+`)
+
+	out, err := runCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Labelling PR with ci, docker")
+	assert.Contains(t, out, "1 OK, 0 skipped")
+
+	fakeGitHub.AssertCalledWith(t, [][]string{
+		{"create_pull_request", "work/org/repo1", "PR title", "ci", "docker"},
+	})
+}
+
+func TestItPushesTheBranchFromTheConfigFile(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+	fakeGit := git.NewAlwaysSucceedsFakeGit()
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+	testsupport.CreateConfigFile("branch: my-campaign-v2\n")
+
+	_, err := runCommand()
+	assert.NoError(t, err)
+
+	fakeGit.AssertCalledWith(t, [][]string{
+		{"isAheadOfDefaultBranch", "work/org/repo1"},
+		{"push", "work/org/repo1", "my-campaign-v2"},
+	})
+}
+
+func TestItRaisesPrsAgainstTheBaseBranchOfEachRepo(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+	fakeGit := git.NewAlwaysSucceedsFakeGit()
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+	_ = os.WriteFile("repos.json", []byte(`[{"repo": "org/repo1", "default-branch": "release"}, {"repo": "org/repo2"}]`), 0o644)
+
+	out, err := runCommand("--repos", "repos.json", "--base-branch", "develop")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "2 OK, 0 skipped")
+
+	fakeGit.AssertCalledWith(t, [][]string{
+		{"isAheadOfDefaultBranch", "work/org/repo1", "release"},
+		{"push", "work/org/repo1", testsupport.Pwd()},
+		{"isAheadOfDefaultBranch", "work/org/repo2", "develop"},
+		{"push", "work/org/repo2", testsupport.Pwd()},
+	})
+	fakeGitHub.AssertCalledWith(t, [][]string{
+		{"create_pull_request", "work/org/repo1", "PR title", "--base", "release"},
+		{"create_pull_request", "work/org/repo2", "PR title", "--base", "develop"},
+	})
+}
+
+func TestItDoesNotPushCampaignBranchesThatAreTheBaseBranch(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+	fakeGit := git.NewAlwaysSucceedsFakeGit()
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runCommand("--base-branch", testsupport.Pwd())
+	assert.NoError(t, err)
+	assert.Contains(t, out, "the campaign branch "+testsupport.Pwd()+" is the base branch of org/repo1, so PRs cannot be raised from it")
+	assert.Contains(t, out, "1 errored")
+
+	fakeGit.AssertCalledWith(t, [][]string{})
+	fakeGitHub.AssertCalledWith(t, [][]string{})
+}
+
+func TestItSummarisesChangedFilesInPrDescriptions(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+	fakeGit := git.NewAlwaysSucceedsFakeGit().WithChangedFiles("go.mod", "go.sum")
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runCommand("--diff-summary", "files")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Summarising changes in org/repo1")
+	assert.Contains(t, out, "1 OK, 0 skipped")
+
+	fakeGit.AssertCalledWith(t, [][]string{
+		{"isAheadOfDefaultBranch", "work/org/repo1"},
+		{"push", "work/org/repo1", testsupport.Pwd()},
+		{"changedFiles", "work/org/repo1"},
+	})
+}
+
+func TestItRejectsUnknownDiffSummaries(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runCommand("--diff-summary", "everything")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Unknown diff summary everything: must be one of files, diff")
+
+	fakeGitHub.AssertCalledWith(t, [][]string{})
+}
+
+func TestItEnablesAutoMergeOnCreatedPrs(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+	g = git.NewAlwaysSucceedsFakeGit()
+
+	tempDir := testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runCommand("--auto-merge")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Enabling auto-merge (squash) for PR in org/repo1")
+	assert.Contains(t, out, "1 OK, 0 skipped")
+
+	fakeGitHub.AssertCalledWith(t, [][]string{
+		{"create_pull_request", "work/org/repo1", "PR title"},
+		{"enable_auto_merge", "work/org/repo1", filepath.Base(tempDir), "squash"},
+	})
+}
+
+func TestItSquashesRatherThanMergesPrsInReposRequiringLinearHistory(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub().WithLinearHistory("work/org/repo2")
+	gh = fakeGitHub
+	g = git.NewAlwaysSucceedsFakeGit()
+
+	tempDir := testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+
+	out, err := runCommand("--auto-merge=merge")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "org/repo2 requires linear history, so the PR will be merged with squash rather than merge")
+	assert.NotContains(t, out, "org/repo1 requires linear history")
+	assert.Contains(t, out, "2 OK, 0 skipped")
+
+	fakeGitHub.AssertCalledWith(t, [][]string{
+		{"create_pull_request", "work/org/repo1", "PR title"},
+		{"enable_auto_merge", "work/org/repo1", filepath.Base(tempDir), "merge"},
+		{"create_pull_request", "work/org/repo2", "PR title"},
+		{"enable_auto_merge", "work/org/repo2", filepath.Base(tempDir), "squash"},
+	})
+}
+
+func TestItSkipsReposWhosePrAlreadyExists(t *testing.T) {
+	fakeGitHub := prAlreadyExistsFakeGitHub()
+	gh = fakeGitHub
+	g = git.NewAlwaysSucceedsFakeGit()
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+
+	out, err := runCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "a PR already exists for the branch in org/repo1: https://github.com/org/repo1/pull/3 - use --update-existing to update its title and description")
+	assert.Contains(t, out, "1 OK, 1 skipped")
+
+	fakeGitHub.AssertCalledWith(t, [][]string{
+		{"create_pull_request", "work/org/repo1", "PR title"},
+		{"create_pull_request", "work/org/repo2", "PR title"},
+	})
+
+	state, err := campaign.ReadState()
+	assert.NoError(t, err)
+	assert.Empty(t, state.Checkpoints, "the PR exists, so there is nothing to resume")
+}
+
+func TestItUpdatesPrsThatAlreadyExist(t *testing.T) {
+	fakeGitHub := prAlreadyExistsFakeGitHub()
+	gh = fakeGitHub
+	g = git.NewAlwaysSucceedsFakeGit()
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+
+	out, err := runCommand("--update-existing")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "a PR already exists for the branch in org/repo1: https://github.com/org/repo1/pull/3, so updating its title and description")
+	assert.Contains(t, out, "2 OK, 0 skipped")
+
+	fakeGitHub.AssertCalledWith(t, [][]string{
+		{"create_pull_request", "work/org/repo1", "PR title"},
+		{"update_pr_description", "work/org/repo1", "PR title", "PR body\n\n<!-- turbolift:campaign=" + testsupport.Pwd() + " -->"},
+		{"create_pull_request", "work/org/repo2", "PR title"},
+	})
+}
+
+func TestItKeepsTickedChecklistItemsWhenUpdatingPrsThatAlreadyExist(t *testing.T) {
+	previousBody := github.WithChecklist("PR body", "### Reviewer checklist", []string{"Deployed to staging", "Dashboards checked"}, "")
+	previousBody = strings.Replace(previousBody, "- [ ] Deployed", "- [x] Deployed", 1)
+	fakeGitHub := github.NewFakeGitHub(func(command github.Command, args []string) (bool, error) {
+		if command == github.CreatePullRequest {
+			return false, &github.PrExistsError{Repo: "org/repo1", Url: "https://github.com/org/repo1/pull/3"}
+		}
+		return true, nil
+	}, func(workingDir string) (interface{}, error) {
+		return &github.PrStatus{Body: previousBody}, nil
+	})
+	gh = fakeGitHub
+	g = git.NewAlwaysSucceedsFakeGit()
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+	testsupport.CreateConfigFile("checklist:\n  - Deployed to staging\n  - Dashboards checked\n")
+
+	out, err := runCommand("--update-existing")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "1 OK, 0 skipped")
+
+	fakeGitHub.AssertCalledWith(t, [][]string{
+		{"create_pull_request", "work/org/repo1", "PR title"},
+		{"get_pr", "work/org/repo1"},
+		{"update_pr_description", "work/org/repo1", "PR title", github.WithCampaignMarker("PR body\n\n<!-- turbolift:checklist -->\n### Reviewer checklist\n\n- [x] Deployed to staging\n- [ ] Dashboards checked\n<!-- turbolift:checklist-end -->", testsupport.Pwd())},
+	})
+}
+
+func TestItRecordsCreatedAndExistingPrsInTheCampaignState(t *testing.T) {
+	gh = prAlreadyExistsFakeGitHub()
+	g = git.NewAlwaysSucceedsFakeGit()
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+
+	_, err := runCommand()
+	assert.NoError(t, err)
+
+	state, err := campaign.ReadState()
+	assert.NoError(t, err)
+	prs := state.TrackedPRs(testsupport.Pwd())
+	assert.Len(t, prs, 2)
+	assert.Equal(t, "org/repo1", prs[0].Repo)
+	assert.Equal(t, 3, prs[0].Number)
+	assert.Equal(t, "https://github.com/org/repo1/pull/3", prs[0].Url)
+	assert.Equal(t, "org/repo2", prs[1].Repo)
+	assert.Equal(t, 1, prs[1].Number)
+	assert.Equal(t, "https://github.com/org/repo2/pull/1", prs[1].Url)
+}
+
+func prAlreadyExistsFakeGitHub() *github.FakeGitHub {
+	return github.NewFakeGitHub(func(command github.Command, args []string) (bool, error) {
+		if command == github.CreatePullRequest && args[1] == "work/org/repo1" {
+			return false, &github.PrExistsError{Repo: "org/repo1", Url: "https://github.com/org/repo1/pull/3"}
+		}
+		return true, nil
+	}, func(workingDir string) (interface{}, error) {
+		return &github.PrStatus{}, nil
+	})
+}
+
+func TestItWarnsIfAutoMergeCannotBeEnabled(t *testing.T) {
+	fakeGitHub := github.NewFakeGitHub(func(command github.Command, args []string) (bool, error) {
+		if command == github.EnableAutoMerge {
+			return false, errors.New("auto-merge is not allowed for this repository")
+		}
+		return true, nil
+	}, func(workingDir string) (interface{}, error) {
+		return &github.PrStatus{}, nil
+	})
+	gh = fakeGitHub
+	g = git.NewAlwaysSucceedsFakeGit()
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runCommand("--auto-merge=rebase")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Unable to enable auto-merge: auto-merge is not allowed for this repository")
+	assert.Contains(t, out, "1 OK, 0 skipped")
+}
+
+func TestItSkipsReposThatHaveBeenDeleted(t *testing.T) {
+	fakeGitHub := github.NewFakeGitHub(func(command github.Command, args []string) (bool, error) {
+		if command == github.CreatePullRequest && args[1] == "work/org/repo2" {
+			return false, &github.RepoGoneError{Repo: "org/repo2"}
+		}
+		return true, nil
+	}, func(workingDir string) (interface{}, error) {
+		return nil, nil
+	})
+	gh = fakeGitHub
+	g = git.NewAlwaysSucceedsFakeGit()
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+
+	out, err := runCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "org/repo2 has been deleted or is no longer accessible")
+	assert.Contains(t, out, "1 OK, 1 skipped")
+	assert.NotContains(t, out, "with errors")
+}
+
+func TestItSkipsReposWithNoCommittedChanges(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+	fakeGit := git.NewFakeGit(func(output io.Writer, call []string) (bool, error) {
+		if call[0] == "isAheadOfDefaultBranch" {
+			return call[1] == "work/org/repo1", nil
+		}
+		return true, nil
+	})
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+
+	out, err := runCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "No changes committed - skipping push and PR")
+	assert.Contains(t, out, "1 OK, 1 skipped")
+
+	fakeGit.AssertCalledWith(t, [][]string{
+		{"isAheadOfDefaultBranch", "work/org/repo1"},
+		{"push", "work/org/repo1", testsupport.Pwd()},
+		{"isAheadOfDefaultBranch", "work/org/repo2"},
+	})
+	fakeGitHub.AssertCalledWith(t, [][]string{
+		{"create_pull_request", "work/org/repo1", "PR title"},
+	})
+}
+
+func TestItPushesAnywayIfCommittedChangesCannotBeChecked(t *testing.T) {
+	gh = github.NewAlwaysSucceedsFakeGitHub()
+	g = git.NewFakeGit(func(output io.Writer, call []string) (bool, error) {
+		if call[0] == "isAheadOfDefaultBranch" {
+			return false, errors.New("unknown revision origin/HEAD")
+		}
+		return true, nil
+	})
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Unable to check for committed changes, so pushing anyway: unknown revision origin/HEAD")
+	assert.Contains(t, out, "1 OK, 0 skipped")
+}
+
+func TestItGroupsPushesRejectedByHooksWithRemediationHints(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+	g = git.NewFakeGit(func(output io.Writer, call []string) (bool, error) {
+		if call[0] != "push" {
+			return true, nil
+		}
+		switch call[1] {
+		case "work/org/repo1", "work/org/repo3":
+			return false, &git.PushRejectedError{Messages: []string{"Commit message must reference a JIRA issue key"}, Err: errors.New("exit status 1")}
+		case "work/org/repo2":
+			return false, &git.PushRejectedError{Messages: []string{"Unsigned commits are not allowed"}, Err: errors.New("exit status 1")}
+		}
+		return true, nil
+	})
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2", "org/repo3", "org/repo4")
+
+	out, err := runCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "push rejected by the remote: Commit message must reference a JIRA issue key")
+	assert.Contains(t, out, "Rejected because: commit message format")
+	assert.Contains(t, out, "1 OK, 0 skipped, 3 errored")
+	assert.Contains(t, out, "Pushes to 3 repos were rejected by the remote:")
+	assert.Contains(t, out, "  commit message format (2 repos): org/repo1, org/repo3")
+	assert.Contains(t, out, "  signature required (1 repos): org/repo2")
+	assert.Contains(t, out, "    Fix: sign commits with commit.gpg-sign in turbolift.yaml")
+
+	fakeGitHub.AssertCalledWith(t, [][]string{
+		{"create_pull_request", "work/org/repo4", "PR title"},
+	})
+}
+
+func TestItPushesAgainWhenAHookFailsTemporarily(t *testing.T) {
+	gh = github.NewAlwaysSucceedsFakeGitHub()
+	operations.PushRetryDelay = 0
+	pushes := 0
+	fakeGit := git.NewFakeGit(func(output io.Writer, call []string) (bool, error) {
+		if call[0] == "push" {
+			pushes++
+			if pushes == 1 {
+				return false, &git.PushRejectedError{Messages: []string{"pre-receive hook timed out"}, Err: errors.New("exit status 1")}
+			}
+		}
+		return true, nil
+	})
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Push rejected (hook failed), so trying again")
+	assert.Contains(t, out, "1 OK, 0 skipped")
+
+	fakeGit.AssertCalledWith(t, [][]string{
+		{"isAheadOfDefaultBranch", "work/org/repo1"},
+		{"push", "work/org/repo1", testsupport.Pwd()},
+		{"push", "work/org/repo1", testsupport.Pwd()},
+	})
+}
+
+func TestItForcePushesAmendedBranchesWithALease(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+	fakeGit := git.NewAlwaysSucceedsFakeGit()
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runCommand("--force-with-lease")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "1 OK, 0 skipped")
+
+	fakeGit.AssertCalledWith(t, [][]string{
+		{"isAheadOfDefaultBranch", "work/org/repo1"},
+		{"forcePush", "work/org/repo1", testsupport.Pwd()},
+	})
+}
+
+func TestItStopsBeforePushingIfPreflightChecksFindProblems(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+	fakeGit := git.NewFakeGit(func(output io.Writer, call []string) (bool, error) {
+		switch {
+		case call[0] == "hasRemote":
+			return false, nil
+		case call[0] == "canPush" && call[1] == "work/org/repo2":
+			return false, errors.New("remote: Permission to org/repo2.git denied to octocat")
+		}
+		return true, nil
+	})
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+
+	out, err := runCommand("--preflight")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "unable to push to org/repo2")
+	assert.Contains(t, out, "PRs cannot be raised for 1 repos (org/repo2), so nothing has been pushed")
+
+	fakeGit.AssertCalledWith(t, [][]string{
+		{"isOnBranch", "work/org/repo1", testsupport.Pwd()},
+		{"isAheadOfDefaultBranch", "work/org/repo1"},
+		{"hasRemote", "work/org/repo1", "upstream"},
+		{"canPush", "work/org/repo1", "origin", testsupport.Pwd()},
+		{"isOnBranch", "work/org/repo2", testsupport.Pwd()},
+		{"isAheadOfDefaultBranch", "work/org/repo2"},
+		{"hasRemote", "work/org/repo2", "upstream"},
+		{"canPush", "work/org/repo2", "origin", testsupport.Pwd()},
+	})
+	fakeGitHub.AssertCalledWith(t, [][]string{})
+}
+
+func TestItCreatesPrsOncePreflightChecksPass(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+	g = git.NewFakeGit(func(output io.Writer, call []string) (bool, error) {
+		return call[0] != "hasRemote", nil
+	})
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runCommand("--preflight")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Preflight checks found no problems in 1 repos")
+	assert.Contains(t, out, "1 OK, 0 skipped")
+
+	fakeGitHub.AssertCalledWith(t, [][]string{
+		{"create_pull_request", "work/org/repo1", "PR title"},
+	})
+}
+
+func TestItPreviewsDiffsAndLeavesOutUnconfirmedRepos(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+	g = git.NewAlwaysSucceedsFakeGit().WithDiffStats(map[string]git.DiffStat{
+		"work/org/repo1": {FilesChanged: 2, Insertions: 10, Deletions: 4},
+		"work/org/repo3": {FilesChanged: 40, Insertions: 900, Deletions: 200},
+	})
+	fakePrompt := prompt.NewFakePromptNo()
+	p = fakePrompt
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2", "org/repo3")
+
+	out, err := runCommand("--preview")
+	assert.NoError(t, err)
+	assert.Regexp(t, `org/repo1\s+2\s+10\s+4\s*\n`, out)
+	assert.Regexp(t, `org/repo2\s+0\s+0\s+0\s+empty diff`, out)
+	assert.Regexp(t, `org/repo3\s+40\s+900\s+200\s+over 1000 lines changed`, out)
+	assert.Contains(t, out, "2 of 3 repos have an empty or suspiciously large diff")
+	assert.Contains(t, out, "Leaving out org/repo2, as its diff was not confirmed")
+	assert.Contains(t, out, "Leaving out org/repo3, as its diff was not confirmed")
+	assert.Contains(t, out, "1 OK, 2 skipped")
+	fakePrompt.AssertCalledWith(t, "Include org/repo3 in the PRs (over 1000 lines changed)?")
+
+	fakeGitHub.AssertCalledWith(t, [][]string{
+		{"create_pull_request", "work/org/repo1", "PR title"},
+	})
+}
+
+func TestItIncludesConfirmedReposAfterPreviewingDiffs(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+	g = git.NewAlwaysSucceedsFakeGit()
+	p = prompt.NewFakePromptYes()
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runCommand("--preview")
+	assert.NoError(t, err)
+	assert.Regexp(t, `org/repo1\s+0\s+0\s+0\s+empty diff`, out)
+	assert.Contains(t, out, "1 OK, 0 skipped")
+}
+
+func TestItCountsReposWhosePrBodyCannotBeProcessedAsErrored(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+	g = git.NewAlwaysSucceedsFakeGit()
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+	testsupport.CreateOrUpdatePrDescriptionFile("README.md", "PR title", "Body\n\n{{ include \"snippets/footer.md\" }}")
+	testsupport.CreateConfigFile("pr-body:\n  processors: [include, template]\n")
+
+	out, err := runCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "pr-body include processor failed: unable to include snippets/footer.md")
+	assert.Contains(t, out, "0 OK, 0 skipped, 1 errored")
+	fakeGitHub.AssertCalledWith(t, [][]string{})
+}
+
+func TestItRejectsUnknownMergeStrategies(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runCommand("--auto-merge=fast-forward")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Unknown merge strategy fast-forward: must be one of merge, squash, rebase")
+
+	fakeGitHub.AssertCalledWith(t, [][]string{})
+}
+
+func TestItRendersPrDescriptionsWithRepoMetadata(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+	g = git.NewAlwaysSucceedsFakeGit()
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+	_ = os.WriteFile("repos.csv", []byte("repo,team\norg/repo1,payments\norg/repo2,search\n"), 0o644)
+	testsupport.CreateConfigFile("templating: true\n")
+	testsupport.CreateOrUpdatePrDescriptionFile("README.md", "Upgrade {{ .RepoName }} for {{ .Vars.team }}", "PR body")
+
+	out, err := runCommand("--repos", "repos.csv")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "2 OK, 0 skipped")
+
+	fakeGitHub.AssertCalledWith(t, [][]string{
+		{"create_pull_request", "work/org/repo1", "Upgrade repo1 for payments"},
+		{"create_pull_request", "work/org/repo2", "Upgrade repo2 for search"},
+	})
+}
+
+func TestItLeavesPrDescriptionsAsWrittenUnlessTemplatingIsOn(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+	g = git.NewAlwaysSucceedsFakeGit()
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+	testsupport.CreateOrUpdatePrDescriptionFile("README.md", "Pass ${{ secrets.TOKEN }} to the deploy step", "PR body")
+
+	out, err := runCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "1 OK, 0 skipped")
+
+	fakeGitHub.AssertCalledWith(t, [][]string{
+		{"create_pull_request", "work/org/repo1", "Pass ${{ secrets.TOKEN }} to the deploy step"},
+	})
+}
+
+func runCommand(args ...string) (string, error) {
+	return runCommandWithContext(context.Background(), args...)
+}
+
+func runCommandWithContext(ctx context.Context, args ...string) (string, error) {
 	cmd := NewCreatePRsCmd()
 	outBuffer := bytes.NewBufferString("")
 	cmd.SetOut(outBuffer)
-	err := cmd.Execute()
+	cmd.SetArgs(args)
+	err := cmd.ExecuteContext(ctx)
 	return outBuffer.String(), err
 }
 
@@ -0,0 +1,69 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package diff
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/git"
+	"github.com/skyscanner/turbolift/internal/logging"
+	"github.com/skyscanner/turbolift/internal/preview"
+)
+
+var g git.Git = git.NewRealGit()
+
+var (
+	repoFile       string
+	largeDiffLines int
+)
+
+func NewDiffCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "diff",
+		Short: "Show the size of the changes committed in each repo",
+		Long: `Show the size of the changes committed in each repo.
+
+For each working copy, this shows how many files, insertions and deletions the campaign branch has
+compared to the default branch, flagging repos whose diff is empty or suspiciously large. Run it
+before create-prs to check what the PRs will contain.`,
+		Run: run,
+	}
+
+	cmd.Flags().StringVar(&repoFile, "repos", "repos.txt", "A file containing a list of repositories to check.")
+	cmd.Flags().IntVar(&largeDiffLines, "large-diff", preview.DefaultLargeDiffLines, "Flag diffs that insert or delete more than this many lines (0 to turn off)")
+
+	return cmd
+}
+
+func run(c *cobra.Command, _ []string) {
+	ctx := c.Context()
+	logger := logging.NewLogger(c)
+
+	readCampaignActivity := logger.StartActivity("Reading campaign data (%s)", repoFile)
+	options := campaign.NewCampaignOptions()
+	options.RepoFilename = repoFile
+	dir, err := campaign.OpenCampaign(options)
+	if err != nil {
+		readCampaignActivity.EndWithFailure(err)
+		return
+	}
+	readCampaignActivity.EndWithSuccess()
+
+	diffs := preview.Collect(ctx, g, logger, dir.Repos)
+	logger.Println()
+	preview.Print(logger, diffs, largeDiffLines)
+}
@@ -0,0 +1,66 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package diff
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/git"
+	"github.com/skyscanner/turbolift/internal/testsupport"
+)
+
+func init() {
+	// disable output colouring so that strings we want to do 'Contains' checks on do not have ANSI escape sequences in IDEs
+	_ = os.Setenv("NO_COLOR", "1")
+}
+
+func TestItShowsTheSizeOfEachRepoDiff(t *testing.T) {
+	fakeGit := git.NewAlwaysSucceedsFakeGit().WithDiffStats(map[string]git.DiffStat{
+		"work/org/repo1": {FilesChanged: 1, Insertions: 3, Deletions: 1},
+		"work/org/repo3": {FilesChanged: 5, Insertions: 40, Deletions: 30},
+	})
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2", "org/repo3")
+	testsupport.CreateAnotherRepoFile("repos.txt", "org/repo1", "org/repo2", "org/repo3", "org/uncloned")
+
+	out, err := runCommand("--large-diff", "50")
+	assert.NoError(t, err)
+	assert.Regexp(t, `org/repo1\s+1\s+3\s+1\s*\n`, out)
+	assert.Regexp(t, `org/repo2\s+0\s+0\s+0\s+empty diff`, out)
+	assert.Regexp(t, `org/repo3\s+5\s+40\s+30\s+over 50 lines changed`, out)
+	assert.NotContains(t, out, "org/uncloned")
+	assert.Contains(t, out, "2 of 3 repos have an empty or suspiciously large diff")
+
+	fakeGit.AssertCalledWith(t, [][]string{
+		{"diffStat", "work/org/repo1"},
+		{"diffStat", "work/org/repo2"},
+		{"diffStat", "work/org/repo3"},
+	})
+}
+
+func runCommand(args ...string) (string, error) {
+	cmd := NewDiffCmd()
+	outBuffer := bytes.NewBufferString("")
+	cmd.SetOut(outBuffer)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return outBuffer.String(), err
+}
@@ -0,0 +1,316 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package doctor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/skyscanner/turbolift/cmd/flags"
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/colors"
+	"github.com/skyscanner/turbolift/internal/executor"
+	"github.com/skyscanner/turbolift/internal/github"
+	"github.com/skyscanner/turbolift/internal/logging"
+	"github.com/skyscanner/turbolift/internal/tools"
+)
+
+var (
+	exec          executor.Executor = executor.NewRealExecutor()
+	freeDiskSpace                   = availableBytes
+	installTool                     = func(ctx context.Context, output io.Writer, tool tools.Tool) (string, error) {
+		return tool.Install(ctx, output)
+	}
+)
+
+const (
+	// warn if less than this much space is free for cloning into the work directory
+	minFreeDiskSpace = 1 << 30
+)
+
+var (
+	minGitVersion    = []int{2, 0, 0}
+	gitVersionRegexp = regexp.MustCompile(`git version (\d+)\.(\d+)(?:\.(\d+))?`)
+)
+
+var (
+	repoFile       string
+	installMissing bool
+)
+
+type checkResults struct {
+	passed   int
+	warnings int
+	failures int
+}
+
+func NewDoctorCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Check that the environment and campaign are ready for turbolift",
+		Long: `Check that the environment and campaign are ready for turbolift.
+
+This checks that gh is installed and authenticated for every host the campaign uses, that git is
+recent enough, that SSH access works where gh is configured to use it, that there is enough disk
+space for the work directory, and that the campaign files can be read. Each problem found comes with
+a suggestion for fixing it, including how to install any tools that are missing. With --install-missing,
+a pinned copy of gh is downloaded for turbolift to use if it is not installed.`,
+		Run: run,
+	}
+
+	cmd.Flags().StringVar(&repoFile, "repos", "repos.txt", "A file containing a list of repositories to clone.")
+	cmd.Flags().BoolVar(&installMissing, "install-missing", false, "Download a pinned, checksum-verified copy of gh into turbolift's tools directory if it is not installed")
+
+	return cmd
+}
+
+func run(c *cobra.Command, _ []string) {
+	ctx := c.Context()
+	logger := logging.NewLogger(c)
+	results := &checkResults{}
+
+	if installMissing {
+		if err := flags.CheckReadOnly("doctor --install-missing"); err != nil {
+			logger.Errorf("%s", err)
+			return
+		}
+	}
+
+	hosts := []string{campaign.DefaultHost}
+	dir := checkCampaign(logger, results)
+	if dir != nil {
+		hosts = dir.Hosts()
+	}
+
+	checkGhInstalled(ctx, logger, results)
+	checkCredentials(logger, results)
+	for _, host := range hosts {
+		checkGhAuthenticated(ctx, logger, results, host)
+		checkSshAccess(ctx, logger, results, host)
+	}
+	checkGitVersion(ctx, logger, results)
+	checkDiskSpace(logger, results)
+
+	if results.failures == 0 && results.warnings == 0 {
+		logger.Successf("turbolift doctor completed %s(%s)\n", colors.Normal(), colors.Green(results.passed, " checks passed"))
+	} else {
+		logger.Warnf("turbolift doctor found problems %s(%s, %s, %s)\n", colors.Normal(), colors.Green(results.passed, " checks passed"), colors.Yellow(results.warnings, " warnings"), colors.Red(results.failures, " failed"))
+	}
+}
+
+func (r *checkResults) pass(activity *logging.Activity) {
+	activity.EndWithSuccess()
+	r.passed++
+}
+
+func (r *checkResults) warn(activity *logging.Activity, problem string, fix string) {
+	activity.EndWithWarningf("%s\n  Fix: %s", problem, fix)
+	r.warnings++
+}
+
+func (r *checkResults) fail(activity *logging.Activity, problem string, fix string) {
+	activity.EndWithFailuref("%s\n  Fix: %s", problem, fix)
+	r.failures++
+}
+
+// checkCampaign reads the campaign in the current directory, if there is one, and checks that its files are consistent
+func checkCampaign(logger *logging.Logger, results *checkResults) *campaign.Campaign {
+	activity := logger.StartActivity("Checking campaign files")
+
+	if _, err := os.Stat(".turbolift"); os.IsNotExist(err) {
+		activity.EndWithWarningf("Not in a campaign directory, so only checking the environment")
+		return nil
+	}
+
+	options := campaign.NewCampaignOptions()
+	options.RepoFilename = repoFile
+	dir, err := campaign.OpenCampaign(options)
+	if err != nil {
+		results.fail(activity, err.Error(), fmt.Sprintf("make sure %s, README.md and turbolift.yaml (if present) are valid", repoFile))
+		return nil
+	}
+
+	var problems []string
+	if len(dir.Repos) == 0 {
+		problems = append(problems, fmt.Sprintf("%s does not list any repos", repoFile))
+	}
+	if dir.PrTitle == "" || strings.HasPrefix(dir.PrTitle, "TODO") {
+		problems = append(problems, "the PR title in README.md has not been written yet")
+	}
+	if orphans := orphanedWorkingCopies(dir); len(orphans) > 0 {
+		problems = append(problems, fmt.Sprintf("the work directory contains repos not listed in %s: %s", repoFile, strings.Join(orphans, ", ")))
+	}
+
+	if len(problems) > 0 {
+		results.warn(activity, strings.Join(problems, "; "), fmt.Sprintf("update %s and README.md, or remove working copies that are no longer needed", repoFile))
+	} else {
+		results.pass(activity)
+	}
+	return dir
+}
+
+// orphanedWorkingCopies lists the working copies in the work directory that are not part of the campaign
+func orphanedWorkingCopies(dir *campaign.Campaign) []string {
+	expected := map[string]bool{}
+	for _, repo := range dir.Repos {
+		expected[repo.FullRepoPath()] = true
+	}
+
+	workingCopies, _ := filepath.Glob(filepath.Join("work", "*", "*"))
+	var orphans []string
+	for _, workingCopy := range workingCopies {
+		if !expected[filepath.ToSlash(workingCopy)] {
+			orphans = append(orphans, strings.TrimPrefix(filepath.ToSlash(workingCopy), "work/"))
+		}
+	}
+	return orphans
+}
+
+func checkGhInstalled(ctx context.Context, logger *logging.Logger, results *checkResults) {
+	activity := logger.StartActivity("Checking gh is installed")
+	if _, err := exec.ExecuteAndCapture(ctx, activity.Writer(), ".", "gh", "--version"); err != nil {
+		if !installMissing {
+			results.fail(activity, fmt.Sprintf("unable to run gh: %s", err), tools.Hint("gh"))
+			return
+		}
+		activity.Logf("unable to run gh, so downloading it: %s", err)
+		if _, err := installTool(ctx, activity.Writer(), tools.Known["gh"]); err != nil {
+			results.fail(activity, fmt.Sprintf("unable to install gh: %s", err), tools.Hint("gh"))
+			return
+		}
+		tools.AddToPath()
+		activity.EndWithSuccessAndEmitLogs()
+		results.passed++
+		return
+	}
+	results.pass(activity)
+}
+
+// checkCredentials checks that the GitHub credentials configured for each host and org can be read, if the user has
+// configured any
+func checkCredentials(logger *logging.Logger, results *checkResults) {
+	filename := github.CredentialsFile()
+	if _, err := os.Stat(filename); filename == "" || err != nil {
+		return
+	}
+
+	activity := logger.StartActivity("Checking the credentials in %s", filename)
+	if _, err := github.ReadCredentials(filename); err != nil {
+		results.fail(activity, err.Error(), "give each entry a host or org (or neither, to match any), and one of token-env, token-command or gh-user")
+		return
+	}
+	results.pass(activity)
+}
+
+func checkGhAuthenticated(ctx context.Context, logger *logging.Logger, results *checkResults, host string) {
+	activity := logger.StartActivity("Checking gh is authenticated with %s", host)
+	if _, err := exec.ExecuteAndCapture(ctx, activity.Writer(), ".", "gh", "auth", "status", "--hostname", host); err != nil {
+		results.fail(activity, fmt.Sprintf("gh is not logged in to %s", host), fmt.Sprintf("run gh auth login --hostname %s", host))
+		return
+	}
+	results.pass(activity)
+}
+
+// checkSshAccess checks that SSH authentication works for hosts where gh clones using SSH
+func checkSshAccess(ctx context.Context, logger *logging.Logger, results *checkResults, host string) {
+	protocol, err := exec.ExecuteAndCapture(ctx, &bytes.Buffer{}, ".", "gh", "config", "get", "git_protocol", "--host", host)
+	if err != nil || strings.TrimSpace(protocol) != "ssh" {
+		// cloning uses HTTPS and the gh token, which has already been checked
+		return
+	}
+
+	activity := logger.StartActivity("Checking SSH access to %s", host)
+	// ssh -T exits non-zero even when authentication succeeds, as the host does not provide a shell
+	output, _ := exec.ExecuteAndCapture(ctx, activity.Writer(), ".", "ssh", "-T", "-o", "BatchMode=yes", "git@"+host)
+	if !strings.Contains(output, "successfully authenticated") {
+		results.fail(activity, fmt.Sprintf("unable to authenticate with %s over SSH", host), fmt.Sprintf("add an SSH key to your account on %s, or run gh config set git_protocol https --host %s", host, host))
+		return
+	}
+	results.pass(activity)
+}
+
+func checkGitVersion(ctx context.Context, logger *logging.Logger, results *checkResults) {
+	activity := logger.StartActivity("Checking git version")
+	output, err := exec.ExecuteAndCapture(ctx, activity.Writer(), ".", "git", "--version")
+	if err != nil {
+		results.fail(activity, fmt.Sprintf("unable to run git: %s", err), tools.Hint("git"))
+		return
+	}
+
+	version := parseGitVersion(output)
+	if version == nil {
+		results.warn(activity, fmt.Sprintf("unable to determine the git version from %q", strings.TrimSpace(output)), "check that git --version works")
+		return
+	}
+	if compareVersions(version, minGitVersion) < 0 {
+		results.fail(activity, fmt.Sprintf("git %s is too old", formatVersion(version)), fmt.Sprintf("upgrade git to %s or later", formatVersion(minGitVersion)))
+		return
+	}
+	results.pass(activity)
+}
+
+func checkDiskSpace(logger *logging.Logger, results *checkResults) {
+	activity := logger.StartActivity("Checking free disk space")
+	free, err := freeDiskSpace(".")
+	if err != nil {
+		results.warn(activity, fmt.Sprintf("unable to determine free disk space: %s", err), "check manually that there is room to clone the campaign's repos")
+		return
+	}
+	if free < minFreeDiskSpace {
+		results.warn(activity, fmt.Sprintf("only %d MiB free for the work directory", free>>20), "free up some disk space, or move the campaign to a larger disk")
+		return
+	}
+	results.pass(activity)
+}
+
+func parseGitVersion(output string) []int {
+	match := gitVersionRegexp.FindStringSubmatch(output)
+	if match == nil {
+		return nil
+	}
+	version := []int{}
+	for _, part := range match[1:] {
+		n, _ := strconv.Atoi(part)
+		version = append(version, n)
+	}
+	return version
+}
+
+func compareVersions(a []int, b []int) int {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] - b[i]
+		}
+	}
+	return 0
+}
+
+func formatVersion(version []int) string {
+	parts := []string{}
+	for _, part := range version {
+		parts = append(parts, strconv.Itoa(part))
+	}
+	return strings.Join(parts, ".")
+}
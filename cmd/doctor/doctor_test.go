@@ -0,0 +1,245 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package doctor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/executor"
+	"github.com/skyscanner/turbolift/internal/testsupport"
+	"github.com/skyscanner/turbolift/internal/tools"
+)
+
+func init() {
+	// disable output colouring so that strings we want to do 'Contains' checks on do not have ANSI escape sequences in IDEs
+	_ = os.Setenv("NO_COLOR", "1")
+}
+
+func TestItPassesAllChecksInAHealthyCampaign(t *testing.T) {
+	fakeExecutor := healthyFakeExecutor()
+	exec = fakeExecutor
+	freeDiskSpace = func(string) (uint64, error) { return 10 << 30, nil }
+
+	testsupport.PrepareTempCampaign(false, "org/repo1", "enterprise.example.com/org/repo2")
+	createMarkerFile()
+	_ = os.MkdirAll("work/org/repo1", 0o755)
+	_ = os.MkdirAll("work/org/repo2", 0o755)
+
+	out, err := runCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "turbolift doctor completed (7 checks passed)")
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{".", "gh", "--version"},
+		{".", "gh", "auth", "status", "--hostname", "enterprise.example.com"},
+		{".", "gh", "config", "get", "git_protocol", "--host", "enterprise.example.com"},
+		{".", "gh", "auth", "status", "--hostname", "github.com"},
+		{".", "gh", "config", "get", "git_protocol", "--host", "github.com"},
+		{".", "ssh", "-T", "-o", "BatchMode=yes", "git@github.com"},
+		{".", "git", "--version"},
+	})
+}
+
+func TestItOnlyChecksTheEnvironmentOutsideACampaign(t *testing.T) {
+	fakeExecutor := healthyFakeExecutor()
+	exec = fakeExecutor
+	freeDiskSpace = func(string) (uint64, error) { return 10 << 30, nil }
+
+	testsupport.CreateAndEnterTempDirectory()
+
+	out, err := runCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Not in a campaign directory")
+	assert.Contains(t, out, "gh is authenticated with github.com")
+	assert.Contains(t, out, "turbolift doctor completed (5 checks passed)")
+}
+
+func TestItSuggestsFixesForEnvironmentProblems(t *testing.T) {
+	fakeExecutor := executor.NewFakeExecutor(nil, func(_ string, name string, args ...string) (string, error) {
+		switch {
+		case name == "gh" && args[0] == "auth":
+			return "You are not logged into any GitHub hosts", errors.New("synthetic error")
+		case name == "git":
+			return "git version 1.8.3.1\n", nil
+		}
+		return "", nil
+	})
+	exec = fakeExecutor
+	freeDiskSpace = func(string) (uint64, error) { return 100 << 20, nil }
+
+	testsupport.PrepareTempCampaign(false, "org/repo1")
+	createMarkerFile()
+
+	out, err := runCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "gh is not logged in to github.com")
+	assert.Contains(t, out, "Fix: run gh auth login --hostname github.com")
+	assert.Contains(t, out, "git 1.8.3 is too old")
+	assert.Contains(t, out, "Fix: upgrade git to 2.0.0 or later")
+	assert.Contains(t, out, "only 100 MiB free for the work directory")
+	assert.Contains(t, out, "(2 checks passed, 1 warnings, 2 failed)")
+}
+
+func TestItReportsSshAuthenticationFailures(t *testing.T) {
+	fakeExecutor := executor.NewFakeExecutor(nil, func(_ string, name string, args ...string) (string, error) {
+		switch {
+		case name == "gh" && args[0] == "config":
+			return "ssh\n", nil
+		case name == "ssh":
+			return "git@github.com: Permission denied (publickey).", errors.New("synthetic error")
+		case name == "git":
+			return "git version 2.33.0\n", nil
+		}
+		return "", nil
+	})
+	exec = fakeExecutor
+	freeDiskSpace = func(string) (uint64, error) { return 10 << 30, nil }
+
+	testsupport.CreateAndEnterTempDirectory()
+
+	out, err := runCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "unable to authenticate with github.com over SSH")
+	assert.Contains(t, out, "gh config set git_protocol https --host github.com")
+}
+
+func TestItWarnsAboutCampaignInconsistencies(t *testing.T) {
+	exec = healthyFakeExecutor()
+	freeDiskSpace = func(string) (uint64, error) { return 10 << 30, nil }
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+	createMarkerFile()
+	testsupport.UseDefaultPrDescription()
+	testsupport.CreateAnotherRepoFile("repos.txt", "org/repo1")
+
+	out, err := runCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "the PR title in README.md has not been written yet")
+	assert.Contains(t, out, "the work directory contains repos not listed in repos.txt: org/repo2")
+}
+
+func TestItFailsWhenTheCampaignCannotBeRead(t *testing.T) {
+	exec = healthyFakeExecutor()
+	freeDiskSpace = func(string) (uint64, error) { return 10 << 30, nil }
+
+	testsupport.PrepareTempCampaign(false, "org/repo1")
+	createMarkerFile()
+
+	out, err := runCommand("--repos", "missing.txt")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "unable to open repo file: missing.txt")
+	assert.Contains(t, out, "1 failed")
+}
+
+func TestItChecksTheCredentialsFile(t *testing.T) {
+	exec = healthyFakeExecutor()
+	freeDiskSpace = func(string) (uint64, error) { return 10 << 30, nil }
+
+	testsupport.CreateAndEnterTempDirectory()
+	testsupport.Setenv(t, "TURBOLIFT_CREDENTIALS", "credentials.yaml")
+	_ = os.WriteFile("credentials.yaml", []byte("credentials:\n  - org: acme\n"), 0o644)
+
+	out, err := runCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "credentials for any host/acme in credentials.yaml must have exactly one of token-env, token-command or gh-user")
+	assert.Contains(t, out, "1 failed")
+
+	_ = os.WriteFile("credentials.yaml", []byte("credentials:\n  - org: acme\n    token-env: ACME_TOKEN\n"), 0o644)
+
+	out, err = runCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "turbolift doctor completed (6 checks passed)")
+}
+
+func TestItSaysHowToInstallMissingTools(t *testing.T) {
+	exec = ghMissingFakeExecutor()
+	freeDiskSpace = func(string) (uint64, error) { return 10 << 30, nil }
+
+	testsupport.CreateAndEnterTempDirectory()
+
+	out, err := runCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "unable to run gh")
+	assert.Contains(t, out, "Fix: "+tools.Hint("gh"))
+}
+
+func TestItInstallsMissingToolsWhenAsked(t *testing.T) {
+	exec = ghMissingFakeExecutor()
+	freeDiskSpace = func(string) (uint64, error) { return 10 << 30, nil }
+	var installed []string
+	installTool = func(_ context.Context, output io.Writer, tool tools.Tool) (string, error) {
+		installed = append(installed, tool.Name)
+		_, _ = io.WriteString(output, "Installed gh into the tools directory\n")
+		return "tools/bin/gh", nil
+	}
+
+	testsupport.CreateAndEnterTempDirectory()
+
+	out, err := runCommand("--install-missing")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"gh"}, installed)
+	assert.Contains(t, out, "Installed gh into the tools directory")
+	assert.NotContains(t, out, "unable to install gh")
+}
+
+func healthyFakeExecutor() *executor.FakeExecutor {
+	return executor.NewFakeExecutor(nil, func(_ string, name string, args ...string) (string, error) {
+		switch {
+		case name == "gh" && strings.Join(args, " ") == "config get git_protocol --host github.com":
+			return "ssh\n", nil
+		case name == "ssh":
+			return "Hi user! You've successfully authenticated, but GitHub does not provide shell access.", errors.New("exit status 1")
+		case name == "git":
+			return "git version 2.33.0\n", nil
+		}
+		return "", nil
+	})
+}
+
+func ghMissingFakeExecutor() *executor.FakeExecutor {
+	return executor.NewFakeExecutor(nil, func(_ string, name string, args ...string) (string, error) {
+		switch {
+		case name == "gh" && args[0] == "--version":
+			return "", &tools.MissingError{Name: "gh"}
+		case name == "git":
+			return "git version 2.33.0\n", nil
+		}
+		return "", nil
+	})
+}
+
+func createMarkerFile() {
+	if err := os.WriteFile(".turbolift", []byte{}, 0o644); err != nil {
+		panic(err)
+	}
+}
+
+func runCommand(args ...string) (string, error) {
+	cmd := NewDoctorCmd()
+	outBuffer := bytes.NewBufferString("")
+	cmd.SetOut(outBuffer)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return outBuffer.String(), err
+}
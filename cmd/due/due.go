@@ -0,0 +1,106 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package due
+
+import (
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/rodaine/table"
+	"github.com/spf13/cobra"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/colors"
+	"github.com/skyscanner/turbolift/internal/logging"
+)
+
+var now = time.Now
+
+var (
+	searchPath string
+	showAll    bool
+)
+
+type dueFollowUp struct {
+	campaign.FollowUp
+	campaignName string
+}
+
+func NewDueCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "due",
+		Short: "List follow-ups that are due across campaigns",
+		Long: `List follow-ups scheduled with turbolift follow-up that are now due.
+
+Both the given directory and its immediate subdirectories are searched for campaigns, so running
+turbolift due from the directory that holds your campaigns lists the follow-ups of all of them.`,
+		Run: run,
+	}
+
+	cmd.Flags().StringVar(&searchPath, "path", ".", "Directory containing the campaigns to check")
+	cmd.Flags().BoolVar(&showAll, "all", false, "Also list follow-ups that are not due yet")
+
+	return cmd
+}
+
+func run(c *cobra.Command, _ []string) {
+	logger := logging.NewLogger(c)
+
+	findActivity := logger.StartActivity("Finding campaigns in %s", searchPath)
+	campaignDirs, err := campaign.FindCampaignDirs(searchPath, 1)
+	if err != nil {
+		findActivity.EndWithFailure(err)
+		return
+	}
+
+	var followUps []dueFollowUp
+	for _, campaignDir := range campaignDirs {
+		state, err := campaign.ReadStateIn(campaignDir)
+		if err != nil {
+			findActivity.Logf("Skipping %s: %s", campaignDir, err)
+			continue
+		}
+		campaignName := filepath.Base(campaignDir)
+		for _, followUp := range state.FollowUps {
+			if followUp.Done || (!showAll && followUp.Due.After(now())) {
+				continue
+			}
+			followUps = append(followUps, dueFollowUp{FollowUp: followUp, campaignName: campaignName})
+		}
+	}
+	findActivity.EndWithSuccessAndEmitLogs()
+
+	sort.SliceStable(followUps, func(i, j int) bool {
+		return followUps[i].Due.Before(followUps[j].Due)
+	})
+
+	logger.Println()
+
+	followUpsTable := table.New("Campaign", "Id", "Due", "Command", "Note")
+	followUpsTable.WithHeaderFormatter(color.New(color.Underline).SprintfFunc())
+	followUpsTable.WithFirstColumnFormatter(color.New(color.FgCyan).SprintfFunc())
+	followUpsTable.WithWriter(logger.Writer())
+
+	for _, followUp := range followUps {
+		followUpsTable.AddRow(followUp.campaignName, followUp.Id, followUp.Due.Format("2006-01-02"), followUp.Command, followUp.Note)
+	}
+	followUpsTable.Print()
+
+	logger.Println()
+	logger.Successf("turbolift due completed %s(%s follow-ups listed from %d campaigns)\n", colors.Normal(), colors.Green(len(followUps)), len(campaignDirs))
+}
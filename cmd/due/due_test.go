@@ -0,0 +1,90 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package due
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/testsupport"
+)
+
+func init() {
+	// disable output colouring so that strings we want to do 'Contains' checks on do not have ANSI escape sequences in IDEs
+	_ = os.Setenv("NO_COLOR", "1")
+}
+
+var fixedNow = time.Date(2021, 10, 1, 9, 0, 0, 0, time.UTC)
+
+func TestItListsDueFollowUpsAcrossCampaigns(t *testing.T) {
+	now = func() time.Time { return fixedNow }
+	testsupport.CreateAndEnterTempDirectory()
+
+	createCampaignWithFollowUps("campaign-a",
+		campaign.FollowUp{Command: "remove-flag-a", Note: "Remove flag", Due: fixedNow.AddDate(0, 0, -2)},
+		campaign.FollowUp{Command: "not-due-yet", Due: fixedNow.AddDate(0, 0, 5)},
+	)
+	createCampaignWithFollowUps("campaign-b",
+		campaign.FollowUp{Command: "remove-flag-b", Due: fixedNow.AddDate(0, 0, -10)},
+		campaign.FollowUp{Command: "already-done", Due: fixedNow.AddDate(0, 0, -10), Done: true},
+	)
+	_ = os.Mkdir("not-a-campaign", 0o755)
+
+	out, err := runCommand()
+	assert.NoError(t, err)
+
+	assert.Regexp(t, "campaign-b\\s+1\\s+2021-09-21\\s+remove-flag-b", out)
+	assert.Regexp(t, "campaign-a\\s+1\\s+2021-09-29\\s+remove-flag-a\\s+Remove flag", out)
+	assert.Regexp(t, "(?s)remove-flag-b.*remove-flag-a", out)
+	assert.NotContains(t, out, "not-due-yet")
+	assert.NotContains(t, out, "already-done")
+	assert.Contains(t, out, "2 follow-ups listed from 2 campaigns")
+
+	out, err = runCommand("--all")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "not-due-yet")
+	assert.Contains(t, out, "3 follow-ups listed from 2 campaigns")
+}
+
+func createCampaignWithFollowUps(name string, followUps ...campaign.FollowUp) {
+	_ = os.Mkdir(name, 0o755)
+	_ = os.Chdir(name)
+	defer func() {
+		_ = os.Chdir("..")
+	}()
+
+	state, _ := campaign.ReadState()
+	for _, followUp := range followUps {
+		state.AddFollowUp(followUp)
+	}
+	if err := state.Save(); err != nil {
+		panic(err)
+	}
+}
+
+func runCommand(args ...string) (string, error) {
+	cmd := NewDueCmd()
+	outBuffer := bytes.NewBufferString("")
+	cmd.SetOut(outBuffer)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return outBuffer.String(), err
+}
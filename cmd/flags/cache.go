@@ -0,0 +1,42 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package flags
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/skyscanner/turbolift/internal/cache"
+	"github.com/skyscanner/turbolift/internal/campaign"
+)
+
+var (
+	// NoCache makes commands look up every PR and repo afresh, rather than using results cached by an earlier run
+	NoCache bool
+	// CacheTTL is how long looked-up PR and repo metadata is cached for
+	CacheTTL time.Duration
+)
+
+// CacheDirectory is where looked-up PR and repo metadata is cached between runs, within the campaign
+var CacheDirectory = filepath.Join(campaign.StateDirectory, "cache")
+
+// Cache is the cache for commands that report on the campaign's PRs, or nil with --no-cache
+func Cache() *cache.Cache {
+	if NoCache || CacheTTL <= 0 {
+		return nil
+	}
+	return cache.New(CacheDirectory, CacheTTL)
+}
@@ -0,0 +1,60 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package flags
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// envPrefix starts the environment variables that stand in for flags
+const envPrefix = "TURBOLIFT_"
+
+// notFromEnv are the flags that cannot be set from the environment: --help and --version, and --org, as
+// TURBOLIFT_ORG is set for the commands and hooks run in each repo
+var notFromEnv = map[string]bool{
+	"help":    true,
+	"version": true,
+	"org":     true,
+}
+
+// EnvName is the environment variable for a flag, e.g. TURBOLIFT_NON_INTERACTIVE for --non-interactive
+func EnvName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// SetFromEnv sets each of the command's flags that was not given on the command line from its environment variable,
+// if that is set, so that turbolift can be configured for cron jobs and CI without changing its command lines
+func SetFromEnv(cmd *cobra.Command) error {
+	var err error
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if err != nil || f.Changed || notFromEnv[f.Name] {
+			return
+		}
+		value, ok := os.LookupEnv(EnvName(f.Name))
+		if !ok {
+			return
+		}
+		if setErr := cmd.Flags().Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("unable to set --%s from %s: %w", f.Name, EnvName(f.Name), setErr)
+		}
+	})
+	return err
+}
@@ -0,0 +1,78 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package flags
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/testsupport"
+)
+
+func TestItNamesTheEnvironmentVariablesOfFlags(t *testing.T) {
+	assert.Equal(t, "TURBOLIFT_NON_INTERACTIVE", EnvName("non-interactive"))
+	assert.Equal(t, "TURBOLIFT_YES", EnvName("yes"))
+}
+
+func TestItSetsFlagsThatWereNotGivenFromTheEnvironment(t *testing.T) {
+	var yes bool
+	var repos string
+	var sleep time.Duration
+	cmd := testCommand(&yes, &repos, &sleep)
+
+	testsupport.Setenv(t, "TURBOLIFT_YES", "true")
+	testsupport.Setenv(t, "TURBOLIFT_REPOS", "from-env.txt")
+	testsupport.Setenv(t, "TURBOLIFT_SLEEP", "5s")
+	assert.NoError(t, cmd.ParseFlags([]string{"--repos", "given.txt"}))
+
+	assert.NoError(t, SetFromEnv(cmd))
+	assert.True(t, yes)
+	assert.Equal(t, "given.txt", repos)
+	assert.Equal(t, 5*time.Second, sleep)
+}
+
+func TestItLeavesOutFlagsThatCannotBeSetFromTheEnvironment(t *testing.T) {
+	var org string
+	cmd := &cobra.Command{Use: "test"}
+	cmd.Flags().StringVar(&org, "org", "", "")
+
+	testsupport.Setenv(t, "TURBOLIFT_ORG", "org-of-the-repo-being-worked-on")
+	assert.NoError(t, SetFromEnv(cmd))
+	assert.Equal(t, "", org)
+}
+
+func TestItRejectsInvalidValuesInTheEnvironment(t *testing.T) {
+	var yes bool
+	var repos string
+	var sleep time.Duration
+	cmd := testCommand(&yes, &repos, &sleep)
+
+	testsupport.Setenv(t, "TURBOLIFT_SLEEP", "a while")
+	err := SetFromEnv(cmd)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unable to set --sleep from TURBOLIFT_SLEEP")
+}
+
+func testCommand(yes *bool, repos *string, sleep *time.Duration) *cobra.Command {
+	cmd := &cobra.Command{Use: "test"}
+	cmd.Flags().BoolVar(yes, "yes", false, "")
+	cmd.Flags().StringVar(repos, "repos", "repos.txt", "")
+	cmd.Flags().DurationVar(sleep, "sleep", 0, "")
+	return cmd
+}
@@ -15,6 +15,37 @@
 
 package flags
 
+import "time"
+
 var (
 	Verbose bool
+	// CommandTimeout limits how long each git, gh or user command may run for. Zero means no limit.
+	CommandTimeout time.Duration
+	// Heartbeat is how often a long-running activity reports that it is still going. Zero means never.
+	Heartbeat time.Duration
+	// FailFast stops a run once the repo being worked on is finished, after the first failure
+	FailFast bool
+	// Retries is how many times a git or gh command that failed because of the network or the server is run again
+	Retries int
+	// RetryDelay is how long to wait before retrying such a command for the first time. It doubles after each retry.
+	RetryDelay time.Duration
+	// ReadOnly disables every command and option that could change the campaign, its repos or their PRs, so that a
+	// shared campaign can be looked at safely. It is also turned on for users whose role is observer.
+	ReadOnly bool
+	// NotifySlack and NotifyWebhook are where the summary of the run is posted when it finishes, in place of any
+	// configured in turbolift.yaml
+	NotifySlack   string
+	NotifyWebhook string
+	// SummaryFile is where the summary of what a command did to each repo is written as JSON, if set
+	SummaryFile string
+	// Audit records every external command that is run, as if audit were turned on in turbolift.yaml
+	Audit bool
+	// Force takes over the campaign's lock, even if another command that makes changes looks to be running in it
+	Force bool
+	// NoColor turns colour off, as it is when NO_COLOR is set or stdout is not a terminal
+	NoColor bool
+	// Theme is the theme that output is styled with, in place of the one in the user's config
+	Theme string
+	// NonInteractive makes sure that nothing is ever prompted for, failing the run with the question instead
+	NonInteractive bool
 )
@@ -0,0 +1,56 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package flags
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// makesChangesAnnotation marks the commands that are disabled in read-only mode
+const makesChangesAnnotation = "turbolift/makes-changes"
+
+// ReadOnlyError is returned for commands and options that are disabled in read-only mode
+type ReadOnlyError struct {
+	Operation string
+}
+
+func (e *ReadOnlyError) Error() string {
+	return fmt.Sprintf("%s can make changes, so it is disabled in read-only mode", e.Operation)
+}
+
+// MarkMakesChanges records that a command can change the campaign, its repos or their PRs, so that it is not run in
+// read-only mode. Commands that only make changes with some options check ReadOnly themselves instead.
+func MarkMakesChanges(cmd *cobra.Command) {
+	if cmd.Annotations == nil {
+		cmd.Annotations = map[string]string{}
+	}
+	cmd.Annotations[makesChangesAnnotation] = "true"
+}
+
+// MakesChanges is true for the commands marked with MarkMakesChanges
+func MakesChanges(cmd *cobra.Command) bool {
+	return cmd.Annotations[makesChangesAnnotation] == "true"
+}
+
+// CheckReadOnly returns a ReadOnlyError for the operation if read-only mode is on
+func CheckReadOnly(operation string) error {
+	if ReadOnly {
+		return &ReadOnlyError{Operation: operation}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package flags
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+)
+
+// AddShuffleFlag adds the --shuffle[=SEED] flag, whose value is passed on as CampaignOptions.Shuffle
+func AddShuffleFlag(cmd *cobra.Command, shuffle *string) {
+	cmd.Flags().StringVar(shuffle, "shuffle", "", "Process repos in a random order, to spread load across orgs and CI systems; give a seed with --shuffle=SEED to repeat an order")
+	cmd.Flags().Lookup("shuffle").NoOptDefVal = campaign.RandomShuffle
+}
+
+// printer is what LogShuffle logs with, which is a *logging.Logger; logging uses these flags, so cannot be imported here
+type printer interface {
+	Printf(format string, args ...interface{})
+}
+
+// LogShuffle tells the user the seed of a shuffled campaign, so that they can process its repos in the same order again
+func LogShuffle(logger printer, dir *campaign.Campaign) {
+	if dir.Shuffled {
+		logger.Printf("Processing repos in shuffled order (use --shuffle=%d to repeat it)", dir.ShuffleSeed)
+	}
+}
@@ -0,0 +1,146 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package followup
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/skyscanner/turbolift/cmd/flags"
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/colors"
+	"github.com/skyscanner/turbolift/internal/logging"
+)
+
+var now = time.Now
+
+var (
+	after    string
+	command  string
+	note     string
+	doneId   int
+	repoFile string
+)
+
+func NewFollowUpCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "follow-up",
+		Short: "Schedule an action to follow up on this campaign, such as removing a feature flag it added",
+		Long: `Schedule an action to follow up on this campaign, such as removing a feature flag it added.
+
+Follow-ups are recorded in the campaign state. Use turbolift due to list the follow-ups that are due
+across campaigns, and --done to mark one as completed.`,
+		Example: `turbolift follow-up --after 30d --command "turbolift foreach -- ./remove-flag.sh" --note "Remove the new-checkout flag"
+turbolift follow-up --done 1`,
+		Run: run,
+	}
+
+	cmd.Flags().StringVar(&after, "after", "", "How long after now the follow-up is due, e.g. 30d, 2w or 12h")
+	cmd.Flags().StringVar(&command, "command", "", "The command to run when following up")
+	cmd.Flags().StringVar(&note, "note", "", "A note describing the follow-up")
+	cmd.Flags().IntVar(&doneId, "done", 0, "Mark the follow-up with this id as done")
+	cmd.Flags().StringVar(&repoFile, "repos", "repos.txt", "A file containing a list of repositories to clone.")
+	flags.MarkMakesChanges(cmd)
+
+	return cmd
+}
+
+func run(c *cobra.Command, _ []string) {
+	logger := logging.NewLogger(c)
+
+	if doneId == 0 && (after == "" || command == "") {
+		logger.Errorf("--after and --command are required, unless using --done")
+		return
+	}
+
+	readCampaignActivity := logger.StartActivity("Reading campaign data (%s)", repoFile)
+	options := campaign.NewCampaignOptions()
+	options.RepoFilename = repoFile
+	dir, err := campaign.OpenCampaign(options)
+	if err != nil {
+		readCampaignActivity.EndWithFailure(err)
+		return
+	}
+	state, err := campaign.ReadState()
+	if err != nil {
+		readCampaignActivity.EndWithFailure(err)
+		return
+	}
+	readCampaignActivity.EndWithSuccess()
+
+	if doneId != 0 {
+		if !state.CompleteFollowUp(doneId) {
+			logger.Errorf("No follow-up with id %d in campaign %s", doneId, dir.Name)
+			return
+		}
+		if err := state.Save(); err != nil {
+			logger.Errorf("%s", err)
+			return
+		}
+		logger.Successf("turbolift follow-up completed %s(follow-up %d marked as done)\n", colors.Normal(), doneId)
+		return
+	}
+
+	delay, err := parseAfter(after)
+	if err != nil {
+		logger.Errorf("Unable to parse --after: %s", err)
+		return
+	}
+
+	created := now().UTC()
+	followUp := state.AddFollowUp(campaign.FollowUp{
+		Command: command,
+		Note:    note,
+		Created: created,
+		Due:     created.Add(delay),
+	})
+	if err := state.Save(); err != nil {
+		logger.Errorf("%s", err)
+		return
+	}
+
+	logger.Successf("turbolift follow-up completed %s(follow-up %d scheduled for %s)\n", colors.Normal(), followUp.Id, colors.Cyan(followUp.Due.Format("2006-01-02")))
+}
+
+var daysOrWeeksRegexp = regexp.MustCompile(`^(\d+)([dw])$`)
+
+// parseAfter parses a duration, allowing days (d) and weeks (w) in addition to the units understood by time.ParseDuration
+func parseAfter(value string) (time.Duration, error) {
+	var duration time.Duration
+	if match := daysOrWeeksRegexp.FindStringSubmatch(value); match != nil {
+		days, _ := strconv.Atoi(match[1])
+		if match[2] == "w" {
+			days *= 7
+		}
+		duration = time.Duration(days) * 24 * time.Hour
+	} else {
+		var err error
+		duration, err = time.ParseDuration(value)
+		if err != nil {
+			return 0, fmt.Errorf("%s is not a duration such as 30d, 2w or 12h", value)
+		}
+	}
+
+	if duration <= 0 {
+		return 0, errors.New("the follow-up must be in the future")
+	}
+	return duration, nil
+}
@@ -0,0 +1,100 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package followup
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/testsupport"
+)
+
+func init() {
+	// disable output colouring so that strings we want to do 'Contains' checks on do not have ANSI escape sequences in IDEs
+	_ = os.Setenv("NO_COLOR", "1")
+}
+
+var fixedNow = time.Date(2021, 10, 1, 9, 0, 0, 0, time.UTC)
+
+func TestItSchedulesAFollowUp(t *testing.T) {
+	now = func() time.Time { return fixedNow }
+	testsupport.PrepareTempCampaign(false, "org/repo1")
+
+	out, err := runCommand("--after", "30d", "--command", "turbolift foreach -- ./remove-flag.sh", "--note", "Remove the flag")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "follow-up 1 scheduled for 2021-10-31")
+
+	out, err = runCommand("--after", "2w", "--command", "make cleanup")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "follow-up 2 scheduled for 2021-10-15")
+
+	state, _ := campaign.ReadState()
+	assert.Equal(t, []campaign.FollowUp{
+		{Id: 1, Command: "turbolift foreach -- ./remove-flag.sh", Note: "Remove the flag", Created: fixedNow, Due: fixedNow.AddDate(0, 0, 30)},
+		{Id: 2, Command: "make cleanup", Created: fixedNow, Due: fixedNow.AddDate(0, 0, 14)},
+	}, state.FollowUps)
+}
+
+func TestItMarksAFollowUpAsDone(t *testing.T) {
+	now = func() time.Time { return fixedNow }
+	testsupport.PrepareTempCampaign(false, "org/repo1")
+
+	_, _ = runCommand("--after", "1d", "--command", "make cleanup")
+	out, err := runCommand("--done", "1")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "follow-up 1 marked as done")
+
+	out, err = runCommand("--done", "2")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "No follow-up with id 2")
+
+	state, _ := campaign.ReadState()
+	assert.True(t, state.FollowUps[0].Done)
+}
+
+func TestItRequiresAfterAndCommand(t *testing.T) {
+	testsupport.PrepareTempCampaign(false, "org/repo1")
+
+	out, err := runCommand("--after", "30d")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "--after and --command are required")
+}
+
+func TestParseAfter(t *testing.T) {
+	duration, err := parseAfter("12h")
+	assert.NoError(t, err)
+	assert.Equal(t, 12*time.Hour, duration)
+
+	_, err = parseAfter("soon")
+	assert.EqualError(t, err, "soon is not a duration such as 30d, 2w or 12h")
+
+	_, err = parseAfter("0d")
+	assert.EqualError(t, err, "the follow-up must be in the future")
+}
+
+func runCommand(args ...string) (string, error) {
+	cmd := NewFollowUpCmd()
+	outBuffer := bytes.NewBufferString("")
+	cmd.SetOut(outBuffer)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return outBuffer.String(), err
+}
@@ -16,26 +16,61 @@
 package foreach
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
+	"text/template"
+	"time"
 
 	"github.com/spf13/cobra"
 
+	"github.com/skyscanner/turbolift/cmd/flags"
 	"github.com/skyscanner/turbolift/internal/campaign"
 	"github.com/skyscanner/turbolift/internal/colors"
 	"github.com/skyscanner/turbolift/internal/executor"
+	"github.com/skyscanner/turbolift/internal/interrupt"
 	"github.com/skyscanner/turbolift/internal/logging"
+	"github.com/skyscanner/turbolift/internal/messages"
+	"github.com/skyscanner/turbolift/internal/operations"
+	"github.com/skyscanner/turbolift/internal/parallel"
+	"github.com/skyscanner/turbolift/internal/summary"
 
 	"github.com/alessio/shellescape"
 )
 
 var exec executor.Executor = executor.NewRealExecutor()
 
+// The ways that --output can show the output of each execution, besides printing it under the execution's outcome
+const (
+	// streamOutput prints each line as soon as it is written, tagged with its repo
+	streamOutput = "stream"
+	// groupedOutput prints every line of an execution together once it finishes, tagged with its repo
+	groupedOutput = "grouped"
+)
+
 var (
-	repoFile = "repos.txt"
+	repoFile    = "repos.txt"
+	shellMode   bool
+	scriptFile  string
+	shuffle     string
+	resume      bool
+	workers     int
+	capturePath string
+	repoTimeout time.Duration
+	maxFailures int
+	outputMode  string
+	dockerImage string
+
+	// capture records the output captured in each repo, if --capture is used
+	capture *campaign.Capture
+	// container is where commands are run, if --docker or the campaign's configuration gives an image
+	container *executor.Container
 
 	overallResultsDirectory string
 
@@ -60,80 +95,452 @@ func NewForeachCmd() *cobra.Command {
 		Short: "Run COMMAND against each working copy",
 		Long: `Run COMMAND against each working copy. Make sure to include a
 double hyphen -- with space on both sides before COMMAND, as this
-marks that no further options should be interpreted by turbolift.`,
+marks that no further options should be interpreted by turbolift.
+
+With --shell, COMMAND is passed as a single string to $SHELL -c, so
+pipes, redirection and other shell syntax can be used without extra
+quoting. With --script, the given local script is run inside each
+working copy, and any ARGUMENTs after -- are passed on to it.
+
+With --repo-timeout, COMMAND is stopped in any working copy where it
+runs for too long, and the run carries on with the next. With
+--max-failures, the run stops once COMMAND has failed in that many
+working copies, and can be carried on with --resume once fixed.
+
+With --output stream, each line of output is printed as soon as it
+is written, tagged with the repo it came from, which is the default
+with --verbose and several --workers. With --output grouped, each
+execution's tagged lines are printed together once it finishes.
+
+With --docker, or a docker image in turbolift.yaml, COMMAND is run
+inside a container of the given image, with each working copy
+mounted, so that it gets the same toolchain on every machine.`,
 		RunE: runE,
-		Args: cobra.MinimumNArgs(1),
 	}
 
 	cmd.Flags().StringVar(&repoFile, "repos", "repos.txt", "A file containing a list of repositories to clone.")
+	cmd.Flags().BoolVar(&shellMode, "shell", false, "Run COMMAND through $SHELL -c in each working copy")
+	cmd.Flags().StringVar(&scriptFile, "script", "", "A local script file to run inside each working copy")
+	cmd.Flags().IntVar(&workers, "workers", 1, "Run COMMAND in up to this many working copies at once; fewer are used while errors or rate limits are seen")
+	cmd.Flags().StringVar(&capturePath, "capture", "", "Write the stdout of COMMAND in each working copy to a file named with this template, e.g. results/{{.Repo}}.out, for turbolift results to compare")
+	cmd.Flags().DurationVar(&repoTimeout, "repo-timeout", 0, "Stop COMMAND in a working copy once it has run for this long, e.g. 10m, and carry on with the next (defaults to no limit)")
+	cmd.Flags().IntVar(&maxFailures, "max-failures", 0, "Stop the run once COMMAND has failed in this many working copies (defaults to no limit)")
+	cmd.Flags().StringVar(&outputMode, "output", "", "Print output line by line tagged with its repo: stream to print lines as they are written, or grouped to print each execution's lines together (defaults to stream with --verbose and several --workers)")
+	cmd.Flags().StringVar(&dockerImage, "docker", "", "Run COMMAND inside a container of this Docker image, e.g. node:20, with each working copy mounted (defaults to the docker image in turbolift.yaml, if any)")
+	flags.AddShuffleFlag(cmd, &shuffle)
+	flags.AddResumeFlag(cmd, &resume)
+	flags.MarkMakesChanges(cmd)
 
 	return cmd
 }
 
 func runE(c *cobra.Command, args []string) error {
+	ctx := c.Context()
 	logger := logging.NewLogger(c)
 
-	if c.ArgsLenAtDash() != 0 {
+	if len(args) > 0 && c.ArgsLenAtDash() != 0 {
 		return errors.New("Use -- to separate command")
 	}
 
+	commandName, commandArgs, err := buildCommand(args)
+	if err != nil {
+		return err
+	}
+	if repoTimeout < 0 || maxFailures < 0 {
+		return errors.New("--repo-timeout and --max-failures cannot be negative")
+	}
+	if outputMode != "" && outputMode != streamOutput && outputMode != groupedOutput {
+		return fmt.Errorf("--output must be %s or %s", streamOutput, groupedOutput)
+	}
+	if outputMode == "" && flags.Verbose && workers > 1 {
+		outputMode = streamOutput
+	}
+	// reaching --max-failures stops the run in the same way as an interrupt, so that it can be resumed
+	ctx, stopForFailures := interrupt.WithGracefulStop(ctx)
+	defer stopForFailures()
+
 	readCampaignActivity := logger.StartActivity("Reading campaign data (%s)", repoFile)
 	options := campaign.NewCampaignOptions()
 	options.RepoFilename = repoFile
+	options.Shuffle = shuffle
 	dir, err := campaign.OpenCampaign(options)
 	if err != nil {
 		readCampaignActivity.EndWithFailure(err)
 		return nil
 	}
 	readCampaignActivity.EndWithSuccess()
+	container = containerFor(dir)
+	if container != nil {
+		commandName, commandArgs = containerCommand(container, args)
+		logger.Printf("Running commands inside containers of %s", container.Image)
+	}
+	flags.LogShuffle(logger, dir)
 
 	// We shell escape these to avoid ambiguity in our logs, and give
 	// the user something they could copy and paste.
 	prettyArgs := formatArguments(args)
+	if shellMode {
+		prettyArgs = strings.Join(args, " ")
+	} else if scriptFile != "" {
+		prettyArgs = strings.TrimSpace(formatArguments(append([]string{scriptFile}, args...)))
+	}
+
+	progress, err := campaign.NewProgress("foreach "+prettyArgs, dir.BranchName, resume)
+	if err != nil {
+		logger.Errorf("%s", err)
+		return nil
+	}
+	var repos []campaign.Repo
+	for _, repo := range dir.Repos {
+		if !progress.AlreadyCompleted(repo) {
+			repos = append(repos, repo)
+		}
+	}
+	if progress.Resumed() > 0 {
+		logger.Printf("Resuming the last run, so leaving alone the %d repos it had finished with", progress.Resumed())
+	}
 
 	setupOutputFiles(dir.Name, prettyArgs)
+	if err := startCapture(prettyArgs); err != nil {
+		logger.Errorf("%s", err)
+		return nil
+	}
 
 	logger.Printf("Logs for all executions will be stored under %s", overallResultsDirectory)
 
-	var doneCount, skippedCount, errorCount int
-	for _, repo := range dir.Repos {
-		repoDirPath := path.Join("work", repo.OrgName, repo.RepoName) // i.e. work/org/repo
+	sum := summary.New("foreach")
+	// tagged output is printed while commands run, which the spinner of a running activity would get in the way of
+	if workers > 1 || outputMode != "" {
+		runInParallel(ctx, logger, progress, sum, dir, repos, prettyArgs, commandName, commandArgs)
+	} else {
+		runSequentially(ctx, logger, progress, sum, dir, repos, prettyArgs, commandName, commandArgs)
+	}
+
+	sum.Finish(ctx)
+	if interrupt.Requested(ctx) {
+		logger.Warnf("%s %s(%s, %s, %s)\n", dir.Messages.Format(messages.SummaryInterrupted, messages.Fields{"Command": "foreach"}), colors.Normal(), colors.Green(sum.Done, " OK"), colors.Yellow(sum.Skipped, " skipped"), colors.Red(sum.Errored, " errored"))
+	} else if sum.Errored == 0 {
+		logger.Successf("%s %s(%s, %s)\n", dir.Messages.Format(messages.SummaryCompleted, messages.Fields{"Command": "foreach"}), colors.Normal(), colors.Green(sum.Done, " OK"), colors.Yellow(sum.Skipped, " skipped"))
+	} else {
+		logger.Warnf("%s %s(%s, %s, %s)\n", dir.Messages.Format(messages.SummaryWithErrors, messages.Fields{"Command": "foreach"}), colors.Normal(), colors.Green(sum.Done, " OK"), colors.Yellow(sum.Skipped, " skipped"), colors.Red(sum.Errored, " errored"))
+	}
+
+	if capture != nil {
+		if err := saveCapture(); err != nil {
+			logger.Warnf("Unable to record the captured output in the campaign state: %s", err)
+		}
+	}
+
+	if err := progress.End(interrupt.Requested(ctx)); err != nil {
+		logger.Warnf("Unable to update the checkpoint in the campaign state: %s", err)
+	} else if interrupt.Requested(ctx) {
+		logger.Printf("To carry on from where it stopped, run %s again with the same options", colors.Cyan(c.CommandPath()+" --resume"))
+	}
+
+	logger.Printf("Logs for all executions have been stored under %s", overallResultsDirectory)
+	logger.Printf("Names of successful repos have been written to %s", successfulReposFileName)
+	logger.Printf("Names of failed repos have been written to %s", failedReposFileName)
+	if capture != nil {
+		logger.Printf("Output of each execution has been captured to %s, so run %s to compare them", capturePath, colors.Cyan("turbolift results"))
+	}
+
+	return nil
+}
+
+func runSequentially(ctx context.Context, logger *logging.Logger, progress *campaign.Progress, sum *summary.Summary, dir *campaign.Campaign, repos []campaign.Repo, prettyArgs string, commandName string, commandArgs []string) {
+	for i, repo := range repos {
+		if interrupt.Requested(ctx) {
+			logger.Warnf("Interrupted, so not running in the remaining %d repos", len(repos)-i)
+			break
+		}
+		repoDirPath := repo.WorkingDir() // i.e. work/org/repo, or work/org/repo/path for repos scoped to a path
 
 		execActivity := logger.StartActivity("Executing { %s } in %s", prettyArgs, repoDirPath)
 
 		// skip if the working copy does not exist
-		if _, err = os.Stat(repoDirPath); os.IsNotExist(err) {
+		if _, err := os.Stat(repoDirPath); os.IsNotExist(err) {
 			execActivity.EndWithWarningf("Directory %s does not exist - has it been cloned?", repoDirPath)
-			skippedCount++
+			sum.RecordSkipped(repo, "not cloned")
 			continue
 		}
 
-		err := exec.Execute(execActivity.Writer(), repoDirPath, args[0], args[1:]...)
+		err := execute(ctx, execActivity.Writer(), dir, repo, commandName, commandArgs)
+		if captureErr := captureOutcome(repo, err); captureErr != nil {
+			execActivity.Log(captureErr.Error())
+		}
 
 		if err != nil {
 			emitOutcomeToFiles(repo, failedReposFileName, failedResultsDirectory, execActivity.Logs(), logger)
 			execActivity.EndWithFailure(err)
-			errorCount++
+			sum.RecordErrored(repo, err)
+			checkMaxFailures(ctx, logger, sum)
 		} else {
 			emitOutcomeToFiles(repo, successfulReposFileName, successfulResultsDirectory, execActivity.Logs(), logger)
 			execActivity.EndWithSuccessAndEmitLogs()
-			doneCount++
+			sum.RecordDone(repo)
+			progress.Complete(repo)
 		}
 	}
+}
 
-	if errorCount == 0 {
-		logger.Successf("turbolift foreach completed %s(%s, %s)\n", colors.Normal(), colors.Green(doneCount, " OK"), colors.Yellow(skippedCount, " skipped"))
-	} else {
-		logger.Warnf("turbolift foreach completed with %s %s(%s, %s, %s)\n", colors.Red("errors"), colors.Normal(), colors.Green(doneCount, " OK"), colors.Yellow(skippedCount, " skipped"), colors.Red(errorCount, " errored"))
+// runInParallel runs the command in several working copies at once, backing off when errors or rate limits are seen.
+// Output is buffered and each execution is reported as it finishes, as activities cannot be shown side by side. With
+// --output, it is also printed line by line tagged with its repo, and is not repeated under the execution's outcome.
+func runInParallel(ctx context.Context, logger *logging.Logger, progress *campaign.Progress, sum *summary.Summary, dir *campaign.Campaign, repos []campaign.Repo, prettyArgs string, commandName string, commandArgs []string) {
+	var runnable []campaign.Repo
+	for _, repo := range repos {
+		// skip if the working copy does not exist
+		if _, err := os.Stat(repo.WorkingDir()); os.IsNotExist(err) {
+			skipActivity := logger.StartActivity("Executing { %s } in %s", prettyArgs, repo.WorkingDir())
+			skipActivity.EndWithWarningf("Directory %s does not exist - has it been cloned?", repo.WorkingDir())
+			sum.RecordSkipped(repo, "not cloned")
+			continue
+		}
+		runnable = append(runnable, repo)
 	}
 
-	logger.Printf("Logs for all executions have been stored under %s", overallResultsDirectory)
-	logger.Printf("Names of successful repos have been written to %s", successfulReposFileName)
-	logger.Printf("Names of failed repos have been written to %s", failedReposFileName)
+	controller := parallel.NewController(workers)
+	parallel.Run(ctx, len(runnable), controller, func(i int) (string, error) {
+		// repos are run several at once, so each is timed from when it starts rather than when the last one finished
+		sum.Start(runnable[i])
+		var output bytes.Buffer
+		if outputMode == "" {
+			err := execute(ctx, &output, dir, runnable[i], commandName, commandArgs)
+			return output.String(), err
+		}
+		tagged := logger.PrefixWriter(runnable[i].FullRepoName, outputMode == groupedOutput)
+		err := execute(ctx, io.MultiWriter(&output, tagged), dir, runnable[i], commandName, commandArgs)
+		tagged.Flush()
+		return output.String(), err
+	}, func(i int, output string, err error, limitChanged bool) {
+		repo := runnable[i]
+		execActivity := logger.StartActivity("Executing { %s } in %s", prettyArgs, repo.WorkingDir())
+		var logs []string
+		if output != "" {
+			logs = strings.Split(strings.TrimRight(logging.Redact(output), "\n"), "\n")
+		}
+		// tagged output has already been printed, so only the outcome is shown under the execution
+		endWithSuccess := execActivity.EndWithSuccess
+		if outputMode == "" {
+			for _, line := range logs {
+				execActivity.Log(line)
+			}
+			endWithSuccess = execActivity.EndWithSuccessAndEmitLogs
+		}
+		if captureErr := captureOutcome(repo, err); captureErr != nil {
+			logs = append(logs, captureErr.Error())
+			execActivity.Log(captureErr.Error())
+		}
+
+		if err != nil {
+			emitOutcomeToFiles(repo, failedReposFileName, failedResultsDirectory, strings.Join(logs, "\n"), logger)
+			execActivity.EndWithFailure(err)
+			sum.RecordErrored(repo, err)
+			checkMaxFailures(ctx, logger, sum)
+		} else {
+			emitOutcomeToFiles(repo, successfulReposFileName, successfulResultsDirectory, strings.Join(logs, "\n"), logger)
+			endWithSuccess()
+			sum.RecordDone(repo)
+			progress.Complete(repo)
+		}
+
+		if limitChanged {
+			logger.Printf("Now running in up to %d working copies at once", controller.Limit())
+		}
+	})
+	if notRun := len(runnable) - sum.Done - sum.Errored; notRun > 0 {
+		logger.Warnf("Interrupted, so not running in the remaining %d repos", notRun)
+	}
+}
+
+// checkMaxFailures stops the run once the command has failed in as many repos as --max-failures allows. Repos that are
+// already running are left to finish.
+func checkMaxFailures(ctx context.Context, logger *logging.Logger, sum *summary.Summary) {
+	if maxFailures == 0 || sum.Errored < maxFailures || interrupt.Requested(ctx) {
+		return
+	}
+	if interrupt.RequestStop(ctx) {
+		logger.Warnf("Stopping after %d failures, as --max-failures is %d. Fix the problem, then carry on with --resume", sum.Errored, maxFailures)
+	}
+}
+
+// execute runs the command in a working copy, or the repo's path within it, with the repo's metadata in TURBOLIFT_* environment variables.
+// With --capture, its stdout is also written to the repo's capture file. With --repo-timeout, the command is stopped if
+// it runs for too long.
+func execute(ctx context.Context, output io.Writer, dir *campaign.Campaign, repo campaign.Repo, commandName string, commandArgs []string) error {
+	if repoTimeout == 0 {
+		return executeInRepo(ctx, output, dir, repo, commandName, commandArgs)
+	}
+
+	repoCtx, cancel := context.WithTimeout(ctx, repoTimeout)
+	defer cancel()
+	err := executeInRepo(repoCtx, output, dir, repo, commandName, commandArgs)
+	if err != nil && errors.Is(repoCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+		return fmt.Errorf("stopped after --repo-timeout of %s: %w", repoTimeout, err)
+	}
+	return err
+}
+
+func executeInRepo(ctx context.Context, output io.Writer, dir *campaign.Campaign, repo campaign.Repo, commandName string, commandArgs []string) error {
+	clients := operations.Clients{Executor: exec}
+	options := operations.RunOptions{Container: container}
+	if capture == nil {
+		return operations.Run(ctx, output, clients, dir, repo, options, commandName, commandArgs...)
+	}
+
+	filename, err := captureFilename(repo)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+		return fmt.Errorf("unable to create directory for captured output: %w", err)
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("unable to create file for captured output: %w", err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	options.Stdout = file
+	return operations.Run(ctx, output, clients, dir, repo, options, commandName, commandArgs...)
+}
+
+// captureFilename names the file that a repo's output is captured in. As well as the usual repo details, the template
+// can use {{.Repo}} for the repo's full name.
+func captureFilename(repo campaign.Repo) (string, error) {
+	parsedTemplate, err := template.New("").Option("missingkey=zero").Parse(capturePath)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse --capture template: %w", err)
+	}
+
+	var sb strings.Builder
+	err = parsedTemplate.Execute(&sb, map[string]interface{}{
+		"Repo":         repo.FullRepoName,
+		"Host":         repo.Host,
+		"OrgName":      repo.OrgName,
+		"RepoName":     repo.RepoName,
+		"FullRepoName": repo.FullRepoName,
+		"Vars":         repo.Vars,
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to render --capture template for %s: %w", repo.FullRepoName, err)
+	}
+	return sb.String(), nil
+}
+
+// startCapture begins recording captured output, carrying on with the last capture if resuming the same command
+func startCapture(command string) error {
+	capture = nil
+	if capturePath == "" {
+		return nil
+	}
+	if _, err := captureFilename(campaign.Repo{}); err != nil {
+		return err
+	}
+
+	state, err := campaign.ReadState()
+	if err != nil {
+		return err
+	}
+	if resume && state.LastCapture != nil && state.LastCapture.Command == command && state.LastCapture.Path == capturePath {
+		capture = state.LastCapture
+		return nil
+	}
+	capture = &campaign.Capture{Command: command, Path: capturePath, Results: []campaign.CaptureResult{}}
+	return nil
+}
 
+// captureOutcome records how the command exited in a repo whose output was captured
+func captureOutcome(repo campaign.Repo, err error) error {
+	if capture == nil {
+		return nil
+	}
+	filename, filenameErr := captureFilename(repo)
+	if filenameErr != nil {
+		return filenameErr
+	}
+	capture.Record(campaign.CaptureResult{Repo: repo.FullRepoName, File: filename, ExitCode: executor.ExitCode(err)})
 	return nil
 }
 
+func saveCapture() error {
+	state, err := campaign.ReadState()
+	if err != nil {
+		return err
+	}
+	capture.Captured = time.Now()
+	state.LastCapture = capture
+	return state.Save()
+}
+
+// buildCommand works out what to execute in each working copy, depending on whether --shell or --script was chosen
+func buildCommand(args []string) (string, []string, error) {
+	if shellMode && scriptFile != "" {
+		return "", nil, errors.New("--shell and --script cannot be used together")
+	}
+
+	if scriptFile != "" {
+		// the command runs from each working copy, so the script needs to be referred to by absolute path
+		scriptPath, err := filepath.Abs(scriptFile)
+		if err != nil {
+			return "", nil, err
+		}
+		info, err := os.Stat(scriptPath)
+		if err != nil {
+			return "", nil, fmt.Errorf("unable to read script: %w", err)
+		}
+		command, commandArgs := executor.ScriptCommand(scriptPath, info.Mode(), args...)
+		return command, commandArgs, nil
+	}
+
+	if len(args) == 0 {
+		return "", nil, errors.New("a COMMAND or --script is required")
+	}
+
+	if shellMode {
+		command, commandArgs := executor.ShellCommand(strings.Join(args, " "))
+		return command, commandArgs, nil
+	}
+
+	return args[0], args[1:], nil
+}
+
+// containerFor returns the container that commands are run in, from --docker or failing that the campaign's
+// configuration, or nil if they are run on the local machine
+func containerFor(dir *campaign.Campaign) *executor.Container {
+	image := dockerImage
+	if image == "" {
+		image = dir.Config.Docker.Image
+	}
+	if image == "" {
+		return nil
+	}
+	c := &executor.Container{Image: image}
+	if scriptFile != "" {
+		// buildCommand has already checked that the script can be read
+		c.ScriptPath, _ = filepath.Abs(scriptFile)
+	}
+	return c
+}
+
+// containerCommand returns the command and arguments that run COMMAND, --shell or --script inside the container, where
+// the user's shell and local paths are not available
+func containerCommand(c *executor.Container, args []string) (string, []string) {
+	if c.ScriptPath != "" {
+		var mode os.FileMode
+		if info, err := os.Stat(c.ScriptPath); err == nil {
+			mode = info.Mode()
+		}
+		return c.ScriptCommand(mode, args...)
+	}
+	if shellMode {
+		return c.ShellCommand(strings.Join(args, " "))
+	}
+	return args[0], args[1:]
+}
+
 // sets up a temporary directory to store success/failure logs etc
 func setupOutputFiles(campaignName string, command string) {
 	overallResultsDirectory, _ = os.MkdirTemp("", fmt.Sprintf("turbolift-foreach-%s-", campaignName))
@@ -17,13 +17,20 @@ package foreach
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"os"
+	"path/filepath"
 	"regexp"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
+	"github.com/skyscanner/turbolift/internal/campaign"
 	"github.com/skyscanner/turbolift/internal/executor"
+	"github.com/skyscanner/turbolift/internal/interrupt"
 	"github.com/skyscanner/turbolift/internal/testsupport"
 )
 
@@ -70,6 +77,80 @@ func TestItRunsCommandWithoutShellAgainstWorkingCopies(t *testing.T) {
 	})
 }
 
+func TestItPassesRepoMetadataToTheCommand(t *testing.T) {
+	fakeExecutor := executor.NewAlwaysSucceedsFakeExecutor()
+	exec = fakeExecutor
+
+	tempDir := testsupport.PrepareTempCampaign(true, "org/repo1")
+	_ = os.MkdirAll("work/org/repo1/.git/refs/remotes/origin", 0o755)
+	_ = os.WriteFile("work/org/repo1/.git/refs/remotes/origin/HEAD", []byte("ref: refs/remotes/origin/trunk\n"), 0o644)
+	repoDir, _ := filepath.Abs("work/org/repo1")
+
+	_, err := runCommand("--", "some", "command")
+	assert.NoError(t, err)
+
+	fakeExecutor.AssertEnvCalledWith(t, [][]string{
+		{
+			"TURBOLIFT_CAMPAIGN=" + filepath.Base(tempDir),
+			"TURBOLIFT_REPO=org/repo1",
+			"TURBOLIFT_HOST=",
+			"TURBOLIFT_ORG=org",
+			"TURBOLIFT_REPO_NAME=repo1",
+			"TURBOLIFT_REPO_DIR=" + repoDir,
+			"TURBOLIFT_DEFAULT_BRANCH=trunk",
+		},
+	})
+}
+
+func TestItRunsCommandInsideThePathOfReposScopedToOne(t *testing.T) {
+	fakeExecutor := executor.NewAlwaysSucceedsFakeExecutor()
+	exec = fakeExecutor
+
+	tempDir := testsupport.PrepareTempCampaign(true, "org/monorepo//services/foo")
+	repoDir, _ := filepath.Abs("work/org/monorepo")
+
+	out, err := runCommand("--", "some", "command")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Executing { some command } in work/org/monorepo/services/foo")
+	assert.Contains(t, out, "1 OK, 0 skipped")
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/monorepo/services/foo", "some", "command"},
+	})
+	fakeExecutor.AssertEnvCalledWith(t, [][]string{
+		{
+			"TURBOLIFT_CAMPAIGN=" + filepath.Base(tempDir),
+			"TURBOLIFT_REPO=org/monorepo",
+			"TURBOLIFT_HOST=",
+			"TURBOLIFT_ORG=org",
+			"TURBOLIFT_REPO_NAME=monorepo",
+			"TURBOLIFT_REPO_DIR=" + repoDir,
+			"TURBOLIFT_DEFAULT_BRANCH=",
+			"TURBOLIFT_REPO_PATH=services/foo",
+		},
+	})
+}
+
+func TestItRunsCommandInShuffledOrder(t *testing.T) {
+	fakeExecutor := executor.NewAlwaysSucceedsFakeExecutor()
+	exec = fakeExecutor
+
+	testsupport.PrepareTempCampaign(true, "org1/repo1", "org1/repo2", "org2/repo1", "org2/repo2", "org3/repo1")
+
+	out, err := runCommand("--shuffle=7", "--", "some", "command")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Processing repos in shuffled order (use --shuffle=7 to repeat it)")
+
+	options := campaign.NewCampaignOptions()
+	options.Shuffle = "7"
+	dir, _ := campaign.OpenCampaign(options)
+	var expected [][]string
+	for _, repo := range dir.Repos {
+		expected = append(expected, []string{repo.FullRepoPath(), "some", "command"})
+	}
+	fakeExecutor.AssertCalledWith(t, expected)
+}
+
 func TestItRunsCommandWithSpacesAgainstWorkingCopied(t *testing.T) {
 	fakeExecutor := executor.NewAlwaysSucceedsFakeExecutor()
 	exec = fakeExecutor
@@ -124,6 +205,326 @@ func TestItContinuesOnAndRecordsFailures(t *testing.T) {
 	})
 }
 
+func TestItStopsAfterMaxFailures(t *testing.T) {
+	fakeExecutor := executor.NewAlwaysFailsFakeExecutor()
+	exec = fakeExecutor
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2", "org/repo3", "org/repo4")
+
+	out, err := runCommand("--max-failures", "2", "--", "some", "command")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Stopping after 2 failures, as --max-failures is 2")
+	assert.Contains(t, out, "Interrupted, so not running in the remaining 2 repos")
+	assert.Contains(t, out, "0 OK, 0 skipped, 2 errored")
+	assert.Contains(t, out, "foreach --resume")
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", "some", "command"},
+		{"work/org/repo2", "some", "command"},
+	})
+}
+
+func TestItStopsAfterMaxFailuresWithSeveralWorkers(t *testing.T) {
+	var mu sync.Mutex
+	runs := 0
+	exec = executor.NewFakeExecutor(func(string, string, ...string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		runs++
+		return errors.New("synthetic error")
+	}, func(string, string, ...string) (string, error) {
+		return "", nil
+	})
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2", "org/repo3", "org/repo4", "org/repo5", "org/repo6")
+
+	out, err := runCommand("--workers", "2", "--max-failures", "1", "--", "some", "command")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Stopping after 1 failures, as --max-failures is 1")
+	assert.Contains(t, out, "turbolift foreach was interrupted")
+	// only the repos already running when the first failure was seen are finished
+	assert.LessOrEqual(t, runs, 2)
+}
+
+func TestItStopsCommandsThatRunPastTheRepoTimeout(t *testing.T) {
+	exec = executor.NewRealExecutor()
+	defer func() { exec = executor.NewAlwaysSucceedsFakeExecutor() }()
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+
+	started := time.Now()
+	out, err := runCommand("--repo-timeout", "200ms", "--shell", "--", "test $TURBOLIFT_REPO_NAME = repo2 || sleep 10")
+	assert.NoError(t, err)
+	assert.Less(t, time.Since(started), 5*time.Second)
+	assert.Contains(t, out, "stopped after --repo-timeout of 200ms")
+	assert.Contains(t, out, "turbolift foreach completed with errors")
+	assert.Contains(t, out, "1 OK, 0 skipped, 1 errored")
+}
+
+func TestItStopsWhenInterrupted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fakeExecutor := executor.NewFakeExecutor(func(string, string, ...string) error {
+		cancel()
+		return errors.New("some command was cancelled: context canceled")
+	}, func(string, string, ...string) (string, error) {
+		return "", nil
+	})
+	exec = fakeExecutor
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2", "org/repo3")
+
+	out, err := runCommandWithContext(ctx, "--", "some", "command")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Interrupted, so not running in the remaining 2 repos")
+	assert.Contains(t, out, "turbolift foreach was interrupted")
+	assert.Contains(t, out, "0 OK, 0 skipped, 1 errored")
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", "some", "command"},
+	})
+}
+
+func TestItFinishesTheCurrentRepoWhenAskedToStopAndResumesLater(t *testing.T) {
+	ctx, requestStop := interrupt.WithGracefulStop(context.Background())
+	defer requestStop()
+	fakeExecutor := executor.NewFakeExecutor(func(string, string, ...string) error {
+		requestStop()
+		return nil
+	}, func(string, string, ...string) (string, error) {
+		return "", nil
+	})
+	exec = fakeExecutor
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2", "org/repo3")
+
+	out, err := runCommandWithContext(ctx, "--", "some", "command")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "turbolift foreach was interrupted")
+	assert.Contains(t, out, "1 OK, 0 skipped, 0 errored")
+	assert.Contains(t, out, "foreach --resume")
+
+	fakeExecutor = executor.NewAlwaysSucceedsFakeExecutor()
+	exec = fakeExecutor
+
+	// a checkpoint is only used to resume the same command
+	out, err = runCommand("--resume", "--", "other", "command")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "there is no interrupted run of foreach other command on branch")
+
+	out, err = runCommand("--resume", "--", "some", "command")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "turbolift foreach completed")
+	assert.Contains(t, out, "2 OK, 0 skipped")
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo2", "some", "command"},
+		{"work/org/repo3", "some", "command"},
+	})
+}
+
+func TestItStopsStartingWorkingCopiesWhenInterruptedInParallel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fakeExecutor := executor.NewFakeExecutor(func(string, string, ...string) error {
+		cancel()
+		return nil
+	}, func(string, string, ...string) (string, error) {
+		return "", nil
+	})
+	exec = fakeExecutor
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2", "org/repo3", "org/repo4")
+
+	out, err := runCommandWithContext(ctx, "--workers", "2", "--", "some", "command")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Interrupted, so not running in the remaining 2 repos")
+	assert.Contains(t, out, "turbolift foreach was interrupted")
+	assert.Contains(t, out, "2 OK, 0 skipped, 0 errored")
+}
+
+func TestItRunsCommandInSeveralWorkingCopiesAtOnce(t *testing.T) {
+	fakeExecutor := executor.NewAlwaysSucceedsFakeExecutor()
+	exec = fakeExecutor
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2", "org/repo3")
+	_ = os.RemoveAll("work/org/repo3")
+
+	out, err := runCommand("--workers", "2", "--", "some", "command")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Directory work/org/repo3 does not exist")
+	assert.Contains(t, out, "2 OK, 1 skipped")
+
+	fakeExecutor.AssertCalledWithInAnyOrder(t, [][]string{
+		{"work/org/repo1", "some", "command"},
+		{"work/org/repo2", "some", "command"},
+	})
+}
+
+func TestItStreamsOutputTaggedWithItsRepo(t *testing.T) {
+	exec = executor.NewFakeExecutor(nil, func(workingDir string, name string, args ...string) (string, error) {
+		if workingDir == "work/org/repo2" {
+			return "bad\n", errors.New("synthetic error")
+		}
+		return "line one\nline two\n", nil
+	})
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+
+	out, err := runCommand("--output", "stream", "--", "some", "command")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "[org/repo1] line one\n[org/repo1] line two\n")
+	assert.Contains(t, out, "[org/repo2] bad\n")
+	assert.Contains(t, out, "1 OK, 0 skipped, 1 errored")
+	// the output is not repeated under each execution's outcome
+	assert.NotRegexp(t, "\\s{5}line one", out)
+
+	logs, err := os.ReadFile(filepath.Join(overallResultsDirectory, "successful", "org", "repo1", "logs.txt"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(logs), "line one\nline two")
+}
+
+func TestItGroupsTaggedOutputForEachRepo(t *testing.T) {
+	exec = executor.NewFakeExecutor(nil, func(workingDir string, name string, args ...string) (string, error) {
+		return "line one\nline two\n", nil
+	})
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+
+	out, err := runCommand("--workers", "2", "--output", "grouped", "--", "some", "command")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "[org/repo1] line one\n[org/repo1] line two\n")
+	assert.Contains(t, out, "[org/repo2] line one\n[org/repo2] line two\n")
+}
+
+func TestItRejectsAnUnknownOutputMode(t *testing.T) {
+	exec = executor.NewAlwaysSucceedsFakeExecutor()
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	_, err := runCommand("--output", "sideways", "--", "some", "command")
+	assert.EqualError(t, err, "--output must be stream or grouped")
+}
+
+func TestItRunsCommandInsideAContainerWithDocker(t *testing.T) {
+	var commands [][]string
+	exec = executor.NewFakeExecutor(func(workingDir string, name string, args ...string) error {
+		commands = append(commands, append([]string{workingDir, name}, args...))
+		return nil
+	}, nil)
+
+	tempDir := testsupport.PrepareTempCampaign(true, "org/repo1")
+	repoDir, _ := filepath.EvalSymlinks(filepath.Join(tempDir, "work", "org", "repo1"))
+	_ = os.Chdir(filepath.Dir(filepath.Dir(filepath.Dir(repoDir))))
+
+	out, err := runCommand("--docker", "node:20", "--", "npm", "test")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Running commands inside containers of node:20")
+	assert.Contains(t, out, "1 OK, 0 skipped")
+
+	assert.Len(t, commands, 1)
+	assert.Equal(t, []string{"work/org/repo1", "docker", "run", "--rm", "-v", repoDir + ":/turbolift/repo"}, commands[0][:6])
+	assert.Contains(t, commands[0], "TURBOLIFT_REPO_DIR=/turbolift/repo")
+	assert.Equal(t, []string{"node:20", "npm", "test"}, commands[0][len(commands[0])-3:])
+}
+
+func TestItRunsShellCommandsInsideTheConfiguredContainer(t *testing.T) {
+	var commands [][]string
+	exec = executor.NewFakeExecutor(func(workingDir string, name string, args ...string) error {
+		commands = append(commands, append([]string{workingDir, name}, args...))
+		return nil
+	}, nil)
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+	testsupport.CreateConfigFile("docker:\n  image: golang:1.22\n")
+
+	_, err := runCommand("--shell", "--", "go vet ./... | tee vet.txt")
+	assert.NoError(t, err)
+
+	assert.Len(t, commands, 1)
+	assert.Equal(t, "docker", commands[0][1])
+	assert.Equal(t, []string{"golang:1.22", "sh", "-c", "go vet ./... | tee vet.txt"}, commands[0][len(commands[0])-4:])
+}
+
+func TestItCapturesOutputForEachRepo(t *testing.T) {
+	fakeExecutor := executor.NewFakeExecutor(nil, func(workingDir string, name string, args ...string) (string, error) {
+		if workingDir == "work/org/repo2" {
+			return "no go.mod\n", errors.New("synthetic error")
+		}
+		return "go 1.21\n", nil
+	})
+	exec = fakeExecutor
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+
+	out, err := runCommand("--capture", "results/{{.Repo}}.out", "--", "grep", "^go ", "go.mod")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Output of each execution has been captured to results/{{.Repo}}.out")
+
+	captured, err := os.ReadFile("results/org/repo1.out")
+	assert.NoError(t, err)
+	assert.Equal(t, "go 1.21\n", string(captured))
+	captured, err = os.ReadFile("results/org/repo2.out")
+	assert.NoError(t, err)
+	assert.Equal(t, "no go.mod\n", string(captured))
+
+	state, err := campaign.ReadState()
+	assert.NoError(t, err)
+	assert.Equal(t, "grep '^go ' go.mod", state.LastCapture.Command)
+	assert.Equal(t, []campaign.CaptureResult{
+		{Repo: "org/repo1", File: "results/org/repo1.out", ExitCode: 0},
+		{Repo: "org/repo2", File: "results/org/repo2.out", ExitCode: -1},
+	}, state.LastCapture.Results)
+}
+
+func TestItCapturesOutputInParallel(t *testing.T) {
+	fakeExecutor := executor.NewFakeExecutor(nil, func(workingDir string, name string, args ...string) (string, error) {
+		return workingDir + "\n", nil
+	})
+	exec = fakeExecutor
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2", "org/repo3")
+
+	_, err := runCommand("--workers", "2", "--capture", "results/{{.RepoName}}.out", "--", "pwd")
+	assert.NoError(t, err)
+
+	for _, repo := range []string{"repo1", "repo2", "repo3"} {
+		captured, err := os.ReadFile("results/" + repo + ".out")
+		assert.NoError(t, err)
+		assert.Equal(t, "work/org/"+repo+"\n", string(captured))
+	}
+	state, _ := campaign.ReadState()
+	assert.Len(t, state.LastCapture.Results, 3)
+}
+
+func TestItRejectsABadCaptureTemplate(t *testing.T) {
+	fakeExecutor := executor.NewAlwaysSucceedsFakeExecutor()
+	exec = fakeExecutor
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runCommand("--capture", "results/{{.Repo", "--", "pwd")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "unable to parse --capture template")
+
+	fakeExecutor.AssertCalledWith(t, [][]string{})
+}
+
+func TestItReducesWorkersWhenRateLimited(t *testing.T) {
+	fakeExecutor := executor.NewFakeExecutor(func(s string, s2 string, s3 ...string) error {
+		return errors.New("HTTP 403: API rate limit exceeded")
+	}, nil)
+	exec = fakeExecutor
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2", "org/repo3", "org/repo4")
+
+	out, err := runCommand("--workers", "4", "--", "some", "command")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Now running in up to 2 working copies at once")
+	assert.Contains(t, out, "Now running in up to 1 working copies at once")
+	assert.Contains(t, out, "0 OK, 0 skipped, 4 errored")
+}
+
 func TestHelpFlagReturnsUsage(t *testing.T) {
 	fakeExecutor := executor.NewAlwaysSucceedsFakeExecutor()
 	exec = fakeExecutor
@@ -204,12 +605,96 @@ func TestItCreatesLogFiles(t *testing.T) {
 	assert.NoError(t, err, "Expected the failure log file for org/repo2 to exist")
 }
 
+func TestItRunsCommandThroughShell(t *testing.T) {
+	fakeExecutor := executor.NewAlwaysSucceedsFakeExecutor()
+	exec = fakeExecutor
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+
+	out, err := runCommand("--shell", "--", "grep needle haystack.txt | wc -l > count.txt")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Executing { grep needle haystack.txt | wc -l > count.txt } in work/org/repo1")
+	assert.Contains(t, out, "2 OK, 0 skipped")
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", executor.Shell(), "-c", "grep needle haystack.txt | wc -l > count.txt"},
+		{"work/org/repo2", executor.Shell(), "-c", "grep needle haystack.txt | wc -l > count.txt"},
+	})
+}
+
+func TestItRunsScriptInEachWorkingCopy(t *testing.T) {
+	fakeExecutor := executor.NewAlwaysSucceedsFakeExecutor()
+	exec = fakeExecutor
+
+	tempDir := testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+	_ = os.WriteFile("script.sh", []byte("echo hello\n"), 0o644)
+	scriptPath, _ := filepath.EvalSymlinks(filepath.Join(tempDir, "script.sh"))
+	_ = os.Chdir(filepath.Dir(scriptPath))
+
+	out, err := runCommand("--script", "script.sh", "--", "arg1")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Executing { script.sh arg1 } in work/org/repo1")
+	assert.Contains(t, out, "2 OK, 0 skipped")
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", executor.Shell(), scriptPath, "arg1"},
+		{"work/org/repo2", executor.Shell(), scriptPath, "arg1"},
+	})
+}
+
+func TestItRunsExecutableScriptDirectly(t *testing.T) {
+	fakeExecutor := executor.NewAlwaysSucceedsFakeExecutor()
+	exec = fakeExecutor
+
+	tempDir := testsupport.PrepareTempCampaign(true, "org/repo1")
+	_ = os.WriteFile("script.py", []byte("#!/usr/bin/env python3\nprint('hello')\n"), 0o755)
+	scriptPath, _ := filepath.EvalSymlinks(filepath.Join(tempDir, "script.py"))
+	_ = os.Chdir(filepath.Dir(scriptPath))
+
+	out, err := runCommand("--script", "script.py")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "1 OK, 0 skipped")
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", scriptPath},
+	})
+}
+
+func TestItRejectsMissingScript(t *testing.T) {
+	fakeExecutor := executor.NewAlwaysSucceedsFakeExecutor()
+	exec = fakeExecutor
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	_, err := runCommand("--script", "does-not-exist.sh")
+	assert.Error(t, err)
+
+	fakeExecutor.AssertCalledWith(t, [][]string{})
+}
+
+func TestItRejectsShellAndScriptTogether(t *testing.T) {
+	fakeExecutor := executor.NewAlwaysSucceedsFakeExecutor()
+	exec = fakeExecutor
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+	_ = os.WriteFile("script.sh", []byte("echo hello\n"), 0o644)
+
+	_, err := runCommand("--shell", "--script", "script.sh", "--", "echo")
+	assert.EqualError(t, err, "--shell and --script cannot be used together")
+
+	fakeExecutor.AssertCalledWith(t, [][]string{})
+}
+
 func runCommand(args ...string) (string, error) {
+	return runCommandWithContext(context.Background(), args...)
+}
+
+func runCommandWithContext(ctx context.Context, args ...string) (string, error) {
 	cmd := NewForeachCmd()
 	outBuffer := bytes.NewBufferString("")
 	cmd.SetOut(outBuffer)
 	cmd.SetArgs(args)
-	err := cmd.Execute()
+	err := cmd.ExecuteContext(ctx)
 	if err != nil {
 		return outBuffer.String(), err
 	}
@@ -22,13 +22,19 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/skyscanner/turbolift/cmd/flags"
 	"github.com/skyscanner/turbolift/internal/colors"
+	"github.com/skyscanner/turbolift/internal/executor"
 	"github.com/skyscanner/turbolift/internal/logging"
 	"github.com/spf13/cobra"
 )
 
+var exec executor.Executor = executor.NewRealExecutor()
+
 var (
-	campaignName string
+	campaignName     string
+	campaignTemplate string
+	orgs             []string
 
 	//go:embed templates/.gitignore
 	gitignoreTemplate string
@@ -45,6 +51,8 @@ var (
 
 type TemplateVariables struct {
 	CampaignName string
+	// Orgs are the orgs whose repos are all included in the campaign
+	Orgs []string
 }
 
 func NewInitCmd() *cobra.Command {
@@ -56,6 +64,10 @@ func NewInitCmd() *cobra.Command {
 
 	cmd.Flags().StringVarP(&campaignName, "name", "n", "", "Campaign name")
 	_ = cmd.MarkFlagRequired("name")
+	cmd.Flags().StringVarP(&campaignTemplate, "template", "t", "", "Template to create the campaign from: the name of a directory in the templates directory, a path, or a git URL")
+	cmd.Flags().StringSliceVar(&orgs, "org", nil, "Include every repo in this org, or host/org, in the campaign, as found by turbolift clone. Can be given more than once.")
+	flags.MarkMakesChanges(cmd)
+
 	return cmd
 }
 
@@ -77,6 +89,7 @@ func run(c *cobra.Command, _ []string) {
 	createFilesActivity := logger.StartActivity("Creating initial files")
 	data := TemplateVariables{
 		CampaignName: campaignName,
+		Orgs:         orgs,
 	}
 
 	files := map[string]string{
@@ -94,6 +107,22 @@ func run(c *cobra.Command, _ []string) {
 	}
 	createFilesActivity.EndWithSuccess()
 
+	if campaignTemplate != "" {
+		applyTemplateActivity := logger.StartActivity("Applying template %s", campaignTemplate)
+		templateDir, cleanup, err := fetchTemplate(c.Context(), applyTemplateActivity.Writer(), campaignTemplate)
+		if err != nil {
+			applyTemplateActivity.EndWithFailure(err)
+			return
+		}
+		err = copyTemplate(templateDir, campaignName, data)
+		cleanup()
+		if err != nil {
+			applyTemplateActivity.EndWithFailure(err)
+			return
+		}
+		applyTemplateActivity.EndWithSuccess()
+	}
+
 	logger.Successf("turbolift init is done - next:\n")
 	logger.Println("\t1. Run", colors.Cyan("cd ", campaignName))
 	logger.Println("\t2. Update", colors.Cyan("repos.txt"), "with the names of the repos that need changing (either manually or using a tool to generate a list of repos)")
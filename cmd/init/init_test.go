@@ -16,10 +16,15 @@
 package init
 
 import (
-	"github.com/skyscanner/turbolift/internal/testsupport"
-	"github.com/stretchr/testify/assert"
+	"bytes"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+
+	"github.com/skyscanner/turbolift/internal/executor"
+	"github.com/skyscanner/turbolift/internal/testsupport"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestAllFilesAreCreated(t *testing.T) {
@@ -47,12 +52,95 @@ func TestTemplatedFilesHaveExpectedContent(t *testing.T) {
 	assert.Contains(t, string(readmeContents), "foo")
 }
 
-func runCommand() {
+func TestItIncludesTheReposOfOrgsGivenWithOrg(t *testing.T) {
+	testsupport.CreateAndEnterTempDirectory()
+	runCommand("--org", "acme", "--org", "github.example.com/platform")
+
+	reposContents, err := ioutil.ReadFile("foo/repos.txt")
+	assert.NoError(t, err)
+	assert.Contains(t, string(reposContents), "\norg:acme\norg:github.example.com/platform\n")
+}
+
+func TestItCopiesANamedTemplateOverTheDefaultFiles(t *testing.T) {
+	testsupport.CreateAndEnterTempDirectory()
+	templatesDir, _ := filepath.Abs("templates")
+	writeTemplate(filepath.Join(templatesDir, "bump-base-image"))
+	testsupport.Setenv(t, "TURBOLIFT_TEMPLATES", templatesDir)
+
+	runCommand("--template", "bump-base-image")
+
+	assertTemplateApplied(t)
+}
+
+func TestItClonesTemplatesGivenAsGitUrls(t *testing.T) {
+	testsupport.CreateAndEnterTempDirectory()
+	var cloneDir string
+	fakeExecutor := executor.NewFakeExecutor(func(_ string, _ string, args ...string) error {
+		cloneDir = args[len(args)-1]
+		writeTemplate(cloneDir)
+		return nil
+	}, func(_ string, _ string, _ ...string) (string, error) {
+		return "", nil
+	})
+	exec = fakeExecutor
+
+	runCommand("--template", "https://github.com/org/campaign-templates.git")
+
+	assertTemplateApplied(t)
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"", "git", "clone", "--depth", "1", "https://github.com/org/campaign-templates.git", cloneDir},
+	})
+	assert.NoDirExists(t, cloneDir, "the cloned template should have been removed")
+}
+
+func TestItFailsForUnknownTemplates(t *testing.T) {
+	testsupport.CreateAndEnterTempDirectory()
+	testsupport.Setenv(t, "TURBOLIFT_TEMPLATES", "templates")
+
+	out := runCommand("--template", "no-such-template")
+
+	assert.Contains(t, out, "no template called no-such-template")
+}
+
+func writeTemplate(dir string) {
+	files := map[string]string{
+		"README.md.tmpl":      "# Bump the base image for {{.CampaignName}}\n\nThis bumps the base image.\n",
+		"turbolift.yaml":      "hooks:\n  post-clone: hooks/post-clone.sh\n",
+		"hooks/post-clone.sh": "#!/bin/sh\necho \"${{ not a template }}\"\n",
+	}
+	for filename, content := range files {
+		path := filepath.Join(dir, filename)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			panic(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0755); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func assertTemplateApplied(t *testing.T) {
+	readme, _ := ioutil.ReadFile("foo/README.md")
+	assert.Equal(t, "# Bump the base image for foo\n\nThis bumps the base image.\n", string(readme))
+	assert.NoFileExists(t, "foo/README.md.tmpl")
+	assert.FileExists(t, "foo/turbolift.yaml")
+	assert.FileExists(t, "foo/repos.txt", "default files not in the template should still be created")
+
+	hook, _ := ioutil.ReadFile("foo/hooks/post-clone.sh")
+	assert.Equal(t, "#!/bin/sh\necho \"${{ not a template }}\"\n", string(hook), "files without the .tmpl suffix should be copied as they are")
+	info, _ := os.Stat("foo/hooks/post-clone.sh")
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm(), "scripts should stay executable")
+}
+
+func runCommand(extraArgs ...string) string {
 	cmd := NewInitCmd()
-	cmd.SetArgs([]string{"--name", "foo"})
+	outBuffer := bytes.NewBufferString("")
+	cmd.SetOut(outBuffer)
+	cmd.SetArgs(append([]string{"--name", "foo"}, extraArgs...))
 	err := cmd.Execute()
 
 	if err != nil {
 		panic(err)
 	}
+	return outBuffer.String()
 }
@@ -0,0 +1,134 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package init
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// templateSuffix marks the files of a campaign template that are filled in with the campaign's details when copied.
+// Other files, such as scripts that use {{ }} for their own purposes, are copied as they are.
+const templateSuffix = ".tmpl"
+
+// TemplatesDirectory is where named campaign templates are kept:
+// $TURBOLIFT_TEMPLATES if set, otherwise turbolift/templates in the user's config directory
+func TemplatesDirectory() string {
+	if dir := os.Getenv("TURBOLIFT_TEMPLATES"); dir != "" {
+		return dir
+	}
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(configDir, "turbolift", "templates")
+}
+
+// isGitUrl is true if a template is given as something to clone rather than a name or path
+func isGitUrl(template string) bool {
+	return strings.Contains(template, "://") || strings.HasPrefix(template, "git@") || strings.HasSuffix(template, ".git")
+}
+
+// fetchTemplate returns the directory holding a template given as a git URL, a path, or the name of a directory in
+// TemplatesDirectory. Templates that are cloned are put in a temporary directory, which cleanup removes.
+func fetchTemplate(ctx context.Context, output io.Writer, template string) (dir string, cleanup func(), err error) {
+	cleanup = func() {}
+
+	if isGitUrl(template) {
+		dir, err = ioutil.TempDir("", "turbolift-template-")
+		if err != nil {
+			return "", cleanup, fmt.Errorf("unable to create a directory to clone the template into: %w", err)
+		}
+		cleanup = func() { _ = os.RemoveAll(dir) }
+		if err := exec.Execute(ctx, output, "", "git", "clone", "--depth", "1", template, dir); err != nil {
+			cleanup()
+			return "", func() {}, fmt.Errorf("unable to clone template %s: %w", template, err)
+		}
+		return dir, cleanup, nil
+	}
+
+	if info, err := os.Stat(template); err == nil && info.IsDir() {
+		return template, cleanup, nil
+	}
+
+	templatesDir := TemplatesDirectory()
+	if templatesDir != "" && !strings.ContainsRune(template, filepath.Separator) {
+		named := filepath.Join(templatesDir, template)
+		if info, err := os.Stat(named); err == nil && info.IsDir() {
+			return named, cleanup, nil
+		}
+	}
+	return "", cleanup, fmt.Errorf("no template called %s in %s, and it is not a directory or git URL", template, templatesDir)
+}
+
+// copyTemplate copies the files of a template directory into the campaign directory, replacing any default files of
+// the same name. Files ending in .tmpl are filled in with data, and lose the suffix.
+func copyTemplate(templateDir string, campaignDir string, data interface{}) error {
+	return filepath.Walk(templateDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relativePath, err := filepath.Rel(templateDir, path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(filepath.Join(campaignDir, relativePath), os.ModeDir|0755)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("unable to read template file %s: %w", relativePath, err)
+		}
+		outputFilename := filepath.Join(campaignDir, relativePath)
+		if strings.HasSuffix(outputFilename, templateSuffix) {
+			outputFilename = strings.TrimSuffix(outputFilename, templateSuffix)
+			content, err = fillIn(relativePath, string(content), data)
+			if err != nil {
+				return err
+			}
+		}
+		if err := ioutil.WriteFile(outputFilename, content, info.Mode().Perm()); err != nil {
+			return fmt.Errorf("unable to write %s: %w", outputFilename, err)
+		}
+		// WriteFile leaves the mode of existing files alone, so make sure scripts stay executable
+		return os.Chmod(outputFilename, info.Mode().Perm())
+	})
+}
+
+func fillIn(name string, content string, data interface{}) ([]byte, error) {
+	parsedTemplate, err := template.New(name).Parse(content)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse template file %s: %w", name, err)
+	}
+	var filled strings.Builder
+	if err := parsedTemplate.Execute(&filled, data); err != nil {
+		return nil, fmt.Errorf("unable to fill in template file %s: %w", name, err)
+	}
+	return []byte(filled.String()), nil
+}
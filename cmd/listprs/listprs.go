@@ -0,0 +1,111 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package listprs
+
+import (
+	"github.com/fatih/color"
+	"github.com/rodaine/table"
+	"github.com/spf13/cobra"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/colors"
+	"github.com/skyscanner/turbolift/internal/logging"
+)
+
+var (
+	repoFile   string
+	branchName string
+	urlsOnly   bool
+)
+
+func NewListPRsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list-prs",
+		Short: "List the PRs recorded for the campaign",
+		Long: `List the PRs that turbolift create-prs has created or found for the campaign's repos, as recorded in the
+campaign state. No API calls are made, so the list is instant even for large campaigns.`,
+		Run: run,
+	}
+
+	cmd.Flags().StringVar(&repoFile, "repos", "repos.txt", "A file containing a list of repositories to list the PRs of.")
+	cmd.Flags().StringVar(&branchName, "branch", "", "The branch to use for the campaign (defaults to branch in turbolift.yaml, or the campaign name)")
+	cmd.Flags().BoolVar(&urlsOnly, "urls", false, "Prints only the URL of each PR, one per line, for use in scripts")
+
+	return cmd
+}
+
+func run(c *cobra.Command, _ []string) {
+	logger := logging.NewLogger(c)
+
+	options := campaign.NewCampaignOptions()
+	options.RepoFilename = repoFile
+	options.BranchName = branchName
+
+	if urlsOnly {
+		dir, state, err := readCampaign(options)
+		if err != nil {
+			logger.Errorf("%s", err)
+			return
+		}
+		for _, repo := range dir.Repos {
+			if pr, ok := state.TrackedPR(repo.FullRepoName, dir.BranchName); ok && pr.Url != "" {
+				logger.Println(pr.Url)
+			}
+		}
+		return
+	}
+
+	readCampaignActivity := logger.StartActivity("Reading campaign data (%s)", repoFile)
+	dir, state, err := readCampaign(options)
+	if err != nil {
+		readCampaignActivity.EndWithFailure(err)
+		return
+	}
+	readCampaignActivity.EndWithSuccess()
+
+	logger.Println()
+
+	prsTable := table.New("Repository", "Number", "URL")
+	prsTable.WithHeaderFormatter(color.New(color.Underline).SprintfFunc())
+	prsTable.WithFirstColumnFormatter(color.New(color.FgCyan).SprintfFunc())
+	prsTable.WithWriter(logger.Writer())
+
+	recorded := 0
+	for _, repo := range dir.Repos {
+		pr, ok := state.TrackedPR(repo.FullRepoName, dir.BranchName)
+		if !ok {
+			continue
+		}
+		recorded++
+		prsTable.AddRow(repo.FullRepoName, pr.Number, pr.Url)
+	}
+	prsTable.Print()
+
+	logger.Println()
+	logger.Successf("turbolift list-prs completed %s(%s of %d repos have a recorded PR)\n", colors.Normal(), colors.Green(recorded), len(dir.Repos))
+}
+
+func readCampaign(options *campaign.CampaignOptions) (*campaign.Campaign, *campaign.State, error) {
+	dir, err := campaign.OpenCampaign(options)
+	if err != nil {
+		return nil, nil, err
+	}
+	state, err := campaign.ReadState()
+	if err != nil {
+		return nil, nil, err
+	}
+	return dir, state, nil
+}
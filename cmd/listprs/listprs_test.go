@@ -0,0 +1,79 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package listprs
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/testsupport"
+)
+
+func init() {
+	// disable output colouring so that strings we want to do 'Contains' checks on do not have ANSI escape sequences in IDEs
+	_ = os.Setenv("NO_COLOR", "1")
+}
+
+func TestItListsTheRecordedPrsOfTheCampaignsRepos(t *testing.T) {
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2", "org/repo3")
+	recordPRs(
+		campaign.TrackedPR{Repo: "org/repo1", Branch: testsupport.Pwd(), Number: 12, Url: "https://github.com/org/repo1/pull/12"},
+		campaign.TrackedPR{Repo: "org/repo2", Branch: "some-other-branch", Number: 5, Url: "https://github.com/org/repo2/pull/5"},
+		campaign.TrackedPR{Repo: "org/repo3", Branch: testsupport.Pwd(), Number: 3, Url: "https://github.com/org/repo3/pull/3"},
+	)
+
+	out, err := runCommand()
+	assert.NoError(t, err)
+	assert.Regexp(t, `org/repo1\s+12\s+https://github.com/org/repo1/pull/12`, out)
+	assert.Regexp(t, `org/repo3\s+3\s+https://github.com/org/repo3/pull/3`, out)
+	assert.NotContains(t, out, "org/repo2")
+	assert.Contains(t, out, "2 of 3 repos have a recorded PR")
+}
+
+func TestItPrintsOnlyTheUrls(t *testing.T) {
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+	recordPRs(
+		campaign.TrackedPR{Repo: "org/repo2", Branch: testsupport.Pwd(), Number: 7, Url: "https://github.com/org/repo2/pull/7"},
+		campaign.TrackedPR{Repo: "org/repo1", Branch: testsupport.Pwd(), Number: 4, Url: "https://github.com/org/repo1/pull/4"},
+	)
+
+	out, err := runCommand("--urls")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://github.com/org/repo1/pull/4\nhttps://github.com/org/repo2/pull/7\n", out)
+}
+
+func recordPRs(prs ...campaign.TrackedPR) {
+	state, _ := campaign.ReadState()
+	for _, pr := range prs {
+		state.RecordPR(pr)
+	}
+	if err := state.Save(); err != nil {
+		panic(err)
+	}
+}
+
+func runCommand(args ...string) (string, error) {
+	cmd := NewListPRsCmd()
+	outBuffer := bytes.NewBufferString("")
+	cmd.SetOut(outBuffer)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return outBuffer.String(), err
+}
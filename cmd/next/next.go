@@ -0,0 +1,414 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package next
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/skyscanner/turbolift/cmd/flags"
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/colors"
+	"github.com/skyscanner/turbolift/internal/git"
+	"github.com/skyscanner/turbolift/internal/github"
+	"github.com/skyscanner/turbolift/internal/interrupt"
+	"github.com/skyscanner/turbolift/internal/logging"
+)
+
+var (
+	gh github.GitHub = github.NewRealGitHub()
+	g  git.Git       = git.NewRealGit()
+)
+
+var (
+	repoFile   string
+	branchName string
+)
+
+// bucketsDirectory is where a repos file is written for each bucket, so that the suggested commands only run over its repos
+var bucketsDirectory = filepath.Join(campaign.StateDirectory, "next")
+
+// shownRepos is how many of a bucket's repos are named in its to-do item
+const shownRepos = 5
+
+func NewNextCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "next",
+		Short: "Show what is left to do in the campaign, and the commands to do it with",
+		Run:   run,
+	}
+
+	cmd.Flags().StringVar(&repoFile, "repos", "repos.txt", "A file containing a list of repositories to clone.")
+	cmd.Flags().StringVar(&branchName, "branch", "", "The branch to use for the campaign (defaults to branch in turbolift.yaml, or the campaign name)")
+
+	return cmd
+}
+
+// kind is something that needs doing to a repo before its PR can be merged. Kinds are listed in the order that they
+// should be dealt with, blockers first.
+type kind int
+
+const (
+	authFailed kind = iota
+	checkFailed
+	conflicting
+	behind
+	checksFailing
+	changesRequested
+	notCloned
+	noChanges
+	noPR
+	readyToMerge
+	awaitingReview
+	checksPending
+	closed
+	gone
+)
+
+type todo struct {
+	// key names the bucket's repos file
+	key         string
+	description string
+	// advice says what to do, if the commands do not do it all themselves
+	advice string
+	// commands are the turbolift commands that deal with the bucket, in which %s stands for its repos file
+	commands []string
+}
+
+var todos = map[kind]todo{
+	authFailed: {
+		key:         "auth-failed",
+		description: "errored on authentication while checking their PRs",
+		commands:    []string{"turbolift doctor"},
+		advice:      "Check your GitHub credentials",
+	},
+	checkFailed: {
+		key:         "check-failed",
+		description: "could not be checked",
+		commands:    []string{"turbolift next --repos %s"},
+		advice:      "See the errors above, then try again",
+	},
+	conflicting: {
+		key:         "conflicting",
+		description: "need manual conflict fixes",
+		commands:    []string{"turbolift foreach --repos %s --shell -- 'git pull --no-rebase origin $TURBOLIFT_DEFAULT_BRANCH'"},
+		advice:      "Merge the base branch into each PR's branch, then fix the conflicts, commit them and push",
+	},
+	behind: {
+		key:         "behind",
+		description: "need rebasing onto their base branch",
+		commands:    []string{"turbolift update-prs --update-branch --repos %s"},
+	},
+	checksFailing: {
+		key:         "checks-failing",
+		description: "have failing checks",
+		commands:    []string{"turbolift foreach --repos %s -- gh pr checks"},
+		advice:      "See which checks failed, then push fixes",
+	},
+	changesRequested: {
+		key:         "changes-requested",
+		description: "have had changes requested by reviewers",
+		commands:    []string{"turbolift foreach --repos %s -- gh pr view --comments"},
+		advice:      "Read the feedback, then push changes",
+	},
+	notCloned: {
+		key:         "not-cloned",
+		description: "have not been cloned",
+		commands:    []string{"turbolift clone --repos %s"},
+	},
+	noChanges: {
+		key:         "no-changes",
+		description: "have no changes committed",
+		commands:    []string{"turbolift commit --repos %s --message \"...\""},
+		advice:      "Make the campaign's changes, or drop repos that need none from the repos file, then commit",
+	},
+	noPR: {
+		key:         "no-pr",
+		description: "have changes committed but no PR",
+		commands:    []string{"turbolift create-prs --repos %s"},
+	},
+	readyToMerge: {
+		key:         "ready-to-merge",
+		description: "are approved and passing their checks, ready to merge",
+		commands:    []string{"turbolift update-prs --enable-auto-merge --repos %s"},
+	},
+	awaitingReview: {
+		key:         "awaiting-review",
+		description: "are awaiting review",
+		commands:    []string{"turbolift pr-status --list --repos %s"},
+		advice:      "Nudge the reviewers, or see the PRs",
+	},
+	checksPending: {
+		key:         "checks-pending",
+		description: "are waiting for their checks to finish",
+		commands:    []string{"turbolift update-prs --wait-checks --repos %s"},
+	},
+	closed: {
+		key:         "closed",
+		description: "have PRs that were closed without being merged",
+		commands:    []string{"turbolift update-prs --reopen --repos %s"},
+		advice:      "Reopen them if they were closed by mistake",
+	},
+	gone: {
+		key:         "gone",
+		description: "have been deleted, or you no longer have access to them",
+		commands:    []string{"turbolift pr-status"},
+		advice:      "Drop them from the repos file",
+	},
+}
+
+// bucket is the repos with the same thing left to do. Repos awaiting review are bucketed by their reviewer.
+type bucket struct {
+	kind     kind
+	reviewer string
+	repos    []campaign.Repo
+}
+
+func (b *bucket) key() string {
+	key := todos[b.kind].key
+	if b.reviewer != "" {
+		key += "-" + nonAlphanumeric.ReplaceAllString(strings.ToLower(b.reviewer), "-")
+	}
+	return key
+}
+
+func (b *bucket) description() string {
+	description := todos[b.kind].description
+	if b.reviewer != "" {
+		description += " from " + b.reviewer
+	}
+	return description
+}
+
+var nonAlphanumeric = regexp.MustCompile(`[^a-z0-9]+`)
+
+func run(c *cobra.Command, _ []string) {
+	ctx := c.Context()
+	logger := logging.NewLogger(c)
+
+	readCampaignActivity := logger.StartActivity("Reading campaign data (%s)", repoFile)
+	options := campaign.NewCampaignOptions()
+	options.RepoFilename = repoFile
+	options.BranchName = branchName
+	dir, err := campaign.OpenCampaign(options)
+	if err != nil {
+		readCampaignActivity.EndWithFailure(err)
+		return
+	}
+	readCampaignActivity.EndWithSuccess()
+
+	if err := github.RememberTrackedPRs(gh); err != nil {
+		logger.Warnf("Unable to read the PRs recorded in the campaign state: %s", err)
+	}
+	forge := github.WithCache(gh, flags.Cache())
+
+	buckets := map[string]*bucket{}
+	add := func(repo campaign.Repo, k kind, reviewer string) {
+		b := &bucket{kind: k, reviewer: reviewer}
+		if existing, ok := buckets[b.key()]; ok {
+			b = existing
+		} else {
+			buckets[b.key()] = b
+		}
+		b.repos = append(b.repos, repo)
+	}
+
+	merged := 0
+	for i, repo := range dir.Repos {
+		if interrupt.Requested(ctx) {
+			// a partial to-do list would make the campaign look closer to done than it is
+			logger.Warnf("Interrupted, so not checking the remaining %d repos", len(dir.Repos)-i)
+			return
+		}
+		repoDirPath := repo.FullRepoPath()
+
+		checkActivity := logger.StartActivity("Checking %s", repo.FullRepoName)
+
+		if _, err := os.Stat(repoDirPath); os.IsNotExist(err) {
+			checkActivity.EndWithWarningf("Directory %s does not exist - has it been cloned?", repoDirPath)
+			add(repo, notCloned, "")
+			continue
+		}
+
+		prStatus, err := forge.GetPR(ctx, checkActivity.Writer(), repoDirPath, dir.BranchName)
+		var noPRFound *github.NoPRFoundError
+		switch {
+		case github.IsRepoGone(err):
+			checkActivity.EndWithWarning(err)
+			add(repo, gone, "")
+			continue
+		case errors.As(err, &noPRFound):
+			committed, err := g.IsAheadOfDefaultBranch(ctx, checkActivity.Writer(), repoDirPath, repo.DefaultBranch)
+			if err != nil {
+				checkActivity.EndWithFailure(err)
+				add(repo, checkFailed, "")
+				continue
+			}
+			checkActivity.EndWithSuccess()
+			if committed {
+				add(repo, noPR, "")
+			} else {
+				add(repo, noChanges, "")
+			}
+			continue
+		case err != nil:
+			checkActivity.EndWithFailure(err)
+			if isAuthError(err) {
+				add(repo, authFailed, "")
+			} else {
+				add(repo, checkFailed, "")
+			}
+			continue
+		}
+		checkActivity.EndWithSuccess()
+
+		if prStatus.State == "MERGED" {
+			merged++
+			continue
+		}
+		k := classify(prStatus)
+		if k != awaitingReview {
+			add(repo, k, "")
+			continue
+		}
+		if len(prStatus.ReviewRequests) == 0 {
+			add(repo, awaitingReview, "")
+		}
+		for _, request := range prStatus.ReviewRequests {
+			add(repo, awaitingReview, request.Reviewer())
+		}
+	}
+
+	logger.Successf("turbolift next completed\n")
+	github.LogApiUsage(ctx, gh, logger, dir.Hosts())
+	logger.Println()
+
+	if len(buckets) == 0 {
+		logger.Successf("Nothing left to do: all %d repos have been merged", merged)
+		return
+	}
+
+	if err := writeBucketsFiles(buckets); err != nil {
+		logger.Errorf("%s", err)
+		return
+	}
+
+	logger.Printf("What's left to do on %s (%d of %d repos merged):\n", dir.Name, merged, len(dir.Repos))
+	for i, b := range sortedBuckets(buckets) {
+		todo := todos[b.kind]
+		logger.Printf("%2d. %s %s: %s", i+1, colors.Red(len(b.repos), " repos"), b.description(), repoNames(b.repos))
+		if todo.advice != "" {
+			logger.Printf("    %s:", todo.advice)
+		}
+		reposFile := filepath.Join(bucketsDirectory, b.key()+".json")
+		for _, command := range todo.commands {
+			if strings.Contains(command, "%s") {
+				command = fmt.Sprintf(command, reposFile)
+			}
+			logger.Printf("    %s", colors.Cyan(command))
+		}
+	}
+}
+
+// classify finds what is left to do for an open or closed PR. Problems that stop a PR from being merged come first,
+// then anything waiting on other people.
+func classify(pr *github.PrStatus) kind {
+	if pr.State == "CLOSED" {
+		return closed
+	}
+
+	checks := github.ChecksStatus(pr.StatusCheckRollup)
+	switch {
+	case pr.Mergeable == "CONFLICTING":
+		return conflicting
+	case pr.MergeStateStatus == "BEHIND":
+		return behind
+	case checks == "FAILURE":
+		return checksFailing
+	case pr.ReviewDecision == "CHANGES_REQUESTED":
+		return changesRequested
+	case pr.ReviewDecision == "REVIEW_REQUIRED":
+		return awaitingReview
+	case checks == "PENDING" || checks == github.ChecksAwaitingDeploymentApproval:
+		return checksPending
+	default:
+		return readyToMerge
+	}
+}
+
+// isAuthError is true if gh failed because the user is not logged in, or their token has been rejected
+func isAuthError(err error) bool {
+	message := strings.ToLower(err.Error())
+	for _, symptom := range []string{"http 401", "bad credentials", "gh auth login", "authentication"} {
+		if strings.Contains(message, symptom) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeBucketsFiles replaces the repos files from the last run with one for each bucket
+func writeBucketsFiles(buckets map[string]*bucket) error {
+	if err := os.RemoveAll(bucketsDirectory); err != nil {
+		return fmt.Errorf("unable to remove %s: %w", bucketsDirectory, err)
+	}
+	if err := os.MkdirAll(bucketsDirectory, os.ModeDir|0o755); err != nil {
+		return fmt.Errorf("unable to create %s: %w", bucketsDirectory, err)
+	}
+	for key, b := range buckets {
+		if err := campaign.WriteReposFile(filepath.Join(bucketsDirectory, key+".json"), b.repos); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sortedBuckets puts the buckets in priority order, with the reviewers with the most repos to review first
+func sortedBuckets(buckets map[string]*bucket) []*bucket {
+	var sorted []*bucket
+	for _, b := range buckets {
+		sorted = append(sorted, b)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].kind != sorted[j].kind {
+			return sorted[i].kind < sorted[j].kind
+		}
+		if len(sorted[i].repos) != len(sorted[j].repos) {
+			return len(sorted[i].repos) > len(sorted[j].repos)
+		}
+		return sorted[i].reviewer < sorted[j].reviewer
+	})
+	return sorted
+}
+
+func repoNames(repos []campaign.Repo) string {
+	var names []string
+	for i, repo := range repos {
+		if i == shownRepos {
+			names = append(names, fmt.Sprintf("and %d more", len(repos)-shownRepos))
+			break
+		}
+		names = append(names, repo.FullRepoName)
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,155 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package next
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/git"
+	"github.com/skyscanner/turbolift/internal/github"
+	"github.com/skyscanner/turbolift/internal/testsupport"
+)
+
+func init() {
+	// disable output colouring so that strings we want to do 'Contains' checks on do not have ANSI escape sequences in IDEs
+	_ = os.Setenv("NO_COLOR", "1")
+}
+
+func TestItListsWhatIsLeftToDoInPriorityOrder(t *testing.T) {
+	prepareFakes()
+	testsupport.PrepareTempCampaign(true, "org/merged", "org/conflicting", "org/behind1", "org/behind2", "org/review1",
+		"org/review2", "org/committed", "org/unchanged", "org/authError", "org/notCloned")
+	_ = os.Remove("work/org/notCloned")
+
+	out, err := runCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "What's left to do on "+testsupport.Pwd()+" (1 of 10 repos merged):")
+
+	expected := []string{
+		" 1. 1 repos errored on authentication while checking their PRs: org/authError",
+		"    turbolift doctor",
+		" 2. 1 repos need manual conflict fixes: org/conflicting",
+		"    turbolift foreach --repos .turbolift-state/next/conflicting.json --shell -- 'git pull --no-rebase origin $TURBOLIFT_DEFAULT_BRANCH'",
+		" 3. 2 repos need rebasing onto their base branch: org/behind1, org/behind2",
+		"    turbolift update-prs --update-branch --repos .turbolift-state/next/behind.json",
+		" 4. 1 repos have not been cloned: org/notCloned",
+		" 5. 1 repos have no changes committed: org/unchanged",
+		" 6. 1 repos have changes committed but no PR: org/committed",
+		"    turbolift create-prs --repos .turbolift-state/next/no-pr.json",
+		" 7. 2 repos are awaiting review from org/team-x: org/review1, org/review2",
+		"    turbolift pr-status --list --repos .turbolift-state/next/awaiting-review-org-team-x.json",
+		" 8. 1 repos are awaiting review from someone: org/review2",
+	}
+	last := -1
+	for _, line := range expected {
+		index := bytes.Index([]byte(out), []byte(line))
+		assert.Greater(t, index, last, "expected %q after the previous line in:\n%s", line, out)
+		last = index
+	}
+	assert.NotContains(t, out, "org/merged:")
+
+	behind, err := os.ReadFile(".turbolift-state/next/behind.json")
+	assert.NoError(t, err)
+	assert.Contains(t, string(behind), `"repo": "org/behind1"`)
+	assert.Contains(t, string(behind), `"repo": "org/behind2"`)
+}
+
+func TestItReplacesTheReposFilesFromTheLastRun(t *testing.T) {
+	prepareFakes()
+	testsupport.PrepareTempCampaign(true, "org/merged")
+	_ = os.MkdirAll(".turbolift-state/next", 0o755)
+	_ = os.WriteFile(".turbolift-state/next/behind.json", []byte("[]"), 0o644)
+
+	out, err := runCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Nothing left to do: all 1 repos have been merged")
+
+	_, err = os.Stat(".turbolift-state/next/behind.json")
+	assert.NoError(t, err, "nothing to do, so the files from the last run are left alone")
+
+	testsupport.PrepareTempCampaign(true, "org/committed")
+	_ = os.MkdirAll(".turbolift-state/next", 0o755)
+	_ = os.WriteFile(".turbolift-state/next/behind.json", []byte("[]"), 0o644)
+
+	_, err = runCommand()
+	assert.NoError(t, err)
+	_, err = os.Stat(".turbolift-state/next/behind.json")
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(".turbolift-state/next/no-pr.json")
+	assert.NoError(t, err)
+}
+
+func TestItClassifiesOpenPrs(t *testing.T) {
+	failing := []github.StatusCheckRollup{{State: "FAILURE"}}
+	pending := []github.StatusCheckRollup{{State: "PENDING"}}
+
+	assert.Equal(t, closed, classify(&github.PrStatus{State: "CLOSED", Mergeable: "CONFLICTING"}))
+	assert.Equal(t, conflicting, classify(&github.PrStatus{State: "OPEN", Mergeable: "CONFLICTING", StatusCheckRollup: failing}))
+	assert.Equal(t, behind, classify(&github.PrStatus{State: "OPEN", MergeStateStatus: "BEHIND", StatusCheckRollup: failing}))
+	assert.Equal(t, checksFailing, classify(&github.PrStatus{State: "OPEN", ReviewDecision: "CHANGES_REQUESTED", StatusCheckRollup: failing}))
+	assert.Equal(t, changesRequested, classify(&github.PrStatus{State: "OPEN", ReviewDecision: "CHANGES_REQUESTED"}))
+	assert.Equal(t, awaitingReview, classify(&github.PrStatus{State: "OPEN", ReviewDecision: "REVIEW_REQUIRED", StatusCheckRollup: pending}))
+	assert.Equal(t, checksPending, classify(&github.PrStatus{State: "OPEN", ReviewDecision: "APPROVED", StatusCheckRollup: pending}))
+	assert.Equal(t, readyToMerge, classify(&github.PrStatus{State: "OPEN", ReviewDecision: "APPROVED"}))
+}
+
+func TestItShortensLongListsOfRepos(t *testing.T) {
+	var repos []campaign.Repo
+	for _, name := range []string{"org/a", "org/b", "org/c", "org/d", "org/e", "org/f", "org/g"} {
+		repos = append(repos, campaign.Repo{FullRepoName: name})
+	}
+	assert.Equal(t, "org/a, org/b, org/c, org/d, org/e, and 2 more", repoNames(repos))
+}
+
+func prepareFakes() {
+	prs := map[string]*github.PrStatus{
+		"work/org/merged":      {State: "MERGED"},
+		"work/org/conflicting": {State: "OPEN", Mergeable: "CONFLICTING"},
+		"work/org/behind1":     {State: "OPEN", MergeStateStatus: "BEHIND"},
+		"work/org/behind2":     {State: "OPEN", MergeStateStatus: "BEHIND"},
+		"work/org/review1": {State: "OPEN", ReviewDecision: "REVIEW_REQUIRED",
+			ReviewRequests: []github.ReviewRequest{{Slug: "org/team-x"}}},
+		"work/org/review2": {State: "OPEN", ReviewDecision: "REVIEW_REQUIRED",
+			ReviewRequests: []github.ReviewRequest{{Slug: "org/team-x"}, {Login: "someone"}}},
+	}
+	gh = github.NewFakeGitHub(nil, func(workingDir string) (interface{}, error) {
+		if pr, ok := prs[workingDir]; ok {
+			return pr, nil
+		}
+		if workingDir == "work/org/authError" {
+			return nil, errors.New("error: exit status 4. Stderr: To get started with GitHub CLI, please run:  gh auth login")
+		}
+		return nil, &github.NoPRFoundError{Path: workingDir}
+	})
+	g = git.NewFakeGit(func(_ io.Writer, call []string) (bool, error) {
+		return call[1] != "work/org/unchanged", nil
+	})
+}
+
+func runCommand() (string, error) {
+	cmd := NewNextCmd()
+	outBuffer := bytes.NewBufferString("")
+	cmd.SetOut(outBuffer)
+	err := cmd.Execute()
+	return outBuffer.String(), err
+}
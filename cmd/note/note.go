@@ -0,0 +1,186 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package note
+
+import (
+	"errors"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/rodaine/table"
+	"github.com/spf13/cobra"
+
+	"github.com/skyscanner/turbolift/cmd/flags"
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/colors"
+	"github.com/skyscanner/turbolift/internal/logging"
+)
+
+var now = time.Now
+
+var (
+	repoFile  string
+	wontDo    bool
+	clearNote bool
+)
+
+func NewNoteCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "note [REPO [NOTE]]",
+		Short: "Attach a note to a repo in the campaign, or mark it won't do",
+		Long: `Attach a note to a repo in the campaign, such as why its PR is stuck. Notes are recorded
+in the campaign state, and shown by turbolift pr-status --list and turbolift report.
+
+With --wont-do, the repo is marked as one the campaign will not be done in, with the note
+as the reason. Repos marked won't do are left out of every command and of the
+campaign's counts, until the note is removed with --clear.
+
+With no REPO, the notes of every repo are listed.`,
+		Example: `turbolift note org/repo "Waiting on the payments team to upgrade their base image"
+turbolift note org/legacy "Being decommissioned next quarter" --wont-do
+turbolift note org/legacy --clear
+turbolift note`,
+		Args: cobra.MaximumNArgs(2),
+		RunE: runE,
+	}
+
+	cmd.Flags().StringVar(&repoFile, "repos", "repos.txt", "A file containing a list of repositories to clone.")
+	cmd.Flags().BoolVar(&wontDo, "wont-do", false, "Mark the repo as won't do, so that it is left out of the campaign's commands and counts")
+	cmd.Flags().BoolVar(&clearNote, "clear", false, "Remove the repo's note, and bring it back into the campaign if it was marked won't do")
+
+	return cmd
+}
+
+func runE(c *cobra.Command, args []string) error {
+	logger := logging.NewLogger(c)
+
+	if len(args) == 0 && (wontDo || clearNote) {
+		return errors.New("a REPO is required with --wont-do or --clear")
+	}
+	if clearNote && (wontDo || len(args) > 1) {
+		return errors.New("--clear cannot be given a NOTE or used with --wont-do")
+	}
+	if len(args) == 1 && !wontDo && !clearNote {
+		return errors.New("a NOTE is required, unless using --wont-do or --clear")
+	}
+	if len(args) > 0 {
+		if err := flags.CheckReadOnly("turbolift note"); err != nil {
+			c.SilenceUsage = true
+			return err
+		}
+	}
+
+	readCampaignActivity := logger.StartActivity("Reading campaign data (%s)", repoFile)
+	options := campaign.NewCampaignOptions()
+	options.RepoFilename = repoFile
+	dir, err := campaign.OpenCampaign(options)
+	if err != nil {
+		readCampaignActivity.EndWithFailure(err)
+		return nil
+	}
+	state, err := campaign.ReadState()
+	if err != nil {
+		readCampaignActivity.EndWithFailure(err)
+		return nil
+	}
+	readCampaignActivity.EndWithSuccess()
+
+	if len(args) == 0 {
+		listNotes(logger, dir, state)
+		return nil
+	}
+
+	repo := args[0]
+	if !inCampaign(dir, repo) {
+		logger.Errorf("%s is not one of the repos in %s", repo, repoFile)
+		return nil
+	}
+
+	if clearNote {
+		if !state.RemoveNote(repo) {
+			logger.Warnf("%s has no note to remove", repo)
+			return nil
+		}
+		if err := state.Save(); err != nil {
+			logger.Errorf("%s", err)
+			return nil
+		}
+		logger.Successf("turbolift note completed %s(note removed from %s)\n", colors.Normal(), repo)
+		return nil
+	}
+
+	note, _ := state.Note(repo)
+	note.Repo = repo
+	if len(args) > 1 {
+		note.Note = args[1]
+	}
+	// a note can be updated without bringing a repo marked won't do back into the campaign
+	note.WontDo = note.WontDo || wontDo
+	note.Updated = now().UTC()
+	state.SetNote(note)
+	if err := state.Save(); err != nil {
+		logger.Errorf("%s", err)
+		return nil
+	}
+
+	if note.WontDo {
+		logger.Successf("turbolift note completed %s(%s marked won't do, so it will be left out of the campaign)\n", colors.Normal(), repo)
+	} else {
+		logger.Successf("turbolift note completed %s(note attached to %s)\n", colors.Normal(), repo)
+	}
+	return nil
+}
+
+// inCampaign is true if the repo is listed in the repos file, including if it has been marked won't do
+func inCampaign(dir *campaign.Campaign, fullRepoName string) bool {
+	for _, repos := range [][]campaign.Repo{dir.Repos, dir.WontDo} {
+		for _, repo := range repos {
+			if repo.FullRepoName == fullRepoName {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func listNotes(logger *logging.Logger, dir *campaign.Campaign, state *campaign.State) {
+	logger.Println()
+
+	notesTable := table.New("Repository", "Won't do", "Note", "Updated")
+	notesTable.WithHeaderFormatter(color.New(color.Underline).SprintfFunc())
+	notesTable.WithFirstColumnFormatter(color.New(color.FgCyan).SprintfFunc())
+	notesTable.WithWriter(logger.Writer())
+
+	noted := 0
+	for _, repos := range [][]campaign.Repo{dir.Repos, dir.WontDo} {
+		for _, repo := range repos {
+			note, ok := state.Note(repo.FullRepoName)
+			if !ok {
+				continue
+			}
+			noted++
+			marked := ""
+			if note.WontDo {
+				marked = "yes"
+			}
+			notesTable.AddRow(repo.FullRepoName, marked, note.Note, note.Updated.Local().Format("2006-01-02"))
+		}
+	}
+	notesTable.Print()
+
+	logger.Println()
+	logger.Successf("turbolift note completed %s(%s repos have notes, %s of them marked won't do)\n", colors.Normal(), colors.Green(noted), colors.Yellow(len(dir.WontDo)))
+}
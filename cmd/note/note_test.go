@@ -0,0 +1,132 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package note
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/testsupport"
+)
+
+func init() {
+	// disable output colouring so that strings we want to do 'Contains' checks on do not have ANSI escape sequences in IDEs
+	_ = os.Setenv("NO_COLOR", "1")
+}
+
+var fixedNow = time.Date(2021, 10, 1, 9, 0, 0, 0, time.UTC)
+
+func TestItAttachesANoteToARepo(t *testing.T) {
+	now = func() time.Time { return fixedNow }
+	testsupport.PrepareTempCampaign(false, "org/repo1", "org/repo2")
+
+	out, err := runCommand("org/repo1", "waiting on team X")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "note attached to org/repo1")
+
+	state, _ := campaign.ReadState()
+	assert.Equal(t, []campaign.RepoNote{{Repo: "org/repo1", Note: "waiting on team X", Updated: fixedNow}}, state.Notes)
+
+	dir, _ := campaign.OpenCampaign(campaign.NewCampaignOptions())
+	assert.Len(t, dir.Repos, 2)
+}
+
+func TestItMarksARepoWontDoAndLeavesItOutOfTheCampaign(t *testing.T) {
+	now = func() time.Time { return fixedNow }
+	testsupport.PrepareTempCampaign(false, "org/repo1", "org/repo2")
+
+	out, err := runCommand("org/repo2", "being decommissioned", "--wont-do")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "org/repo2 marked won't do")
+
+	dir, _ := campaign.OpenCampaign(campaign.NewCampaignOptions())
+	assert.Equal(t, []string{"org/repo1"}, repoNames(dir.Repos))
+	assert.Equal(t, []string{"org/repo2"}, repoNames(dir.WontDo))
+
+	// updating the note keeps the repo marked won't do
+	_, err = runCommand("org/repo2", "decommissioned in Q3")
+	assert.NoError(t, err)
+	state, _ := campaign.ReadState()
+	assert.True(t, state.IsWontDo("org/repo2"))
+
+	out, err = runCommand("org/repo2", "--clear")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "note removed from org/repo2")
+
+	dir, _ = campaign.OpenCampaign(campaign.NewCampaignOptions())
+	assert.Equal(t, []string{"org/repo1", "org/repo2"}, repoNames(dir.Repos))
+}
+
+func TestItListsNotes(t *testing.T) {
+	now = func() time.Time { return fixedNow }
+	testsupport.PrepareTempCampaign(false, "org/repo1", "org/repo2", "org/repo3")
+
+	_, _ = runCommand("org/repo1", "waiting on team X")
+	_, _ = runCommand("org/repo3", "archived soon", "--wont-do")
+
+	out, err := runCommand()
+	assert.NoError(t, err)
+	assert.Regexp(t, `org/repo1\s+waiting on team X\s+2021-10-01`, out)
+	assert.Regexp(t, `org/repo3\s+yes\s+archived soon`, out)
+	assert.NotContains(t, out, "org/repo2")
+	assert.Contains(t, out, "2 repos have notes, 1 of them marked won't do")
+}
+
+func TestItRejectsReposNotInTheCampaign(t *testing.T) {
+	testsupport.PrepareTempCampaign(false, "org/repo1")
+
+	out, err := runCommand("org/other", "a note")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "org/other is not one of the repos in repos.txt")
+
+	state, _ := campaign.ReadState()
+	assert.Empty(t, state.Notes)
+}
+
+func TestItRequiresANoteUnlessMarkingWontDoOrClearing(t *testing.T) {
+	testsupport.PrepareTempCampaign(false, "org/repo1")
+
+	_, err := runCommand("org/repo1")
+	assert.EqualError(t, err, "a NOTE is required, unless using --wont-do or --clear")
+
+	_, err = runCommand("--clear")
+	assert.EqualError(t, err, "a REPO is required with --wont-do or --clear")
+
+	_, err = runCommand("org/repo1", "--clear", "--wont-do")
+	assert.EqualError(t, err, "--clear cannot be given a NOTE or used with --wont-do")
+}
+
+func repoNames(repos []campaign.Repo) []string {
+	var names []string
+	for _, repo := range repos {
+		names = append(names, repo.FullRepoName)
+	}
+	return names
+}
+
+func runCommand(args ...string) (string, error) {
+	cmd := NewNoteCmd()
+	outBuffer := bytes.NewBufferString("")
+	cmd.SetOut(outBuffer)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return outBuffer.String(), err
+}
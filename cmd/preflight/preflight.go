@@ -0,0 +1,96 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package preflight
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/colors"
+	"github.com/skyscanner/turbolift/internal/git"
+	"github.com/skyscanner/turbolift/internal/interrupt"
+	"github.com/skyscanner/turbolift/internal/logging"
+	"github.com/skyscanner/turbolift/internal/messages"
+	"github.com/skyscanner/turbolift/internal/preflight"
+	"github.com/skyscanner/turbolift/internal/summary"
+)
+
+var g git.Git = git.NewRealGit()
+
+var (
+	repoFile   string
+	branchName string
+)
+
+func NewPreflightCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "preflight",
+		Short: "Check that PRs can be raised for each repo before running create-prs",
+		Long: `Check that PRs can be raised for each repo before running create-prs.
+
+For each working copy with changes committed, this checks that it is on the campaign branch, that the
+branch could be pushed to origin (the repo itself, or your fork of it), and that any default-branch
+given in the repos file exists. Pushes are only tried with --dry-run, so nothing is changed, and
+server-side hooks are not run. create-prs --preflight runs the same checks first, and stops before
+pushing anything if they find problems.`,
+		Run: run,
+	}
+
+	cmd.Flags().StringVar(&repoFile, "repos", "repos.txt", "A file containing a list of repositories to check.")
+	cmd.Flags().StringVar(&branchName, "branch", "", "The branch to use for the campaign (defaults to branch in turbolift.yaml, or the campaign name)")
+
+	return cmd
+}
+
+func run(c *cobra.Command, _ []string) {
+	ctx := c.Context()
+	logger := logging.NewLogger(c)
+
+	readCampaignActivity := logger.StartActivity("Reading campaign data (%s)", repoFile)
+	options := campaign.NewCampaignOptions()
+	options.RepoFilename = repoFile
+	options.BranchName = branchName
+	dir, err := campaign.OpenCampaign(options)
+	if err != nil {
+		readCampaignActivity.EndWithFailure(err)
+		return
+	}
+	readCampaignActivity.EndWithSuccess()
+
+	sum := summary.New("preflight")
+	for _, check := range preflight.Run(ctx, g, logger, dir.Repos, dir.BranchName) {
+		switch {
+		case len(check.Problems) > 0:
+			sum.RecordErrored(check.Repo, errors.New(strings.Join(check.Problems, "; ")))
+		case check.Skipped != "":
+			sum.RecordSkipped(check.Repo, check.Skipped)
+		default:
+			sum.RecordDone(check.Repo)
+		}
+	}
+
+	sum.Finish(ctx)
+	if interrupt.Requested(ctx) {
+		logger.Warnf("%s %s(%s, %s, %s)\n", dir.Messages.Format(messages.SummaryInterrupted, messages.Fields{"Command": "preflight"}), colors.Normal(), colors.Green(sum.Done, " ready"), colors.Yellow(sum.Skipped, " skipped"), colors.Red(sum.Errored, " with problems"))
+	} else if sum.Errored == 0 {
+		logger.Successf("%s %s(%s, %s)\n", dir.Messages.Format(messages.SummaryCompleted, messages.Fields{"Command": "preflight"}), colors.Normal(), colors.Green(sum.Done, " ready"), colors.Yellow(sum.Skipped, " skipped"))
+	} else {
+		logger.Warnf("turbolift preflight completed with %s %s(%s, %s, %s)\n", colors.Red("problems"), colors.Normal(), colors.Green(sum.Done, " ready"), colors.Yellow(sum.Skipped, " skipped"), colors.Red(sum.Errored, " with problems"))
+	}
+}
@@ -0,0 +1,78 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package preflight
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/git"
+	"github.com/skyscanner/turbolift/internal/testsupport"
+)
+
+func init() {
+	// disable output colouring so that strings we want to do 'Contains' checks on do not have ANSI escape sequences in IDEs
+	_ = os.Setenv("NO_COLOR", "1")
+}
+
+func TestItReportsTheReposThatPrsCouldNotBeRaisedFor(t *testing.T) {
+	g = git.NewFakeGit(func(_ io.Writer, call []string) (bool, error) {
+		switch {
+		case call[0] == "hasRemote":
+			return false, nil
+		case call[0] == "isAheadOfDefaultBranch":
+			return call[1] != "work/org/repo3", nil
+		case call[0] == "canPush" && call[1] == "work/org/repo2":
+			return false, errors.New("remote: Permission to org/repo2.git denied to octocat")
+		}
+		return true, nil
+	})
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2", "org/repo3")
+	testsupport.CreateAnotherRepoFile("repos.txt", "org/repo1", "org/repo2", "org/repo3", "org/uncloned")
+
+	out, err := runCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "unable to push to org/repo2: remote: Permission to org/repo2.git denied to octocat")
+	assert.Contains(t, out, "No changes committed - create-prs will skip it")
+	assert.Contains(t, out, "turbolift preflight completed with problems (1 ready, 2 skipped, 1 with problems)")
+}
+
+func TestItSucceedsWhenEveryPrCanBeRaised(t *testing.T) {
+	g = git.NewFakeGit(func(_ io.Writer, call []string) (bool, error) {
+		return call[0] != "hasRemote", nil
+	})
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+
+	out, err := runCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "turbolift preflight completed (2 ready, 0 skipped)")
+}
+
+func runCommand(args ...string) (string, error) {
+	cmd := NewPreflightCmd()
+	outBuffer := bytes.NewBufferString("")
+	cmd.SetOut(outBuffer)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return outBuffer.String(), err
+}
@@ -25,9 +25,16 @@ import (
 	"github.com/rodaine/table"
 	"github.com/spf13/cobra"
 
+	"github.com/skyscanner/turbolift/cmd/flags"
+	"github.com/skyscanner/turbolift/internal/bitbucket"
 	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/colors"
+	"github.com/skyscanner/turbolift/internal/forge"
 	"github.com/skyscanner/turbolift/internal/github"
+	"github.com/skyscanner/turbolift/internal/interrupt"
 	"github.com/skyscanner/turbolift/internal/logging"
+	"github.com/skyscanner/turbolift/internal/messages"
+	"github.com/skyscanner/turbolift/internal/prompt"
 )
 
 var reactionsOrder = []string{
@@ -41,6 +48,7 @@ var reactionsOrder = []string{
 	"EYES",
 }
 
+// reactionsMapping is the emoji shown for each reaction, unless the theme is plain, when the reaction's name is shown
 var reactionsMapping = map[string]string{
 	"THUMBS_UP":   "👍",
 	"THUMBS_DOWN": "👎",
@@ -52,11 +60,17 @@ var reactionsMapping = map[string]string{
 	"EYES":        "👀",
 }
 
-var gh github.GitHub = github.NewRealGitHub()
+var (
+	gh github.GitHub = github.NewRealGitHub()
+	bb github.GitHub = bitbucket.NewBitbucketServer()
+	p  prompt.Prompt = prompt.NewRealPrompt()
+)
 
 var (
-	list     bool
-	repoFile string
+	list        bool
+	allBranches bool
+	repoFile    string
+	branchName  string
 )
 
 func NewPrStatusCmd() *cobra.Command {
@@ -66,18 +80,22 @@ func NewPrStatusCmd() *cobra.Command {
 		Run:   run,
 	}
 	cmd.Flags().BoolVar(&list, "list", false, "Displays a listing by PR")
+	cmd.Flags().BoolVar(&allBranches, "all-branches", false, "Includes PRs from every branch the campaign has been run on, not just the current one")
 	cmd.Flags().StringVar(&repoFile, "repos", "repos.txt", "A file containing a list of repositories to clone.")
+	cmd.Flags().StringVar(&branchName, "branch", "", "The branch to use for the campaign (defaults to branch in turbolift.yaml, or the campaign name)")
 
 	return cmd
 }
 
 func run(c *cobra.Command, _ []string) {
+	ctx := c.Context()
 	logger := logging.NewLogger(c)
 
 	readCampaignActivity := logger.StartActivity("Reading campaign data (%s)", repoFile)
 
 	options := campaign.NewCampaignOptions()
 	options.RepoFilename = repoFile
+	options.BranchName = branchName
 	dir, err := campaign.OpenCampaign(options)
 	if err != nil {
 		readCampaignActivity.EndWithFailure(err)
@@ -85,15 +103,59 @@ func run(c *cobra.Command, _ []string) {
 	}
 	readCampaignActivity.EndWithSuccess()
 
+	if err := github.RememberTrackedPRs(gh, bb); err != nil {
+		logger.Warnf("Unable to read the PRs recorded in the campaign state: %s", err)
+	}
+	cachedGitHub, cachedBitbucket := github.WithCache(gh, flags.Cache()), github.WithCache(bb, flags.Cache())
+
+	campaignState, err := campaign.ReadState()
+	if err != nil {
+		logger.Errorf("%s", err)
+		return
+	}
+	branches := []string{dir.BranchName}
+	if allBranches {
+		branches = campaignState.TrackedBranches(dir.BranchName)
+	}
+	showNotes := false
+	for _, repo := range dir.Repos {
+		if _, ok := campaignState.Note(repo.FullRepoName); ok {
+			showNotes = true
+		}
+	}
+
 	statuses := make(map[string]int)
 	reactions := make(map[string]int)
+	mergedRepos := 0
+	archivedRepos := 0
+	var goneRepos []string
+
+	showChecklist := len(dir.Config.Checklist) > 0
+	checklistsConfirmed := 0
+	checklistsTotal := 0
 
-	detailsTable := table.New("Repository", "State", "Reviews", "Checks status", "URL")
+	columns := []interface{}{"Repository"}
+	if allBranches {
+		columns = append(columns, "Branch")
+	}
+	columns = append(columns, "State", "Reviews", "Checks status")
+	if showChecklist {
+		columns = append(columns, "Checklist")
+	}
+	columns = append(columns, "URL")
+	if showNotes {
+		columns = append(columns, "Note")
+	}
+	detailsTable := table.New(columns...)
 	detailsTable.WithHeaderFormatter(color.New(color.Underline).SprintfFunc())
 	detailsTable.WithFirstColumnFormatter(color.New(color.FgCyan).SprintfFunc())
 	detailsTable.WithWriter(logger.Writer())
 
-	for _, repo := range dir.Repos {
+	for i, repo := range dir.Repos {
+		if interrupt.Requested(ctx) {
+			logger.Warnf("Interrupted, so not checking the remaining %d repos", len(dir.Repos)-i)
+			break
+		}
 		repoDirPath := path.Join("work", repo.OrgName, repo.RepoName) // i.e. work/org/repo
 
 		checkStatusActivity := logger.StartActivity("Checking PR status for %s", repo.FullRepoName)
@@ -105,42 +167,100 @@ func run(c *cobra.Command, _ []string) {
 			continue
 		}
 
-		prStatus, err := gh.GetPR(checkStatusActivity.Writer(), repoDirPath, dir.Name)
-		if err != nil {
-			checkStatusActivity.EndWithFailuref("No PR found: %v", err)
-			statuses["NO_PR"]++
-			continue
-		}
+		client := forge.For(repo, cachedGitHub, cachedBitbucket)
+		var failures []string
+		merged := false
+		// whether the repo is archived is only asked once, and only if it has a PR that is not merged
+		var archived *bool
+		for _, branch := range branches {
+			var prStatus *github.PrStatus
+			if branch == dir.BranchName {
+				prStatus, err = client.GetPR(ctx, checkStatusActivity.Writer(), repoDirPath, branch)
+			} else {
+				prStatus, err = client.GetPRForBranch(ctx, checkStatusActivity.Writer(), repoDirPath, branch)
+			}
+			if github.IsRepoGone(err) {
+				failures = append(failures, err.Error())
+				statuses["GONE"]++
+				goneRepos = append(goneRepos, repo.FullRepoName)
+				break
+			}
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("No PR found: %v", err))
+				statuses["NO_PR"]++
+				continue
+			}
 
-		statuses[prStatus.State]++
+			state := prStatus.State
+			if state != github.PrMerged {
+				if archived == nil {
+					isArchived, err := client.IsArchived(ctx, checkStatusActivity.Writer(), repoDirPath)
+					if err != nil {
+						failures = append(failures, fmt.Sprintf("Unable to tell whether the repo is archived: %v", err))
+					}
+					archived = &isArchived
+				}
+				state = github.ClassifyPR(prStatus, *archived)
+			}
 
-		for _, reaction := range prStatus.ReactionGroups {
-			reactions[reaction.Content] += reaction.Users.TotalCount
-		}
+			statuses[state]++
+			if state == github.PrMerged {
+				merged = true
+			}
+
+			for _, reaction := range prStatus.ReactionGroups {
+				reactions[reaction.Content] += reaction.Users.TotalCount
+			}
 
-		failedCheck := false
-		pendingCheck := false
-		for _, check := range prStatus.StatusCheckRollup {
-			if strings.Contains(check.State, "FAILURE") {
-				failedCheck = true
-			} else if strings.Contains(check.State, "PENDING") {
-				pendingCheck = true
+			checksStatus := github.ChecksStatus(prStatus.StatusCheckRollup)
+			// these PRs can look stuck to reviewers, but are held by environment protection rules rather than by review
+			if prStatus.State == "OPEN" && checksStatus == github.ChecksAwaitingDeploymentApproval {
+				statuses["AWAITING_DEPLOYMENT_APPROVAL"]++
 			}
+
+			row := []interface{}{repo.FullRepoName}
+			if allBranches {
+				row = append(row, branch)
+			}
+			row = append(row, state, prStatus.ReviewDecision, checksStatus)
+			if showChecklist {
+				ticked, total := github.ChecklistProgress(prStatus.Body)
+				row = append(row, fmt.Sprintf("%d/%d", ticked, total))
+				if total > 0 {
+					checklistsTotal++
+					if ticked == total {
+						checklistsConfirmed++
+					}
+				}
+			}
+			row = append(row, prStatus.Url)
+			if showNotes {
+				note, _ := campaignState.Note(repo.FullRepoName)
+				row = append(row, note.Note)
+			}
+			detailsTable.AddRow(row...)
 		}
 
-		checksStatus := "SUCCESS"
-		if failedCheck {
-			checksStatus = "FAILURE"
-		} else if pendingCheck {
-			checksStatus = "PENDING"
+		if merged {
+			mergedRepos++
+		} else if archived != nil && *archived {
+			archivedRepos++
 		}
 
-		detailsTable.AddRow(repo.FullRepoName, prStatus.State, prStatus.ReviewDecision, checksStatus, prStatus.Url)
+		if len(failures) > 0 {
+			checkStatusActivity.EndWithFailure(strings.Join(failures, "; "))
+		} else {
+			checkStatusActivity.EndWithSuccess()
+		}
+	}
 
-		checkStatusActivity.EndWithSuccess()
+	if interrupt.Requested(ctx) {
+		logger.Warnf("turbolift pr-status was %s\n", colors.Red("interrupted"))
+	} else {
+		logger.Successf("turbolift pr-status completed\n")
 	}
 
-	logger.Successf("turbolift pr-status completed\n")
+	github.LogApiUsage(ctx, gh, logger, dir.Hosts())
 
 	logger.Println()
 
@@ -156,21 +276,76 @@ func run(c *cobra.Command, _ []string) {
 
 	summaryTable.AddRow("Merged", statuses["MERGED"])
 	summaryTable.AddRow("Open", statuses["OPEN"])
+	summaryTable.AddRow("  Awaiting deployment approval", statuses["AWAITING_DEPLOYMENT_APPROVAL"])
 	summaryTable.AddRow("Closed", statuses["CLOSED"])
+	summaryTable.AddRow("Repo archived", statuses[github.PrRepoArchived])
 	summaryTable.AddRow("Skipped", statuses["SKIPPED"])
 	summaryTable.AddRow("No PR Found", statuses["NO_PR"])
+	summaryTable.AddRow("Deleted or inaccessible", statuses["GONE"])
+	summaryTable.AddRow("Won't do", len(dir.WontDo))
 
 	summaryTable.Print()
 
 	logger.Println()
 
+	// repos that no longer exist, or were archived before their PR was merged, can never be completed, so they do not
+	// count against the campaign. Repos marked won't do are not in dir.Repos at all.
+	inScope := len(dir.Repos) - len(goneRepos) - archivedRepos
+	if inScope > 0 {
+		logger.Printf("Completion: %d%% (%d of %d repos merged)\n", mergedRepos*100/inScope, mergedRepos, inScope)
+	}
+
+	if showChecklist {
+		logger.Printf("Reviewer checklist fully confirmed in %d of %d PRs\n", checklistsConfirmed, checklistsTotal)
+	}
+
 	var reactionsOutput []string
 	for _, key := range reactionsOrder {
 		if reactions[key] > 0 {
-			reactionsOutput = append(reactionsOutput, fmt.Sprintf("%s %d", reactionsMapping[key], reactions[key]))
+			reactionsOutput = append(reactionsOutput, fmt.Sprintf("%s %d", reactionLabel(key), reactions[key]))
 		}
 	}
 	if len(reactionsOutput) > 0 {
 		logger.Println("Reactions:", strings.Join(reactionsOutput, "   "))
 	}
+
+	if len(goneRepos) > 0 {
+		dropGoneRepos(logger, dir, goneRepos)
+	}
+}
+
+// reactionLabel is what the reaction is shown as: its emoji or, under a plain theme, its name, e.g. THUMBS_UP
+func reactionLabel(reaction string) string {
+	if colors.Plain() {
+		return reaction
+	}
+	return reactionsMapping[reaction]
+}
+
+// dropGoneRepos offers to remove repos that have been deleted or made inaccessible from the campaign's repos file
+func dropGoneRepos(logger *logging.Logger, dir *campaign.Campaign, goneRepos []string) {
+	logger.Warnf("%d repos have been deleted, or you no longer have access to them:", len(goneRepos))
+	for _, repo := range goneRepos {
+		logger.Printf("\t%s", repo)
+	}
+
+	if flags.ReadOnly {
+		logger.Printf("Leaving them in %s, as turbolift is in read-only mode", repoFile)
+		return
+	}
+	// dropping them is only offered, so there is nothing to fail the run over
+	if flags.NonInteractive {
+		logger.Printf("Leaving them in %s, as prompts are turned off with --non-interactive", repoFile)
+		return
+	}
+	if !p.AskConfirm(dir.Messages.Format(messages.ConfirmDropGoneRepos, messages.Fields{"ReposFile": repoFile})) {
+		return
+	}
+
+	dropActivity := logger.StartActivity("Dropping %d repos from %s", len(goneRepos), repoFile)
+	if err := campaign.DropReposFromFile(repoFile, goneRepos); err != nil {
+		dropActivity.EndWithFailure(err)
+		return
+	}
+	dropActivity.EndWithSuccess()
 }
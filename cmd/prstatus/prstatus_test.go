@@ -19,11 +19,17 @@ import (
 	"bytes"
 	"errors"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
+	"github.com/skyscanner/turbolift/cmd/flags"
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/colors"
 	"github.com/skyscanner/turbolift/internal/github"
+	"github.com/skyscanner/turbolift/internal/prompt"
 	"github.com/skyscanner/turbolift/internal/testsupport"
 )
 
@@ -53,6 +59,19 @@ func TestItLogsSummaryInformation(t *testing.T) {
 	assert.NotRegexp(t, "org/repo2\\s+MERGED", out)
 }
 
+func TestItNamesReactionsUnderThePlainTheme(t *testing.T) {
+	prepareFakeResponses()
+	assert.NoError(t, colors.SetTheme(colors.AsciiTheme, false))
+	defer func() { _ = colors.SetTheme(colors.DefaultTheme, false) }()
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2", "org/repo3")
+
+	out, err := runCommand(false)
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Reactions: THUMBS_UP 4   THUMBS_DOWN 3   ROCKET 1")
+	assert.NotContains(t, out, "👍")
+}
+
 func TestItLogsDetailedInformation(t *testing.T) {
 	prepareFakeResponses()
 
@@ -72,6 +91,23 @@ func TestItLogsDetailedInformation(t *testing.T) {
 	assert.Regexp(t, "org/repo6\\s+OPEN\\s+REVIEW_REQUIRED\\s+PENDING", out)
 }
 
+func TestItShowsNotesAndLeavesReposMarkedWontDoOut(t *testing.T) {
+	prepareFakeResponses()
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2", "org/repo3")
+	state, _ := campaign.ReadState()
+	state.SetNote(campaign.RepoNote{Repo: "org/repo1", Note: "waiting on team X"})
+	state.SetNote(campaign.RepoNote{Repo: "org/repo3", Note: "legacy", WontDo: true})
+	assert.NoError(t, state.Save())
+
+	out, err := runCommand(true)
+	assert.NoError(t, err)
+	assert.Regexp(t, "org/repo1\\s+OPEN.*waiting on team X", out)
+	assert.NotContains(t, out, "org/repo3")
+	assert.Regexp(t, "Won't do\\s+1", out)
+	assert.Contains(t, out, "Completion: 50% (1 of 2 repos merged)")
+}
+
 func TestItSkipsUnclonedRepos(t *testing.T) {
 	prepareFakeResponses()
 
@@ -106,6 +142,167 @@ func TestItNotesReposWhereNoPrCanBeFound(t *testing.T) {
 	assert.Regexp(t, "org/repo1\\s+OPEN", out)
 }
 
+func TestItHighlightsPrsAwaitingDeploymentApproval(t *testing.T) {
+	prepareFakeResponses()
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo4", "org/repoAwaitingApproval")
+
+	out, err := runCommand(true)
+	assert.NoError(t, err)
+	assert.Regexp(t, "Open\\s+3", out)
+	assert.Regexp(t, "Awaiting deployment approval\\s+1", out)
+
+	assert.Regexp(t, "org/repoAwaitingApproval\\s+OPEN\\s+APPROVED\\s+AWAITING_DEPLOYMENT_APPROVAL", out)
+	assert.Regexp(t, "org/repo4\\s+OPEN\\s+REVIEW_REQUIRED\\s+PENDING", out)
+}
+
+func TestItShowsPrsFromEveryTrackedBranch(t *testing.T) {
+	prepareFakeResponses()
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+	state, _ := campaign.ReadState()
+	state.AddBranch("my-campaign")
+	state.AddBranch(testsupport.Pwd())
+	_ = state.Save()
+
+	cmd := NewPrStatusCmd()
+	list = true
+	allBranches = true
+	outBuffer := bytes.NewBufferString("")
+	cmd.SetOut(outBuffer)
+	err := cmd.Execute()
+	assert.NoError(t, err)
+	out := outBuffer.String()
+
+	assert.Regexp(t, "org/repo1\\s+my-campaign\\s+CLOSED", out)
+	assert.Regexp(t, "org/repo1\\s+"+testsupport.Pwd()+"\\s+OPEN", out)
+	assert.Regexp(t, "org/repo2\\s+"+testsupport.Pwd()+"\\s+MERGED", out)
+	assert.Contains(t, out, "no PR found for work/org/repo2 and branch my-campaign")
+	assert.Regexp(t, "Closed\\s+1", out)
+	assert.Regexp(t, "No PR Found\\s+1", out)
+}
+
+func TestItShowsReviewerChecklistProgress(t *testing.T) {
+	prepareFakeResponses()
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2", "org/repo3")
+	testsupport.CreateConfigFile("checklist:\n  - Deployed to staging\n  - Dashboards checked\n")
+
+	out, err := runCommand(true)
+	assert.NoError(t, err)
+
+	assert.Regexp(t, "org/repo1\\s+OPEN\\s+REVIEW_REQUIRED\\s+FAILURE\\s+1/2", out)
+	assert.Regexp(t, "org/repo2\\s+MERGED\\s+APPROVED\\s+SUCCESS\\s+2/2", out)
+	assert.Regexp(t, "org/repo3\\s+CLOSED\\s+FAILURE\\s+0/0", out)
+	assert.Contains(t, out, "Reviewer checklist fully confirmed in 1 of 2 PRs")
+}
+
+func TestItClassifiesDeletedReposAndLeavesThemOutOfCompletion(t *testing.T) {
+	prepareFakeResponses()
+	fakePrompt := prompt.NewFakePromptNo()
+	p = fakePrompt
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2", "org/repoGone")
+
+	out, err := runCommand(false)
+	assert.NoError(t, err)
+	assert.Contains(t, out, "org/repoGone has been deleted or is no longer accessible")
+	assert.Regexp(t, "No PR Found\\s+0", out)
+	assert.Regexp(t, "Deleted or inaccessible\\s+1", out)
+	assert.Contains(t, out, "Completion: 50% (1 of 2 repos merged)")
+	fakePrompt.AssertCalledWith(t, "Drop these repos from repos.txt?")
+
+	contents, _ := os.ReadFile("repos.txt")
+	assert.Contains(t, string(contents), "\norg/repoGone")
+}
+
+func TestItClassifiesUnmergedPrsInArchivedReposAndLeavesThemOutOfCompletion(t *testing.T) {
+	prepareFakeResponses().WithArchivedRepos("work/org/repo2", "work/org/repo3")
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2", "org/repo3")
+
+	out, err := runCommand(true)
+	assert.NoError(t, err)
+	assert.Regexp(t, "org/repo2\\s+MERGED", out)
+	assert.Regexp(t, "org/repo3\\s+ARCHIVED", out)
+	assert.Regexp(t, "Merged\\s+1", out)
+	assert.Regexp(t, "Closed\\s+0", out)
+	assert.Regexp(t, "Repo archived\\s+1", out)
+	assert.Contains(t, out, "Completion: 50% (1 of 2 repos merged)")
+}
+
+func TestItDropsDeletedReposFromTheCampaign(t *testing.T) {
+	prepareFakeResponses()
+	p = prompt.NewFakePromptYes()
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repoGone")
+
+	out, err := runCommand(false)
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Dropping 1 repos from repos.txt")
+
+	dir, err := campaign.OpenCampaign(campaign.NewCampaignOptions())
+	assert.NoError(t, err)
+	assert.Len(t, dir.Repos, 1)
+	assert.Equal(t, "org/repo1", dir.Repos[0].FullRepoName)
+}
+
+func TestItUsesPrsCachedByAnEarlierRunUnlessToldNotTo(t *testing.T) {
+	flags.CacheTTL = time.Minute
+	defer func() {
+		flags.CacheTTL = 0
+		flags.NoCache = false
+	}()
+	prepareFakeResponses()
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2", "org/repo3")
+
+	_, err := runCommand(false)
+	assert.NoError(t, err)
+
+	gh = github.NewFakeGitHub(nil, func(workingDir string) (interface{}, error) {
+		return &github.PrStatus{State: "MERGED"}, nil
+	})
+	out, err := runCommand(false)
+	assert.NoError(t, err)
+	assert.Regexp(t, "Open\\s+1", out)
+	assert.Regexp(t, "Merged\\s+1", out)
+
+	flags.NoCache = true
+	out, err = runCommand(false)
+	assert.NoError(t, err)
+	assert.Regexp(t, "Open\\s+0", out)
+	assert.Regexp(t, "Merged\\s+3", out)
+}
+
+func TestItAsksBitbucketAboutBitbucketRepos(t *testing.T) {
+	fakeGitHub := prepareFakeResponses()
+	fakeBitbucket := github.NewFakeGitHub(nil, func(workingDir string) (interface{}, error) {
+		return &github.PrStatus{State: "MERGED"}, nil
+	})
+	bb = fakeBitbucket
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+	testsupport.CreateAnotherRepoFile("repos.yaml", "- repo: org/repo1", "- repo: bitbucket.example.com/PROJ/repo2", "  forge: bitbucket")
+	assert.NoError(t, os.MkdirAll("work/PROJ/repo2", 0o755))
+
+	cmd := NewPrStatusCmd()
+	outBuffer := bytes.NewBufferString("")
+	cmd.SetOut(outBuffer)
+	cmd.SetArgs([]string{"--repos", "repos.yaml"})
+	assert.NoError(t, cmd.Execute())
+	out := outBuffer.String()
+	assert.Regexp(t, "Open\\s+1", out)
+	assert.Regexp(t, "Merged\\s+1", out)
+
+	fakeGitHub.AssertCalledWith(t, [][]string{
+		{"get_pr", "work/org/repo1"},
+	})
+	fakeBitbucket.AssertCalledWith(t, [][]string{
+		{"get_pr", "work/PROJ/repo2"},
+	})
+}
+
 func runCommand(showList bool) (string, error) {
 	cmd := NewPrStatusCmd()
 	list = showList
@@ -118,7 +315,7 @@ func runCommand(showList bool) (string, error) {
 	return outBuffer.String(), nil
 }
 
-func prepareFakeResponses() {
+func prepareFakeResponses() *github.FakeGitHub {
 	dummyData := map[string]*github.PrStatus{
 		"work/org/repo1": {
 			State: "OPEN",
@@ -243,8 +440,37 @@ func prepareFakeResponses() {
 			},
 			ReviewDecision: "REVIEW_REQUIRED",
 		},
+		"work/org/repoAwaitingApproval": {
+			State: "OPEN",
+			StatusCheckRollup: []github.StatusCheckRollup{
+				{
+					Name:       "build",
+					Status:     "COMPLETED",
+					Conclusion: "SUCCESS",
+				},
+				{
+					Name:   "deploy-staging",
+					Status: "WAITING",
+				},
+			},
+			ReviewDecision: "APPROVED",
+		},
+	}
+	checklistBody := github.WithChecklist("PR body", "### Reviewer checklist", []string{"Deployed to staging", "Dashboards checked"}, "")
+	dummyData["work/org/repo1"].Body = strings.Replace(checklistBody, "- [ ]", "- [x]", 1)
+	dummyData["work/org/repo2"].Body = strings.ReplaceAll(checklistBody, "- [ ]", "- [x]")
+
+	dummyData["work/org/repo1@my-campaign"] = &github.PrStatus{
+		State:          "CLOSED",
+		ReviewDecision: "REVIEW_REQUIRED",
 	}
 	fakeGitHub := github.NewFakeGitHub(nil, func(workingDir string) (interface{}, error) {
+		if workingDir == "work/org/repo2@my-campaign" {
+			return nil, &github.NoPRFoundError{Path: "work/org/repo2", BranchName: "my-campaign"}
+		}
+		if workingDir == "work/org/repoGone" {
+			return nil, &github.RepoGoneError{Repo: "org/repoGone"}
+		}
 		if workingDir == "work/org/repoWithError" {
 			return nil, errors.New("Synthetic error")
 		} else {
@@ -252,4 +478,6 @@ func prepareFakeResponses() {
 		}
 	})
 	gh = fakeGitHub
+	p = prompt.NewFakePromptNo()
+	return fakeGitHub
 }
@@ -0,0 +1,159 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package registry
+
+import (
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/rodaine/table"
+	"github.com/spf13/cobra"
+
+	"github.com/skyscanner/turbolift/cmd/flags"
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/colors"
+	"github.com/skyscanner/turbolift/internal/logging"
+	"github.com/skyscanner/turbolift/internal/registry"
+)
+
+var openRegistry = registry.NewRegistry
+
+var (
+	owner     string
+	status    string
+	reportUrl string
+	repoFile  string
+)
+
+func NewRegistryCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "registry",
+		Short: "Publish and browse campaigns in the organisation's campaign registry",
+	}
+
+	cmd.AddCommand(newPublishCmd())
+	cmd.AddCommand(newListCmd())
+
+	return cmd
+}
+
+func newPublishCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "publish",
+		Short: "Publish this campaign's metadata to the registry",
+		Run:   runPublish,
+	}
+
+	cmd.Flags().StringVar(&owner, "owner", "", "Owner of the campaign (defaults to registry.owner in turbolift.yaml)")
+	cmd.Flags().StringVar(&status, "status", "in-progress", "Status of the campaign, e.g. planned, in-progress, done")
+	cmd.Flags().StringVar(&reportUrl, "report-url", "", "Link to a report on the campaign's progress")
+	cmd.Flags().StringVar(&repoFile, "repos", "repos.txt", "A file containing a list of repositories to clone.")
+	flags.MarkMakesChanges(cmd)
+
+	return cmd
+}
+
+func newListCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List the campaigns in the registry",
+		Run:   runList,
+	}
+}
+
+func runPublish(c *cobra.Command, _ []string) {
+	ctx := c.Context()
+	logger := logging.NewLogger(c)
+
+	readCampaignActivity := logger.StartActivity("Reading campaign data (%s)", repoFile)
+	options := campaign.NewCampaignOptions()
+	options.RepoFilename = repoFile
+	dir, err := campaign.OpenCampaign(options)
+	if err != nil {
+		readCampaignActivity.EndWithFailure(err)
+		return
+	}
+	readCampaignActivity.EndWithSuccess()
+
+	r, err := openRegistry(dir.Config.Registry)
+	if err != nil {
+		logger.Errorf("%s", err)
+		return
+	}
+
+	entry := registry.Entry{
+		Name:      dir.Name,
+		Owner:     owner,
+		Status:    status,
+		ReportUrl: reportUrl,
+		Repos:     []string{},
+		UpdatedAt: time.Now().UTC(),
+	}
+	if entry.Owner == "" {
+		entry.Owner = dir.Config.Registry.Owner
+	}
+	for _, repo := range dir.Repos {
+		entry.Repos = append(entry.Repos, repo.FullRepoName)
+	}
+
+	publishActivity := logger.StartActivity("Publishing %s to the registry", dir.Name)
+	if err := r.Publish(ctx, publishActivity.Writer(), entry); err != nil {
+		publishActivity.EndWithFailure(err)
+		return
+	}
+	publishActivity.EndWithSuccess()
+
+	logger.Successf("turbolift registry publish completed %s(%s repos registered)\n", colors.Normal(), colors.Green(len(entry.Repos)))
+}
+
+func runList(c *cobra.Command, _ []string) {
+	ctx := c.Context()
+	logger := logging.NewLogger(c)
+
+	// the registry is configured per campaign, but a missing repos.txt or README.md shouldn't prevent browsing
+	options := campaign.NewCampaignOptions()
+	config, err := campaign.ReadConfig(options.ConfigFilename)
+	if err != nil {
+		logger.Errorf("%s", err)
+		return
+	}
+
+	r, err := openRegistry(config.Registry)
+	if err != nil {
+		logger.Errorf("%s", err)
+		return
+	}
+
+	listActivity := logger.StartActivity("Fetching campaigns from the registry")
+	entries, err := r.List(ctx, listActivity.Writer())
+	if err != nil {
+		listActivity.EndWithFailure(err)
+		return
+	}
+	listActivity.EndWithSuccess()
+
+	logger.Println()
+
+	campaignsTable := table.New("Campaign", "Owner", "Status", "Repos", "Updated", "Report")
+	campaignsTable.WithHeaderFormatter(color.New(color.Underline).SprintfFunc())
+	campaignsTable.WithFirstColumnFormatter(color.New(color.FgCyan).SprintfFunc())
+	campaignsTable.WithWriter(logger.Writer())
+
+	for _, entry := range entries {
+		campaignsTable.AddRow(entry.Name, entry.Owner, entry.Status, len(entry.Repos), entry.UpdatedAt.Format("2006-01-02"), entry.ReportUrl)
+	}
+	campaignsTable.Print()
+}
@@ -0,0 +1,115 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package registry
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/registry"
+	"github.com/skyscanner/turbolift/internal/testsupport"
+)
+
+func init() {
+	// disable output colouring so that strings we want to do 'Contains' checks on do not have ANSI escape sequences in IDEs
+	_ = os.Setenv("NO_COLOR", "1")
+}
+
+func TestItPublishesCampaignMetadata(t *testing.T) {
+	fakeRegistry := useFakeRegistry()
+
+	testsupport.PrepareTempCampaign(false, "org/repo1", "org/repo2")
+	testsupport.CreateConfigFile("registry:\n  url: https://registry.example.com\n  owner: platform-team\n")
+
+	out, err := runCommand("publish", "--status", "done", "--report-url", "https://example.com/report")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "turbolift registry publish completed (2 repos registered)")
+
+	assert.Len(t, fakeRegistry.Published(), 1)
+	entry := fakeRegistry.Published()[0]
+	assert.Equal(t, testsupport.Pwd(), entry.Name)
+	assert.Equal(t, "platform-team", entry.Owner)
+	assert.Equal(t, "done", entry.Status)
+	assert.Equal(t, "https://example.com/report", entry.ReportUrl)
+	assert.Equal(t, []string{"org/repo1", "org/repo2"}, entry.Repos)
+}
+
+func TestOwnerFlagOverridesConfig(t *testing.T) {
+	fakeRegistry := useFakeRegistry()
+
+	testsupport.PrepareTempCampaign(false, "org/repo1")
+	testsupport.CreateConfigFile("registry:\n  url: https://registry.example.com\n  owner: platform-team\n")
+
+	_, err := runCommand("publish", "--owner", "someone-else")
+	assert.NoError(t, err)
+
+	assert.Equal(t, "someone-else", fakeRegistry.Published()[0].Owner)
+}
+
+func TestItReportsMissingRegistryConfiguration(t *testing.T) {
+	openRegistry = registry.NewRegistry
+
+	testsupport.PrepareTempCampaign(false, "org/repo1")
+
+	out, err := runCommand("publish")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "no registry is configured")
+	assert.NotContains(t, out, "turbolift registry publish completed")
+}
+
+func TestItListsCampaigns(t *testing.T) {
+	useFakeRegistry(
+		registry.Entry{Name: "bump-base-image", Owner: "platform-team", Status: "in-progress", Repos: []string{"org/repo1", "org/repo2"}, UpdatedAt: time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)},
+		registry.Entry{Name: "remove-legacy-ci", Owner: "ci-team", Status: "done", Repos: []string{"org/repo3"}, UpdatedAt: time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC)},
+	)
+
+	// listing works from any directory that has a registry configured
+	testsupport.CreateAndEnterTempDirectory()
+	testsupport.CreateConfigFile("registry:\n  path: ../registry\n")
+
+	out, err := runCommand("list")
+	assert.NoError(t, err)
+	assert.Regexp(t, "bump-base-image\\s+platform-team\\s+in-progress\\s+2\\s+2021-06-01", out)
+	assert.Regexp(t, "remove-legacy-ci\\s+ci-team\\s+done\\s+1\\s+2021-05-01", out)
+}
+
+func useFakeRegistry(entries ...registry.Entry) *registry.FakeRegistry {
+	fake := registry.NewFakeRegistry(entries...)
+	openRegistry = func(campaign.RegistryConfig) (registry.Registry, error) {
+		return fake, nil
+	}
+	return fake
+}
+
+func runCommand(args ...string) (string, error) {
+	cmd := NewRegistryCmd()
+	owner = ""
+	status = "in-progress"
+	reportUrl = ""
+	outBuffer := bytes.NewBufferString("")
+	cmd.SetOut(outBuffer)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	if err != nil {
+		return outBuffer.String(), err
+	}
+	return outBuffer.String(), nil
+}
@@ -0,0 +1,326 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package remotes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/skyscanner/turbolift/cmd/flags"
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/colors"
+	"github.com/skyscanner/turbolift/internal/git"
+	"github.com/skyscanner/turbolift/internal/interrupt"
+	"github.com/skyscanner/turbolift/internal/logging"
+	"github.com/skyscanner/turbolift/internal/messages"
+	"github.com/skyscanner/turbolift/internal/summary"
+)
+
+var g git.Git = git.NewRealGit()
+
+var (
+	repoFile string
+	from     string
+	to       string
+)
+
+func NewRemotesCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "remotes",
+		Short: "Manage the git remotes of the campaign's working copies",
+	}
+
+	cmd.AddCommand(newRewriteCmd())
+
+	return cmd
+}
+
+func newRewriteCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rewrite --from PREFIX --to PREFIX",
+		Short: "Move repos to a renamed org or a new host",
+		Long: `Move repos to a renamed org or a new host.
+
+For --from and --to, give a host and org (github.com/oldorg), an org on any host (oldorg) or a whole
+host (github.com). For each repo in the campaign that matches --from, the origin and upstream remotes
+of its working copy are rewritten to match --to, the working copy is moved to the new org's directory,
+and the repos file and campaign state are updated to use the repo's new name.`,
+		Run: runRewrite,
+	}
+
+	cmd.Flags().StringVar(&repoFile, "repos", "repos.txt", "A file containing a list of repositories to rewrite.")
+	cmd.Flags().StringVar(&from, "from", "", "The host, org or host/org that repos have moved from")
+	cmd.Flags().StringVar(&to, "to", "", "The host, org or host/org that repos have moved to")
+	flags.MarkMakesChanges(cmd)
+
+	return cmd
+}
+
+// prefix is where repos live: a host, an org on any host, or an org on a host
+type prefix struct {
+	host string
+	org  string
+}
+
+// parsePrefix parses a prefix given as host/org, org or host. A single name is taken to be a host if it has a dot in it.
+func parsePrefix(s string) (prefix, error) {
+	parts := strings.Split(strings.Trim(s, "/"), "/")
+	switch {
+	case len(parts) == 2 && parts[0] != "" && parts[1] != "":
+		return prefix{host: parts[0], org: parts[1]}, nil
+	case len(parts) == 1 && strings.Contains(parts[0], "."):
+		return prefix{host: parts[0]}, nil
+	case len(parts) == 1 && parts[0] != "":
+		return prefix{org: parts[0]}, nil
+	}
+	return prefix{}, fmt.Errorf("unable to parse %s: must be given as host/org, org or host", s)
+}
+
+func (p prefix) matches(host string, org string) bool {
+	return (p.host == "" || strings.EqualFold(p.host, host)) && (p.org == "" || strings.EqualFold(p.org, org))
+}
+
+// rewrite moves a host and org from one prefix to another, keeping whatever the prefixes do not say
+func rewrite(host string, org string, from prefix, to prefix) (string, string) {
+	if from.host != "" {
+		host = to.host
+	}
+	if from.org != "" {
+		org = to.org
+	}
+	return host, org
+}
+
+func runRewrite(c *cobra.Command, _ []string) {
+	ctx := c.Context()
+	logger := logging.NewLogger(c)
+
+	fromPrefix, toPrefix, err := parsePrefixes(from, to)
+	if err != nil {
+		logger.Errorf("%s", err)
+		return
+	}
+
+	readCampaignActivity := logger.StartActivity("Reading campaign data (%s)", repoFile)
+	options := campaign.NewCampaignOptions()
+	options.RepoFilename = repoFile
+	dir, err := campaign.OpenCampaign(options)
+	if err != nil {
+		readCampaignActivity.EndWithFailure(err)
+		return
+	}
+	readCampaignActivity.EndWithSuccess()
+
+	var renames []campaign.Rename
+	sum := summary.New("remotes")
+	for i, repo := range dir.Repos {
+		if interrupt.Requested(ctx) {
+			logger.Warnf("Interrupted, so not rewriting the remaining %d repos", len(dir.Repos)-i)
+			break
+		}
+		host := repo.Host
+		if host == "" {
+			host = campaign.DefaultHost
+		}
+		if !fromPrefix.matches(host, repo.OrgName) {
+			continue
+		}
+
+		newHost, newOrg := rewrite(host, repo.OrgName, fromPrefix, toPrefix)
+		newName := newOrg + "/" + repo.RepoName
+		if repo.Host != "" || newHost != campaign.DefaultHost {
+			newName = newHost + "/" + newName
+		}
+		renamed, err := repo.Renamed(newName)
+		if err != nil {
+			logger.Errorf("%s", err)
+			sum.RecordErrored(repo, err)
+			continue
+		}
+
+		rewriteActivity := logger.StartActivity("Moving %s to %s", repo.FullRepoName, newName)
+
+		if _, err = os.Stat(repo.FullRepoPath()); os.IsNotExist(err) {
+			rewriteActivity.EndWithWarningf("Directory %s does not exist, so only renaming the repo in %s", repo.FullRepoPath(), repoFile)
+			renames = append(renames, campaign.Rename{From: repo.FullRepoName, To: newName, Noticed: time.Now()})
+			sum.RecordSkipped(repo, "not cloned")
+			continue
+		}
+
+		if err := rewriteRemotes(ctx, rewriteActivity.Writer(), repo.FullRepoPath(), fromPrefix, toPrefix); err != nil {
+			rewriteActivity.EndWithFailure(err)
+			sum.RecordErrored(repo, err)
+			continue
+		}
+		if err := moveWorkingCopy(repo, renamed); err != nil {
+			rewriteActivity.EndWithFailure(err)
+			sum.RecordErrored(repo, err)
+			continue
+		}
+		renames = append(renames, campaign.Rename{From: repo.FullRepoName, To: newName, Noticed: time.Now()})
+		rewriteActivity.EndWithSuccess()
+		sum.RecordDone(repo)
+	}
+
+	if len(renames) > 0 {
+		if err := recordRenames(renames); err != nil {
+			logger.Errorf("%s", err)
+			sum.RecordError(err)
+		}
+	}
+
+	sum.Finish(ctx)
+	if interrupt.Requested(ctx) {
+		logger.Warnf("%s %s(%s, %s, %s)\n", dir.Messages.Format(messages.SummaryInterrupted, messages.Fields{"Command": "remotes rewrite"}), colors.Normal(), colors.Green(sum.Done, " OK"), colors.Yellow(sum.Skipped, " skipped"), colors.Red(sum.Errored, " errored"))
+	} else if len(sum.Repos) == 0 && len(sum.Errors) == 0 {
+		logger.Warnf("No repos in %s are on %s, so nothing was rewritten", repoFile, from)
+	} else if sum.Errored == 0 {
+		logger.Successf("%s %s(%s, %s)\n", dir.Messages.Format(messages.SummaryCompleted, messages.Fields{"Command": "remotes rewrite"}), colors.Normal(), colors.Green(sum.Done, " OK"), colors.Yellow(sum.Skipped, " skipped"))
+	} else {
+		logger.Warnf("%s %s(%s, %s, %s)\n", dir.Messages.Format(messages.SummaryWithErrors, messages.Fields{"Command": "remotes rewrite"}), colors.Normal(), colors.Green(sum.Done, " OK"), colors.Yellow(sum.Skipped, " skipped"), colors.Red(sum.Errored, " errored"))
+	}
+}
+
+// parsePrefixes checks that --from and --to say the same sort of thing, so that every name has somewhere to go
+func parsePrefixes(from string, to string) (prefix, prefix, error) {
+	if from == "" || to == "" {
+		return prefix{}, prefix{}, errors.New("both --from and --to are required")
+	}
+	fromPrefix, err := parsePrefix(from)
+	if err != nil {
+		return prefix{}, prefix{}, err
+	}
+	toPrefix, err := parsePrefix(to)
+	if err != nil {
+		return prefix{}, prefix{}, err
+	}
+	if (fromPrefix.host == "") != (toPrefix.host == "") || (fromPrefix.org == "") != (toPrefix.org == "") {
+		return prefix{}, prefix{}, fmt.Errorf("--from %s and --to %s must both be given as host/org, org or host", from, to)
+	}
+	return fromPrefix, toPrefix, nil
+}
+
+// rewriteRemotes points the origin and upstream remotes of a working copy at the new place, if they were on the old one.
+// The origin of a fork is left alone unless the fork's org or host has moved too.
+func rewriteRemotes(ctx context.Context, output io.Writer, repoDirPath string, fromPrefix prefix, toPrefix prefix) error {
+	remotes := []string{"origin"}
+	isFork, err := g.HasRemote(ctx, output, repoDirPath, "upstream")
+	if err != nil {
+		return err
+	}
+	if isFork {
+		remotes = append(remotes, "upstream")
+	}
+
+	for _, remote := range remotes {
+		oldUrl, err := g.RemoteUrl(ctx, output, repoDirPath, remote)
+		if err != nil {
+			return err
+		}
+		newUrl, ok := rewriteUrl(oldUrl, fromPrefix, toPrefix)
+		if !ok {
+			continue
+		}
+		if err := g.SetRemoteUrl(ctx, output, repoDirPath, remote, newUrl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var scpLikeUrlRegexp = regexp.MustCompile(`^([^@/:]+@)?([^:/]+):(.+)$`)
+
+// rewriteUrl moves a remote URL, in https, ssh or scp-like user@host:org/repo form, from one prefix to another.
+// It returns false if the URL is not on the old prefix.
+func rewriteUrl(remoteUrl string, fromPrefix prefix, toPrefix prefix) (string, bool) {
+	if !strings.Contains(remoteUrl, "://") {
+		match := scpLikeUrlRegexp.FindStringSubmatch(remoteUrl)
+		if match == nil {
+			return "", false
+		}
+		host, repoPath, ok := rewritePath(match[2], match[3], fromPrefix, toPrefix)
+		if !ok {
+			return "", false
+		}
+		return match[1] + host + ":" + repoPath, true
+	}
+
+	parsed, err := url.Parse(remoteUrl)
+	if err != nil {
+		return "", false
+	}
+	host, repoPath, ok := rewritePath(parsed.Hostname(), strings.TrimPrefix(parsed.Path, "/"), fromPrefix, toPrefix)
+	if !ok {
+		return "", false
+	}
+	if port := parsed.Port(); port != "" {
+		host = host + ":" + port
+	}
+	parsed.Host = host
+	parsed.Path = "/" + repoPath
+	return parsed.String(), true
+}
+
+func rewritePath(host string, repoPath string, fromPrefix prefix, toPrefix prefix) (string, string, bool) {
+	parts := strings.SplitN(repoPath, "/", 2)
+	if len(parts) != 2 || !fromPrefix.matches(host, parts[0]) {
+		return "", "", false
+	}
+	newHost, newOrg := rewrite(host, parts[0], fromPrefix, toPrefix)
+	return newHost, newOrg + "/" + parts[1], true
+}
+
+// moveWorkingCopy moves a working copy to where it belongs under the repo's new name, if its org has changed
+func moveWorkingCopy(repo campaign.Repo, renamed campaign.Repo) error {
+	oldPath := repo.FullRepoPath()
+	newPath := renamed.FullRepoPath()
+	if oldPath == newPath {
+		return nil
+	}
+	if _, err := os.Stat(newPath); !os.IsNotExist(err) {
+		return fmt.Errorf("unable to move the working copy to %s, which already exists", newPath)
+	}
+	if err := os.MkdirAll(path.Dir(newPath), os.ModeDir|0o755); err != nil {
+		return err
+	}
+	return os.Rename(oldPath, newPath)
+}
+
+// recordRenames updates the repos file and campaign state to use the repos' new names
+func recordRenames(renames []campaign.Rename) error {
+	if err := campaign.RenameReposInFile(repoFile, renames); err != nil {
+		return err
+	}
+
+	state, err := campaign.ReadState()
+	if err != nil {
+		return err
+	}
+	for _, rename := range renames {
+		state.RecordRename(rename)
+	}
+	return state.Save()
+}
@@ -0,0 +1,177 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package remotes
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/git"
+	"github.com/skyscanner/turbolift/internal/testsupport"
+)
+
+func init() {
+	// disable output colouring so that strings we want to do 'Contains' checks on do not have ANSI escape sequences in IDEs
+	_ = os.Setenv("NO_COLOR", "1")
+}
+
+func TestItMovesReposToARenamedOrg(t *testing.T) {
+	fakeGit := git.NewFakeGit(func(_ io.Writer, call []string) (bool, error) {
+		if call[0] == "hasRemote" {
+			return call[1] == "work/oldorg/forked", nil
+		}
+		return true, nil
+	})
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(true, "oldorg/owned", "oldorg/forked", "other/repo")
+	testsupport.CreateAnotherRepoFile("repos.txt", "oldorg/owned", "oldorg/forked", "other/repo", "oldorg/uncloned")
+
+	out, err := runCommand("rewrite", "--from", "github.com/oldorg", "--to", "github.com/neworg")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Moving oldorg/owned to neworg/owned")
+	assert.Contains(t, out, "Directory work/oldorg/uncloned does not exist, so only renaming the repo in repos.txt")
+	assert.Contains(t, out, "turbolift remotes rewrite completed (2 OK, 1 skipped)")
+
+	fakeGit.AssertCalledWith(t, [][]string{
+		{"hasRemote", "work/oldorg/owned", "upstream"},
+		{"remoteUrl", "work/oldorg/owned", "origin"},
+		{"setRemoteUrl", "work/oldorg/owned", "origin", "https://github.com/neworg/owned.git"},
+		{"hasRemote", "work/oldorg/forked", "upstream"},
+		{"remoteUrl", "work/oldorg/forked", "origin"},
+		{"setRemoteUrl", "work/oldorg/forked", "origin", "https://github.com/neworg/forked.git"},
+		{"remoteUrl", "work/oldorg/forked", "upstream"},
+		{"setRemoteUrl", "work/oldorg/forked", "upstream", "https://github.com/neworg/forked.git"},
+	})
+
+	assert.DirExists(t, "work/neworg/owned")
+	assert.DirExists(t, "work/neworg/forked")
+	assert.NoDirExists(t, "work/oldorg/owned")
+
+	reposFile, _ := os.ReadFile("repos.txt")
+	assert.Equal(t, "neworg/owned\nneworg/forked\nother/repo\nneworg/uncloned", string(reposFile))
+
+	state, err := campaign.ReadState()
+	assert.NoError(t, err)
+	assert.Len(t, state.Renames, 3)
+	assert.Equal(t, "oldorg/owned", state.Renames[0].From)
+	assert.Equal(t, "neworg/owned", state.Renames[0].To)
+}
+
+func TestItLeavesForkOriginsOnOtherOrgsAlone(t *testing.T) {
+	fakeGit := git.NewFakeGit(func(_ io.Writer, call []string) (bool, error) {
+		return true, nil
+	}).WithRemoteUrls(map[string]string{
+		"origin":   "git@github.com:me/forked.git",
+		"upstream": "git@github.com:oldorg/forked.git",
+	})
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(true, "oldorg/forked")
+
+	_, err := runCommand("rewrite", "--from", "oldorg", "--to", "neworg")
+	assert.NoError(t, err)
+
+	fakeGit.AssertCalledWith(t, [][]string{
+		{"hasRemote", "work/oldorg/forked", "upstream"},
+		{"remoteUrl", "work/oldorg/forked", "origin"},
+		{"remoteUrl", "work/oldorg/forked", "upstream"},
+		{"setRemoteUrl", "work/oldorg/forked", "upstream", "git@github.com:neworg/forked.git"},
+	})
+}
+
+func TestItMovesReposToANewHost(t *testing.T) {
+	fakeGit := git.NewFakeGit(func(_ io.Writer, call []string) (bool, error) {
+		return call[0] != "hasRemote", nil
+	})
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runCommand("rewrite", "--from", "github.com", "--to", "github.example.com")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Moving org/repo1 to github.example.com/org/repo1")
+
+	fakeGit.AssertCalledWith(t, [][]string{
+		{"hasRemote", "work/org/repo1", "upstream"},
+		{"remoteUrl", "work/org/repo1", "origin"},
+		{"setRemoteUrl", "work/org/repo1", "origin", "https://github.example.com/org/repo1.git"},
+	})
+	assert.DirExists(t, "work/org/repo1")
+
+	reposFile, _ := os.ReadFile("repos.txt")
+	assert.Equal(t, "github.example.com/org/repo1", string(reposFile))
+}
+
+func TestItSaysWhenNoReposMatch(t *testing.T) {
+	fakeGit := git.NewAlwaysSucceedsFakeGit()
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runCommand("rewrite", "--from", "oldorg", "--to", "neworg")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "No repos in repos.txt are on oldorg, so nothing was rewritten")
+	fakeGit.AssertCalledWith(t, [][]string{})
+}
+
+func TestItRejectsMismatchedPrefixes(t *testing.T) {
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runCommand("rewrite", "--from", "github.com/oldorg", "--to", "neworg")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "--from github.com/oldorg and --to neworg must both be given as host/org, org or host")
+
+	out, err = runCommand("rewrite", "--from", "oldorg")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "both --from and --to are required")
+}
+
+func TestItRewritesRemoteUrls(t *testing.T) {
+	from := prefix{host: "github.com", org: "oldorg"}
+	to := prefix{host: "github.example.com", org: "neworg"}
+
+	rewritten, ok := rewriteUrl("https://github.com/oldorg/repo1.git", from, to)
+	assert.True(t, ok)
+	assert.Equal(t, "https://github.example.com/neworg/repo1.git", rewritten)
+
+	rewritten, ok = rewriteUrl("git@github.com:OldOrg/repo1.git", from, to)
+	assert.True(t, ok)
+	assert.Equal(t, "git@github.example.com:neworg/repo1.git", rewritten)
+
+	rewritten, ok = rewriteUrl("ssh://git@github.com:2222/oldorg/repo1.git", from, to)
+	assert.True(t, ok)
+	assert.Equal(t, "ssh://git@github.example.com:2222/neworg/repo1.git", rewritten)
+
+	_, ok = rewriteUrl("https://github.com/oldorg2/repo1.git", from, to)
+	assert.False(t, ok)
+	_, ok = rewriteUrl("https://gitlab.com/oldorg/repo1.git", from, to)
+	assert.False(t, ok)
+}
+
+func runCommand(args ...string) (string, error) {
+	cmd := NewRemotesCmd()
+	outBuffer := bytes.NewBufferString("")
+	cmd.SetOut(outBuffer)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return outBuffer.String(), err
+}
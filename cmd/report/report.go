@@ -0,0 +1,193 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package report
+
+import (
+	_ "embed"
+	"fmt"
+	htmlTemplate "html/template"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/skyscanner/turbolift/cmd/flags"
+	"github.com/skyscanner/turbolift/internal/bitbucket"
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/colors"
+	"github.com/skyscanner/turbolift/internal/git"
+	"github.com/skyscanner/turbolift/internal/github"
+	"github.com/skyscanner/turbolift/internal/logging"
+	"github.com/skyscanner/turbolift/internal/status"
+)
+
+var (
+	gh github.GitHub = github.NewRealGitHub()
+	bb github.GitHub = bitbucket.NewBitbucketServer()
+	g  git.Git       = git.NewRealGit()
+)
+
+var (
+	format     string
+	outputFile string
+	repoFile   string
+	branchName string
+
+	//go:embed templates/report.md
+	markdownTemplate string
+
+	//go:embed templates/report.html
+	htmlReportTemplate string
+)
+
+// ReportRow and ReportSummary are the status of each repo, and the number of repos in each state
+type (
+	ReportRow     = status.Row
+	ReportSummary = status.Summary
+)
+
+type ReportData struct {
+	CampaignName string
+	GeneratedAt  string
+	Summary      ReportSummary
+	Rows         []ReportRow
+}
+
+func NewReportCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "report",
+		Short: "Generate a Markdown or HTML report of the campaign's progress",
+		Run:   run,
+	}
+
+	cmd.Flags().StringVar(&format, "format", "markdown", "Format of the report: markdown or html")
+	cmd.Flags().StringVarP(&outputFile, "output", "o", "", "File to write the report to (default report.md or report.html, depending on format)")
+	cmd.Flags().StringVar(&repoFile, "repos", "repos.txt", "A file containing a list of repositories to clone.")
+	cmd.Flags().StringVar(&branchName, "branch", "", "The branch to use for the campaign (defaults to branch in turbolift.yaml, or the campaign name)")
+
+	return cmd
+}
+
+func run(c *cobra.Command, _ []string) {
+	ctx := c.Context()
+	logger := logging.NewLogger(c)
+
+	if format != "markdown" && format != "html" {
+		logger.Errorf("Unknown report format %s: must be one of markdown, html", format)
+		return
+	}
+	if outputFile == "" {
+		if format == "html" {
+			outputFile = "report.html"
+		} else {
+			outputFile = "report.md"
+		}
+	}
+
+	readCampaignActivity := logger.StartActivity("Reading campaign data (%s)", repoFile)
+	options := campaign.NewCampaignOptions()
+	options.RepoFilename = repoFile
+	options.BranchName = branchName
+	dir, err := campaign.OpenCampaign(options)
+	if err != nil {
+		readCampaignActivity.EndWithFailure(err)
+		return
+	}
+	readCampaignActivity.EndWithSuccess()
+
+	data := ReportData{
+		CampaignName: dir.Name,
+		GeneratedAt:  time.Now().Format(time.RFC1123),
+	}
+
+	data.Rows, data.Summary = status.Collect(ctx, logger, github.WithCache(gh, flags.Cache()), github.WithCache(bb, flags.Cache()), g, dir)
+
+	writeReportActivity := logger.StartActivity("Writing report to %s", outputFile)
+	err = writeReport(outputFile, format, data)
+	if err != nil {
+		writeReportActivity.EndWithFailure(err)
+		return
+	}
+	writeReportActivity.EndWithSuccess()
+
+	logger.Successf("turbolift report completed %s(report written to %s)\n", colors.Normal(), colors.Cyan(outputFile))
+	if data.Summary.Blocked > 0 {
+		logger.Warnf("%d open PRs can never merge under their branch protection, so need escalating - see their blockers in the report", data.Summary.Blocked)
+	}
+
+	github.LogApiUsage(ctx, gh, logger, dir.Hosts())
+}
+
+func writeReport(filename string, format string, data ReportData) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("unable to open file for output: %w", err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	return renderReport(file, format, data)
+}
+
+func renderReport(w io.Writer, format string, data ReportData) error {
+	funcs := map[string]interface{}{
+		"yesNo": yesNo,
+		"cell":  cell,
+		"join":  join,
+	}
+
+	var err error
+	if format == "html" {
+		var parsedTemplate *htmlTemplate.Template
+		parsedTemplate, err = htmlTemplate.New("").Funcs(funcs).Parse(htmlReportTemplate)
+		if err != nil {
+			return fmt.Errorf("unable to parse template: %w", err)
+		}
+		err = parsedTemplate.Execute(w, data)
+	} else {
+		var parsedTemplate *template.Template
+		parsedTemplate, err = template.New("").Funcs(funcs).Parse(markdownTemplate)
+		if err != nil {
+			return fmt.Errorf("unable to parse template: %w", err)
+		}
+		err = parsedTemplate.Execute(w, data)
+	}
+
+	if err != nil {
+		return fmt.Errorf("unable to write report: %w", err)
+	}
+	return nil
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// cell escapes text so that it stays in its cell of a markdown table
+func cell(text string) string {
+	return strings.NewReplacer("|", `\|`, "\n", " ").Replace(text)
+}
+
+func join(items []string) string {
+	return strings.Join(items, "; ")
+}
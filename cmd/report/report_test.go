@@ -0,0 +1,201 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package report
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/git"
+	"github.com/skyscanner/turbolift/internal/github"
+	"github.com/skyscanner/turbolift/internal/testsupport"
+)
+
+func init() {
+	// disable output colouring so that strings we want to do 'Contains' checks on do not have ANSI escape sequences in IDEs
+	_ = os.Setenv("NO_COLOR", "1")
+}
+
+func TestItWritesAMarkdownReport(t *testing.T) {
+	prepareFakes()
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2", "org/repoWithError")
+
+	out, err := runCommand("markdown", "")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Collecting status for org/repo1")
+	assert.Contains(t, out, "turbolift report completed")
+	assert.Contains(t, out, "report.md")
+
+	report, err := os.ReadFile("report.md")
+	assert.NoError(t, err)
+	assert.Contains(t, string(report), "# Turbolift campaign report: "+testsupport.Pwd())
+	assert.Contains(t, string(report), "| Merged | 1 |")
+	assert.Contains(t, string(report), "| Open | 1 |")
+	assert.Contains(t, string(report), "| No PR Found | 1 |")
+	assert.Contains(t, string(report), "| org/repo1 | yes | yes | OPEN | FAILURE | https://github.com/org/repo1/pull/1 |")
+	assert.Contains(t, string(report), "| org/repo2 | yes | no | MERGED | SUCCESS | https://github.com/org/repo2/pull/2 |")
+	assert.Contains(t, string(report), "| org/repoWithError | yes | yes |  |  |  |")
+}
+
+func TestItWritesAnHtmlReport(t *testing.T) {
+	prepareFakes()
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+
+	_, err := runCommand("html", "custom.html")
+	assert.NoError(t, err)
+
+	report, err := os.ReadFile("custom.html")
+	assert.NoError(t, err)
+	assert.Contains(t, string(report), "<h1>Turbolift campaign report: "+testsupport.Pwd()+"</h1>")
+	assert.Contains(t, string(report), `<td>org/repo1</td><td>yes</td><td>yes</td><td>OPEN</td><td>FAILURE</td><td><a href="https://github.com/org/repo1/pull/1">`)
+}
+
+func TestItReportsUnclonedRepos(t *testing.T) {
+	prepareFakes()
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+	_ = os.Remove("work/org/repo2")
+
+	_, err := runCommand("markdown", "")
+	assert.NoError(t, err)
+
+	report, err := os.ReadFile("report.md")
+	assert.NoError(t, err)
+	assert.Contains(t, string(report), "| Not cloned | 1 |")
+	assert.Contains(t, string(report), "| org/repo2 | no | no |  |  |  |")
+}
+
+func TestItLeavesDeletedReposOutOfCompletion(t *testing.T) {
+	prepareFakes()
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2", "org/repoGone")
+
+	out, err := runCommand("markdown", "")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "org/repoGone has been deleted or is no longer accessible")
+
+	report, err := os.ReadFile("report.md")
+	assert.NoError(t, err)
+	assert.Contains(t, string(report), "| Deleted or inaccessible | 1 |")
+	assert.Contains(t, string(report), "| No PR Found | 0 |")
+	assert.Contains(t, string(report), "Completion: 50% of repos merged")
+	assert.Contains(t, string(report), "| org/repoGone | yes | yes | GONE |  |  |")
+}
+
+func TestItShowsNotesAndLeavesReposMarkedWontDoOut(t *testing.T) {
+	prepareFakes()
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2", "org/repoWithError")
+	state, _ := campaign.ReadState()
+	state.SetNote(campaign.RepoNote{Repo: "org/repo1", Note: "waiting on team X | Y"})
+	state.SetNote(campaign.RepoNote{Repo: "org/repoWithError", Note: "legacy", WontDo: true})
+	assert.NoError(t, state.Save())
+
+	_, err := runCommand("markdown", "")
+	assert.NoError(t, err)
+
+	report, err := os.ReadFile("report.md")
+	assert.NoError(t, err)
+	assert.Contains(t, string(report), "| Won't do | 1 |")
+	assert.Contains(t, string(report), "| No PR Found | 0 |")
+	assert.Contains(t, string(report), "Completion: 50% of repos merged")
+	assert.Contains(t, string(report), `| https://github.com/org/repo1/pull/1 | waiting on team X \| Y |`)
+	assert.NotContains(t, string(report), "org/repoWithError")
+}
+
+func TestItFlagsPrsThatCanNeverMergeUnderBranchProtection(t *testing.T) {
+	prepareFakes()
+	gh.(*github.FakeGitHub).WithBranchProtection(map[string]*github.BranchProtection{
+		"work/org/repo1": {RequiredApprovingReviews: 1, RequiredChecks: []string{"build"}},
+		"work/org/repo2": {RequiredChecks: []string{"build"}},
+	})
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+
+	out, err := runCommand("markdown", "")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "1 open PRs can never merge under their branch protection")
+
+	report, err := os.ReadFile("report.md")
+	assert.NoError(t, err)
+	assert.Contains(t, string(report), "| Blocked by branch protection | 1 |")
+	assert.Contains(t, string(report), "| https://github.com/org/repo1/pull/1 |  | required check build is not reported on the PR; approving reviews are required (1), but nobody has been asked to review |")
+	// merged PRs are no longer held by branch protection
+	assert.Contains(t, string(report), "| https://github.com/org/repo2/pull/2 |  |  |")
+}
+
+func TestItRejectsUnknownFormats(t *testing.T) {
+	prepareFakes()
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runCommand("pdf", "")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Unknown report format pdf")
+	assert.NoFileExists(t, "report.md")
+}
+
+func runCommand(reportFormat string, output string) (string, error) {
+	cmd := NewReportCmd()
+	format = reportFormat
+	outputFile = output
+	outBuffer := bytes.NewBufferString("")
+	cmd.SetOut(outBuffer)
+	err := cmd.Execute()
+	if err != nil {
+		return outBuffer.String(), err
+	}
+	return outBuffer.String(), nil
+}
+
+func prepareFakes() {
+	dummyData := map[string]*github.PrStatus{
+		"work/org/repo1": {
+			State: "OPEN",
+			StatusCheckRollup: []github.StatusCheckRollup{
+				{State: "FAILURE"},
+			},
+			Url: "https://github.com/org/repo1/pull/1",
+		},
+		"work/org/repo2": {
+			State: "MERGED",
+			StatusCheckRollup: []github.StatusCheckRollup{
+				{State: "SUCCESS"},
+			},
+			Url: "https://github.com/org/repo2/pull/2",
+		},
+	}
+	gh = github.NewFakeGitHub(nil, func(workingDir string) (interface{}, error) {
+		if workingDir == "work/org/repoWithError" {
+			return nil, errors.New("synthetic error")
+		}
+		if workingDir == "work/org/repoGone" {
+			return nil, &github.RepoGoneError{Repo: "org/repoGone"}
+		}
+		return dummyData[workingDir], nil
+	})
+	g = git.NewFakeGit(func(_ io.Writer, call []string) (bool, error) {
+		return call[1] != "work/org/repo2", nil
+	})
+}
@@ -0,0 +1,142 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package results
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/colors"
+	"github.com/skyscanner/turbolift/internal/logging"
+)
+
+// maxOutputLines is how much of each group's output is shown, as captured output can be long
+const maxOutputLines = 10
+
+var (
+	by       string
+	maxLines int
+)
+
+func NewResultsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "results",
+		Short: "Group repos by the output captured with foreach --capture",
+		Long: `Group repos by the output captured with foreach --capture.
+
+Repos whose command printed exactly the same output are shown together, largest group first, so
+that it is easy to see, for example, which repos use which version of a dependency. Use
+--by exit-code to group them by how the command exited instead.`,
+		Run: run,
+	}
+
+	cmd.Flags().StringVar(&by, "by", "output", "What to group repos by: output or exit-code")
+	cmd.Flags().IntVar(&maxLines, "max-lines", maxOutputLines, "How many lines of each group's output to show (0 for all)")
+
+	return cmd
+}
+
+type group struct {
+	key   string
+	repos []string
+}
+
+func run(c *cobra.Command, _ []string) {
+	logger := logging.NewLogger(c)
+
+	if by != "output" && by != "exit-code" {
+		logger.Errorf("Unknown grouping %s: must be one of output, exit-code", by)
+		return
+	}
+
+	state, err := campaign.ReadState()
+	if err != nil {
+		logger.Errorf("%s", err)
+		return
+	}
+	capture := state.LastCapture
+	if capture == nil {
+		logger.Errorf("No output has been captured yet: run turbolift foreach with --capture first")
+		return
+	}
+
+	logger.Printf("Results of { %s } in %d repos, captured %s\n", capture.Command, len(capture.Results), capture.Captured.Format("2006-01-02 15:04"))
+
+	groups, err := groupResults(capture)
+	if err != nil {
+		logger.Errorf("%s", err)
+		return
+	}
+
+	for _, g := range groups {
+		if by == "exit-code" {
+			logger.Printf("%s with exit code %s:", colors.Cyan(len(g.repos), " repos"), g.key)
+		} else if g.key == "" {
+			logger.Printf("%s with no output:", colors.Cyan(len(g.repos), " repos"))
+		} else {
+			logger.Printf("%s with output:", colors.Cyan(len(g.repos), " repos"))
+			for _, line := range truncatedLines(g.key) {
+				logger.Printf("    %s", line)
+			}
+		}
+		for _, repo := range g.repos {
+			logger.Printf("  %s", repo)
+		}
+		logger.Println()
+	}
+}
+
+// groupResults groups the repos that were captured by their output or exit code, largest group first
+func groupResults(capture *campaign.Capture) ([]group, error) {
+	index := map[string]int{}
+	var groups []group
+	for _, result := range capture.Results {
+		key := fmt.Sprint(result.ExitCode)
+		if by == "output" {
+			contents, err := os.ReadFile(result.File)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read the output captured for %s: %w", result.Repo, err)
+			}
+			key = strings.TrimSpace(string(contents))
+		}
+
+		i, seen := index[key]
+		if !seen {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, group{key: key})
+		}
+		groups[i].repos = append(groups[i].repos, result.Repo)
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		return len(groups[i].repos) > len(groups[j].repos)
+	})
+	return groups, nil
+}
+
+func truncatedLines(output string) []string {
+	lines := strings.Split(output, "\n")
+	if maxLines <= 0 || len(lines) <= maxLines {
+		return lines
+	}
+	return append(lines[:maxLines], fmt.Sprintf("... (%d more lines)", len(lines)-maxLines))
+}
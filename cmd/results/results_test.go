@@ -0,0 +1,111 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package results
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/testsupport"
+)
+
+func init() {
+	// disable output colouring so that strings we want to do 'Contains' checks on do not have ANSI escape sequences in IDEs
+	_ = os.Setenv("NO_COLOR", "1")
+}
+
+func TestItGroupsReposByOutput(t *testing.T) {
+	prepareCapture()
+
+	out, err := runCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Results of { grep '^go ' go.mod } in 4 repos")
+	assert.Regexp(t, `2 repos with output:\n    go 1.21\n  org/repo1\n  org/repo3\n`, out)
+	assert.Regexp(t, `1 repos with output:\n    go 1.19\n  org/repo2\n`, out)
+	assert.Regexp(t, `1 repos with no output:\n  org/repo4\n`, out)
+}
+
+func TestItGroupsReposByExitCode(t *testing.T) {
+	prepareCapture()
+
+	out, err := runCommand("--by", "exit-code")
+	assert.NoError(t, err)
+	assert.Regexp(t, `3 repos with exit code 0:\n  org/repo1\n  org/repo2\n  org/repo3\n`, out)
+	assert.Regexp(t, `1 repos with exit code 1:\n  org/repo4\n`, out)
+}
+
+func TestItTruncatesLongOutput(t *testing.T) {
+	prepareCapture()
+	_ = os.WriteFile("results/org/repo2.out", []byte("1\n2\n3\n4\n"), 0o644)
+
+	out, err := runCommand("--max-lines", "2")
+	assert.NoError(t, err)
+	assert.Regexp(t, `    1\n    2\n    \.\.\. \(2 more lines\)\n  org/repo2\n`, out)
+}
+
+func TestItExplainsWhenNothingHasBeenCaptured(t *testing.T) {
+	testsupport.PrepareTempCampaign(false)
+
+	out, err := runCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "No output has been captured yet: run turbolift foreach with --capture first")
+}
+
+func TestItRejectsUnknownGroupings(t *testing.T) {
+	prepareCapture()
+
+	out, err := runCommand("--by", "colour")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Unknown grouping colour: must be one of output, exit-code")
+}
+
+func prepareCapture() {
+	testsupport.PrepareTempCampaign(false)
+	_ = os.MkdirAll("results/org", 0o755)
+	outputs := map[string]string{
+		"org/repo1": "go 1.21\n",
+		"org/repo2": "go 1.19\n",
+		"org/repo3": "go 1.21\n",
+		"org/repo4": "",
+	}
+	capture := &campaign.Capture{Command: "grep '^go ' go.mod", Path: "results/{{.Repo}}.out", Captured: time.Now()}
+	for _, repo := range []string{"org/repo1", "org/repo2", "org/repo3", "org/repo4"} {
+		_ = os.WriteFile("results/"+repo+".out", []byte(outputs[repo]), 0o644)
+		exitCode := 0
+		if outputs[repo] == "" {
+			exitCode = 1
+		}
+		capture.Record(campaign.CaptureResult{Repo: repo, File: "results/" + repo + ".out", ExitCode: exitCode})
+	}
+
+	state, _ := campaign.ReadState()
+	state.LastCapture = capture
+	_ = state.Save()
+}
+
+func runCommand(args ...string) (string, error) {
+	cmd := NewResultsCmd()
+	outBuffer := bytes.NewBufferString("")
+	cmd.SetOut(outBuffer)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return outBuffer.String(), err
+}
@@ -16,49 +16,383 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 
+	applyCmd "github.com/skyscanner/turbolift/cmd/apply"
+	campaignsCmd "github.com/skyscanner/turbolift/cmd/campaigns"
+	cleanCmd "github.com/skyscanner/turbolift/cmd/clean"
 	cloneCmd "github.com/skyscanner/turbolift/cmd/clone"
 	commitCmd "github.com/skyscanner/turbolift/cmd/commit"
 	createPrsCmd "github.com/skyscanner/turbolift/cmd/create_prs"
+	diffCmd "github.com/skyscanner/turbolift/cmd/diff"
+	doctorCmd "github.com/skyscanner/turbolift/cmd/doctor"
+	dueCmd "github.com/skyscanner/turbolift/cmd/due"
 	"github.com/skyscanner/turbolift/cmd/flags"
+	followUpCmd "github.com/skyscanner/turbolift/cmd/followup"
 	foreachCmd "github.com/skyscanner/turbolift/cmd/foreach"
 	initCmd "github.com/skyscanner/turbolift/cmd/init"
+	listPrsCmd "github.com/skyscanner/turbolift/cmd/listprs"
+	nextCmd "github.com/skyscanner/turbolift/cmd/next"
+	noteCmd "github.com/skyscanner/turbolift/cmd/note"
+	preflightCmd "github.com/skyscanner/turbolift/cmd/preflight"
 	prStatusCmd "github.com/skyscanner/turbolift/cmd/prstatus"
+	registryCmd "github.com/skyscanner/turbolift/cmd/registry"
+	remotesCmd "github.com/skyscanner/turbolift/cmd/remotes"
+	reportCmd "github.com/skyscanner/turbolift/cmd/report"
+	resultsCmd "github.com/skyscanner/turbolift/cmd/results"
+	serveCmd "github.com/skyscanner/turbolift/cmd/serve"
+	statsCmd "github.com/skyscanner/turbolift/cmd/stats"
+	syncForksCmd "github.com/skyscanner/turbolift/cmd/syncforks"
+	toolsCmd "github.com/skyscanner/turbolift/cmd/tools"
 	updatePrsCmd "github.com/skyscanner/turbolift/cmd/updateprs"
+	verifyClonesCmd "github.com/skyscanner/turbolift/cmd/verifyclones"
+	"github.com/skyscanner/turbolift/internal/audit"
+	"github.com/skyscanner/turbolift/internal/cache"
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/colors"
+	"github.com/skyscanner/turbolift/internal/executor"
+	"github.com/skyscanner/turbolift/internal/exitcode"
+	"github.com/skyscanner/turbolift/internal/interrupt"
+	"github.com/skyscanner/turbolift/internal/logging"
+	"github.com/skyscanner/turbolift/internal/notify"
+	"github.com/skyscanner/turbolift/internal/prompt"
+	"github.com/skyscanner/turbolift/internal/summary"
+	"github.com/skyscanner/turbolift/internal/tools"
+	"github.com/skyscanner/turbolift/internal/usage"
+	"github.com/skyscanner/turbolift/internal/userconfig"
 )
 
+// notifyTimeout is how long sending notifications may take, so that an unreachable webhook cannot hold up the run
+const notifyTimeout = 30 * time.Second
+
 var (
 	version = "version-dev"
 	commit  = "commit-dev"
 	date    = "date-dev"
 )
 
-var rootCmd = &cobra.Command{
-	Use:              "turbolift",
-	Short:            "Turbolift",
-	Long:             `Mass refactoring tool for repositories in GitHub`,
-	Version:          fmt.Sprintf("%s (%s, built %s)", version, commit, date),
-	TraverseChildren: true,
-}
+// invocation is how turbolift was run, as recorded in the audit log
+var invocation []string
+
+// newRootCmd builds the turbolift command and all of its subcommands. Each build binds the flags afresh, setting them
+// back to their defaults, so that one run does not inherit the flags of another.
+func newRootCmd() *cobra.Command {
+	root := &cobra.Command{
+		Use:               "turbolift",
+		Short:             "Turbolift",
+		Long:              `Mass refactoring tool for repositories in GitHub`,
+		Version:           fmt.Sprintf("%s (%s, built %s)", version, commit, date),
+		TraverseChildren:  true,
+		PersistentPreRunE: prepareRun,
+	}
+
+	root.PersistentFlags().BoolVarP(&flags.Verbose, "verbose", "v", false, "verbose output")
+	root.PersistentFlags().DurationVar(&flags.CommandTimeout, "command-timeout", 0, "Stop any git, gh or other command that runs for longer than this, e.g. 5m (defaults to no limit)")
+	root.PersistentFlags().BoolVar(&flags.FailFast, "fail-fast", false, "Stop after the first repo that fails, instead of carrying on with the rest")
+	root.PersistentFlags().IntVar(&flags.Retries, "retries", 2, "How many times to retry a git or gh command that fails because of the network or a temporary problem on the server")
+	root.PersistentFlags().DurationVar(&flags.RetryDelay, "retry-delay", 2*time.Second, "How long to wait before the first retry of a failed git or gh command, doubling after each retry")
+	root.PersistentFlags().BoolVar(&flags.ReadOnly, "read-only", false, "Only look at the campaign: refuse to run anything that could change it, its repos or their PRs")
+	root.PersistentFlags().BoolVar(&flags.Audit, "audit", false, "Record every git, gh or other command that is run, and its output, in the campaign's audit log")
+	root.PersistentFlags().BoolVar(&flags.Force, "force", false, "Run even if another command that makes changes looks to be running in the campaign, e.g. after one was killed")
+	root.PersistentFlags().StringVar(&flags.SummaryFile, "summary-file", "", "Write a JSON summary of what the command did to each repo to this file, for pipelines")
+	root.PersistentFlags().StringVar(&flags.NotifySlack, "notify-slack", "", "Post a summary to this Slack incoming webhook URL when the command finishes")
+	root.PersistentFlags().StringVar(&flags.NotifyWebhook, "notify-webhook", "", "POST a JSON summary to this URL when the command finishes")
+	root.PersistentFlags().BoolVar(&flags.NoCache, "no-cache", false, "Look up every PR and repo afresh, rather than using what an earlier run of a status command cached")
+	root.PersistentFlags().DurationVar(&flags.CacheTTL, "cache-ttl", cache.DefaultTTL, "How long status commands such as pr-status and report cache the PRs and repos they look up (0 to turn off)")
+	root.PersistentFlags().BoolVar(&flags.NoColor, "no-color", false, "Turn off coloured output, as setting NO_COLOR does")
+	root.PersistentFlags().StringVar(&flags.Theme, "theme", "", "Style output with this theme: "+strings.Join(colors.Themes(), ", ")+" (defaults to the theme in your user config, or default)")
+	root.PersistentFlags().BoolVar(&flags.NonInteractive, "non-interactive", false, "Never prompt, for running from cron or CI: fail instead if a question would need answering, e.g. when --yes is not given")
+	root.PersistentFlags().DurationVar(&flags.Heartbeat, "heartbeat", time.Minute, "How often to report that a slow command is still running, with its latest output (0 to turn off)")
 
-func init() {
-	rootCmd.PersistentFlags().BoolVarP(&flags.Verbose, "verbose", "v", false, "verbose output")
+	root.AddCommand(cloneCmd.NewCloneCmd())
+	root.AddCommand(commitCmd.NewCommitCmd())
+	root.AddCommand(createPrsCmd.NewCreatePRsCmd())
+	root.AddCommand(initCmd.NewInitCmd())
+	root.AddCommand(foreachCmd.NewForeachCmd())
+	root.AddCommand(applyCmd.NewApplyCmd())
+	root.AddCommand(updatePrsCmd.NewUpdatePRsCmd())
+	root.AddCommand(prStatusCmd.NewPrStatusCmd())
+	root.AddCommand(listPrsCmd.NewListPRsCmd())
+	root.AddCommand(reportCmd.NewReportCmd())
+	root.AddCommand(statsCmd.NewStatsCmd())
+	root.AddCommand(serveCmd.NewServeCmd())
+	root.AddCommand(nextCmd.NewNextCmd())
+	root.AddCommand(registryCmd.NewRegistryCmd())
+	root.AddCommand(followUpCmd.NewFollowUpCmd())
+	root.AddCommand(noteCmd.NewNoteCmd())
+	root.AddCommand(dueCmd.NewDueCmd())
+	root.AddCommand(campaignsCmd.NewCampaignsCmd())
+	root.AddCommand(doctorCmd.NewDoctorCmd())
+	root.AddCommand(syncForksCmd.NewSyncForksCmd())
+	root.AddCommand(cleanCmd.NewCleanCmd())
+	root.AddCommand(verifyClonesCmd.NewVerifyClonesCmd())
+	root.AddCommand(resultsCmd.NewResultsCmd())
+	root.AddCommand(remotesCmd.NewRemotesCmd())
+	root.AddCommand(diffCmd.NewDiffCmd())
+	root.AddCommand(preflightCmd.NewPreflightCmd())
+	root.AddCommand(toolsCmd.NewToolsCmd())
 
-	rootCmd.AddCommand(cloneCmd.NewCloneCmd())
-	rootCmd.AddCommand(commitCmd.NewCommitCmd())
-	rootCmd.AddCommand(createPrsCmd.NewCreatePRsCmd())
-	rootCmd.AddCommand(initCmd.NewInitCmd())
-	rootCmd.AddCommand(foreachCmd.NewForeachCmd())
-	rootCmd.AddCommand(updatePrsCmd.NewUpdatePRsCmd())
-	rootCmd.AddCommand(prStatusCmd.NewPrStatusCmd())
+	return root
 }
 
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
-		log.Fatal(err)
+	// the first Ctrl-C lets the current repo finish before stopping, so that a checkpoint and a partial summary can be
+	// written; the second cancels any commands still running
+	ctx, stop := interrupt.HandleSignals(context.Background(), os.Stderr)
+	// tools downloaded by turbolift doctor --install-missing are used in preference to any others on the PATH
+	tools.AddToPath()
+
+	started := time.Now()
+	invocation = os.Args[1:]
+	ctx, collector := summary.WithCollector(ctx)
+	rootCmd := newRootCmd()
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
+		log.Print(err)
+		exitcode.Fail()
+	}
+	if err := prompt.TakeRefusal(); err != nil {
+		log.Print(err)
+		exitcode.Fail()
+	}
+	stop()
+	stopAudit()
+	unlockCampaign()
+	if sum := collector.Summary(); sum != nil && flags.SummaryFile != "" {
+		if err := sum.WriteFile(flags.SummaryFile); err != nil {
+			log.Printf("Unable to write the summary to %s: %s", flags.SummaryFile, err)
+			exitcode.Fail()
+		}
+	}
+	if sum := collector.Summary(); sum != nil {
+		notifyOfRun(sum)
+	}
+	if usage.Enabled() {
+		if executedCmd, _, err := rootCmd.Find(os.Args[1:]); err == nil {
+			recordUsage(executedCmd, started)
+		}
+	}
+	os.Exit(exitcode.Code())
+}
+
+// runMu keeps to one command at a time the runs made through Run, as commands keep their flags, the campaign lock and
+// the audit log in package variables
+var runMu sync.Mutex
+
+// Run runs a turbolift command, given as it would be on the command line, and returns the summary of what it did to
+// each repo, for programs that drive the CLI's commands rather than reading their output. Programs that work on a
+// campaign through Go should use pkg/turbolift instead. The summary is nil for commands that do not work through the
+// campaign's repos, or that stopped before reaching them. Each run starts from the default flags, and runs made at the
+// same time wait for each other.
+func Run(ctx context.Context, args ...string) (*summary.Summary, error) {
+	runMu.Lock()
+	defer runMu.Unlock()
+
+	invocation = args
+	ctx, collector := summary.WithCollector(ctx)
+	rootCmd := newRootCmd()
+	rootCmd.SetArgs(args)
+	err := rootCmd.ExecuteContext(ctx)
+	if refusal := prompt.TakeRefusal(); err == nil {
+		err = refusal
+	}
+	stopAudit()
+	unlockCampaign()
+	return collector.Summary(), err
+}
+
+// notifyOfRun posts the summary of the run wherever the campaign or the flags say to. Notifications that cannot be
+// sent are reported, but do not fail the run.
+func notifyOfRun(sum *summary.Summary) {
+	config, err := campaign.ReadConfig("turbolift.yaml")
+	if err != nil {
+		log.Printf("Unable to read where to send notifications: %s", err)
+		return
+	}
+	targets := notify.Targets(config.Notify, sum.Command, flags.NotifySlack, flags.NotifyWebhook)
+	if len(targets) == 0 {
+		return
+	}
+
+	dir, _ := os.Getwd()
+	notification := notify.NewNotification(filepath.Base(dir), sum)
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	for _, target := range targets {
+		if err := notify.Send(ctx, target, notification); err != nil {
+			log.Printf("Unable to notify %s: %s", target.Name, err)
+		}
+	}
+}
+
+// prepareRun sets any flags not given from the environment, checks that the command can be run, locks the campaign
+// for commands that make changes, and starts recording it in the audit log if one is kept
+func prepareRun(c *cobra.Command, args []string) error {
+	if err := flags.SetFromEnv(c); err != nil {
+		c.SilenceUsage = true
+		return err
+	}
+	prompt.NonInteractive = flags.NonInteractive
+	executor.DefaultTimeout = flags.CommandTimeout
+	executor.DefaultRetries = flags.Retries
+	executor.DefaultRetryDelay = flags.RetryDelay
+	if err := setUpTheme(c); err != nil {
+		return err
+	}
+	if err := checkReadOnly(c, args); err != nil {
+		return err
+	}
+	if err := lockCampaign(c); err != nil {
+		return err
+	}
+	// PRs cached by status commands may be changed by this one, so they have to be looked up again next time
+	if flags.MakesChanges(c) {
+		_ = cache.Clear(flags.CacheDirectory)
+	}
+	setUpRedaction()
+	return startAudit(c)
+}
+
+// setUpTheme styles output with the theme chosen by --theme or the user's config
+func setUpTheme(c *cobra.Command) error {
+	theme := flags.Theme
+	if theme == "" {
+		// an unreadable user config is reported when checking the user's role
+		if config, err := userconfig.Read(userconfig.File()); err == nil {
+			theme = config.Theme
+		}
+	}
+	if err := colors.SetTheme(theme, flags.NoColor); err != nil {
+		c.SilenceUsage = true
+		return err
+	}
+	return nil
+}
+
+// setUpRedaction masks the secrets matching the campaign's redact patterns in everything logged from now on
+func setUpRedaction() {
+	// an unreadable config file is reported by the command itself
+	config, err := campaign.ReadConfig("turbolift.yaml")
+	if err != nil {
+		return
+	}
+	_ = logging.SetSecretPatterns(config.Redact)
+}
+
+// startAudit records every external command run from now on, if the campaign or --audit asks for it
+func startAudit(c *cobra.Command) error {
+	if !flags.Audit {
+		// an unreadable config file is reported by the command itself
+		config, err := campaign.ReadConfig("turbolift.yaml")
+		if err != nil || !config.Audit {
+			return nil
+		}
+	}
+	auditLog, err := audit.Open(".", invocation)
+	if err != nil {
+		c.SilenceUsage = true
+		return err
+	}
+	audit.SetActive(auditLog)
+	return nil
+}
+
+var campaignLock *campaign.Lock
+
+// lockCampaign stops other commands that make changes from running in the campaign at the same time as this one.
+// Commands run outside a campaign, such as init, have nothing to lock.
+func lockCampaign(c *cobra.Command) error {
+	if !flags.MakesChanges(c) || !campaign.IsCampaignDir(".") {
+		return nil
+	}
+	lock, err := campaign.AcquireLock(c.CommandPath(), flags.Force)
+	if err != nil {
+		c.SilenceUsage = true
+		return err
+	}
+	if lock.Replaced != nil {
+		log.Printf("Taking over the campaign lock from %s", lock.Replaced)
+	}
+	campaignLock = lock
+	return nil
+}
+
+// unlockCampaign lets other commands run in the campaign again, if this one locked it
+func unlockCampaign() {
+	if campaignLock == nil {
+		return
+	}
+	if err := campaignLock.Release(); err != nil {
+		log.Printf("%s", err)
+		exitcode.Fail()
+	}
+	campaignLock = nil
+}
+
+// stopAudit finishes the audit log, if one is being kept
+func stopAudit() {
+	auditLog := audit.Active()
+	if auditLog == nil {
+		return
+	}
+	audit.SetActive(nil)
+	if err := auditLog.Close(); err != nil {
+		log.Printf("Unable to close the audit log: %s", err)
+		exitcode.Fail()
+	}
+}
+
+// checkReadOnly turns on read-only mode for users whose role is observer, and refuses to run commands that make
+// changes while it is on
+func checkReadOnly(c *cobra.Command, _ []string) error {
+	config, err := userconfig.Read(userconfig.File())
+	if err != nil {
+		c.SilenceUsage = true
+		return err
+	}
+	if config.ReadOnly() {
+		flags.ReadOnly = true
+	}
+
+	if flags.ReadOnly && flags.MakesChanges(c) {
+		c.SilenceUsage = true
+		return &flags.ReadOnlyError{Operation: c.CommandPath()}
+	}
+	return nil
+}
+
+// recordUsage adds the command that has just run to the user's local usage statistics, which they have opted in to
+func recordUsage(c *cobra.Command, started time.Time) {
+	if !c.HasParent() {
+		return
+	}
+	command := strings.TrimPrefix(c.CommandPath(), c.Root().Name()+" ")
+
+	flagNames := []string{}
+	c.Flags().Visit(func(f *pflag.Flag) {
+		flagNames = append(flagNames, f.Name)
+	})
+
+	reposFilename := "repos.txt"
+	if reposFlag := c.Flags().Lookup("repos"); reposFlag != nil {
+		reposFilename = reposFlag.Value.String()
+	}
+	repoCount := 0
+	if repos, err := campaign.ReadReposFile(reposFilename); err == nil {
+		repoCount = len(repos)
+	}
+
+	entry := usage.NewEntry(command, flagNames, started, repoCount, exitcode.Code(), version)
+	if err := usage.Append(entry); err != nil {
+		log.Printf("Unable to record usage statistics: %s", err)
 	}
 }
@@ -0,0 +1,52 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package cmd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/cmd/flags"
+	"github.com/skyscanner/turbolift/internal/executor"
+)
+
+func TestEachRootCommandStartsFromTheDefaultFlags(t *testing.T) {
+	root := newRootCmd()
+	assert.NoError(t, root.ParseFlags([]string{"--verbose", "--retries=5"}))
+	assert.True(t, flags.Verbose)
+	assert.Equal(t, 5, flags.Retries)
+
+	root = newRootCmd()
+	assert.False(t, flags.Verbose)
+	assert.Equal(t, 2, flags.Retries)
+	assert.False(t, root.PersistentFlags().Changed("verbose"))
+}
+
+func TestItPassesTheCommandTimeoutAndRetriesToTheExecutors(t *testing.T) {
+	defer func(timeout time.Duration, retries int, delay time.Duration) {
+		executor.DefaultTimeout, executor.DefaultRetries, executor.DefaultRetryDelay = timeout, retries, delay
+	}(executor.DefaultTimeout, executor.DefaultRetries, executor.DefaultRetryDelay)
+
+	_, err := Run(context.Background(), "--command-timeout=3m", "--retries=4", "--retry-delay=1s", "campaigns", "list", "--path", t.TempDir())
+	assert.NoError(t, err)
+
+	assert.Equal(t, 3*time.Minute, executor.DefaultTimeout)
+	assert.Equal(t, 4, executor.DefaultRetries)
+	assert.Equal(t, time.Second, executor.DefaultRetryDelay)
+}
@@ -0,0 +1,426 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package serve
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skyscanner/turbolift/cmd/flags"
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/logging"
+	"github.com/skyscanner/turbolift/internal/status"
+)
+
+var (
+	//go:embed templates/dashboard.html
+	dashboardTemplateText string
+	dashboardTemplate     = template.Must(template.New("dashboard").Funcs(templateFuncs).Parse(dashboardTemplateText))
+
+	//go:embed templates/operation.html
+	operationTemplateText string
+	operationTemplate     = template.Must(template.New("operation").Funcs(templateFuncs).Parse(operationTemplateText))
+
+	templateFuncs = template.FuncMap{
+		"yesNo": func(b bool) string {
+			if b {
+				return "yes"
+			}
+			return "no"
+		},
+		"when": func(t time.Time) string {
+			return t.Local().Format("2006-01-02 15:04:05")
+		},
+	}
+)
+
+// retryableCommands are the commands that the dashboard can run again over the repos they failed for, along with
+// which repos those are
+var retryableCommands = map[string]func(status.Row) bool{
+	"clone":      func(row status.Row) bool { return !row.Cloned },
+	"create-prs": func(row status.Row) bool { return row.Cloned && row.PrState == "" },
+}
+
+// dashboard serves the campaign's progress, and runs commands to retry the repos that failed
+type dashboard struct {
+	ctx        context.Context
+	logger     *logging.Logger
+	dir        *campaign.Campaign
+	executable string
+	// address is the address the dashboard listens on, which requests must be addressed to unless they are addressed
+	// to a loopback host
+	address string
+	// token is sent with every form on the dashboard, and POSTs without it are rejected
+	token string
+	// readOnly stops the dashboard from running commands, in read-only mode
+	readOnly bool
+
+	// mu guards everything below, which is updated as status is collected and operations run
+	mu         sync.Mutex
+	rows       []status.Row
+	summary    status.Summary
+	collected  time.Time
+	collecting bool
+	operations []*operation
+	// running tracks the collection and operations in progress, so that they can finish before turbolift exits
+	running sync.WaitGroup
+}
+
+// operation is a turbolift command that the dashboard ran over some of the campaign's repos
+type operation struct {
+	Id       int
+	Command  string
+	Repos    []string
+	Started  time.Time
+	Finished time.Time
+	Err      error
+
+	mu  sync.Mutex
+	log bytes.Buffer
+}
+
+func (o *operation) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.log.Write(p)
+}
+
+// terminalControl matches the escape sequences and carriage returns that turbolift writes to redraw its spinners
+var terminalControl = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]|\r`)
+
+// Log is the output of the command so far, without any terminal control sequences
+func (o *operation) Log() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return terminalControl.ReplaceAllString(o.log.String(), "")
+}
+
+// Running is true until the command has finished
+func (o *operation) Running() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.Finished.IsZero()
+}
+
+func (o *operation) finish(err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.Finished = time.Now()
+	o.Err = err
+}
+
+func newDashboard(ctx context.Context, logger *logging.Logger, dir *campaign.Campaign, executable string, address string) (*dashboard, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, fmt.Errorf("unable to generate a token for the dashboard's forms: %w", err)
+	}
+	return &dashboard{
+		ctx:        ctx,
+		logger:     logger,
+		dir:        dir,
+		executable: executable,
+		address:    address,
+		token:      hex.EncodeToString(token),
+		readOnly:   flags.ReadOnly,
+	}, nil
+}
+
+func (d *dashboard) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", d.showDashboard)
+	mux.HandleFunc("/refresh", d.postOnly(d.startRefresh))
+	mux.HandleFunc("/operations", d.postOnly(d.startOperation))
+	mux.HandleFunc("/operations/", d.showOperation)
+	return d.hostOnly(mux)
+}
+
+// hostOnly rejects requests that are not addressed to the dashboard, so that sites that resolve their own name to
+// the dashboard's address can neither read its pages, which include the token, nor run commands
+func (d *dashboard) hostOnly(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !d.allowedHost(r.Host) {
+			http.Error(w, "requests must be addressed to the dashboard", http.StatusForbidden)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// postOnly rejects requests that are not POSTs from the dashboard itself, so that other sites open in the browser
+// cannot make it run commands. Forms on other sites cannot know the token.
+func (d *dashboard) postOnly(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "only POST is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" && origin != "http://"+r.Host {
+			http.Error(w, "requests must come from the dashboard", http.StatusForbidden)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.PostFormValue("token")), []byte(d.token)) != 1 {
+			http.Error(w, "requests must come from the dashboard's own forms", http.StatusForbidden)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// allowedHost is true for a Host header naming a loopback host, or the address the dashboard listens on
+func (d *dashboard) allowedHost(host string) bool {
+	if host == d.address {
+		return true
+	}
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	if strings.EqualFold(hostname, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(hostname)
+	return ip != nil && ip.IsLoopback()
+}
+
+// refresh collects the status of every repo again, in the background. It does nothing if a collection is already
+// in progress.
+func (d *dashboard) refresh() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.collecting {
+		return
+	}
+	d.collecting = true
+
+	d.running.Add(1)
+	go func() {
+		defer d.running.Done()
+		rows, summary := status.Collect(d.ctx, d.logger, gh, bb, g, d.dir)
+
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.rows, d.summary, d.collected, d.collecting = rows, summary, time.Now(), false
+	}()
+}
+
+// wait waits for the collection and operations in progress to finish
+func (d *dashboard) wait() {
+	d.running.Wait()
+}
+
+type dashboardData struct {
+	Campaign   *campaign.Campaign
+	Rows       []repoView
+	Summary    status.Summary
+	Collected  time.Time
+	Collecting bool
+	// Retryable lists the repos that each command can be retried for, which is none in read-only mode
+	Retryable   map[string][]string
+	ReadOnly    bool
+	Token       string
+	Operations  []*operation
+	State       *campaign.State
+	StateErr    error
+	AnyRunning  bool
+	RefreshSecs int
+}
+
+// repoView is a repo's status, along with the commands that can be retried for it
+type repoView struct {
+	status.Row
+	Retry []string
+}
+
+func sortedCommands() []string {
+	var commands []string
+	for command := range retryableCommands {
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+	return commands
+}
+
+func (d *dashboard) showDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	d.mu.Lock()
+	data := dashboardData{
+		Campaign:   d.dir,
+		Summary:    d.summary,
+		Collected:  d.collected,
+		Collecting: d.collecting,
+		Retryable:  map[string][]string{},
+		ReadOnly:   d.readOnly,
+		Token:      d.token,
+	}
+	for i := len(d.operations) - 1; i >= 0; i-- {
+		data.Operations = append(data.Operations, d.operations[i])
+	}
+	rows := d.rows
+	d.mu.Unlock()
+
+	for _, row := range rows {
+		view := repoView{Row: row}
+		for _, command := range sortedCommands() {
+			if !d.readOnly && retryableCommands[command](row) {
+				view.Retry = append(view.Retry, command)
+				data.Retryable[command] = append(data.Retryable[command], row.Repository)
+			}
+		}
+		data.Rows = append(data.Rows, view)
+	}
+	for _, op := range data.Operations {
+		data.AnyRunning = data.AnyRunning || op.Running()
+	}
+	// the page reloads itself while anything is changing, so that it stays up to date
+	if data.Collecting || data.AnyRunning {
+		data.RefreshSecs = 2
+	}
+	data.State, data.StateErr = campaign.ReadState()
+
+	render(w, dashboardTemplate, data)
+}
+
+func (d *dashboard) startRefresh(w http.ResponseWriter, r *http.Request) {
+	d.refresh()
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (d *dashboard) startOperation(w http.ResponseWriter, r *http.Request) {
+	if d.readOnly {
+		http.Error(w, "turbolift is in read-only mode, so the dashboard cannot run commands", http.StatusForbidden)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	command := r.Form.Get("command")
+	if _, ok := retryableCommands[command]; !ok {
+		http.Error(w, fmt.Sprintf("%s cannot be run from the dashboard", command), http.StatusBadRequest)
+		return
+	}
+	op, err := d.start(command, r.Form["repo"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/operations/%d", op.Id), http.StatusSeeOther)
+}
+
+// start runs a turbolift command over some of the campaign's repos, in the background. Only one command runs at a
+// time, as commands update the campaign state and working copies.
+func (d *dashboard) start(command string, repoNames []string) (*operation, error) {
+	selected := map[string]bool{}
+	for _, name := range repoNames {
+		selected[name] = true
+	}
+	var repos []campaign.Repo
+	for _, repo := range d.dir.Repos {
+		if selected[repo.FullRepoName] {
+			repos = append(repos, repo)
+		}
+	}
+	if len(repos) == 0 {
+		return nil, fmt.Errorf("none of the repos given are in the campaign")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, op := range d.operations {
+		if op.Running() {
+			return nil, fmt.Errorf("turbolift %s is still running, so wait for it to finish first", op.Command)
+		}
+	}
+
+	op := &operation{Id: len(d.operations) + 1, Command: command, Started: time.Now()}
+	for _, repo := range repos {
+		op.Repos = append(op.Repos, repo.FullRepoName)
+	}
+	// the repos are written to a repos file of their own, so that the command only runs over them
+	if err := os.MkdirAll(campaign.StateDirectory, os.ModeDir|0o755); err != nil {
+		return nil, fmt.Errorf("unable to create %s: %w", campaign.StateDirectory, err)
+	}
+	reposFile := filepath.Join(campaign.StateDirectory, fmt.Sprintf("serve-%d-repos.json", op.Id))
+	if err := campaign.WriteReposFile(reposFile, repos); err != nil {
+		return nil, err
+	}
+	d.operations = append(d.operations, op)
+
+	d.running.Add(1)
+	go func() {
+		defer d.running.Done()
+		_, _ = fmt.Fprintf(op, "$ turbolift %s (%d repos)\n", command, len(repos))
+		err := exec.Execute(d.ctx, op, ".", d.executable, command, "--repos", reposFile, "--branch", d.dir.BranchName)
+		op.finish(err)
+		d.refresh()
+	}()
+	return op, nil
+}
+
+func (d *dashboard) showOperation(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/operations/")
+	rawLog := strings.HasSuffix(path, "/log")
+	path = strings.TrimSuffix(path, "/log")
+	id, err := strconv.Atoi(path)
+
+	d.mu.Lock()
+	var op *operation
+	if err == nil && id >= 1 && id <= len(d.operations) {
+		op = d.operations[id-1]
+	}
+	d.mu.Unlock()
+	if op == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if rawLog {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte(op.Log()))
+		return
+	}
+	render(w, operationTemplate, op)
+}
+
+func render(w http.ResponseWriter, t *template.Template, data interface{}) {
+	var page bytes.Buffer
+	if err := t.Execute(&page, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = page.WriteTo(w)
+}
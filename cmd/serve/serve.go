@@ -0,0 +1,146 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package serve
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/skyscanner/turbolift/internal/bitbucket"
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/colors"
+	"github.com/skyscanner/turbolift/internal/executor"
+	"github.com/skyscanner/turbolift/internal/git"
+	"github.com/skyscanner/turbolift/internal/github"
+	"github.com/skyscanner/turbolift/internal/interrupt"
+	"github.com/skyscanner/turbolift/internal/logging"
+)
+
+var (
+	gh   github.GitHub     = github.NewRealGitHub()
+	bb   github.GitHub     = bitbucket.NewBitbucketServer()
+	g    git.Git           = git.NewRealGit()
+	exec executor.Executor = executor.NewRealExecutor()
+)
+
+var (
+	address     string
+	allowRemote bool
+	repoFile    string
+	branchName  string
+)
+
+// shutdownTimeout is how long requests in progress have to finish once the server is asked to stop
+const shutdownTimeout = 5 * time.Second
+
+func NewServeCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve a local dashboard of the campaign's progress",
+		Long: `Serve a local dashboard of the campaign's progress.
+
+The dashboard lists every repo with whether it has been cloned, whether changes have been committed, and the
+state of its PR, along with the campaign state the other commands keep. Repos that could not be cloned, or that
+have no PR, can be retried from the dashboard, which runs turbolift clone or create-prs over just those repos
+and shows their output as it runs.`,
+		Run: run,
+	}
+
+	cmd.Flags().StringVar(&address, "address", "127.0.0.1:8080", "The address to serve the dashboard on")
+	cmd.Flags().BoolVar(&allowRemote, "allow-remote", false, "Serve the dashboard on an address that is not loopback, where anyone who can reach it can run turbolift commands as you")
+	cmd.Flags().StringVar(&repoFile, "repos", "repos.txt", "A file containing a list of repositories to clone.")
+	cmd.Flags().StringVar(&branchName, "branch", "", "The branch to use for the campaign (defaults to branch in turbolift.yaml, or the campaign name)")
+
+	return cmd
+}
+
+func run(c *cobra.Command, _ []string) {
+	ctx := c.Context()
+	logger := logging.NewLogger(c)
+
+	readCampaignActivity := logger.StartActivity("Reading campaign data (%s)", repoFile)
+	options := campaign.NewCampaignOptions()
+	options.RepoFilename = repoFile
+	options.BranchName = branchName
+	dir, err := campaign.OpenCampaign(options)
+	if err != nil {
+		readCampaignActivity.EndWithFailure(err)
+		return
+	}
+	readCampaignActivity.EndWithSuccess()
+
+	executable, err := os.Executable()
+	if err != nil {
+		logger.Errorf("Unable to find the turbolift executable to run commands with: %s", err)
+		return
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		logger.Errorf("Unable to serve on %s: %s", address, err)
+		return
+	}
+	if !isLoopback(listener.Addr()) {
+		if !allowRemote {
+			_ = listener.Close()
+			logger.Errorf("Refusing to serve the dashboard on %s, which is not a loopback address: anyone who can reach it could run turbolift commands as you. Use --allow-remote if that is intended.", listener.Addr())
+			return
+		}
+		logger.Warnf("The dashboard is served on %s, which is not a loopback address. Anyone who can reach it can see the campaign and run turbolift commands as you.", listener.Addr())
+	}
+
+	dashboard, err := newDashboard(ctx, logger, dir, executable, listener.Addr().String())
+	if err != nil {
+		_ = listener.Close()
+		logger.Errorf("Unable to serve the dashboard: %s", err)
+		return
+	}
+	dashboard.refresh()
+
+	httpServer := &http.Server{Handler: dashboard.routes()}
+	served := make(chan error, 1)
+	go func() {
+		served <- httpServer.Serve(listener)
+	}()
+	logger.Successf("Serving the dashboard for %s at %s %s(press Ctrl-C to stop)", dir.Name, colors.Cyan("http://", listener.Addr()), colors.Normal())
+
+	select {
+	case err = <-served:
+	case <-interrupt.Stopping(ctx).Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		err = httpServer.Shutdown(shutdownCtx)
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Errorf("The dashboard stopped: %s", err)
+		return
+	}
+
+	dashboard.wait()
+	logger.Successf("turbolift serve stopped")
+}
+
+// isLoopback is true for addresses that only this machine can reach
+func isLoopback(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	return ok && tcpAddr.IP.IsLoopback()
+}
@@ -0,0 +1,305 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package serve
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/cmd/flags"
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/executor"
+	"github.com/skyscanner/turbolift/internal/git"
+	"github.com/skyscanner/turbolift/internal/github"
+	"github.com/skyscanner/turbolift/internal/logging"
+	"github.com/skyscanner/turbolift/internal/testsupport"
+)
+
+func init() {
+	// disable output colouring so that strings we want to do 'Contains' checks on do not have ANSI escape sequences in IDEs
+	_ = os.Setenv("NO_COLOR", "1")
+}
+
+func TestItShowsTheStatusOfEachRepo(t *testing.T) {
+	prepareFakes()
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2", "org/repoWithError", "org/repoNotCloned")
+	_ = os.Remove("work/org/repoNotCloned")
+
+	dashboard := openDashboard(t)
+
+	page := get(t, dashboard, "/")
+	assert.Contains(t, page, "<h1>Turbolift campaign: "+testsupport.Pwd()+"</h1>")
+	assert.Contains(t, page, "Completion: 25% of repos merged")
+	assert.Contains(t, page, `<tr><td>org/repo1</td><td>yes</td><td>yes</td><td>OPEN</td><td>FAILURE</td><td><a href="https://github.com/org/repo1/pull/1">`)
+	assert.Contains(t, page, "Run turbolift clone for 1 repos")
+	assert.Contains(t, page, "Run turbolift create-prs for 1 repos")
+	assert.Contains(t, page, `<input type="hidden" name="repo" value="org/repoWithError"><button type="submit">create-prs</button>`)
+}
+
+func TestItShowsTheCampaignState(t *testing.T) {
+	prepareFakes()
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+	state := &campaign.State{
+		Branches:    []string{"first-try", testsupport.Pwd()},
+		Checkpoints: []campaign.Checkpoint{{Command: "create-prs", Branch: testsupport.Pwd(), Completed: []string{"org/repo1"}}},
+	}
+	assert.NoError(t, state.Save())
+
+	page := get(t, openDashboard(t), "/")
+	assert.Contains(t, page, "Branches: first-try, "+testsupport.Pwd())
+	assert.Contains(t, page, "turbolift create-prs on "+testsupport.Pwd()+", 1 repos done")
+}
+
+func TestItRetriesFailedReposAndShowsTheLog(t *testing.T) {
+	prepareFakes()
+	fakeExecutor := executor.NewFakeExecutor(func(workingDir string, name string, args ...string) error {
+		return nil
+	}, nil)
+	exec = fakeExecutor
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repoWithError")
+
+	dashboard := openDashboard(t)
+	response := post(dashboard, "/operations", url.Values{"command": {"create-prs"}, "repo": {"org/repoWithError"}}, "")
+	assert.Equal(t, http.StatusSeeOther, response.Code)
+	assert.Equal(t, "/operations/1", response.Header().Get("Location"))
+	dashboard.wait()
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{".", "/usr/local/bin/turbolift", "create-prs", "--repos", ".turbolift-state/serve-1-repos.json", "--branch", testsupport.Pwd()},
+	})
+	repos, err := os.ReadFile(".turbolift-state/serve-1-repos.json")
+	assert.NoError(t, err)
+	assert.Contains(t, string(repos), `"repo": "org/repoWithError"`)
+	assert.NotContains(t, string(repos), "org/repo1")
+
+	page := get(t, dashboard, "/operations/1")
+	assert.Contains(t, page, "$ turbolift create-prs (1 repos)")
+	assert.Contains(t, page, "succeeded")
+	assert.Contains(t, get(t, dashboard, "/"), `<a href="/operations/1">turbolift create-prs</a>`)
+}
+
+func TestItOnlyRunsOneOperationAtATime(t *testing.T) {
+	prepareFakes()
+	release := make(chan struct{})
+	exec = executor.NewFakeExecutor(func(workingDir string, name string, args ...string) error {
+		<-release
+		return errors.New("exit status 1")
+	}, nil)
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+	_ = os.Remove("work/org/repo1")
+
+	dashboard := openDashboard(t)
+	form := url.Values{"command": {"clone"}, "repo": {"org/repo1"}}
+	assert.Equal(t, http.StatusSeeOther, post(dashboard, "/operations", form, "").Code)
+
+	response := post(dashboard, "/operations", form, "")
+	assert.Equal(t, http.StatusConflict, response.Code)
+	assert.Contains(t, response.Body.String(), "turbolift clone is still running")
+	assert.Contains(t, get(t, dashboard, "/operations/1"), "running")
+
+	close(release)
+	dashboard.wait()
+	assert.Contains(t, get(t, dashboard, "/operations/1"), "failed: exit status 1")
+}
+
+func TestItRejectsUnsafeRequests(t *testing.T) {
+	prepareFakes()
+	exec = executor.NewAlwaysSucceedsFakeExecutor()
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+	dashboard := openDashboard(t)
+
+	response := post(dashboard, "/operations", url.Values{"command": {"clean"}, "repo": {"org/repo1"}}, "")
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+	assert.Contains(t, response.Body.String(), "clean cannot be run from the dashboard")
+
+	response = post(dashboard, "/operations", url.Values{"command": {"clone"}, "repo": {"org/repo1"}}, "http://evil.example.com")
+	assert.Equal(t, http.StatusForbidden, response.Code)
+
+	response = post(dashboard, "/operations", url.Values{"command": {"clone"}, "repo": {"org/repo1"}, "token": {"guessed"}}, "")
+	assert.Equal(t, http.StatusForbidden, response.Code)
+	assert.Contains(t, response.Body.String(), "dashboard's own forms")
+
+	// a site that resolves its own name to the dashboard's address sends its own name as the Host
+	form := url.Values{"command": {"clone"}, "repo": {"org/repo1"}, "token": {dashboard.token}}
+	response = postTo(dashboard, "evil.example.com:8080", "/operations", form, "http://evil.example.com:8080")
+	assert.Equal(t, http.StatusForbidden, response.Code)
+	assert.Contains(t, response.Body.String(), "addressed to the dashboard")
+
+	response = post(dashboard, "/operations", url.Values{"command": {"clone"}, "repo": {"other/repo"}}, "")
+	assert.Equal(t, http.StatusConflict, response.Code)
+	assert.Contains(t, response.Body.String(), "none of the repos given are in the campaign")
+
+	assert.Equal(t, http.StatusMethodNotAllowed, getFrom(dashboard, "127.0.0.1:8080", "/operations").Code)
+}
+
+func TestItOnlyShowsPagesForRequestsAddressedToTheDashboard(t *testing.T) {
+	prepareFakes()
+	exec = executor.NewAlwaysSucceedsFakeExecutor()
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repoWithError")
+	dashboard := openDashboard(t)
+	assert.Equal(t, http.StatusSeeOther, post(dashboard, "/operations", url.Values{"command": {"create-prs"}, "repo": {"org/repoWithError"}}, "").Code)
+	dashboard.wait()
+
+	// otherwise a site that resolves its own name to the dashboard's address could read the token from its pages
+	for _, path := range []string{"/", "/operations/1", "/operations/1/log"} {
+		response := getFrom(dashboard, "evil.example.com:8080", path)
+		assert.Equal(t, http.StatusForbidden, response.Code, path)
+		assert.NotContains(t, response.Body.String(), dashboard.token, path)
+	}
+	assert.Equal(t, http.StatusOK, getFrom(dashboard, "localhost:8080", "/operations/1").Code)
+}
+
+func TestItRefusesToServeOnAddressesThatAreNotLoopback(t *testing.T) {
+	prepareFakes()
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runServeCommand("--address", "0.0.0.0:0")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "which is not a loopback address")
+	assert.Contains(t, out, "Use --allow-remote if that is intended")
+	assert.NotContains(t, out, "Serving the dashboard")
+}
+
+func TestItAcceptsRequestsAddressedToLoopbackOrTheListenAddress(t *testing.T) {
+	prepareFakes()
+	exec = executor.NewAlwaysSucceedsFakeExecutor()
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+	dashboard := openDashboard(t)
+	assert.Contains(t, get(t, dashboard, "/"), `name="token" value="`+dashboard.token+`"`)
+
+	for _, host := range []string{"localhost:8080", "[::1]:8080", "192.168.1.20:8080"} {
+		response := postTo(dashboard, host, "/refresh", url.Values{"token": {dashboard.token}}, "http://"+host)
+		assert.Equal(t, http.StatusSeeOther, response.Code, host)
+		dashboard.wait()
+	}
+}
+
+func TestItDoesNotRunCommandsInReadOnlyMode(t *testing.T) {
+	prepareFakes()
+	fakeExecutor := executor.NewAlwaysSucceedsFakeExecutor()
+	exec = fakeExecutor
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repoWithError")
+	flags.ReadOnly = true
+	defer func() {
+		flags.ReadOnly = false
+	}()
+
+	dashboard := openDashboard(t)
+	page := get(t, dashboard, "/")
+	assert.Contains(t, page, "Read-only mode")
+	assert.Contains(t, page, "org/repoWithError")
+	assert.NotContains(t, page, `action="/operations"`)
+
+	response := post(dashboard, "/operations", url.Values{"command": {"create-prs"}, "repo": {"org/repoWithError"}}, "")
+	assert.Equal(t, http.StatusForbidden, response.Code)
+	assert.Contains(t, response.Body.String(), "read-only mode")
+	fakeExecutor.AssertCalledWith(t, [][]string{})
+}
+
+func TestItStripsTerminalControlSequencesFromLogs(t *testing.T) {
+	op := &operation{}
+	_, _ = op.Write([]byte("\x1b[?25l\x1b[?25h\r  OK   Cloning org/repo1\n"))
+	assert.Equal(t, "  OK   Cloning org/repo1\n", op.Log())
+}
+
+func runServeCommand(args ...string) (string, error) {
+	cmd := NewServeCmd()
+	cmd.SetArgs(args)
+	outBuffer := bytes.NewBufferString("")
+	cmd.SetOut(outBuffer)
+	err := cmd.ExecuteContext(context.Background())
+	return outBuffer.String(), err
+}
+
+// openDashboard opens the dashboard for the campaign in the current directory, once it has collected its status
+func openDashboard(t *testing.T) *dashboard {
+	dir, err := campaign.OpenCampaign(campaign.NewCampaignOptions())
+	assert.NoError(t, err)
+
+	cmd := &cobra.Command{}
+	cmd.SetOut(io.Discard)
+	d, err := newDashboard(context.Background(), logging.NewLogger(cmd), dir, "/usr/local/bin/turbolift", "192.168.1.20:8080")
+	assert.NoError(t, err)
+	d.refresh()
+	d.wait()
+	return d
+}
+
+func get(t *testing.T, d *dashboard, path string) string {
+	recorder := getFrom(d, "127.0.0.1:8080", path)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	return recorder.Body.String()
+}
+
+func getFrom(d *dashboard, host string, path string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodGet, path, nil)
+	request.Host = host
+	recorder := httptest.NewRecorder()
+	d.routes().ServeHTTP(recorder, request)
+	return recorder
+}
+
+// post submits a form to the dashboard as its own pages would, with its token unless the form gives one
+func post(d *dashboard, path string, form url.Values, origin string) *httptest.ResponseRecorder {
+	if form.Get("token") == "" {
+		form.Set("token", d.token)
+	}
+	return postTo(d, "127.0.0.1:8080", path, form, origin)
+}
+
+func postTo(d *dashboard, host string, path string, form url.Values, origin string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	request.Host = host
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if origin != "" {
+		request.Header.Set("Origin", origin)
+	}
+	recorder := httptest.NewRecorder()
+	d.routes().ServeHTTP(recorder, request)
+	return recorder
+}
+
+func prepareFakes() {
+	dummyData := map[string]*github.PrStatus{
+		"work/org/repo1": {
+			State:             "OPEN",
+			StatusCheckRollup: []github.StatusCheckRollup{{State: "FAILURE"}},
+			Url:               "https://github.com/org/repo1/pull/1",
+		},
+		"work/org/repo2": {
+			State: "MERGED",
+			Url:   "https://github.com/org/repo2/pull/2",
+		},
+	}
+	gh = github.NewFakeGitHub(nil, func(workingDir string) (interface{}, error) {
+		if workingDir == "work/org/repoWithError" {
+			return nil, errors.New("synthetic error")
+		}
+		return dummyData[workingDir], nil
+	})
+	g = git.NewAlwaysSucceedsFakeGit()
+}
@@ -0,0 +1,332 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package stats
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/rodaine/table"
+	"github.com/spf13/cobra"
+
+	"github.com/skyscanner/turbolift/cmd/flags"
+	"github.com/skyscanner/turbolift/internal/bitbucket"
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/colors"
+	"github.com/skyscanner/turbolift/internal/exitcode"
+	"github.com/skyscanner/turbolift/internal/forge"
+	"github.com/skyscanner/turbolift/internal/github"
+	"github.com/skyscanner/turbolift/internal/interrupt"
+	"github.com/skyscanner/turbolift/internal/logging"
+	"github.com/skyscanner/turbolift/internal/usage"
+)
+
+var (
+	gh github.GitHub = github.NewRealGitHub()
+	bb github.GitHub = bitbucket.NewBitbucketServer()
+)
+
+var (
+	format     string
+	outputFile string
+	noRecord   bool
+	offline    bool
+	repoFile   string
+	branchName string
+)
+
+// progressBarWidth is the number of characters in the burn-down bar shown for each snapshot
+const progressBarWidth = 20
+
+func NewStatsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "stats",
+		Short: "Record a snapshot of the campaign's PRs and show its progress over time",
+		Run:   run,
+	}
+
+	cmd.Flags().StringVar(&format, "format", "table", "Format to show the snapshots in: table or json")
+	cmd.Flags().StringVarP(&outputFile, "output", "o", "stats.json", "File to write the snapshots to, when the format is json")
+	cmd.Flags().BoolVar(&noRecord, "no-record", false, "Show the current progress without recording it as a snapshot")
+	cmd.Flags().BoolVar(&offline, "offline", false, "Show the snapshots already recorded, without checking the PRs again")
+	cmd.Flags().StringVar(&repoFile, "repos", "repos.txt", "A file containing a list of repositories to clone.")
+	cmd.Flags().StringVar(&branchName, "branch", "", "The branch to use for the campaign (defaults to branch in turbolift.yaml, or the campaign name)")
+
+	cmd.AddCommand(newExportCmd())
+
+	return cmd
+}
+
+func run(c *cobra.Command, _ []string) {
+	ctx := c.Context()
+	logger := logging.NewLogger(c)
+
+	if format != "table" && format != "json" {
+		logger.Errorf("Unknown stats format %s: must be one of table, json", format)
+		return
+	}
+
+	state, err := campaign.ReadState()
+	if err != nil {
+		logger.Errorf("%s", err)
+		return
+	}
+	snapshots := state.Snapshots
+
+	if !offline {
+		readCampaignActivity := logger.StartActivity("Reading campaign data (%s)", repoFile)
+		options := campaign.NewCampaignOptions()
+		options.RepoFilename = repoFile
+		options.BranchName = branchName
+		dir, err := campaign.OpenCampaign(options)
+		if err != nil {
+			readCampaignActivity.EndWithFailure(err)
+			return
+		}
+		readCampaignActivity.EndWithSuccess()
+
+		for _, pr := range state.PullRequests {
+			gh.RememberPR(pr.FullRepoPath(), pr.Branch, pr.Number)
+			bb.RememberPR(pr.FullRepoPath(), pr.Branch, pr.Number)
+		}
+		cachedGitHub, cachedBitbucket := github.WithCache(gh, flags.Cache()), github.WithCache(bb, flags.Cache())
+
+		snapshot := campaign.Snapshot{Taken: time.Now().UTC()}
+		var timesToMerge []time.Duration
+		for i, repo := range dir.Repos {
+			if interrupt.Requested(ctx) {
+				// a snapshot of only some of the repos would make the campaign look further behind than it is
+				logger.Warnf("Interrupted, so not checking the remaining %d repos or recording a snapshot", len(dir.Repos)-i)
+				exitcode.Record(true, 0, 0)
+				return
+			}
+			repoDirPath := repo.FullRepoPath()
+
+			checkStatusActivity := logger.StartActivity("Checking PR status for %s", repo.FullRepoName)
+
+			if _, err = os.Stat(repoDirPath); os.IsNotExist(err) {
+				checkStatusActivity.EndWithWarningf("Directory %s does not exist - has it been cloned?", repoDirPath)
+				snapshot.NotCloned++
+				continue
+			}
+
+			prStatus, err := forge.For(repo, cachedGitHub, cachedBitbucket).GetPR(ctx, checkStatusActivity.Writer(), repoDirPath, dir.BranchName)
+			if github.IsRepoGone(err) {
+				checkStatusActivity.EndWithWarning(err)
+				snapshot.Gone++
+				continue
+			}
+			if err != nil {
+				checkStatusActivity.EndWithWarningf("No PR found: %v", err)
+				snapshot.NoPR++
+				continue
+			}
+
+			switch prStatus.State {
+			case "MERGED":
+				snapshot.Merged++
+				if !prStatus.CreatedAt.IsZero() && !prStatus.MergedAt.IsZero() {
+					timesToMerge = append(timesToMerge, prStatus.MergedAt.Sub(prStatus.CreatedAt))
+				}
+			case "OPEN":
+				snapshot.Open++
+			case "CLOSED":
+				snapshot.Closed++
+			}
+			checkStatusActivity.EndWithSuccess()
+		}
+		snapshot.MedianHoursToMerge = median(timesToMerge).Hours()
+		snapshots = append(snapshots, snapshot)
+
+		if flags.ReadOnly && !noRecord {
+			logger.Printf("Not recording the snapshot, as turbolift is in read-only mode")
+		} else if !noRecord {
+			state.Snapshots = snapshots
+			if err := state.Save(); err != nil {
+				logger.Errorf("Unable to record the snapshot: %s", err)
+				return
+			}
+		}
+
+		github.LogApiUsage(ctx, gh, logger, dir.Hosts())
+	}
+
+	if len(snapshots) == 0 {
+		logger.Warnf("No snapshots have been recorded yet - run turbolift stats without --offline to record one")
+		return
+	}
+
+	if format == "json" {
+		writeActivity := logger.StartActivity("Writing %d snapshots to %s", len(snapshots), outputFile)
+		if err := writeJson(outputFile, snapshots); err != nil {
+			writeActivity.EndWithFailure(err)
+			return
+		}
+		writeActivity.EndWithSuccess()
+		logger.Successf("turbolift stats completed %s(snapshots written to %s)\n", colors.Normal(), colors.Cyan(outputFile))
+		return
+	}
+
+	snapshotsTable := table.New("Taken", "Merged", "Open", "Closed", "No PR", "Not cloned", "Gone", "Progress", "Change", "Median time to merge")
+	snapshotsTable.WithHeaderFormatter(color.New(color.Underline).SprintfFunc())
+	snapshotsTable.WithFirstColumnFormatter(color.New(color.FgCyan).SprintfFunc())
+	snapshotsTable.WithWriter(logger.Writer())
+	for i, snapshot := range snapshots {
+		change := ""
+		if i > 0 {
+			change = fmt.Sprintf("%+d%%", snapshot.Completion()-snapshots[i-1].Completion())
+		}
+		snapshotsTable.AddRow(snapshot.Taken.Local().Format("2006-01-02 15:04"), snapshot.Merged, snapshot.Open, snapshot.Closed,
+			snapshot.NoPR, snapshot.NotCloned, snapshot.Gone, progressBar(snapshot.Completion()), change,
+			formatHours(snapshot.MedianHoursToMerge))
+	}
+	logger.Println()
+	snapshotsTable.Print()
+	logger.Println()
+
+	latest := snapshots[len(snapshots)-1]
+	logger.Successf("turbolift stats completed %s(%d%% of repos merged)\n", colors.Normal(), latest.Completion())
+}
+
+var (
+	exportFile string
+	clearUsage bool
+)
+
+// usageExport is what is written for the user to share: totals for each command, and the runs they came from
+type usageExport struct {
+	Exported string                 `json:"exported"`
+	Commands []usage.CommandSummary `json:"commands"`
+	Runs     []usage.Entry          `json:"runs"`
+}
+
+func newExportCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export the usage statistics recorded on this machine, to share with whoever maintains turbolift for you",
+		Run:   runExport,
+	}
+
+	cmd.Flags().StringVarP(&exportFile, "output", "o", "turbolift-usage.json", "File to export the usage statistics to")
+	cmd.Flags().BoolVar(&clearUsage, "clear", false, "Clear the recorded usage statistics once they have been exported")
+
+	return cmd
+}
+
+func runExport(c *cobra.Command, _ []string) {
+	logger := logging.NewLogger(c)
+
+	entries, err := usage.Read()
+	if err != nil {
+		logger.Errorf("%s", err)
+		return
+	}
+	if len(entries) == 0 {
+		if usage.Enabled() {
+			logger.Warnf("No usage statistics have been recorded in %s yet", usage.File())
+		} else {
+			logger.Warnf("No usage statistics have been recorded - set %s=1 to opt in to recording them", usage.EnableVariable)
+		}
+		return
+	}
+
+	summaries := usage.Summarise(entries)
+	writeActivity := logger.StartActivity("Writing %d runs to %s", len(entries), exportFile)
+	export := usageExport{
+		Exported: time.Now().UTC().Format("2006-01-02"),
+		Commands: summaries,
+		Runs:     entries,
+	}
+	contents, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		writeActivity.EndWithFailure(err)
+		return
+	}
+	if err := os.WriteFile(exportFile, append(contents, '\n'), 0o644); err != nil {
+		writeActivity.EndWithFailuref("unable to write usage statistics: %s", err)
+		return
+	}
+	writeActivity.EndWithSuccess()
+
+	if clearUsage {
+		clearActivity := logger.StartActivity("Clearing %s", usage.File())
+		if err := os.Remove(usage.File()); err != nil {
+			clearActivity.EndWithFailure(err)
+			return
+		}
+		clearActivity.EndWithSuccess()
+	}
+
+	commandsTable := table.New("Command", "Runs", "Failures", "Repos", "Total time")
+	commandsTable.WithHeaderFormatter(color.New(color.Underline).SprintfFunc())
+	commandsTable.WithFirstColumnFormatter(color.New(color.FgCyan).SprintfFunc())
+	commandsTable.WithWriter(logger.Writer())
+	for _, summary := range summaries {
+		commandsTable.AddRow(summary.Command, summary.Runs, summary.Failures, summary.Repos,
+			(time.Duration(summary.DurationSeconds) * time.Second).String())
+	}
+	logger.Println()
+	commandsTable.Print()
+	logger.Println()
+
+	logger.Successf("turbolift stats export completed %s(%d runs of %d commands written to %s)\n", colors.Normal(),
+		len(entries), len(summaries), colors.Cyan(exportFile))
+	logger.Println("Nothing has been sent anywhere: share the file with your turbolift maintainers if you would like to.")
+}
+
+func writeJson(filename string, snapshots []campaign.Snapshot) error {
+	contents, err := json.MarshalIndent(snapshots, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filename, append(contents, '\n'), 0o644); err != nil {
+		return fmt.Errorf("unable to write snapshots: %w", err)
+	}
+	return nil
+}
+
+func median(durations []time.Duration) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	middle := len(durations) / 2
+	if len(durations)%2 == 0 {
+		return (durations[middle-1] + durations[middle]) / 2
+	}
+	return durations[middle]
+}
+
+// progressBar draws the completion percentage as a bar that fills up as the campaign's PRs are merged
+func progressBar(completion int) string {
+	filled := completion * progressBarWidth / 100
+	return fmt.Sprintf("%s%s %3d%%", strings.Repeat("#", filled), strings.Repeat(".", progressBarWidth-filled), completion)
+}
+
+func formatHours(hours float64) string {
+	if hours == 0 {
+		return ""
+	}
+	if hours < 24 {
+		return fmt.Sprintf("%.0fh", hours)
+	}
+	return fmt.Sprintf("%dd %dh", int(hours)/24, int(hours)%24)
+}
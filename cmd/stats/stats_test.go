@@ -0,0 +1,241 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package stats
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/cmd/flags"
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/github"
+	"github.com/skyscanner/turbolift/internal/testsupport"
+	"github.com/skyscanner/turbolift/internal/usage"
+)
+
+func init() {
+	// disable output colouring so that strings we want to do 'Contains' checks on do not have ANSI escape sequences in IDEs
+	_ = os.Setenv("NO_COLOR", "1")
+}
+
+func TestItRecordsASnapshot(t *testing.T) {
+	prepareFakes()
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2", "org/repo3", "org/repoWithError")
+
+	out, err := runCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Checking PR status for org/repo1")
+	assert.Contains(t, out, "turbolift stats completed (50% of repos merged)")
+	assert.Contains(t, out, "##########..........  50%")
+	assert.Contains(t, out, "1d 12h")
+
+	state, err := campaign.ReadState()
+	assert.NoError(t, err)
+	assert.Len(t, state.Snapshots, 1)
+	assert.Equal(t, 2, state.Snapshots[0].Merged)
+	assert.Equal(t, 1, state.Snapshots[0].Open)
+	assert.Equal(t, 1, state.Snapshots[0].NoPR)
+	assert.Equal(t, 36.0, state.Snapshots[0].MedianHoursToMerge)
+}
+
+func TestItShowsTheChangeSinceThePreviousSnapshot(t *testing.T) {
+	prepareFakes()
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2", "org/repo3", "org/repoWithError")
+	state := &campaign.State{Snapshots: []campaign.Snapshot{
+		{Taken: time.Now().Add(-7 * 24 * time.Hour), Merged: 1, Open: 3},
+	}}
+	assert.NoError(t, state.Save())
+
+	out, err := runCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "#####...............  25%")
+	assert.Contains(t, out, "+25%")
+
+	state, err = campaign.ReadState()
+	assert.NoError(t, err)
+	assert.Len(t, state.Snapshots, 2)
+}
+
+func TestItDoesNotRecordWithNoRecord(t *testing.T) {
+	prepareFakes()
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+
+	out, err := runCommand("--no-record")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "turbolift stats completed (50% of repos merged)")
+
+	state, err := campaign.ReadState()
+	assert.NoError(t, err)
+	assert.Empty(t, state.Snapshots)
+}
+
+func TestItDoesNotRecordInReadOnlyMode(t *testing.T) {
+	prepareFakes()
+	flags.ReadOnly = true
+	defer func() {
+		flags.ReadOnly = false
+	}()
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+
+	out, err := runCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Not recording the snapshot, as turbolift is in read-only mode")
+	assert.Contains(t, out, "turbolift stats completed (50% of repos merged)")
+
+	state, err := campaign.ReadState()
+	assert.NoError(t, err)
+	assert.Empty(t, state.Snapshots)
+}
+
+func TestItShowsRecordedSnapshotsOffline(t *testing.T) {
+	fakeGitHub := prepareFakes()
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+	state := &campaign.State{Snapshots: []campaign.Snapshot{{Taken: time.Now(), Merged: 3, Open: 1}}}
+	assert.NoError(t, state.Save())
+
+	out, err := runCommand("--offline")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "turbolift stats completed (75% of repos merged)")
+	fakeGitHub.AssertCalledWith(t, [][]string{})
+}
+
+func TestItWarnsWhenThereAreNoSnapshotsOffline(t *testing.T) {
+	prepareFakes()
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runCommand("--offline")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "No snapshots have been recorded yet")
+}
+
+func TestItWritesSnapshotsAsJson(t *testing.T) {
+	prepareFakes()
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+
+	out, err := runCommand("--format", "json")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "snapshots written to stats.json")
+
+	contents, err := os.ReadFile("stats.json")
+	assert.NoError(t, err)
+	var snapshots []campaign.Snapshot
+	assert.NoError(t, json.Unmarshal(contents, &snapshots))
+	assert.Len(t, snapshots, 1)
+	assert.Equal(t, 1, snapshots[0].Merged)
+	assert.Equal(t, 1, snapshots[0].Open)
+}
+
+func TestItRejectsUnknownFormats(t *testing.T) {
+	prepareFakes()
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runCommand("--format", "csv")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Unknown stats format csv")
+}
+
+func TestItExportsUsageStatistics(t *testing.T) {
+	testsupport.CreateAndEnterTempDirectory()
+	usageFile := filepath.Join(t.TempDir(), "usage.jsonl")
+	testsupport.Setenv(t, "TURBOLIFT_USAGE_FILE", usageFile)
+	started := time.Now()
+	assert.NoError(t, usage.Append(usage.NewEntry("clone", nil, started, 4, 0, "1.2.3")))
+	assert.NoError(t, usage.Append(usage.NewEntry("foreach", nil, started, 4, 2, "1.2.3")))
+	assert.NoError(t, usage.Append(usage.NewEntry("foreach", nil, started, 2, 0, "1.2.3")))
+
+	out, err := runCommand("export", "--output", "usage.json")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "turbolift stats export completed (3 runs of 2 commands written to usage.json)")
+	assert.Contains(t, out, "Nothing has been sent anywhere")
+
+	contents, err := os.ReadFile("usage.json")
+	assert.NoError(t, err)
+	var export usageExport
+	assert.NoError(t, json.Unmarshal(contents, &export))
+	assert.Len(t, export.Runs, 3)
+	assert.Equal(t, "foreach", export.Commands[0].Command)
+	assert.Equal(t, 2, export.Commands[0].Runs)
+	assert.Equal(t, 1, export.Commands[0].Failures)
+	assert.Equal(t, 6, export.Commands[0].Repos)
+	assert.FileExists(t, usageFile, "usage statistics should be kept unless --clear is given")
+}
+
+func TestItClearsUsageStatisticsOnceExported(t *testing.T) {
+	testsupport.CreateAndEnterTempDirectory()
+	usageFile := filepath.Join(t.TempDir(), "usage.jsonl")
+	testsupport.Setenv(t, "TURBOLIFT_USAGE_FILE", usageFile)
+	assert.NoError(t, usage.Append(usage.NewEntry("clone", nil, time.Now(), 4, 0, "1.2.3")))
+
+	_, err := runCommand("export", "--clear")
+	assert.NoError(t, err)
+	assert.FileExists(t, "turbolift-usage.json")
+	assert.NoFileExists(t, usageFile)
+}
+
+func TestItExplainsHowToOptInWhenNoUsageHasBeenRecorded(t *testing.T) {
+	testsupport.CreateAndEnterTempDirectory()
+	testsupport.Setenv(t, "TURBOLIFT_USAGE_FILE", filepath.Join(t.TempDir(), "usage.jsonl"))
+	testsupport.Setenv(t, usage.EnableVariable, "")
+
+	out, err := runCommand("export")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "set TURBOLIFT_USAGE_STATS=1 to opt in")
+	assert.NoFileExists(t, "turbolift-usage.json")
+}
+
+func runCommand(args ...string) (string, error) {
+	cmd := NewStatsCmd()
+	cmd.SetArgs(args)
+	outBuffer := bytes.NewBufferString("")
+	cmd.SetOut(outBuffer)
+	err := cmd.Execute()
+	if err != nil {
+		return outBuffer.String(), err
+	}
+	return outBuffer.String(), nil
+}
+
+func prepareFakes() *github.FakeGitHub {
+	raised := time.Date(2026, 1, 5, 9, 0, 0, 0, time.UTC)
+	dummyData := map[string]*github.PrStatus{
+		"work/org/repo1": {State: "OPEN", CreatedAt: raised},
+		"work/org/repo2": {State: "MERGED", CreatedAt: raised, MergedAt: raised.Add(24 * time.Hour)},
+		"work/org/repo3": {State: "MERGED", CreatedAt: raised, MergedAt: raised.Add(48 * time.Hour)},
+	}
+	fakeGitHub := github.NewFakeGitHub(nil, func(workingDir string) (interface{}, error) {
+		if workingDir == "work/org/repoWithError" {
+			return nil, errors.New("synthetic error")
+		}
+		return dummyData[workingDir], nil
+	})
+	gh = fakeGitHub
+	return fakeGitHub
+}
@@ -0,0 +1,159 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package syncforks
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/skyscanner/turbolift/cmd/flags"
+	"github.com/skyscanner/turbolift/internal/bitbucket"
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/colors"
+	"github.com/skyscanner/turbolift/internal/forge"
+	"github.com/skyscanner/turbolift/internal/git"
+	"github.com/skyscanner/turbolift/internal/github"
+	"github.com/skyscanner/turbolift/internal/interrupt"
+	"github.com/skyscanner/turbolift/internal/logging"
+	"github.com/skyscanner/turbolift/internal/messages"
+	"github.com/skyscanner/turbolift/internal/summary"
+)
+
+var (
+	gh github.GitHub = github.NewRealGitHub()
+	bb github.GitHub = bitbucket.NewBitbucketServer()
+	g  git.Git       = git.NewRealGit()
+)
+
+var (
+	repoFile string
+	shuffle  string
+	resume   bool
+)
+
+func NewSyncForksCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "sync-forks",
+		Short: "Fast-forward the default branch of each fork from upstream",
+		Long: `Fast-forward the default branch of each fork from upstream.
+
+Stale forks cause push rejections and conflicting PRs. For each working copy that was cloned from a fork,
+this brings the fork's default branch up to date with the upstream repo. Forks whose default branch has
+commits that are not upstream are left alone and reported as errors.`,
+		Run: run,
+	}
+
+	cmd.Flags().StringVar(&repoFile, "repos", "repos.txt", "A file containing a list of repositories to clone.")
+	flags.AddShuffleFlag(cmd, &shuffle)
+	flags.AddResumeFlag(cmd, &resume)
+	flags.MarkMakesChanges(cmd)
+
+	return cmd
+}
+
+func run(c *cobra.Command, _ []string) {
+	ctx := c.Context()
+	logger := logging.NewLogger(c)
+
+	readCampaignActivity := logger.StartActivity("Reading campaign data (%s)", repoFile)
+	options := campaign.NewCampaignOptions()
+	options.RepoFilename = repoFile
+	options.Shuffle = shuffle
+	dir, err := campaign.OpenCampaign(options)
+	if err != nil {
+		readCampaignActivity.EndWithFailure(err)
+		return
+	}
+	readCampaignActivity.EndWithSuccess()
+	flags.LogShuffle(logger, dir)
+
+	progress, err := campaign.NewProgress("sync-forks", dir.BranchName, resume)
+	if err != nil {
+		logger.Errorf("%s", err)
+		return
+	}
+	if progress.Resumed() > 0 {
+		logger.Printf("Resuming the last run, so leaving alone the %d repos it had finished with", progress.Resumed())
+	}
+
+	sum := summary.New("sync-forks")
+	for i, repo := range dir.Repos {
+		if interrupt.Requested(ctx) {
+			logger.Warnf("Interrupted, so not syncing the remaining %d repos", len(dir.Repos)-i)
+			break
+		}
+		if progress.AlreadyCompleted(repo) {
+			continue
+		}
+		syncActivity := logger.StartActivity("Syncing fork of %s", repo.FullRepoName)
+
+		// skip if the working copy does not exist
+		if _, err = os.Stat(repo.FullRepoPath()); os.IsNotExist(err) {
+			syncActivity.EndWithWarningf("Directory %s does not exist - has it been cloned?", repo.FullRepoPath())
+			sum.RecordSkipped(repo, "not cloned")
+			continue
+		}
+
+		isFork, err := g.HasRemote(ctx, syncActivity.Writer(), repo.FullRepoPath(), "upstream")
+		if err != nil {
+			syncActivity.EndWithFailure(err)
+			sum.RecordErrored(repo, err)
+			continue
+		}
+		if !isFork {
+			syncActivity.EndWithWarningf("%s was not cloned from a fork", repo.FullRepoName)
+			sum.RecordSkipped(repo, "not a fork")
+			continue
+		}
+
+		defaultBranch := repo.DefaultBranch
+		if defaultBranch == "" {
+			defaultBranch, err = forge.For(repo, gh, bb).GetDefaultBranchName(ctx, syncActivity.Writer(), repo.FullRepoPath(), repo.FullRepoName)
+			if err != nil {
+				syncActivity.EndWithFailure(err)
+				sum.RecordErrored(repo, err)
+				continue
+			}
+		}
+
+		if err = g.SyncFork(ctx, syncActivity.Writer(), repo.FullRepoPath(), defaultBranch); err != nil {
+			syncActivity.EndWithFailuref("Unable to fast-forward %s from upstream, probably because the fork has commits that upstream does not: %s", defaultBranch, err)
+			sum.RecordErrored(repo, err)
+			continue
+		}
+		syncActivity.EndWithSuccess()
+		sum.RecordDone(repo)
+		progress.Complete(repo)
+	}
+
+	sum.Finish(ctx)
+	if interrupt.Requested(ctx) {
+		logger.Warnf("%s %s(%s, %s, %s)\n", dir.Messages.Format(messages.SummaryInterrupted, messages.Fields{"Command": "sync-forks"}), colors.Normal(), colors.Green(sum.Done, " OK"), colors.Yellow(sum.Skipped, " skipped"), colors.Red(sum.Errored, " errored"))
+	} else if sum.Errored == 0 {
+		logger.Successf("%s %s(%s, %s)\n", dir.Messages.Format(messages.SummaryCompleted, messages.Fields{"Command": "sync-forks"}), colors.Normal(), colors.Green(sum.Done, " OK"), colors.Yellow(sum.Skipped, " skipped"))
+	} else {
+		logger.Warnf("%s %s(%s, %s, %s)\n", dir.Messages.Format(messages.SummaryWithErrors, messages.Fields{"Command": "sync-forks"}), colors.Normal(), colors.Green(sum.Done, " OK"), colors.Yellow(sum.Skipped, " skipped"), colors.Red(sum.Errored, " errored"))
+	}
+
+	github.LogApiUsage(ctx, gh, logger, dir.Hosts())
+
+	if err := progress.End(interrupt.Requested(ctx)); err != nil {
+		logger.Warnf("Unable to update the checkpoint in the campaign state: %s", err)
+	} else if interrupt.Requested(ctx) {
+		logger.Printf("To carry on from where it stopped, run %s again with the same options", colors.Cyan(c.CommandPath()+" --resume"))
+	}
+}
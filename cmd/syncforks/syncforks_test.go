@@ -0,0 +1,91 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package syncforks
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/git"
+	"github.com/skyscanner/turbolift/internal/github"
+	"github.com/skyscanner/turbolift/internal/testsupport"
+)
+
+func init() {
+	// disable output colouring so that strings we want to do 'Contains' checks on do not have ANSI escape sequences in IDEs
+	_ = os.Setenv("NO_COLOR", "1")
+}
+
+func TestItSyncsForksAndSkipsOtherWorkingCopies(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+	fakeGit := git.NewFakeGit(func(_ io.Writer, call []string) (bool, error) {
+		if call[0] == "hasRemote" {
+			return call[1] == "work/org/forked", nil
+		}
+		return true, nil
+	})
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(true, "org/forked", "org/owned")
+	testsupport.CreateAnotherRepoFile("repos.txt", "org/forked", "org/owned", "org/missing")
+
+	out, err := runCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "org/owned was not cloned from a fork")
+	assert.Contains(t, out, "Directory work/org/missing does not exist")
+	assert.Contains(t, out, "turbolift sync-forks completed (1 OK, 2 skipped)")
+
+	fakeGit.AssertCalledWith(t, [][]string{
+		{"hasRemote", "work/org/forked", "upstream"},
+		{"syncFork", "work/org/forked", "main"},
+		{"hasRemote", "work/org/owned", "upstream"},
+	})
+	fakeGitHub.AssertCalledWith(t, [][]string{
+		{"get_default_branch", "work/org/forked", "org/forked"},
+	})
+}
+
+func TestItReportsForksThatCannotBeFastForwarded(t *testing.T) {
+	gh = github.NewAlwaysSucceedsFakeGitHub()
+	g = git.NewFakeGit(func(_ io.Writer, call []string) (bool, error) {
+		if call[0] == "syncFork" {
+			return false, errors.New("non-fast-forward")
+		}
+		return true, nil
+	})
+
+	testsupport.PrepareTempCampaign(true, "org/forked")
+
+	out, err := runCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Unable to fast-forward main from upstream")
+	assert.Contains(t, out, "1 errored")
+}
+
+func runCommand(args ...string) (string, error) {
+	cmd := NewSyncForksCmd()
+	outBuffer := bytes.NewBufferString("")
+	cmd.SetOut(outBuffer)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return outBuffer.String(), err
+}
@@ -0,0 +1,139 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package tools
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/skyscanner/turbolift/cmd/flags"
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/colors"
+	"github.com/skyscanner/turbolift/internal/logging"
+	"github.com/skyscanner/turbolift/internal/tools"
+)
+
+var installTool = func(ctx context.Context, output io.Writer, tool tools.Tool, binDir string) (string, error) {
+	return tool.InstallInto(ctx, output, binDir)
+}
+
+var configFile string
+
+func NewToolsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "tools",
+		Short: "Manage the helper tools used by the campaign",
+	}
+
+	cmd.AddCommand(newInstallCmd())
+
+	return cmd
+}
+
+func newInstallCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "install [TOOL[@VERSION]...]",
+		Short: "Install pinned versions of helper tools for the campaign",
+		Long: fmt.Sprintf(`Download pinned versions of helper tools into the campaign's %s directory, so that everyone working on
+the campaign runs the same versions of them. The tools installed are used ahead of any other copies by every command
+that turbolift runs in the campaign, including those run by foreach.
+
+Without any arguments, the tools listed under tools in turbolift.yaml are installed, given like the arguments.
+The tools that can be installed are: %s.`, tools.CampaignDirectory, strings.Join(tools.Downloadable(), ", ")),
+		Run: runInstall,
+	}
+
+	cmd.Flags().StringVar(&configFile, "config", "turbolift.yaml", "The campaign configuration file listing the tools to install")
+	flags.MarkMakesChanges(cmd)
+
+	return cmd
+}
+
+func runInstall(c *cobra.Command, args []string) {
+	logger := logging.NewLogger(c)
+
+	readConfigActivity := logger.StartActivity("Reading campaign configuration (%s)", configFile)
+	config, err := campaign.ReadConfig(configFile)
+	if err != nil {
+		readConfigActivity.EndWithFailure(err)
+		return
+	}
+	readConfigActivity.EndWithSuccess()
+
+	versions, err := toolVersions(args, config.Tools)
+	if err != nil {
+		logger.Errorf("%s", err)
+		return
+	}
+	if len(versions) == 0 {
+		logger.Errorf("No tools are listed in %s. Give the tools to install, e.g. turbolift tools install %s", configFile, strings.Join(tools.Downloadable(), " "))
+		return
+	}
+
+	var names []string
+	for name := range versions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	binDir := tools.CampaignBinDirectory()
+	for _, name := range names {
+		tool := tools.Known[name]
+		if version := versions[name]; version != "" && version != tool.Release.Version {
+			if tool, err = tool.AtVersion(version); err != nil {
+				logger.Errorf("%s", err)
+				return
+			}
+		}
+
+		installActivity := logger.StartActivity("Installing %s %s", tool.Name, tool.Release.Version)
+		if _, err := installTool(c.Context(), installActivity.Writer(), tool, binDir); err != nil {
+			installActivity.EndWithFailure(err)
+			return
+		}
+		installActivity.EndWithSuccess()
+	}
+
+	logger.Successf("turbolift tools install completed %s(%d tools installed into %s)\n", colors.Normal(), len(names), colors.Cyan(binDir))
+}
+
+// toolVersions returns the version of each tool to install, given as TOOL or TOOL@VERSION on the command line, or
+// else listed in the campaign configuration. An empty version is the one that turbolift pins.
+func toolVersions(args []string, configured []string) (map[string]string, error) {
+	if len(args) == 0 {
+		args = configured
+	}
+	versions := map[string]string{}
+	for _, arg := range args {
+		name, version := arg, ""
+		if i := strings.Index(arg, "@"); i >= 0 {
+			name, version = arg[:i], arg[i+1:]
+		}
+		versions[name] = version
+	}
+
+	for name := range versions {
+		if tool, ok := tools.Known[name]; !ok || tool.Release == nil {
+			return nil, fmt.Errorf("turbolift is unable to install %s: the tools it can install are %s", name, strings.Join(tools.Downloadable(), ", "))
+		}
+	}
+	return versions, nil
+}
@@ -0,0 +1,95 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package tools
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/testsupport"
+	"github.com/skyscanner/turbolift/internal/tools"
+)
+
+func TestItInstallsTheToolsGivenIntoTheCampaign(t *testing.T) {
+	installed := fakeInstall()
+	testsupport.CreateAndEnterTempDirectory()
+
+	out, err := runCommand("install", "yq", "gh@2.39.0")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"gh 2.39.0 into " + tools.CampaignBinDirectory(),
+		"yq 4.40.5 into " + tools.CampaignBinDirectory(),
+	}, *installed)
+	assert.Contains(t, out, "Installing gh 2.39.0")
+	assert.Contains(t, out, "turbolift tools install completed")
+	assert.Contains(t, out, "2 tools installed into "+tools.CampaignBinDirectory())
+}
+
+func TestItInstallsTheToolsListedInTheConfig(t *testing.T) {
+	installed := fakeInstall()
+	testsupport.CreateAndEnterTempDirectory()
+	testsupport.CreateConfigFile("tools:\n  - gitleaks@v8.17.0\n  - gh\n")
+
+	_, err := runCommand("install")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"gh 2.40.1 into " + tools.CampaignBinDirectory(),
+		"gitleaks 8.17.0 into " + tools.CampaignBinDirectory(),
+	}, *installed)
+}
+
+func TestItRefusesToInstallUnknownTools(t *testing.T) {
+	installed := fakeInstall()
+	testsupport.CreateAndEnterTempDirectory()
+
+	out, err := runCommand("install", "gh", "git")
+	assert.NoError(t, err)
+	assert.Empty(t, *installed)
+	assert.Contains(t, out, "turbolift is unable to install git: the tools it can install are gh, gitleaks, yq")
+}
+
+func TestItSaysWhichToolsToInstallWhenNoneAreListed(t *testing.T) {
+	installed := fakeInstall()
+	testsupport.CreateAndEnterTempDirectory()
+
+	out, err := runCommand("install")
+	assert.NoError(t, err)
+	assert.Empty(t, *installed)
+	assert.Contains(t, out, "No tools are listed in turbolift.yaml")
+}
+
+func fakeInstall() *[]string {
+	installed := []string{}
+	installTool = func(_ context.Context, _ io.Writer, tool tools.Tool, binDir string) (string, error) {
+		installed = append(installed, tool.Name+" "+tool.Release.Version+" into "+binDir)
+		return filepath.Join(binDir, tool.Name), nil
+	}
+	return &installed
+}
+
+func runCommand(args ...string) (string, error) {
+	cmd := NewToolsCmd()
+	outBuffer := bytes.NewBufferString("")
+	cmd.SetOut(outBuffer)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return outBuffer.String(), err
+}
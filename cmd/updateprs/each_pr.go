@@ -0,0 +1,171 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package updateprs
+
+import (
+	"context"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/skyscanner/turbolift/cmd/flags"
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/colors"
+	"github.com/skyscanner/turbolift/internal/forge"
+	"github.com/skyscanner/turbolift/internal/github"
+	"github.com/skyscanner/turbolift/internal/interrupt"
+	"github.com/skyscanner/turbolift/internal/logging"
+	"github.com/skyscanner/turbolift/internal/messages"
+	"github.com/skyscanner/turbolift/internal/operations"
+	"github.com/skyscanner/turbolift/internal/summary"
+)
+
+// prRun describes a run of update-prs that does something to the PR of each repo
+type prRun struct {
+	// action names the run in the campaign state, e.g. update-prs --nag
+	action string
+	// interrupted says what is not done to the remaining repos when the run is interrupted
+	interrupted string
+	// confirm asks whether to go ahead with the run, given how many repos it will process
+	confirm func(dir *campaign.Campaign, repos int) (bool, error)
+}
+
+// repoOperation is what a run does to the PR of each repo. It logs its own activities, and returns what it did.
+type repoOperation func(ctx context.Context, logger *logging.Logger, dir *campaign.Campaign, repo campaign.Repo) operations.Outcome
+
+// prOperation is what a run does to the PR of a repo once it has been found. It ends the activity, and returns what it
+// did.
+type prOperation func(ctx context.Context, activity *logging.Activity, client github.GitHub, dir *campaign.Campaign, repo campaign.Repo, pr *github.PrStatus) operations.Outcome
+
+// forEachPR opens the campaign and runs the operation for each of its repos, resuming from where the last run stopped
+// if asked to, and summarising what it did. It returns false if it stopped before processing any repos.
+func forEachPR(c *cobra.Command, run prRun, operation repoOperation) bool {
+	ctx := c.Context()
+	logger := logging.NewLogger(c)
+
+	readCampaignActivity := logger.StartActivity("Reading campaign data (%s)", repoFile)
+	options := campaign.NewCampaignOptions()
+	options.RepoFilename = repoFile
+	options.Shuffle = shuffle
+	options.BranchName = branchName
+	options.PrDescriptionFilename = prDescriptionFile
+	dir, err := campaign.OpenCampaign(options)
+	if err != nil {
+		readCampaignActivity.EndWithFailure(err)
+		return false
+	}
+	readCampaignActivity.EndWithSuccess()
+	flags.LogShuffle(logger, dir)
+
+	progress, err := campaign.NewProgress(run.action, dir.BranchName, resume)
+	if err != nil {
+		logger.Errorf("%s", err)
+		return false
+	}
+	if progress.Resumed() > 0 {
+		logger.Printf("Resuming the last run, so leaving alone the %d repos it had finished with", progress.Resumed())
+	}
+
+	// Prompting for confirmation
+	confirmed, err := run.confirm(dir, len(dir.Repos)-progress.Resumed())
+	if err != nil {
+		logger.Errorf("%s", err)
+		return false
+	}
+	if !confirmed {
+		return false
+	}
+
+	sum := summary.New("update-prs")
+
+	for i, repo := range dir.Repos {
+		if interrupt.Requested(ctx) {
+			logger.Warnf("Interrupted, so not %s for the remaining %d repos", run.interrupted, len(dir.Repos)-i)
+			break
+		}
+		if progress.AlreadyCompleted(repo) {
+			continue
+		}
+
+		outcome := operation(ctx, logger, dir, repo)
+		outcome.Record(sum, repo)
+		if outcome.Finished {
+			progress.Complete(repo)
+		}
+	}
+
+	sum.Finish(ctx)
+	if interrupt.Requested(ctx) {
+		logger.Warnf("%s %s(%s, %s, %s)\n", dir.Messages.Format(messages.SummaryInterrupted, messages.Fields{"Command": "update-prs"}), colors.Normal(), colors.Green(sum.Done, " OK"), colors.Yellow(sum.Skipped, " skipped"), colors.Red(sum.Errored, " errored"))
+	} else if sum.Errored == 0 {
+		logger.Successf("%s %s(%s, %s)\n", dir.Messages.Format(messages.SummaryCompleted, messages.Fields{"Command": "update-prs"}), colors.Normal(), colors.Green(sum.Done, " OK"), colors.Yellow(sum.Skipped, " skipped"))
+	} else {
+		logger.Warnf("%s %s(%s, %s, %s)\n", dir.Messages.Format(messages.SummaryWithErrors, messages.Fields{"Command": "update-prs"}), colors.Normal(), colors.Green(sum.Done, " OK"), colors.Yellow(sum.Skipped, " skipped"), colors.Red(sum.Errored, " errored"))
+	}
+	if skipped := sum.DescribeSkipped(); skipped != "" {
+		logger.Printf("Skipped repos: %s\n", skipped)
+	}
+
+	github.LogApiUsage(ctx, gh, logger, dir.Hosts())
+
+	if err := progress.End(interrupt.Requested(ctx)); err != nil {
+		logger.Warnf("Unable to update the checkpoint in the campaign state: %s", err)
+	} else if interrupt.Requested(ctx) {
+		logger.Printf("To carry on from where it stopped, run %s again with the same options", colors.Cyan(c.CommandPath()+" --resume"))
+	}
+	return true
+}
+
+// forgeOperation is what a run does to the PR of a repo, through the repo's forge. It ends the activity, and returns
+// what it did.
+type forgeOperation func(ctx context.Context, activity *logging.Activity, client github.GitHub, dir *campaign.Campaign, repo campaign.Repo) operations.Outcome
+
+// ifCloned runs the operation for each repo that has been cloned, skipping the others. The activity is logged for each
+// repo, with the repo's name in place of %s.
+func ifCloned(activity string, operation forgeOperation) repoOperation {
+	return func(ctx context.Context, logger *logging.Logger, dir *campaign.Campaign, repo campaign.Repo) operations.Outcome {
+		repoActivity := logger.StartActivity(activity, repo.FullRepoName)
+		// skip if the working copy does not exist
+		if _, err := os.Stat(repo.FullRepoPath()); os.IsNotExist(err) {
+			repoActivity.EndWithWarningf("Directory %s does not exist - has it been cloned?", repo.FullRepoPath())
+			return operations.Outcome{Skipped: "not cloned", SkippedAs: summary.SkippedNotCloned}
+		}
+		return operation(ctx, repoActivity, forge.For(repo, gh, bb), dir, repo)
+	}
+}
+
+// withPR finds the PR of each repo that has been cloned and runs the operation on it, skipping repos that have no PR
+func withPR(activity string, operation prOperation) repoOperation {
+	return ifCloned(activity, func(ctx context.Context, activity *logging.Activity, client github.GitHub, dir *campaign.Campaign, repo campaign.Repo) operations.Outcome {
+		pr, err := client.GetPR(ctx, activity.Writer(), repo.FullRepoPath(), dir.BranchName)
+		if err != nil {
+			return skipOrFail(activity, err)
+		}
+		return operation(ctx, activity, client, dir, repo, pr)
+	})
+}
+
+// skipOrFail ends the activity for an action on a PR that failed. Repos are skipped if there was nothing to do, such
+// as there being no PR or the PR already being merged; a resumed run leaves them alone unless they had no PR, as one
+// may have been raised since. They are errored for any other failure.
+func skipOrFail(activity *logging.Activity, err error) operations.Outcome {
+	if category, ok := operations.SkippedAs(err); ok {
+		activity.EndWithWarning(err)
+		return operations.Outcome{Skipped: err.Error(), SkippedAs: category, Finished: category != summary.SkippedNoPR}
+	}
+	activity.EndWithFailure(err)
+	return operations.Outcome{Err: err}
+}
@@ -16,44 +16,104 @@
 package updateprs
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 
+	"github.com/skyscanner/turbolift/cmd/flags"
+	"github.com/skyscanner/turbolift/internal/bitbucket"
 	"github.com/skyscanner/turbolift/internal/campaign"
 	"github.com/skyscanner/turbolift/internal/colors"
+	"github.com/skyscanner/turbolift/internal/confirm"
+	"github.com/skyscanner/turbolift/internal/forge"
+	"github.com/skyscanner/turbolift/internal/git"
 	"github.com/skyscanner/turbolift/internal/github"
+	"github.com/skyscanner/turbolift/internal/interrupt"
 	"github.com/skyscanner/turbolift/internal/logging"
+	"github.com/skyscanner/turbolift/internal/messages"
+	"github.com/skyscanner/turbolift/internal/operations"
+	"github.com/skyscanner/turbolift/internal/prbody"
 	"github.com/skyscanner/turbolift/internal/prompt"
+	"github.com/skyscanner/turbolift/internal/summary"
 )
 
 var (
 	gh github.GitHub = github.NewRealGitHub()
+	bb github.GitHub = bitbucket.NewBitbucketServer()
 	p  prompt.Prompt = prompt.NewRealPrompt()
+	g  git.Git       = git.NewRealGit()
+
+	now   = time.Now
+	sleep = sleepUnlessDone
 )
 
 var (
 	closeFlag             bool
+	reopenFlag            bool
+	deleteBranchesFlag    bool
+	includeForks          bool
 	updateDescriptionFlag bool
+	waitChecksFlag        bool
+	noChecksOk            bool
+	noChecksGrace         time.Duration
+	updateBranchFlag      bool
+	autoMergeStrategy     string
+	addLabels             []string
+	removeLabels          []string
+	nagFlag               bool
+	nagDays               int
+	nagBy                 []string
+	requestReviewers      []string
+	reRequest             bool
 	yesFlag               bool
+	timeout               time.Duration
+	pollInterval          time.Duration
 	repoFile              string
+	branchName            string
 	prDescriptionFile     string
+	shuffle               string
+	resume                bool
 )
 
 func NewUpdatePRsCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "update-prs",
 		Short: "update all PRs that have been generated by the campaign",
-		Run:   run,
+		RunE:  runE,
 	}
 
 	cmd.Flags().BoolVar(&closeFlag, "close", false, "Close all generated PRs")
+	cmd.Flags().BoolVar(&reopenFlag, "reopen", false, "Reopen generated PRs that were closed without being merged")
+	cmd.Flags().BoolVar(&deleteBranchesFlag, "delete-branches", false, "Delete the campaign branches of PRs that have been closed or merged")
+	cmd.Flags().BoolVar(&includeForks, "include-forks", false, "With --delete-branches, also delete campaign branches that were pushed to forks")
 	cmd.Flags().BoolVar(&updateDescriptionFlag, "amend-description", false, "Update PR titles and descriptions")
+	cmd.Flags().StringVar(&autoMergeStrategy, "enable-auto-merge", "", "Enable auto-merge on all generated PRs, using the given merge strategy: merge, squash or rebase")
+	cmd.Flags().Lookup("enable-auto-merge").NoOptDefVal = "squash"
+	cmd.Flags().BoolVar(&updateBranchFlag, "update-branch", false, "Update PR branches with the latest changes from their base branches, rebasing them in repos that require linear history")
+	cmd.Flags().StringSliceVar(&addLabels, "add-label", nil, "Add the given labels to all generated PRs, e.g. --add-label deadline-friday (may be repeated, or given as a comma-separated list)")
+	cmd.Flags().StringSliceVar(&removeLabels, "remove-label", nil, "Remove the given labels from all generated PRs (may be repeated, or given as a comma-separated list)")
+	cmd.Flags().BoolVar(&nagFlag, "nag", false, "Remind reviewers of open PRs that nobody has reviewed for --nag-days days or more")
+	cmd.Flags().IntVar(&nagDays, "nag-days", 7, "How many days a PR must have been waiting for a review before --nag reminds its reviewers")
+	cmd.Flags().StringSliceVar(&nagBy, "nag-by", []string{"comment"}, "How --nag reminds reviewers: comment (posting the nag-comment message on the PR), review (requesting their reviews again), or both")
+	cmd.Flags().StringSliceVar(&requestReviewers, "request-review", nil, "Request reviews of all open generated PRs from the given users, or org/team for teams (may be repeated, or given as a comma-separated list)")
+	cmd.Flags().BoolVar(&reRequest, "re-request", false, "With --request-review, also ask users who have already reviewed a PR to review it again")
+	cmd.Flags().BoolVar(&waitChecksFlag, "wait-checks", false, "Wait until the checks on all generated PRs have finished, and fail if any did not pass")
+	cmd.Flags().DurationVar(&timeout, "timeout", 30*time.Minute, "How long --wait-checks waits for checks to finish")
+	cmd.Flags().DurationVar(&pollInterval, "poll-interval", 30*time.Second, "How often --wait-checks polls the status of checks")
+	cmd.Flags().DurationVar(&noChecksGrace, "no-checks-grace", 2*time.Minute, "How long --wait-checks waits for checks to appear on a PR that has none")
+	cmd.Flags().BoolVar(&noChecksOk, "no-checks-ok", false, "With --wait-checks, count PRs that still have no checks after --no-checks-grace as passed, rather than failed")
 	cmd.Flags().BoolVar(&yesFlag, "yes", false, "Skips the confirmation prompt")
 	cmd.Flags().StringVar(&repoFile, "repos", "repos.txt", "A file containing a list of repositories to clone.")
+	cmd.Flags().StringVar(&branchName, "branch", "", "The branch to use for the campaign (defaults to branch in turbolift.yaml, or the campaign name)")
 	cmd.Flags().StringVar(&prDescriptionFile, "description", "README.md", "A file containing the title and description for the PRs.")
+	flags.AddShuffleFlag(cmd, &shuffle)
+	flags.AddResumeFlag(cmd, &resume)
+	flags.MarkMakesChanges(cmd)
 
 	return cmd
 }
@@ -71,138 +131,584 @@ func onlyOne(args ...bool) bool {
 	return b[true] == 1
 }
 
-func validateFlags(closeFlag bool, updateDescriptionFlag bool) error {
-	if !onlyOne(closeFlag, updateDescriptionFlag) {
+func validateFlags(closeFlag bool, reopenFlag bool, deleteBranchesFlag bool, updateDescriptionFlag bool, waitChecksFlag bool, updateBranchFlag bool, autoMergeStrategy string, addLabels []string, removeLabels []string, nagFlag bool, requestReviewers []string) error {
+	if !onlyOne(closeFlag, reopenFlag, deleteBranchesFlag, updateDescriptionFlag, waitChecksFlag, updateBranchFlag, autoMergeStrategy != "", len(addLabels) > 0, len(removeLabels) > 0, nagFlag, len(requestReviewers) > 0) {
 		return errors.New("update-prs needs one and only one action flag")
 	}
+	for _, reviewer := range requestReviewers {
+		if strings.TrimSpace(reviewer) == "" {
+			return errors.New("reviewers must not be empty")
+		}
+	}
+	if reRequest && len(requestReviewers) == 0 {
+		return errors.New("--re-request can only be used with --request-review")
+	}
+	if includeForks && !deleteBranchesFlag {
+		return errors.New("--include-forks can only be used with --delete-branches")
+	}
+	if autoMergeStrategy != "" && !github.IsMergeStrategy(autoMergeStrategy) {
+		return fmt.Errorf("unknown merge strategy %s: must be one of %s", autoMergeStrategy, strings.Join(github.MergeStrategies, ", "))
+	}
+	for _, label := range append(addLabels, removeLabels...) {
+		if strings.TrimSpace(label) == "" {
+			return errors.New("labels must not be empty")
+		}
+	}
+	if nagFlag {
+		if nagDays < 0 {
+			return errors.New("--nag-days must not be negative")
+		}
+		if len(nagBy) == 0 {
+			return fmt.Errorf("--nag-by must be given at least one of %s", strings.Join(nagMethods, ", "))
+		}
+		for _, method := range nagBy {
+			if method != "comment" && method != "review" {
+				return fmt.Errorf("unknown --nag-by method %s: must be one of %s", method, strings.Join(nagMethods, ", "))
+			}
+		}
+	}
 	return nil
 }
 
 // we keep the args as one of the subfunctions might need it one day.
-func run(c *cobra.Command, args []string) {
+func runE(c *cobra.Command, args []string) error {
 	logger := logging.NewLogger(c)
-	if err := validateFlags(closeFlag, updateDescriptionFlag); err != nil {
+	if err := validateFlags(closeFlag, reopenFlag, deleteBranchesFlag, updateDescriptionFlag, waitChecksFlag, updateBranchFlag, autoMergeStrategy, addLabels, removeLabels, nagFlag, requestReviewers); err != nil {
 		logger.Errorf("Error while parsing the flags: %v", err)
-		return
+		return nil
+	}
+
+	if err := github.RememberTrackedPRs(gh, bb); err != nil {
+		logger.Warnf("Unable to read the PRs recorded in the campaign state: %s", err)
 	}
 
 	if closeFlag {
 		runClose(c, args)
+	} else if reopenFlag {
+		runReopen(c, args)
+	} else if deleteBranchesFlag {
+		runDeleteBranches(c, args)
 	} else if updateDescriptionFlag {
 		runUpdatePrDescription(c, args)
+	} else if waitChecksFlag {
+		return runWaitChecks(c, args)
+	} else if updateBranchFlag {
+		runUpdateBranch(c, args)
+	} else if autoMergeStrategy != "" {
+		runEnableAutoMerge(c, args)
+	} else if len(addLabels) > 0 {
+		runUpdateLabels(c, args, addLabels, false)
+	} else if len(removeLabels) > 0 {
+		runUpdateLabels(c, args, removeLabels, true)
+	} else if nagFlag {
+		runNag(c, args)
+	} else if len(requestReviewers) > 0 {
+		runRequestReview(c, args)
 	}
+	return nil
 }
 
 func runClose(c *cobra.Command, _ []string) {
-	logger := logging.NewLogger(c)
+	clients := operations.Clients{GitHub: gh, Bitbucket: bb}
+	run := prRun{
+		action:      "update-prs --close",
+		interrupted: "closing PRs",
+		// TODO: add the number of PRs that it will actually close
+		confirm: func(dir *campaign.Campaign, repos int) (bool, error) {
+			return confirm.Destructive(p, dir, "update-prs --close", repos, yesFlag,
+				dir.Messages.Format(messages.ConfirmClosePrs, messages.Fields{"Campaign": dir.Name, "ReposFile": repoFile}))
+		},
+	}
+	forEachPR(c, run, func(ctx context.Context, logger *logging.Logger, dir *campaign.Campaign, repo campaign.Repo) operations.Outcome {
+		return operations.ClosePR(ctx, logger, clients, dir, repo)
+	})
+}
 
-	readCampaignActivity := logger.StartActivity("Reading campaign data (%s)", repoFile)
-	options := campaign.NewCampaignOptions()
-	options.RepoFilename = repoFile
-	dir, err := campaign.OpenCampaign(options)
+func runReopen(c *cobra.Command, _ []string) {
+	clients := operations.Clients{GitHub: gh, Bitbucket: bb}
+	run := prRun{
+		action:      "update-prs --reopen",
+		interrupted: "reopening PRs",
+		confirm: func(dir *campaign.Campaign, _ int) (bool, error) {
+			return yesFlag || p.AskConfirm(dir.Messages.Format(messages.ConfirmReopenPrs, messages.Fields{"Campaign": dir.Name, "ReposFile": repoFile})), nil
+		},
+	}
+	forEachPR(c, run, func(ctx context.Context, logger *logging.Logger, dir *campaign.Campaign, repo campaign.Repo) operations.Outcome {
+		return operations.ReopenPR(ctx, logger, clients, dir, repo)
+	})
+}
+
+func runDeleteBranches(c *cobra.Command, _ []string) {
+	run := prRun{
+		action:      "update-prs --delete-branches",
+		interrupted: "deleting branches",
+		confirm: func(dir *campaign.Campaign, repos int) (bool, error) {
+			return confirm.Destructive(p, dir, "update-prs --delete-branches", repos, yesFlag,
+				dir.Messages.Format(messages.ConfirmDeleteBranches, messages.Fields{"Branch": dir.BranchName, "Campaign": dir.Name, "ReposFile": repoFile}))
+		},
+	}
+	forEachPR(c, run, withPR("Deleting campaign branch in %s", deleteBranch))
+}
+
+// deleteBranch deletes the campaign branch of a closed or merged PR from origin
+func deleteBranch(ctx context.Context, activity *logging.Activity, _ github.GitHub, dir *campaign.Campaign, repo campaign.Repo, pr *github.PrStatus) operations.Outcome {
+	// a campaign run on the default branch by mistake finds a PR from it, and the default branch is never deleted
+	if dir.BranchName == pr.BaseRefName || dir.BranchName == repo.DefaultBranch {
+		err := fmt.Errorf("%s is the base branch of the PR or the repo's default branch, so it is not deleted", dir.BranchName)
+		activity.EndWithFailure(err)
+		return operations.Outcome{Err: err}
+	}
+	// the branch of an open PR is still needed
+	if pr.State == github.PrOpen {
+		activity.EndWithWarning("PR is still open - skipping")
+		return operations.Outcome{Skipped: "PR is still open", SkippedAs: summary.SkippedOpen}
+	}
+
+	// campaign branches are pushed to origin, which is the user's fork rather than the repo for forked repos
+	isFork, err := g.HasRemote(ctx, activity.Writer(), repo.FullRepoPath(), "upstream")
 	if err != nil {
-		readCampaignActivity.EndWithFailure(err)
-		return
+		activity.EndWithFailure(err)
+		return operations.Outcome{Err: err}
+	}
+	if isFork && !includeForks {
+		activity.EndWithWarning("The branch is on a fork - use --include-forks to delete it too")
+		return operations.Outcome{Skipped: "branch on a fork"}
 	}
-	readCampaignActivity.EndWithSuccess()
 
-	// Prompting for confirmation
-	if !yesFlag {
-		// TODO: add the number of PRs that it will actually close
-		if !p.AskConfirm(fmt.Sprintf("Close %s campaign PRs for all repos in %s?", dir.Name, repoFile)) {
-			return
+	// GitHub may have deleted the branch itself once the PR was merged
+	exists, err := g.HasRemoteBranch(ctx, activity.Writer(), repo.FullRepoPath(), "origin", dir.BranchName)
+	if err != nil {
+		activity.EndWithFailure(err)
+		return operations.Outcome{Err: err}
+	}
+	if !exists {
+		activity.EndWithWarning("The branch has already been deleted")
+		return operations.Outcome{Skipped: "already deleted", Finished: true}
+	}
+
+	err = g.DeleteRemoteBranch(ctx, activity.Writer(), repo.FullRepoPath(), "origin", dir.BranchName)
+	if err != nil {
+		activity.EndWithFailure(err)
+		return operations.Outcome{Err: err}
+	}
+	activity.EndWithSuccess()
+	return operations.Outcome{Finished: true}
+}
+
+func runEnableAutoMerge(c *cobra.Command, _ []string) {
+	run := prRun{
+		action:      "update-prs --enable-auto-merge",
+		interrupted: "enabling auto-merge",
+		confirm: func(dir *campaign.Campaign, _ int) (bool, error) {
+			return yesFlag || p.AskConfirm(dir.Messages.Format(messages.ConfirmEnableAutoMerge, messages.Fields{"Campaign": dir.Name, "ReposFile": repoFile, "Strategy": autoMergeStrategy})), nil
+		},
+	}
+	forEachPR(c, run, ifCloned("Enabling auto-merge for PR in %s", func(ctx context.Context, activity *logging.Activity, client github.GitHub, dir *campaign.Campaign, repo campaign.Repo) operations.Outcome {
+		strategy, err := github.MergeStrategyFor(ctx, client, activity.Writer(), repo.FullRepoPath(), repo.DefaultBranch, autoMergeStrategy)
+		if err != nil {
+			activity.EndWithFailuref("Unable to check whether %s requires linear history: %s", repo.FullRepoName, err)
+			return operations.Outcome{Err: err}
+		}
+		if strategy != autoMergeStrategy {
+			activity.Logf("%s requires linear history, so the PR will be merged with %s rather than %s", repo.FullRepoName, strategy, autoMergeStrategy)
 		}
+
+		err = client.EnableAutoMerge(ctx, activity.Writer(), repo.FullRepoPath(), dir.BranchName, strategy)
+		if err != nil {
+			return skipOrFail(activity, err)
+		}
+		if strategy != autoMergeStrategy {
+			activity.EndWithSuccessAndEmitLogs()
+		} else {
+			activity.EndWithSuccess()
+		}
+		return operations.Outcome{Finished: true}
+	}))
+}
+
+func runUpdateBranch(c *cobra.Command, _ []string) {
+	run := prRun{
+		action:      "update-prs --update-branch",
+		interrupted: "updating PR branches",
+		confirm: func(dir *campaign.Campaign, _ int) (bool, error) {
+			return yesFlag || p.AskConfirm(dir.Messages.Format(messages.ConfirmUpdateBranch, messages.Fields{"Campaign": dir.Name, "ReposFile": repoFile})), nil
+		},
 	}
+	forEachPR(c, run, ifCloned("Updating PR branch in %s", func(ctx context.Context, activity *logging.Activity, client github.GitHub, dir *campaign.Campaign, repo campaign.Repo) operations.Outcome {
+		// merging the base branch into the PR's branch adds a merge commit, which repos that require linear history reject,
+		// so their PR branches are rebased instead
+		rebase, err := client.RequiresLinearHistory(ctx, activity.Writer(), repo.FullRepoPath(), repo.DefaultBranch)
+		if err != nil {
+			activity.EndWithFailuref("Unable to check whether %s requires linear history: %s", repo.FullRepoName, err)
+			return operations.Outcome{Err: err}
+		}
+		if rebase {
+			activity.Logf("%s requires linear history, so the PR branch will be rebased rather than merged", repo.FullRepoName)
+		}
 
-	doneCount := 0
-	skippedCount := 0
-	errorCount := 0
+		err = client.UpdatePRBranch(ctx, activity.Writer(), repo.FullRepoPath(), dir.BranchName, rebase)
+		if err != nil {
+			return skipOrFail(activity, err)
+		}
+		if rebase {
+			activity.EndWithSuccessAndEmitLogs()
+		} else {
+			activity.EndWithSuccess()
+		}
+		return operations.Outcome{Finished: true}
+	}))
+}
 
-	for _, repo := range dir.Repos {
+// runUpdateLabels adds the labels to every campaign PR or, if remove is true, removes them
+func runUpdateLabels(c *cobra.Command, _ []string, labels []string, remove bool) {
+	action, confirmMessage, verb := "update-prs --add-label", messages.ConfirmAddLabels, "Adding labels to"
+	if remove {
+		action, confirmMessage, verb = "update-prs --remove-label", messages.ConfirmRemoveLabels, "Removing labels from"
+	}
 
-		closeActivity := logger.StartActivity("Closing PR in %s", repo.FullRepoName)
-		// skip if the working copy does not exist
-		if _, err = os.Stat(repo.FullRepoPath()); os.IsNotExist(err) {
-			closeActivity.EndWithWarningf("Directory %s does not exist - has it been cloned?", repo.FullRepoPath())
-			skippedCount++
-			continue
+	run := prRun{
+		action:      action,
+		interrupted: "updating PR labels",
+		confirm: func(dir *campaign.Campaign, _ int) (bool, error) {
+			return yesFlag || p.AskConfirm(dir.Messages.Format(confirmMessage, messages.Fields{"Campaign": dir.Name, "ReposFile": repoFile, "Labels": strings.Join(labels, ", ")})), nil
+		},
+	}
+	forEachPR(c, run, ifCloned(verb+" PR in %s", func(ctx context.Context, activity *logging.Activity, client github.GitHub, dir *campaign.Campaign, repo campaign.Repo) operations.Outcome {
+		var err error
+		if remove {
+			err = client.RemovePRLabels(ctx, activity.Writer(), repo.FullRepoPath(), dir.BranchName, labels)
+		} else {
+			err = client.AddPRLabels(ctx, activity.Writer(), repo.FullRepoPath(), dir.BranchName, labels)
+		}
+		if err != nil {
+			return skipOrFail(activity, err)
+		}
+		activity.EndWithSuccess()
+		return operations.Outcome{Finished: true}
+	}))
+}
+
+// nagMethods are the ways that update-prs --nag can remind reviewers of a PR
+var nagMethods = []string{"comment", "review"}
+
+// nagReviewers lists the reviewers to re-request a review from, as gh expects them: logins, or org/team slugs for teams
+func nagReviewers(repo campaign.Repo, pr *github.PrStatus) []string {
+	var reviewers []string
+	for _, request := range pr.ReviewRequests {
+		if request.Slug != "" {
+			reviewers = append(reviewers, repo.OrgName+"/"+request.Slug)
+		} else if request.Login != "" {
+			reviewers = append(reviewers, request.Login)
 		}
+	}
+	return reviewers
+}
+
+func runNag(c *cobra.Command, _ []string) {
+	comment, review := false, false
+	for _, method := range nagBy {
+		comment = comment || method == "comment"
+		review = review || method == "review"
+	}
 
-		err = gh.ClosePullRequest(closeActivity.Writer(), repo.FullRepoPath(), dir.Name)
+	nagged := 0
+	run := prRun{
+		action:      "update-prs --nag",
+		interrupted: "reminding reviewers",
+		confirm: func(dir *campaign.Campaign, _ int) (bool, error) {
+			return yesFlag || p.AskConfirm(dir.Messages.Format(messages.ConfirmNag, messages.Fields{"Campaign": dir.Name, "ReposFile": repoFile, "Days": nagDays})), nil
+		},
+	}
+	ran := forEachPR(c, run, withPR("Checking whether the PR in %s is waiting for a review", func(ctx context.Context, activity *logging.Activity, client github.GitHub, dir *campaign.Campaign, repo campaign.Repo, pr *github.PrStatus) operations.Outcome {
+		// PRs that do not need a reminder have been dealt with, so are not checked again on --resume
+		days := int(now().Sub(pr.CreatedAt).Hours() / 24)
+		if pr.State != "OPEN" {
+			activity.EndWithWarningf("PR is %s, so nobody needs reminding", strings.ToLower(pr.State))
+			return operations.Outcome{Skipped: "PR is not open", Finished: true}
+		}
+		if !pr.Unreviewed() {
+			activity.EndWithWarningf("PR has already been reviewed")
+			return operations.Outcome{Skipped: "PR has been reviewed", Finished: true}
+		}
+		if days < nagDays {
+			activity.EndWithWarningf("PR has only been open for %d days", days)
+			return operations.Outcome{Skipped: "PR is not stale yet", Finished: true}
+		}
+		// reviewers are reminded at most once every --nag-days, however often this is run
+		state, err := campaign.ReadState()
 		if err != nil {
-			if _, ok := err.(*github.NoPRFoundError); ok {
-				closeActivity.EndWithWarning(err)
-				skippedCount++
-			} else {
-				closeActivity.EndWithFailure(err)
-				errorCount++
+			activity.EndWithFailure(err)
+			return operations.Outcome{Err: err}
+		}
+		if nagged, ok := state.LastNag(repo.FullRepoName, dir.BranchName); ok && now().Sub(nagged) < time.Duration(nagDays)*24*time.Hour {
+			activity.EndWithWarningf("Reviewers were reminded %d days ago", int(now().Sub(nagged).Hours()/24))
+			return operations.Outcome{Skipped: "reminded recently", Finished: true}
+		}
+
+		reviewers := nagReviewers(repo, pr)
+		if !comment && len(reviewers) == 0 {
+			activity.EndWithWarningf("Nobody has been asked to review the PR, so there are no reviews to request again")
+			return operations.Outcome{Skipped: "no reviewers requested", Finished: true}
+		}
+
+		if comment {
+			err = client.CommentOnPR(ctx, activity.Writer(), repo.FullRepoPath(), dir.BranchName,
+				dir.Messages.Format(messages.NagComment, messages.Fields{"Campaign": dir.Name, "Days": days}))
+			if err != nil {
+				activity.EndWithFailuref("Unable to comment on the PR: %s", err)
+				return operations.Outcome{Err: err}
+			}
+		}
+		// reviewers can only be asked again if somebody was asked in the first place
+		if review && len(reviewers) > 0 {
+			err = client.RequestReviews(ctx, activity.Writer(), repo.FullRepoPath(), dir.BranchName, reviewers)
+			if err != nil {
+				activity.EndWithFailuref("Unable to request reviews from %s: %s", strings.Join(reviewers, ", "), err)
+				return operations.Outcome{Err: err}
 			}
-		} else {
-			closeActivity.EndWithSuccess()
-			doneCount++
 		}
+		state.RecordNag(campaign.Nag{Repo: repo.FullRepoName, Branch: dir.BranchName, Nagged: now()})
+		if err := state.Save(); err != nil {
+			activity.EndWithFailuref("Reminded reviewers, but unable to record it, so they may be reminded again: %s", err)
+			return operations.Outcome{Err: err}
+		}
+		activity.EndWithSuccess()
+		nagged++
+		return operations.Outcome{Finished: true}
+	}))
+	if ran {
+		logging.NewLogger(c).Printf("Reminded reviewers of %d PRs that had been waiting for a review for %d days or more", nagged, nagDays)
 	}
+}
 
-	if errorCount == 0 {
-		logger.Successf("turbolift update-prs completed %s(%s, %s)\n", colors.Normal(), colors.Green(doneCount, " OK"), colors.Yellow(skippedCount, " skipped"))
-	} else {
-		logger.Warnf("turbolift update-prs completed with %s %s(%s, %s, %s)\n", colors.Red("errors"), colors.Normal(), colors.Green(doneCount, " OK"), colors.Yellow(skippedCount, " skipped"), colors.Red(errorCount, " errored"))
+// reviewersToRequest lists the reviewers whose review of the PR is still to be asked for: those whose review has not
+// already been requested and, unless reRequest is true, who have not reviewed an earlier revision of it
+func reviewersToRequest(repo campaign.Repo, pr *github.PrStatus, reviewers []string, reRequest bool) []string {
+	asked := map[string]bool{}
+	for _, requested := range nagReviewers(repo, pr) {
+		asked[strings.ToLower(requested)] = true
+	}
+	if !reRequest {
+		for _, review := range pr.LatestReviews {
+			asked[strings.ToLower(review.Author.Login)] = true
+		}
+	}
+
+	var toRequest []string
+	for _, reviewer := range reviewers {
+		if !asked[strings.ToLower(reviewer)] {
+			toRequest = append(toRequest, reviewer)
+		}
+	}
+	return toRequest
+}
+
+func runRequestReview(c *cobra.Command, _ []string) {
+	requested := 0
+	run := prRun{
+		action:      "update-prs --request-review",
+		interrupted: "requesting reviews",
+		confirm: func(dir *campaign.Campaign, _ int) (bool, error) {
+			return yesFlag || p.AskConfirm(dir.Messages.Format(messages.ConfirmRequestReview, messages.Fields{"Campaign": dir.Name, "ReposFile": repoFile, "Reviewers": strings.Join(requestReviewers, ", ")})), nil
+		},
+	}
+	ran := forEachPR(c, run, withPR("Requesting reviews of the PR in %s", func(ctx context.Context, activity *logging.Activity, client github.GitHub, dir *campaign.Campaign, repo campaign.Repo, pr *github.PrStatus) operations.Outcome {
+		// reviews of merged and closed PRs are of no use, and PRs that need no more reviewers are done with
+		if pr.State != github.PrOpen {
+			activity.EndWithWarningf("PR is %s, so nobody needs asking", strings.ToLower(pr.State))
+			return operations.Outcome{Skipped: "PR is not open", SkippedAs: strings.ToLower(pr.State), Finished: true}
+		}
+		reviewers := reviewersToRequest(repo, pr, requestReviewers, reRequest)
+		if len(reviewers) == 0 {
+			activity.EndWithWarningf("Everyone has already been asked to review the PR - use --re-request to ask those who have reviewed it again")
+			return operations.Outcome{Skipped: "reviews already requested", Finished: true}
+		}
+
+		err := client.RequestReviews(ctx, activity.Writer(), repo.FullRepoPath(), dir.BranchName, reviewers)
+		if err != nil {
+			activity.EndWithFailuref("Unable to request reviews from %s: %s", strings.Join(reviewers, ", "), err)
+			return operations.Outcome{Err: err}
+		}
+		activity.Logf("Requested reviews from %s", strings.Join(reviewers, ", "))
+		activity.EndWithSuccessAndEmitLogs()
+		requested++
+		return operations.Outcome{Finished: true}
+	}))
+	if ran {
+		logging.NewLogger(c).Printf("Requested reviews on %d PRs", requested)
 	}
 }
 
 func runUpdatePrDescription(c *cobra.Command, _ []string) {
+	clients := operations.Clients{GitHub: gh, Bitbucket: bb}
+	var bodyPipeline *prbody.Pipeline
+	run := prRun{
+		action:      "update-prs --amend-description",
+		interrupted: "updating PRs",
+		confirm: func(dir *campaign.Campaign, _ int) (bool, error) {
+			return yesFlag || p.AskConfirm(dir.Messages.Format(messages.ConfirmAmendDescription, messages.Fields{"Campaign": dir.Name, "ReposFile": repoFile})), nil
+		},
+	}
+	forEachPR(c, run, func(ctx context.Context, logger *logging.Logger, dir *campaign.Campaign, repo campaign.Repo) operations.Outcome {
+		if bodyPipeline == nil {
+			bodyPipeline = prbody.ForCampaign(dir)
+		}
+		return operations.UpdatePRDescription(ctx, logger, clients, dir, repo, bodyPipeline)
+	})
+}
+
+// runWaitChecks polls the checks on every campaign PR until they have all finished or the timeout expires,
+// returning an error if any checks failed or were still running at the timeout, so that pipelines can gate on it
+func runWaitChecks(c *cobra.Command, _ []string) error {
+	ctx := c.Context()
 	logger := logging.NewLogger(c)
 
 	readCampaignActivity := logger.StartActivity("Reading campaign data (%s)", repoFile)
 	options := campaign.NewCampaignOptions()
 	options.RepoFilename = repoFile
-	options.PrDescriptionFilename = prDescriptionFile
+	options.Shuffle = shuffle
+	options.BranchName = branchName
 	dir, err := campaign.OpenCampaign(options)
 	if err != nil {
 		readCampaignActivity.EndWithFailure(err)
-		return
+		return nil
 	}
 	readCampaignActivity.EndWithSuccess()
+	flags.LogShuffle(logger, dir)
 
-	// Prompting for confirmation
-	if !yesFlag {
-		if !p.AskConfirm(fmt.Sprintf("Update %s campaign PR titles and descriptions for all repos listed in %s?", dir.Name, repoFile)) {
-			return
-		}
-	}
-
-	doneCount := 0
-	skippedCount := 0
-	errorCount := 0
+	sum := summary.New("update-prs")
+	// failed checks are errors in the summary, but are reported apart from the PRs whose status could not be found
+	failedCount := 0
 
+	var pending []campaign.Repo
 	for _, repo := range dir.Repos {
-		updatePrActivity := logger.StartActivity("Updating PR description in %s", repo.FullRepoName)
-
 		// skip if the working copy does not exist
 		if _, err = os.Stat(repo.FullRepoPath()); os.IsNotExist(err) {
-			updatePrActivity.EndWithWarningf("Directory %s does not exist - has it been cloned?", repo.FullRepoPath())
-			skippedCount++
+			logger.Warnf("Directory %s does not exist - has it been cloned?", repo.FullRepoPath())
+			sum.RecordSkippedAs(repo, summary.SkippedNotCloned, "not cloned")
+			continue
+		}
+		if repo.OnBitbucket() {
+			logger.Warnf("%s is on Bitbucket, which does not report checks to turbolift", repo.FullRepoName)
+			sum.RecordSkipped(repo, "on Bitbucket, which does not report checks")
 			continue
 		}
+		pending = append(pending, repo)
+	}
 
-		err = gh.UpdatePRDescription(updatePrActivity.Writer(), repo.FullRepoPath(), dir.PrTitle, dir.PrBody)
-		if err != nil {
-			if _, ok := err.(*github.NoPRFoundError); ok {
-				updatePrActivity.EndWithWarning(err)
-				skippedCount++
-			} else {
-				updatePrActivity.EndWithFailure(err)
-				errorCount++
+	started := now()
+	deadline := started.Add(timeout)
+	for len(pending) > 0 && !interrupt.Requested(ctx) {
+		checksActivity := logger.StartActivity("Checking status of %d PRs", len(pending))
+
+		var stillPending []campaign.Repo
+		roundFailures := 0
+		for _, repo := range pending {
+			pr, err := forge.For(repo, gh, bb).GetPR(ctx, checksActivity.Writer(), repo.FullRepoPath(), dir.BranchName)
+			if category, ok := operations.SkippedAs(err); ok {
+				checksActivity.Logf("%s: %s", repo.FullRepoName, err)
+				sum.RecordSkippedAs(repo, category, err.Error())
+				continue
+			} else if err != nil {
+				checksActivity.Logf("%s: unable to get PR status: %s", repo.FullRepoName, err)
+				sum.RecordErrored(repo, err)
+				roundFailures++
+				continue
+			}
+			// the checks of merged and closed PRs no longer matter
+			if pr.State == github.PrMerged || pr.State == github.PrClosed {
+				checksActivity.Logf("%s: PR is %s, so its checks are not waited for", repo.FullRepoName, strings.ToLower(pr.State))
+				sum.RecordSkippedAs(repo, strings.ToLower(pr.State), "PR is "+strings.ToLower(pr.State))
+				continue
+			}
+
+			// checks may not have been started yet on a PR that has just been created or pushed to, so a PR without any
+			// only counts as passing if that is expected, once they have had time to appear
+			if len(pr.StatusCheckRollup) == 0 {
+				switch {
+				case now().Sub(started) < noChecksGrace:
+					stillPending = append(stillPending, repo)
+				case noChecksOk:
+					checksActivity.Logf("%s: no checks", repo.FullRepoName)
+					sum.RecordDone(repo)
+				default:
+					checksActivity.Logf("%s: no checks were reported after %s (%s); use --no-checks-ok if it has none", repo.FullRepoName, noChecksGrace, pr.Url)
+					sum.RecordErrored(repo, fmt.Errorf("no checks were reported after %s (%s)", noChecksGrace, pr.Url))
+					failedCount++
+					roundFailures++
+				}
+				continue
 			}
+
+			switch github.ChecksStatus(pr.StatusCheckRollup) {
+			case "SUCCESS":
+				checksActivity.Logf("%s: checks passed", repo.FullRepoName)
+				sum.RecordDone(repo)
+			case "FAILURE":
+				checksActivity.Logf("%s: checks failed (%s)", repo.FullRepoName, pr.Url)
+				sum.RecordErrored(repo, fmt.Errorf("checks failed (%s)", pr.Url))
+				failedCount++
+				roundFailures++
+			default:
+				stillPending = append(stillPending, repo)
+			}
+		}
+		pending = stillPending
+
+		if roundFailures > 0 {
+			checksActivity.EndWithFailuref("checks did not pass for %d PRs", roundFailures)
 		} else {
-			updatePrActivity.EndWithSuccess()
-			doneCount++
+			checksActivity.EndWithSuccessAndEmitLogs()
 		}
+
+		if len(pending) == 0 {
+			break
+		}
+		if !now().Add(pollInterval).Before(deadline) {
+			for _, repo := range pending {
+				logger.Warnf("Timed out waiting for checks in %s", repo.FullRepoName)
+				sum.RecordErrored(repo, fmt.Errorf("timed out waiting for checks: %w", context.DeadlineExceeded))
+			}
+			break
+		}
+		logger.Printf("Checks are still running for %d PRs, checking again in %s", len(pending), pollInterval)
+		sleep(ctx, pollInterval)
 	}
 
-	if errorCount == 0 {
-		logger.Successf("turbolift update-prs completed %s(%s, %s)\n", colors.Normal(), colors.Green(doneCount, " OK"), colors.Yellow(skippedCount, " skipped"))
+	defer github.LogApiUsage(ctx, gh, logger, dir.Hosts())
+
+	timedOutCount := 0
+	if interrupt.Requested(ctx) {
+		for _, repo := range pending {
+			sum.RecordErrored(repo, fmt.Errorf("checks still running when interrupted: %w", context.Canceled))
+		}
 	} else {
-		logger.Warnf("turbolift update-prs completed with %s %s(%s, %s, %s)\n", colors.Red("errors"), colors.Normal(), colors.Green(doneCount, " OK"), colors.Yellow(skippedCount, " skipped"), colors.Red(errorCount, " errored"))
+		timedOutCount = len(pending)
+	}
+	// the PRs whose status could not be found, as opposed to those whose checks failed or did not finish
+	lookupErrors := sum.Errored - failedCount - len(pending)
+	sum.Finish(ctx)
+	if interrupt.Requested(ctx) {
+		logger.Warnf("%s %s(%s, %s, %s, %s still running, %s)\n", dir.Messages.Format(messages.SummaryInterrupted, messages.Fields{"Command": "update-prs"}), colors.Normal(), colors.Green(sum.Done, " passed"), colors.Yellow(sum.Skipped, " skipped"), colors.Red(failedCount, " failed"), colors.Yellow(len(pending)), colors.Red(lookupErrors, " errored"))
+		c.SilenceUsage = true
+		return errors.New("interrupted while waiting for checks")
+	}
+
+	if sum.Errored == 0 {
+		logger.Successf("%s %s(%s, %s)\n", dir.Messages.Format(messages.SummaryCompleted, messages.Fields{"Command": "update-prs"}), colors.Normal(), colors.Green(sum.Done, " passed"), colors.Yellow(sum.Skipped, " skipped"))
+		return nil
+	}
+
+	logger.Warnf("%s %s(%s, %s, %s, %s, %s)\n", dir.Messages.Format(messages.SummaryWithErrors, messages.Fields{"Command": "update-prs"}), colors.Normal(), colors.Green(sum.Done, " passed"), colors.Yellow(sum.Skipped, " skipped"), colors.Red(failedCount, " failed"), colors.Yellow(timedOutCount, " timed out"), colors.Red(lookupErrors, " errored"))
+	// the problems have already been reported, so there is no need to repeat the usage
+	c.SilenceUsage = true
+	return fmt.Errorf("checks did not pass for %d PRs", sum.Errored)
+}
+
+// sleepUnlessDone sleeps for the given duration, returning early if ctx is cancelled
+func sleepUnlessDone(ctx context.Context, d time.Duration) {
+	select {
+	case <-interrupt.Stopping(ctx).Done():
+	case <-time.After(d):
 	}
 }
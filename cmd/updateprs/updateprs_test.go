@@ -2,12 +2,20 @@ package updateprs
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"io"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/git"
 	"github.com/skyscanner/turbolift/internal/github"
+	"github.com/skyscanner/turbolift/internal/interrupt"
 	"github.com/skyscanner/turbolift/internal/prompt"
 	"github.com/skyscanner/turbolift/internal/testsupport"
 )
@@ -51,6 +59,25 @@ func TestItClosesPrsSuccessfully(t *testing.T) {
 	})
 }
 
+func TestItLooksUpPrsRecordedInTheCampaignState(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+
+	tempDir := testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+	state, _ := campaign.ReadState()
+	state.RecordPR(campaign.TrackedPR{Repo: "org/repo1", Branch: filepath.Base(tempDir), Number: 7})
+	assert.NoError(t, state.Save())
+
+	_, err := runCloseCommandAuto()
+	assert.NoError(t, err)
+
+	number, ok := fakeGitHub.KnownPR("work/org/repo1", filepath.Base(tempDir))
+	assert.True(t, ok)
+	assert.Equal(t, 7, number)
+	_, ok = fakeGitHub.KnownPR("work/org/repo2", filepath.Base(tempDir))
+	assert.False(t, ok)
+}
+
 func TestNoPRFound(t *testing.T) {
 	fakeGitHub := github.NewAlwaysThrowNoPRFound()
 	gh = fakeGitHub
@@ -70,6 +97,83 @@ func TestNoPRFound(t *testing.T) {
 	})
 }
 
+func TestItSkipsMergedClosedAndArchivedPrsWhenClosing(t *testing.T) {
+	fakeGitHub := github.NewFakeGitHub(func(command github.Command, args []string) (bool, error) {
+		switch args[1] {
+		case "work/org/merged":
+			return false, &github.PrNotOpenError{Path: args[1], State: github.PrMerged}
+		case "work/org/closed":
+			return false, &github.PrNotOpenError{Path: args[1], State: github.PrClosed}
+		case "work/org/archived":
+			return false, &github.RepoArchivedError{Repo: "org/archived"}
+		default:
+			return true, nil
+		}
+	}, nil)
+	gh = fakeGitHub
+
+	testsupport.PrepareTempCampaign(true, "org/open", "org/merged", "org/closed", "org/archived")
+
+	out, err := runCloseCommandAuto()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "PR for work/org/merged is already merged")
+	assert.Contains(t, out, "org/archived is archived, so its PR cannot be changed")
+	assert.Contains(t, out, "turbolift update-prs completed")
+	assert.NotContains(t, out, "errored")
+	assert.Contains(t, out, "1 OK, 3 skipped")
+	assert.Contains(t, out, "Skipped repos: 1 merged, 1 closed, 1 archived")
+}
+
+func TestItLeavesPrsThatWereAlreadyClosedAloneWhenResuming(t *testing.T) {
+	ctx, requestStop := interrupt.WithGracefulStop(context.Background())
+	defer requestStop()
+	fakeGitHub := github.NewFakeGitHub(func(command github.Command, args []string) (bool, error) {
+		switch args[1] {
+		case "work/org/merged":
+			return false, &github.PrNotOpenError{Path: args[1], State: github.PrMerged}
+		case "work/org/closed":
+			return false, &github.PrNotOpenError{Path: args[1], State: github.PrClosed}
+		case "work/org/open1":
+			// a Ctrl-C arrives while the first open PR is being closed
+			requestStop()
+		}
+		return true, nil
+	}, nil)
+	gh = fakeGitHub
+
+	tempDir := testsupport.PrepareTempCampaign(true, "org/merged", "org/closed", "org/open1", "org/open2")
+
+	cmd := NewUpdatePRsCmd()
+	closeFlag = true
+	yesFlag = true
+	outBuffer := bytes.NewBufferString("")
+	cmd.SetOut(outBuffer)
+	assert.NoError(t, cmd.ExecuteContext(ctx))
+	assert.Contains(t, outBuffer.String(), "Interrupted, so not closing PRs for the remaining 1 repos")
+
+	state, err := campaign.ReadState()
+	assert.NoError(t, err)
+	assert.Len(t, state.Checkpoints, 1)
+	assert.Equal(t, []string{"org/merged", "org/closed", "org/open1"}, state.Checkpoints[0].Completed)
+
+	fakeGitHub = github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+
+	cmd = NewUpdatePRsCmd()
+	closeFlag = true
+	yesFlag = true
+	cmd.SetArgs([]string{"--resume"})
+	outBuffer = bytes.NewBufferString("")
+	cmd.SetOut(outBuffer)
+	assert.NoError(t, cmd.Execute())
+	assert.Contains(t, outBuffer.String(), "Resuming the last run, so leaving alone the 3 repos it had finished with")
+	assert.Contains(t, outBuffer.String(), "1 OK, 0 skipped")
+
+	fakeGitHub.AssertCalledWith(t, [][]string{
+		{"close_pull_request", "work/org/open2", filepath.Base(tempDir)},
+	})
+}
+
 func TestItDoesNotClosePRsIfNotConfirmed(t *testing.T) {
 	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
 	gh = fakeGitHub
@@ -88,6 +192,29 @@ func TestItDoesNotClosePRsIfNotConfirmed(t *testing.T) {
 	fakeGitHub.AssertCalledWith(t, [][]string{})
 }
 
+func TestItNeedsTheCampaignNameTypedToCloseManyPRsEvenWithYes(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+	p = prompt.NewFakePromptNo()
+
+	tempDir := testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+	testsupport.CreateConfigFile("confirm:\n  threshold: 1\n")
+
+	out, err := runCloseCommandAuto()
+	assert.NoError(t, err)
+	assert.NotContains(t, out, "turbolift update-prs completed")
+	fakeGitHub.AssertCalledWith(t, [][]string{})
+
+	p = prompt.NewFakePromptYes()
+	out, err = runCloseCommandAuto()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "2 OK, 0 skipped")
+	fakeGitHub.AssertCalledWith(t, [][]string{
+		{"close_pull_request", "work/org/repo1", filepath.Base(tempDir)},
+		{"close_pull_request", "work/org/repo2", filepath.Base(tempDir)},
+	})
+}
+
 func TestItLogsUpdateDescriptionErrorsButContinuesToTryAll(t *testing.T) {
 	fakeGitHub := github.NewAlwaysFailsFakeGitHub()
 	gh = fakeGitHub
@@ -102,8 +229,8 @@ func TestItLogsUpdateDescriptionErrorsButContinuesToTryAll(t *testing.T) {
 	assert.Contains(t, out, "2 errored")
 
 	fakeGitHub.AssertCalledWith(t, [][]string{
-		{"update_pr_description", "work/org/repo1", "PR title", "PR body"},
-		{"update_pr_description", "work/org/repo2", "PR title", "PR body"},
+		{"get_pr", "work/org/repo1"},
+		{"get_pr", "work/org/repo2"},
 	})
 }
 
@@ -122,8 +249,10 @@ func TestItUpdatesDescriptionsSuccessfully(t *testing.T) {
 	assert.Contains(t, out, "2 OK, 0 skipped")
 
 	fakeGitHub.AssertCalledWith(t, [][]string{
-		{"update_pr_description", "work/org/repo1", "Updated PR title", "Updated PR body"},
-		{"update_pr_description", "work/org/repo2", "Updated PR title", "Updated PR body"},
+		{"get_pr", "work/org/repo1"},
+		{"update_pr_description", "work/org/repo1", "Updated PR title", github.WithCampaignMarker("Updated PR body", testsupport.Pwd())},
+		{"get_pr", "work/org/repo2"},
+		{"update_pr_description", "work/org/repo2", "Updated PR title", github.WithCampaignMarker("Updated PR body", testsupport.Pwd())},
 	})
 }
 
@@ -142,8 +271,58 @@ func TestItUpdatesDescriptionsFromAlternativeFile(t *testing.T) {
 	assert.Contains(t, out, "2 OK, 0 skipped")
 
 	fakeGitHub.AssertCalledWith(t, [][]string{
-		{"update_pr_description", "work/org/repo1", "custom PR title", "custom PR body"},
-		{"update_pr_description", "work/org/repo2", "custom PR title", "custom PR body"},
+		{"get_pr", "work/org/repo1"},
+		{"update_pr_description", "work/org/repo1", "custom PR title", github.WithCampaignMarker("custom PR body", testsupport.Pwd())},
+		{"get_pr", "work/org/repo2"},
+		{"update_pr_description", "work/org/repo2", "custom PR title", github.WithCampaignMarker("custom PR body", testsupport.Pwd())},
+	})
+}
+
+func TestItKeepsTickedChecklistItemsWhenUpdatingDescriptions(t *testing.T) {
+	previousBody := github.WithChecklist("PR body", "### Reviewer checklist", []string{"Deployed repo1 to staging", "Dashboards checked"}, "")
+	previousBody = strings.Replace(previousBody, "- [ ] Deployed repo1", "- [x] Deployed repo1", 1)
+	fakeGitHub := github.NewFakeGitHub(func(command github.Command, args []string) (bool, error) {
+		return true, nil
+	}, func(workingDir string) (interface{}, error) {
+		return &github.PrStatus{Body: previousBody}, nil
+	})
+	gh = fakeGitHub
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+	testsupport.CreateConfigFile("templating: true\nchecklist:\n  - Deployed {{ .RepoName }} to staging\n  - Dashboards checked\n")
+
+	out, err := runUpdateDescriptionCommandAuto("README.md")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "1 OK, 0 skipped")
+
+	fakeGitHub.AssertCalledWith(t, [][]string{
+		{"get_pr", "work/org/repo1"},
+		{"update_pr_description", "work/org/repo1", "PR title", github.WithCampaignMarker("PR body\n\n<!-- turbolift:checklist -->\n### Reviewer checklist\n\n- [x] Deployed repo1 to staging\n- [ ] Dashboards checked\n<!-- turbolift:checklist-end -->", testsupport.Pwd())},
+	})
+}
+
+func TestItSkipsMergedPrsWhenUpdatingDescriptions(t *testing.T) {
+	fakeGitHub := github.NewFakeGitHub(func(command github.Command, args []string) (bool, error) {
+		return true, nil
+	}, func(workingDir string) (interface{}, error) {
+		if workingDir == "work/org/repo1" {
+			return &github.PrStatus{State: github.PrMerged}, nil
+		}
+		return &github.PrStatus{State: github.PrOpen}, nil
+	})
+	gh = fakeGitHub
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+
+	out, err := runUpdateDescriptionCommandAuto("README.md")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "PR for work/org/repo1 is already merged")
+	assert.Contains(t, out, "1 OK, 1 skipped")
+
+	fakeGitHub.AssertCalledWith(t, [][]string{
+		{"get_pr", "work/org/repo1"},
+		{"get_pr", "work/org/repo2"},
+		{"update_pr_description", "work/org/repo2", "PR title", github.WithCampaignMarker("PR body", testsupport.Pwd())},
 	})
 }
 
@@ -165,6 +344,216 @@ func TestItDoesNotUpdateDescriptionsIfNotConfirmed(t *testing.T) {
 	fakeGitHub.AssertCalledWith(t, [][]string{})
 }
 
+func TestItReopensPrsSuccessfully(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+
+	tempDir := testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+
+	out, err := runReopenCommandAuto()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Reopening PR in org/repo1")
+	assert.Contains(t, out, "Reopening PR in org/repo2")
+	assert.Contains(t, out, "turbolift update-prs completed")
+	assert.Contains(t, out, "2 OK, 0 skipped")
+
+	fakeGitHub.AssertCalledWith(t, [][]string{
+		{"reopen_pull_request", "work/org/repo1", filepath.Base(tempDir)},
+		{"reopen_pull_request", "work/org/repo2", filepath.Base(tempDir)},
+	})
+}
+
+func TestItSkipsPrsThatAreNotClosedWhenReopening(t *testing.T) {
+	fakeGitHub := github.NewFakeGitHub(func(command github.Command, args []string) (bool, error) {
+		switch args[1] {
+		case "work/org/merged":
+			return false, &github.PrNotClosedError{Path: args[1], State: "MERGED"}
+		case "work/org/broken":
+			return false, errors.New("synthetic error")
+		default:
+			return true, nil
+		}
+	}, nil)
+	gh = fakeGitHub
+
+	testsupport.PrepareTempCampaign(true, "org/closed", "org/merged", "org/broken")
+
+	out, err := runReopenCommandAuto()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "PR for work/org/merged is merged, not closed")
+	assert.Contains(t, out, "turbolift update-prs completed with errors")
+	assert.Contains(t, out, "1 OK, 1 skipped, 1 errored")
+	assert.Contains(t, out, "Skipped repos: 1 merged")
+}
+
+func TestNoPRFoundWhenReopening(t *testing.T) {
+	fakeGitHub := github.NewAlwaysThrowNoPRFound()
+	gh = fakeGitHub
+
+	tempDir := testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runReopenCommandAuto()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "no PR found for work/org/repo1 and branch "+filepath.Base(tempDir))
+	assert.Contains(t, out, "0 OK, 1 skipped")
+}
+
+func TestItRejectsCloseAndReopenTogether(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	cmd := NewUpdatePRsCmd()
+	closeFlag = true
+	reopenFlag = true
+	yesFlag = true
+	outBuffer := bytes.NewBufferString("")
+	cmd.SetOut(outBuffer)
+	err := cmd.Execute()
+	assert.NoError(t, err)
+	assert.Contains(t, outBuffer.String(), "update-prs needs one and only one action flag")
+
+	fakeGitHub.AssertCalledWith(t, [][]string{})
+}
+
+func TestItDeletesTheBranchesOfClosedAndMergedPrs(t *testing.T) {
+	fakeGitHub := github.NewFakeGitHub(nil, func(workingDir string) (interface{}, error) {
+		switch workingDir {
+		case "work/org/open":
+			return &github.PrStatus{State: github.PrOpen}, nil
+		case "work/org/closed":
+			return &github.PrStatus{State: github.PrClosed}, nil
+		default:
+			return &github.PrStatus{State: github.PrMerged}, nil
+		}
+	})
+	gh = fakeGitHub
+	fakeGit := git.NewFakeGit(func(_ io.Writer, call []string) (bool, error) {
+		if call[0] == "hasRemote" {
+			return false, nil
+		}
+		return call[1] != "work/org/deleted", nil
+	})
+	g = fakeGit
+
+	tempDir := testsupport.PrepareTempCampaign(true, "org/open", "org/closed", "org/merged", "org/deleted")
+	branch := filepath.Base(tempDir)
+
+	out, err := runDeleteBranchesCommandAuto(false)
+	assert.NoError(t, err)
+	assert.Contains(t, out, "PR is still open")
+	assert.Contains(t, out, "The branch has already been deleted")
+	assert.Contains(t, out, "turbolift update-prs completed")
+	assert.Contains(t, out, "2 OK, 2 skipped")
+
+	fakeGit.AssertCalledWith(t, [][]string{
+		{"hasRemote", "work/org/closed", "upstream"},
+		{"hasRemoteBranch", "work/org/closed", "origin", branch},
+		{"deleteRemoteBranch", "work/org/closed", "origin", branch},
+		{"hasRemote", "work/org/merged", "upstream"},
+		{"hasRemoteBranch", "work/org/merged", "origin", branch},
+		{"deleteRemoteBranch", "work/org/merged", "origin", branch},
+		{"hasRemote", "work/org/deleted", "upstream"},
+		{"hasRemoteBranch", "work/org/deleted", "origin", branch},
+	})
+}
+
+func TestItOnlyDeletesBranchesOnForksWhenAskedTo(t *testing.T) {
+	fakeGitHub := github.NewFakeGitHub(nil, func(workingDir string) (interface{}, error) {
+		return &github.PrStatus{State: github.PrMerged}, nil
+	})
+	gh = fakeGitHub
+	fakeGit := git.NewAlwaysSucceedsFakeGit()
+	g = fakeGit
+
+	tempDir := testsupport.PrepareTempCampaign(true, "org/forked")
+	branch := filepath.Base(tempDir)
+
+	out, err := runDeleteBranchesCommandAuto(false)
+	assert.NoError(t, err)
+	assert.Contains(t, out, "use --include-forks to delete it too")
+	assert.Contains(t, out, "0 OK, 1 skipped")
+	fakeGit.AssertCalledWith(t, [][]string{
+		{"hasRemote", "work/org/forked", "upstream"},
+	})
+
+	fakeGit = git.NewAlwaysSucceedsFakeGit()
+	g = fakeGit
+
+	out, err = runDeleteBranchesCommandAuto(true)
+	assert.NoError(t, err)
+	assert.Contains(t, out, "1 OK, 0 skipped")
+	fakeGit.AssertCalledWith(t, [][]string{
+		{"hasRemote", "work/org/forked", "upstream"},
+		{"hasRemoteBranch", "work/org/forked", "origin", branch},
+		{"deleteRemoteBranch", "work/org/forked", "origin", branch},
+	})
+}
+
+func TestItNeverDeletesTheBaseBranchOfAPr(t *testing.T) {
+	tempDir := testsupport.PrepareTempCampaign(true, "org/repo1")
+	branch := filepath.Base(tempDir)
+	fakeGitHub := github.NewFakeGitHub(nil, func(workingDir string) (interface{}, error) {
+		return &github.PrStatus{State: github.PrMerged, BaseRefName: branch}, nil
+	})
+	gh = fakeGitHub
+	fakeGit := git.NewAlwaysSucceedsFakeGit()
+	g = fakeGit
+
+	out, err := runDeleteBranchesCommandAuto(false)
+	assert.NoError(t, err)
+	assert.Contains(t, out, "is the base branch of the PR or the repo's default branch, so it is not deleted")
+	assert.Contains(t, out, "1 errored")
+	fakeGit.AssertCalledWith(t, [][]string{})
+}
+
+func TestItRejectsIncludeForksWithoutDeleteBranches(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	cmd := NewUpdatePRsCmd()
+	closeFlag = true
+	includeForks = true
+	yesFlag = true
+	outBuffer := bytes.NewBufferString("")
+	cmd.SetOut(outBuffer)
+	err := cmd.Execute()
+	assert.NoError(t, err)
+	assert.Contains(t, outBuffer.String(), "--include-forks can only be used with --delete-branches")
+
+	fakeGitHub.AssertCalledWith(t, [][]string{})
+}
+
+func runDeleteBranchesCommandAuto(forks bool) (string, error) {
+	cmd := NewUpdatePRsCmd()
+	deleteBranchesFlag = true
+	includeForks = forks
+	yesFlag = true
+	outBuffer := bytes.NewBufferString("")
+	cmd.SetOut(outBuffer)
+	err := cmd.Execute()
+	if err != nil {
+		return outBuffer.String(), err
+	}
+	return outBuffer.String(), nil
+}
+
+func runReopenCommandAuto() (string, error) {
+	cmd := NewUpdatePRsCmd()
+	reopenFlag = true
+	yesFlag = true
+	outBuffer := bytes.NewBufferString("")
+	cmd.SetOut(outBuffer)
+	err := cmd.Execute()
+	if err != nil {
+		return outBuffer.String(), err
+	}
+	return outBuffer.String(), nil
+}
+
 func runCloseCommandAuto() (string, error) {
 	cmd := NewUpdatePRsCmd()
 	closeFlag = true
@@ -217,3 +606,559 @@ func runUpdateDescriptionCommandConfirm() (string, error) {
 	}
 	return outBuffer.String(), nil
 }
+
+func TestItEnablesAutoMergeOnPrs(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+
+	tempDir := testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+
+	out, err := runEnableAutoMergeCommand("--enable-auto-merge=merge", "--yes")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Enabling auto-merge for PR in org/repo1")
+	assert.Contains(t, out, "2 OK, 0 skipped")
+
+	fakeGitHub.AssertCalledWith(t, [][]string{
+		{"enable_auto_merge", "work/org/repo1", filepath.Base(tempDir), "merge"},
+		{"enable_auto_merge", "work/org/repo2", filepath.Base(tempDir), "merge"},
+	})
+}
+
+func TestItSquashesRatherThanMergesWhereLinearHistoryIsRequired(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub().WithLinearHistory("work/org/repo1")
+	gh = fakeGitHub
+
+	tempDir := testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+
+	out, err := runEnableAutoMergeCommand("--enable-auto-merge=merge", "--yes")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "org/repo1 requires linear history, so the PR will be merged with squash rather than merge")
+	assert.Contains(t, out, "2 OK, 0 skipped")
+
+	fakeGitHub.AssertCalledWith(t, [][]string{
+		{"enable_auto_merge", "work/org/repo1", filepath.Base(tempDir), "squash"},
+		{"enable_auto_merge", "work/org/repo2", filepath.Base(tempDir), "merge"},
+	})
+}
+
+func TestItUpdatesPrBranchesRebasingWhereLinearHistoryIsRequired(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub().WithLinearHistory("work/org/repo2")
+	gh = fakeGitHub
+
+	tempDir := testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+
+	out, err := runEnableAutoMergeCommand("--update-branch", "--yes")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Updating PR branch in org/repo1")
+	assert.Contains(t, out, "org/repo2 requires linear history, so the PR branch will be rebased rather than merged")
+	assert.Contains(t, out, "2 OK, 0 skipped")
+
+	fakeGitHub.AssertCalledWith(t, [][]string{
+		{"update_pr_branch", "work/org/repo1", filepath.Base(tempDir), "merge"},
+		{"update_pr_branch", "work/org/repo2", filepath.Base(tempDir), "rebase"},
+	})
+}
+
+func TestItSkipsUpdatingPrBranchesWhereThereIsNoPr(t *testing.T) {
+	fakeGitHub := github.NewAlwaysThrowNoPRFound()
+	gh = fakeGitHub
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runEnableAutoMergeCommand("--update-branch", "--yes")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "0 OK, 1 skipped")
+}
+
+func TestItSkipsEnablingAutoMergeWhereThereIsNoPr(t *testing.T) {
+	fakeGitHub := github.NewAlwaysThrowNoPRFound()
+	gh = fakeGitHub
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runEnableAutoMergeCommand("--enable-auto-merge", "--yes")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "0 OK, 1 skipped")
+}
+
+func TestItSkipsMergedClosedAndArchivedPrsWhenEnablingAutoMerge(t *testing.T) {
+	fakeGitHub := github.NewFakeGitHub(func(command github.Command, args []string) (bool, error) {
+		switch args[1] {
+		case "work/org/merged":
+			return false, &github.PrNotOpenError{Path: args[1], State: github.PrMerged}
+		case "work/org/closed":
+			return false, &github.PrNotOpenError{Path: args[1], State: github.PrClosed}
+		case "work/org/archived":
+			return false, &github.RepoArchivedError{Repo: "org/archived"}
+		default:
+			return true, nil
+		}
+	}, nil)
+	gh = fakeGitHub
+
+	testsupport.PrepareTempCampaign(true, "org/open", "org/merged", "org/closed", "org/archived")
+
+	out, err := runEnableAutoMergeCommand("--enable-auto-merge", "--yes")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "PR for work/org/closed is already closed")
+	assert.NotContains(t, out, "errored")
+	assert.Contains(t, out, "1 OK, 3 skipped")
+	assert.Contains(t, out, "Skipped repos: 1 merged, 1 closed, 1 archived")
+}
+
+func TestItSkipsReposThatWereNotClonedWhenUpdatingPrBranches(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+
+	testsupport.PrepareTempCampaign(false, "org/repo1")
+
+	out, err := runEnableAutoMergeCommand("--update-branch", "--yes")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "0 OK, 1 skipped")
+	assert.Contains(t, out, "Skipped repos: 1 not-cloned")
+
+	fakeGitHub.AssertCalledWith(t, [][]string{})
+}
+
+func TestItRejectsUnknownAutoMergeStrategies(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runEnableAutoMergeCommand("--enable-auto-merge=fast-forward", "--yes")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "unknown merge strategy fast-forward: must be one of merge, squash, rebase")
+
+	fakeGitHub.AssertCalledWith(t, [][]string{})
+}
+
+func TestItAddsLabelsToPrs(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+
+	tempDir := testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+
+	out, err := runEnableAutoMergeCommand("--add-label", "deadline-friday", "--add-label", "campaign,tooling", "--yes")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Adding labels to PR in org/repo1")
+	assert.Contains(t, out, "2 OK, 0 skipped")
+
+	fakeGitHub.AssertCalledWith(t, [][]string{
+		{"add_pr_labels", "work/org/repo1", filepath.Base(tempDir), "deadline-friday", "campaign", "tooling"},
+		{"add_pr_labels", "work/org/repo2", filepath.Base(tempDir), "deadline-friday", "campaign", "tooling"},
+	})
+}
+
+func TestItRemovesLabelsFromPrsSkippingReposWithoutAPr(t *testing.T) {
+	fakeGitHub := github.NewAlwaysThrowNoPRFound()
+	gh = fakeGitHub
+
+	tempDir := testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runEnableAutoMergeCommand("--remove-label", "wip", "--yes")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Removing labels from PR in org/repo1")
+	assert.Contains(t, out, "0 OK, 1 skipped")
+
+	fakeGitHub.AssertCalledWith(t, [][]string{
+		{"remove_pr_labels", "work/org/repo1", filepath.Base(tempDir), "wip"},
+	})
+}
+
+func TestItDoesNotAllowAddingAndRemovingLabelsTogether(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runEnableAutoMergeCommand("--add-label", "ready", "--remove-label", "wip", "--yes")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "update-prs needs one and only one action flag")
+
+	fakeGitHub.AssertCalledWith(t, [][]string{})
+}
+
+func TestItRejectsEmptyLabels(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runEnableAutoMergeCommand("--add-label", "ready,,wip", "--yes")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "labels must not be empty")
+
+	fakeGitHub.AssertCalledWith(t, [][]string{})
+}
+
+func TestItNagsReviewersOfStalePrs(t *testing.T) {
+	useFakeClock()
+	daysAgo := func(days int) time.Time { return now().AddDate(0, 0, -days) }
+	fakeGitHub := github.NewFakeGitHub(func(command github.Command, args []string) (bool, error) {
+		return true, nil
+	}, func(workingDir string) (interface{}, error) {
+		switch workingDir {
+		case "work/org/stale":
+			return &github.PrStatus{State: "OPEN", CreatedAt: daysAgo(10), ReviewRequests: []github.ReviewRequest{{Login: "octocat"}, {Slug: "platform"}}}, nil
+		case "work/org/fresh":
+			return &github.PrStatus{State: "OPEN", CreatedAt: daysAgo(2)}, nil
+		case "work/org/reviewed":
+			return &github.PrStatus{State: "OPEN", CreatedAt: daysAgo(10), ReviewDecision: "CHANGES_REQUESTED"}, nil
+		}
+		return &github.PrStatus{State: "MERGED", CreatedAt: daysAgo(10)}, nil
+	})
+	gh = fakeGitHub
+
+	tempDir := testsupport.PrepareTempCampaign(true, "org/stale", "org/fresh", "org/reviewed", "org/merged")
+
+	out, err := runEnableAutoMergeCommand("--nag", "--nag-by", "comment,review", "--yes")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "PR has only been open for 2 days")
+	assert.Contains(t, out, "PR has already been reviewed")
+	assert.Contains(t, out, "PR is merged, so nobody needs reminding")
+	assert.Contains(t, out, "1 OK, 3 skipped")
+	assert.Contains(t, out, "Reminded reviewers of 1 PRs that had been waiting for a review for 7 days or more")
+
+	branch := filepath.Base(tempDir)
+	fakeGitHub.AssertCalledWith(t, [][]string{
+		{"get_pr", "work/org/stale"},
+		{"comment_on_pr", "work/org/stale", branch, "This PR from the " + branch + " campaign has been waiting for a review for 10 days. Could somebody take a look, please?"},
+		{"request_reviews", "work/org/stale", branch, "octocat", "org/platform"},
+		{"get_pr", "work/org/fresh"},
+		{"get_pr", "work/org/reviewed"},
+		{"get_pr", "work/org/merged"},
+	})
+}
+
+func TestItOnlyNagsOnceEveryNagDays(t *testing.T) {
+	useFakeClock()
+	created := now().AddDate(0, 0, -10)
+	fakeGitHub := github.NewFakeGitHub(func(command github.Command, args []string) (bool, error) {
+		return true, nil
+	}, func(workingDir string) (interface{}, error) {
+		return &github.PrStatus{State: "OPEN", CreatedAt: created}, nil
+	})
+	gh = fakeGitHub
+
+	tempDir := testsupport.PrepareTempCampaign(true, "org/stale")
+	branch := filepath.Base(tempDir)
+
+	out, err := runEnableAutoMergeCommand("--nag", "--yes")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "1 OK, 0 skipped")
+
+	// a scheduled run the next day leaves the PR alone
+	clock := now
+	now = func() time.Time { return clock().AddDate(0, 0, 1) }
+	out, err = runEnableAutoMergeCommand("--nag", "--yes")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Reviewers were reminded 1 days ago")
+	assert.Contains(t, out, "0 OK, 1 skipped")
+
+	// and once --nag-days have passed, the reviewers are reminded again
+	now = func() time.Time { return clock().AddDate(0, 0, 7) }
+	out, err = runEnableAutoMergeCommand("--nag", "--yes")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "1 OK, 0 skipped")
+
+	fakeGitHub.AssertCalledWith(t, [][]string{
+		{"get_pr", "work/org/stale"},
+		{"comment_on_pr", "work/org/stale", branch, "This PR from the " + branch + " campaign has been waiting for a review for 10 days. Could somebody take a look, please?"},
+		{"get_pr", "work/org/stale"},
+		{"get_pr", "work/org/stale"},
+		{"comment_on_pr", "work/org/stale", branch, "This PR from the " + branch + " campaign has been waiting for a review for 17 days. Could somebody take a look, please?"},
+	})
+}
+
+func TestItSkipsReRequestingReviewsWhereNobodyWasAsked(t *testing.T) {
+	useFakeClock()
+	fakeGitHub := github.NewFakeGitHub(func(command github.Command, args []string) (bool, error) {
+		return true, nil
+	}, func(workingDir string) (interface{}, error) {
+		return &github.PrStatus{State: "OPEN", CreatedAt: now().AddDate(0, 0, -2)}, nil
+	})
+	gh = fakeGitHub
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runEnableAutoMergeCommand("--nag", "--nag-days", "1", "--nag-by", "review", "--yes")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Nobody has been asked to review the PR, so there are no reviews to request again")
+	assert.Contains(t, out, "0 OK, 1 skipped")
+
+	fakeGitHub.AssertCalledWith(t, [][]string{
+		{"get_pr", "work/org/repo1"},
+	})
+}
+
+func TestItRequestsReviewsFromReviewersNotYetAsked(t *testing.T) {
+	fakeGitHub := github.NewFakeGitHub(func(command github.Command, args []string) (bool, error) {
+		return true, nil
+	}, func(workingDir string) (interface{}, error) {
+		switch workingDir {
+		case "work/org/repo1":
+			return &github.PrStatus{State: "OPEN"}, nil
+		case "work/org/repo2":
+			pr := &github.PrStatus{State: "OPEN", ReviewRequests: []github.ReviewRequest{{Slug: "platform"}}}
+			pr.LatestReviews = []github.Review{{State: "COMMENTED"}}
+			pr.LatestReviews[0].Author.Login = "octocat"
+			return pr, nil
+		case "work/org/repo3":
+			return &github.PrStatus{State: "OPEN", ReviewRequests: []github.ReviewRequest{{Login: "octocat"}, {Slug: "platform"}}}, nil
+		}
+		return &github.PrStatus{State: "MERGED"}, nil
+	})
+	gh = fakeGitHub
+
+	tempDir := testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2", "org/repo3", "org/merged")
+
+	out, err := runEnableAutoMergeCommand("--request-review", "octocat,org/platform", "--yes")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Everyone has already been asked to review the PR")
+	assert.Contains(t, out, "PR is merged, so nobody needs asking")
+	assert.Contains(t, out, "1 OK, 3 skipped")
+	assert.Contains(t, out, "Requested reviews on 1 PRs")
+
+	branch := filepath.Base(tempDir)
+	fakeGitHub.AssertCalledWith(t, [][]string{
+		{"get_pr", "work/org/repo1"},
+		{"request_reviews", "work/org/repo1", branch, "octocat", "org/platform"},
+		{"get_pr", "work/org/repo2"},
+		{"get_pr", "work/org/repo3"},
+		{"get_pr", "work/org/merged"},
+	})
+}
+
+func TestItReRequestsReviewsFromReviewersWhoHaveReviewed(t *testing.T) {
+	fakeGitHub := github.NewFakeGitHub(func(command github.Command, args []string) (bool, error) {
+		return true, nil
+	}, func(workingDir string) (interface{}, error) {
+		pr := &github.PrStatus{State: "OPEN", ReviewRequests: []github.ReviewRequest{{Slug: "platform"}}}
+		pr.LatestReviews = []github.Review{{State: "CHANGES_REQUESTED"}}
+		pr.LatestReviews[0].Author.Login = "octocat"
+		return pr, nil
+	})
+	gh = fakeGitHub
+
+	tempDir := testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runEnableAutoMergeCommand("--request-review", "octocat", "--request-review", "org/platform", "--re-request", "--yes")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "1 OK, 0 skipped")
+
+	fakeGitHub.AssertCalledWith(t, [][]string{
+		{"get_pr", "work/org/repo1"},
+		{"request_reviews", "work/org/repo1", filepath.Base(tempDir), "octocat"},
+	})
+}
+
+func TestItRejectsReRequestWithoutRequestReview(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runEnableAutoMergeCommand("--close", "--re-request", "--yes")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "--re-request can only be used with --request-review")
+
+	fakeGitHub.AssertCalledWith(t, [][]string{})
+}
+
+func TestItRejectsUnknownNagMethods(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runEnableAutoMergeCommand("--nag", "--nag-by", "email", "--yes")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "unknown --nag-by method email: must be one of comment, review")
+
+	fakeGitHub.AssertCalledWith(t, [][]string{})
+}
+
+func TestItWaitsForChecksToFinish(t *testing.T) {
+	polls := map[string]int{}
+	fakeGitHub := github.NewFakeGitHub(nil, func(workingDir string) (interface{}, error) {
+		polls[workingDir]++
+		switch {
+		case workingDir == "work/org/repo1" && polls[workingDir] < 3:
+			return &github.PrStatus{StatusCheckRollup: []github.StatusCheckRollup{{State: "PENDING"}}}, nil
+		case workingDir == "work/org/repo3":
+			return nil, &github.NoPRFoundError{Path: workingDir}
+		}
+		return &github.PrStatus{StatusCheckRollup: []github.StatusCheckRollup{{State: "SUCCESS"}}}, nil
+	})
+	gh = fakeGitHub
+	slept := useFakeClock()
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2", "org/repo3")
+
+	out, err := runWaitChecksCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "org/repo2: checks passed")
+	assert.Contains(t, out, "Checks are still running for 1 PRs, checking again in 30s")
+	assert.Contains(t, out, "org/repo1: checks passed")
+	assert.Contains(t, out, "turbolift update-prs completed (2 passed, 1 skipped)")
+	assert.Equal(t, 2, *slept)
+
+	fakeGitHub.AssertCalledWith(t, [][]string{
+		{"get_pr", "work/org/repo1"},
+		{"get_pr", "work/org/repo2"},
+		{"get_pr", "work/org/repo3"},
+		{"get_pr", "work/org/repo1"},
+		{"get_pr", "work/org/repo1"},
+	})
+}
+
+func TestItDoesNotWaitForTheChecksOfMergedOrClosedPrs(t *testing.T) {
+	fakeGitHub := github.NewFakeGitHub(nil, func(workingDir string) (interface{}, error) {
+		switch workingDir {
+		case "work/org/merged":
+			return &github.PrStatus{State: github.PrMerged, StatusCheckRollup: []github.StatusCheckRollup{{State: "PENDING"}}}, nil
+		case "work/org/closed":
+			return &github.PrStatus{State: github.PrClosed}, nil
+		}
+		return &github.PrStatus{State: github.PrOpen, StatusCheckRollup: []github.StatusCheckRollup{{State: "SUCCESS"}}}, nil
+	})
+	gh = fakeGitHub
+	slept := useFakeClock()
+
+	testsupport.PrepareTempCampaign(true, "org/open", "org/merged", "org/closed")
+
+	out, err := runWaitChecksCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "org/merged: PR is merged, so its checks are not waited for")
+	assert.Contains(t, out, "turbolift update-prs completed (1 passed, 2 skipped)")
+	assert.Equal(t, 0, *slept)
+}
+
+func TestItFailsIfAnyChecksFail(t *testing.T) {
+	fakeGitHub := github.NewFakeGitHub(nil, func(workingDir string) (interface{}, error) {
+		if workingDir == "work/org/repo1" {
+			return &github.PrStatus{Url: "https://github.com/org/repo1/pull/1", StatusCheckRollup: []github.StatusCheckRollup{{Conclusion: "FAILURE"}}}, nil
+		}
+		return &github.PrStatus{StatusCheckRollup: []github.StatusCheckRollup{{State: "SUCCESS"}}}, nil
+	})
+	gh = fakeGitHub
+	useFakeClock()
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+
+	out, err := runWaitChecksCommand()
+	assert.EqualError(t, err, "checks did not pass for 1 PRs")
+	assert.Contains(t, out, "org/repo1: checks failed (https://github.com/org/repo1/pull/1)")
+	assert.Contains(t, out, "1 passed, 0 skipped, 1 failed, 0 timed out, 0 errored")
+}
+
+func TestItFailsIfChecksAreStillRunningAtTheTimeout(t *testing.T) {
+	fakeGitHub := github.NewFakeGitHub(nil, func(workingDir string) (interface{}, error) {
+		return &github.PrStatus{StatusCheckRollup: []github.StatusCheckRollup{{State: "PENDING"}}}, nil
+	})
+	gh = fakeGitHub
+	slept := useFakeClock()
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runWaitChecksCommand("--timeout", "2m")
+	assert.EqualError(t, err, "checks did not pass for 1 PRs")
+	assert.Contains(t, out, "Timed out waiting for checks in org/repo1")
+	assert.Contains(t, out, "1 timed out")
+	assert.Equal(t, 3, *slept)
+}
+
+func TestItWaitsForChecksToAppearOnPrsWithoutAny(t *testing.T) {
+	polls := 0
+	fakeGitHub := github.NewFakeGitHub(nil, func(workingDir string) (interface{}, error) {
+		polls++
+		if polls < 3 {
+			return &github.PrStatus{}, nil
+		}
+		return &github.PrStatus{StatusCheckRollup: []github.StatusCheckRollup{{State: "FAILURE"}}}, nil
+	})
+	gh = fakeGitHub
+	slept := useFakeClock()
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runWaitChecksCommand()
+	assert.EqualError(t, err, "checks did not pass for 1 PRs")
+	assert.Contains(t, out, "org/repo1: checks failed")
+	assert.Equal(t, 2, *slept)
+}
+
+func TestItFailsPrsThatNeverGetChecks(t *testing.T) {
+	fakeGitHub := github.NewFakeGitHub(nil, func(workingDir string) (interface{}, error) {
+		return &github.PrStatus{Url: "https://github.com/org/repo1/pull/1"}, nil
+	})
+	gh = fakeGitHub
+	slept := useFakeClock()
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runWaitChecksCommand()
+	assert.EqualError(t, err, "checks did not pass for 1 PRs")
+	assert.Contains(t, out, "org/repo1: no checks were reported after 2m0s (https://github.com/org/repo1/pull/1); use --no-checks-ok if it has none")
+	assert.Contains(t, out, "0 passed, 0 skipped, 1 failed, 0 timed out, 0 errored")
+	assert.Equal(t, 4, *slept)
+}
+
+func TestItCountsPrsWithoutChecksAsPassedWithNoChecksOk(t *testing.T) {
+	fakeGitHub := github.NewFakeGitHub(nil, func(workingDir string) (interface{}, error) {
+		return &github.PrStatus{}, nil
+	})
+	gh = fakeGitHub
+	useFakeClock()
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runWaitChecksCommand("--no-checks-ok", "--no-checks-grace", "1m")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "org/repo1: no checks")
+	assert.Contains(t, out, "turbolift update-prs completed (1 passed, 0 skipped)")
+}
+
+func TestItDoesNotAllowWaitChecksWithOtherActions(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runWaitChecksCommand("--close")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "update-prs needs one and only one action flag")
+
+	fakeGitHub.AssertCalledWith(t, [][]string{})
+}
+
+// useFakeClock makes sleep advance the clock seen by the command instead of blocking, and counts the sleeps
+func useFakeClock() *int {
+	current := time.Date(2021, 10, 1, 9, 0, 0, 0, time.UTC)
+	slept := 0
+	now = func() time.Time { return current }
+	sleep = func(_ context.Context, d time.Duration) {
+		current = current.Add(d)
+		slept++
+	}
+	return &slept
+}
+
+func runWaitChecksCommand(args ...string) (string, error) {
+	cmd := NewUpdatePRsCmd()
+	outBuffer := bytes.NewBufferString("")
+	cmd.SetOut(outBuffer)
+	cmd.SetArgs(append([]string{"--wait-checks"}, args...))
+	err := cmd.Execute()
+	return outBuffer.String(), err
+}
+
+func runEnableAutoMergeCommand(args ...string) (string, error) {
+	cmd := NewUpdatePRsCmd()
+	outBuffer := bytes.NewBufferString("")
+	cmd.SetOut(outBuffer)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return outBuffer.String(), err
+}
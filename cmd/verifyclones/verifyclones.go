@@ -0,0 +1,213 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package verifyclones
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/skyscanner/turbolift/cmd/flags"
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/colors"
+	"github.com/skyscanner/turbolift/internal/git"
+	"github.com/skyscanner/turbolift/internal/interrupt"
+	"github.com/skyscanner/turbolift/internal/logging"
+	"github.com/skyscanner/turbolift/internal/messages"
+	"github.com/skyscanner/turbolift/internal/summary"
+)
+
+var g git.Git = git.NewRealGit()
+
+var (
+	repoFile   string
+	branchName string
+	repair     bool
+)
+
+func NewVerifyClonesCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "verify-clones",
+		Short: "Check that each working copy is in a fit state for the campaign",
+		Long: `Check that each working copy is in a fit state for the campaign.
+
+For each repo, this checks that the working copy is on the campaign branch, that its remotes point at
+the repo (with an upstream remote for forks), and that git's object store is intact. Run it before
+bulk operations such as foreach, commit or create-prs, to find working copies that have drifted.
+
+With --repair, working copies on the wrong branch are switched back to the campaign branch. Other
+problems are only reported, as turbolift clean --reclone is the way to fix them.`,
+		Run: run,
+	}
+
+	cmd.Flags().StringVar(&repoFile, "repos", "repos.txt", "A file containing a list of repositories to verify.")
+	cmd.Flags().StringVar(&branchName, "branch", "", "The branch to use for the campaign (defaults to branch in turbolift.yaml, or the campaign name)")
+	cmd.Flags().BoolVar(&repair, "repair", false, "Switch working copies that are on the wrong branch back to the campaign branch")
+
+	return cmd
+}
+
+func run(c *cobra.Command, _ []string) {
+	ctx := c.Context()
+	logger := logging.NewLogger(c)
+
+	if repair {
+		if err := flags.CheckReadOnly("verify-clones --repair"); err != nil {
+			logger.Errorf("%s", err)
+			return
+		}
+	}
+
+	readCampaignActivity := logger.StartActivity("Reading campaign data (%s)", repoFile)
+	options := campaign.NewCampaignOptions()
+	options.RepoFilename = repoFile
+	options.BranchName = branchName
+	dir, err := campaign.OpenCampaign(options)
+	if err != nil {
+		readCampaignActivity.EndWithFailure(err)
+		return
+	}
+	readCampaignActivity.EndWithSuccess()
+
+	state, err := campaign.ReadState()
+	if err != nil {
+		logger.Errorf("%s", err)
+		return
+	}
+
+	sum := summary.New("verify-clones")
+	// repaired repos are done, but are reported apart from those that were fine already
+	repairedCount := 0
+	for i, repo := range dir.Repos {
+		if interrupt.Requested(ctx) {
+			logger.Warnf("Interrupted, so not verifying the remaining %d repos", len(dir.Repos)-i)
+			break
+		}
+		repoDirPath := repo.FullRepoPath()
+		verifyActivity := logger.StartActivity("Verifying %s", repo.FullRepoName)
+
+		// skip if the working copy does not exist
+		if _, err = os.Stat(repoDirPath); os.IsNotExist(err) {
+			verifyActivity.EndWithWarningf("Directory %s does not exist - has it been cloned?", repoDirPath)
+			sum.RecordSkipped(repo, "not cloned")
+			continue
+		}
+
+		// a corrupted working copy cannot be trusted to answer the other checks
+		if err := g.Verify(ctx, verifyActivity.Writer(), repoDirPath); err != nil {
+			verifyActivity.EndWithFailuref("The working copy is corrupted, so use turbolift clean --reclone to clone it again: %s", err)
+			sum.RecordErrored(repo, err)
+			continue
+		}
+
+		problems := checkRemotes(ctx, verifyActivity.Writer(), repoDirPath, repo, oldNamesOf(state, repo))
+
+		repaired := false
+		onBranch, err := g.IsOnBranch(ctx, verifyActivity.Writer(), repoDirPath, dir.BranchName)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("unable to determine the current branch: %s", err))
+		} else if !onBranch && !repair {
+			problems = append(problems, fmt.Sprintf("not on branch %s, so use --repair to switch to it", dir.BranchName))
+		} else if !onBranch {
+			if err := g.SwitchBranch(ctx, verifyActivity.Writer(), repoDirPath, dir.BranchName); err != nil {
+				problems = append(problems, fmt.Sprintf("unable to switch to branch %s: %s", dir.BranchName, err))
+			} else {
+				repaired = true
+			}
+		}
+
+		if len(problems) > 0 {
+			verifyActivity.EndWithFailure(strings.Join(problems, "; "))
+			sum.RecordErrored(repo, errors.New(strings.Join(problems, "; ")))
+		} else if repaired {
+			verifyActivity.EndWithWarningf("Switched back to branch %s", dir.BranchName)
+			sum.RecordDone(repo)
+			repairedCount++
+		} else {
+			verifyActivity.EndWithSuccess()
+			sum.RecordDone(repo)
+		}
+	}
+
+	sum.Finish(ctx)
+	if interrupt.Requested(ctx) {
+		logger.Warnf("%s %s(%s, %s, %s, %s)\n", dir.Messages.Format(messages.SummaryInterrupted, messages.Fields{"Command": "verify-clones"}), colors.Normal(), colors.Green(sum.Done-repairedCount, " OK"), colors.Yellow(repairedCount, " repaired"), colors.Yellow(sum.Skipped, " skipped"), colors.Red(sum.Errored, " with problems"))
+	} else if sum.Errored == 0 {
+		logger.Successf("%s %s(%s, %s, %s)\n", dir.Messages.Format(messages.SummaryCompleted, messages.Fields{"Command": "verify-clones"}), colors.Normal(), colors.Green(sum.Done-repairedCount, " OK"), colors.Yellow(repairedCount, " repaired"), colors.Yellow(sum.Skipped, " skipped"))
+	} else {
+		logger.Warnf("turbolift verify-clones completed with %s %s(%s, %s, %s, %s)\n", colors.Red("problems"), colors.Normal(), colors.Green(sum.Done-repairedCount, " OK"), colors.Yellow(repairedCount, " repaired"), colors.Yellow(sum.Skipped, " skipped"), colors.Red(sum.Errored, " with problems"))
+	}
+}
+
+// checkRemotes checks that the working copy's remotes point at the repo: upstream for forks, and otherwise origin.
+// Repos that have been renamed may still point at one of their old names, which GitHub redirects.
+func checkRemotes(ctx context.Context, output io.Writer, repoDirPath string, repo campaign.Repo, oldNames []string) []string {
+	var problems []string
+
+	originUrl, err := g.RemoteUrl(ctx, output, repoDirPath, "origin")
+	if err != nil {
+		return []string{fmt.Sprintf("unable to find remote origin: %s", err)}
+	}
+
+	isFork, err := g.HasRemote(ctx, output, repoDirPath, "upstream")
+	if err != nil {
+		return []string{fmt.Sprintf("unable to list remotes: %s", err)}
+	}
+
+	remote, url := "origin", originUrl
+	if isFork {
+		remote = "upstream"
+		url, err = g.RemoteUrl(ctx, output, repoDirPath, "upstream")
+		if err != nil {
+			return []string{fmt.Sprintf("unable to find remote upstream: %s", err)}
+		}
+	}
+
+	if !urlPointsAtAny(url, append([]string{repo.FullRepoName}, oldNames...)) {
+		problems = append(problems, fmt.Sprintf("remote %s points at %s rather than %s", remote, url, repo.FullRepoName))
+	}
+	return problems
+}
+
+// oldNamesOf lists the names that a repo had before it was renamed during the campaign
+func oldNamesOf(state *campaign.State, repo campaign.Repo) []string {
+	var names []string
+	for _, rename := range state.Renames {
+		if rename.To == repo.FullRepoName {
+			names = append(names, rename.From)
+		}
+	}
+	return names
+}
+
+// urlPointsAtAny is true if a remote URL, in https or ssh form, is for one of the repos given as org/repo or
+// host/org/repo
+func urlPointsAtAny(url string, fullRepoNames []string) bool {
+	url = strings.TrimSuffix(strings.TrimSuffix(strings.ToLower(url), "/"), ".git")
+	for _, name := range fullRepoNames {
+		parts := strings.Split(strings.ToLower(name), "/")
+		orgAndRepo := strings.Join(parts[len(parts)-2:], "/")
+		if strings.HasSuffix(url, "/"+orgAndRepo) || strings.HasSuffix(url, ":"+orgAndRepo) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,168 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package verifyclones
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/git"
+	"github.com/skyscanner/turbolift/internal/testsupport"
+)
+
+func init() {
+	// disable output colouring so that strings we want to do 'Contains' checks on do not have ANSI escape sequences in IDEs
+	_ = os.Setenv("NO_COLOR", "1")
+}
+
+func TestItVerifiesEachWorkingCopy(t *testing.T) {
+	fakeGit := git.NewFakeGit(func(_ io.Writer, call []string) (bool, error) {
+		if call[0] == "hasRemote" {
+			return call[1] == "work/org/forked", nil
+		}
+		return true, nil
+	})
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(true, "org/forked", "org/owned")
+	testsupport.CreateAnotherRepoFile("repos.txt", "org/forked", "org/owned", "org/missing")
+
+	out, err := runCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Directory work/org/missing does not exist")
+	assert.Contains(t, out, "turbolift verify-clones completed (2 OK, 0 repaired, 1 skipped)")
+
+	fakeGit.AssertCalledWith(t, [][]string{
+		{"verify", "work/org/forked"},
+		{"remoteUrl", "work/org/forked", "origin"},
+		{"hasRemote", "work/org/forked", "upstream"},
+		{"remoteUrl", "work/org/forked", "upstream"},
+		{"isOnBranch", "work/org/forked", testsupport.Pwd()},
+		{"verify", "work/org/owned"},
+		{"remoteUrl", "work/org/owned", "origin"},
+		{"hasRemote", "work/org/owned", "upstream"},
+		{"isOnBranch", "work/org/owned", testsupport.Pwd()},
+	})
+}
+
+func TestItFlagsWorkingCopiesOnTheWrongBranch(t *testing.T) {
+	fakeGit := git.NewFakeGit(func(_ io.Writer, call []string) (bool, error) {
+		return call[0] != "hasRemote" && call[0] != "isOnBranch", nil
+	})
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "not on branch "+testsupport.Pwd()+", so use --repair to switch to it")
+	assert.Contains(t, out, "turbolift verify-clones completed with problems (0 OK, 0 repaired, 0 skipped, 1 with problems)")
+}
+
+func TestItRepairsWorkingCopiesOnTheWrongBranch(t *testing.T) {
+	fakeGit := git.NewFakeGit(func(_ io.Writer, call []string) (bool, error) {
+		return call[0] != "hasRemote" && call[0] != "isOnBranch", nil
+	})
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runCommand("--repair")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Switched back to branch "+testsupport.Pwd())
+	assert.Contains(t, out, "turbolift verify-clones completed (0 OK, 1 repaired, 0 skipped)")
+
+	fakeGit.AssertCalledWith(t, [][]string{
+		{"verify", "work/org/repo1"},
+		{"remoteUrl", "work/org/repo1", "origin"},
+		{"hasRemote", "work/org/repo1", "upstream"},
+		{"isOnBranch", "work/org/repo1", testsupport.Pwd()},
+		{"switchBranch", "work/org/repo1", testsupport.Pwd()},
+	})
+}
+
+func TestItFlagsCorruptedWorkingCopies(t *testing.T) {
+	fakeGit := git.NewFakeGit(func(_ io.Writer, call []string) (bool, error) {
+		if call[0] == "verify" {
+			return false, errors.New("missing blob 1234")
+		}
+		return true, nil
+	})
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "The working copy is corrupted, so use turbolift clean --reclone to clone it again: missing blob 1234")
+	assert.Contains(t, out, "1 with problems")
+
+	fakeGit.AssertCalledWith(t, [][]string{
+		{"verify", "work/org/repo1"},
+	})
+}
+
+func TestItFlagsRemotesThatPointElsewhere(t *testing.T) {
+	g = git.NewFakeGit(func(_ io.Writer, call []string) (bool, error) {
+		return call[0] != "hasRemote", nil
+	}).WithRemoteUrls(map[string]string{"origin": "git@github.com:other/repo1.git"})
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+
+	out, err := runCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "remote origin points at git@github.com:other/repo1.git rather than org/repo1")
+	assert.Contains(t, out, "1 with problems")
+}
+
+func TestItAcceptsRemotesUnderTheOldNameOfARenamedRepo(t *testing.T) {
+	g = git.NewFakeGit(func(_ io.Writer, call []string) (bool, error) {
+		return call[0] != "hasRemote", nil
+	}).WithRemoteUrls(map[string]string{"origin": "git@github.com:org/repo1.git"})
+
+	testsupport.PrepareTempCampaign(true, "neworg/renamed1")
+	state, _ := campaign.ReadState()
+	state.RecordRename(campaign.Rename{From: "org/repo1", To: "neworg/renamed1", Noticed: time.Now()})
+	_ = state.Save()
+
+	out, err := runCommand()
+	assert.NoError(t, err)
+	assert.Contains(t, out, "turbolift verify-clones completed (1 OK, 0 repaired, 0 skipped)")
+}
+
+func TestItMatchesRemoteUrls(t *testing.T) {
+	assert.True(t, urlPointsAtAny("https://github.com/org/repo1.git", []string{"org/repo1"}))
+	assert.True(t, urlPointsAtAny("git@github.example.com:Org/Repo1.git", []string{"github.example.com/org/repo1"}))
+	assert.True(t, urlPointsAtAny("ssh://git@bitbucket.example.com:7999/proj/repo1.git", []string{"bitbucket.example.com/proj/repo1"}))
+	assert.False(t, urlPointsAtAny("https://github.com/org/repo10.git", []string{"org/repo1"}))
+	assert.False(t, urlPointsAtAny("https://github.com/myorg/repo1.git", []string{"org/repo1"}))
+}
+
+func runCommand(args ...string) (string, error) {
+	cmd := NewVerifyClonesCmd()
+	outBuffer := bytes.NewBufferString("")
+	cmd.SetOut(outBuffer)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return outBuffer.String(), err
+}
@@ -10,6 +10,8 @@ require (
 	github.com/mattn/go-isatty v0.0.13 // indirect
 	github.com/rodaine/table v1.0.1
 	github.com/spf13/cobra v1.1.3
+	github.com/spf13/pflag v1.0.5
 	github.com/stretchr/testify v1.7.0
-	golang.org/x/sys v0.1.0 // indirect
+	golang.org/x/sys v0.1.0
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
 )
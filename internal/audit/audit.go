@@ -0,0 +1,246 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package audit keeps a record of every external command that turbolift runs for a campaign, so that mass changes
+// made with it can be reviewed afterwards. Each command is written as one JSON object per line to audit.ndjson in the
+// campaign's state directory, and its complete output is kept in a file of its own that the record refers to.
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/logging"
+)
+
+// Filename is the name of the audit log within the campaign's state directory
+const Filename = "audit.ndjson"
+
+// outputDirectory holds the output of each command, in a directory for each run of turbolift
+const outputDirectory = "audit"
+
+// Record is one external command that turbolift ran
+type Record struct {
+	// Run identifies the run of turbolift that the command was part of, and Invocation is how turbolift was run
+	Run        string    `json:"run"`
+	Invocation []string  `json:"invocation"`
+	Started    time.Time `json:"started"`
+	DurationMs int64     `json:"durationMs"`
+	WorkingDir string    `json:"workingDir"`
+	Command    string    `json:"command"`
+	Args       []string  `json:"args"`
+	// ExitCode is -1 if the command did not exit by itself, e.g. because it could not be started or was stopped
+	ExitCode int    `json:"exitCode"`
+	Error    string `json:"error,omitempty"`
+	// Output is the file holding everything the command wrote to stdout and stderr, relative to the campaign
+	// directory, with any secrets redacted. It is empty if the output was withheld because it holds secrets.
+	Output      string `json:"output,omitempty"`
+	OutputBytes int64  `json:"outputBytes"`
+	// OutputSha256 is the hash of the output file, so that it can be checked that the file has not been changed since
+	OutputSha256 string `json:"outputSha256,omitempty"`
+}
+
+// Log writes the audit records of a run of turbolift
+type Log struct {
+	mu          sync.Mutex
+	file        *os.File
+	campaignDir string
+	run         string
+	invocation  []string
+	commands    int
+}
+
+// Open starts recording a run of turbolift, invoked with the given arguments, in the campaign in the given directory
+func Open(campaignDir string, invocation []string) (*Log, error) {
+	stateDir := filepath.Join(campaignDir, campaign.StateDirectory)
+	if err := os.MkdirAll(stateDir, os.ModeDir|0o755); err != nil {
+		return nil, fmt.Errorf("unable to create %s: %w", stateDir, err)
+	}
+	filename := filepath.Join(stateDir, Filename)
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open audit log %s: %w", filename, err)
+	}
+	return &Log{
+		file:        file,
+		campaignDir: campaignDir,
+		run:         fmt.Sprintf("%s-%d", time.Now().UTC().Format("20060102T150405Z"), os.Getpid()),
+		invocation:  logging.RedactAll(invocation),
+	}, nil
+}
+
+// Close stops recording
+func (l *Log) Close() error {
+	return l.file.Close()
+}
+
+// Command is a command that has been started, whose record is written when it finishes
+type Command struct {
+	log    *Log
+	record Record
+	output *os.File
+	hash   hash.Hash
+	mu     sync.Mutex
+	// partial is output that has yet to be written, as secrets are redacted a line at a time so that one split across
+	// writes is still found
+	partial []byte
+}
+
+// Start records that a command is about to be run. Everything it outputs must be written to the command's Output.
+// The output of commands started with a context from WithoutOutput is counted, but not kept.
+func (l *Log) Start(ctx context.Context, workingDir string, name string, args []string) (*Command, error) {
+	l.mu.Lock()
+	l.commands++
+	number := l.commands
+	l.mu.Unlock()
+
+	command := &Command{
+		log: l,
+		record: Record{
+			Run:        l.run,
+			Invocation: l.invocation,
+			Started:    time.Now().UTC(),
+			WorkingDir: workingDir,
+			Command:    name,
+			Args:       logging.RedactAll(args),
+		},
+		hash: sha256.New(),
+	}
+	if withheld, _ := ctx.Value(withoutOutputKey{}).(bool); withheld {
+		return command, nil
+	}
+
+	outputFile := filepath.Join(campaign.StateDirectory, outputDirectory, l.run, strconv.Itoa(number)+".log")
+	path := filepath.Join(l.campaignDir, outputFile)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModeDir|0o755); err != nil {
+		return nil, fmt.Errorf("unable to create %s: %w", filepath.Dir(path), err)
+	}
+	output, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create audit output file: %w", err)
+	}
+	command.output = output
+	command.record.Output = filepath.ToSlash(outputFile)
+	return command, nil
+}
+
+// Output is where the command's stdout and stderr should also be written. It is safe to write to from several
+// goroutines.
+func (c *Command) Output() io.Writer {
+	return c
+}
+
+func (c *Command) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.record.OutputBytes += int64(len(p))
+	if c.output == nil {
+		return len(p), nil
+	}
+	c.partial = append(c.partial, p...)
+	i := bytes.LastIndexByte(c.partial, '\n')
+	if i < 0 {
+		return len(p), nil
+	}
+	lines := c.partial[:i+1]
+	c.partial = append([]byte(nil), c.partial[i+1:]...)
+	if err := c.writeOutput(lines); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *Command) writeOutput(output []byte) error {
+	redacted := []byte(logging.Redact(string(output)))
+	c.hash.Write(redacted)
+	_, err := c.output.Write(redacted)
+	return err
+}
+
+// Finish writes the command's record to the log, given the exit code and error it finished with
+func (c *Command) Finish(exitCode int, err error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.record.DurationMs = time.Since(c.record.Started).Milliseconds()
+	c.record.ExitCode = exitCode
+	if err != nil {
+		c.record.Error = logging.Redact(err.Error())
+	}
+	if c.output != nil {
+		if len(c.partial) > 0 {
+			if writeErr := c.writeOutput(c.partial); writeErr != nil {
+				_ = c.output.Close()
+				return fmt.Errorf("unable to write audit output file: %w", writeErr)
+			}
+		}
+		c.record.OutputSha256 = hex.EncodeToString(c.hash.Sum(nil))
+		if closeErr := c.output.Close(); closeErr != nil {
+			return fmt.Errorf("unable to write audit output file: %w", closeErr)
+		}
+	}
+	return c.log.write(c.record)
+}
+
+func (l *Log) write(record Record) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("unable to write to audit log: %w", err)
+	}
+	return nil
+}
+
+type withoutOutputKey struct{}
+
+// WithoutOutput marks the commands run with the returned context as printing secrets, such as tokens, so that their
+// output is not kept in the audit log
+func WithoutOutput(ctx context.Context) context.Context {
+	return context.WithValue(ctx, withoutOutputKey{}, true)
+}
+
+var (
+	activeMu sync.Mutex
+	active   *Log
+)
+
+// SetActive makes the log record every command run from now on, or stops recording if it is nil
+func SetActive(l *Log) {
+	activeMu.Lock()
+	defer activeMu.Unlock()
+	active = l
+}
+
+// Active returns the log that commands are being recorded in, or nil if they are not being recorded
+func Active() *Log {
+	activeMu.Lock()
+	defer activeMu.Unlock()
+	return active
+}
@@ -0,0 +1,141 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+)
+
+func TestItRecordsCommandsAndTheirOutput(t *testing.T) {
+	dir := t.TempDir()
+	log, err := Open(dir, []string{"foreach", "--", "make"})
+	assert.NoError(t, err)
+
+	command, err := log.Start(context.Background(), "work/org/repo1", "make", []string{"lint"})
+	assert.NoError(t, err)
+	_, _ = command.Output().Write([]byte("linting\n"))
+	assert.NoError(t, command.Finish(2, errors.New("exit status 2")))
+
+	command, err = log.Start(context.Background(), "work/org/repo2", "make", []string{"lint"})
+	assert.NoError(t, err)
+	assert.NoError(t, command.Finish(0, nil))
+	assert.NoError(t, log.Close())
+
+	records := readRecords(t, dir)
+	assert.Len(t, records, 2)
+
+	first := records[0]
+	assert.Equal(t, []string{"foreach", "--", "make"}, first.Invocation)
+	assert.Equal(t, "work/org/repo1", first.WorkingDir)
+	assert.Equal(t, "make", first.Command)
+	assert.Equal(t, []string{"lint"}, first.Args)
+	assert.Equal(t, 2, first.ExitCode)
+	assert.Equal(t, "exit status 2", first.Error)
+	assert.Equal(t, int64(8), first.OutputBytes)
+	assert.Equal(t, "15db191ad54c646072eec5e494658c9b48a15fb053cc04ca6cb0d0e617a5d469", first.OutputSha256)
+	output, err := os.ReadFile(filepath.Join(dir, first.Output))
+	assert.NoError(t, err)
+	assert.Equal(t, "linting\n", string(output))
+
+	second := records[1]
+	assert.Equal(t, first.Run, second.Run)
+	assert.NotEqual(t, first.Output, second.Output)
+	assert.Equal(t, 0, second.ExitCode)
+	assert.Empty(t, second.Error)
+}
+
+func TestItWithholdsSecretOutput(t *testing.T) {
+	dir := t.TempDir()
+	log, err := Open(dir, []string{"clone"})
+	assert.NoError(t, err)
+
+	command, err := log.Start(WithoutOutput(context.Background()), ".", "gh", []string{"auth", "token"})
+	assert.NoError(t, err)
+	_, _ = command.Output().Write([]byte("ghp_secret\n"))
+	assert.NoError(t, command.Finish(0, nil))
+	assert.NoError(t, log.Close())
+
+	records := readRecords(t, dir)
+	assert.Len(t, records, 1)
+	assert.Empty(t, records[0].Output)
+	assert.Empty(t, records[0].OutputSha256)
+	assert.Equal(t, int64(11), records[0].OutputBytes)
+
+	entries, err := os.ReadDir(filepath.Join(dir, campaign.StateDirectory))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1, "no output files should be written")
+}
+
+func TestItRedactsSecretsInCommandsAndTheirOutput(t *testing.T) {
+	dir := t.TempDir()
+	log, err := Open(dir, []string{"foreach", "--", "curl", "-H", "Authorization: Bearer s3cr3t"})
+	assert.NoError(t, err)
+
+	command, err := log.Start(context.Background(), "work/org/repo1", "curl", []string{"-H", "Authorization: Bearer s3cr3t"})
+	assert.NoError(t, err)
+	// a token split across writes is still found
+	_, _ = command.Output().Write([]byte("using ghp_abcdefghijklmnopq"))
+	_, _ = command.Output().Write([]byte("rstuvwxyz0123456789\nno newline at the end"))
+	assert.NoError(t, command.Finish(0, nil))
+	assert.NoError(t, log.Close())
+
+	records := readRecords(t, dir)
+	assert.Len(t, records, 1)
+	assert.Equal(t, []string{"foreach", "--", "curl", "-H", "Authorization: Bearer [REDACTED]"}, records[0].Invocation)
+	assert.Equal(t, []string{"-H", "Authorization: Bearer [REDACTED]"}, records[0].Args)
+	output, err := os.ReadFile(filepath.Join(dir, records[0].Output))
+	assert.NoError(t, err)
+	assert.Equal(t, "using [REDACTED]\nno newline at the end", string(output))
+}
+
+func TestItAppendsToTheLogAcrossRuns(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 2; i++ {
+		log, err := Open(dir, []string{"commit"})
+		assert.NoError(t, err)
+		command, err := log.Start(context.Background(), ".", "git", []string{"status"})
+		assert.NoError(t, err)
+		assert.NoError(t, command.Finish(0, nil))
+		assert.NoError(t, log.Close())
+	}
+
+	assert.Len(t, readRecords(t, dir), 2)
+}
+
+func readRecords(t *testing.T, dir string) []Record {
+	file, err := os.Open(filepath.Join(dir, campaign.StateDirectory, Filename))
+	assert.NoError(t, err)
+	defer func() { _ = file.Close() }()
+
+	records := []Record{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record Record
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+	return records
+}
@@ -0,0 +1,629 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package bitbucket implements the GitHub interface, which turbolift uses for everything it asks of a forge, on
+// Bitbucket Server and Data Center using their REST API.
+package bitbucket
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/skyscanner/turbolift/internal/executor"
+	"github.com/skyscanner/turbolift/internal/github"
+)
+
+var execInstance executor.Executor = executor.NewRetryingExecutor(executor.NewRealExecutor())
+
+// requestTimeout is how long a call to the Bitbucket API may take, so that a server that stops responding cannot hold
+// up the run
+const requestTimeout = 30 * time.Second
+
+// TokenVariable is the environment variable holding the HTTP access token sent to Bitbucket, if any
+const TokenVariable = "TURBOLIFT_BITBUCKET_TOKEN"
+
+// UnsupportedError is returned for the operations that turbolift only supports on GitHub
+type UnsupportedError struct {
+	Operation string
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("%s is not supported on Bitbucket", e.Operation)
+}
+
+// BitbucketServer talks to any number of Bitbucket hosts. Repos are given as host/PROJECT/repo and are cloned over
+// HTTP(S) into work/PROJECT/repo, and the server that a working copy belongs to is found from its origin remote.
+type BitbucketServer struct {
+	Client *http.Client
+	// usage is never added to, as only GitHub API usage is tallied
+	usage github.ApiUsage
+	github.KnownPRs
+}
+
+func NewBitbucketServer() *BitbucketServer {
+	return &BitbucketServer{Client: &http.Client{Timeout: requestTimeout}}
+}
+
+// location identifies a repo by the base URL of its server, its project key and its slug
+type location struct {
+	BaseUrl string
+	Project string
+	Slug    string
+}
+
+func (l location) api(path string) string {
+	return fmt.Sprintf("%s/rest/api/1.0/projects/%s/repos/%s%s", l.BaseUrl, url.PathEscape(l.Project), url.PathEscape(l.Slug), path)
+}
+
+func (l location) ref() refRepository {
+	return refRepository{Slug: l.Slug, Project: refProject{Key: l.Project}}
+}
+
+func locationOfRepo(fullRepoName string) (location, error) {
+	parts := strings.Split(fullRepoName, "/")
+	if len(parts) != 3 {
+		return location{}, fmt.Errorf("bitbucket repo %s must be given as host/project/repo", fullRepoName)
+	}
+	return location{BaseUrl: "https://" + parts[0], Project: parts[1], Slug: parts[2]}, nil
+}
+
+// locationOfWorkingCopy finds the repo that a working copy was cloned from, using the URL of its origin remote,
+// which is https://host[/context]/scm/project/repo.git, or ssh://git@host:port/project/repo.git
+func locationOfWorkingCopy(ctx context.Context, output io.Writer, workingDir string) (location, error) {
+	remote, err := execInstance.ExecuteAndCapture(ctx, output, workingDir, "git", "remote", "get-url", "origin")
+	if err != nil {
+		return location{}, err
+	}
+
+	remoteUrl, err := url.Parse(strings.TrimSpace(remote))
+	if err != nil {
+		return location{}, fmt.Errorf("unable to parse the origin remote of %s: %w", workingDir, err)
+	}
+
+	path := strings.TrimSuffix(strings.Trim(remoteUrl.Path, "/"), ".git")
+	baseUrl := "https://" + remoteUrl.Hostname()
+	if remoteUrl.Scheme == "http" || remoteUrl.Scheme == "https" {
+		index := strings.LastIndex(path, "scm/")
+		if index == -1 {
+			return location{}, fmt.Errorf("the origin remote of %s is not a Bitbucket clone url", workingDir)
+		}
+		baseUrl = remoteUrl.Scheme + "://" + remoteUrl.Host + "/" + path[:index]
+		path = path[index+len("scm/"):]
+	}
+
+	parts := strings.Split(path, "/")
+	if len(parts) != 2 {
+		return location{}, fmt.Errorf("the origin remote of %s is not a Bitbucket clone url", workingDir)
+	}
+	return location{BaseUrl: strings.TrimSuffix(baseUrl, "/"), Project: parts[0], Slug: parts[1]}, nil
+}
+
+type refProject struct {
+	Key string `json:"key"`
+}
+
+type refRepository struct {
+	Slug    string     `json:"slug"`
+	Project refProject `json:"project"`
+}
+
+type ref struct {
+	Id         string        `json:"id"`
+	DisplayId  string        `json:"displayId,omitempty"`
+	Repository refRepository `json:"repository"`
+}
+
+type link struct {
+	Href string `json:"href"`
+	Name string `json:"name"`
+}
+
+type repository struct {
+	Slug     string     `json:"slug"`
+	Project  refProject `json:"project"`
+	Archived bool       `json:"archived"`
+	Links    struct {
+		Clone []link `json:"clone"`
+	} `json:"links"`
+}
+
+type reviewer struct {
+	Status string `json:"status"`
+}
+
+type pullRequest struct {
+	Id          int        `json:"id"`
+	Version     int        `json:"version"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	State       string     `json:"state"`
+	FromRef     ref        `json:"fromRef"`
+	ToRef       ref        `json:"toRef"`
+	Reviewers   []reviewer `json:"reviewers"`
+	// CreatedDate is when the PR was raised, in milliseconds since the epoch
+	CreatedDate int64 `json:"createdDate"`
+	Links       struct {
+		Self []link `json:"self"`
+	} `json:"links"`
+}
+
+func (pr pullRequest) url() string {
+	if len(pr.Links.Self) == 0 {
+		return ""
+	}
+	return pr.Links.Self[0].Href
+}
+
+// prStatus describes the PR in the terms that turbolift uses for GitHub PRs
+func (pr pullRequest) prStatus() *github.PrStatus {
+	state := pr.State
+	if state == "DECLINED" {
+		state = "CLOSED"
+	}
+
+	reviewDecision := "REVIEW_REQUIRED"
+	for _, reviewer := range pr.Reviewers {
+		if reviewer.Status == "NEEDS_WORK" {
+			reviewDecision = "CHANGES_REQUESTED"
+			break
+		}
+		if reviewer.Status == "APPROVED" {
+			reviewDecision = "APPROVED"
+		}
+	}
+
+	return &github.PrStatus{
+		BaseRefName:    pr.ToRef.DisplayId,
+		Body:           pr.Description,
+		Closed:         state != "OPEN",
+		CreatedAt:      time.Unix(0, pr.CreatedDate*int64(time.Millisecond)),
+		HeadRefName:    pr.FromRef.DisplayId,
+		Number:         pr.Id,
+		ReviewDecision: reviewDecision,
+		State:          state,
+		Title:          pr.Title,
+		Url:            pr.url(),
+	}
+}
+
+type page struct {
+	Values     json.RawMessage `json:"values"`
+	IsLastPage bool            `json:"isLastPage"`
+	// NextPageStart is where the next page starts, if there is one
+	NextPageStart *int `json:"nextPageStart"`
+}
+
+// apiError is an error response from the REST API
+type apiError struct {
+	Status string
+	Errors []struct {
+		Message       string `json:"message"`
+		ExceptionName string `json:"exceptionName"`
+	} `json:"errors"`
+}
+
+func (e *apiError) Error() string {
+	messages := []string{}
+	for _, err := range e.Errors {
+		messages = append(messages, err.Message)
+	}
+	if len(messages) == 0 {
+		return fmt.Sprintf("bitbucket returned %s", e.Status)
+	}
+	return fmt.Sprintf("bitbucket returned %s: %s", e.Status, strings.Join(messages, "; "))
+}
+
+func (e *apiError) hasException(name string) bool {
+	for _, err := range e.Errors {
+		if strings.HasSuffix(err.ExceptionName, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// call makes a request of the REST API, decoding the response into result unless it is nil
+func (b *BitbucketServer) call(ctx context.Context, method string, url string, body interface{}, result interface{}) error {
+	var requestBody io.Reader
+	if body != nil {
+		contents, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		requestBody = bytes.NewReader(contents)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, url, requestBody)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "application/json")
+	request.Header.Set("Content-Type", "application/json")
+	// Bitbucket rejects POSTs without it as a precaution against cross-site request forgery
+	request.Header.Set("X-Atlassian-Token", "no-check")
+	if token := os.Getenv(TokenVariable); token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	response, err := b.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	if response.StatusCode >= 300 {
+		apiErr := &apiError{Status: response.Status}
+		_ = json.NewDecoder(response.Body).Decode(apiErr)
+		return apiErr
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.NewDecoder(response.Body).Decode(result); err != nil {
+		return fmt.Errorf("unable to parse bitbucket response: %w", err)
+	}
+	return nil
+}
+
+// values fetches the first page of a paged API, which is plenty for the one repo or branch that turbolift asks about
+func (b *BitbucketServer) values(ctx context.Context, url string, result interface{}) error {
+	var p page
+	if err := b.call(ctx, http.MethodGet, url, nil, &p); err != nil {
+		return err
+	}
+	if len(p.Values) == 0 {
+		return nil
+	}
+	return json.Unmarshal(p.Values, result)
+}
+
+// allValues fetches every page of a paged API, whose url already has a query, passing the values of each page to add
+func (b *BitbucketServer) allValues(ctx context.Context, url string, add func(values json.RawMessage) error) error {
+	start := 0
+	for {
+		var p page
+		if err := b.call(ctx, http.MethodGet, fmt.Sprintf("%s&start=%d", url, start), nil, &p); err != nil {
+			return err
+		}
+		if len(p.Values) > 0 {
+			if err := add(p.Values); err != nil {
+				return err
+			}
+		}
+		if p.IsLastPage || p.NextPageStart == nil {
+			return nil
+		}
+		start = *p.NextPageStart
+	}
+}
+
+func (b *BitbucketServer) Clone(ctx context.Context, output io.Writer, workingDir string, fullRepoName string, gitArgs ...string) error {
+	l, err := locationOfRepo(fullRepoName)
+	if err != nil {
+		return err
+	}
+
+	var repo repository
+	if err := b.call(ctx, http.MethodGet, l.api(""), nil, &repo); err != nil {
+		return err
+	}
+	for _, clone := range repo.Links.Clone {
+		if clone.Name == "http" {
+			args := append(append([]string{"clone"}, gitArgs...), clone.Href, l.Slug)
+			return execInstance.Execute(ctx, output, workingDir, "git", args...)
+		}
+	}
+	return fmt.Errorf("bitbucket has no http clone url for %s", fullRepoName)
+}
+
+func (b *BitbucketServer) ForkAndClone(_ context.Context, _ io.Writer, _ string, _ string, _ ...string) error {
+	return &UnsupportedError{Operation: "forking"}
+}
+
+func (b *BitbucketServer) currentBranch(ctx context.Context, output io.Writer, workingDir string) (string, error) {
+	branch, err := execInstance.ExecuteAndCapture(ctx, output, workingDir, "git", "rev-parse", "--abbrev-ref", "HEAD")
+	return strings.TrimSpace(branch), err
+}
+
+func (b *BitbucketServer) defaultBranch(ctx context.Context, l location) (string, error) {
+	var branch ref
+	if err := b.call(ctx, http.MethodGet, l.api("/branches/default"), nil, &branch); err != nil {
+		return "", err
+	}
+	return branch.DisplayId, nil
+}
+
+// CreatePullRequest opens a PR from the working copy's current branch to the PR's base branch, or the repo's default
+// branch if it has none. Bitbucket PRs have no labels, so any labels are left off.
+func (b *BitbucketServer) CreatePullRequest(ctx context.Context, output io.Writer, workingDir string, metadata github.PullRequest) (*github.PrRef, error) {
+	l, err := locationOfRepo(metadata.UpstreamRepo)
+	if err != nil {
+		return nil, err
+	}
+	branch, err := b.currentBranch(ctx, output, workingDir)
+	if err != nil {
+		return nil, err
+	}
+	baseBranch := metadata.BaseBranch
+	if baseBranch == "" {
+		baseBranch, err = b.defaultBranch(ctx, l)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	request := map[string]interface{}{
+		"title":       metadata.Title,
+		"description": metadata.Body,
+		"fromRef":     ref{Id: "refs/heads/" + branch, Repository: l.ref()},
+		"toRef":       ref{Id: "refs/heads/" + baseBranch, Repository: l.ref()},
+	}
+	if metadata.IsDraft {
+		request["draft"] = true
+	}
+
+	var created pullRequest
+	err = b.call(ctx, http.MethodPost, l.api("/pull-requests"), request, &created)
+	if apiErr, ok := err.(*apiError); ok && apiErr.hasException("EmptyPullRequestException") {
+		// no PR was created because the branch has no changes
+		return nil, nil
+	} else if apiErr, ok := err.(*apiError); ok && apiErr.hasException("DuplicatePullRequestException") {
+		return nil, &github.PrExistsError{Repo: metadata.UpstreamRepo}
+	} else if err != nil {
+		return nil, err
+	}
+	_, _ = fmt.Fprintln(output, created.url())
+	return &github.PrRef{Number: created.Id, Url: created.url()}, nil
+}
+
+// findPR finds the latest PR from the branch of the working copy's repo, or the PR remembered for the branch
+func (b *BitbucketServer) findPR(ctx context.Context, output io.Writer, workingDir string, branchName string) (location, *pullRequest, error) {
+	l, err := locationOfWorkingCopy(ctx, output, workingDir)
+	if err != nil {
+		return location{}, nil, err
+	}
+
+	if number, ok := b.KnownPR(workingDir, branchName); ok {
+		var pr pullRequest
+		if err := b.call(ctx, http.MethodGet, l.api(fmt.Sprintf("/pull-requests/%d", number)), nil, &pr); err != nil {
+			return location{}, nil, err
+		}
+		return l, &pr, nil
+	}
+
+	query := url.Values{"direction": {"OUTGOING"}, "state": {"ALL"}, "at": {"refs/heads/" + branchName}}
+	var prs []pullRequest
+	if err := b.values(ctx, l.api("/pull-requests?"+query.Encode()), &prs); err != nil {
+		return location{}, nil, err
+	}
+	if len(prs) == 0 {
+		return location{}, nil, &github.NoPRFoundError{Path: workingDir, BranchName: branchName}
+	}
+	return l, &prs[0], nil
+}
+
+func (b *BitbucketServer) ClosePullRequest(ctx context.Context, output io.Writer, workingDir string, branchName string) error {
+	l, pr, err := b.findPR(ctx, output, workingDir, branchName)
+	if err != nil {
+		return err
+	}
+	if pr.State != "OPEN" {
+		return &github.PrNotOpenError{Path: workingDir, State: pr.prStatus().State}
+	}
+	if archived, err := b.isArchived(ctx, l); err != nil {
+		return err
+	} else if archived {
+		return &github.RepoArchivedError{Repo: l.Project + "/" + l.Slug}
+	}
+	return b.call(ctx, http.MethodPost, l.api(fmt.Sprintf("/pull-requests/%d/decline?version=%d", pr.Id, pr.Version)), map[string]interface{}{}, nil)
+}
+
+func (b *BitbucketServer) ReopenPullRequest(ctx context.Context, output io.Writer, workingDir string, branchName string) error {
+	l, pr, err := b.findPR(ctx, output, workingDir, branchName)
+	if err != nil {
+		return err
+	}
+	if pr.State != "DECLINED" {
+		return &github.PrNotClosedError{Path: workingDir, State: pr.State}
+	}
+	if archived, err := b.isArchived(ctx, l); err != nil {
+		return err
+	} else if archived {
+		return &github.RepoArchivedError{Repo: l.Project + "/" + l.Slug}
+	}
+	return b.call(ctx, http.MethodPost, l.api(fmt.Sprintf("/pull-requests/%d/reopen?version=%d", pr.Id, pr.Version)), map[string]interface{}{}, nil)
+}
+
+// IsArchived is true if the working copy's repo has been archived, and so is read-only
+func (b *BitbucketServer) IsArchived(ctx context.Context, output io.Writer, workingDir string) (bool, error) {
+	l, err := locationOfWorkingCopy(ctx, output, workingDir)
+	if err != nil {
+		return false, err
+	}
+	return b.isArchived(ctx, l)
+}
+
+func (b *BitbucketServer) isArchived(ctx context.Context, l location) (bool, error) {
+	var repo repository
+	if err := b.call(ctx, http.MethodGet, l.api(""), nil, &repo); err != nil {
+		return false, err
+	}
+	return repo.Archived, nil
+}
+
+func (b *BitbucketServer) EnableAutoMerge(_ context.Context, _ io.Writer, _ string, _ string, _ string) error {
+	return &UnsupportedError{Operation: "auto-merge"}
+}
+
+func (b *BitbucketServer) UpdatePRBranch(_ context.Context, _ io.Writer, _ string, _ string, _ bool) error {
+	return &UnsupportedError{Operation: "updating PR branches"}
+}
+
+func (b *BitbucketServer) AddPRLabels(_ context.Context, _ io.Writer, _ string, _ string, _ []string) error {
+	return &UnsupportedError{Operation: "labelling PRs"}
+}
+
+func (b *BitbucketServer) RemovePRLabels(_ context.Context, _ io.Writer, _ string, _ string, _ []string) error {
+	return &UnsupportedError{Operation: "labelling PRs"}
+}
+
+func (b *BitbucketServer) CommentOnPR(ctx context.Context, output io.Writer, workingDir string, branchName string, body string) error {
+	l, pr, err := b.findPR(ctx, output, workingDir, branchName)
+	if err != nil {
+		return err
+	}
+	return b.call(ctx, http.MethodPost, l.api(fmt.Sprintf("/pull-requests/%d/comments", pr.Id)), map[string]interface{}{"text": body}, nil)
+}
+
+func (b *BitbucketServer) RequestReviews(_ context.Context, _ io.Writer, _ string, _ string, _ []string) error {
+	return &UnsupportedError{Operation: "re-requesting reviews"}
+}
+
+// RequiresLinearHistory is always false, as the merge strategy of Bitbucket PRs is not chosen by turbolift
+func (b *BitbucketServer) RequiresLinearHistory(_ context.Context, _ io.Writer, _ string, _ string) (bool, error) {
+	return false, nil
+}
+
+// BranchProtection reports nothing as required, as Bitbucket's merge checks are not read by turbolift
+func (b *BitbucketServer) BranchProtection(_ context.Context, _ io.Writer, _ string, _ string) (*github.BranchProtection, error) {
+	return &github.BranchProtection{}, nil
+}
+
+func (b *BitbucketServer) UpdatePRDescription(ctx context.Context, output io.Writer, workingDir string, title string, body string) error {
+	branch, err := b.currentBranch(ctx, output, workingDir)
+	if err != nil {
+		return err
+	}
+	l, pr, err := b.findPR(ctx, output, workingDir, branch)
+	if err != nil {
+		return err
+	}
+	return b.call(ctx, http.MethodPut, l.api(fmt.Sprintf("/pull-requests/%d", pr.Id)), map[string]interface{}{
+		"version":     pr.Version,
+		"title":       title,
+		"description": body,
+	}, nil)
+}
+
+// GetPR finds the PR from the branch. Bitbucket has no equivalent of GitHub's checks, so none are reported.
+func (b *BitbucketServer) GetPR(ctx context.Context, output io.Writer, workingDir string, branchName string) (*github.PrStatus, error) {
+	_, pr, err := b.findPR(ctx, output, workingDir, branchName)
+	if err != nil {
+		return nil, err
+	}
+	return pr.prStatus(), nil
+}
+
+func (b *BitbucketServer) GetPRForBranch(ctx context.Context, output io.Writer, workingDir string, branchName string) (*github.PrStatus, error) {
+	return b.GetPR(ctx, output, workingDir, branchName)
+}
+
+func (b *BitbucketServer) GetDefaultBranchName(ctx context.Context, _ io.Writer, _ string, fullRepoName string) (string, error) {
+	l, err := locationOfRepo(fullRepoName)
+	if err != nil {
+		return "", err
+	}
+	return b.defaultBranch(ctx, l)
+}
+
+// IsPushable checks whether the user has write permission on the repo
+func (b *BitbucketServer) IsPushable(ctx context.Context, _ io.Writer, fullRepoName string) (bool, error) {
+	l, err := locationOfRepo(fullRepoName)
+	if err != nil {
+		return false, err
+	}
+
+	query := url.Values{"projectname": {l.Project}, "name": {l.Slug}, "permission": {"REPO_WRITE"}}
+	var repos []repository
+	if err := b.values(ctx, l.BaseUrl+"/rest/api/1.0/repos?"+query.Encode(), &repos); err != nil {
+		return false, err
+	}
+	for _, repo := range repos {
+		if strings.EqualFold(repo.Project.Key, l.Project) && strings.EqualFold(repo.Slug, l.Slug) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ResolveRepoName returns the name it is given, as renames are only followed on GitHub
+func (b *BitbucketServer) ResolveRepoName(_ context.Context, _ io.Writer, fullRepoName string) (string, error) {
+	return fullRepoName, nil
+}
+
+// ListOpenPRs lists the repo's open PRs, without the files that they change
+func (b *BitbucketServer) ListOpenPRs(ctx context.Context, _ io.Writer, _ string, fullRepoName string) ([]github.OpenPR, error) {
+	l, err := locationOfRepo(fullRepoName)
+	if err != nil {
+		return nil, err
+	}
+
+	var prs []pullRequest
+	if err := b.values(ctx, l.api("/pull-requests?state=OPEN&limit=100"), &prs); err != nil {
+		return nil, err
+	}
+	openPRs := []github.OpenPR{}
+	for _, pr := range prs {
+		openPRs = append(openPRs, github.OpenPR{Number: pr.Id, Url: pr.url(), Body: pr.Description, HeadRefName: pr.FromRef.DisplayId})
+	}
+	return openPRs, nil
+}
+
+// ListOrgRepos lists the repos of a project, which must be given with its host. Bitbucket repos have no languages or
+// topics, and forks are the repos with an origin.
+func (b *BitbucketServer) ListOrgRepos(ctx context.Context, _ io.Writer, host string, project string) ([]github.OrgRepo, error) {
+	if host == "" {
+		return nil, fmt.Errorf("bitbucket project %s must be given as host/project", project)
+	}
+
+	orgRepos := []github.OrgRepo{}
+	err := b.allValues(ctx, fmt.Sprintf("https://%s/rest/api/1.0/projects/%s/repos?limit=100", host, url.PathEscape(project)), func(values json.RawMessage) error {
+		var repos []struct {
+			Slug     string     `json:"slug"`
+			Project  refProject `json:"project"`
+			Archived bool       `json:"archived"`
+			Origin   *struct{}  `json:"origin"`
+		}
+		if err := json.Unmarshal(values, &repos); err != nil {
+			return err
+		}
+		for _, repo := range repos {
+			orgRepos = append(orgRepos, github.OrgRepo{NameWithOwner: repo.Project.Key + "/" + repo.Slug, IsArchived: repo.Archived, IsFork: repo.Origin != nil})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return orgRepos, nil
+}
+
+func (b *BitbucketServer) RateLimit(_ context.Context, _ io.Writer, _ string) (*github.RateLimit, error) {
+	return nil, &UnsupportedError{Operation: "checking rate limits"}
+}
+
+func (b *BitbucketServer) Usage() *github.ApiUsage {
+	return &b.usage
+}
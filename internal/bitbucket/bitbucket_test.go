@@ -0,0 +1,344 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package bitbucket
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/executor"
+	"github.com/skyscanner/turbolift/internal/github"
+	"github.com/skyscanner/turbolift/internal/testsupport"
+)
+
+const repoPath = "/rest/api/1.0/projects/PROJ/repos/repo1"
+
+func TestItFindsTheRepoOfAWorkingCopyFromItsRemote(t *testing.T) {
+	testCases := map[string]location{
+		"https://bitbucket.example.com/scm/PROJ/repo1.git":           {BaseUrl: "https://bitbucket.example.com", Project: "PROJ", Slug: "repo1"},
+		"https://example.com/bitbucket/scm/PROJ/repo1.git\n":         {BaseUrl: "https://example.com/bitbucket", Project: "PROJ", Slug: "repo1"},
+		"ssh://git@bitbucket.example.com:7999/proj/repo1.git":        {BaseUrl: "https://bitbucket.example.com", Project: "proj", Slug: "repo1"},
+		"https://user@bitbucket.example.com:8443/scm/PROJ/repo1.git": {BaseUrl: "https://bitbucket.example.com:8443", Project: "PROJ", Slug: "repo1"},
+	}
+
+	for remote, expected := range testCases {
+		execInstance = fakeRemote(remote)
+		l, err := locationOfWorkingCopy(context.Background(), &strings.Builder{}, "work/PROJ/repo1")
+		assert.NoError(t, err)
+		assert.Equal(t, expected, l)
+	}
+
+	execInstance = fakeRemote("https://github.com/org/repo1.git")
+	_, err := locationOfWorkingCopy(context.Background(), &strings.Builder{}, "work/org/repo1")
+	assert.Error(t, err)
+}
+
+func TestItClonesOverHttp(t *testing.T) {
+	server, host := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, repoPath, r.URL.Path)
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		_, _ = w.Write([]byte(`{"slug": "repo1", "links": {"clone": [
+			{"href": "ssh://git@bitbucket.example.com:7999/proj/repo1.git", "name": "ssh"},
+			{"href": "https://bitbucket.example.com/scm/proj/repo1.git", "name": "http"}
+		]}}`))
+	})
+	testsupport.Setenv(t, TokenVariable, "secret")
+	fakeExecutor := executor.NewAlwaysSucceedsFakeExecutor()
+	execInstance = fakeExecutor
+
+	err := server.Clone(context.Background(), &strings.Builder{}, "work/PROJ", host+"/PROJ/repo1")
+	assert.NoError(t, err)
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/PROJ", "git", "clone", "https://bitbucket.example.com/scm/proj/repo1.git", "repo1"},
+	})
+}
+
+func TestItCreatesPullRequestsToTheDefaultBranch(t *testing.T) {
+	var created map[string]interface{}
+	server, host := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case repoPath + "/branches/default":
+			_, _ = w.Write([]byte(`{"id": "refs/heads/main", "displayId": "main"}`))
+		case repoPath + "/pull-requests":
+			assert.Equal(t, "no-check", r.Header.Get("X-Atlassian-Token"))
+			_ = json.NewDecoder(r.Body).Decode(&created)
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"id": 1, "links": {"self": [{"href": "https://bitbucket.example.com/projects/PROJ/repos/repo1/pull-requests/1"}]}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	execInstance = executor.NewFakeExecutor(nil, func(string, string, ...string) (string, error) {
+		return "my-campaign\n", nil
+	})
+
+	output := &strings.Builder{}
+	pr, err := server.CreatePullRequest(context.Background(), output, "work/PROJ/repo1", github.PullRequest{
+		Title:        "PR title",
+		Body:         "PR body",
+		UpstreamRepo: host + "/PROJ/repo1",
+		IsDraft:      true,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, &github.PrRef{Number: 1, Url: "https://bitbucket.example.com/projects/PROJ/repos/repo1/pull-requests/1"}, pr)
+	assert.Contains(t, output.String(), "pull-requests/1")
+
+	assert.Equal(t, "PR title", created["title"])
+	assert.Equal(t, "PR body", created["description"])
+	assert.Equal(t, true, created["draft"])
+	assert.Equal(t, "refs/heads/my-campaign", created["fromRef"].(map[string]interface{})["id"])
+	assert.Equal(t, "refs/heads/main", created["toRef"].(map[string]interface{})["id"])
+}
+
+func TestItDoesNotCreateEmptyPullRequests(t *testing.T) {
+	server, host := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == repoPath+"/branches/default" {
+			_, _ = w.Write([]byte(`{"displayId": "main"}`))
+			return
+		}
+		w.WriteHeader(http.StatusConflict)
+		_, _ = w.Write([]byte(`{"errors": [{"message": "No changes", "exceptionName": "com.atlassian.bitbucket.pull.EmptyPullRequestException"}]}`))
+	})
+	execInstance = executor.NewFakeExecutor(nil, func(string, string, ...string) (string, error) {
+		return "my-campaign", nil
+	})
+
+	pr, err := server.CreatePullRequest(context.Background(), &strings.Builder{}, "work/PROJ/repo1", github.PullRequest{UpstreamRepo: host + "/PROJ/repo1"})
+	assert.NoError(t, err)
+	assert.Nil(t, pr)
+}
+
+func TestItReturnsPrExistsErrorForDuplicatePullRequests(t *testing.T) {
+	server, host := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == repoPath+"/branches/default" {
+			_, _ = w.Write([]byte(`{"displayId": "main"}`))
+			return
+		}
+		w.WriteHeader(http.StatusConflict)
+		_, _ = w.Write([]byte(`{"errors": [{"message": "Only one pull request may be open", "exceptionName": "com.atlassian.bitbucket.pull.DuplicatePullRequestException"}]}`))
+	})
+	execInstance = executor.NewFakeExecutor(nil, func(string, string, ...string) (string, error) {
+		return "my-campaign", nil
+	})
+
+	pr, err := server.CreatePullRequest(context.Background(), &strings.Builder{}, "work/PROJ/repo1", github.PullRequest{UpstreamRepo: host + "/PROJ/repo1"})
+	assert.Nil(t, pr)
+	assert.Equal(t, &github.PrExistsError{Repo: host + "/PROJ/repo1"}, err)
+}
+
+func TestItDeclinesPullRequestsToClose(t *testing.T) {
+	var declined string
+	server, host := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == repoPath+"/pull-requests":
+			assert.Equal(t, "refs/heads/my-campaign", r.URL.Query().Get("at"))
+			_, _ = w.Write([]byte(`{"values": [{"id": 7, "version": 3, "state": "OPEN"}]}`))
+		case r.Method == http.MethodGet && r.URL.Path == repoPath:
+			_, _ = w.Write([]byte(`{"slug": "repo1", "archived": false}`))
+		case r.Method == http.MethodPost:
+			declined = r.URL.Path + "?" + r.URL.RawQuery
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	execInstance = fakeRemote("https://" + host + "/scm/PROJ/repo1.git")
+
+	err := server.ClosePullRequest(context.Background(), &strings.Builder{}, "work/PROJ/repo1", "my-campaign")
+	assert.NoError(t, err)
+	assert.Equal(t, repoPath+"/pull-requests/7/decline?version=3", declined)
+}
+
+func TestItDoesNotDeclineMergedPullRequests(t *testing.T) {
+	server, host := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == repoPath+"/pull-requests":
+			_, _ = w.Write([]byte(`{"values": [{"id": 7, "version": 3, "state": "MERGED"}]}`))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	execInstance = fakeRemote("https://" + host + "/scm/PROJ/repo1.git")
+
+	err := server.ClosePullRequest(context.Background(), &strings.Builder{}, "work/PROJ/repo1", "my-campaign")
+	var notOpenErr *github.PrNotOpenError
+	assert.ErrorAs(t, err, &notOpenErr)
+	assert.Equal(t, "MERGED", notOpenErr.State)
+}
+
+func TestItDoesNotDeclinePullRequestsInArchivedRepos(t *testing.T) {
+	server, host := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == repoPath+"/pull-requests":
+			_, _ = w.Write([]byte(`{"values": [{"id": 7, "version": 3, "state": "OPEN"}]}`))
+		case r.Method == http.MethodGet && r.URL.Path == repoPath:
+			_, _ = w.Write([]byte(`{"slug": "repo1", "archived": true}`))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	execInstance = fakeRemote("https://" + host + "/scm/PROJ/repo1.git")
+
+	err := server.ClosePullRequest(context.Background(), &strings.Builder{}, "work/PROJ/repo1", "my-campaign")
+	var archivedErr *github.RepoArchivedError
+	assert.ErrorAs(t, err, &archivedErr)
+	assert.Equal(t, "PROJ/repo1", archivedErr.Repo)
+}
+
+func TestItCommentsOnPullRequests(t *testing.T) {
+	var comment map[string]interface{}
+	server, host := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == repoPath+"/pull-requests":
+			_, _ = w.Write([]byte(`{"values": [{"id": 7, "version": 3, "state": "OPEN"}]}`))
+		case r.Method == http.MethodPost && r.URL.Path == repoPath+"/pull-requests/7/comments":
+			_ = json.NewDecoder(r.Body).Decode(&comment)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	execInstance = fakeRemote("https://" + host + "/scm/PROJ/repo1.git")
+
+	err := server.CommentOnPR(context.Background(), &strings.Builder{}, "work/PROJ/repo1", "my-campaign", "Please review")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"text": "Please review"}, comment)
+}
+
+func TestItListsTheReposOfAProject(t *testing.T) {
+	server, host := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/rest/api/1.0/projects/PROJ/repos" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		// the repos come in pages, which are fetched until the last one
+		if r.URL.Query().Get("start") == "0" {
+			_, _ = w.Write([]byte(`{"values": [
+				{"slug": "repo1", "project": {"key": "PROJ"}},
+				{"slug": "repo2", "project": {"key": "PROJ"}, "archived": true}
+			], "isLastPage": false, "nextPageStart": 2}`))
+			return
+		}
+		assert.Equal(t, "2", r.URL.Query().Get("start"))
+		_, _ = w.Write([]byte(`{"values": [
+			{"slug": "repo3", "project": {"key": "PROJ"}, "origin": {"slug": "repo3", "project": {"key": "OTHER"}}}
+		], "isLastPage": true}`))
+	})
+
+	repos, err := server.ListOrgRepos(context.Background(), &strings.Builder{}, host, "PROJ")
+	assert.NoError(t, err)
+	assert.Equal(t, []github.OrgRepo{
+		{NameWithOwner: "PROJ/repo1"},
+		{NameWithOwner: "PROJ/repo2", IsArchived: true},
+		{NameWithOwner: "PROJ/repo3", IsFork: true},
+	}, repos)
+
+	_, err = server.ListOrgRepos(context.Background(), &strings.Builder{}, "", "PROJ")
+	assert.EqualError(t, err, "bitbucket project PROJ must be given as host/project")
+}
+
+func TestItReportsMissingPullRequests(t *testing.T) {
+	server, host := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"values": []}`))
+	})
+	execInstance = fakeRemote("https://" + host + "/scm/PROJ/repo1.git")
+
+	_, err := server.GetPR(context.Background(), &strings.Builder{}, "work/PROJ/repo1", "my-campaign")
+	assert.IsType(t, &github.NoPRFoundError{}, err)
+}
+
+func TestItLooksUpRememberedPullRequestsByTheirId(t *testing.T) {
+	server, host := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != repoPath+"/pull-requests/9" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"id": 9, "state": "MERGED"}`))
+	})
+	execInstance = fakeRemote("https://" + host + "/scm/PROJ/repo1.git")
+
+	server.RememberPR("work/PROJ/repo1", "my-campaign", 9)
+	pr, err := server.GetPR(context.Background(), &strings.Builder{}, "work/PROJ/repo1", "my-campaign")
+	assert.NoError(t, err)
+	assert.Equal(t, 9, pr.Number)
+	assert.Equal(t, "MERGED", pr.State)
+}
+
+func TestItDescribesPullRequestsLikeGitHubOnes(t *testing.T) {
+	pr := pullRequest{Id: 7, Title: "PR title", State: "DECLINED", Reviewers: []reviewer{{Status: "APPROVED"}, {Status: "UNAPPROVED"}}, CreatedDate: 1700000000000}
+	pr.FromRef.DisplayId = "my-campaign"
+	pr.ToRef.DisplayId = "main"
+
+	status := pr.prStatus()
+	assert.Equal(t, 7, status.Number)
+	assert.Equal(t, "CLOSED", status.State)
+	assert.True(t, status.Closed)
+	assert.Equal(t, "APPROVED", status.ReviewDecision)
+	assert.Equal(t, "my-campaign", status.HeadRefName)
+	assert.Equal(t, "main", status.BaseRefName)
+	assert.True(t, status.CreatedAt.Equal(time.Unix(1700000000, 0)))
+}
+
+func TestItChecksForWritePermission(t *testing.T) {
+	server, host := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/rest/api/1.0/repos", r.URL.Path)
+		assert.Equal(t, "REPO_WRITE", r.URL.Query().Get("permission"))
+		if r.URL.Query().Get("name") == "repo1" {
+			_, _ = w.Write([]byte(`{"values": [{"slug": "repo1", "project": {"key": "PROJ"}}]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"values": []}`))
+	})
+
+	pushable, err := server.IsPushable(context.Background(), &strings.Builder{}, host+"/PROJ/repo1")
+	assert.NoError(t, err)
+	assert.True(t, pushable)
+
+	pushable, err = server.IsPushable(context.Background(), &strings.Builder{}, host+"/PROJ/repo2")
+	assert.NoError(t, err)
+	assert.False(t, pushable)
+}
+
+func TestItRejectsOperationsOnlySupportedOnGitHub(t *testing.T) {
+	server := NewBitbucketServer()
+
+	err := server.ForkAndClone(context.Background(), &strings.Builder{}, "work/PROJ", "bitbucket.example.com/PROJ/repo1")
+	assert.EqualError(t, err, "forking is not supported on Bitbucket")
+
+	err = server.EnableAutoMerge(context.Background(), &strings.Builder{}, "work/PROJ/repo1", "my-campaign", "squash")
+	assert.EqualError(t, err, "auto-merge is not supported on Bitbucket")
+}
+
+// newServer starts a fake Bitbucket server, returning a client for it and the host to give repos
+func newServer(t *testing.T, handler http.HandlerFunc) (*BitbucketServer, string) {
+	server := httptest.NewTLSServer(handler)
+	t.Cleanup(server.Close)
+	return &BitbucketServer{Client: server.Client()}, strings.TrimPrefix(server.URL, "https://")
+}
+
+func fakeRemote(remote string) *executor.FakeExecutor {
+	return executor.NewFakeExecutor(nil, func(string, string, ...string) (string, error) {
+		return remote, nil
+	})
+}
@@ -0,0 +1,125 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package cache keeps the results of slow lookups, such as the state of a repo's PR, for a while, so that commands
+// that are run over and over, like turbolift pr-status, need not look everything up again each time. Results are
+// held in memory for the run and, when the cache has a directory, in files there for later runs.
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultTTL is how long results are kept unless told otherwise
+const DefaultTTL = 5 * time.Minute
+
+var now = time.Now
+
+type entry struct {
+	Key    string          `json:"key"`
+	Value  json.RawMessage `json:"value"`
+	Stored time.Time       `json:"stored"`
+}
+
+// Cache holds results for up to its TTL. It is safe to use from several goroutines.
+type Cache struct {
+	dir string
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]entry
+}
+
+// New creates a cache whose results expire after the TTL. Results are also kept in files in dir, unless it is empty.
+func New(dir string, ttl time.Duration) *Cache {
+	return &Cache{dir: dir, ttl: ttl, entries: map[string]entry{}}
+}
+
+// Get reads the result stored under the key into value. It returns false if there is none, or it has expired.
+func (c *Cache) Get(key string, value interface{}) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok && c.dir != "" {
+		e, ok = c.read(key)
+	}
+	if !ok || now().Sub(e.Stored) >= c.ttl {
+		return false
+	}
+	c.entries[key] = e
+	return json.Unmarshal(e.Value, value) == nil
+}
+
+// Put stores a result under the key. Results that cannot be stored are simply not cached.
+func (c *Cache) Put(key string, value interface{}) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := entry{Key: key, Value: encoded, Stored: now()}
+	c.entries[key] = e
+	if c.dir != "" {
+		c.write(e)
+	}
+}
+
+func (c *Cache) read(key string) (entry, bool) {
+	contents, err := os.ReadFile(c.filename(key))
+	if err != nil {
+		return entry{}, false
+	}
+	var e entry
+	// a file can only be for another key if two keys hash the same, but that is cheap to rule out
+	if err := json.Unmarshal(contents, &e); err != nil || e.Key != key {
+		return entry{}, false
+	}
+	return e, true
+}
+
+func (c *Cache) write(e entry) {
+	contents, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(c.dir, os.ModePerm); err != nil {
+		return
+	}
+	// writing to a temporary file first means that a run that is stopped part way cannot leave a truncated entry
+	filename := c.filename(e.Key)
+	if err := os.WriteFile(filename+".tmp", contents, 0o644); err != nil {
+		return
+	}
+	_ = os.Rename(filename+".tmp", filename)
+}
+
+func (c *Cache) filename(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// Clear removes every result kept in dir, for when they may no longer be true, such as after PRs have been changed
+func Clear(dir string) error {
+	return os.RemoveAll(dir)
+}
@@ -0,0 +1,65 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package cache
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/testsupport"
+)
+
+var fixedNow = time.Date(2021, 10, 1, 9, 0, 0, 0, time.UTC)
+
+func useFakeClock() *time.Time {
+	clock := fixedNow
+	now = func() time.Time { return clock }
+	return &clock
+}
+
+func TestItReturnsStoredResultsUntilTheyExpire(t *testing.T) {
+	clock := useFakeClock()
+	c := New("", time.Minute)
+
+	var value string
+	assert.False(t, c.Get("key", &value))
+
+	c.Put("key", "stored")
+	assert.True(t, c.Get("key", &value))
+	assert.Equal(t, "stored", value)
+
+	*clock = clock.Add(time.Minute)
+	assert.False(t, c.Get("key", &value), "the result should have expired")
+}
+
+func TestItKeepsResultsOnDiskForLaterRuns(t *testing.T) {
+	useFakeClock()
+	dir := filepath.Join(testsupport.CreateAndEnterTempDirectory(), "cache")
+
+	New(dir, time.Minute).Put("key", map[string]int{"number": 12})
+
+	var value map[string]int
+	assert.True(t, New(dir, time.Minute).Get("key", &value))
+	assert.Equal(t, map[string]int{"number": 12}, value)
+
+	assert.False(t, New("", time.Minute).Get("key", &value), "a cache without a directory should only see its own results")
+
+	assert.NoError(t, Clear(dir))
+	assert.False(t, New(dir, time.Minute).Get("key", &value))
+}
@@ -22,7 +22,10 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+
+	"github.com/skyscanner/turbolift/internal/messages"
 )
 
 type Repo struct {
@@ -30,28 +33,123 @@ type Repo struct {
 	OrgName      string
 	RepoName     string
 	FullRepoName string
+	// DefaultBranch overrides the repo's default branch as reported by GitHub, if not empty
+	DefaultBranch string
+	// Vars holds any custom metadata given for the repo in a structured repos file
+	Vars map[string]string
+	// Forge is where the repo is hosted: GitHubForge or BitbucketForge. Repos with no forge are on GitHub.
+	Forge string
+	// Path scopes the campaign to a directory within the repo, for monorepos. Only that directory is checked out, and
+	// commands are run inside it. It is empty for campaigns over the whole repo.
+	Path string
+}
+
+// The forges that repos can be hosted on
+const (
+	GitHubForge    = "github"
+	BitbucketForge = "bitbucket"
+)
+
+func isKnownForge(forge string) bool {
+	return forge == "" || forge == GitHubForge || forge == BitbucketForge
+}
+
+// OnBitbucket is true if the repo is hosted on Bitbucket Server or Data Center rather than GitHub
+func (r Repo) OnBitbucket() bool {
+	return r.Forge == BitbucketForge
 }
 
 type Campaign struct {
-	Name    string
-	Repos   []Repo
-	PrTitle string
-	PrBody  string
+	Name string
+	// BranchName is the branch that changes are made on in each repo, which defaults to the campaign name
+	BranchName string
+	Repos      []Repo
+	PrTitle    string
+	PrBody     string
+	Config     Config
+	// Shuffled is true if the repos have been put in a random order, generated from ShuffleSeed
+	Shuffled    bool
+	ShuffleSeed int64
+	// Messages are the user-facing messages of the campaign, with any overrides from its configuration
+	Messages *messages.Catalog
+	// WontDo are the repos in the repos file that have been marked won't do with turbolift note, which are left out of
+	// Repos so that commands and counts pass them by
+	WontDo []Repo
 }
 
+// DefaultHost is the GitHub host of repos that are not given one
+const DefaultHost = "github.com"
+
 func (r Repo) FullRepoPath() string {
 	return path.Join("work", r.OrgName, r.RepoName) // i.e. work/org/repo
 }
 
+// WorkingDir is where commands are run for the repo: the working copy, or the campaign's path within it
+func (r Repo) WorkingDir() string {
+	return path.Join(r.FullRepoPath(), r.Path) // i.e. work/org/repo/path
+}
+
+// ListedName is the repo as listed in a repos file: its full name, followed by //path when scoped to a path
+func (r Repo) ListedName() string {
+	if r.Path == "" {
+		return r.FullRepoName
+	}
+	return r.FullRepoName + "//" + r.Path
+}
+
+// Renamed returns the repo under a new name, given as org/repo or host/org/repo, keeping its metadata
+func (r Repo) Renamed(fullRepoName string) (Repo, error) {
+	renamed, err := parseRepoName(fullRepoName)
+	if err != nil {
+		return Repo{}, err
+	}
+	renamed.DefaultBranch = r.DefaultBranch
+	renamed.Vars = r.Vars
+	renamed.Forge = r.Forge
+	renamed.Path = r.Path
+	return renamed, nil
+}
+
+// Hosts lists the GitHub hosts that the campaign's repos are on, in alphabetical order. Bitbucket hosts are left out.
+func (c *Campaign) Hosts() []string {
+	uniq := map[string]bool{}
+	for _, repo := range c.Repos {
+		if repo.OnBitbucket() {
+			continue
+		}
+		host := repo.Host
+		if host == "" {
+			host = DefaultHost
+		}
+		uniq[host] = true
+	}
+
+	hosts := []string{}
+	for host := range uniq {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	if len(hosts) == 0 {
+		hosts = append(hosts, DefaultHost)
+	}
+	return hosts
+}
+
 type CampaignOptions struct {
 	RepoFilename          string
 	PrDescriptionFilename string
+	ConfigFilename        string
+	// BranchName overrides the branch set in the config file, if not empty
+	BranchName string
+	// Shuffle is the seed to shuffle the repos with, or RandomShuffle to choose one. Repos are not shuffled if empty.
+	Shuffle string
 }
 
 func NewCampaignOptions() *CampaignOptions {
 	return &CampaignOptions{
 		RepoFilename:          "repos.txt",
 		PrDescriptionFilename: "README.md",
+		ConfigFilename:        "turbolift.yaml",
 	}
 }
 
@@ -59,7 +157,7 @@ func OpenCampaign(options *CampaignOptions) (*Campaign, error) {
 	dir, _ := os.Getwd()
 	dirBasename := filepath.Base(dir)
 
-	repos, err := readReposTxtFile(options.RepoFilename)
+	repos, err := ReadReposFile(options.RepoFilename)
 	if err != nil {
 		return nil, err
 	}
@@ -69,14 +167,85 @@ func OpenCampaign(options *CampaignOptions) (*Campaign, error) {
 		return nil, err
 	}
 
+	config, err := ReadConfig(options.ConfigFilename)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := setForges(repos, config.Forge); err != nil {
+		return nil, err
+	}
+
+	catalog, err := messages.New(config.Messages)
+	if err != nil {
+		return nil, fmt.Errorf("%w in %s", err, options.ConfigFilename)
+	}
+
+	branchName := dirBasename
+	if config.Branch != "" {
+		branchName = config.Branch
+	}
+	if options.BranchName != "" {
+		branchName = options.BranchName
+	}
+
+	var shuffleSeed int64
+	if options.Shuffle != "" {
+		shuffleSeed, err = parseShuffleSeed(options.Shuffle)
+		if err != nil {
+			return nil, err
+		}
+		shuffleRepos(repos, shuffleSeed)
+	}
+
+	// repos are shuffled first, so that the order stays the same for a seed when repos are marked won't do
+	state, err := ReadState()
+	if err != nil {
+		return nil, err
+	}
+	repos, wontDo := withoutWontDo(repos, state)
+
 	return &Campaign{
-		Name:    dirBasename,
-		Repos:   repos,
-		PrTitle: prTitle,
-		PrBody:  prBody,
+		Name:        dirBasename,
+		BranchName:  branchName,
+		Repos:       repos,
+		PrTitle:     prTitle,
+		PrBody:      prBody,
+		Config:      config,
+		Shuffled:    options.Shuffle != "",
+		ShuffleSeed: shuffleSeed,
+		Messages:    catalog,
+		WontDo:      wontDo,
 	}, nil
 }
 
+// withoutWontDo separates the repos marked won't do from the rest
+func withoutWontDo(repos []Repo, state *State) ([]Repo, []Repo) {
+	var todo, wontDo []Repo
+	for _, repo := range repos {
+		if state.IsWontDo(repo.FullRepoName) {
+			wontDo = append(wontDo, repo)
+		} else {
+			todo = append(todo, repo)
+		}
+	}
+	return todo, wontDo
+}
+
+// setForges gives repos that do not say where they are hosted the campaign's forge, if it has one.
+// Bitbucket has no default host, so its repos must be given as host/project/repo.
+func setForges(repos []Repo, campaignForge string) error {
+	for i := range repos {
+		if repos[i].Forge == "" {
+			repos[i].Forge = campaignForge
+		}
+		if repos[i].OnBitbucket() && repos[i].Host == "" {
+			return fmt.Errorf("bitbucket repo %s must be given as host/project/repo", repos[i].FullRepoName)
+		}
+	}
+	return nil
+}
+
 func readReposTxtFile(filename string) ([]Repo, error) {
 	if filename == "" {
 		return nil, errors.New("no repos filename to open")
@@ -98,33 +267,18 @@ func readReposTxtFile(filename string) ([]Repo, error) {
 	for scanner.Scan() {
 		line := scanner.Text()
 		if !strings.HasPrefix(line, "#") && len(line) > 0 {
-			if _, seen := uniq[line]; seen {
-				continue
+			lineRepos, err := reposOnLine(filename, line)
+			if err != nil {
+				return nil, err
 			}
-			uniq[line] = struct{}{}
-
-			splitLine := strings.Split(line, "/")
-			numParts := len(splitLine)
-
-			var repo Repo
-			switch numParts {
-			case 2:
-				repo = Repo{
-					OrgName:      splitLine[0],
-					RepoName:     splitLine[1],
-					FullRepoName: line,
+			// repos expanded from an org may also be listed on their own
+			for _, repo := range lineRepos {
+				if _, seen := uniq[repo.ListedName()]; seen {
+					continue
 				}
-			case 3:
-				repo = Repo{
-					Host:         splitLine[0],
-					OrgName:      splitLine[1],
-					RepoName:     splitLine[2],
-					FullRepoName: line,
-				}
-			default:
-				return nil, fmt.Errorf("unable to parse entry in %s file: %s", filename, line)
+				uniq[repo.ListedName()] = struct{}{}
+				repos = append(repos, repo)
 			}
-			repos = append(repos, repo)
 		}
 	}
 
@@ -135,6 +289,69 @@ func readReposTxtFile(filename string) ([]Repo, error) {
 	return repos, nil
 }
 
+// reposOnLine returns the repo on a line of a plain repos file, or the repos of an org pattern
+func reposOnLine(filename string, line string) ([]Repo, error) {
+	pattern, isPattern, err := parseOrgPattern(line)
+	if err != nil {
+		return nil, fmt.Errorf("%w in %s file", err, filename)
+	} else if isPattern {
+		return expandOrgPattern(filename, pattern)
+	}
+
+	repo, err := parseRepoName(line)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse entry in %s file: %s", filename, line)
+	}
+	return []Repo{repo}, nil
+}
+
+// parseRepoName parses a repo given as org/repo or host/org/repo, optionally followed by //path to scope it to a
+// directory within the repo. Paths are kept with forward slashes, even when given with Windows separators.
+func parseRepoName(name string) (Repo, error) {
+	repoPath := ""
+	if i := strings.Index(name, "//"); i >= 0 {
+		repoPath = strings.Trim(strings.ReplaceAll(name[i+2:], `\`, "/"), "/")
+		name = name[:i]
+		if repoPath == "" || strings.HasPrefix(path.Clean(repoPath), "..") {
+			return Repo{}, fmt.Errorf("unable to parse repo path in %s//%s", name, repoPath)
+		}
+		repoPath = path.Clean(repoPath)
+	}
+	splitName := strings.Split(name, "/")
+
+	switch len(splitName) {
+	case 2:
+		return Repo{
+			OrgName:      splitName[0],
+			RepoName:     splitName[1],
+			FullRepoName: name,
+			Path:         repoPath,
+		}, nil
+	case 3:
+		return Repo{
+			Host:         splitName[0],
+			OrgName:      splitName[1],
+			RepoName:     splitName[2],
+			FullRepoName: name,
+			Path:         repoPath,
+		}, nil
+	default:
+		return Repo{}, fmt.Errorf("unable to parse repo name %s", name)
+	}
+}
+
+// checkPaths makes sure that no repo is listed with more than one path, as each repo has a single working copy
+func checkPaths(filename string, repos []Repo) error {
+	paths := map[string]string{}
+	for _, repo := range repos {
+		if seenPath, seen := paths[repo.FullRepoName]; seen && seenPath != repo.Path {
+			return fmt.Errorf("%s is listed more than once in %s file, with different paths", repo.FullRepoName, filename)
+		}
+		paths[repo.FullRepoName] = repo.Path
+	}
+	return nil
+}
+
 func readPrDescriptionFile(filename string) (string, string, error) {
 	if filename == "" {
 		return "", "", errors.New("no PR description file to open")
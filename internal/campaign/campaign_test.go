@@ -75,6 +75,15 @@ func TestItReadsRepoNamesWithOtherHostsFromReposFile(t *testing.T) {
 	assert.Equal(t, "PR body", campaign.PrBody)
 }
 
+func TestItListsTheHostsOfTheRepos(t *testing.T) {
+	testsupport.PrepareTempCampaign(false, "org/repo1", "mygitserver.com/org/repo2", "org/repo3")
+
+	campaign, err := OpenCampaign(NewCampaignOptions())
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"github.com", "mygitserver.com"}, campaign.Hosts())
+}
+
 func TestItIgnoresCommentedLines(t *testing.T) {
 	testsupport.PrepareTempCampaign(false, "org/repo1", "#org/repo2")
 
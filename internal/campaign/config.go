@@ -0,0 +1,292 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package campaign
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Names of the hooks that can be configured in turbolift.yaml
+const (
+	PreCloneHook     = "pre-clone"
+	PostCloneHook    = "post-clone"
+	PreCommitHook    = "pre-commit"
+	PostCommitHook   = "post-commit"
+	PreCreatePrHook  = "pre-create-pr"
+	PostCreatePrHook = "post-create-pr"
+)
+
+var knownHooks = []string{PreCloneHook, PostCloneHook, PreCommitHook, PostCommitHook, PreCreatePrHook, PostCreatePrHook}
+
+// Names of the processors that the PR body can be passed through, as configured in turbolift.yaml
+const (
+	IncludeProcessor  = "include"
+	TemplateProcessor = "template"
+	TocProcessor      = "toc"
+	LinksProcessor    = "links"
+	CommandProcessor  = "command"
+)
+
+var knownBodyProcessors = []string{IncludeProcessor, TemplateProcessor, TocProcessor, LinksProcessor, CommandProcessor}
+
+// Config holds the optional campaign-level settings that can be kept in turbolift.yaml
+type Config struct {
+	// Branch is the name of the working branch, if it should differ from the campaign directory name
+	Branch string `yaml:"branch"`
+	// Hooks maps a hook name (e.g. post-clone) to a shell script that is run for each repo
+	Hooks map[string]string `yaml:"hooks"`
+	// Registry configures where campaign metadata is published for discovery across the organisation
+	Registry RegistryConfig `yaml:"registry"`
+	// Commit holds defaults for the options used by turbolift commit
+	Commit CommitConfig `yaml:"commit"`
+	// Labels maps a label name to file patterns; PRs changing a matching file are given the label by create-prs
+	Labels map[string][]string `yaml:"labels"`
+	// Checklist holds the items of a reviewer checklist added to every PR description. Items may use repo templating.
+	Checklist []string `yaml:"checklist"`
+	// Templating renders the PR title, description and checklist items as Go templates for each repo. Without it they
+	// are used as written, so that text such as ${{ secrets.TOKEN }} in a GitHub Actions snippet is left alone.
+	Templating bool `yaml:"templating"`
+	// Forge is where the campaign's repos are hosted, github (the default) or bitbucket, unless a repo says otherwise
+	Forge string `yaml:"forge"`
+	// Confirm sets how destructive commands over many repos are confirmed
+	Confirm ConfirmConfig `yaml:"confirm"`
+	// Messages overrides the text of prompts, PR description sections and summaries, keyed by message name
+	Messages map[string]string `yaml:"messages"`
+	// PrBody sets how the PR description is processed for each repo
+	PrBody PrBodyConfig `yaml:"pr-body"`
+	// Tools lists the helper tools installed for the campaign by turbolift tools install, as TOOL or TOOL@VERSION
+	Tools []string `yaml:"tools"`
+	// Notify sets where a summary is posted when a long-running command finishes
+	Notify NotifyConfig `yaml:"notify"`
+	// Audit records every external command run for the campaign, and its output, in the campaign's state directory
+	Audit bool `yaml:"audit"`
+	// Docker runs foreach commands inside a container, so that they get the same toolchain on every machine
+	Docker DockerConfig `yaml:"docker"`
+	// Redact lists regular expressions matching secrets to mask in logged output, besides tokens turbolift knows about
+	Redact []string `yaml:"redact"`
+}
+
+// DockerConfig sets the container that foreach runs commands in, with each repo's working copy mounted
+type DockerConfig struct {
+	// Image is the Docker image to run commands in, e.g. node:20. Commands run on the local machine if empty.
+	Image string `yaml:"image"`
+}
+
+// DefaultNotifyCommands are the commands that send notifications when they finish, unless configured otherwise
+var DefaultNotifyCommands = []string{"clone", "foreach", "create-prs"}
+
+// NotifyConfig sets where the summary of a run is posted when it finishes. The URLs may refer to environment
+// variables, as in ${SLACK_WEBHOOK_URL}, so that they need not be kept in turbolift.yaml.
+type NotifyConfig struct {
+	// SlackWebhook is the URL of a Slack incoming webhook
+	SlackWebhook string `yaml:"slack-webhook"`
+	// Webhook is the URL of an HTTP endpoint that the summary is POSTed to as JSON
+	Webhook string `yaml:"webhook"`
+	// Commands are the commands that send notifications when they finish, if not DefaultNotifyCommands
+	Commands []string `yaml:"commands"`
+}
+
+// NotifiesFor is true if the command sends notifications when it finishes
+func (c NotifyConfig) NotifiesFor(command string) bool {
+	commands := c.Commands
+	if len(commands) == 0 {
+		commands = DefaultNotifyCommands
+	}
+	return contains(commands, command)
+}
+
+// PrBodyConfig is the chain of processors that the PR description is passed through for each repo, before PRs are
+// created or their descriptions amended
+type PrBodyConfig struct {
+	// Processors are applied in order. Without any, the body only has its template actions expanded, if templating is on.
+	Processors []string `yaml:"processors"`
+	// Links maps URL prefixes to the prefixes that the links processor rewrites them with
+	Links map[string]string `yaml:"links"`
+	// Command is the shell command run by the command processor, which prints the new body
+	Command string `yaml:"command"`
+	// IncludeCacheFor is how long the include processor reuses snippets fetched from URLs before fetching them again
+	IncludeCacheFor time.Duration `yaml:"include-cache-for"`
+}
+
+// DefaultIncludeCacheFor is how long snippets fetched from URLs are cached for, unless configured otherwise
+const DefaultIncludeCacheFor = time.Hour
+
+// IncludeCacheDuration returns how long snippets fetched from URLs are cached for
+func (c PrBodyConfig) IncludeCacheDuration() time.Duration {
+	if c.IncludeCacheFor == 0 {
+		return DefaultIncludeCacheFor
+	}
+	return c.IncludeCacheFor
+}
+
+// BodyProcessors returns the processors that the PR body is passed through, in order
+func (c Config) BodyProcessors() []string {
+	if len(c.PrBody.Processors) == 0 && c.Templating {
+		return []string{TemplateProcessor}
+	}
+	return c.PrBody.Processors
+}
+
+// When the campaign name must be typed to confirm a destructive command
+const (
+	ConfirmFirstUse = "first-use"
+	ConfirmAlways   = "always"
+	ConfirmNever    = "never"
+)
+
+// DefaultDestructiveThreshold is how many repos a destructive command must affect before it needs typed confirmation
+const DefaultDestructiveThreshold = 100
+
+// ConfirmConfig sets when the campaign name must be typed to confirm a destructive command, such as closing every PR
+type ConfirmConfig struct {
+	// Destructive is first-use (the default), always, or never, which suits automation that cannot type anything
+	Destructive string `yaml:"destructive"`
+	// Threshold is the number of repos above which a destructive command needs confirming, if not the default
+	Threshold int `yaml:"threshold"`
+}
+
+// DestructivePolicy returns when destructive commands need typed confirmation, which is on first use by default
+func (c ConfirmConfig) DestructivePolicy() string {
+	if c.Destructive == "" {
+		return ConfirmFirstUse
+	}
+	return c.Destructive
+}
+
+// DestructiveThreshold returns the number of repos above which destructive commands need typed confirmation
+func (c ConfirmConfig) DestructiveThreshold() int {
+	if c.Threshold <= 0 {
+		return DefaultDestructiveThreshold
+	}
+	return c.Threshold
+}
+
+// CommitConfig allows commits to be signed, signed off, or attributed to a different author or committer
+type CommitConfig struct {
+	GpgSign        bool   `yaml:"gpg-sign"`
+	Signoff        bool   `yaml:"signoff"`
+	AuthorName     string `yaml:"author-name"`
+	AuthorEmail    string `yaml:"author-email"`
+	CommitterName  string `yaml:"committer-name"`
+	CommitterEmail string `yaml:"committer-email"`
+}
+
+// RegistryConfig points at a central campaign registry: either an HTTP endpoint or a local checkout of a registry repo
+type RegistryConfig struct {
+	Url   string `yaml:"url"`
+	Path  string `yaml:"path"`
+	Owner string `yaml:"owner"`
+}
+
+// ReadConfig reads the campaign configuration file. A missing file is not an error, and results in an empty Config.
+func ReadConfig(filename string) (Config, error) {
+	config := Config{}
+	if filename == "" {
+		return config, nil
+	}
+
+	contents, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		// the config file is optional
+		return config, nil
+	} else if err != nil {
+		return config, fmt.Errorf("unable to open config file: %s", filename)
+	}
+
+	if err := yaml.Unmarshal(contents, &config); err != nil {
+		return config, fmt.Errorf("unable to parse config file %s: %w", filename, err)
+	}
+
+	for hook := range config.Hooks {
+		if !isKnownHook(hook) {
+			return config, fmt.Errorf("unknown hook %s in %s", hook, filename)
+		}
+	}
+
+	if config.Registry.Url != "" && config.Registry.Path != "" {
+		return config, fmt.Errorf("only one of registry url and path may be set in %s", filename)
+	}
+
+	if err := validateLabelPatterns(config.Labels, filename); err != nil {
+		return config, err
+	}
+
+	if !isKnownForge(config.Forge) {
+		return config, fmt.Errorf("unknown forge %s in %s: must be one of %s, %s", config.Forge, filename, GitHubForge, BitbucketForge)
+	}
+
+	if err := validateBodyProcessors(config.PrBody, filename); err != nil {
+		return config, err
+	}
+
+	for _, pattern := range config.Redact {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return config, fmt.Errorf("unable to parse redact pattern %s in %s: %w", pattern, filename, err)
+		}
+	}
+
+	switch config.Confirm.DestructivePolicy() {
+	case ConfirmFirstUse, ConfirmAlways, ConfirmNever:
+	default:
+		return config, fmt.Errorf("unknown confirm destructive policy %s in %s: must be one of %s, %s, %s", config.Confirm.Destructive, filename, ConfirmFirstUse, ConfirmAlways, ConfirmNever)
+	}
+
+	return config, nil
+}
+
+func validateBodyProcessors(config PrBodyConfig, filename string) error {
+	for _, processor := range config.Processors {
+		if !contains(knownBodyProcessors, processor) {
+			return fmt.Errorf("unknown pr-body processor %s in %s: must be one of %s", processor, filename, strings.Join(knownBodyProcessors, ", "))
+		}
+		if processor == CommandProcessor && config.Command == "" {
+			return fmt.Errorf("the pr-body command processor needs a command in %s", filename)
+		}
+		if processor == LinksProcessor && len(config.Links) == 0 {
+			return fmt.Errorf("the pr-body links processor needs links to rewrite in %s", filename)
+		}
+	}
+	return nil
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func isKnownHook(name string) bool {
+	for _, hook := range knownHooks {
+		if hook == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Hook returns the script configured for the named hook, or an empty string if there is none
+func (c *Campaign) Hook(name string) string {
+	return c.Config.Hooks[name]
+}
@@ -0,0 +1,265 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package campaign
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/messages"
+	"github.com/skyscanner/turbolift/internal/testsupport"
+)
+
+func TestItOpensCampaignWithoutConfigFile(t *testing.T) {
+	testsupport.PrepareTempCampaign(false, "org/repo1")
+
+	campaign, err := OpenCampaign(NewCampaignOptions())
+	assert.NoError(t, err)
+
+	assert.Empty(t, campaign.Config.Hooks)
+	assert.Equal(t, "", campaign.Hook(PostCloneHook))
+}
+
+func TestItReadsHooksFromConfigFile(t *testing.T) {
+	testsupport.PrepareTempCampaign(false, "org/repo1")
+	testsupport.CreateConfigFile(`
+hooks:
+  post-clone: make deps
+  pre-commit: |
+    gofmt -w .
+    go mod tidy
+`)
+
+	campaign, err := OpenCampaign(NewCampaignOptions())
+	assert.NoError(t, err)
+
+	assert.Equal(t, "make deps", campaign.Hook(PostCloneHook))
+	assert.Equal(t, "gofmt -w .\ngo mod tidy\n", campaign.Hook(PreCommitHook))
+	assert.Equal(t, "", campaign.Hook(PostCreatePrHook))
+}
+
+func TestItRejectsUnknownHooks(t *testing.T) {
+	testsupport.PrepareTempCampaign(false, "org/repo1")
+	testsupport.CreateConfigFile(`
+hooks:
+  post-lunch: make coffee
+`)
+
+	_, err := OpenCampaign(NewCampaignOptions())
+	assert.EqualError(t, err, "unknown hook post-lunch in turbolift.yaml")
+}
+
+func TestItRejectsInvalidConfigFile(t *testing.T) {
+	testsupport.PrepareTempCampaign(false, "org/repo1")
+	testsupport.CreateConfigFile("hooks: [this is not a map")
+
+	_, err := OpenCampaign(NewCampaignOptions())
+	assert.Error(t, err)
+}
+
+func TestItReadsRegistryFromConfigFile(t *testing.T) {
+	testsupport.PrepareTempCampaign(false, "org/repo1")
+	testsupport.CreateConfigFile(`
+registry:
+  url: https://registry.example.com/campaigns
+  owner: platform-team
+`)
+
+	campaign, err := OpenCampaign(NewCampaignOptions())
+	assert.NoError(t, err)
+
+	assert.Equal(t, RegistryConfig{Url: "https://registry.example.com/campaigns", Owner: "platform-team"}, campaign.Config.Registry)
+}
+
+func TestItRejectsRegistryWithBothUrlAndPath(t *testing.T) {
+	testsupport.PrepareTempCampaign(false, "org/repo1")
+	testsupport.CreateConfigFile(`
+registry:
+  url: https://registry.example.com/campaigns
+  path: ../registry
+`)
+
+	_, err := OpenCampaign(NewCampaignOptions())
+	assert.EqualError(t, err, "only one of registry url and path may be set in turbolift.yaml")
+}
+
+func TestItLabelsChangedFilesUsingConfiguredPatterns(t *testing.T) {
+	testsupport.PrepareTempCampaign(false, "org/repo1")
+	testsupport.CreateConfigFile(`
+labels:
+  ci: [".github/workflows/**", Jenkinsfile]
+  dependencies: [go.mod, go.sum, package.json]
+  docker: ["Dockerfile*", "deploy/docker-compose*.yml"]
+`)
+
+	campaign, err := OpenCampaign(NewCampaignOptions())
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"ci", "dependencies"}, campaign.LabelsFor([]string{".github/workflows/build.yml", "services/api/go.mod"}))
+	assert.Equal(t, []string{"docker"}, campaign.LabelsFor([]string{"images/Dockerfile.base", "deploy/docker-compose.test.yml"}))
+	assert.Equal(t, []string{}, campaign.LabelsFor([]string{"docker-compose.yml", "src/main.go"}))
+}
+
+func TestItRejectsInvalidLabelPatterns(t *testing.T) {
+	testsupport.PrepareTempCampaign(false, "org/repo1")
+	testsupport.CreateConfigFile(`
+labels:
+  ci: ["[unterminated"]
+`)
+
+	_, err := OpenCampaign(NewCampaignOptions())
+	assert.EqualError(t, err, "invalid pattern [unterminated for label ci in turbolift.yaml")
+}
+
+func TestItUsesTheCampaignNameAsTheDefaultBranch(t *testing.T) {
+	testsupport.PrepareTempCampaign(false, "org/repo1")
+
+	campaign, err := OpenCampaign(NewCampaignOptions())
+	assert.NoError(t, err)
+
+	assert.Equal(t, campaign.Name, campaign.BranchName)
+}
+
+func TestItReadsBranchFromConfigFileUnlessOverridden(t *testing.T) {
+	testsupport.PrepareTempCampaign(false, "org/repo1")
+	testsupport.CreateConfigFile("branch: my-campaign-v2\n")
+
+	campaign, err := OpenCampaign(NewCampaignOptions())
+	assert.NoError(t, err)
+	assert.Equal(t, "my-campaign-v2", campaign.BranchName)
+
+	options := NewCampaignOptions()
+	options.BranchName = "my-campaign-v3"
+	campaign, err = OpenCampaign(options)
+	assert.NoError(t, err)
+	assert.Equal(t, "my-campaign-v3", campaign.BranchName)
+}
+
+func TestItPutsAllReposOnTheCampaignForge(t *testing.T) {
+	testsupport.PrepareTempCampaign(false, "bitbucket.example.com/PROJ/repo1", "bitbucket.example.com/PROJ/repo2")
+	testsupport.CreateConfigFile(`
+forge: bitbucket
+`)
+
+	campaign, err := OpenCampaign(NewCampaignOptions())
+	assert.NoError(t, err)
+
+	assert.True(t, campaign.Repos[0].OnBitbucket())
+	assert.True(t, campaign.Repos[1].OnBitbucket())
+	assert.Equal(t, []string{"github.com"}, campaign.Hosts())
+}
+
+func TestItRejectsBitbucketReposWithoutAHost(t *testing.T) {
+	testsupport.PrepareTempCampaign(false, "PROJ/repo1")
+	testsupport.CreateConfigFile(`
+forge: bitbucket
+`)
+
+	_, err := OpenCampaign(NewCampaignOptions())
+	assert.EqualError(t, err, "bitbucket repo PROJ/repo1 must be given as host/project/repo")
+}
+
+func TestItRejectsUnknownForges(t *testing.T) {
+	testsupport.PrepareTempCampaign(false, "org/repo1")
+	testsupport.CreateConfigFile(`
+forge: sourceforge
+`)
+
+	_, err := OpenCampaign(NewCampaignOptions())
+	assert.EqualError(t, err, "unknown forge sourceforge in turbolift.yaml: must be one of github, bitbucket")
+}
+
+func TestItRejectsBadRedactPatterns(t *testing.T) {
+	testsupport.PrepareTempCampaign(false, "org/repo1")
+	testsupport.CreateConfigFile(`
+redact:
+  - "npm_[0-9a-f]{8}"
+  - "(unclosed"
+`)
+
+	_, err := OpenCampaign(NewCampaignOptions())
+	assert.EqualError(t, err, "unable to parse redact pattern (unclosed in turbolift.yaml: error parsing regexp: missing closing ): `(unclosed`")
+}
+
+func TestItRejectsUnknownConfirmPolicies(t *testing.T) {
+	testsupport.PrepareTempCampaign(false, "org/repo1")
+	testsupport.CreateConfigFile(`
+confirm:
+  destructive: sometimes
+`)
+
+	_, err := OpenCampaign(NewCampaignOptions())
+	assert.EqualError(t, err, "unknown confirm destructive policy sometimes in turbolift.yaml: must be one of first-use, always, never")
+}
+
+func TestItReadsMessageOverrides(t *testing.T) {
+	testsupport.PrepareTempCampaign(false, "org/repo1")
+	testsupport.CreateConfigFile(`
+messages:
+  checklist-heading: "## Before merging"
+`)
+
+	dir, err := OpenCampaign(NewCampaignOptions())
+	assert.NoError(t, err)
+	assert.Equal(t, "## Before merging", dir.Messages.Format(messages.ChecklistHeading, nil))
+}
+
+func TestItRejectsUnknownMessages(t *testing.T) {
+	testsupport.PrepareTempCampaign(false, "org/repo1")
+	testsupport.CreateConfigFile(`
+messages:
+  greeting: hello
+`)
+
+	_, err := OpenCampaign(NewCampaignOptions())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown message greeting")
+	assert.Contains(t, err.Error(), "in turbolift.yaml")
+}
+
+func TestItRejectsUnknownOrIncompletePrBodyProcessors(t *testing.T) {
+	testsupport.PrepareTempCampaign(false, "org/repo1")
+	testsupport.CreateConfigFile(`
+pr-body:
+  processors: [include, spellcheck]
+`)
+	_, err := OpenCampaign(NewCampaignOptions())
+	assert.EqualError(t, err, "unknown pr-body processor spellcheck in turbolift.yaml: must be one of include, template, toc, links, command")
+
+	testsupport.CreateConfigFile(`
+pr-body:
+  processors: [template, command]
+`)
+	_, err = OpenCampaign(NewCampaignOptions())
+	assert.EqualError(t, err, "the pr-body command processor needs a command in turbolift.yaml")
+}
+
+func TestItReadsHowLongToCacheIncludedSnippets(t *testing.T) {
+	testsupport.PrepareTempCampaign(false, "org/repo1")
+	dir, err := OpenCampaign(NewCampaignOptions())
+	assert.NoError(t, err)
+	assert.Equal(t, time.Hour, dir.Config.PrBody.IncludeCacheDuration())
+
+	testsupport.CreateConfigFile(`
+pr-body:
+  include-cache-for: 24h
+`)
+	dir, err = OpenCampaign(NewCampaignOptions())
+	assert.NoError(t, err)
+	assert.Equal(t, 24*time.Hour, dir.Config.PrBody.IncludeCacheDuration())
+}
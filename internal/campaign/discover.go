@@ -0,0 +1,81 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package campaign
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MarkerFile is created by turbolift init in each campaign directory
+const MarkerFile = ".turbolift"
+
+// FindCampaignDirs returns the directories that are campaigns, either created by turbolift init or with some campaign
+// state, from the given directory down to the given depth beneath it: 1 for its immediate subdirectories. Campaigns
+// are not searched for other campaigns within them, so if the given directory is a campaign it is the only one found.
+// Hidden directories are not searched.
+func FindCampaignDirs(root string, depth int) ([]string, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	var dirs []string
+	err = filepath.WalkDir(root, func(dir string, entry os.DirEntry, err error) error {
+		if err != nil {
+			if dir == root {
+				return err
+			}
+			// directories that cannot be read are not campaigns that the user can work on
+			return filepath.SkipDir
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+		if dir != root && strings.HasPrefix(entry.Name(), ".") {
+			return filepath.SkipDir
+		}
+
+		if IsCampaignDir(dir) {
+			dirs = append(dirs, dir)
+			return filepath.SkipDir
+		}
+		if depthOf(root, dir) >= depth {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return dirs, err
+}
+
+// depthOf is how far the directory is beneath the root: 0 for the root itself
+func depthOf(root string, dir string) int {
+	relative, err := filepath.Rel(root, dir)
+	if err != nil || relative == "." {
+		return 0
+	}
+	return strings.Count(relative, string(filepath.Separator)) + 1
+}
+
+// IsCampaignDir is true if the directory was created by turbolift init or has campaign state
+func IsCampaignDir(dir string) bool {
+	if _, err := os.Stat(filepath.Join(dir, MarkerFile)); err == nil {
+		return true
+	}
+	info, err := os.Stat(filepath.Join(dir, StateDirectory))
+	return err == nil && info.IsDir()
+}
@@ -0,0 +1,54 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package campaign
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/testsupport"
+)
+
+func TestItFindsCampaignsBeneathADirectory(t *testing.T) {
+	root := testsupport.CreateAndEnterTempDirectory()
+
+	createDir(t, "team-a/campaign-1", MarkerFile)
+	createDir(t, "team-a/campaign-1/work/org/nested", MarkerFile)
+	createDir(t, "team-b/campaign-2", filepath.Join(StateDirectory, "state.json"))
+	createDir(t, "team-b/not-a-campaign", "README.md")
+	createDir(t, ".hidden/campaign-3", MarkerFile)
+	createDir(t, "a/b/c/too-deep", MarkerFile)
+
+	dirs, err := FindCampaignDirs(".", 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(root, "team-a/campaign-1"), filepath.Join(root, "team-b/campaign-2")}, dirs)
+
+	dirs, err = FindCampaignDirs(".", 1)
+	assert.NoError(t, err)
+	assert.Empty(t, dirs)
+
+	dirs, err = FindCampaignDirs("team-a/campaign-1", 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(root, "team-a/campaign-1")}, dirs)
+}
+
+func createDir(t *testing.T, dir string, filename string) {
+	assert.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, filename)), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, filename), []byte{}, 0o644))
+}
@@ -0,0 +1,234 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package campaign
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OrgPattern is a line of a plain repos file that stands for every repo in an org, given as org:ORG or ORG/*,
+// optionally with a host. It is expanded into the org's repos by turbolift clone, so that the campaign picks up repos
+// created since it started. Archived repos and forks are left out unless asked for, and the repos can be narrowed
+// down to those with a language or topics, e.g. org:acme language:go topic:payments
+type OrgPattern struct {
+	// Line is the pattern as written in the repos file, which identifies its expansion
+	Line     string
+	Host     string
+	Org      string
+	Language string
+	// Topics are the topics that a repo must have all of to be included
+	Topics []string
+	// Archived and Forks include archived repos and forks
+	Archived bool
+	Forks    bool
+}
+
+// OrgRepo is a repo found in an org, with the details that an OrgPattern can filter on
+type OrgRepo struct {
+	// FullRepoName is the repo's name as org/repo
+	FullRepoName string
+	Archived     bool
+	Fork         bool
+	Language     string
+	Topics       []string
+}
+
+// Matches is true if the repo passes the pattern's filters. Languages and topics are compared ignoring case.
+func (p OrgPattern) Matches(repo OrgRepo) bool {
+	if (repo.Archived && !p.Archived) || (repo.Fork && !p.Forks) {
+		return false
+	}
+	if p.Language != "" && !strings.EqualFold(p.Language, repo.Language) {
+		return false
+	}
+	for _, topic := range p.Topics {
+		found := false
+		for _, repoTopic := range repo.Topics {
+			if strings.EqualFold(topic, repoTopic) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// RepoName is the name that an org's repo is listed under in the campaign, with the pattern's host if it has one
+func (p OrgPattern) RepoName(repo OrgRepo) string {
+	if p.Host == "" {
+		return repo.FullRepoName
+	}
+	return p.Host + "/" + repo.FullRepoName
+}
+
+// Expansion is the list of repos that an org pattern was last expanded into
+type Expansion struct {
+	Pattern  string    `json:"pattern"`
+	Repos    []string  `json:"repos"`
+	Expanded time.Time `json:"expanded"`
+}
+
+// Expansion returns the last expansion of an org pattern, or nil if it has not been expanded yet
+func (s *State) Expansion(pattern string) *Expansion {
+	for i := range s.Expansions {
+		if s.Expansions[i].Pattern == pattern {
+			return &s.Expansions[i]
+		}
+	}
+	return nil
+}
+
+// SetExpansion records the repos an org pattern has been expanded into, in alphabetical order, replacing any earlier
+// expansion of it. It returns how many of them are new since the earlier expansion.
+func (s *State) SetExpansion(pattern string, repos []string) int {
+	sorted := append([]string{}, repos...)
+	sort.Strings(sorted)
+	expansion := Expansion{Pattern: pattern, Repos: sorted, Expanded: time.Now()}
+
+	earlier := s.Expansion(pattern)
+	if earlier == nil {
+		s.Expansions = append(s.Expansions, expansion)
+		return len(sorted)
+	}
+	known := map[string]bool{}
+	for _, repo := range earlier.Repos {
+		known[repo] = true
+	}
+	added := 0
+	for _, repo := range sorted {
+		if !known[repo] {
+			added++
+		}
+	}
+	*earlier = expansion
+	return added
+}
+
+// OrgPatterns lists the org patterns in a plain repos file. Structured repos files cannot contain any.
+func OrgPatterns(filename string) ([]OrgPattern, error) {
+	if isStructuredReposFile(filename) {
+		return nil, nil
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open repo file: %s", filename)
+	}
+	defer func() { _ = file.Close() }()
+
+	var patterns []OrgPattern
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		pattern, ok, err := parseOrgPattern(line)
+		if err != nil {
+			return nil, fmt.Errorf("%w in %s file", err, filename)
+		} else if ok {
+			patterns = append(patterns, pattern)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to open %s file: %w", filename, err)
+	}
+	return patterns, nil
+}
+
+// parseOrgPattern parses a line of a plain repos file as an org pattern. It returns false if the line names a single
+// repo instead.
+func parseOrgPattern(line string) (OrgPattern, bool, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return OrgPattern{}, false, nil
+	}
+
+	var org string
+	switch {
+	case strings.HasPrefix(fields[0], "org:"):
+		org = strings.TrimPrefix(fields[0], "org:")
+	case strings.HasSuffix(fields[0], "/*"):
+		org = strings.TrimSuffix(fields[0], "/*")
+	default:
+		return OrgPattern{}, false, nil
+	}
+
+	pattern := OrgPattern{Line: strings.Join(fields, " ")}
+	switch parts := strings.Split(org, "/"); len(parts) {
+	case 1:
+		pattern.Org = parts[0]
+	case 2:
+		pattern.Host, pattern.Org = parts[0], parts[1]
+	}
+	if pattern.Org == "" || (strings.Contains(org, "/") && pattern.Host == "") {
+		return OrgPattern{}, false, fmt.Errorf("unable to parse org %s", fields[0])
+	}
+
+	for _, filter := range fields[1:] {
+		key, value := filter, ""
+		if i := strings.Index(filter, ":"); i >= 0 {
+			key, value = filter[:i], filter[i+1:]
+		}
+		var err error
+		switch key {
+		case "language":
+			pattern.Language = value
+		case "topic":
+			pattern.Topics = append(pattern.Topics, value)
+		case "archived":
+			pattern.Archived, err = strconv.ParseBool(value)
+		case "fork":
+			pattern.Forks, err = strconv.ParseBool(value)
+		default:
+			return OrgPattern{}, false, fmt.Errorf("unknown filter %s for %s, expected language:, topic:, archived: or fork:", filter, fields[0])
+		}
+		if err != nil || value == "" {
+			return OrgPattern{}, false, fmt.Errorf("unable to parse filter %s for %s", filter, fields[0])
+		}
+	}
+	return pattern, true, nil
+}
+
+// expandOrgPattern lists the repos that an org pattern was last expanded into
+func expandOrgPattern(filename string, pattern OrgPattern) ([]Repo, error) {
+	state, err := ReadState()
+	if err != nil {
+		return nil, err
+	}
+	expansion := state.Expansion(pattern.Line)
+	if expansion == nil {
+		return nil, fmt.Errorf("%s in %s file has not been expanded into its repos yet, so run turbolift clone first", pattern.Line, filename)
+	}
+
+	var repos []Repo
+	for _, name := range expansion.Repos {
+		repo, err := parseRepoName(name)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse repo %s expanded from %s", name, pattern.Line)
+		}
+		repos = append(repos, repo)
+	}
+	return repos, nil
+}
@@ -0,0 +1,102 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package campaign
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/testsupport"
+)
+
+func TestItParsesOrgPatterns(t *testing.T) {
+	pattern, ok, err := parseOrgPattern("org:acme  language:go topic:payments topic:api")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, OrgPattern{Line: "org:acme language:go topic:payments topic:api", Org: "acme", Language: "go", Topics: []string{"payments", "api"}}, pattern)
+
+	pattern, ok, err = parseOrgPattern("github.example.com/acme/* archived:true fork:true")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, OrgPattern{Line: "github.example.com/acme/* archived:true fork:true", Host: "github.example.com", Org: "acme", Archived: true, Forks: true}, pattern)
+
+	_, ok, err = parseOrgPattern("acme/repo1")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	_, _, err = parseOrgPattern("org:acme stars:100")
+	assert.EqualError(t, err, "unknown filter stars:100 for org:acme, expected language:, topic:, archived: or fork:")
+
+	_, _, err = parseOrgPattern("org:acme archived:maybe")
+	assert.EqualError(t, err, "unable to parse filter archived:maybe for org:acme")
+
+	_, _, err = parseOrgPattern("org:")
+	assert.EqualError(t, err, "unable to parse org org:")
+}
+
+func TestOrgPatternsLeaveOutArchivedReposAndForksUnlessAskedFor(t *testing.T) {
+	pattern := OrgPattern{Org: "acme"}
+	assert.True(t, pattern.Matches(OrgRepo{FullRepoName: "acme/api"}))
+	assert.False(t, pattern.Matches(OrgRepo{FullRepoName: "acme/old", Archived: true}))
+	assert.False(t, pattern.Matches(OrgRepo{FullRepoName: "acme/fork", Fork: true}))
+
+	pattern = OrgPattern{Org: "acme", Archived: true, Forks: true}
+	assert.True(t, pattern.Matches(OrgRepo{FullRepoName: "acme/old", Archived: true}))
+	assert.True(t, pattern.Matches(OrgRepo{FullRepoName: "acme/fork", Fork: true}))
+}
+
+func TestOrgPatternsFilterByLanguageAndTopics(t *testing.T) {
+	pattern := OrgPattern{Org: "acme", Language: "go", Topics: []string{"payments", "api"}}
+	assert.True(t, pattern.Matches(OrgRepo{FullRepoName: "acme/api", Language: "Go", Topics: []string{"API", "payments", "other"}}))
+	assert.False(t, pattern.Matches(OrgRepo{FullRepoName: "acme/web", Language: "TypeScript", Topics: []string{"api", "payments"}}))
+	assert.False(t, pattern.Matches(OrgRepo{FullRepoName: "acme/cli", Language: "Go", Topics: []string{"payments"}}))
+}
+
+func TestItCountsTheNewReposOfAnExpansion(t *testing.T) {
+	state := &State{}
+	assert.Equal(t, 2, state.SetExpansion("org:acme", []string{"acme/b", "acme/a"}))
+	assert.Equal(t, []string{"acme/a", "acme/b"}, state.Expansion("org:acme").Repos)
+
+	assert.Equal(t, 1, state.SetExpansion("org:acme", []string{"acme/a", "acme/c"}))
+	assert.Equal(t, []string{"acme/a", "acme/c"}, state.Expansion("org:acme").Repos)
+	assert.Len(t, state.Expansions, 1)
+	assert.Nil(t, state.Expansion("org:other"))
+}
+
+func TestItReadsTheExpandedReposOfOrgsInReposFile(t *testing.T) {
+	testsupport.PrepareTempCampaign(false)
+	writeFile("repos.txt", "org:acme language:go\nacme/api\nother/repo1\n")
+
+	_, err := ReadReposFile("repos.txt")
+	assert.EqualError(t, err, "org:acme language:go in repos.txt file has not been expanded into its repos yet, so run turbolift clone first")
+
+	state, _ := ReadState()
+	state.SetExpansion("org:acme language:go", []string{"acme/api", "acme/cli"})
+	assert.NoError(t, state.Save())
+
+	repos, err := ReadReposFile("repos.txt")
+	assert.NoError(t, err)
+	var names []string
+	for _, repo := range repos {
+		names = append(names, repo.FullRepoName)
+	}
+	assert.Equal(t, []string{"acme/api", "acme/cli", "other/repo1"}, names)
+
+	patterns, err := OrgPatterns("repos.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, []OrgPattern{{Line: "org:acme language:go", Org: "acme", Language: "go"}}, patterns)
+}
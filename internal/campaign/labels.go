@@ -0,0 +1,73 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package campaign
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// LabelsFor returns the labels whose patterns in the campaign's label mapping match any of the given changed files.
+// The result is sorted, and empty if no labels are configured.
+func (c *Campaign) LabelsFor(changedFiles []string) []string {
+	labels := []string{}
+	for label, patterns := range c.Config.Labels {
+		if anyFileMatches(patterns, changedFiles) {
+			labels = append(labels, label)
+		}
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+func anyFileMatches(patterns []string, files []string) bool {
+	for _, pattern := range patterns {
+		for _, file := range files {
+			if matchesPattern(pattern, file) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchesPattern matches a repo-relative file path against a label pattern. Patterns ending in /** match anything
+// beneath that directory, patterns without a slash match the file name in any directory, and any other patterns
+// are matched against the whole path.
+func matchesPattern(pattern string, file string) bool {
+	if dir := strings.TrimSuffix(pattern, "/**"); dir != pattern {
+		return strings.HasPrefix(file, dir+"/")
+	}
+	if !strings.Contains(pattern, "/") {
+		matched, _ := path.Match(pattern, path.Base(file))
+		return matched
+	}
+	matched, _ := path.Match(pattern, file)
+	return matched
+}
+
+func validateLabelPatterns(labels map[string][]string, filename string) error {
+	for label, patterns := range labels {
+		for _, pattern := range patterns {
+			if _, err := path.Match(strings.TrimSuffix(pattern, "/**"), ""); err != nil {
+				return fmt.Errorf("invalid pattern %s for label %s in %s", pattern, label, filename)
+			}
+		}
+	}
+	return nil
+}
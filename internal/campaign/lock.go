@@ -0,0 +1,146 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package campaign
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const lockFilename = "lock"
+
+// StaleLockAge is how old a lock must be before it is taken to have been left behind by a command that never finished,
+// when it was taken on another machine and so cannot be checked
+const StaleLockAge = 24 * time.Hour
+
+// LockHolder is the command that holds a campaign's lock
+type LockHolder struct {
+	Command  string    `json:"command"`
+	Pid      int       `json:"pid"`
+	Host     string    `json:"host"`
+	Acquired time.Time `json:"acquired"`
+}
+
+func (h LockHolder) String() string {
+	return fmt.Sprintf("%s (pid %d on %s, since %s)", h.Command, h.Pid, h.Host, h.Acquired.Local().Format("15:04 on 2 Jan"))
+}
+
+// stale is true if the command that took the lock can no longer be running
+func (h LockHolder) stale() bool {
+	if host, _ := os.Hostname(); h.Host == host {
+		return !processRunning(h.Pid)
+	}
+	return time.Since(h.Acquired) > StaleLockAge
+}
+
+// LockedError is returned when another command that makes changes is already running in the campaign
+type LockedError struct {
+	Holder LockHolder
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("the campaign is in use by %s - wait for it to finish, or use --force if it is no longer running", e.Holder)
+}
+
+// Lock stops other commands that make changes from running in the campaign at the same time, which could corrupt its
+// working copies or raise duplicate PRs
+type Lock struct {
+	filename string
+	// Replaced is the holder of a lock that was left behind or overridden in taking this one, if there was one
+	Replaced *LockHolder
+}
+
+// AcquireLock takes the lock of the campaign in the current directory for the command. Locks left behind by commands
+// that are no longer running are taken over, as are any others if force is set.
+func AcquireLock(command string, force bool) (*Lock, error) {
+	if err := os.MkdirAll(StateDirectory, os.ModeDir|0o755); err != nil {
+		return nil, fmt.Errorf("unable to create %s: %w", StateDirectory, err)
+	}
+	host, _ := os.Hostname()
+	holder := LockHolder{Command: command, Pid: os.Getpid(), Host: host, Acquired: time.Now().UTC()}
+	lock := &Lock{filename: filepath.Join(StateDirectory, lockFilename)}
+
+	err := writeLock(lock.filename, holder)
+	if !errors.Is(err, os.ErrExist) {
+		return lock, err
+	}
+
+	existing, readErr := readLock(lock.filename)
+	// a lock that cannot be read is treated as left behind, as there is no one to wait for
+	if readErr == nil && !force && !existing.stale() {
+		return nil, &LockedError{Holder: existing}
+	}
+	if readErr == nil {
+		lock.Replaced = &existing
+	}
+	if err := os.Remove(lock.filename); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("unable to remove the campaign lock: %w", err)
+	}
+	// another command may have taken over the lock at the same time, in which case it is the one that gets it
+	if err := writeLock(lock.filename, holder); errors.Is(err, os.ErrExist) {
+		if existing, readErr := readLock(lock.filename); readErr == nil {
+			return nil, &LockedError{Holder: existing}
+		}
+		return nil, errors.New("the campaign is in use by another command")
+	} else if err != nil {
+		return nil, err
+	}
+	return lock, nil
+}
+
+// Release gives up the lock, so that other commands can run in the campaign
+func (l *Lock) Release() error {
+	if err := os.Remove(l.filename); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("unable to release the campaign lock: %w", err)
+	}
+	return nil
+}
+
+// writeLock creates the lock file, failing with os.ErrExist if it is already there
+func writeLock(filename string, holder LockHolder) error {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		if os.IsExist(err) {
+			return os.ErrExist
+		}
+		return fmt.Errorf("unable to create the campaign lock: %w", err)
+	}
+	contents, err := json.Marshal(holder)
+	if err == nil {
+		_, err = file.Write(append(contents, '\n'))
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("unable to write the campaign lock: %w", err)
+	}
+	return nil
+}
+
+func readLock(filename string) (LockHolder, error) {
+	var holder LockHolder
+	contents, err := os.ReadFile(filename)
+	if err != nil {
+		return holder, err
+	}
+	err = json.Unmarshal(contents, &holder)
+	return holder, err
+}
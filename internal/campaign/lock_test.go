@@ -0,0 +1,106 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package campaign
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/testsupport"
+)
+
+func TestItLocksTheCampaignUntilReleased(t *testing.T) {
+	testsupport.PrepareTempCampaign(false)
+
+	lock, err := AcquireLock("turbolift foreach", false)
+	assert.NoError(t, err)
+	assert.Nil(t, lock.Replaced)
+
+	_, err = AcquireLock("turbolift create-prs", false)
+	var locked *LockedError
+	assert.True(t, errors.As(err, &locked))
+	assert.Equal(t, "turbolift foreach", locked.Holder.Command)
+	assert.Equal(t, os.Getpid(), locked.Holder.Pid)
+	assert.Contains(t, err.Error(), "the campaign is in use by turbolift foreach (pid ")
+
+	assert.NoError(t, lock.Release())
+	lock, err = AcquireLock("turbolift create-prs", false)
+	assert.NoError(t, err)
+	assert.NoError(t, lock.Release())
+}
+
+func TestItTakesOverLocksFromCommandsThatAreNoLongerRunning(t *testing.T) {
+	testsupport.PrepareTempCampaign(false)
+	host, _ := os.Hostname()
+	// pids are never this large, so no process can be running with it
+	writeTestLock(t, LockHolder{Command: "turbolift clone", Pid: 1 << 30, Host: host, Acquired: time.Now()})
+
+	lock, err := AcquireLock("turbolift foreach", false)
+	assert.NoError(t, err)
+	assert.Equal(t, "turbolift clone", lock.Replaced.Command)
+	assert.NoError(t, lock.Release())
+}
+
+func TestItOnlyTakesOverLocksFromOtherMachinesOnceTheyAreOld(t *testing.T) {
+	testsupport.PrepareTempCampaign(false)
+	writeTestLock(t, LockHolder{Command: "turbolift clone", Pid: 1, Host: "elsewhere", Acquired: time.Now().Add(-time.Hour)})
+
+	_, err := AcquireLock("turbolift foreach", false)
+	assert.Error(t, err)
+
+	writeTestLock(t, LockHolder{Command: "turbolift clone", Pid: 1, Host: "elsewhere", Acquired: time.Now().Add(-StaleLockAge - time.Hour)})
+	lock, err := AcquireLock("turbolift foreach", false)
+	assert.NoError(t, err)
+	assert.Equal(t, "elsewhere", lock.Replaced.Host)
+	assert.NoError(t, lock.Release())
+}
+
+func TestItTakesOverLocksWhenForced(t *testing.T) {
+	testsupport.PrepareTempCampaign(false)
+	writeTestLock(t, LockHolder{Command: "turbolift clone", Pid: 1, Host: "elsewhere", Acquired: time.Now()})
+
+	lock, err := AcquireLock("turbolift foreach", true)
+	assert.NoError(t, err)
+	assert.Equal(t, "turbolift clone", lock.Replaced.Command)
+
+	holder, err := readLock(filepath.Join(StateDirectory, lockFilename))
+	assert.NoError(t, err)
+	assert.Equal(t, "turbolift foreach", holder.Command)
+	assert.NoError(t, lock.Release())
+}
+
+func TestItTakesOverLocksThatCannotBeRead(t *testing.T) {
+	testsupport.PrepareTempCampaign(false)
+	_ = os.MkdirAll(StateDirectory, 0o755)
+	_ = os.WriteFile(filepath.Join(StateDirectory, lockFilename), []byte("{not json"), 0o644)
+
+	lock, err := AcquireLock("turbolift foreach", false)
+	assert.NoError(t, err)
+	assert.Nil(t, lock.Replaced)
+	assert.NoError(t, lock.Release())
+}
+
+func writeTestLock(t *testing.T, holder LockHolder) {
+	assert.NoError(t, os.MkdirAll(StateDirectory, 0o755))
+	contents, _ := json.Marshal(holder)
+	assert.NoError(t, os.WriteFile(filepath.Join(StateDirectory, lockFilename), contents, 0o644))
+}
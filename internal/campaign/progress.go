@@ -0,0 +1,96 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package campaign
+
+import (
+	"fmt"
+	"time"
+)
+
+// Progress keeps track of the repos that a run of a command has finished with, so that if the run is interrupted,
+// a checkpoint can be saved in the campaign state and a later run can pick up from it
+type Progress struct {
+	command   string
+	branch    string
+	previous  map[string]bool
+	completed []string
+}
+
+// NewProgress starts tracking a command's progress on a branch. If resume is true, the repos completed before the
+// command was last interrupted are loaded from its checkpoint, and it is an error if there is no checkpoint.
+func NewProgress(command string, branch string, resume bool) (*Progress, error) {
+	progress := &Progress{
+		command:  command,
+		branch:   branch,
+		previous: map[string]bool{},
+	}
+	if !resume {
+		return progress, nil
+	}
+
+	state, err := ReadState()
+	if err != nil {
+		return nil, err
+	}
+	checkpoint, ok := state.Checkpoint(command, branch)
+	if !ok {
+		return nil, fmt.Errorf("there is no interrupted run of %s on branch %s to resume", command, branch)
+	}
+	for _, repo := range checkpoint.Completed {
+		progress.previous[repo] = true
+		progress.completed = append(progress.completed, repo)
+	}
+	return progress, nil
+}
+
+// Resumed is the number of repos completed before the command was interrupted
+func (p *Progress) Resumed() int {
+	return len(p.previous)
+}
+
+// AlreadyCompleted is true if the repo was completed before the command was interrupted, so can be left alone
+func (p *Progress) AlreadyCompleted(repo Repo) bool {
+	return p.previous[repo.FullRepoName]
+}
+
+// Complete records that the command has finished with the repo
+func (p *Progress) Complete(repo Repo) {
+	p.completed = append(p.completed, repo.FullRepoName)
+}
+
+// End saves a checkpoint of the completed repos if the run was interrupted. Otherwise the run got to the end, so any
+// earlier checkpoint is no longer needed and is removed.
+func (p *Progress) End(interrupted bool) error {
+	state, err := ReadState()
+	if err != nil {
+		return err
+	}
+
+	if interrupted {
+		state.SetCheckpoint(Checkpoint{
+			Command:   p.command,
+			Branch:    p.branch,
+			Completed: p.completed,
+			Saved:     time.Now(),
+		})
+		return state.Save()
+	}
+
+	if state.RemoveCheckpoint(p.command, p.branch) {
+		return state.Save()
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package campaign
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/testsupport"
+)
+
+func TestItSavesCheckpointWhenInterrupted(t *testing.T) {
+	testsupport.PrepareTempCampaign(false, "org/repo1", "org/repo2")
+
+	progress, err := NewProgress("create-prs", "my-campaign", false)
+	assert.NoError(t, err)
+	progress.Complete(Repo{FullRepoName: "org/repo1"})
+	assert.NoError(t, progress.End(true))
+
+	state, _ := ReadState()
+	checkpoint, ok := state.Checkpoint("create-prs", "my-campaign")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"org/repo1"}, checkpoint.Completed)
+
+	_, ok = state.Checkpoint("create-prs", "my-campaign-v2")
+	assert.False(t, ok)
+}
+
+func TestItResumesFromCheckpoint(t *testing.T) {
+	testsupport.PrepareTempCampaign(false, "org/repo1", "org/repo2", "org/repo3")
+
+	progress, _ := NewProgress("commit", "my-campaign", false)
+	progress.Complete(Repo{FullRepoName: "org/repo1"})
+	assert.NoError(t, progress.End(true))
+
+	progress, err := NewProgress("commit", "my-campaign", true)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, progress.Resumed())
+	assert.True(t, progress.AlreadyCompleted(Repo{FullRepoName: "org/repo1"}))
+	assert.False(t, progress.AlreadyCompleted(Repo{FullRepoName: "org/repo2"}))
+
+	// the checkpoint saved by a second interruption includes the repos completed by the first
+	progress.Complete(Repo{FullRepoName: "org/repo2"})
+	assert.NoError(t, progress.End(true))
+
+	state, _ := ReadState()
+	checkpoint, _ := state.Checkpoint("commit", "my-campaign")
+	assert.Equal(t, []string{"org/repo1", "org/repo2"}, checkpoint.Completed)
+}
+
+func TestItRemovesCheckpointOnceRunToTheEnd(t *testing.T) {
+	testsupport.PrepareTempCampaign(false, "org/repo1")
+
+	progress, _ := NewProgress("commit", "my-campaign", false)
+	assert.NoError(t, progress.End(true))
+
+	progress, _ = NewProgress("commit", "my-campaign", true)
+	progress.Complete(Repo{FullRepoName: "org/repo1"})
+	assert.NoError(t, progress.End(false))
+
+	state, _ := ReadState()
+	assert.Empty(t, state.Checkpoints)
+
+	_, err := NewProgress("commit", "my-campaign", true)
+	assert.EqualError(t, err, "there is no interrupted run of commit on branch my-campaign to resume")
+}
@@ -0,0 +1,296 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package campaign
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// repoEntry is one repo in a structured (JSON, YAML or CSV) repos file
+type repoEntry struct {
+	Repo          string            `json:"repo" yaml:"repo"`
+	Host          string            `json:"host,omitempty" yaml:"host"`
+	DefaultBranch string            `json:"default-branch,omitempty" yaml:"default-branch"`
+	Skip          bool              `json:"skip,omitempty" yaml:"skip"`
+	Forge         string            `json:"forge,omitempty" yaml:"forge"`
+	Path          string            `json:"path,omitempty" yaml:"path"`
+	Vars          map[string]string `json:"vars,omitempty" yaml:"vars"`
+}
+
+// ReadReposFile reads the list of repos in the format given by the file's extension: .json, .yaml/.yml, .csv,
+// or otherwise one repo per line. Repos marked to be skipped are left out.
+func ReadReposFile(filename string) ([]Repo, error) {
+	var repos []Repo
+	var err error
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		repos, err = readStructuredReposFile(filename, json.Unmarshal)
+	case ".yaml", ".yml":
+		repos, err = readStructuredReposFile(filename, yaml.Unmarshal)
+	case ".csv":
+		repos, err = readReposCsvFile(filename)
+	default:
+		repos, err = readReposTxtFile(filename)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPaths(filename, repos); err != nil {
+		return nil, err
+	}
+	return repos, nil
+}
+
+// WriteReposFile writes the repos to a JSON repos file, keeping their metadata, so that a command can be run over just
+// some of a campaign's repos
+func WriteReposFile(filename string, repos []Repo) error {
+	entries := []repoEntry{}
+	for _, repo := range repos {
+		entries = append(entries, repoEntry{
+			Repo:          repo.FullRepoName,
+			DefaultBranch: repo.DefaultBranch,
+			Forge:         repo.Forge,
+			Path:          repo.Path,
+			Vars:          repo.Vars,
+		})
+	}
+
+	contents, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filename, append(contents, '\n'), 0o644); err != nil {
+		return fmt.Errorf("unable to write %s file: %w", filename, err)
+	}
+	return nil
+}
+
+// RenameReposInFile rewrites a repos file of any format so that it lists renamed repos under their new names,
+// leaving everything else in the file, such as comments and metadata, as it was
+func RenameReposInFile(filename string, renames []Rename) error {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return fmt.Errorf("unable to open repo file: %s", filename)
+	}
+	contents, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("unable to open repo file: %s", filename)
+	}
+
+	structured := isStructuredReposFile(filename)
+
+	updated := string(contents)
+	for _, rename := range renames {
+		if !structured {
+			// repos scoped to a path keep it
+			pattern := regexp.MustCompile(`(?m)^([ \t]*)` + regexp.QuoteMeta(rename.From) + `((?://[^ \t]*)?[ \t]*)$`)
+			updated = pattern.ReplaceAllString(updated, "${1}"+rename.To+"${2}")
+			continue
+		}
+
+		// a structured file may give the host in a field of its own, leaving only org/repo in the repo field
+		names := [][2]string{{rename.From, rename.To}}
+		if from, to := withoutHost(rename.From), withoutHost(rename.To); from != rename.From {
+			names = append(names, [2]string{from, to})
+		}
+		for _, name := range names {
+			pattern := regexp.MustCompile(`(^|[^\w./-])` + regexp.QuoteMeta(name[0]) + `($|[^\w./-])`)
+			updated = pattern.ReplaceAllString(updated, "${1}"+name[1]+"${2}")
+		}
+	}
+
+	if err := os.WriteFile(filename, []byte(updated), info.Mode().Perm()); err != nil {
+		return fmt.Errorf("unable to update %s file: %w", filename, err)
+	}
+	return nil
+}
+
+// DropReposFromFile comments out the given repos in a plain repos file, so that later commands leave them out of the
+// campaign while keeping a record of them. Structured repos files are not rewritten: their repos should be marked
+// with skip instead.
+func DropReposFromFile(filename string, fullRepoNames []string) error {
+	if isStructuredReposFile(filename) {
+		return fmt.Errorf("unable to drop repos from %s: mark them to be skipped instead", filename)
+	}
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		return fmt.Errorf("unable to open repo file: %s", filename)
+	}
+	contents, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("unable to open repo file: %s", filename)
+	}
+
+	updated := string(contents)
+	for _, name := range fullRepoNames {
+		pattern := regexp.MustCompile(`(?m)^[ \t]*(` + regexp.QuoteMeta(name) + `(?://[^ \t]*)?)[ \t]*$`)
+		updated = pattern.ReplaceAllString(updated, "# ${1}")
+	}
+
+	if err := os.WriteFile(filename, []byte(updated), info.Mode().Perm()); err != nil {
+		return fmt.Errorf("unable to update %s file: %w", filename, err)
+	}
+	return nil
+}
+
+// isStructuredReposFile is true for repos files in JSON, YAML or CSV, rather than with one repo per line
+func isStructuredReposFile(filename string) bool {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json", ".yaml", ".yml", ".csv":
+		return true
+	}
+	return false
+}
+
+// withoutHost turns host/org/repo into org/repo, and leaves org/repo as it is
+func withoutHost(fullRepoName string) string {
+	parts := strings.Split(fullRepoName, "/")
+	if len(parts) == 3 {
+		return parts[1] + "/" + parts[2]
+	}
+	return fullRepoName
+}
+
+func readStructuredReposFile(filename string, unmarshal func([]byte, interface{}) error) ([]Repo, error) {
+	contents, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open repo file: %s", filename)
+	}
+
+	var entries []repoEntry
+	if err := unmarshal(contents, &entries); err != nil {
+		return nil, fmt.Errorf("unable to parse %s file: %w", filename, err)
+	}
+
+	return reposFromEntries(filename, entries)
+}
+
+// readReposCsvFile reads a CSV file with a header row. The repo column is required, the host, default-branch, skip
+// and forge columns are optional, and any other columns are made available as vars.
+func readReposCsvFile(filename string) ([]Repo, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open repo file: %s", filename)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	reader := csv.NewReader(file)
+	reader.Comment = '#'
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s file: %w", filename, err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	header := records[0]
+	repoColumn := -1
+	for i, column := range header {
+		header[i] = strings.TrimSpace(column)
+		if header[i] == "repo" {
+			repoColumn = i
+		}
+	}
+	if repoColumn == -1 {
+		return nil, fmt.Errorf("no repo column in %s file", filename)
+	}
+
+	var entries []repoEntry
+	for _, record := range records[1:] {
+		entry := repoEntry{}
+		for i, value := range record {
+			value = strings.TrimSpace(value)
+			switch header[i] {
+			case "repo":
+				entry.Repo = value
+			case "host":
+				entry.Host = value
+			case "default-branch":
+				entry.DefaultBranch = value
+			case "forge":
+				entry.Forge = value
+			case "path":
+				entry.Path = value
+			case "skip":
+				if value != "" {
+					if entry.Skip, err = strconv.ParseBool(value); err != nil {
+						return nil, fmt.Errorf("unable to parse skip value %s for %s in %s file", value, entry.Repo, filename)
+					}
+				}
+			default:
+				if entry.Vars == nil {
+					entry.Vars = map[string]string{}
+				}
+				entry.Vars[header[i]] = value
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	return reposFromEntries(filename, entries)
+}
+
+func reposFromEntries(filename string, entries []repoEntry) ([]Repo, error) {
+	uniq := map[string]interface{}{}
+	var repos []Repo
+	for _, entry := range entries {
+		if entry.Repo == "" {
+			return nil, fmt.Errorf("an entry in %s file has no repo", filename)
+		}
+
+		name := entry.Repo
+		if entry.Host != "" {
+			name = entry.Host + "/" + entry.Repo
+		}
+		if entry.Path != "" {
+			name = name + "//" + entry.Path
+		}
+
+		repo, err := parseRepoName(name)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse entry in %s file: %s", filename, name)
+		}
+		if entry.Skip {
+			continue
+		}
+		if !isKnownForge(entry.Forge) {
+			return nil, fmt.Errorf("unknown forge %s for %s in %s file", entry.Forge, name, filename)
+		}
+		if _, seen := uniq[repo.ListedName()]; seen {
+			continue
+		}
+		uniq[repo.ListedName()] = struct{}{}
+
+		repo.DefaultBranch = entry.DefaultBranch
+		repo.Vars = entry.Vars
+		repo.Forge = entry.Forge
+		repos = append(repos, repo)
+	}
+	return repos, nil
+}
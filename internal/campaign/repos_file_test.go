@@ -0,0 +1,299 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package campaign
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/testsupport"
+)
+
+func TestItReadsReposWithMetadataFromJsonFile(t *testing.T) {
+	testsupport.PrepareTempCampaign(false)
+	writeFile("repos.json", `[
+  {"repo": "org/repo1", "default-branch": "develop", "vars": {"team": "payments"}},
+  {"repo": "org/repo2", "host": "github.example.com"},
+  {"repo": "org/repo3", "skip": true}
+]`)
+
+	repos, err := ReadReposFile("repos.json")
+	assert.NoError(t, err)
+
+	assert.Equal(t, []Repo{
+		{
+			OrgName:       "org",
+			RepoName:      "repo1",
+			FullRepoName:  "org/repo1",
+			DefaultBranch: "develop",
+			Vars:          map[string]string{"team": "payments"},
+		},
+		{
+			Host:         "github.example.com",
+			OrgName:      "org",
+			RepoName:     "repo2",
+			FullRepoName: "github.example.com/org/repo2",
+		},
+	}, repos)
+}
+
+func TestItReadsReposWithMetadataFromYamlFile(t *testing.T) {
+	testsupport.PrepareTempCampaign(false)
+	writeFile("repos.yaml", `
+- repo: org/repo1
+  vars:
+    team: payments
+- repo: org/repo1
+- repo: org/repo2
+  skip: true
+`)
+
+	repos, err := ReadReposFile("repos.yaml")
+	assert.NoError(t, err)
+
+	assert.Equal(t, []Repo{
+		{
+			OrgName:      "org",
+			RepoName:     "repo1",
+			FullRepoName: "org/repo1",
+			Vars:         map[string]string{"team": "payments"},
+		},
+	}, repos)
+}
+
+func TestItReadsReposWithMetadataFromCsvFile(t *testing.T) {
+	testsupport.PrepareTempCampaign(false)
+	writeFile("repos.csv", `repo,default-branch,skip,team
+# comments are ignored
+org/repo1,develop,,payments
+org/repo2,,true,search
+org/repo3,,false,
+`)
+
+	repos, err := ReadReposFile("repos.csv")
+	assert.NoError(t, err)
+
+	assert.Equal(t, []Repo{
+		{
+			OrgName:       "org",
+			RepoName:      "repo1",
+			FullRepoName:  "org/repo1",
+			DefaultBranch: "develop",
+			Vars:          map[string]string{"team": "payments"},
+		},
+		{
+			OrgName:      "org",
+			RepoName:     "repo3",
+			FullRepoName: "org/repo3",
+			Vars:         map[string]string{"team": ""},
+		},
+	}, repos)
+}
+
+func TestItRejectsCsvFileWithoutRepoColumn(t *testing.T) {
+	testsupport.PrepareTempCampaign(false)
+	writeFile("repos.csv", "name,team\norg/repo1,payments\n")
+
+	_, err := ReadReposFile("repos.csv")
+	assert.EqualError(t, err, "no repo column in repos.csv file")
+}
+
+func TestItRejectsStructuredEntriesWithoutRepo(t *testing.T) {
+	testsupport.PrepareTempCampaign(false)
+	writeFile("repos.json", `[{"host": "github.example.com"}]`)
+
+	_, err := ReadReposFile("repos.json")
+	assert.EqualError(t, err, "an entry in repos.json file has no repo")
+}
+
+func TestItReadsTheForgeOfEachRepo(t *testing.T) {
+	testsupport.PrepareTempCampaign(false)
+	writeFile("repos.yaml", `
+- repo: org/repo1
+- repo: PROJ/repo2
+  host: bitbucket.example.com
+  forge: bitbucket
+`)
+
+	repos, err := ReadReposFile("repos.yaml")
+	assert.NoError(t, err)
+	assert.False(t, repos[0].OnBitbucket())
+	assert.True(t, repos[1].OnBitbucket())
+
+	writeFile("repos.yaml", `
+- repo: org/repo1
+  forge: sourceforge
+`)
+	_, err = ReadReposFile("repos.yaml")
+	assert.EqualError(t, err, "unknown forge sourceforge for org/repo1 in repos.yaml file")
+}
+
+func TestItReadsReposScopedToAPath(t *testing.T) {
+	testsupport.PrepareTempCampaign(false)
+	writeFile("repos.txt", "org/monorepo//services/foo/\ngithub.example.com/org/repo2\n")
+
+	repos, err := ReadReposFile("repos.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "org/monorepo", repos[0].FullRepoName)
+	assert.Equal(t, "services/foo", repos[0].Path)
+	assert.Equal(t, "work/org/monorepo/services/foo", repos[0].WorkingDir())
+	assert.Equal(t, "org/monorepo//services/foo", repos[0].ListedName())
+	assert.Equal(t, "", repos[1].Path)
+	assert.Equal(t, "work/org/repo2", repos[1].WorkingDir())
+
+	writeFile("repos.yaml", "- repo: org/monorepo\n  path: services/bar\n")
+	repos, err = ReadReposFile("repos.yaml")
+	assert.NoError(t, err)
+	assert.Equal(t, "services/bar", repos[0].Path)
+
+	writeFile("repos.yaml", "- repo: org/monorepo\n  path: services\\baz\n")
+	repos, err = ReadReposFile("repos.yaml")
+	assert.NoError(t, err)
+	assert.Equal(t, "services/baz", repos[0].Path)
+}
+
+func TestItRejectsInvalidRepoPaths(t *testing.T) {
+	testsupport.PrepareTempCampaign(false)
+
+	writeFile("repos.txt", "org/monorepo//\n")
+	_, err := ReadReposFile("repos.txt")
+	assert.Error(t, err)
+
+	writeFile("repos.txt", "org/monorepo//../elsewhere\n")
+	_, err = ReadReposFile("repos.txt")
+	assert.Error(t, err)
+
+	writeFile("repos.txt", "org/monorepo//services/foo\norg/monorepo//services/bar\n")
+	_, err = ReadReposFile("repos.txt")
+	assert.EqualError(t, err, "org/monorepo is listed more than once in repos.txt file, with different paths")
+}
+
+func TestItKeepsPathsWhenRenamingAndDroppingRepos(t *testing.T) {
+	testsupport.PrepareTempCampaign(false)
+	writeFile("repos.txt", "org/monorepo//services/foo\norg/repo2//lib\n")
+
+	assert.NoError(t, RenameReposInFile("repos.txt", []Rename{{From: "org/monorepo", To: "neworg/monorepo"}}))
+	assert.NoError(t, DropReposFromFile("repos.txt", []string{"org/repo2"}))
+
+	contents, _ := os.ReadFile("repos.txt")
+	assert.Equal(t, "neworg/monorepo//services/foo\n# org/repo2//lib\n", string(contents))
+}
+
+func TestItRenamesReposInTextFile(t *testing.T) {
+	testsupport.PrepareTempCampaign(false)
+	writeFile("repos.txt", "# payments\norg/repo1\norg/repo10\ngithub.example.com/org/repo2\n")
+
+	err := RenameReposInFile("repos.txt", []Rename{
+		{From: "org/repo1", To: "neworg/repo1"},
+		{From: "github.example.com/org/repo2", To: "github.example.com/org/renamed2"},
+	})
+	assert.NoError(t, err)
+
+	contents, _ := os.ReadFile("repos.txt")
+	assert.Equal(t, "# payments\nneworg/repo1\norg/repo10\ngithub.example.com/org/renamed2\n", string(contents))
+}
+
+func TestItRenamesReposInStructuredFile(t *testing.T) {
+	testsupport.PrepareTempCampaign(false)
+	writeFile("repos.yaml", `
+# payments team
+- repo: org/repo1
+  vars:
+    team: payments
+- repo: org/repo1-legacy
+- repo: org/repo2
+  host: github.example.com
+`)
+
+	err := RenameReposInFile("repos.yaml", []Rename{
+		{From: "org/repo1", To: "neworg/repo1"},
+		{From: "github.example.com/org/repo2", To: "github.example.com/org/renamed2"},
+	})
+	assert.NoError(t, err)
+
+	repos, err := ReadReposFile("repos.yaml")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"neworg/repo1", "org/repo1-legacy", "github.example.com/org/renamed2"}, fullRepoNames(repos))
+	contents, _ := os.ReadFile("repos.yaml")
+	assert.Contains(t, string(contents), "# payments team")
+}
+
+func TestItDropsReposFromTextFile(t *testing.T) {
+	testsupport.PrepareTempCampaign(false)
+	writeFile("repos.txt", "org/repo1\norg/repo10\ngithub.example.com/org/repo2\n")
+
+	err := DropReposFromFile("repos.txt", []string{"org/repo1", "github.example.com/org/repo2"})
+	assert.NoError(t, err)
+
+	repos, err := ReadReposFile("repos.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"org/repo10"}, fullRepoNames(repos))
+	contents, _ := os.ReadFile("repos.txt")
+	assert.Equal(t, "# org/repo1\norg/repo10\n# github.example.com/org/repo2\n", string(contents))
+}
+
+func TestItDoesNotDropReposFromStructuredFile(t *testing.T) {
+	testsupport.PrepareTempCampaign(false)
+	writeFile("repos.yaml", "- repo: org/repo1\n")
+
+	err := DropReposFromFile("repos.yaml", []string{"org/repo1"})
+	assert.EqualError(t, err, "unable to drop repos from repos.yaml: mark them to be skipped instead")
+}
+
+func TestItRendersTemplatesForRepo(t *testing.T) {
+	repo := Repo{OrgName: "org", RepoName: "repo1", FullRepoName: "org/repo1", Vars: map[string]string{"team": "payments"}}
+
+	rendered, err := RenderForRepo("Hello {{ .Vars.team }}, this is for {{ .FullRepoName }}{{ .Vars.missing }}", repo)
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello payments, this is for org/repo1", rendered)
+
+	rendered, err = RenderForRepo("No templating here", repo)
+	assert.NoError(t, err)
+	assert.Equal(t, "No templating here", rendered)
+
+	_, err = RenderForRepo("Broken {{ .Vars.team", repo)
+	assert.Error(t, err)
+}
+
+func writeFile(filename string, contents string) {
+	if err := os.WriteFile(filename, []byte(contents), 0o644); err != nil {
+		panic(err)
+	}
+}
+
+func fullRepoNames(repos []Repo) []string {
+	names := []string{}
+	for _, repo := range repos {
+		names = append(names, repo.FullRepoName)
+	}
+	return names
+}
+
+func TestItWritesReposWithTheirMetadata(t *testing.T) {
+	testsupport.PrepareTempCampaign(false)
+	repos := []Repo{
+		{OrgName: "org", RepoName: "repo1", FullRepoName: "org/repo1", DefaultBranch: "develop", Vars: map[string]string{"team": "payments"}},
+		{Host: "bitbucket.example.com", OrgName: "PROJ", RepoName: "repo2", FullRepoName: "bitbucket.example.com/PROJ/repo2", Forge: BitbucketForge},
+	}
+
+	assert.NoError(t, WriteReposFile("subset.json", repos))
+
+	read, err := ReadReposFile("subset.json")
+	assert.NoError(t, err)
+	assert.Equal(t, repos, read)
+}
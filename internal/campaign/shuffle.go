@@ -0,0 +1,47 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package campaign
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+// RandomShuffle is the CampaignOptions.Shuffle value that asks for repos to be shuffled using a newly chosen seed
+const RandomShuffle = "random"
+
+// parseShuffleSeed reads the seed given to --shuffle, choosing one if none was given
+func parseShuffleSeed(value string) (int64, error) {
+	if value == RandomShuffle {
+		return time.Now().UnixNano(), nil
+	}
+	seed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid shuffle seed %s: must be an integer", value)
+	}
+	return seed, nil
+}
+
+// shuffleRepos reorders repos pseudo-randomly, so that work is spread across orgs rather than proceeding
+// alphabetically. The same seed always gives the same order for the same repos.
+func shuffleRepos(repos []Repo, seed int64) {
+	r := rand.New(rand.NewSource(seed))
+	r.Shuffle(len(repos), func(i, j int) {
+		repos[i], repos[j] = repos[j], repos[i]
+	})
+}
@@ -0,0 +1,86 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package campaign
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/testsupport"
+)
+
+var manyRepos = []string{"a/repo1", "a/repo2", "a/repo3", "b/repo1", "b/repo2", "b/repo3", "c/repo1", "c/repo2"}
+
+func TestItShufflesReposReproduciblyWithASeed(t *testing.T) {
+	testsupport.PrepareTempCampaign(false, manyRepos...)
+
+	options := NewCampaignOptions()
+	options.Shuffle = "42"
+	first, err := OpenCampaign(options)
+	assert.NoError(t, err)
+	second, err := OpenCampaign(options)
+	assert.NoError(t, err)
+
+	assert.True(t, first.Shuffled)
+	assert.Equal(t, int64(42), first.ShuffleSeed)
+	assert.Equal(t, repoNames(first), repoNames(second))
+	assert.NotEqual(t, manyRepos, repoNames(first))
+	assert.ElementsMatch(t, manyRepos, repoNames(first))
+}
+
+func TestItDoesNotShuffleReposByDefault(t *testing.T) {
+	testsupport.PrepareTempCampaign(false, manyRepos...)
+
+	dir, err := OpenCampaign(NewCampaignOptions())
+	assert.NoError(t, err)
+
+	assert.False(t, dir.Shuffled)
+	assert.Equal(t, manyRepos, repoNames(dir))
+}
+
+func TestItChoosesASeedForRandomShuffles(t *testing.T) {
+	testsupport.PrepareTempCampaign(false, manyRepos...)
+
+	options := NewCampaignOptions()
+	options.Shuffle = RandomShuffle
+	dir, err := OpenCampaign(options)
+	assert.NoError(t, err)
+	assert.True(t, dir.Shuffled)
+
+	options.Shuffle = fmt.Sprint(dir.ShuffleSeed)
+	repeated, err := OpenCampaign(options)
+	assert.NoError(t, err)
+	assert.Equal(t, repoNames(dir), repoNames(repeated))
+}
+
+func TestItRejectsInvalidShuffleSeeds(t *testing.T) {
+	testsupport.PrepareTempCampaign(false, manyRepos...)
+
+	options := NewCampaignOptions()
+	options.Shuffle = "lots"
+	_, err := OpenCampaign(options)
+	assert.EqualError(t, err, "invalid shuffle seed lots: must be an integer")
+}
+
+func repoNames(dir *Campaign) []string {
+	names := []string{}
+	for _, repo := range dir.Repos {
+		names = append(names, repo.FullRepoName)
+	}
+	return names
+}
@@ -0,0 +1,467 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package campaign
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// StateDirectory holds turbolift's record of a campaign's progress. It is kept apart from the .turbolift marker file,
+// which only identifies the directory as a campaign.
+const StateDirectory = ".turbolift-state"
+
+const stateFilename = "state.json"
+
+// State is the information turbolift keeps about a campaign between commands
+type State struct {
+	// Branches lists the branches that the campaign has been run on, oldest first
+	Branches []string `json:"branches"`
+	// FollowUps are actions scheduled to be taken some time after the campaign, such as removing a feature flag
+	FollowUps []FollowUp `json:"followUps,omitempty"`
+	// Checkpoints record how far commands got before they were interrupted, so that they can be resumed
+	Checkpoints []Checkpoint `json:"checkpoints,omitempty"`
+	// Renames record the repos found to have been renamed or transferred since they were added to the campaign
+	Renames []Rename `json:"renames,omitempty"`
+	// LastCapture records the output captured by the latest run of turbolift foreach --capture
+	LastCapture *Capture `json:"lastCapture,omitempty"`
+	// Confirmations record the destructive commands that the campaign name has been typed to confirm
+	Confirmations []Confirmation `json:"confirmations,omitempty"`
+	// Snapshots record the state of the campaign's PRs each time turbolift stats was run, oldest first
+	Snapshots []Snapshot `json:"snapshots,omitempty"`
+	// Expansions record the repos that each org pattern in the repos file was expanded into by turbolift clone
+	Expansions []Expansion `json:"expansions,omitempty"`
+	// PullRequests record the PRs that turbolift create-prs has created or found, so that they can be looked up directly
+	PullRequests []TrackedPR `json:"pullRequests,omitempty"`
+	// Notes are the notes attached to repos with turbolift note, including those marked won't do
+	Notes []RepoNote `json:"notes,omitempty"`
+	// Nags record when turbolift update-prs --nag last reminded the reviewers of each PR
+	Nags []Nag `json:"nags,omitempty"`
+}
+
+// Nag is when the reviewers of the PR for a branch of a repo were last reminded of it
+type Nag struct {
+	Repo   string    `json:"repo"`
+	Branch string    `json:"branch"`
+	Nagged time.Time `json:"nagged"`
+}
+
+// RepoNote is a note attached to one of the campaign's repos, such as why its PR is stuck
+type RepoNote struct {
+	Repo string `json:"repo"`
+	Note string `json:"note,omitempty"`
+	// WontDo marks a repo that the campaign will not be done in, which is left out of its counts and commands
+	WontDo  bool      `json:"wontDo,omitempty"`
+	Updated time.Time `json:"updated"`
+}
+
+// TrackedPR is a PR recorded for a branch of one of the campaign's repos
+type TrackedPR struct {
+	Repo    string    `json:"repo"`
+	Branch  string    `json:"branch"`
+	Number  int       `json:"number"`
+	Url     string    `json:"url,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// FullRepoPath is the working copy of the PR's repo
+func (p TrackedPR) FullRepoPath() string {
+	repo, err := parseRepoName(p.Repo)
+	if err != nil {
+		return ""
+	}
+	return repo.FullRepoPath()
+}
+
+// Snapshot counts the campaign's repos by the state of their PRs at a point in time
+type Snapshot struct {
+	Taken     time.Time `json:"taken"`
+	Merged    int       `json:"merged"`
+	Open      int       `json:"open"`
+	Closed    int       `json:"closed"`
+	NoPR      int       `json:"noPr"`
+	NotCloned int       `json:"notCloned"`
+	Gone      int       `json:"gone"`
+	// MedianHoursToMerge is the median time between a PR being raised and merged, or zero if none have been merged
+	MedianHoursToMerge float64 `json:"medianHoursToMerge,omitempty"`
+}
+
+// Completion is the percentage of repos whose PRs have been merged. Gone repos can never be completed, so they are
+// left out.
+func (s Snapshot) Completion() int {
+	inScope := s.Merged + s.Open + s.Closed + s.NoPR + s.NotCloned
+	if inScope == 0 {
+		return 0
+	}
+	return s.Merged * 100 / inScope
+}
+
+// Confirmation is a destructive command that was confirmed by typing the campaign name, for a number of repos
+type Confirmation struct {
+	Command   string    `json:"command"`
+	Repos     int       `json:"repos"`
+	Confirmed time.Time `json:"confirmed"`
+}
+
+// Capture is a run of turbolift foreach whose output was captured to a file for each repo
+type Capture struct {
+	Command string `json:"command"`
+	// Path is the template that the capture file for each repo was named with
+	Path     string          `json:"path"`
+	Results  []CaptureResult `json:"results"`
+	Captured time.Time       `json:"captured"`
+}
+
+// CaptureResult is where the output of a command in one repo was captured, and how the command exited
+type CaptureResult struct {
+	Repo     string `json:"repo"`
+	File     string `json:"file"`
+	ExitCode int    `json:"exitCode"`
+}
+
+// Rename is a repo that was renamed or transferred to another org, as found by turbolift clone
+type Rename struct {
+	From    string    `json:"from"`
+	To      string    `json:"to"`
+	Noticed time.Time `json:"noticed"`
+}
+
+// Checkpoint lists the repos that a command had finished with on a branch when it was interrupted
+type Checkpoint struct {
+	Command   string    `json:"command"`
+	Branch    string    `json:"branch"`
+	Completed []string  `json:"completed"`
+	Saved     time.Time `json:"saved"`
+}
+
+// Record adds the result for a repo to the capture, replacing any earlier result for it
+func (c *Capture) Record(result CaptureResult) {
+	for i := range c.Results {
+		if c.Results[i].Repo == result.Repo {
+			c.Results[i] = result
+			return
+		}
+	}
+	c.Results = append(c.Results, result)
+}
+
+// FollowUp is an action scheduled with turbolift follow-up
+type FollowUp struct {
+	Id      int       `json:"id"`
+	Command string    `json:"command"`
+	Note    string    `json:"note,omitempty"`
+	Created time.Time `json:"created"`
+	Due     time.Time `json:"due"`
+	Done    bool      `json:"done,omitempty"`
+}
+
+// ReadState reads the campaign state from the current directory. A campaign without any state yet is not an error.
+func ReadState() (*State, error) {
+	return ReadStateIn(".")
+}
+
+// ReadStateIn reads the state of the campaign in the given directory
+func ReadStateIn(campaignDir string) (*State, error) {
+	state := &State{Branches: []string{}}
+
+	filename := filepath.Join(campaignDir, StateDirectory, stateFilename)
+	contents, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to read campaign state: %w", err)
+	}
+
+	if err := json.Unmarshal(contents, state); err != nil {
+		return nil, fmt.Errorf("unable to parse campaign state %s: %w", filename, err)
+	}
+	return state, nil
+}
+
+// Save writes the campaign state to the current directory
+func (s *State) Save() error {
+	if err := os.MkdirAll(StateDirectory, os.ModeDir|0o755); err != nil {
+		return fmt.Errorf("unable to create %s: %w", StateDirectory, err)
+	}
+
+	contents, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(StateDirectory, stateFilename), append(contents, '\n'), 0o644); err != nil {
+		return fmt.Errorf("unable to write campaign state: %w", err)
+	}
+	return nil
+}
+
+func (s *State) HasBranch(name string) bool {
+	for _, branch := range s.Branches {
+		if branch == name {
+			return true
+		}
+	}
+	return false
+}
+
+// AddBranch records that the campaign has been run on a branch, if it is not already known
+func (s *State) AddBranch(name string) {
+	if !s.HasBranch(name) {
+		s.Branches = append(s.Branches, name)
+	}
+}
+
+// TrackedBranches returns the branches the campaign has been run on. Campaigns cloned before branches were recorded
+// are assumed to have used the campaign's current branch.
+func (s *State) TrackedBranches(currentBranch string) []string {
+	if len(s.Branches) == 0 {
+		return []string{currentBranch}
+	}
+	return s.Branches
+}
+
+// LastActivity is the latest time recorded anywhere in the state, or the zero time if nothing has been recorded
+func (s *State) LastActivity() time.Time {
+	var times []time.Time
+	for _, followUp := range s.FollowUps {
+		times = append(times, followUp.Created)
+	}
+	for _, checkpoint := range s.Checkpoints {
+		times = append(times, checkpoint.Saved)
+	}
+	for _, rename := range s.Renames {
+		times = append(times, rename.Noticed)
+	}
+	for _, confirmation := range s.Confirmations {
+		times = append(times, confirmation.Confirmed)
+	}
+	for _, snapshot := range s.Snapshots {
+		times = append(times, snapshot.Taken)
+	}
+	for _, pr := range s.PullRequests {
+		times = append(times, pr.Created)
+	}
+	for _, note := range s.Notes {
+		times = append(times, note.Updated)
+	}
+	for _, nag := range s.Nags {
+		times = append(times, nag.Nagged)
+	}
+	if s.LastCapture != nil {
+		times = append(times, s.LastCapture.Captured)
+	}
+
+	var latest time.Time
+	for _, t := range times {
+		if t.After(latest) {
+			latest = t
+		}
+	}
+	return latest
+}
+
+// AddFollowUp schedules a follow-up action, giving it the next free id
+func (s *State) AddFollowUp(followUp FollowUp) FollowUp {
+	followUp.Id = 1
+	for _, existing := range s.FollowUps {
+		if existing.Id >= followUp.Id {
+			followUp.Id = existing.Id + 1
+		}
+	}
+	s.FollowUps = append(s.FollowUps, followUp)
+	return followUp
+}
+
+// CompleteFollowUp marks a follow-up action as done. It returns false if there is no follow-up with the id.
+func (s *State) CompleteFollowUp(id int) bool {
+	for i := range s.FollowUps {
+		if s.FollowUps[i].Id == id {
+			s.FollowUps[i].Done = true
+			return true
+		}
+	}
+	return false
+}
+
+// Checkpoint returns the checkpoint saved when the command was last interrupted on the branch, if there is one
+func (s *State) Checkpoint(command string, branch string) (Checkpoint, bool) {
+	for _, checkpoint := range s.Checkpoints {
+		if checkpoint.Command == command && checkpoint.Branch == branch {
+			return checkpoint, true
+		}
+	}
+	return Checkpoint{}, false
+}
+
+// SetCheckpoint records a checkpoint, replacing any earlier one for the same command and branch
+func (s *State) SetCheckpoint(checkpoint Checkpoint) {
+	s.RemoveCheckpoint(checkpoint.Command, checkpoint.Branch)
+	s.Checkpoints = append(s.Checkpoints, checkpoint)
+}
+
+// RemoveCheckpoint forgets the checkpoint for the command and branch. It returns false if there was none.
+func (s *State) RemoveCheckpoint(command string, branch string) bool {
+	for i, checkpoint := range s.Checkpoints {
+		if checkpoint.Command == command && checkpoint.Branch == branch {
+			s.Checkpoints = append(s.Checkpoints[:i], s.Checkpoints[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// RecordRename notes that a repo has a new name, and updates the checkpoints, PRs, notes and nags that refer to it by its
+// old one
+func (s *State) RecordRename(rename Rename) {
+	s.Renames = append(s.Renames, rename)
+	for i := range s.Checkpoints {
+		for j, repo := range s.Checkpoints[i].Completed {
+			if repo == rename.From {
+				s.Checkpoints[i].Completed[j] = rename.To
+			}
+		}
+	}
+	for i := range s.PullRequests {
+		if s.PullRequests[i].Repo == rename.From {
+			s.PullRequests[i].Repo = rename.To
+		}
+	}
+	for i := range s.Notes {
+		if s.Notes[i].Repo == rename.From {
+			s.Notes[i].Repo = rename.To
+		}
+	}
+	for i := range s.Nags {
+		if s.Nags[i].Repo == rename.From {
+			s.Nags[i].Repo = rename.To
+		}
+	}
+}
+
+// RecordNag notes that the reviewers of the PR for a branch of a repo were reminded of it, replacing any earlier nag
+func (s *State) RecordNag(nag Nag) {
+	for i := range s.Nags {
+		if s.Nags[i].Repo == nag.Repo && s.Nags[i].Branch == nag.Branch {
+			s.Nags[i] = nag
+			return
+		}
+	}
+	s.Nags = append(s.Nags, nag)
+}
+
+// LastNag returns when the reviewers of the PR for a branch of a repo were last reminded of it, if they ever were
+func (s *State) LastNag(repo string, branch string) (time.Time, bool) {
+	for _, nag := range s.Nags {
+		if nag.Repo == repo && nag.Branch == branch {
+			return nag.Nagged, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// RecordPR notes the PR for a branch of a repo, replacing any PR recorded earlier for the same repo and branch
+func (s *State) RecordPR(pr TrackedPR) {
+	for i := range s.PullRequests {
+		if s.PullRequests[i].Repo == pr.Repo && s.PullRequests[i].Branch == pr.Branch {
+			s.PullRequests[i] = pr
+			return
+		}
+	}
+	s.PullRequests = append(s.PullRequests, pr)
+}
+
+// TrackedPRs lists the PRs recorded for the branch, in the order they were recorded
+func (s *State) TrackedPRs(branch string) []TrackedPR {
+	var prs []TrackedPR
+	for _, pr := range s.PullRequests {
+		if pr.Branch == branch {
+			prs = append(prs, pr)
+		}
+	}
+	return prs
+}
+
+// TrackedPR returns the PR recorded for a branch of a repo, if there is one
+func (s *State) TrackedPR(repo string, branch string) (TrackedPR, bool) {
+	for _, pr := range s.PullRequests {
+		if pr.Repo == repo && pr.Branch == branch {
+			return pr, true
+		}
+	}
+	return TrackedPR{}, false
+}
+
+// IsConfirmed reports whether the destructive command has already been confirmed for at least this many repos
+func (s *State) IsConfirmed(command string, repos int) bool {
+	for _, confirmation := range s.Confirmations {
+		if confirmation.Command == command && confirmation.Repos >= repos {
+			return true
+		}
+	}
+	return false
+}
+
+// RecordConfirmation notes that a destructive command has been confirmed, replacing any earlier confirmation of it
+func (s *State) RecordConfirmation(confirmation Confirmation) {
+	for i := range s.Confirmations {
+		if s.Confirmations[i].Command == confirmation.Command {
+			s.Confirmations[i] = confirmation
+			return
+		}
+	}
+	s.Confirmations = append(s.Confirmations, confirmation)
+}
+
+// SetNote attaches a note to a repo, replacing any note it already had
+func (s *State) SetNote(note RepoNote) {
+	for i := range s.Notes {
+		if s.Notes[i].Repo == note.Repo {
+			s.Notes[i] = note
+			return
+		}
+	}
+	s.Notes = append(s.Notes, note)
+}
+
+// Note returns the note attached to a repo, if it has one
+func (s *State) Note(repo string) (RepoNote, bool) {
+	for _, note := range s.Notes {
+		if note.Repo == repo {
+			return note, true
+		}
+	}
+	return RepoNote{}, false
+}
+
+// RemoveNote removes the note from a repo, so that it is no longer marked won't do either. It returns false if the
+// repo had no note.
+func (s *State) RemoveNote(repo string) bool {
+	for i, note := range s.Notes {
+		if note.Repo == repo {
+			s.Notes = append(s.Notes[:i], s.Notes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// IsWontDo is true if the repo has been marked won't do
+func (s *State) IsWontDo(repo string) bool {
+	note, ok := s.Note(repo)
+	return ok && note.WontDo
+}
@@ -0,0 +1,147 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package campaign
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/testsupport"
+)
+
+func TestItReadsEmptyStateForNewCampaign(t *testing.T) {
+	testsupport.PrepareTempCampaign(false, "org/repo1")
+
+	state, err := ReadState()
+	assert.NoError(t, err)
+
+	assert.Empty(t, state.Branches)
+	assert.Equal(t, []string{"my-campaign"}, state.TrackedBranches("my-campaign"))
+}
+
+func TestItSavesAndReadsState(t *testing.T) {
+	testsupport.PrepareTempCampaign(false, "org/repo1")
+
+	state, _ := ReadState()
+	state.AddBranch("my-campaign")
+	state.AddBranch("my-campaign-v2")
+	state.AddBranch("my-campaign")
+	assert.NoError(t, state.Save())
+
+	state, err := ReadState()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"my-campaign", "my-campaign-v2"}, state.Branches)
+	assert.Equal(t, []string{"my-campaign", "my-campaign-v2"}, state.TrackedBranches("my-campaign-v2"))
+	assert.True(t, state.HasBranch("my-campaign-v2"))
+	assert.False(t, state.HasBranch("my-campaign-v3"))
+}
+
+func TestItRecordsRenamesAndUpdatesCheckpoints(t *testing.T) {
+	testsupport.PrepareTempCampaign(false, "org/repo1")
+
+	state, _ := ReadState()
+	state.SetCheckpoint(Checkpoint{Command: "commit", Branch: "my-campaign", Completed: []string{"org/repo1", "org/repo2"}})
+	state.RecordRename(Rename{From: "org/repo1", To: "neworg/repo1"})
+
+	checkpoint, ok := state.Checkpoint("commit", "my-campaign")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"neworg/repo1", "org/repo2"}, checkpoint.Completed)
+	assert.Equal(t, []Rename{{From: "org/repo1", To: "neworg/repo1"}}, state.Renames)
+}
+
+func TestItReplacesNotesAndMovesThemWhenARepoIsRenamed(t *testing.T) {
+	testsupport.PrepareTempCampaign(false, "org/repo1")
+
+	state, _ := ReadState()
+	state.SetNote(RepoNote{Repo: "org/repo1", Note: "waiting on team X"})
+	state.SetNote(RepoNote{Repo: "org/repo1", Note: "legacy", WontDo: true})
+	state.RecordRename(Rename{From: "org/repo1", To: "neworg/repo1"})
+
+	note, ok := state.Note("neworg/repo1")
+	assert.True(t, ok)
+	assert.Equal(t, "legacy", note.Note)
+	assert.True(t, state.IsWontDo("neworg/repo1"))
+	assert.False(t, state.IsWontDo("org/repo1"))
+
+	assert.True(t, state.RemoveNote("neworg/repo1"))
+	assert.False(t, state.RemoveNote("neworg/repo1"))
+	assert.Empty(t, state.Notes)
+}
+
+func TestItFindsTheLastActivityRecordedInTheState(t *testing.T) {
+	state := &State{}
+	assert.True(t, state.LastActivity().IsZero())
+
+	latest := time.Date(2021, 10, 2, 9, 0, 0, 0, time.UTC)
+	state.Snapshots = []Snapshot{{Taken: latest.AddDate(0, 0, -3)}}
+	state.Checkpoints = []Checkpoint{{Saved: latest}}
+	state.FollowUps = []FollowUp{{Created: latest.AddDate(0, 0, -1), Due: latest.AddDate(0, 0, 30)}}
+	assert.Equal(t, latest, state.LastActivity())
+}
+
+func TestItRecordsPRsAndReplacesEarlierOnesForTheSameBranch(t *testing.T) {
+	testsupport.PrepareTempCampaign(false, "org/repo1", "org/repo2")
+
+	state, _ := ReadState()
+	state.RecordPR(TrackedPR{Repo: "org/repo1", Branch: "my-campaign", Number: 1})
+	state.RecordPR(TrackedPR{Repo: "org/repo2", Branch: "my-campaign", Number: 2, Url: "https://github.com/org/repo2/pull/2"})
+	state.RecordPR(TrackedPR{Repo: "org/repo1", Branch: "my-campaign-v2", Number: 3})
+	state.RecordPR(TrackedPR{Repo: "org/repo1", Branch: "my-campaign", Number: 4})
+	assert.NoError(t, state.Save())
+
+	state, err := ReadState()
+	assert.NoError(t, err)
+	assert.Equal(t, []TrackedPR{
+		{Repo: "org/repo1", Branch: "my-campaign", Number: 4},
+		{Repo: "org/repo2", Branch: "my-campaign", Number: 2, Url: "https://github.com/org/repo2/pull/2"},
+	}, state.TrackedPRs("my-campaign"))
+
+	pr, ok := state.TrackedPR("org/repo1", "my-campaign-v2")
+	assert.True(t, ok)
+	assert.Equal(t, 3, pr.Number)
+	assert.Equal(t, "work/org/repo1", pr.FullRepoPath())
+
+	_, ok = state.TrackedPR("org/repo2", "my-campaign-v2")
+	assert.False(t, ok)
+}
+
+func TestItRecordsTheLastNagOfEachPR(t *testing.T) {
+	first := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	state := &State{}
+	state.RecordNag(Nag{Repo: "org/repo1", Branch: "my-campaign", Nagged: first})
+	state.RecordNag(Nag{Repo: "org/repo1", Branch: "my-campaign", Nagged: first.AddDate(0, 0, 7)})
+	state.RecordRename(Rename{From: "org/repo1", To: "neworg/repo1"})
+
+	nagged, ok := state.LastNag("neworg/repo1", "my-campaign")
+	assert.True(t, ok)
+	assert.Equal(t, first.AddDate(0, 0, 7), nagged)
+	assert.Len(t, state.Nags, 1)
+
+	_, ok = state.LastNag("neworg/repo1", "other-campaign")
+	assert.False(t, ok)
+}
+
+func TestItUpdatesTrackedPRsWhenARepoIsRenamed(t *testing.T) {
+	state := &State{}
+	state.RecordPR(TrackedPR{Repo: "org/repo1", Branch: "my-campaign", Number: 1})
+	state.RecordRename(Rename{From: "org/repo1", To: "neworg/repo1"})
+
+	pr, ok := state.TrackedPR("neworg/repo1", "my-campaign")
+	assert.True(t, ok)
+	assert.Equal(t, "work/neworg/repo1", pr.FullRepoPath())
+}
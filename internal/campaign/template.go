@@ -0,0 +1,63 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package campaign
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// RenderForRepo expands template actions in text, such as {{ .RepoName }} or {{ .Vars.team }}, using the repo's details.
+// Text without any template actions is returned unchanged.
+func RenderForRepo(text string, repo Repo) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+
+	parsedTemplate, err := template.New("").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse template: %w", err)
+	}
+
+	var sb strings.Builder
+	if err := parsedTemplate.Execute(&sb, repo); err != nil {
+		return "", fmt.Errorf("unable to render template for %s: %w", repo.FullRepoName, err)
+	}
+	return sb.String(), nil
+}
+
+// RenderTextForRepo expands the template actions in text for a repo if the campaign has templating turned on, and
+// otherwise returns the text unchanged
+func (c *Campaign) RenderTextForRepo(text string, repo Repo) (string, error) {
+	if !c.Config.Templating {
+		return text, nil
+	}
+	return RenderForRepo(text, repo)
+}
+
+// ChecklistForRepo renders the campaign's reviewer checklist items for a repo
+func (c *Campaign) ChecklistForRepo(repo Repo) ([]string, error) {
+	var items []string
+	for _, item := range c.Config.Checklist {
+		rendered, err := c.RenderTextForRepo(item, repo)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, rendered)
+	}
+	return items, nil
+}
@@ -0,0 +1,200 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package codemod makes simple textual changes across the files of a working copy, replacing the matches of a regular
+// expression in the files that match a set of globs, so that common campaigns need no external tools.
+package codemod
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Replacement replaces the matches of a regular expression in the files matching any of its globs
+type Replacement struct {
+	Regexp *regexp.Regexp
+	// Replace is the text that each match is replaced with, in which $1 or ${name} stand for the text of a group
+	Replace string
+	// Globs are slash-separated patterns of the files to change, relative to the directory the replacement is run in.
+	// A ** segment matches any number of directories. Every file is changed if there are none.
+	Globs []string
+}
+
+// New checks the regular expression and globs of a replacement
+func New(expr string, replace string, globs []string) (*Replacement, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression %s: %w", expr, err)
+	}
+	for _, glob := range globs {
+		if _, err := path.Match(strings.ReplaceAll(glob, "**", "*"), ""); err != nil {
+			return nil, fmt.Errorf("invalid glob %s: %w", glob, err)
+		}
+	}
+	return &Replacement{Regexp: re, Replace: replace, Globs: globs}, nil
+}
+
+// Change is one match that was replaced, or would be in a dry run
+type Change struct {
+	// Line is the line of the file that the match starts on, counting from 1
+	Line int
+	Old  string
+	New  string
+}
+
+// FileResult is what a replacement did to one file
+type FileResult struct {
+	// Path is slash-separated, relative to the directory the replacement was run in
+	Path    string
+	Changes []Change
+}
+
+// Result is what a replacement did to the files of a directory
+type Result struct {
+	Files []FileResult
+}
+
+// Replacements counts the matches replaced over all the files
+func (r Result) Replacements() int {
+	count := 0
+	for _, file := range r.Files {
+		count += len(file.Changes)
+	}
+	return count
+}
+
+// Run makes the replacement in the files under dir, leaving out git's own files, and reports the files it changed.
+// Files that look binary are left alone. In a dry run, the changes are reported without writing them.
+func (r *Replacement) Run(dir string, dryRun bool) (Result, error) {
+	result := Result{Files: []FileResult{}}
+	err := filepath.WalkDir(dir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if entry.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+		if !r.matchesFile(relPath) {
+			return nil
+		}
+
+		changes, err := r.replaceInFile(filePath, dryRun)
+		if err != nil {
+			return fmt.Errorf("unable to change %s: %w", relPath, err)
+		}
+		if len(changes) > 0 {
+			result.Files = append(result.Files, FileResult{Path: relPath, Changes: changes})
+		}
+		return nil
+	})
+	return result, err
+}
+
+func (r *Replacement) matchesFile(relPath string) bool {
+	if len(r.Globs) == 0 {
+		return true
+	}
+	for _, glob := range r.Globs {
+		if MatchGlob(glob, relPath) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Replacement) replaceInFile(filePath string, dryRun bool) ([]Change, error) {
+	contents, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	// the NUL bytes of binary files never turn up in text
+	if bytes.IndexByte(contents, 0) >= 0 {
+		return nil, nil
+	}
+
+	text := string(contents)
+	matches := r.Regexp.FindAllStringSubmatchIndex(text, -1)
+	if len(matches) == 0 {
+		return nil, nil
+	}
+
+	var changes []Change
+	var replaced strings.Builder
+	last := 0
+	for _, match := range matches {
+		expanded := string(r.Regexp.ExpandString(nil, r.Replace, text, match))
+		replaced.WriteString(text[last:match[0]])
+		replaced.WriteString(expanded)
+		last = match[1]
+		if expanded != text[match[0]:match[1]] {
+			changes = append(changes, Change{Line: strings.Count(text[:match[0]], "\n") + 1, Old: text[match[0]:match[1]], New: expanded})
+		}
+	}
+	replaced.WriteString(text[last:])
+
+	if len(changes) == 0 || dryRun {
+		return changes, nil
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return changes, os.WriteFile(filePath, []byte(replaced.String()), info.Mode().Perm())
+}
+
+// MatchGlob reports whether a slash-separated path matches a glob, in which ** matches any number of directories,
+// including none, and other segments are matched as by path.Match
+func MatchGlob(glob string, filePath string) bool {
+	return matchSegments(strings.Split(glob, "/"), strings.Split(filePath, "/"))
+}
+
+func matchSegments(glob []string, segments []string) bool {
+	if len(glob) == 0 {
+		return len(segments) == 0
+	}
+	if glob[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(glob[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	if matched, err := path.Match(glob[0], segments[0]); err != nil || !matched {
+		return false
+	}
+	return matchSegments(glob[1:], segments[1:])
+}
@@ -0,0 +1,109 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package codemod
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestItMatchesGlobsAcrossDirectories(t *testing.T) {
+	assert.True(t, MatchGlob("**/Dockerfile", "Dockerfile"))
+	assert.True(t, MatchGlob("**/Dockerfile", "services/api/Dockerfile"))
+	assert.False(t, MatchGlob("**/Dockerfile", "services/api/Dockerfile.dev"))
+	assert.True(t, MatchGlob("services/**/*.yaml", "services/api/deploy/values.yaml"))
+	assert.False(t, MatchGlob("services/*.yaml", "services/api/values.yaml"))
+	assert.True(t, MatchGlob("*.md", "README.md"))
+	assert.False(t, MatchGlob("*.md", "docs/README.md"))
+}
+
+func TestItReplacesMatchesInTheFilesMatchingTheGlobs(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "Dockerfile", "FROM ubuntu:18.04\nRUN make\n")
+	writeFile(t, dir, "services/api/Dockerfile", "FROM ubuntu:18.04 AS build\nFROM ubuntu:18.04\n")
+	writeFile(t, dir, "README.md", "Built on FROM ubuntu:18.04\n")
+	writeFile(t, dir, ".git/Dockerfile", "FROM ubuntu:18.04\n")
+
+	replacement, err := New(`FROM ubuntu:18\.04`, "FROM ubuntu:22.04", []string{"**/Dockerfile"})
+	assert.NoError(t, err)
+	result, err := replacement.Run(dir, false)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 3, result.Replacements())
+	assert.Equal(t, []FileResult{
+		{Path: "Dockerfile", Changes: []Change{{Line: 1, Old: "FROM ubuntu:18.04", New: "FROM ubuntu:22.04"}}},
+		{Path: "services/api/Dockerfile", Changes: []Change{
+			{Line: 1, Old: "FROM ubuntu:18.04", New: "FROM ubuntu:22.04"},
+			{Line: 2, Old: "FROM ubuntu:18.04", New: "FROM ubuntu:22.04"},
+		}},
+	}, result.Files)
+	assert.Equal(t, "FROM ubuntu:22.04\nRUN make\n", readFile(t, dir, "Dockerfile"))
+	assert.Equal(t, "FROM ubuntu:22.04 AS build\nFROM ubuntu:22.04\n", readFile(t, dir, "services/api/Dockerfile"))
+	assert.Equal(t, "Built on FROM ubuntu:18.04\n", readFile(t, dir, "README.md"))
+	assert.Equal(t, "FROM ubuntu:18.04\n", readFile(t, dir, ".git/Dockerfile"))
+}
+
+func TestItExpandsGroupsInTheReplacement(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "go.mod", "module example.com/app\n\ngo 1.19\n")
+
+	replacement, err := New(`(?m)^go (\d+)\.\d+$`, "go ${1}.22", nil)
+	assert.NoError(t, err)
+	result, err := replacement.Run(dir, false)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []Change{{Line: 3, Old: "go 1.19", New: "go 1.22"}}, result.Files[0].Changes)
+	assert.Equal(t, "module example.com/app\n\ngo 1.22\n", readFile(t, dir, "go.mod"))
+}
+
+func TestADryRunReportsChangesWithoutMakingThem(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "Dockerfile", "FROM ubuntu:18.04\n")
+	writeFile(t, dir, "image.png", "FROM ubuntu:18.04\x00")
+
+	replacement, err := New(`ubuntu:18\.04`, "ubuntu:22.04", nil)
+	assert.NoError(t, err)
+	result, err := replacement.Run(dir, true)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1, result.Replacements())
+	assert.Equal(t, "FROM ubuntu:18.04\n", readFile(t, dir, "Dockerfile"))
+}
+
+func TestItRejectsInvalidExpressionsAndGlobs(t *testing.T) {
+	_, err := New(`FROM (ubuntu`, "", nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid regular expression")
+
+	_, err = New(`FROM`, "", []string{"[Dockerfile"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid glob")
+}
+
+func writeFile(t *testing.T, dir string, name string, contents string) {
+	filePath := filepath.Join(dir, filepath.FromSlash(name))
+	assert.NoError(t, os.MkdirAll(filepath.Dir(filePath), 0o755))
+	assert.NoError(t, os.WriteFile(filePath, []byte(contents), 0o644))
+}
+
+func readFile(t *testing.T, dir string, name string) string {
+	contents, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+	assert.NoError(t, err)
+	return string(contents)
+}
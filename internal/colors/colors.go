@@ -15,17 +15,16 @@
 
 package colors
 
-import (
-	"github.com/fatih/color"
-)
-
-var Green = color.New(color.FgGreen).SprintFunc()
-var Cyan = color.New(color.FgCyan).SprintFunc()
-var White = color.New(color.FgWhite).SprintFunc()
-var Red = color.New(color.FgRed).SprintFunc()
-var Yellow = color.New(color.FgYellow).SprintFunc()
+// The styles of the current theme, set with SetTheme
+var (
+	Green  = current.Green
+	Cyan   = current.Cyan
+	White  = current.White
+	Red    = current.Red
+	Yellow = current.Yellow
 
-var Normal = color.New(color.Reset).SprintFunc()
-var Pass = color.New(color.BgGreen, color.FgBlack).SprintFunc()
-var Warn = color.New(color.BgYellow, color.FgBlack).SprintFunc()
-var Fail = color.New(color.BgRed, color.FgBlack).SprintFunc()
+	Normal = current.Normal
+	Pass   = current.Pass
+	Warn   = current.Warn
+	Fail   = current.Fail
+)
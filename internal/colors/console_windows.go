@@ -0,0 +1,39 @@
+//go:build windows
+// +build windows
+
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package colors
+
+import (
+	"github.com/fatih/color"
+	"golang.org/x/sys/windows"
+)
+
+// Windows consoles only understand the ANSI escape codes that colours are written with once virtual terminal
+// processing is turned on. Consoles too old to support it are left uncoloured rather than filled with escape codes.
+func init() {
+	for _, handle := range []windows.Handle{windows.Stdout, windows.Stderr} {
+		var mode uint32
+		if err := windows.GetConsoleMode(handle, &mode); err != nil {
+			// not a console, e.g. output is redirected to a file
+			continue
+		}
+		if err := windows.SetConsoleMode(handle, mode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING); err != nil {
+			color.NoColor = true
+		}
+	}
+}
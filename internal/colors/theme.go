@@ -0,0 +1,133 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package colors
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/briandowns/spinner"
+	"github.com/fatih/color"
+)
+
+// The themes that output can be styled with
+const (
+	DefaultTheme = "default"
+	// HighContrastTheme uses bright, bold colours that stay readable on dark and light backgrounds alike
+	HighContrastTheme = "high-contrast"
+	// AsciiTheme writes plain ASCII, with no colours and no spinners, for CI log viewers that mangle escape sequences
+	AsciiTheme = "ascii"
+)
+
+// Theme is how output is styled: the colours of its text and status badges, and the spinner shown while an activity
+// is running
+type Theme struct {
+	Green  func(a ...interface{}) string
+	Cyan   func(a ...interface{}) string
+	White  func(a ...interface{}) string
+	Red    func(a ...interface{}) string
+	Yellow func(a ...interface{}) string
+	Normal func(a ...interface{}) string
+	Pass   func(a ...interface{}) string
+	Warn   func(a ...interface{}) string
+	Fail   func(a ...interface{}) string
+	// Plain themes turn colour off everywhere, including in tables
+	Plain bool
+	// Spinner is the frames of the spinner, or nil for activities to show only their outcome once they end
+	Spinner []string
+}
+
+var themes = map[string]Theme{
+	DefaultTheme: {
+		Green:   color.New(color.FgGreen).SprintFunc(),
+		Cyan:    color.New(color.FgCyan).SprintFunc(),
+		White:   color.New(color.FgWhite).SprintFunc(),
+		Red:     color.New(color.FgRed).SprintFunc(),
+		Yellow:  color.New(color.FgYellow).SprintFunc(),
+		Normal:  color.New(color.Reset).SprintFunc(),
+		Pass:    color.New(color.BgGreen, color.FgBlack).SprintFunc(),
+		Warn:    color.New(color.BgYellow, color.FgBlack).SprintFunc(),
+		Fail:    color.New(color.BgRed, color.FgBlack).SprintFunc(),
+		Spinner: spinner.CharSets[11],
+	},
+	HighContrastTheme: {
+		Green:   color.New(color.FgHiGreen, color.Bold).SprintFunc(),
+		Cyan:    color.New(color.FgHiCyan, color.Bold).SprintFunc(),
+		White:   color.New(color.FgHiWhite).SprintFunc(),
+		Red:     color.New(color.FgHiRed, color.Bold).SprintFunc(),
+		Yellow:  color.New(color.FgHiYellow, color.Bold).SprintFunc(),
+		Normal:  color.New(color.Reset).SprintFunc(),
+		Pass:    color.New(color.BgHiGreen, color.FgBlack, color.Bold).SprintFunc(),
+		Warn:    color.New(color.BgHiYellow, color.FgBlack, color.Bold).SprintFunc(),
+		Fail:    color.New(color.BgHiRed, color.FgHiWhite, color.Bold).SprintFunc(),
+		Spinner: spinner.CharSets[11],
+	},
+	AsciiTheme: {
+		Green:  fmt.Sprint,
+		Cyan:   fmt.Sprint,
+		White:  fmt.Sprint,
+		Red:    fmt.Sprint,
+		Yellow: fmt.Sprint,
+		Normal: fmt.Sprint,
+		Pass:   fmt.Sprint,
+		Warn:   fmt.Sprint,
+		Fail:   fmt.Sprint,
+		Plain:  true,
+	},
+}
+
+var current = themes[DefaultTheme]
+
+// Themes lists the names of the themes, in alphabetical order
+func Themes() []string {
+	var names []string
+	for name := range themes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetTheme styles all output from now on with the named theme, or DefaultTheme if the name is empty. Colour is
+// turned off if noColor is set or the theme is plain, as well as when NO_COLOR is set or stdout is not a terminal.
+func SetTheme(name string, noColor bool) error {
+	if name == "" {
+		name = DefaultTheme
+	}
+	theme, ok := themes[name]
+	if !ok {
+		return fmt.Errorf("unknown theme %s: must be one of %s", name, strings.Join(Themes(), ", "))
+	}
+
+	current = theme
+	Green, Cyan, White, Red, Yellow = theme.Green, theme.Cyan, theme.White, theme.Red, theme.Yellow
+	Normal, Pass, Warn, Fail = theme.Normal, theme.Pass, theme.Warn, theme.Fail
+	if noColor || theme.Plain {
+		color.NoColor = true
+	}
+	return nil
+}
+
+// Spinner is the frames of the current theme's spinner, or nil if it has none
+func Spinner() []string {
+	return current.Spinner
+}
+
+// Plain is true if the current theme writes plain ASCII, so that output should not include emoji either
+func Plain() bool {
+	return current.Plain
+}
@@ -0,0 +1,58 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package colors
+
+import (
+	"testing"
+
+	"github.com/fatih/color"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestItStylesOutputWithTheTheme(t *testing.T) {
+	noColor := color.NoColor
+	defer func() {
+		_ = SetTheme(DefaultTheme, false)
+		color.NoColor = noColor
+	}()
+	color.NoColor = false
+
+	assert.NoError(t, SetTheme(HighContrastTheme, false))
+	assert.Equal(t, "\x1b[92;1mok\x1b[0m", Green("ok"))
+	assert.NotNil(t, Spinner())
+
+	assert.NoError(t, SetTheme(AsciiTheme, false))
+	assert.Equal(t, "ok", Green("ok"))
+	assert.Nil(t, Spinner())
+	assert.True(t, color.NoColor)
+}
+
+func TestItTurnsColourOffWithNoColor(t *testing.T) {
+	noColor := color.NoColor
+	defer func() {
+		_ = SetTheme(DefaultTheme, false)
+		color.NoColor = noColor
+	}()
+	color.NoColor = false
+
+	assert.NoError(t, SetTheme("", true))
+	assert.Equal(t, "ok", Green("ok"))
+	assert.NotNil(t, Spinner())
+}
+
+func TestItRejectsUnknownThemes(t *testing.T) {
+	assert.EqualError(t, SetTheme("neon", false), "unknown theme neon: must be one of ascii, default, high-contrast")
+}
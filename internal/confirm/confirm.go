@@ -0,0 +1,54 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package confirm
+
+import (
+	"time"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/messages"
+	"github.com/skyscanner/turbolift/internal/prompt"
+)
+
+// Destructive asks whether a destructive command may go ahead over a number of the campaign's repos.
+// Over the configured threshold, the campaign name must be typed to confirm it, even if yes is set: the first time
+// the command is run over that many repos, or every time if the campaign is configured that way.
+// Otherwise, the question is asked as usual, unless yes is set.
+func Destructive(p prompt.Prompt, dir *campaign.Campaign, command string, repos int, yes bool, question string) (bool, error) {
+	policy := dir.Config.Confirm.DestructivePolicy()
+	if policy == campaign.ConfirmNever || repos <= dir.Config.Confirm.DestructiveThreshold() {
+		return yes || p.AskConfirm(question), nil
+	}
+
+	state, err := campaign.ReadState()
+	if err != nil {
+		return false, err
+	}
+	if policy == campaign.ConfirmFirstUse && state.IsConfirmed(command, repos) {
+		return yes || p.AskConfirm(question), nil
+	}
+
+	typedQuestion := dir.Messages.Format(messages.ConfirmByCampaignName, messages.Fields{"Question": question, "Repos": repos, "Campaign": dir.Name})
+	if !p.AskTyped(typedQuestion, dir.Name) {
+		return false, nil
+	}
+
+	state.RecordConfirmation(campaign.Confirmation{Command: command, Repos: repos, Confirmed: time.Now()})
+	if err := state.Save(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
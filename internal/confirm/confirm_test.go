@@ -0,0 +1,104 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package confirm
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/prompt"
+	"github.com/skyscanner/turbolift/internal/testsupport"
+)
+
+func openCampaign(t *testing.T, config string, repos ...string) *campaign.Campaign {
+	testsupport.PrepareTempCampaign(false, repos...)
+	testsupport.CreateConfigFile(config)
+	dir, err := campaign.OpenCampaign(campaign.NewCampaignOptions())
+	assert.NoError(t, err)
+	return dir
+}
+
+func TestItAsksAsUsualBelowTheThreshold(t *testing.T) {
+	dir := openCampaign(t, "confirm:\n  threshold: 2\n", "org/repo1", "org/repo2")
+
+	fakePrompt := prompt.NewFakePromptNo()
+	confirmed, err := Destructive(fakePrompt, dir, "clean", 2, false, "Clean?")
+	assert.NoError(t, err)
+	assert.False(t, confirmed)
+	fakePrompt.AssertCalledWith(t, "Clean?")
+
+	fakePrompt = prompt.NewFakePromptNo()
+	confirmed, err = Destructive(fakePrompt, dir, "clean", 2, true, "Clean?")
+	assert.NoError(t, err)
+	assert.True(t, confirmed)
+	fakePrompt.AssertCalledWith(t, "")
+}
+
+func TestItRequiresTheCampaignNameToBeTypedOnFirstUseEvenWithYes(t *testing.T) {
+	dir := openCampaign(t, "confirm:\n  threshold: 1\n", "org/repo1", "org/repo2")
+
+	fakePrompt := prompt.NewFakePromptNo()
+	confirmed, err := Destructive(fakePrompt, dir, "clean", 2, true, "Clean?")
+	assert.NoError(t, err)
+	assert.False(t, confirmed)
+	fakePrompt.AssertCalledWith(t, "Clean? This affects 2 repos, so type the campaign name ("+dir.Name+") to confirm")
+
+	confirmed, err = Destructive(prompt.NewFakePromptYes(), dir, "clean", 2, true, "Clean?")
+	assert.NoError(t, err)
+	assert.True(t, confirmed)
+
+	// trusted from now on, for as many repos as were confirmed
+	fakePrompt = prompt.NewFakePromptNo()
+	confirmed, err = Destructive(fakePrompt, dir, "clean", 2, true, "Clean?")
+	assert.NoError(t, err)
+	assert.True(t, confirmed)
+	fakePrompt.AssertCalledWith(t, "")
+
+	confirmed, err = Destructive(fakePrompt, dir, "clean", 3, true, "Clean?")
+	assert.NoError(t, err)
+	assert.False(t, confirmed)
+	confirmed, err = Destructive(fakePrompt, dir, "update-prs --close", 2, true, "Close?")
+	assert.NoError(t, err)
+	assert.False(t, confirmed)
+}
+
+func TestItRequiresTheCampaignNameToBeTypedEveryTimeIfConfigured(t *testing.T) {
+	dir := openCampaign(t, "confirm:\n  destructive: always\n  threshold: 1\n", "org/repo1", "org/repo2")
+
+	confirmed, err := Destructive(prompt.NewFakePromptYes(), dir, "clean", 2, true, "Clean?")
+	assert.NoError(t, err)
+	assert.True(t, confirmed)
+
+	confirmed, err = Destructive(prompt.NewFakePromptNo(), dir, "clean", 2, true, "Clean?")
+	assert.NoError(t, err)
+	assert.False(t, confirmed)
+}
+
+func TestItNeverRequiresTheCampaignNameToBeTypedIfConfigured(t *testing.T) {
+	dir := openCampaign(t, "confirm:\n  destructive: never\n  threshold: 1\n", "org/repo1", "org/repo2")
+
+	fakePrompt := prompt.NewFakePromptNo()
+	confirmed, err := Destructive(fakePrompt, dir, "clean", 2, true, "Clean?")
+	assert.NoError(t, err)
+	assert.True(t, confirmed)
+	fakePrompt.AssertCalledWith(t, "")
+
+	_, err = os.Stat(campaign.StateDirectory)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,97 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package executor
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+)
+
+// Where working copies and scripts are mounted in the containers that commands are run in
+const (
+	ContainerRepoDir   = "/turbolift/repo"
+	containerScriptDir = "/turbolift/scripts"
+)
+
+// Container runs commands inside a Docker container with the repo's working copy mounted, so that they get the same
+// toolchain whatever machine turbolift is run on
+type Container struct {
+	Image string
+	// ScriptPath is the absolute path of a local script that is mounted into the container, so that it can be run there
+	ScriptPath string
+}
+
+// Command returns the docker command that runs name with args inside a container for the repo, from the container's
+// copy of the repo's working dir. The TURBOLIFT_* variables in env are passed on, with TURBOLIFT_REPO_DIR pointing at
+// where the working copy is mounted.
+func (c Container) Command(repo campaign.Repo, env []string, name string, args ...string) (string, []string) {
+	repoDir, err := filepath.Abs(repo.FullRepoPath())
+	if err != nil {
+		repoDir = repo.FullRepoPath()
+	}
+
+	dockerArgs := []string{"run", "--rm", "-v", repoDir + ":" + ContainerRepoDir, "-w", path.Join(ContainerRepoDir, repo.Path)}
+	if c.ScriptPath != "" {
+		dockerArgs = append(dockerArgs, "-v", c.ScriptPath+":"+c.containerScriptPath()+":ro")
+	}
+	// files the command creates in the working copy should belong to the user, not root
+	if user := containerUser(); user != "" {
+		dockerArgs = append(dockerArgs, "--user", user)
+	}
+	for _, variable := range env {
+		if strings.HasPrefix(variable, "TURBOLIFT_REPO_DIR=") {
+			variable = "TURBOLIFT_REPO_DIR=" + ContainerRepoDir
+		}
+		dockerArgs = append(dockerArgs, "-e", variable)
+	}
+	dockerArgs = append(append(dockerArgs, c.Image, name), args...)
+	return "docker", dockerArgs
+}
+
+// ScriptCommand returns the command and arguments that run the mounted script inside the container. As with
+// ScriptCommand, executables are run directly; other scripts are run with sh, as the user's shell may not be there.
+func (c Container) ScriptCommand(mode os.FileMode, args ...string) (string, []string) {
+	if mode&0o111 != 0 {
+		return c.containerScriptPath(), args
+	}
+	return "sh", append([]string{c.containerScriptPath()}, args...)
+}
+
+// ShellCommand returns the command and arguments that run a script with sh inside the container
+func (c Container) ShellCommand(script string, args ...string) (string, []string) {
+	shellArgs := []string{"-c", script}
+	if len(args) > 0 {
+		shellArgs = append(append(shellArgs, "turbolift"), args...)
+	}
+	return "sh", shellArgs
+}
+
+func (c Container) containerScriptPath() string {
+	return path.Join(containerScriptDir, filepath.Base(c.ScriptPath))
+}
+
+// containerUser is the uid and gid that commands are run as in containers, or empty on Windows, which has neither
+func containerUser() string {
+	if goos == "windows" {
+		return ""
+	}
+	return fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid())
+}
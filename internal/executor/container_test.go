@@ -0,0 +1,79 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package executor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/testsupport"
+)
+
+func TestContainerCommandMountsTheWorkingCopy(t *testing.T) {
+	withGoos(t, "linux")
+	repoDir, _ := filepath.Abs("work/org/repo1")
+	repo := campaign.Repo{OrgName: "org", RepoName: "repo1", FullRepoName: "org/repo1", Path: "services/api"}
+	env := []string{"TURBOLIFT_REPO=org/repo1", "TURBOLIFT_REPO_DIR=" + repoDir}
+
+	name, args := Container{Image: "node:20"}.Command(repo, env, "npm", "test")
+
+	assert.Equal(t, "docker", name)
+	assert.Equal(t, []string{
+		"run", "--rm", "-v", repoDir + ":/turbolift/repo", "-w", "/turbolift/repo/services/api",
+		"--user", fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid()),
+		"-e", "TURBOLIFT_REPO=org/repo1", "-e", "TURBOLIFT_REPO_DIR=/turbolift/repo",
+		"node:20", "npm", "test",
+	}, args)
+}
+
+func TestContainerCommandMountsTheScriptAndLeavesOutTheUserOnWindows(t *testing.T) {
+	withGoos(t, "windows")
+	repoDir, _ := filepath.Abs("work/org/repo1")
+	repo := campaign.Repo{OrgName: "org", RepoName: "repo1", FullRepoName: "org/repo1"}
+
+	_, args := Container{Image: "python:3.12", ScriptPath: "/home/me/migrate.py"}.Command(repo, nil, "python", "/turbolift/scripts/migrate.py")
+
+	assert.Equal(t, []string{
+		"run", "--rm", "-v", repoDir + ":/turbolift/repo", "-w", "/turbolift/repo",
+		"-v", "/home/me/migrate.py:/turbolift/scripts/migrate.py:ro",
+		"python:3.12", "python", "/turbolift/scripts/migrate.py",
+	}, args)
+}
+
+func TestContainerScriptCommandRunsExecutablesDirectly(t *testing.T) {
+	c := Container{Image: "alpine", ScriptPath: "/home/me/fix.sh"}
+
+	name, args := c.ScriptCommand(0o755, "arg1")
+	assert.Equal(t, "/turbolift/scripts/fix.sh", name)
+	assert.Equal(t, []string{"arg1"}, args)
+
+	name, args = c.ScriptCommand(0o644, "arg1")
+	assert.Equal(t, "sh", name)
+	assert.Equal(t, []string{"/turbolift/scripts/fix.sh", "arg1"}, args)
+}
+
+func TestContainerShellCommandUsesSh(t *testing.T) {
+	testsupport.Setenv(t, "SHELL", "/bin/zsh")
+
+	name, args := Container{Image: "alpine"}.ShellCommand("echo $1", "hello")
+	assert.Equal(t, "sh", name)
+	assert.Equal(t, []string{"-c", "echo $1", "turbolift", "hello"}, args)
+}
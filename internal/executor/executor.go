@@ -16,24 +16,57 @@
 package executor
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/skyscanner/turbolift/internal/audit"
+	"github.com/skyscanner/turbolift/internal/logging"
+	"github.com/skyscanner/turbolift/internal/tools"
 )
 
 type Executor interface {
-	Execute(output io.Writer, workingDir string, name string, args ...string) error
-	ExecuteAndCapture(output io.Writer, workingDir string, name string, args ...string) (string, error)
+	Execute(ctx context.Context, output io.Writer, workingDir string, name string, args ...string) error
+	ExecuteWithEnv(ctx context.Context, output io.Writer, workingDir string, env []string, name string, args ...string) error
+	ExecuteAndCapture(ctx context.Context, output io.Writer, workingDir string, name string, args ...string) (string, error)
+	ExecuteAndCaptureWithEnv(ctx context.Context, output io.Writer, workingDir string, env []string, name string, args ...string) (string, error)
+	ExecuteCapturingStdout(ctx context.Context, output io.Writer, stdout io.Writer, workingDir string, name string, args ...string) error
+	ExecuteCapturingStdoutWithEnv(ctx context.Context, output io.Writer, stdout io.Writer, workingDir string, env []string, name string, args ...string) error
 	SetVerbose(bool)
 }
 
 type RealExecutor struct {
 	Verbose bool
+	// Timeout limits how long each command may run for, overriding DefaultTimeout if set
+	Timeout time.Duration
+}
+
+// DefaultTimeout limits how long each command may run for, for executors that do not set their own Timeout. Zero
+// means no limit. The CLI sets it from the --command-timeout flag.
+var DefaultTimeout time.Duration
+
+// killGracePeriod is how long a cancelled command has to exit after being interrupted, before it is killed, and how
+// long its output is then waited for
+var killGracePeriod = 5 * time.Second
+
+func (e *RealExecutor) Execute(ctx context.Context, output io.Writer, workingDir string, name string, args ...string) error {
+	return e.ExecuteWithEnv(ctx, output, workingDir, nil, name, args...)
 }
 
-func (e *RealExecutor) Execute(output io.Writer, workingDir string, name string, args ...string) error {
+// ExecuteWithEnv behaves like Execute, but adds the given KEY=value pairs to the environment inherited by the command.
+func (e *RealExecutor) ExecuteWithEnv(ctx context.Context, output io.Writer, workingDir string, env []string, name string, args ...string) error {
 	command := exec.Command(name, args...)
 	command.Dir = workingDir
+	if len(env) > 0 {
+		command.Env = append(os.Environ(), env...)
+	}
 	command.Stdout = output
 	command.Stderr = output
 
@@ -43,37 +76,193 @@ func (e *RealExecutor) Execute(output io.Writer, workingDir string, name string,
 		}
 	}
 
-	if err := command.Start(); err != nil {
-		return err
+	return e.run(ctx, command)
+}
+
+func (e *RealExecutor) ExecuteAndCapture(ctx context.Context, output io.Writer, workingDir string, name string, args ...string) (string, error) {
+	return e.ExecuteAndCaptureWithEnv(ctx, output, workingDir, nil, name, args...)
+}
+
+// ExecuteAndCaptureWithEnv behaves like ExecuteAndCapture, but adds the given KEY=value pairs to the environment
+// inherited by the command.
+func (e *RealExecutor) ExecuteAndCaptureWithEnv(ctx context.Context, output io.Writer, workingDir string, env []string, name string, args ...string) (string, error) {
+	command := exec.Command(name, args...)
+	command.Dir = workingDir
+	if len(env) > 0 {
+		command.Env = append(os.Environ(), env...)
 	}
+	var stdout, stderr bytes.Buffer
+	command.Stdout = &stdout
+	command.Stderr = &stderr
 
-	if err := command.Wait(); err != nil {
-		return err
+	if e.Verbose {
+		if _, err := fmt.Fprintln(output, "Executing:", name, summarizedArgs(args), "in", workingDir); err != nil {
+			return "", err
+		}
 	}
 
-	return nil
+	if err := e.run(ctx, command); err != nil {
+		if exitErr, _ := err.(*exec.ExitError); exitErr != nil {
+			stdErr := stderr.String()
+			return stdErr, fmt.Errorf("error: %w. Stderr: %s", exitErr, stdErr)
+		}
+		return stdout.String(), err
+	}
+
+	return stdout.String(), nil
+}
+
+// ExecuteCapturingStdout behaves like Execute, but also writes the command's stdout, without its stderr, to stdout
+func (e *RealExecutor) ExecuteCapturingStdout(ctx context.Context, output io.Writer, stdout io.Writer, workingDir string, name string, args ...string) error {
+	return e.ExecuteCapturingStdoutWithEnv(ctx, output, stdout, workingDir, nil, name, args...)
 }
 
-func (e *RealExecutor) ExecuteAndCapture(output io.Writer, workingDir string, name string, args ...string) (string, error) {
+// ExecuteCapturingStdoutWithEnv behaves like ExecuteCapturingStdout, but adds the given KEY=value pairs to the
+// environment inherited by the command.
+func (e *RealExecutor) ExecuteCapturingStdoutWithEnv(ctx context.Context, output io.Writer, stdout io.Writer, workingDir string, env []string, name string, args ...string) error {
 	command := exec.Command(name, args...)
 	command.Dir = workingDir
+	if len(env) > 0 {
+		command.Env = append(os.Environ(), env...)
+	}
+	// stdout and stderr are copied to output by separate goroutines, now that they are different writers
+	combined := &lockedWriter{writer: output}
+	command.Stdout = io.MultiWriter(combined, stdout)
+	command.Stderr = combined
 
 	if e.Verbose {
 		if _, err := fmt.Fprintln(output, "Executing:", name, summarizedArgs(args), "in", workingDir); err != nil {
-			return "", err
+			return err
 		}
 	}
 
-	commandOutput, err := command.Output()
+	return e.run(ctx, command)
+}
+
+// lockedWriter serialises writes from several goroutines to a writer
+type lockedWriter struct {
+	mu     sync.Mutex
+	writer io.Writer
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.writer.Write(p)
+}
+
+// ExitCode returns the exit code of a command from the error it returned: 0 if it succeeded, or -1 if it did not exit
+// by itself, e.g. because it could not be started or was stopped
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() >= 0 {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// run runs the command, recording it in the audit log if one is being kept
+func (e *RealExecutor) run(ctx context.Context, command *exec.Cmd) error {
+	auditLog := audit.Active()
+	if auditLog == nil {
+		return e.runCommand(ctx, command)
+	}
+
+	audited, err := auditLog.Start(ctx, command.Dir, command.Args[0], command.Args[1:])
 	if err != nil {
-		if exitErr, _ := err.(*exec.ExitError); exitErr != nil {
-			stdErr := string(exitErr.Stderr)
-			return stdErr, fmt.Errorf("error: %w. Stderr: %s", exitErr, stdErr)
+		return err
+	}
+	// a command given the same writer for stdout and stderr only writes to it from one goroutine at a time, so they are
+	// kept the same
+	sameWriter := command.Stdout == command.Stderr
+	command.Stdout = io.MultiWriter(command.Stdout, audited.Output())
+	if sameWriter {
+		command.Stderr = command.Stdout
+	} else {
+		command.Stderr = io.MultiWriter(command.Stderr, audited.Output())
+	}
+
+	err = e.runCommand(ctx, command)
+	if auditErr := audited.Finish(ExitCode(err), err); auditErr != nil && err == nil {
+		return auditErr
+	}
+	return err
+}
+
+// runCommand starts the command and waits for it to finish. If ctx is cancelled or the timeout expires first, the
+// command and everything it started are interrupted so that they can clean up after themselves, and killed if the
+// command has not exited after killGracePeriod. Output still being written after that is dropped, rather than waited
+// for.
+func (e *RealExecutor) runCommand(ctx context.Context, command *exec.Cmd) error {
+	timeout := e.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+		// the caller's own deadline comes first, so it is the one that applies
+		timeout = 0
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	if err := ctx.Err(); err != nil {
+		return contextError(err, command, timeout)
+	}
+	startInOwnProcessGroup(command)
+	useShellCommandLine(command)
+	pipes, err := pipeOutput(command)
+	if err != nil {
+		return err
+	}
+	if err := command.Start(); err != nil {
+		pipes.closeAll()
+		if errors.Is(err, exec.ErrNotFound) {
+			return &tools.MissingError{Name: command.Args[0], Err: err}
 		}
-		return string(commandOutput), err
+		return err
 	}
+	pipes.started()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- command.Wait()
+	}()
 
-	return string(commandOutput), nil
+	select {
+	case err := <-done:
+		pipes.wait()
+		return err
+	case <-ctx.Done():
+	}
+
+	if err := interruptProcessGroup(command.Process); err != nil {
+		killProcessGroup(command.Process)
+	}
+	select {
+	case <-done:
+	case <-time.After(killGracePeriod):
+		killProcessGroup(command.Process)
+		<-done
+	}
+	// anything the command started that is still running would otherwise keep going, holding on to its output
+	killProcessGroup(command.Process)
+	pipes.waitAtMost(killGracePeriod)
+	return contextError(ctx.Err(), command, timeout)
+}
+
+func contextError(err error, command *exec.Cmd, timeout time.Duration) error {
+	if errors.Is(err, context.DeadlineExceeded) && timeout > 0 {
+		return fmt.Errorf("%s timed out after %s: %w", filepath.Base(command.Path), timeout, err)
+	} else if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%s timed out: %w", filepath.Base(command.Path), err)
+	}
+	return fmt.Errorf("%s was cancelled: %w", filepath.Base(command.Path), err)
 }
 
 func (e *RealExecutor) SetVerbose(verbose bool) {
@@ -81,10 +270,10 @@ func (e *RealExecutor) SetVerbose(verbose bool) {
 }
 
 // summarizedArgs transforms a list of command arguments where any long value is replaced by "...". Used to ensure
-// that logging of long arguments doesn't take excessive screen space.
+// that logging of long arguments doesn't take excessive screen space. Any secrets in the arguments are redacted.
 func summarizedArgs(args []string) []string {
 	result := []string{}
-	for _, arg := range args {
+	for _, arg := range logging.RedactAll(args) {
 		if len(arg) > 30 {
 			result = append(result, "...")
 		} else {
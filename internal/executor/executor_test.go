@@ -1,17 +1,29 @@
 package executor
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/audit"
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/tools"
 )
 
 func TestExecutorExecuteVerbose(t *testing.T) {
 	localExecutor := NewRealExecutor()
 	outputBytes := bytes.NewBuffer([]byte{})
 
-	err := localExecutor.Execute(outputBytes, ".", "echo", "Test1234")
+	err := localExecutor.Execute(context.Background(), outputBytes, ".", "echo", "Test1234")
 	assert.NoError(t, err)
 
 	output := outputBytes.String()
@@ -26,7 +38,7 @@ func TestExecutorExecuteSilent(t *testing.T) {
 
 	outputBytes := bytes.NewBuffer([]byte{})
 
-	err := localExecutor.Execute(outputBytes, ".", "echo", "Test1234")
+	err := localExecutor.Execute(context.Background(), outputBytes, ".", "echo", "Test1234")
 	assert.NoError(t, err)
 
 	output := outputBytes.String()
@@ -39,18 +51,177 @@ func TestExecutorExecuteThrowsError(t *testing.T) {
 
 	outputBytes := bytes.NewBuffer([]byte{})
 
-	err := localExecutor.Execute(outputBytes, ".", "fakecommand", "should", "error")
+	err := localExecutor.Execute(context.Background(), outputBytes, ".", "fakecommand", "should", "error")
 	assert.Error(t, err)
 
 	output := outputBytes.String()
 	assert.Contains(t, output, "Executing: fakecommand [should error] in .")
 }
 
+func TestExecutorSaysHowToInstallMissingCommands(t *testing.T) {
+	localExecutor := NewRealExecutor()
+
+	err := localExecutor.Execute(context.Background(), &bytes.Buffer{}, ".", "fakecommand")
+
+	var missingErr *tools.MissingError
+	assert.True(t, errors.As(err, &missingErr))
+	assert.Equal(t, "fakecommand", missingErr.Name)
+	assert.True(t, errors.Is(err, exec.ErrNotFound))
+	assert.Contains(t, err.Error(), "fakecommand is not installed, or is not on the PATH")
+}
+
+func TestExecutorExecuteTimesOut(t *testing.T) {
+	localExecutor := NewRealExecutor()
+	localExecutor.SetVerbose(false)
+	localExecutor.Timeout = 100 * time.Millisecond
+
+	outputBytes := bytes.NewBuffer([]byte{})
+
+	started := time.Now()
+	err := localExecutor.Execute(context.Background(), outputBytes, ".", "sleep", "10")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "sleep timed out after 100ms")
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Less(t, int64(time.Since(started)), int64(5*time.Second))
+}
+
+func TestExecutorStopsWhatTheCommandStartedOnTimeout(t *testing.T) {
+	localExecutor := NewRealExecutor()
+	localExecutor.SetVerbose(false)
+	localExecutor.Timeout = time.Second
+
+	outputBytes := bytes.NewBuffer([]byte{})
+
+	started := time.Now()
+	err := localExecutor.Execute(context.Background(), outputBytes, ".", "sh", "-c", "sleep 8; echo done")
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Less(t, int64(time.Since(started)), int64(4*time.Second))
+	assert.NotContains(t, outputBytes.String(), "done")
+}
+
+func TestExecutorStopsWaitingForOutputHeldOpenAfterTimeout(t *testing.T) {
+	if _, err := exec.LookPath("setsid"); err != nil {
+		t.Skip("needs setsid")
+	}
+	defer func(gracePeriod time.Duration) {
+		killGracePeriod = gracePeriod
+	}(killGracePeriod)
+	killGracePeriod = 200 * time.Millisecond
+	localExecutor := NewRealExecutor()
+	localExecutor.SetVerbose(false)
+	localExecutor.Timeout = 200 * time.Millisecond
+
+	// the child leaves turbolift's process group, so it is not stopped along with the command, and keeps its
+	// output open
+	started := time.Now()
+	_, err := localExecutor.ExecuteAndCapture(context.Background(), bytes.NewBuffer([]byte{}), ".", "sh", "-c", "setsid sleep 8 & sleep 8")
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Less(t, int64(time.Since(started)), int64(4*time.Second))
+}
+
+func TestExecutorRunsCommandsInTheirOwnProcessGroup(t *testing.T) {
+	localExecutor := NewRealExecutor()
+	localExecutor.SetVerbose(false)
+
+	// a command in its own group leads it, so its group id is its own process id
+	out, err := localExecutor.ExecuteAndCapture(context.Background(), bytes.NewBuffer([]byte{}), ".", "sh", "-c", `[ "$(ps -o pgid= -p $$ | tr -d ' ')" = "$$" ] && echo own group`)
+	assert.NoError(t, err)
+	assert.Equal(t, "own group\n", out)
+}
+
+func TestExecutorExecuteAndCaptureIsCancelled(t *testing.T) {
+	localExecutor := NewRealExecutor()
+	localExecutor.SetVerbose(false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+
+	started := time.Now()
+	_, err := localExecutor.ExecuteAndCapture(ctx, bytes.NewBuffer([]byte{}), ".", "sleep", "10")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "sleep was cancelled")
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Less(t, int64(time.Since(started)), int64(5*time.Second))
+}
+
+func TestExecutorDoesNotStartCommandOnceCancelled(t *testing.T) {
+	localExecutor := NewRealExecutor()
+	localExecutor.SetVerbose(false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	outputBytes := bytes.NewBuffer([]byte{})
+	err := localExecutor.Execute(ctx, outputBytes, ".", "echo", "Test1234")
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Empty(t, outputBytes.String())
+}
+
+func TestExecutorExecuteWithEnvPassesEnvironment(t *testing.T) {
+	localExecutor := NewRealExecutor()
+	localExecutor.SetVerbose(false)
+
+	outputBytes := bytes.NewBuffer([]byte{})
+
+	err := localExecutor.ExecuteWithEnv(context.Background(), outputBytes, ".", []string{"TURBOLIFT_TEST_VAR=Test1234"}, "sh", "-c", "echo $TURBOLIFT_TEST_VAR")
+	assert.NoError(t, err)
+
+	assert.Equal(t, "Test1234\n", outputBytes.String())
+}
+
+func TestExecutorRecordsCommandsInTheAuditLog(t *testing.T) {
+	dir := t.TempDir()
+	auditLog, err := audit.Open(dir, []string{"foreach"})
+	assert.NoError(t, err)
+	audit.SetActive(auditLog)
+	defer audit.SetActive(nil)
+
+	localExecutor := NewRealExecutor()
+	localExecutor.SetVerbose(false)
+	outputBytes := bytes.NewBuffer([]byte{})
+	err = localExecutor.Execute(context.Background(), outputBytes, ".", "sh", "-c", "echo out; echo err >&2; exit 3")
+	assert.Error(t, err)
+	output, err := localExecutor.ExecuteAndCapture(context.Background(), outputBytes, ".", "echo", "captured")
+	assert.NoError(t, err)
+	assert.Equal(t, "captured\n", output)
+	assert.NoError(t, auditLog.Close())
+
+	file, err := os.Open(filepath.Join(dir, campaign.StateDirectory, audit.Filename))
+	assert.NoError(t, err)
+	defer func() { _ = file.Close() }()
+	var records []audit.Record
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record audit.Record
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+
+	assert.Len(t, records, 2)
+	assert.Equal(t, "sh", records[0].Command)
+	assert.Equal(t, []string{"-c", "echo out; echo err >&2; exit 3"}, records[0].Args)
+	assert.Equal(t, 3, records[0].ExitCode)
+	recordedOutput, err := os.ReadFile(filepath.Join(dir, records[0].Output))
+	assert.NoError(t, err)
+	assert.Equal(t, "out\nerr\n", string(recordedOutput))
+	assert.Equal(t, "out\nerr\n", outputBytes.String())
+
+	assert.Equal(t, 0, records[1].ExitCode)
+	recordedOutput, err = os.ReadFile(filepath.Join(dir, records[1].Output))
+	assert.NoError(t, err)
+	assert.Equal(t, "captured\n", string(recordedOutput))
+}
+
 func TestExecutorExecuteAndCaptureVerbose(t *testing.T) {
 	localExecutor := NewRealExecutor()
 	commandOutput := bytes.NewBuffer([]byte{})
 
-	output, err := localExecutor.ExecuteAndCapture(commandOutput, ".", "echo", "Test1234")
+	output, err := localExecutor.ExecuteAndCapture(context.Background(), commandOutput, ".", "echo", "Test1234")
 	assert.NoError(t, err)
 
 	assert.Equal(t, output, "Test1234\n")
@@ -62,7 +233,7 @@ func TestExecutorExecuteAndCaptureSilent(t *testing.T) {
 	localExecutor.SetVerbose(false)
 	commandOutput := bytes.NewBuffer([]byte{})
 
-	output, err := localExecutor.ExecuteAndCapture(commandOutput, ".", "echo", "Test1234")
+	output, err := localExecutor.ExecuteAndCapture(context.Background(), commandOutput, ".", "echo", "Test1234")
 	assert.NoError(t, err)
 
 	assert.Equal(t, output, "Test1234\n")
@@ -73,7 +244,7 @@ func TestExecutorExecuteAndCaptureThrowsError(t *testing.T) {
 	localExecutor := NewRealExecutor()
 	commandOutput := bytes.NewBuffer([]byte{})
 
-	output, err := localExecutor.ExecuteAndCapture(commandOutput, ".", "fakecommand", "does", "not", "exist")
+	output, err := localExecutor.ExecuteAndCapture(context.Background(), commandOutput, ".", "fakecommand", "does", "not", "exist")
 	assert.Error(t, err)
 
 	assert.Empty(t, output, "Test1234\n")
@@ -124,3 +295,24 @@ func TestSummarizedArgs(t *testing.T) {
 		})
 	}
 }
+
+func TestExecutorCapturesStdoutSeparately(t *testing.T) {
+	localExecutor := NewRealExecutor()
+	localExecutor.SetVerbose(false)
+
+	outputBytes := bytes.NewBuffer([]byte{})
+	stdoutBytes := bytes.NewBuffer([]byte{})
+
+	err := localExecutor.ExecuteCapturingStdout(context.Background(), outputBytes, stdoutBytes, ".", "sh", "-c", "echo out; echo err >&2; exit 3")
+	assert.Error(t, err)
+	assert.Equal(t, 3, ExitCode(err))
+
+	assert.Equal(t, "out\n", stdoutBytes.String())
+	assert.Contains(t, outputBytes.String(), "out\n")
+	assert.Contains(t, outputBytes.String(), "err\n")
+}
+
+func TestExitCodes(t *testing.T) {
+	assert.Equal(t, 0, ExitCode(nil))
+	assert.Equal(t, -1, ExitCode(errors.New("unable to start")))
+}
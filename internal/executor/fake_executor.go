@@ -16,8 +16,10 @@
 package executor
 
 import (
+	"context"
 	"errors"
 	"io"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -26,32 +28,98 @@ import (
 type FakeExecutor struct {
 	Handler          func(workingDir string, name string, args ...string) error
 	ReturningHandler func(workingDir string, name string, args ...string) (string, error)
-	calls            [][]string
+	// mu guards calls and envs, as commands may execute in several working copies at once
+	mu    sync.Mutex
+	calls [][]string
+	envs  [][]string
 }
 
-func (e *FakeExecutor) Execute(_ io.Writer, workingDir string, name string, args ...string) error {
+func (e *FakeExecutor) Execute(_ context.Context, _ io.Writer, workingDir string, name string, args ...string) error {
 	allArgs := append([]string{workingDir, name}, args...)
+	e.mu.Lock()
 	e.calls = append(e.calls, allArgs)
+	e.mu.Unlock()
 	return e.Handler(workingDir, name, args...)
 }
 
-func (e *FakeExecutor) ExecuteAndCapture(_ io.Writer, workingDir string, name string, args ...string) (string, error) {
+// ExecuteWithEnv calls Handler, or if there is none writes the output of ReturningHandler, as if the command had printed it
+func (e *FakeExecutor) ExecuteWithEnv(_ context.Context, output io.Writer, workingDir string, env []string, name string, args ...string) error {
 	allArgs := append([]string{workingDir, name}, args...)
+	e.mu.Lock()
 	e.calls = append(e.calls, allArgs)
+	e.envs = append(e.envs, env)
+	e.mu.Unlock()
+	if e.Handler == nil {
+		s, err := e.ReturningHandler(workingDir, name, args...)
+		_, _ = io.WriteString(output, s)
+		return err
+	}
+	return e.Handler(workingDir, name, args...)
+}
+
+func (e *FakeExecutor) ExecuteAndCapture(_ context.Context, _ io.Writer, workingDir string, name string, args ...string) (string, error) {
+	allArgs := append([]string{workingDir, name}, args...)
+	e.mu.Lock()
+	e.calls = append(e.calls, allArgs)
+	e.mu.Unlock()
 	return e.ReturningHandler(workingDir, name, args...)
 }
 
+func (e *FakeExecutor) ExecuteAndCaptureWithEnv(_ context.Context, _ io.Writer, workingDir string, env []string, name string, args ...string) (string, error) {
+	allArgs := append([]string{workingDir, name}, args...)
+	e.mu.Lock()
+	e.calls = append(e.calls, allArgs)
+	e.envs = append(e.envs, env)
+	e.mu.Unlock()
+	return e.ReturningHandler(workingDir, name, args...)
+}
+
+// ExecuteCapturingStdout writes the output of ReturningHandler to stdout, as if the command had printed it
+func (e *FakeExecutor) ExecuteCapturingStdout(_ context.Context, _ io.Writer, stdout io.Writer, workingDir string, name string, args ...string) error {
+	allArgs := append([]string{workingDir, name}, args...)
+	e.mu.Lock()
+	e.calls = append(e.calls, allArgs)
+	e.mu.Unlock()
+	s, err := e.ReturningHandler(workingDir, name, args...)
+	_, _ = io.WriteString(stdout, s)
+	return err
+}
+
+// ExecuteCapturingStdoutWithEnv behaves like ExecuteCapturingStdout, also recording the environment it is given
+func (e *FakeExecutor) ExecuteCapturingStdoutWithEnv(_ context.Context, _ io.Writer, stdout io.Writer, workingDir string, env []string, name string, args ...string) error {
+	allArgs := append([]string{workingDir, name}, args...)
+	e.mu.Lock()
+	e.calls = append(e.calls, allArgs)
+	e.envs = append(e.envs, env)
+	e.mu.Unlock()
+	s, err := e.ReturningHandler(workingDir, name, args...)
+	_, _ = io.WriteString(stdout, s)
+	return err
+}
+
 func (e *FakeExecutor) SetVerbose(_ bool) {}
 
 func (e *FakeExecutor) AssertCalledWith(t *testing.T, expected [][]string) {
 	assert.Equal(t, expected, e.calls)
 }
 
+// AssertCalledWithInAnyOrder checks the calls made, ignoring their order, for commands that execute in parallel
+func (e *FakeExecutor) AssertCalledWithInAnyOrder(t *testing.T, expected [][]string) {
+	assert.ElementsMatch(t, expected, e.calls)
+}
+
+// AssertEnvCalledWith checks the extra environment passed to each call of ExecuteWithEnv, ExecuteAndCaptureWithEnv
+// or ExecuteCapturingStdoutWithEnv
+func (e *FakeExecutor) AssertEnvCalledWith(t *testing.T, expected [][]string) {
+	assert.Equal(t, expected, e.envs)
+}
+
 func NewFakeExecutor(handler func(string, string, ...string) error, returningHandler func(string, string, ...string) (string, error)) *FakeExecutor {
 	return &FakeExecutor{
 		Handler:          handler,
 		ReturningHandler: returningHandler,
 		calls:            [][]string{},
+		envs:             [][]string{},
 	}
 }
 
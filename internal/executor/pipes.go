@@ -0,0 +1,124 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package executor
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// outputPipes copies a command's stdout and stderr to their writers. os/exec would do the same, but Wait then blocks
+// until every process holding the pipes has exited, including any that a stopped command left running; owning the
+// pipes lets runCommand stop waiting for them.
+type outputPipes struct {
+	readers []*os.File
+	writers []*os.File
+	copied  sync.WaitGroup
+}
+
+// pipeOutput replaces the command's stdout and stderr writers with pipes, unless they are already files. Writers that
+// are the same get a single pipe, as os/exec gives them.
+func pipeOutput(command *exec.Cmd) (*outputPipes, error) {
+	pipes := &outputPipes{}
+	var stdoutPipe *os.File
+	if command.Stdout != nil {
+		if _, isFile := command.Stdout.(*os.File); !isFile {
+			w, err := pipes.add(command.Stdout)
+			if err != nil {
+				return nil, err
+			}
+			stdoutPipe = w
+		}
+	}
+	if command.Stderr != nil {
+		if _, isFile := command.Stderr.(*os.File); !isFile {
+			if stdoutPipe != nil && command.Stderr == command.Stdout {
+				command.Stderr = stdoutPipe
+			} else {
+				w, err := pipes.add(command.Stderr)
+				if err != nil {
+					pipes.closeAll()
+					return nil, err
+				}
+				command.Stderr = w
+			}
+		}
+	}
+	if stdoutPipe != nil {
+		command.Stdout = stdoutPipe
+	}
+	return pipes, nil
+}
+
+// add makes a pipe whose output is copied to writer, and returns its end for the command to write to
+func (p *outputPipes) add(writer io.Writer) (*os.File, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	p.readers = append(p.readers, r)
+	p.writers = append(p.writers, w)
+	p.copied.Add(1)
+	go func() {
+		defer p.copied.Done()
+		_, _ = io.Copy(writer, r)
+	}()
+	return w, nil
+}
+
+// started closes turbolift's copies of the ends that the command writes to, which it now has its own of, so that the
+// copying finishes once the command and everything it started are done with them
+func (p *outputPipes) started() {
+	for _, w := range p.writers {
+		_ = w.Close()
+	}
+}
+
+// wait waits for all the output to be copied
+func (p *outputPipes) wait() {
+	p.copied.Wait()
+	p.closeReaders()
+}
+
+// waitAtMost waits for the output to be copied, giving up after the delay and dropping whatever has not been copied
+// by then, e.g. because a process that the command started is still holding the pipes open
+func (p *outputPipes) waitAtMost(delay time.Duration) {
+	copied := make(chan struct{})
+	go func() {
+		p.copied.Wait()
+		close(copied)
+	}()
+	select {
+	case <-copied:
+	case <-time.After(delay):
+	}
+	p.closeReaders()
+}
+
+// closeAll closes both ends of every pipe, for commands that could not be started
+func (p *outputPipes) closeAll() {
+	p.started()
+	p.closeReaders()
+}
+
+func (p *outputPipes) closeReaders() {
+	for _, r := range p.readers {
+		_ = r.Close()
+	}
+}
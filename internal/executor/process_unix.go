@@ -0,0 +1,47 @@
+//go:build !windows
+// +build !windows
+
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package executor
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// startInOwnProcessGroup puts the command in a process group of its own, along with anything it starts, so that a
+// Ctrl-C in the terminal reaches only turbolift, which finishes the current repo before stopping. Commands are only
+// signalled once they need to be stopped, and then the whole group is, so that nothing the command started is left
+// running.
+func startInOwnProcessGroup(command *exec.Cmd) {
+	if command.SysProcAttr == nil {
+		command.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	command.SysProcAttr.Setpgid = true
+}
+
+// interruptProcessGroup asks the command and everything it started to stop
+func interruptProcessGroup(process *os.Process) error {
+	return syscall.Kill(-process.Pid, syscall.SIGINT)
+}
+
+// killProcessGroup stops the command and everything it started straight away. The group outlives the command while
+// anything it started is still running, so this can be called once the command itself has exited.
+func killProcessGroup(process *os.Process) {
+	_ = syscall.Kill(-process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,45 @@
+//go:build windows
+// +build windows
+
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package executor
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// startInOwnProcessGroup puts the command in a process group of its own, so that a Ctrl-C in the console reaches only
+// turbolift, which finishes the current repo before stopping
+func startInOwnProcessGroup(command *exec.Cmd) {
+	if command.SysProcAttr == nil {
+		command.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	command.SysProcAttr.CreationFlags |= syscall.CREATE_NEW_PROCESS_GROUP
+}
+
+// interruptProcessGroup is not supported on Windows, so commands are killed straight away there
+func interruptProcessGroup(_ *os.Process) error {
+	return errors.New("interrupting commands is not supported on Windows")
+}
+
+// killProcessGroup stops the command straight away
+func killProcessGroup(process *os.Process) {
+	_ = process.Kill()
+}
@@ -0,0 +1,59 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package executor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+)
+
+// RepoEnv returns the TURBOLIFT_* environment variables describing a repo in a campaign, so that the scripts that
+// turbolift runs in its working copy can adapt to the repo without parsing its path
+func RepoEnv(dir *campaign.Campaign, repo campaign.Repo) []string {
+	repoDir, err := filepath.Abs(repo.FullRepoPath())
+	if err != nil {
+		repoDir = repo.FullRepoPath()
+	}
+	env := []string{
+		"TURBOLIFT_CAMPAIGN=" + dir.Name,
+		"TURBOLIFT_REPO=" + repo.FullRepoName,
+		"TURBOLIFT_HOST=" + repo.Host,
+		"TURBOLIFT_ORG=" + repo.OrgName,
+		"TURBOLIFT_REPO_NAME=" + repo.RepoName,
+		"TURBOLIFT_REPO_DIR=" + repoDir,
+		"TURBOLIFT_DEFAULT_BRANCH=" + defaultBranch(repo),
+	}
+	if repo.Path != "" {
+		env = append(env, "TURBOLIFT_REPO_PATH="+repo.Path)
+	}
+	return env
+}
+
+// defaultBranch is the default branch given for the repo in the repos file or, failing that, the branch that origin's
+// HEAD pointed to when the repo was cloned. It is empty if neither is known.
+func defaultBranch(repo campaign.Repo) string {
+	if repo.DefaultBranch != "" {
+		return repo.DefaultBranch
+	}
+	head, err := os.ReadFile(filepath.Join(repo.FullRepoPath(), ".git", "refs", "remotes", "origin", "HEAD"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.TrimSpace(string(head)), "ref: refs/remotes/origin/")
+}
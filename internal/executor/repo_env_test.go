@@ -0,0 +1,40 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package executor
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/testsupport"
+)
+
+func TestItPrefersTheDefaultBranchFromTheReposFile(t *testing.T) {
+	testsupport.CreateAndEnterTempDirectory()
+	_ = os.MkdirAll("work/org/repo1/.git/refs/remotes/origin", 0o755)
+	_ = os.WriteFile("work/org/repo1/.git/refs/remotes/origin/HEAD", []byte("ref: refs/remotes/origin/main\n"), 0o644)
+
+	repo := campaign.Repo{OrgName: "org", RepoName: "repo1", FullRepoName: "org/repo1"}
+	assert.Equal(t, "main", defaultBranch(repo))
+
+	repo.DefaultBranch = "release"
+	assert.Equal(t, "release", defaultBranch(repo))
+
+	assert.Equal(t, "", defaultBranch(campaign.Repo{OrgName: "org", RepoName: "repo2", FullRepoName: "org/repo2"}))
+}
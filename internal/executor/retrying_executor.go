@@ -0,0 +1,284 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skyscanner/turbolift/internal/tools"
+)
+
+// RetryPolicy says which failures of a command are worth trying again, and how often
+type RetryPolicy struct {
+	// Retries is how many times to run a failed command again, overriding DefaultRetries if set
+	Retries int
+	// Delay is how long to wait before the first retry, overriding DefaultRetryDelay if set. It doubles after each
+	// retry.
+	Delay time.Duration
+	// Retryable is true for failures worth trying again, given the error and the command's output
+	Retryable func(err error, output string) bool
+	// Idempotent is true for the commands that are safe to run again, given the command and its arguments. Others are
+	// only ever run once.
+	Idempotent func(name string, args []string) bool
+}
+
+// RetryingExecutor runs commands with another executor, and runs them again if they fail in a way that the policy
+// says is worth retrying, and the policy says they are safe to run again. It is meant for git and gh, whose commands
+// fail now and then because of the network or the server, rather than for the user's own commands.
+type RetryingExecutor struct {
+	Executor
+	Policy RetryPolicy
+}
+
+// DefaultRetries and DefaultRetryDelay are how many times failed commands are run again, and how long is waited before
+// the first retry, for policies that do not set their own. The CLI sets them from the --retries and --retry-delay
+// flags.
+var (
+	DefaultRetries    int
+	DefaultRetryDelay = 2 * time.Second
+)
+
+// outputTailSize is how much of the end of a command's output is kept, to decide whether its failure is retryable
+const outputTailSize = 4096
+
+// transientFailures are found, ignoring case, in the errors and output of commands that failed because of the
+// network or a temporary problem on the server
+var transientFailures = []string{
+	"connection reset",
+	"connection refused",
+	"connection timed out",
+	"i/o timeout",
+	"tls handshake timeout",
+	"could not resolve host",
+	"temporary failure in name resolution",
+	"the remote end hung up unexpectedly",
+	"unexpected disconnect",
+	"early eof",
+	"rpc failed",
+	"error connecting to",
+	"502 bad gateway",
+	"503 service unavailable",
+	"504 gateway timeout",
+	"http 502",
+	"http 503",
+	"http 504",
+	"something went wrong while executing your query",
+}
+
+// IsTransient is true for failures that look like network problems or temporary errors from the server, which are
+// likely to go away if the command is run again. Commands that were cancelled, timed out or are not installed are
+// never transient.
+func IsTransient(err error, output string) bool {
+	var missingErr *tools.MissingError
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.As(err, &missingErr) {
+		return false
+	}
+	failure := strings.ToLower(err.Error() + "\n" + output)
+	for _, pattern := range transientFailures {
+		if strings.Contains(failure, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// ghReads are the gh commands that only read, or clone, so can be run again after failing
+var ghReads = map[string]bool{
+	"auth status": true,
+	"auth token":  true,
+	"pr checks":   true,
+	"pr diff":     true,
+	"pr list":     true,
+	"pr status":   true,
+	"pr view":     true,
+	"repo clone":  true,
+	"repo list":   true,
+	"repo view":   true,
+}
+
+// IsIdempotent is true for the git and gh commands that read, fetch or clone. A failure that looks transient may have
+// happened after the server did what was asked, so commands that change anything remote, such as pushing, creating,
+// commenting on or merging a PR, or forking a repo, would then be done twice; they are never retried. git commands
+// that only change the working copy are safe to run again, as are pushes with --dry-run.
+func IsIdempotent(name string, args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	switch filepath.Base(name) {
+	case "git":
+		return args[0] != "push" || hasArg(args, "--dry-run")
+	case "gh":
+		if args[0] == "api" {
+			return isReadOnlyApiCall(args[1:])
+		}
+		return len(args) > 1 && ghReads[args[0]+" "+args[1]]
+	}
+	return false
+}
+
+// isReadOnlyApiCall is true for gh api calls that are GET requests: those that give no method and no fields, as fields
+// make gh send a POST, or that ask for GET explicitly
+func isReadOnlyApiCall(args []string) bool {
+	method := ""
+	hasFields := false
+	for i, arg := range args {
+		switch {
+		case arg == "-X" || arg == "--method":
+			if i+1 < len(args) {
+				method = args[i+1]
+			}
+		case strings.HasPrefix(arg, "--method="):
+			method = strings.TrimPrefix(arg, "--method=")
+		case strings.HasPrefix(arg, "-X") && len(arg) > 2:
+			method = strings.TrimPrefix(arg, "-X")
+		case arg == "-f" || arg == "-F" || arg == "--field" || arg == "--raw-field" || arg == "--input" ||
+			strings.HasPrefix(arg, "--field=") || strings.HasPrefix(arg, "--raw-field=") || strings.HasPrefix(arg, "--input="):
+			hasFields = true
+		}
+	}
+	if method != "" {
+		return strings.EqualFold(method, "GET")
+	}
+	return !hasFields
+}
+
+func hasArg(args []string, want string) bool {
+	for _, arg := range args {
+		if arg == want {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *RetryingExecutor) Execute(ctx context.Context, output io.Writer, workingDir string, name string, args ...string) error {
+	_, err := e.retry(ctx, output, name, args, func(output io.Writer) (string, error) {
+		return "", e.Executor.Execute(ctx, output, workingDir, name, args...)
+	})
+	return err
+}
+
+func (e *RetryingExecutor) ExecuteWithEnv(ctx context.Context, output io.Writer, workingDir string, env []string, name string, args ...string) error {
+	_, err := e.retry(ctx, output, name, args, func(output io.Writer) (string, error) {
+		return "", e.Executor.ExecuteWithEnv(ctx, output, workingDir, env, name, args...)
+	})
+	return err
+}
+
+func (e *RetryingExecutor) ExecuteAndCapture(ctx context.Context, output io.Writer, workingDir string, name string, args ...string) (string, error) {
+	return e.retry(ctx, output, name, args, func(output io.Writer) (string, error) {
+		return e.Executor.ExecuteAndCapture(ctx, output, workingDir, name, args...)
+	})
+}
+
+func (e *RetryingExecutor) ExecuteAndCaptureWithEnv(ctx context.Context, output io.Writer, workingDir string, env []string, name string, args ...string) (string, error) {
+	return e.retry(ctx, output, name, args, func(output io.Writer) (string, error) {
+		return e.Executor.ExecuteAndCaptureWithEnv(ctx, output, workingDir, env, name, args...)
+	})
+}
+
+// ExecuteCapturingStdout behaves like Execute. The stdout of any failed attempts is also written to stdout.
+func (e *RetryingExecutor) ExecuteCapturingStdout(ctx context.Context, output io.Writer, stdout io.Writer, workingDir string, name string, args ...string) error {
+	_, err := e.retry(ctx, output, name, args, func(output io.Writer) (string, error) {
+		return "", e.Executor.ExecuteCapturingStdout(ctx, output, stdout, workingDir, name, args...)
+	})
+	return err
+}
+
+// ExecuteCapturingStdoutWithEnv behaves like ExecuteWithEnv. The stdout of any failed attempts is also written to
+// stdout.
+func (e *RetryingExecutor) ExecuteCapturingStdoutWithEnv(ctx context.Context, output io.Writer, stdout io.Writer, workingDir string, env []string, name string, args ...string) error {
+	_, err := e.retry(ctx, output, name, args, func(output io.Writer) (string, error) {
+		return "", e.Executor.ExecuteCapturingStdoutWithEnv(ctx, output, stdout, workingDir, env, name, args...)
+	})
+	return err
+}
+
+// retry runs the command until it succeeds, fails in a way that is not retryable, or has been retried as often as
+// the policy allows, waiting longer before each retry. Commands that are not idempotent are only run once.
+func (e *RetryingExecutor) retry(ctx context.Context, output io.Writer, name string, args []string, run func(output io.Writer) (string, error)) (string, error) {
+	retries := e.Policy.Retries
+	if retries == 0 {
+		retries = DefaultRetries
+	}
+	delay := e.Policy.Delay
+	if delay == 0 {
+		delay = DefaultRetryDelay
+	}
+	retryable := e.Policy.Retryable
+	if retryable == nil {
+		retryable = IsTransient
+	}
+	idempotent := e.Policy.Idempotent
+	if idempotent == nil {
+		idempotent = IsIdempotent
+	}
+	if !idempotent(name, args) {
+		retries = 0
+	}
+
+	for retry := 1; ; retry++ {
+		tail := &tailWriter{size: outputTailSize}
+		captured, err := run(io.MultiWriter(output, tail))
+		if err == nil || retry > retries || ctx.Err() != nil || !retryable(err, tail.String()+captured) {
+			return captured, err
+		}
+
+		_, _ = fmt.Fprintf(output, "%s failed with what looks like a temporary problem, so trying again in %s (retry %d of %d): %s\n", name, delay, retry, retries, err)
+		select {
+		case <-ctx.Done():
+			return captured, err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// tailWriter keeps the last size bytes written to it
+type tailWriter struct {
+	mu   sync.Mutex
+	size int
+	tail []byte
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.tail = append(w.tail, p...)
+	if len(w.tail) > w.size {
+		w.tail = w.tail[len(w.tail)-w.size:]
+	}
+	return len(p), nil
+}
+
+func (w *tailWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return string(w.tail)
+}
+
+// NewRetryingExecutor retries the transient failures of commands run by another executor, as often as DefaultRetries
+// allows
+func NewRetryingExecutor(executor Executor) *RetryingExecutor {
+	return &RetryingExecutor{Executor: executor, Policy: RetryPolicy{Retryable: IsTransient, Idempotent: IsIdempotent}}
+}
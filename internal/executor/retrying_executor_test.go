@@ -0,0 +1,170 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package executor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/tools"
+)
+
+func failingTimes(failures int, err error) *FakeExecutor {
+	calls := 0
+	return NewFakeExecutor(func(string, string, ...string) error {
+		calls++
+		if calls <= failures {
+			return err
+		}
+		return nil
+	}, func(string, string, ...string) (string, error) {
+		calls++
+		if calls <= failures {
+			return "", err
+		}
+		return "captured", nil
+	})
+}
+
+func fastRetries(inner Executor, retries int) *RetryingExecutor {
+	retryingExecutor := NewRetryingExecutor(inner)
+	retryingExecutor.Policy.Retries = retries
+	retryingExecutor.Policy.Delay = time.Millisecond
+	return retryingExecutor
+}
+
+func TestRetryingExecutorRetriesTransientFailures(t *testing.T) {
+	fakeExecutor := failingTimes(2, errors.New("fatal: unable to access: Could not resolve host: github.com"))
+	output := &bytes.Buffer{}
+
+	err := fastRetries(fakeExecutor, 2).Execute(context.Background(), output, "work/org/repo1", "git", "pull")
+	assert.NoError(t, err)
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", "git", "pull"},
+		{"work/org/repo1", "git", "pull"},
+		{"work/org/repo1", "git", "pull"},
+	})
+	assert.Contains(t, output.String(), "git failed with what looks like a temporary problem, so trying again in 1ms (retry 1 of 2)")
+	assert.Contains(t, output.String(), "trying again in 2ms (retry 2 of 2)")
+}
+
+func TestRetryingExecutorReturnsCapturedOutputOfLastAttempt(t *testing.T) {
+	fakeExecutor := failingTimes(1, errors.New("HTTP 502: Bad Gateway"))
+
+	captured, err := fastRetries(fakeExecutor, 1).ExecuteAndCapture(context.Background(), &bytes.Buffer{}, "work/org/repo1", "gh", "pr", "view")
+	assert.NoError(t, err)
+	assert.Equal(t, "captured", captured)
+}
+
+func TestRetryingExecutorGivesUpAfterItsRetries(t *testing.T) {
+	fakeExecutor := failingTimes(10, errors.New("connection reset by peer"))
+
+	err := fastRetries(fakeExecutor, 1).Execute(context.Background(), &bytes.Buffer{}, "work/org/repo1", "git", "fetch")
+	assert.EqualError(t, err, "connection reset by peer")
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", "git", "fetch"},
+		{"work/org/repo1", "git", "fetch"},
+	})
+}
+
+func TestRetryingExecutorDoesNotRetryCommandsThatChangeThings(t *testing.T) {
+	fakeExecutor := failingTimes(10, errors.New("HTTP 502: Bad Gateway"))
+
+	_, err := fastRetries(fakeExecutor, 2).ExecuteAndCapture(context.Background(), &bytes.Buffer{}, "work/org/repo1", "gh", "pr", "create")
+	assert.EqualError(t, err, "HTTP 502: Bad Gateway")
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", "gh", "pr", "create"},
+	})
+}
+
+func TestRetryingExecutorDoesNotRetryOtherFailures(t *testing.T) {
+	fakeExecutor := failingTimes(1, errors.New("error: failed to push some refs"))
+
+	err := fastRetries(fakeExecutor, 2).Execute(context.Background(), &bytes.Buffer{}, "work/org/repo1", "git", "push")
+	assert.Error(t, err)
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", "git", "push"},
+	})
+}
+
+func TestRetryingExecutorStopsWhenCancelled(t *testing.T) {
+	fakeExecutor := failingTimes(10, errors.New("connection refused"))
+	retryingExecutor := NewRetryingExecutor(fakeExecutor)
+	retryingExecutor.Policy.Retries = 5
+	retryingExecutor.Policy.Delay = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	started := time.Now()
+	err := retryingExecutor.Execute(ctx, &bytes.Buffer{}, "work/org/repo1", "git", "pull")
+	assert.Error(t, err)
+	assert.Less(t, int64(time.Since(started)), int64(5*time.Second))
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", "git", "pull"},
+	})
+}
+
+func TestRetryingExecutorUsesItsPolicy(t *testing.T) {
+	fakeExecutor := failingTimes(1, errors.New("rate limited"))
+	retryingExecutor := fastRetries(fakeExecutor, 1)
+	retryingExecutor.Policy.Retryable = func(err error, output string) bool {
+		return err.Error() == "rate limited"
+	}
+
+	err := retryingExecutor.Execute(context.Background(), &bytes.Buffer{}, "work/org/repo1", "gh", "api")
+	assert.NoError(t, err)
+}
+
+func TestIsIdempotent(t *testing.T) {
+	assert.True(t, IsIdempotent("git", []string{"fetch", "origin"}))
+	assert.True(t, IsIdempotent("git", []string{"pull", "--ff-only"}))
+	assert.True(t, IsIdempotent("git", []string{"push", "--dry-run", "origin", "HEAD"}))
+	assert.False(t, IsIdempotent("git", []string{"push", "origin", "HEAD"}))
+	assert.False(t, IsIdempotent("git", []string{"push", "--delete", "origin", "branch"}))
+
+	assert.True(t, IsIdempotent("gh", []string{"pr", "view", "branch"}))
+	assert.True(t, IsIdempotent("gh", []string{"repo", "clone", "org/repo1"}))
+	assert.True(t, IsIdempotent("gh", []string{"api", "repos/org/repo1"}))
+	assert.True(t, IsIdempotent("gh", []string{"api", "-X", "GET", "search/code", "-f", "q=org:org"}))
+	assert.False(t, IsIdempotent("gh", []string{"api", "-X", "POST", "repos/org/repo1/forks"}))
+	assert.False(t, IsIdempotent("gh", []string{"api", "repos/org/repo1/issues/1/comments", "-f", "body=hello"}))
+	assert.False(t, IsIdempotent("gh", []string{"pr", "create", "--title", "title"}))
+	assert.False(t, IsIdempotent("gh", []string{"pr", "comment", "branch"}))
+	assert.False(t, IsIdempotent("gh", []string{"pr", "merge", "branch"}))
+	assert.False(t, IsIdempotent("gh", []string{"repo", "fork", "org/repo1", "--clone"}))
+
+	assert.False(t, IsIdempotent("sh", []string{"-c", "make"}))
+}
+
+func TestIsTransient(t *testing.T) {
+	assert.True(t, IsTransient(errors.New("exit status 128"), "fatal: the remote end hung up unexpectedly"))
+	assert.True(t, IsTransient(errors.New("exit status 1"), "HTTP 503: Service Unavailable"))
+	assert.True(t, IsTransient(errors.New("exit status 1"), "error connecting to api.github.com"))
+	assert.False(t, IsTransient(errors.New("exit status 1"), "could not create pull request"))
+	assert.False(t, IsTransient(context.DeadlineExceeded, "i/o timeout"))
+	assert.False(t, IsTransient(&tools.MissingError{Name: "gh", Err: errors.New("connection refused")}, ""))
+}
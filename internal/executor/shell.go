@@ -0,0 +1,119 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package executor
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// goos is the operating system that shells are chosen for, which tests can change
+var goos = runtime.GOOS
+
+// Shell returns the user's preferred shell, as given by $SHELL. Otherwise it falls back to sh, or on Windows to the
+// command interpreter given by %ComSpec%, usually cmd.exe.
+func Shell() string {
+	shellCommand := os.Getenv("SHELL")
+	if shellCommand == "" && goos == "windows" {
+		shellCommand = os.Getenv("ComSpec")
+		if shellCommand == "" {
+			shellCommand = "cmd.exe"
+		}
+	}
+	if shellCommand == "" {
+		shellCommand = "sh"
+	}
+	return shellCommand
+}
+
+// ShellCommand returns the command and arguments that run a script in the user's shell, passing the script any
+// further arguments. POSIX shells see the arguments as $1, $2 and so on. cmd.exe and PowerShell have no positional
+// parameters for scripts given on the command line, so the arguments are quoted and added to the end of the script.
+// cmd.exe is always given the script as the single argument after /C.
+func ShellCommand(script string, args ...string) (string, []string) {
+	shell := Shell()
+	switch shellName(shell) {
+	case "cmd":
+		for _, arg := range args {
+			script += " " + cmdQuote(arg)
+		}
+		return shell, []string{"/C", script}
+	case "powershell", "pwsh":
+		for _, arg := range args {
+			script += " '" + strings.ReplaceAll(arg, "'", "''") + "'"
+		}
+		return shell, []string{"-NoProfile", "-NonInteractive", "-Command", script}
+	}
+
+	shellArgs := []string{"-c", script}
+	if len(args) > 0 {
+		// the first argument after the script becomes $0, so turbolift is given as that
+		shellArgs = append(append(shellArgs, "turbolift"), args...)
+	}
+	return shell, shellArgs
+}
+
+// ScriptCommand returns the command and arguments that run a script file. Executables are run directly, so that their
+// shebang line is respected, and other scripts are run with the user's shell. Files on Windows have no executable bit,
+// so there programs and batch files are run directly, and PowerShell scripts with PowerShell.
+func ScriptCommand(scriptPath string, mode os.FileMode, args ...string) (string, []string) {
+	if goos == "windows" {
+		switch strings.ToLower(filepath.Ext(scriptPath)) {
+		case ".exe", ".com", ".bat", ".cmd":
+			return scriptPath, args
+		case ".ps1":
+			return "powershell", append([]string{"-NoProfile", "-NonInteractive", "-ExecutionPolicy", "Bypass", "-File", scriptPath}, args...)
+		}
+	} else if mode&0o111 != 0 {
+		return scriptPath, args
+	}
+	shell := Shell()
+	if shellName(shell) == "cmd" {
+		script := cmdQuote(scriptPath)
+		for _, arg := range args {
+			script += " " + cmdQuote(arg)
+		}
+		return shell, []string{"/C", script}
+	}
+	return shell, append([]string{scriptPath}, args...)
+}
+
+// shellName is the name of a shell without its directory or any .exe suffix, in lower case, e.g. cmd for
+// C:\Windows\system32\cmd.exe
+func shellName(shell string) string {
+	name := shell
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	return strings.TrimSuffix(strings.ToLower(name), ".exe")
+}
+
+// cmdQuote quotes an argument for cmd.exe, which takes a doubled quote inside quotes as a literal one
+func cmdQuote(arg string) string {
+	return `"` + strings.ReplaceAll(arg, `"`, `""`) + `"`
+}
+
+// cmdCommandLine is the command line that runs a script with cmd.exe. Go would quote the script as if cmd.exe parsed
+// its command line like other programs, escaping quotes with backslashes that cmd.exe keeps, so the command line is
+// built here instead. /S makes cmd.exe strip only the outer quotes and run everything between them as it is.
+func cmdCommandLine(shell string, script string) string {
+	if strings.ContainsAny(shell, " \t") {
+		shell = `"` + shell + `"`
+	}
+	return shell + ` /S /C "` + script + `"`
+}
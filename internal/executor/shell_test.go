@@ -0,0 +1,133 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package executor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/testsupport"
+)
+
+func withGoos(t *testing.T, os string) {
+	previous := goos
+	goos = os
+	t.Cleanup(func() { goos = previous })
+}
+
+func TestShellFallsBackToSh(t *testing.T) {
+	withGoos(t, "linux")
+	testsupport.Setenv(t, "SHELL", "")
+
+	assert.Equal(t, "sh", Shell())
+}
+
+func TestShellFallsBackToComSpecOnWindows(t *testing.T) {
+	withGoos(t, "windows")
+	testsupport.Setenv(t, "SHELL", "")
+	testsupport.Setenv(t, "ComSpec", `C:\Windows\system32\cmd.exe`)
+
+	assert.Equal(t, `C:\Windows\system32\cmd.exe`, Shell())
+}
+
+func TestShellPrefersShellOnWindows(t *testing.T) {
+	withGoos(t, "windows")
+	testsupport.Setenv(t, "SHELL", "/usr/bin/bash")
+
+	assert.Equal(t, "/usr/bin/bash", Shell())
+}
+
+func TestShellCommandPassesArgumentsPositionallyToPosixShells(t *testing.T) {
+	testsupport.Setenv(t, "SHELL", "/bin/zsh")
+
+	shell, args := ShellCommand(`cat "$1"`, "body.md", "org/repo1")
+
+	assert.Equal(t, "/bin/zsh", shell)
+	assert.Equal(t, []string{"-c", `cat "$1"`, "turbolift", "body.md", "org/repo1"}, args)
+}
+
+func TestShellCommandWithoutArguments(t *testing.T) {
+	testsupport.Setenv(t, "SHELL", "/bin/bash")
+
+	_, args := ShellCommand("echo hello")
+
+	assert.Equal(t, []string{"-c", "echo hello"}, args)
+}
+
+func TestShellCommandQuotesArgumentsForCmd(t *testing.T) {
+	withGoos(t, "windows")
+	testsupport.Setenv(t, "SHELL", "")
+	testsupport.Setenv(t, "ComSpec", `C:\Windows\system32\CMD.EXE`)
+
+	shell, args := ShellCommand("type", `C:\Temp\body "1".md`)
+
+	assert.Equal(t, `C:\Windows\system32\CMD.EXE`, shell)
+	assert.Equal(t, []string{"/C", `type "C:\Temp\body ""1"".md"`}, args)
+}
+
+func TestShellCommandQuotesArgumentsForPowerShell(t *testing.T) {
+	withGoos(t, "windows")
+	testsupport.Setenv(t, "SHELL", "pwsh")
+
+	shell, args := ShellCommand("Get-Content", "it's.md")
+
+	assert.Equal(t, "pwsh", shell)
+	assert.Equal(t, []string{"-NoProfile", "-NonInteractive", "-Command", "Get-Content 'it''s.md'"}, args)
+}
+
+func TestScriptCommandRunsExecutablesDirectly(t *testing.T) {
+	withGoos(t, "linux")
+
+	command, args := ScriptCommand("/tmp/script.sh", 0o755, "arg1")
+
+	assert.Equal(t, "/tmp/script.sh", command)
+	assert.Equal(t, []string{"arg1"}, args)
+}
+
+func TestScriptCommandRunsOtherScriptsWithTheShell(t *testing.T) {
+	withGoos(t, "linux")
+	testsupport.Setenv(t, "SHELL", "/bin/bash")
+
+	command, args := ScriptCommand("/tmp/script.sh", 0o644, "arg1")
+
+	assert.Equal(t, "/bin/bash", command)
+	assert.Equal(t, []string{"/tmp/script.sh", "arg1"}, args)
+}
+
+func TestScriptCommandOnWindows(t *testing.T) {
+	withGoos(t, "windows")
+	testsupport.Setenv(t, "SHELL", "")
+	testsupport.Setenv(t, "ComSpec", "cmd.exe")
+
+	command, args := ScriptCommand(`C:\scripts\fix.BAT`, 0o666, "arg1")
+	assert.Equal(t, `C:\scripts\fix.BAT`, command)
+	assert.Equal(t, []string{"arg1"}, args)
+
+	command, args = ScriptCommand(`C:\scripts\fix.ps1`, 0o666, "arg1")
+	assert.Equal(t, "powershell", command)
+	assert.Equal(t, []string{"-NoProfile", "-NonInteractive", "-ExecutionPolicy", "Bypass", "-File", `C:\scripts\fix.ps1`, "arg1"}, args)
+
+	// files have no executable bit on Windows, so anything else goes to the shell
+	command, args = ScriptCommand(`C:\scripts\fix.sh`, 0o777, "arg1")
+	assert.Equal(t, "cmd.exe", command)
+	assert.Equal(t, []string{"/C", `"C:\scripts\fix.sh" "arg1"`}, args)
+}
+
+func TestCmdCommandLineKeepsTheScriptAsItIs(t *testing.T) {
+	assert.Equal(t, `cmd.exe /S /C "type "C:\Temp\body ""1"".md""`, cmdCommandLine("cmd.exe", `type "C:\Temp\body ""1"".md"`))
+	assert.Equal(t, `"C:\Program Files\cmd.exe" /S /C "echo a\"b"`, cmdCommandLine(`C:\Program Files\cmd.exe`, `echo a\"b`))
+}
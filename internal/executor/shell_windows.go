@@ -0,0 +1,38 @@
+//go:build windows
+// +build windows
+
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package executor
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// useShellCommandLine gives cmd.exe the command line built by cmdCommandLine, rather than the one Go would build from
+// the command's arguments. Anything else already set in SysProcAttr, such as its creation flags, is kept.
+func useShellCommandLine(command *exec.Cmd) {
+	if len(command.Args) != 3 || shellName(command.Args[0]) != "cmd" || command.Args[1] != "/C" {
+		return
+	}
+	if command.SysProcAttr == nil {
+		command.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	if command.SysProcAttr.CmdLine == "" {
+		command.SysProcAttr.CmdLine = cmdCommandLine(command.Args[0], command.Args[2])
+	}
+}
@@ -0,0 +1,45 @@
+//go:build windows
+// +build windows
+
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package executor
+
+import (
+	"os/exec"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCmdIsGivenItsCommandLineWithoutLosingItsProcessGroup(t *testing.T) {
+	command := exec.Command("cmd.exe", "/C", `echo "a b"`)
+
+	startInOwnProcessGroup(command)
+	useShellCommandLine(command)
+
+	assert.Equal(t, `cmd.exe /S /C "echo "a b""`, command.SysProcAttr.CmdLine)
+	assert.NotZero(t, command.SysProcAttr.CreationFlags&syscall.CREATE_NEW_PROCESS_GROUP)
+}
+
+func TestOtherCommandsKeepTheCommandLineGoBuilds(t *testing.T) {
+	command := exec.Command("git", "commit", "-m", `say "hi"`)
+
+	useShellCommandLine(command)
+
+	assert.Nil(t, command.SysProcAttr)
+}
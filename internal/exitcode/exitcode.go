@@ -0,0 +1,91 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package exitcode works out the exit code of a run, so that scripts and CI can tell whether every repo was dealt with.
+package exitcode
+
+import "sync"
+
+// The exit codes of turbolift, from the best outcome to the worst
+const (
+	// OK means that the command completed, and every repo was dealt with
+	OK = 0
+	// Skipped means that the command completed, but some repos were skipped, for example because they were not cloned
+	Skipped = 3
+	// Interrupted means that the command was stopped before it had dealt with every repo
+	Interrupted = 130
+	// Errored means that the command completed, but failed for some repos
+	Errored = 2
+	// Failed means that the command could not be run, or part of it failed
+	Failed = 1
+)
+
+var severity = map[int]int{OK: 0, Skipped: 1, Interrupted: 2, Errored: 3, Failed: 4}
+
+var (
+	mu       sync.Mutex
+	recorded bool
+	outcome  int
+	failed   bool
+)
+
+// Record notes the outcome of a command over the campaign's repos. The summary it is taken from accounts for every
+// repo, so it takes the place of any failures noted along the way.
+func Record(interrupted bool, skipped int, errored int) {
+	code := OK
+	if errored > 0 {
+		code = Errored
+	} else if interrupted {
+		code = Interrupted
+	} else if skipped > 0 {
+		code = Skipped
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !recorded || severity[code] > severity[outcome] {
+		outcome = code
+	}
+	recorded = true
+}
+
+// Fail notes that something went wrong. This sets the exit code of commands that do not record an outcome.
+func Fail() {
+	mu.Lock()
+	defer mu.Unlock()
+	failed = true
+}
+
+// Code returns the exit code for everything recorded so far
+func Code() int {
+	mu.Lock()
+	defer mu.Unlock()
+	if recorded {
+		return outcome
+	}
+	if failed {
+		return Failed
+	}
+	return OK
+}
+
+// Reset forgets everything recorded so far
+func Reset() {
+	mu.Lock()
+	defer mu.Unlock()
+	recorded = false
+	outcome = OK
+	failed = false
+}
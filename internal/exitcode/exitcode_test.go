@@ -0,0 +1,62 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package exitcode
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestItIsOKUnlessSomethingWentWrong(t *testing.T) {
+	Reset()
+	assert.Equal(t, OK, Code())
+
+	Record(false, 0, 0)
+	assert.Equal(t, OK, Code())
+
+	Reset()
+	Fail()
+	assert.Equal(t, Failed, Code())
+}
+
+func TestItDistinguishesSkippedErroredAndInterruptedRuns(t *testing.T) {
+	Reset()
+	Record(false, 2, 0)
+	assert.Equal(t, Skipped, Code())
+
+	Reset()
+	Record(true, 2, 0)
+	assert.Equal(t, Interrupted, Code())
+
+	Reset()
+	Record(true, 2, 1)
+	assert.Equal(t, Errored, Code())
+}
+
+func TestRecordedOutcomesTakeThePlaceOfFailures(t *testing.T) {
+	Reset()
+	Fail()
+	Record(false, 0, 1)
+	assert.Equal(t, Errored, Code())
+}
+
+func TestTheWorstRecordedOutcomeWins(t *testing.T) {
+	Reset()
+	Record(false, 0, 1)
+	Record(false, 1, 0)
+	assert.Equal(t, Errored, Code())
+}
@@ -0,0 +1,31 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package forge picks the client for the forge that each of a campaign's repos is hosted on, so that every command
+// sends a repo's API calls to the same place.
+package forge
+
+import (
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/github"
+)
+
+// For returns the client for the forge that the repo is hosted on, out of the clients for each forge
+func For(repo campaign.Repo, gitHub github.GitHub, bitbucket github.GitHub) github.GitHub {
+	if repo.OnBitbucket() {
+		return bitbucket
+	}
+	return gitHub
+}
@@ -0,0 +1,34 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package forge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/github"
+)
+
+func TestItPicksTheForgeThatTheRepoIsHostedOn(t *testing.T) {
+	gitHub := github.NewAlwaysSucceedsFakeGitHub()
+	bitbucket := github.NewAlwaysSucceedsFakeGitHub()
+
+	assert.Same(t, gitHub, For(campaign.Repo{FullRepoName: "org/repo"}, gitHub, bitbucket))
+	assert.Same(t, gitHub, For(campaign.Repo{FullRepoName: "org/repo", Forge: campaign.GitHubForge}, gitHub, bitbucket))
+	assert.Same(t, bitbucket, For(campaign.Repo{FullRepoName: "bitbucket.example.com/PROJ/repo", Forge: campaign.BitbucketForge}, gitHub, bitbucket))
+}
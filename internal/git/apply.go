@@ -0,0 +1,150 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package git
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// ApplyOptions controls how Apply applies a patch. The zero value applies it as git apply does by default.
+type ApplyOptions struct {
+	// ThreeWay falls back to a three-way merge for hunks that do not apply cleanly, leaving conflict markers in files
+	// that cannot be merged
+	ThreeWay bool
+	// Check only finds out whether the patch would apply, leaving the working copy alone
+	Check bool
+	// Reverse undoes the patch rather than applying it, which with Check tells whether it has already been applied
+	Reverse bool
+	// Directory is prepended to the paths in the patch, for campaigns scoped to a directory within the repo
+	Directory string
+}
+
+// args returns the extra arguments to pass to git apply
+func (o ApplyOptions) args() []string {
+	args := []string{}
+	if o.ThreeWay {
+		args = append(args, "--3way")
+	}
+	if o.Check {
+		args = append(args, "--check")
+	}
+	if o.Reverse {
+		args = append(args, "--reverse")
+	}
+	if o.Directory != "" {
+		args = append(args, "--directory="+o.Directory)
+	}
+	return args
+}
+
+// Apply applies a patch to the working copy with git apply, returning a PatchConflictError if it does not apply
+func (r *RealGit) Apply(ctx context.Context, output io.Writer, workingDir string, patchFile string, options ApplyOptions) error {
+	// git's output is kept so that the files the patch does not apply to can be picked out of it
+	var applyOutput bytes.Buffer
+	args := append([]string{"apply"}, options.args()...)
+	err := execInstance.Execute(ctx, io.MultiWriter(output, &applyOutput), workingDir, "git", append(args, patchFile)...)
+	if err != nil {
+		return asPatchConflict(filepath.Base(patchFile), applyOutput.String(), err)
+	}
+	return nil
+}
+
+// PatchConflictError is returned when a patch does not apply to a working copy
+type PatchConflictError struct {
+	Patch string
+	// Files are those that the patch does not apply to
+	Files []string
+	// Conflicted is true if a three-way merge left conflict markers in the files, rather than leaving them alone
+	Conflicted bool
+	Err        error
+}
+
+func (e *PatchConflictError) Error() string {
+	if e.Conflicted {
+		return fmt.Sprintf("patch %s left conflicts in %s", e.Patch, strings.Join(e.Files, ", "))
+	}
+	return fmt.Sprintf("patch %s does not apply to %s", e.Patch, strings.Join(e.Files, ", "))
+}
+
+func (e *PatchConflictError) Unwrap() error {
+	return e.Err
+}
+
+// the reasons git apply gives for not applying a patch to a file, after "error: <file>: "
+var patchFileErrors = []string{"patch does not apply", "does not exist in index", "already exists in working directory", "No such file or directory", "does not match index"}
+
+// asPatchConflict recognises a patch that did not apply from git's output, and collects the files it did not apply
+// to. Three-way merges list the files they left conflicts in as "U <file>". Other failures, such as a corrupt patch,
+// are returned as they are.
+func asPatchConflict(patch string, output string, err error) error {
+	conflict := &PatchConflictError{Patch: patch, Err: err}
+	seen := map[string]bool{}
+	addFile := func(file string) {
+		if file != "" && !seen[file] {
+			seen[file] = true
+			conflict.Files = append(conflict.Files, file)
+		}
+	}
+
+	var conflicted []string
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, "U ") {
+			conflicted = append(conflicted, strings.TrimSpace(strings.TrimPrefix(line, "U ")))
+			continue
+		}
+		if !strings.HasPrefix(line, "error: ") {
+			continue
+		}
+		rest := strings.TrimPrefix(line, "error: ")
+		if strings.HasPrefix(rest, "patch failed: ") {
+			file := strings.TrimPrefix(rest, "patch failed: ")
+			if i := strings.LastIndex(file, ":"); i >= 0 {
+				file = file[:i]
+			}
+			addFile(file)
+			continue
+		}
+		for _, reason := range patchFileErrors {
+			if strings.HasSuffix(rest, ": "+reason) {
+				addFile(strings.TrimSuffix(rest, ": "+reason))
+			}
+		}
+	}
+
+	if len(conflicted) > 0 {
+		conflict.Conflicted = true
+		conflict.Files = conflicted
+	}
+	if len(conflict.Files) == 0 {
+		return err
+	}
+	return conflict
+}
+
+// AsPatchConflict is the PatchConflictError that the error is, or wraps, if there is one
+func AsPatchConflict(err error) (*PatchConflictError, bool) {
+	var conflictErr *PatchConflictError
+	if errors.As(err, &conflictErr) {
+		return conflictErr, true
+	}
+	return nil, false
+}
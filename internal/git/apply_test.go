@@ -0,0 +1,77 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package git
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/executor"
+)
+
+func TestItRecognisesPatchesThatDoNotApply(t *testing.T) {
+	exitErr := errors.New("exit status 1")
+	output := `error: patch failed: a.txt:1
+error: a.txt: patch does not apply
+error: docs/b.md: No such file or directory
+`
+
+	conflictErr, ok := AsPatchConflict(asPatchConflict("fix.patch", output, exitErr))
+	assert.True(t, ok)
+	assert.Equal(t, []string{"a.txt", "docs/b.md"}, conflictErr.Files)
+	assert.False(t, conflictErr.Conflicted)
+	assert.ErrorIs(t, conflictErr, exitErr)
+	assert.Equal(t, "patch fix.patch does not apply to a.txt, docs/b.md", conflictErr.Error())
+}
+
+func TestItRecognisesConflictsLeftByThreeWayMerges(t *testing.T) {
+	output := `error: patch failed: a.txt:1
+Falling back to three-way merge...
+Applied patch to 'a.txt' with conflicts.
+U a.txt
+`
+
+	conflictErr, ok := AsPatchConflict(asPatchConflict("fix.patch", output, errors.New("exit status 1")))
+	assert.True(t, ok)
+	assert.Equal(t, []string{"a.txt"}, conflictErr.Files)
+	assert.True(t, conflictErr.Conflicted)
+	assert.Equal(t, "patch fix.patch left conflicts in a.txt", conflictErr.Error())
+}
+
+func TestItDoesNotTreatCorruptPatchesAsConflicts(t *testing.T) {
+	err := asPatchConflict("fix.patch", `error: No valid patches in input (allow with "--allow-empty")`, errors.New("exit status 128"))
+	_, ok := AsPatchConflict(err)
+	assert.False(t, ok)
+}
+
+func TestItAppliesPatchesWithOptions(t *testing.T) {
+	fakeExecutor := executor.NewAlwaysSucceedsFakeExecutor()
+	execInstance = fakeExecutor
+
+	err := NewRealGit().Apply(context.Background(), &strings.Builder{}, "work/org/repo1", "/campaign/fix.patch", ApplyOptions{ThreeWay: true, Directory: "services/api"})
+	assert.NoError(t, err)
+	err = NewRealGit().Apply(context.Background(), &strings.Builder{}, "work/org/repo1", "/campaign/fix.patch", ApplyOptions{Check: true, Reverse: true})
+	assert.NoError(t, err)
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", "git", "apply", "--3way", "--directory=services/api", "/campaign/fix.patch"},
+		{"work/org/repo1", "git", "apply", "--check", "--reverse", "/campaign/fix.patch"},
+	})
+}
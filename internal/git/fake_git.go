@@ -16,52 +16,254 @@
 package git
 
 import (
+	"context"
 	"errors"
 	"github.com/stretchr/testify/assert"
 	"io"
+	"path/filepath"
 	"testing"
 )
 
 type FakeGit struct {
 	handler func(output io.Writer, call []string) (bool, error)
 	calls   [][]string
+	changed []string
+	diff    string
+	remotes map[string]string
+	stats   map[string]DiffStat
+	commits []string
 }
 
-func (f *FakeGit) Checkout(output io.Writer, workingDir string, branch string) error {
+func (f *FakeGit) Checkout(_ context.Context, output io.Writer, workingDir string, branch string) error {
 	call := []string{"checkout", workingDir, branch}
 	f.calls = append(f.calls, call)
 	_, err := f.handler(output, call)
 	return err
 }
 
-func (f *FakeGit) Commit(output io.Writer, workingDir string, message string) error {
-	call := []string{"commit", workingDir, message}
+func (f *FakeGit) Commit(_ context.Context, output io.Writer, workingDir string, message string, options CommitOptions) error {
+	call := append([]string{"commit", workingDir, message}, options.args()...)
+	call = append(call, options.env()...)
 	f.calls = append(f.calls, call)
 	_, err := f.handler(output, call)
 	return err
 }
 
-func (f *FakeGit) IsRepoChanged(output io.Writer, workingDir string) (bool, error) {
+func (f *FakeGit) IsRepoChanged(_ context.Context, output io.Writer, workingDir string) (bool, error) {
 	call := []string{"isRepoChanged", workingDir}
 	f.calls = append(f.calls, call)
 	result, err := f.handler(output, call)
 	return result, err
 }
 
-func (f *FakeGit) Push(output io.Writer, workingDir string, _ string, branchName string) error {
+func (f *FakeGit) Push(_ context.Context, output io.Writer, workingDir string, _ string, branchName string) error {
 	call := []string{"push", workingDir, branchName}
 	f.calls = append(f.calls, call)
 	_, err := f.handler(output, call)
 	return err
 }
 
-func (f *FakeGit) Pull(output io.Writer, workingDir string, remote string, branchName string) error {
+func (f *FakeGit) ForcePush(_ context.Context, output io.Writer, workingDir string, _ string, branchName string) error {
+	call := []string{"forcePush", workingDir, branchName}
+	f.calls = append(f.calls, call)
+	_, err := f.handler(output, call)
+	return err
+}
+
+func (f *FakeGit) Pull(_ context.Context, output io.Writer, workingDir string, remote string, branchName string) error {
 	call := []string{"pull", "--ff-only", workingDir, remote, branchName}
 	f.calls = append(f.calls, call)
 	_, err := f.handler(output, call)
 	return err
 }
 
+func (f *FakeGit) IsAheadOfDefaultBranch(_ context.Context, output io.Writer, workingDir string, baseBranch string) (bool, error) {
+	call := []string{"isAheadOfDefaultBranch", workingDir}
+	if baseBranch != "" {
+		call = append(call, baseBranch)
+	}
+	f.calls = append(f.calls, call)
+	return f.handler(output, call)
+}
+
+func (f *FakeGit) CampaignCommits(_ context.Context, output io.Writer, workingDir string, baseBranch string) ([]string, error) {
+	call := []string{"campaignCommits", workingDir}
+	if baseBranch != "" {
+		call = append(call, baseBranch)
+	}
+	f.calls = append(f.calls, call)
+	_, err := f.handler(output, call)
+	return f.commits, err
+}
+
+// WithCampaignCommits sets the commit subjects that CampaignCommits reports for every working copy
+func (f *FakeGit) WithCampaignCommits(subjects ...string) *FakeGit {
+	f.commits = subjects
+	return f
+}
+
+func (f *FakeGit) ChangedFiles(_ context.Context, output io.Writer, workingDir string, baseBranch string) ([]string, error) {
+	call := []string{"changedFiles", workingDir}
+	if baseBranch != "" {
+		call = append(call, baseBranch)
+	}
+	f.calls = append(f.calls, call)
+	_, err := f.handler(output, call)
+	return f.changed, err
+}
+
+// WithChangedFiles sets the files that ChangedFiles reports for every working copy
+func (f *FakeGit) WithChangedFiles(files ...string) *FakeGit {
+	f.changed = files
+	return f
+}
+
+func (f *FakeGit) Diff(_ context.Context, output io.Writer, workingDir string, baseBranch string) (string, error) {
+	call := []string{"diff", workingDir}
+	if baseBranch != "" {
+		call = append(call, baseBranch)
+	}
+	f.calls = append(f.calls, call)
+	_, err := f.handler(output, call)
+	return f.diff, err
+}
+
+// WithDiff sets the diff that Diff and UncommittedDiff report for every working copy
+func (f *FakeGit) WithDiff(diff string) *FakeGit {
+	f.diff = diff
+	return f
+}
+
+func (f *FakeGit) UncommittedDiff(_ context.Context, output io.Writer, workingDir string) (string, error) {
+	call := []string{"uncommittedDiff", workingDir}
+	f.calls = append(f.calls, call)
+	_, err := f.handler(output, call)
+	return f.diff, err
+}
+
+func (f *FakeGit) DiffStat(_ context.Context, output io.Writer, workingDir string, baseBranch string) (DiffStat, error) {
+	call := []string{"diffStat", workingDir}
+	if baseBranch != "" {
+		call = append(call, baseBranch)
+	}
+	f.calls = append(f.calls, call)
+	_, err := f.handler(output, call)
+	return f.stats[workingDir], err
+}
+
+// WithDiffStats sets the size of the changes that DiffStat reports, by working copy. Others have no changes.
+func (f *FakeGit) WithDiffStats(stats map[string]DiffStat) *FakeGit {
+	f.stats = stats
+	return f
+}
+
+func (f *FakeGit) HasRemote(_ context.Context, output io.Writer, workingDir string, remote string) (bool, error) {
+	call := []string{"hasRemote", workingDir, remote}
+	f.calls = append(f.calls, call)
+	return f.handler(output, call)
+}
+
+func (f *FakeGit) HasRemoteBranch(_ context.Context, output io.Writer, workingDir string, remote string, branchName string) (bool, error) {
+	call := []string{"hasRemoteBranch", workingDir, remote, branchName}
+	f.calls = append(f.calls, call)
+	return f.handler(output, call)
+}
+
+func (f *FakeGit) DeleteRemoteBranch(_ context.Context, output io.Writer, workingDir string, remote string, branchName string) error {
+	call := []string{"deleteRemoteBranch", workingDir, remote, branchName}
+	f.calls = append(f.calls, call)
+	_, err := f.handler(output, call)
+	return err
+}
+
+func (f *FakeGit) CanPush(_ context.Context, output io.Writer, workingDir string, remote string, branchName string) error {
+	call := []string{"canPush", workingDir, remote, branchName}
+	f.calls = append(f.calls, call)
+	_, err := f.handler(output, call)
+	return err
+}
+
+func (f *FakeGit) SyncFork(_ context.Context, output io.Writer, workingDir string, branchName string) error {
+	call := []string{"syncFork", workingDir, branchName}
+	f.calls = append(f.calls, call)
+	_, err := f.handler(output, call)
+	return err
+}
+
+func (f *FakeGit) DiscardChanges(_ context.Context, output io.Writer, workingDir string) error {
+	call := []string{"discardChanges", workingDir}
+	f.calls = append(f.calls, call)
+	_, err := f.handler(output, call)
+	return err
+}
+
+func (f *FakeGit) ResetBranch(_ context.Context, output io.Writer, workingDir string, remote string, defaultBranch string, branchName string) error {
+	call := []string{"resetBranch", workingDir, remote, defaultBranch, branchName}
+	f.calls = append(f.calls, call)
+	_, err := f.handler(output, call)
+	return err
+}
+
+func (f *FakeGit) IsOnBranch(_ context.Context, output io.Writer, workingDir string, branchName string) (bool, error) {
+	call := []string{"isOnBranch", workingDir, branchName}
+	f.calls = append(f.calls, call)
+	return f.handler(output, call)
+}
+
+func (f *FakeGit) SwitchBranch(_ context.Context, output io.Writer, workingDir string, branchName string) error {
+	call := []string{"switchBranch", workingDir, branchName}
+	f.calls = append(f.calls, call)
+	_, err := f.handler(output, call)
+	return err
+}
+
+// RemoteUrl returns the URL set for the remote with WithRemoteUrls, or otherwise a GitHub URL for the working copy's repo
+func (f *FakeGit) RemoteUrl(_ context.Context, output io.Writer, workingDir string, remote string) (string, error) {
+	call := []string{"remoteUrl", workingDir, remote}
+	f.calls = append(f.calls, call)
+	if _, err := f.handler(output, call); err != nil {
+		return "", err
+	}
+	if url, ok := f.remotes[remote]; ok {
+		return url, nil
+	}
+	return "https://github.com/" + filepath.Base(filepath.Dir(workingDir)) + "/" + filepath.Base(workingDir) + ".git", nil
+}
+
+// WithRemoteUrls sets the URLs that RemoteUrl reports for every working copy, by remote name
+func (f *FakeGit) WithRemoteUrls(remotes map[string]string) *FakeGit {
+	f.remotes = remotes
+	return f
+}
+
+func (f *FakeGit) SetRemoteUrl(_ context.Context, output io.Writer, workingDir string, remote string, url string) error {
+	call := []string{"setRemoteUrl", workingDir, remote, url}
+	f.calls = append(f.calls, call)
+	_, err := f.handler(output, call)
+	return err
+}
+
+func (f *FakeGit) Verify(_ context.Context, output io.Writer, workingDir string) error {
+	call := []string{"verify", workingDir}
+	f.calls = append(f.calls, call)
+	_, err := f.handler(output, call)
+	return err
+}
+
+func (f *FakeGit) SparseCheckout(_ context.Context, output io.Writer, workingDir string, paths ...string) error {
+	call := append([]string{"sparseCheckout", workingDir}, paths...)
+	f.calls = append(f.calls, call)
+	_, err := f.handler(output, call)
+	return err
+}
+
+func (f *FakeGit) Apply(_ context.Context, output io.Writer, workingDir string, patchFile string, options ApplyOptions) error {
+	call := append([]string{"apply", workingDir, patchFile}, options.args()...)
+	f.calls = append(f.calls, call)
+	_, err := f.handler(output, call)
+	return err
+}
+
 func (f *FakeGit) AssertCalledWith(t *testing.T, expected [][]string) {
 	assert.Equal(t, expected, f.calls)
 }
@@ -16,62 +16,367 @@
 package git
 
 import (
+	"bytes"
+	"context"
 	"io"
-	"os"
+	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/skyscanner/turbolift/internal/executor"
 )
 
-var execInstance executor.Executor = executor.NewRealExecutor()
+var execInstance executor.Executor = executor.NewRetryingExecutor(executor.NewRealExecutor())
 
 type Git interface {
-	Checkout(output io.Writer, workingDir string, branch string) error
-	Push(stdout io.Writer, workingDir string, remote string, branchName string) error
-	Commit(output io.Writer, workingDir string, message string) error
-	IsRepoChanged(output io.Writer, workingDir string) (bool, error)
-	Pull(output io.Writer, workingDir string, remote string, branchName string) error
+	Checkout(ctx context.Context, output io.Writer, workingDir string, branch string) error
+	Push(ctx context.Context, stdout io.Writer, workingDir string, remote string, branchName string) error
+	ForcePush(ctx context.Context, stdout io.Writer, workingDir string, remote string, branchName string) error
+	Commit(ctx context.Context, output io.Writer, workingDir string, message string, options CommitOptions) error
+	IsRepoChanged(ctx context.Context, output io.Writer, workingDir string) (bool, error)
+	Pull(ctx context.Context, output io.Writer, workingDir string, remote string, branchName string) error
+	// IsAheadOfDefaultBranch, CampaignCommits, ChangedFiles, Diff and DiffStat compare the current branch with
+	// baseBranch on origin, or origin's default branch if it is empty
+	IsAheadOfDefaultBranch(ctx context.Context, output io.Writer, workingDir string, baseBranch string) (bool, error)
+	CampaignCommits(ctx context.Context, output io.Writer, workingDir string, baseBranch string) ([]string, error)
+	ChangedFiles(ctx context.Context, output io.Writer, workingDir string, baseBranch string) ([]string, error)
+	Diff(ctx context.Context, output io.Writer, workingDir string, baseBranch string) (string, error)
+	UncommittedDiff(ctx context.Context, output io.Writer, workingDir string) (string, error)
+	DiffStat(ctx context.Context, output io.Writer, workingDir string, baseBranch string) (DiffStat, error)
+	HasRemote(ctx context.Context, output io.Writer, workingDir string, remote string) (bool, error)
+	HasRemoteBranch(ctx context.Context, output io.Writer, workingDir string, remote string, branchName string) (bool, error)
+	DeleteRemoteBranch(ctx context.Context, output io.Writer, workingDir string, remote string, branchName string) error
+	CanPush(ctx context.Context, output io.Writer, workingDir string, remote string, branchName string) error
+	SyncFork(ctx context.Context, output io.Writer, workingDir string, branchName string) error
+	DiscardChanges(ctx context.Context, output io.Writer, workingDir string) error
+	ResetBranch(ctx context.Context, output io.Writer, workingDir string, remote string, defaultBranch string, branchName string) error
+	IsOnBranch(ctx context.Context, output io.Writer, workingDir string, branchName string) (bool, error)
+	SwitchBranch(ctx context.Context, output io.Writer, workingDir string, branchName string) error
+	RemoteUrl(ctx context.Context, output io.Writer, workingDir string, remote string) (string, error)
+	SetRemoteUrl(ctx context.Context, output io.Writer, workingDir string, remote string, url string) error
+	Verify(ctx context.Context, output io.Writer, workingDir string) error
+	SparseCheckout(ctx context.Context, output io.Writer, workingDir string, paths ...string) error
+	Apply(ctx context.Context, output io.Writer, workingDir string, patchFile string, options ApplyOptions) error
+}
+
+// CommitOptions controls signing and attribution of commits. The zero value gives git's default behaviour.
+type CommitOptions struct {
+	GpgSign        bool
+	Signoff        bool
+	AuthorName     string
+	AuthorEmail    string
+	CommitterName  string
+	CommitterEmail string
+	// Amend replaces the last commit rather than adding a new one
+	Amend bool
+}
+
+// args returns the extra arguments to pass to git commit
+func (o CommitOptions) args() []string {
+	args := []string{}
+	if o.GpgSign {
+		args = append(args, "--gpg-sign")
+	}
+	if o.Signoff {
+		args = append(args, "--signoff")
+	}
+	if o.Amend {
+		args = append(args, "--amend")
+	}
+	return args
+}
+
+// env returns the environment variables that override the author and committer identities
+func (o CommitOptions) env() []string {
+	env := []string{}
+	for name, value := range map[string]string{
+		"GIT_AUTHOR_NAME":     o.AuthorName,
+		"GIT_AUTHOR_EMAIL":    o.AuthorEmail,
+		"GIT_COMMITTER_NAME":  o.CommitterName,
+		"GIT_COMMITTER_EMAIL": o.CommitterEmail,
+	} {
+		if value != "" {
+			env = append(env, name+"="+value)
+		}
+	}
+	sort.Strings(env)
+	return env
 }
 
 type RealGit struct{}
 
-func (r *RealGit) Checkout(output io.Writer, workingDir string, branchName string) error {
-	return execInstance.Execute(output, workingDir, "git", "checkout", "-b", branchName)
+func (r *RealGit) Checkout(ctx context.Context, output io.Writer, workingDir string, branchName string) error {
+	return execInstance.Execute(ctx, output, workingDir, "git", "checkout", "-b", branchName)
+}
+
+// Push pushes the branch, returning a PushRejectedError if the remote rejects it
+func (r *RealGit) Push(ctx context.Context, output io.Writer, workingDir string, remote string, branchName string) error {
+	// git's output is kept so that the reasons for a rejection can be picked out of it
+	var pushOutput bytes.Buffer
+	err := execInstance.Execute(ctx, io.MultiWriter(output, &pushOutput), workingDir, "git", "push", "-u", remote, branchName)
+	if err != nil {
+		return asPushRejected(pushOutput.String(), err)
+	}
+	return nil
 }
 
-func (r *RealGit) Push(output io.Writer, workingDir string, remote string, branchName string) error {
-	return execInstance.Execute(output, workingDir, "git", "push", "-u", remote, branchName)
+// ForcePush pushes a branch whose commits have been rewritten, e.g. by amending them. It is refused if the remote
+// branch has commits that have not been fetched, so that nobody else's work is overwritten.
+func (r *RealGit) ForcePush(ctx context.Context, output io.Writer, workingDir string, remote string, branchName string) error {
+	var pushOutput bytes.Buffer
+	err := execInstance.Execute(ctx, io.MultiWriter(output, &pushOutput), workingDir, "git", "push", "--force-with-lease", "-u", remote, branchName)
+	if err != nil {
+		return asPushRejected(pushOutput.String(), err)
+	}
+	return nil
 }
 
-func (r *RealGit) Commit(output io.Writer, workingDir string, message string) error {
-	return execInstance.Execute(output, workingDir, "git", "commit", "--all", "--message", message)
+// Commit commits all changes with the message. An amended commit keeps its message if none is given.
+func (r *RealGit) Commit(ctx context.Context, output io.Writer, workingDir string, message string, options CommitOptions) error {
+	args := []string{"commit", "--all"}
+	if message == "" && options.Amend {
+		args = append(args, "--no-edit")
+	} else {
+		args = append(args, "--message", message)
+	}
+	args = append(args, options.args()...)
+	return execInstance.ExecuteWithEnv(ctx, output, workingDir, options.env(), "git", args...)
 }
 
-func (r *RealGit) IsRepoChanged(output io.Writer, workingDir string) (bool, error) {
+func (r *RealGit) IsRepoChanged(ctx context.Context, output io.Writer, workingDir string) (bool, error) {
 	var localExecutor executor.Executor = executor.NewRealExecutor()
 	localExecutor.SetVerbose(false)
-	shellCommand := os.Getenv("SHELL")
-	if shellCommand == "" {
-		shellCommand = "sh"
+	// the porcelain output is checked here rather than through a shell pipeline, so that it works without a POSIX shell
+	commandOutput, err := localExecutor.ExecuteAndCapture(ctx, output, workingDir, "git", "status", "--porcelain=v1")
+	if err != nil {
+		return false, err
+	}
+
+	return strings.TrimSpace(commandOutput) != "", nil
+}
+
+func (r *RealGit) Pull(ctx context.Context, output io.Writer, workingDir string, remote string, branchName string) error {
+	return execInstance.Execute(ctx, output, workingDir, "git", "pull", "--ff-only", remote, branchName)
+}
+
+// IsAheadOfDefaultBranch reports whether the current branch has any commits that are not on origin's default branch,
+// i.e. whether anything has been committed as part of the campaign. Repos whose PRs are raised against another branch
+// are compared with that branch instead.
+func (r *RealGit) IsAheadOfDefaultBranch(ctx context.Context, output io.Writer, workingDir string, baseBranch string) (bool, error) {
+	commandOutput, err := execInstance.ExecuteAndCapture(ctx, output, workingDir, "git", "rev-list", "--count", baseRef(baseBranch)+"..HEAD")
+	if err != nil {
+		return false, err
+	}
+
+	commitCount, err := strconv.Atoi(strings.TrimSpace(commandOutput))
+	if err != nil {
+		return false, err
+	}
+
+	return commitCount > 0, nil
+}
+
+// baseRef is the branch on origin that the campaign's changes are compared with: baseBranch, or origin's default
+// branch if it is empty
+func baseRef(baseBranch string) string {
+	if baseBranch == "" {
+		return "origin/HEAD"
+	}
+	return "origin/" + baseBranch
+}
+
+// CampaignCommits lists the subjects of the commits on the current branch that are not on the base branch, oldest
+// first
+func (r *RealGit) CampaignCommits(ctx context.Context, output io.Writer, workingDir string, baseBranch string) ([]string, error) {
+	commandOutput, err := execInstance.ExecuteAndCapture(ctx, output, workingDir, "git", "log", "--reverse", "--format=%s", baseRef(baseBranch)+"..HEAD")
+	if err != nil {
+		return nil, err
+	}
+
+	subjects := []string{}
+	for _, line := range strings.Split(commandOutput, "\n") {
+		if line != "" {
+			subjects = append(subjects, line)
+		}
+	}
+	return subjects, nil
+}
+
+// ChangedFiles lists the files changed on the current branch since it diverged from the base branch
+func (r *RealGit) ChangedFiles(ctx context.Context, output io.Writer, workingDir string, baseBranch string) ([]string, error) {
+	commandOutput, err := execInstance.ExecuteAndCapture(ctx, output, workingDir, "git", "diff", "--name-only", baseRef(baseBranch)+"...HEAD")
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{}
+	for _, line := range strings.Split(commandOutput, "\n") {
+		if line != "" {
+			files = append(files, line)
+		}
 	}
-	shellArgs := []string{"-c", "git status --porcelain=v1 | wc -l | tr -d '[:space:]'"}
-	commandOutput, err := localExecutor.ExecuteAndCapture(output, workingDir, shellCommand, shellArgs...)
+	return files, nil
+}
+
+// Diff returns the changes made on the current branch since it diverged from the base branch
+func (r *RealGit) Diff(ctx context.Context, output io.Writer, workingDir string, baseBranch string) (string, error) {
+	return execInstance.ExecuteAndCapture(ctx, output, workingDir, "git", "diff", baseRef(baseBranch)+"...HEAD")
+}
+
+// UncommittedDiff returns the changes to tracked files that have not been committed yet, which are those that
+// Commit would commit
+func (r *RealGit) UncommittedDiff(ctx context.Context, output io.Writer, workingDir string) (string, error) {
+	return execInstance.ExecuteAndCapture(ctx, output, workingDir, "git", "diff", "HEAD")
+}
+
+// DiffStat is the size of the changes made on a branch
+type DiffStat struct {
+	FilesChanged int
+	Insertions   int
+	Deletions    int
+}
+
+// Lines is the number of lines inserted or deleted
+func (d DiffStat) Lines() int {
+	return d.Insertions + d.Deletions
+}
+
+// DiffStat measures the changes made on the current branch since it diverged from the base branch.
+// Binary files count as changed files, without any lines.
+func (r *RealGit) DiffStat(ctx context.Context, output io.Writer, workingDir string, baseBranch string) (DiffStat, error) {
+	commandOutput, err := execInstance.ExecuteAndCapture(ctx, output, workingDir, "git", "diff", "--numstat", baseRef(baseBranch)+"...HEAD")
+	if err != nil {
+		return DiffStat{}, err
+	}
+
+	var stat DiffStat
+	for _, line := range strings.Split(commandOutput, "\n") {
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		stat.FilesChanged++
+		if insertions, err := strconv.Atoi(fields[0]); err == nil {
+			stat.Insertions += insertions
+		}
+		if deletions, err := strconv.Atoi(fields[1]); err == nil {
+			stat.Deletions += deletions
+		}
+	}
+	return stat, nil
+}
+
+// HasRemote reports whether the working copy has a remote with the given name
+func (r *RealGit) HasRemote(ctx context.Context, output io.Writer, workingDir string, remote string) (bool, error) {
+	commandOutput, err := execInstance.ExecuteAndCapture(ctx, output, workingDir, "git", "remote")
 	if err != nil {
 		return false, err
 	}
 
-	diffSize, err := strconv.Atoi(commandOutput)
+	for _, line := range strings.Split(commandOutput, "\n") {
+		if strings.TrimSpace(line) == remote {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// HasRemoteBranch reports whether the branch exists on the remote, asking the remote rather than relying on what
+// was last fetched
+func (r *RealGit) HasRemoteBranch(ctx context.Context, output io.Writer, workingDir string, remote string, branchName string) (bool, error) {
+	commandOutput, err := execInstance.ExecuteAndCapture(ctx, output, workingDir, "git", "ls-remote", "--heads", remote, "refs/heads/"+branchName)
 	if err != nil {
 		return false, err
 	}
+	return strings.TrimSpace(commandOutput) != "", nil
+}
 
-	return diffSize > 0, nil
+// DeleteRemoteBranch deletes the branch from the remote, leaving the local branch as it is
+func (r *RealGit) DeleteRemoteBranch(ctx context.Context, output io.Writer, workingDir string, remote string, branchName string) error {
+	return execInstance.Execute(ctx, output, workingDir, "git", "push", "--delete", remote, branchName)
 }
 
-func (r *RealGit) Pull(output io.Writer, workingDir string, remote string, branchName string) error {
-	return execInstance.Execute(output, workingDir, "git", "pull", "--ff-only", remote, branchName)
+// CanPush checks that the branch could be pushed to the remote, by pushing with --dry-run. This checks the
+// credentials and permissions for the remote, but not server-side hooks, which only run for real pushes.
+func (r *RealGit) CanPush(ctx context.Context, output io.Writer, workingDir string, remote string, branchName string) error {
+	var pushOutput bytes.Buffer
+	err := execInstance.Execute(ctx, io.MultiWriter(output, &pushOutput), workingDir, "git", "push", "--dry-run", remote, branchName)
+	if err != nil {
+		return asPushRejected(pushOutput.String(), err)
+	}
+	return nil
 }
 
+// SyncFork fast-forwards a branch of the fork at origin to match the same branch at upstream.
+// It fails, rather than overwriting anything, if the fork's branch has commits that upstream does not.
+func (r *RealGit) SyncFork(ctx context.Context, output io.Writer, workingDir string, branchName string) error {
+	upstreamRef := "refs/remotes/upstream/" + branchName
+	if err := execInstance.Execute(ctx, output, workingDir, "git", "fetch", "upstream", "+refs/heads/"+branchName+":"+upstreamRef); err != nil {
+		return err
+	}
+	return execInstance.Execute(ctx, output, workingDir, "git", "push", "origin", upstreamRef+":refs/heads/"+branchName)
+}
+
+// DiscardChanges throws away uncommitted changes to tracked files, and removes untracked files that are not ignored
+func (r *RealGit) DiscardChanges(ctx context.Context, output io.Writer, workingDir string) error {
+	if err := execInstance.Execute(ctx, output, workingDir, "git", "reset", "--hard"); err != nil {
+		return err
+	}
+	return execInstance.Execute(ctx, output, workingDir, "git", "clean", "--force", "-d")
+}
+
+// ResetBranch fetches the latest default branch from a remote and checks out the branch afresh from it,
+// replacing any commits previously made on the branch
+func (r *RealGit) ResetBranch(ctx context.Context, output io.Writer, workingDir string, remote string, defaultBranch string, branchName string) error {
+	remoteRef := "refs/remotes/" + remote + "/" + defaultBranch
+	if err := execInstance.Execute(ctx, output, workingDir, "git", "fetch", remote, "+refs/heads/"+defaultBranch+":"+remoteRef); err != nil {
+		return err
+	}
+	return execInstance.Execute(ctx, output, workingDir, "git", "checkout", "--no-track", "-B", branchName, remoteRef)
+}
+
+// IsOnBranch reports whether the given branch is checked out in the working copy
+func (r *RealGit) IsOnBranch(ctx context.Context, output io.Writer, workingDir string, branchName string) (bool, error) {
+	currentBranch, err := execInstance.ExecuteAndCapture(ctx, output, workingDir, "git", "branch", "--show-current")
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(currentBranch) == branchName, nil
+}
+
+// SwitchBranch checks out a branch that already exists in the working copy
+func (r *RealGit) SwitchBranch(ctx context.Context, output io.Writer, workingDir string, branchName string) error {
+	return execInstance.Execute(ctx, output, workingDir, "git", "checkout", branchName)
+}
+
+// RemoteUrl returns the URL that the working copy fetches from for the given remote
+func (r *RealGit) RemoteUrl(ctx context.Context, output io.Writer, workingDir string, remote string) (string, error) {
+	url, err := execInstance.ExecuteAndCapture(ctx, output, workingDir, "git", "remote", "get-url", remote)
+	return strings.TrimSpace(url), err
+}
+
+// SetRemoteUrl changes the URL that the working copy fetches from and pushes to for the given remote
+func (r *RealGit) SetRemoteUrl(ctx context.Context, output io.Writer, workingDir string, remote string, url string) error {
+	return execInstance.Execute(ctx, output, workingDir, "git", "remote", "set-url", remote, url)
+}
+
+// Verify checks that every object reachable in the working copy's repository is present. It is much quicker than
+// a full fsck, as it does not check the objects' contents, but still finds the damage that stops git working.
+func (r *RealGit) Verify(ctx context.Context, output io.Writer, workingDir string) error {
+	return execInstance.Execute(ctx, output, workingDir, "git", "fsck", "--connectivity-only", "--no-dangling", "--no-progress")
+}
+
+// SparseCheckout limits the working copy to the given directories, and the files at the top of the repo, so that only
+// part of a large repo is checked out. The working copy should have been cloned with SparseCloneArgs.
+func (r *RealGit) SparseCheckout(ctx context.Context, output io.Writer, workingDir string, paths ...string) error {
+	args := append([]string{"sparse-checkout", "set", "--cone"}, paths...)
+	return execInstance.Execute(ctx, output, workingDir, "git", args...)
+}
+
+// SparseCloneArgs are the flags for git clone that start a working copy with nothing but the files at the top of the
+// repo checked out, and fetch the contents of other files only when they are checked out
+var SparseCloneArgs = []string{"--filter=blob:none", "--sparse"}
+
 func NewRealGit() *RealGit {
 	return &RealGit{}
 }
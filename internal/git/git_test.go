@@ -16,6 +16,7 @@
 package git
 
 import (
+	"context"
 	"github.com/skyscanner/turbolift/internal/executor"
 	"github.com/stretchr/testify/assert"
 	"strings"
@@ -70,9 +71,291 @@ func TestItReturnsNilErrorOnSuccessfulPull(t *testing.T) {
 	})
 }
 
+func TestItReportsBranchAheadOfDefaultBranch(t *testing.T) {
+	fakeExecutor := executor.NewFakeExecutor(nil, func(workingDir string, name string, args ...string) (string, error) {
+		return "2\n", nil
+	})
+	execInstance = fakeExecutor
+
+	isAhead, err := NewRealGit().IsAheadOfDefaultBranch(context.Background(), &strings.Builder{}, "work/org/repo1", "")
+	assert.NoError(t, err)
+	assert.True(t, isAhead)
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", "git", "rev-list", "--count", "origin/HEAD..HEAD"},
+	})
+}
+
+func TestItComparesWithTheBaseBranchWhenGivenOne(t *testing.T) {
+	fakeExecutor := executor.NewFakeExecutor(nil, func(workingDir string, name string, args ...string) (string, error) {
+		return "1\n", nil
+	})
+	execInstance = fakeExecutor
+
+	isAhead, err := NewRealGit().IsAheadOfDefaultBranch(context.Background(), &strings.Builder{}, "work/org/repo1", "develop")
+	assert.NoError(t, err)
+	assert.True(t, isAhead)
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", "git", "rev-list", "--count", "origin/develop..HEAD"},
+	})
+}
+
+func TestItReportsBranchNotAheadOfDefaultBranch(t *testing.T) {
+	fakeExecutor := executor.NewFakeExecutor(nil, func(workingDir string, name string, args ...string) (string, error) {
+		return "0\n", nil
+	})
+	execInstance = fakeExecutor
+
+	isAhead, err := NewRealGit().IsAheadOfDefaultBranch(context.Background(), &strings.Builder{}, "work/org/repo1", "")
+	assert.NoError(t, err)
+	assert.False(t, isAhead)
+}
+
+func TestItListsTheCampaignCommitsOldestFirst(t *testing.T) {
+	fakeExecutor := executor.NewFakeExecutor(nil, func(workingDir string, name string, args ...string) (string, error) {
+		return "Bump the Go version\nTidy go.sum\n", nil
+	})
+	execInstance = fakeExecutor
+
+	commits, err := NewRealGit().CampaignCommits(context.Background(), &strings.Builder{}, "work/org/repo1", "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Bump the Go version", "Tidy go.sum"}, commits)
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", "git", "log", "--reverse", "--format=%s", "origin/HEAD..HEAD"},
+	})
+}
+
+func TestItListsChangedFiles(t *testing.T) {
+	fakeExecutor := executor.NewFakeExecutor(nil, func(workingDir string, name string, args ...string) (string, error) {
+		return "Dockerfile\n.github/workflows/ci.yml\n", nil
+	})
+	execInstance = fakeExecutor
+
+	files, err := NewRealGit().ChangedFiles(context.Background(), &strings.Builder{}, "work/org/repo1", "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Dockerfile", ".github/workflows/ci.yml"}, files)
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", "git", "diff", "--name-only", "origin/HEAD...HEAD"},
+	})
+}
+
+func TestItListsTheFilesChangedSinceTheBaseBranchWhenGivenOne(t *testing.T) {
+	fakeExecutor := executor.NewFakeExecutor(nil, func(workingDir string, name string, args ...string) (string, error) {
+		return "Dockerfile\n", nil
+	})
+	execInstance = fakeExecutor
+
+	files, err := NewRealGit().ChangedFiles(context.Background(), &strings.Builder{}, "work/org/repo1", "develop")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Dockerfile"}, files)
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", "git", "diff", "--name-only", "origin/develop...HEAD"},
+	})
+}
+
+func TestItMeasuresAndDiffsAgainstTheBaseBranchWhenGivenOne(t *testing.T) {
+	fakeExecutor := executor.NewAlwaysSucceedsFakeExecutor()
+	execInstance = fakeExecutor
+
+	_, err := NewRealGit().CampaignCommits(context.Background(), &strings.Builder{}, "work/org/repo1", "develop")
+	assert.NoError(t, err)
+	_, err = NewRealGit().Diff(context.Background(), &strings.Builder{}, "work/org/repo1", "develop")
+	assert.NoError(t, err)
+	_, err = NewRealGit().DiffStat(context.Background(), &strings.Builder{}, "work/org/repo1", "develop")
+	assert.NoError(t, err)
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", "git", "log", "--reverse", "--format=%s", "origin/develop..HEAD"},
+		{"work/org/repo1", "git", "diff", "origin/develop...HEAD"},
+		{"work/org/repo1", "git", "diff", "--numstat", "origin/develop...HEAD"},
+	})
+}
+
+func TestItCommitsWithDefaultOptions(t *testing.T) {
+	fakeExecutor := executor.NewAlwaysSucceedsFakeExecutor()
+	execInstance = fakeExecutor
+
+	err := NewRealGit().Commit(context.Background(), &strings.Builder{}, "work/org/repo1", "some message", CommitOptions{})
+	assert.NoError(t, err)
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", "git", "commit", "--all", "--message", "some message"},
+	})
+	fakeExecutor.AssertEnvCalledWith(t, [][]string{{}})
+}
+
+func TestItCommitsWithSigningAndIdentityOverrides(t *testing.T) {
+	fakeExecutor := executor.NewAlwaysSucceedsFakeExecutor()
+	execInstance = fakeExecutor
+
+	err := NewRealGit().Commit(context.Background(), &strings.Builder{}, "work/org/repo1", "some message", CommitOptions{
+		GpgSign:        true,
+		Signoff:        true,
+		AuthorName:     "Campaign Bot",
+		AuthorEmail:    "bot@example.com",
+		CommitterEmail: "committer@example.com",
+	})
+	assert.NoError(t, err)
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", "git", "commit", "--all", "--message", "some message", "--gpg-sign", "--signoff"},
+	})
+	fakeExecutor.AssertEnvCalledWith(t, [][]string{
+		{"GIT_AUTHOR_EMAIL=bot@example.com", "GIT_AUTHOR_NAME=Campaign Bot", "GIT_COMMITTER_EMAIL=committer@example.com"},
+	})
+}
+
+func TestItAmendsTheLastCommitKeepingItsMessageIfNoneIsGiven(t *testing.T) {
+	fakeExecutor := executor.NewAlwaysSucceedsFakeExecutor()
+	execInstance = fakeExecutor
+
+	err := NewRealGit().Commit(context.Background(), &strings.Builder{}, "work/org/repo1", "", CommitOptions{Amend: true})
+	assert.NoError(t, err)
+	err = NewRealGit().Commit(context.Background(), &strings.Builder{}, "work/org/repo1", "new message", CommitOptions{Amend: true})
+	assert.NoError(t, err)
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", "git", "commit", "--all", "--no-edit", "--amend"},
+		{"work/org/repo1", "git", "commit", "--all", "--message", "new message", "--amend"},
+	})
+}
+
+func TestItForcePushesWithALease(t *testing.T) {
+	fakeExecutor := executor.NewAlwaysSucceedsFakeExecutor()
+	execInstance = fakeExecutor
+
+	err := NewRealGit().ForcePush(context.Background(), &strings.Builder{}, "work/org/repo1", "origin", "my-campaign")
+	assert.NoError(t, err)
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", "git", "push", "--force-with-lease", "-u", "origin", "my-campaign"},
+	})
+}
+
+func TestItDiffsAgainstTheDefaultBranch(t *testing.T) {
+	fakeExecutor := executor.NewAlwaysSucceedsFakeExecutor()
+	execInstance = fakeExecutor
+
+	_, err := NewRealGit().Diff(context.Background(), &strings.Builder{}, "work/org/repo1", "")
+	assert.NoError(t, err)
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", "git", "diff", "origin/HEAD...HEAD"},
+	})
+}
+
+func TestItDiffsUncommittedChanges(t *testing.T) {
+	fakeExecutor := executor.NewAlwaysSucceedsFakeExecutor()
+	execInstance = fakeExecutor
+
+	_, err := NewRealGit().UncommittedDiff(context.Background(), &strings.Builder{}, "work/org/repo1")
+	assert.NoError(t, err)
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", "git", "diff", "HEAD"},
+	})
+}
+
+func TestItDeletesARemoteBranch(t *testing.T) {
+	fakeExecutor := executor.NewAlwaysSucceedsFakeExecutor()
+	execInstance = fakeExecutor
+
+	err := NewRealGit().DeleteRemoteBranch(context.Background(), &strings.Builder{}, "work/org/repo1", "origin", "my-campaign")
+	assert.NoError(t, err)
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", "git", "push", "--delete", "origin", "my-campaign"},
+	})
+}
+
+func TestItChecksForARemote(t *testing.T) {
+	fakeExecutor := executor.NewFakeExecutor(nil, func(workingDir string, name string, args ...string) (string, error) {
+		return "origin\nupstream\n", nil
+	})
+	execInstance = fakeExecutor
+
+	hasUpstream, err := NewRealGit().HasRemote(context.Background(), &strings.Builder{}, "work/org/repo1", "upstream")
+	assert.NoError(t, err)
+	assert.True(t, hasUpstream)
+
+	hasOther, err := NewRealGit().HasRemote(context.Background(), &strings.Builder{}, "work/org/repo1", "other")
+	assert.NoError(t, err)
+	assert.False(t, hasOther)
+}
+
+func TestItChecksForABranchOnTheRemote(t *testing.T) {
+	fakeExecutor := executor.NewFakeExecutor(nil, func(workingDir string, name string, args ...string) (string, error) {
+		return "1a2b3c4\trefs/heads/release\n", nil
+	})
+	execInstance = fakeExecutor
+
+	exists, err := NewRealGit().HasRemoteBranch(context.Background(), &strings.Builder{}, "work/org/repo1", "upstream", "release")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", "git", "ls-remote", "--heads", "upstream", "refs/heads/release"},
+	})
+}
+
+func TestItChecksThatABranchCanBePushedWithADryRun(t *testing.T) {
+	fakeExecutor := executor.NewAlwaysSucceedsFakeExecutor()
+	execInstance = fakeExecutor
+
+	err := NewRealGit().CanPush(context.Background(), &strings.Builder{}, "work/org/repo1", "origin", "my-campaign")
+	assert.NoError(t, err)
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", "git", "push", "--dry-run", "origin", "my-campaign"},
+	})
+}
+
+func TestItSyncsAForkFromUpstream(t *testing.T) {
+	fakeExecutor := executor.NewAlwaysSucceedsFakeExecutor()
+	execInstance = fakeExecutor
+
+	err := NewRealGit().SyncFork(context.Background(), &strings.Builder{}, "work/org/repo1", "main")
+	assert.NoError(t, err)
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", "git", "fetch", "upstream", "+refs/heads/main:refs/remotes/upstream/main"},
+		{"work/org/repo1", "git", "push", "origin", "refs/remotes/upstream/main:refs/heads/main"},
+	})
+}
+
+func TestItDiscardsChanges(t *testing.T) {
+	fakeExecutor := executor.NewAlwaysSucceedsFakeExecutor()
+	execInstance = fakeExecutor
+
+	err := NewRealGit().DiscardChanges(context.Background(), &strings.Builder{}, "work/org/repo1")
+	assert.NoError(t, err)
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", "git", "reset", "--hard"},
+		{"work/org/repo1", "git", "clean", "--force", "-d"},
+	})
+}
+
+func TestItResetsABranchToTheLatestDefaultBranch(t *testing.T) {
+	fakeExecutor := executor.NewAlwaysSucceedsFakeExecutor()
+	execInstance = fakeExecutor
+
+	err := NewRealGit().ResetBranch(context.Background(), &strings.Builder{}, "work/org/repo1", "upstream", "main", "my-campaign")
+	assert.NoError(t, err)
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", "git", "fetch", "upstream", "+refs/heads/main:refs/remotes/upstream/main"},
+		{"work/org/repo1", "git", "checkout", "--no-track", "-B", "my-campaign", "refs/remotes/upstream/main"},
+	})
+}
+
 func runCheckoutAndCaptureOutput() (string, error) {
 	sb := strings.Builder{}
-	err := NewRealGit().Checkout(&sb, "work/org/repo1", "some_branch")
+	err := NewRealGit().Checkout(context.Background(), &sb, "work/org/repo1", "some_branch")
 
 	if err != nil {
 		return sb.String(), err
@@ -82,7 +365,52 @@ func runCheckoutAndCaptureOutput() (string, error) {
 
 func runPullAndCaptureOutput() (string, error) {
 	sb := strings.Builder{}
-	err := NewRealGit().Pull(&sb, "work/org1/repo1", "upstream", "main")
+	err := NewRealGit().Pull(context.Background(), &sb, "work/org1/repo1", "upstream", "main")
 
 	return sb.String(), err
 }
+
+func TestItChecksTheCurrentBranch(t *testing.T) {
+	fakeExecutor := executor.NewFakeExecutor(func(s string, s2 string, s3 ...string) error {
+		return nil
+	}, func(s string, s2 string, s3 ...string) (string, error) {
+		return "my-campaign\n", nil
+	})
+	execInstance = fakeExecutor
+
+	onBranch, err := NewRealGit().IsOnBranch(context.Background(), &strings.Builder{}, "work/org/repo1", "my-campaign")
+	assert.NoError(t, err)
+	assert.True(t, onBranch)
+
+	onBranch, err = NewRealGit().IsOnBranch(context.Background(), &strings.Builder{}, "work/org/repo1", "main")
+	assert.NoError(t, err)
+	assert.False(t, onBranch)
+}
+
+func TestItVerifiesTheRepository(t *testing.T) {
+	fakeExecutor := executor.NewAlwaysSucceedsFakeExecutor()
+	execInstance = fakeExecutor
+
+	err := NewRealGit().Verify(context.Background(), &strings.Builder{}, "work/org/repo1")
+	assert.NoError(t, err)
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", "git", "fsck", "--connectivity-only", "--no-dangling", "--no-progress"},
+	})
+}
+
+func TestItMeasuresTheDiff(t *testing.T) {
+	fakeExecutor := executor.NewFakeExecutor(nil, func(workingDir string, name string, args ...string) (string, error) {
+		return "10\t2\tgo.mod\n-\t-\tlogo.png\n3\t0\tREADME.md\n", nil
+	})
+	execInstance = fakeExecutor
+
+	stat, err := NewRealGit().DiffStat(context.Background(), &strings.Builder{}, "work/org/repo1", "")
+	assert.NoError(t, err)
+	assert.Equal(t, DiffStat{FilesChanged: 3, Insertions: 13, Deletions: 2}, stat)
+	assert.Equal(t, 15, stat.Lines())
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", "git", "diff", "--numstat", "origin/HEAD...HEAD"},
+	})
+}
@@ -0,0 +1,147 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package git
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// PushRejectedError is returned when the remote refuses a push, typically because a server-side hook such as a
+// pre-receive hook declined it
+type PushRejectedError struct {
+	// Messages are what the remote said about why it rejected the push, without the "remote:" prefixes
+	Messages []string
+	Err      error
+}
+
+func (e *PushRejectedError) Error() string {
+	if len(e.Messages) == 0 {
+		return fmt.Sprintf("push rejected by the remote: %s", e.Err)
+	}
+	return fmt.Sprintf("push rejected by the remote: %s", strings.Join(e.Messages, " / "))
+}
+
+func (e *PushRejectedError) Unwrap() error {
+	return e.Err
+}
+
+// Rejection classifies why a push was rejected, along with how to remedy it
+type Rejection struct {
+	Reason string
+	Hint   string
+	// Retryable is true if the rejection looks temporary, so pushing again may succeed
+	Retryable bool
+}
+
+// rejections are the common reasons that hooks give for rejecting pushes, checked in order against the remote's
+// messages. Protected branches come first, as their messages often mention who is not authorized to push.
+var rejections = []struct {
+	phrases   *regexp.Regexp
+	rejection Rejection
+}{
+	{
+		phrases:   wholePhrases("protected branch", "branch name", "not authorized to push", "not allowed to push"),
+		rejection: Rejection{Reason: "branch not allowed", Hint: "choose a branch name the host accepts, with branch in turbolift.yaml or --branch"},
+	},
+	{
+		phrases:   wholePhrases("non-fast-forward", "fetch first"),
+		rejection: Rejection{Reason: "branch has diverged", Hint: "if the commits were amended, push them with create-prs --force-with-lease; otherwise pull the changes made to the branch first"},
+	},
+	{
+		phrases:   wholePhrases("secret", "secrets", "push protection", "credential", "credentials"),
+		rejection: Rejection{Reason: "secret detected", Hint: "remove the secret from the commits, e.g. with git commit --amend, and rotate it if it is real"},
+	},
+	{
+		phrases:   wholePhrases("signed", "unsigned", "signature", "signatures", "gpg", "unverified"),
+		rejection: Rejection{Reason: "signature required", Hint: "sign commits with commit.gpg-sign in turbolift.yaml, then redo the commits"},
+	},
+	{
+		phrases:   wholePhrases("commit message", "message format", "conventional commit", "issue key", "jira"),
+		rejection: Rejection{Reason: "commit message format", Hint: "redo the commits with a message in the format the hook asks for"},
+	},
+	{
+		phrases:   wholePhrases("author email", "committer email", "email address", "author", "committer"),
+		rejection: Rejection{Reason: "author not allowed", Hint: "set commit.author-email in turbolift.yaml to an identity the host accepts, then redo the commits"},
+	},
+	{
+		phrases:   wholePhrases("large file", "file size", "exceeds", "gh001"),
+		rejection: Rejection{Reason: "file too large", Hint: "remove the large files from the commits, or track them with Git LFS"},
+	},
+	{
+		phrases:   wholePhrases("timed out", "timeout", "try again", "temporarily", "unavailable"),
+		rejection: Rejection{Reason: "hook failed", Hint: "the hook did not finish, so try pushing again later", Retryable: true},
+	},
+}
+
+// wholePhrases matches any of the phrases as whole words, ignoring case, so that "author" does not match "authorized"
+func wholePhrases(phrases ...string) *regexp.Regexp {
+	quoted := make([]string, len(phrases))
+	for i, phrase := range phrases {
+		quoted[i] = regexp.QuoteMeta(phrase)
+	}
+	return regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)
+}
+
+// otherRejection is the classification of rejections whose reason is not recognised
+var otherRejection = Rejection{Reason: "other", Hint: "read the messages from the remote, and check with the owners of its hooks"}
+
+// Classify works out why the push was rejected from the remote's messages
+func (e *PushRejectedError) Classify() Rejection {
+	messages := strings.Join(e.Messages, "\n")
+	for _, r := range rejections {
+		if r.phrases.MatchString(messages) {
+			return r.rejection
+		}
+	}
+	return otherRejection
+}
+
+// AsPushRejected returns the error as a PushRejectedError, if it is one
+func AsPushRejected(err error) (*PushRejectedError, bool) {
+	var rejectedErr *PushRejectedError
+	if errors.As(err, &rejectedErr) {
+		return rejectedErr, true
+	}
+	return nil, false
+}
+
+// asPushRejected recognises a failed push that the remote rejected from git's output, and collects the reasons given.
+// Pushes that would lose commits on the remote branch are rejected by git itself, which gives its reason in brackets.
+func asPushRejected(output string, err error) error {
+	if !strings.Contains(output, "[remote rejected]") && !strings.Contains(output, "hook declined") && !strings.Contains(output, "[rejected]") {
+		return err
+	}
+
+	var messages []string
+	for _, line := range strings.Split(output, "\n") {
+		if strings.Contains(line, "[rejected]") {
+			if start, end := strings.LastIndex(line, "("), strings.LastIndex(line, ")"); start >= 0 && end > start {
+				messages = append(messages, line[start+1:end])
+			}
+			continue
+		}
+		if !strings.HasPrefix(line, "remote:") {
+			continue
+		}
+		if message := strings.TrimSpace(strings.TrimPrefix(line, "remote:")); message != "" {
+			messages = append(messages, message)
+		}
+	}
+	return &PushRejectedError{Messages: messages, Err: err}
+}
@@ -0,0 +1,76 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package git
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const rejectedPushOutput = `Enumerating objects: 5, done.
+remote: 
+remote: ERROR: commit 1a2b3c4: Commit message must reference a JIRA issue key
+remote: 
+To github.com:org/repo1.git
+ ! [remote rejected] my-campaign -> my-campaign (pre-receive hook declined)
+error: failed to push some refs to 'github.com:org/repo1.git'
+`
+
+func TestItRecognisesPushesRejectedByHooks(t *testing.T) {
+	exitErr := errors.New("exit status 1")
+
+	err := asPushRejected(rejectedPushOutput, exitErr)
+	rejectedErr, ok := AsPushRejected(err)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"ERROR: commit 1a2b3c4: Commit message must reference a JIRA issue key"}, rejectedErr.Messages)
+	assert.ErrorIs(t, err, exitErr)
+	assert.Equal(t, "push rejected by the remote: ERROR: commit 1a2b3c4: Commit message must reference a JIRA issue key", err.Error())
+
+	err = asPushRejected("fatal: unable to access 'https://github.com/org/repo1/': Could not resolve host", exitErr)
+	_, ok = AsPushRejected(err)
+	assert.False(t, ok)
+}
+
+func TestItRecognisesPushesThatWouldLoseCommits(t *testing.T) {
+	output := `To github.com:org/repo1.git
+ ! [rejected]        my-campaign -> my-campaign (non-fast-forward)
+error: failed to push some refs to 'github.com:org/repo1.git'
+`
+
+	rejectedErr, ok := AsPushRejected(asPushRejected(output, errors.New("exit status 1")))
+	assert.True(t, ok)
+	assert.Equal(t, []string{"non-fast-forward"}, rejectedErr.Messages)
+	assert.Equal(t, "branch has diverged", rejectedErr.Classify().Reason)
+}
+
+func TestItClassifiesRejectedPushes(t *testing.T) {
+	classify := func(message string) Rejection {
+		return (&PushRejectedError{Messages: []string{message}}).Classify()
+	}
+
+	assert.Equal(t, "commit message format", classify("Commit message must reference a JIRA issue key").Reason)
+	assert.Equal(t, "signature required", classify("Commits must have verified signatures.").Reason)
+	assert.Equal(t, "secret detected", classify("GH013: Push cannot contain secrets").Reason)
+	assert.Equal(t, "file too large", classify("File big.bin is 120 MB; this exceeds GitHub's file size limit").Reason)
+	assert.True(t, classify("pre-receive hook timed out after 60s").Retryable)
+	assert.Equal(t, "branch not allowed", classify("You're not authorized to push to this branch").Reason)
+	assert.Equal(t, "branch not allowed", classify("GH006: Protected branch update failed for refs/heads/main.").Reason)
+	assert.Equal(t, "author not allowed", classify("Author email jo@example.com is not allowed").Reason)
+	assert.Equal(t, otherRejection, classify("Pushes are frozen for the release; contact the repository authors"))
+	assert.Equal(t, otherRejection, classify("Pushes are frozen for the release"))
+}
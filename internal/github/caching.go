@@ -0,0 +1,103 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package github
+
+import (
+	"context"
+	"io"
+
+	"github.com/skyscanner/turbolift/internal/cache"
+)
+
+// CachingGitHub looks up PRs and repo metadata through a cache, for commands that only report on the campaign and may
+// be run many times over. Failed lookups are not cached, and everything else is passed straight through.
+type CachingGitHub struct {
+	GitHub
+	cache *cache.Cache
+}
+
+// WithCache returns the forge looking things up through the cache, or the forge itself if there is no cache
+func WithCache(forge GitHub, c *cache.Cache) GitHub {
+	if c == nil {
+		return forge
+	}
+	return &CachingGitHub{GitHub: forge, cache: c}
+}
+
+func (c *CachingGitHub) GetPR(ctx context.Context, output io.Writer, workingDir string, branchName string) (*PrStatus, error) {
+	key := "pr:" + workingDir + "@" + branchName
+	var pr PrStatus
+	if c.cache.Get(key, &pr) {
+		return &pr, nil
+	}
+	found, err := c.GitHub.GetPR(ctx, output, workingDir, branchName)
+	if err == nil {
+		c.cache.Put(key, found)
+	}
+	return found, err
+}
+
+func (c *CachingGitHub) GetPRForBranch(ctx context.Context, output io.Writer, workingDir string, branchName string) (*PrStatus, error) {
+	key := "pr-for-branch:" + workingDir + "@" + branchName
+	var pr PrStatus
+	if c.cache.Get(key, &pr) {
+		return &pr, nil
+	}
+	found, err := c.GitHub.GetPRForBranch(ctx, output, workingDir, branchName)
+	if err == nil {
+		c.cache.Put(key, found)
+	}
+	return found, err
+}
+
+func (c *CachingGitHub) IsArchived(ctx context.Context, output io.Writer, workingDir string) (bool, error) {
+	key := "archived:" + workingDir
+	var archived bool
+	if c.cache.Get(key, &archived) {
+		return archived, nil
+	}
+	archived, err := c.GitHub.IsArchived(ctx, output, workingDir)
+	if err == nil {
+		c.cache.Put(key, archived)
+	}
+	return archived, err
+}
+
+func (c *CachingGitHub) GetDefaultBranchName(ctx context.Context, output io.Writer, workingDir string, fullRepoName string) (string, error) {
+	key := "default-branch:" + fullRepoName
+	var branch string
+	if c.cache.Get(key, &branch) {
+		return branch, nil
+	}
+	branch, err := c.GitHub.GetDefaultBranchName(ctx, output, workingDir, fullRepoName)
+	if err == nil {
+		c.cache.Put(key, branch)
+	}
+	return branch, err
+}
+
+func (c *CachingGitHub) BranchProtection(ctx context.Context, output io.Writer, workingDir string, baseBranch string) (*BranchProtection, error) {
+	key := "branch-protection:" + workingDir + "@" + baseBranch
+	var protection BranchProtection
+	if c.cache.Get(key, &protection) {
+		return &protection, nil
+	}
+	found, err := c.GitHub.BranchProtection(ctx, output, workingDir, baseBranch)
+	if err == nil {
+		c.cache.Put(key, found)
+	}
+	return found, err
+}
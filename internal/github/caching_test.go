@@ -0,0 +1,59 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package github
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/cache"
+)
+
+func TestItLooksUpEachPrOnceThroughTheCache(t *testing.T) {
+	fake := NewFakeGitHub(nil, func(workingDir string) (interface{}, error) {
+		if workingDir == "work/org/repo2" {
+			return nil, errors.New("temporarily unavailable")
+		}
+		return &PrStatus{Number: 12, State: "OPEN", Url: "https://github.com/org/repo1/pull/12"}, nil
+	})
+	gh := WithCache(fake, cache.New("", time.Minute))
+
+	for i := 0; i < 2; i++ {
+		pr, err := gh.GetPR(context.Background(), &strings.Builder{}, "work/org/repo1", "my-campaign")
+		assert.NoError(t, err)
+		assert.Equal(t, 12, pr.Number)
+		assert.Equal(t, "OPEN", pr.State)
+
+		_, err = gh.GetPR(context.Background(), &strings.Builder{}, "work/org/repo2", "my-campaign")
+		assert.Error(t, err)
+	}
+
+	fake.AssertCalledWith(t, [][]string{
+		{"get_pr", "work/org/repo1"},
+		{"get_pr", "work/org/repo2"},
+		{"get_pr", "work/org/repo2"},
+	})
+}
+
+func TestItPassesLookupsStraightThroughWithoutACache(t *testing.T) {
+	fake := NewAlwaysSucceedsFakeGitHub()
+	assert.Same(t, fake, WithCache(fake, nil))
+}
@@ -0,0 +1,78 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package github
+
+import (
+	"regexp"
+	"strings"
+)
+
+const (
+	checklistStartMarker = "<!-- turbolift:checklist -->"
+	checklistEndMarker   = "<!-- turbolift:checklist-end -->"
+)
+
+var checklistItemRegexp = regexp.MustCompile(`(?m)^- \[([ xX])\] (.*)$`)
+
+// WithChecklist appends a reviewer checklist to a PR body, under the given heading. Items that were already ticked in the
+// checklist of previousBody stay ticked, so that amending a PR description does not lose reviewers' progress.
+func WithChecklist(body string, heading string, items []string, previousBody string) string {
+	if len(items) == 0 {
+		return body
+	}
+
+	ticked := map[string]bool{}
+	for _, match := range checklistItemRegexp.FindAllStringSubmatch(checklistSection(previousBody), -1) {
+		ticked[strings.TrimSpace(match[2])] = match[1] != " "
+	}
+
+	var sb strings.Builder
+	sb.WriteString(strings.TrimRight(body, "\n"))
+	sb.WriteString("\n\n" + checklistStartMarker + "\n" + heading + "\n\n")
+	for _, item := range items {
+		if ticked[item] {
+			sb.WriteString("- [x] " + item + "\n")
+		} else {
+			sb.WriteString("- [ ] " + item + "\n")
+		}
+	}
+	sb.WriteString(checklistEndMarker)
+	return sb.String()
+}
+
+// ChecklistProgress counts the ticked and total items in the reviewer checklist of a PR body.
+// Checkboxes outside the checklist added by WithChecklist are ignored.
+func ChecklistProgress(body string) (ticked int, total int) {
+	for _, match := range checklistItemRegexp.FindAllStringSubmatch(checklistSection(body), -1) {
+		total++
+		if match[1] != " " {
+			ticked++
+		}
+	}
+	return ticked, total
+}
+
+func checklistSection(body string) string {
+	start := strings.Index(body, checklistStartMarker)
+	if start == -1 {
+		return ""
+	}
+	section := body[start+len(checklistStartMarker):]
+	if end := strings.Index(section, checklistEndMarker); end != -1 {
+		section = section[:end]
+	}
+	return section
+}
@@ -0,0 +1,49 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package github
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestItAppendsAChecklist(t *testing.T) {
+	body := WithChecklist("some body\n", "### Reviewer checklist", []string{"Deployed to staging", "Dashboards checked"}, "")
+
+	assert.Equal(t, "some body\n\n<!-- turbolift:checklist -->\n### Reviewer checklist\n\n- [ ] Deployed to staging\n- [ ] Dashboards checked\n<!-- turbolift:checklist-end -->", body)
+	assert.Equal(t, "some body", WithChecklist("some body", "### Reviewer checklist", nil, ""))
+}
+
+func TestItKeepsTickedItemsWhenReplacingAChecklist(t *testing.T) {
+	previous := WithChecklist("old body", "### Reviewer checklist", []string{"Deployed to staging", "Dashboards checked"}, "")
+	previous = "- [x] a checkbox that is not part of the checklist\n" + previous
+	previous = strings.Replace(previous, "- [ ] Dashboards checked", "- [x] Dashboards checked", 1)
+
+	body := WithChecklist("new body", "### Reviewer checklist", []string{"Deployed to staging", "Dashboards checked", "Alerts still firing"}, previous)
+
+	ticked, total := ChecklistProgress(body)
+	assert.Equal(t, 1, ticked)
+	assert.Equal(t, 3, total)
+	assert.Contains(t, body, "- [x] Dashboards checked")
+}
+
+func TestChecklistProgressIgnoresOtherCheckboxes(t *testing.T) {
+	ticked, total := ChecklistProgress("- [x] done\n- [ ] not done")
+	assert.Equal(t, 0, ticked)
+	assert.Equal(t, 0, total)
+}
@@ -0,0 +1,232 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package github
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/skyscanner/turbolift/internal/audit"
+	"github.com/skyscanner/turbolift/internal/executor"
+	"github.com/skyscanner/turbolift/internal/logging"
+)
+
+// defaultHost is the host of repos that are not given one
+const defaultHost = "github.com"
+
+// CredentialsFile is where the user configures which GitHub credentials to use for each host and org:
+// $TURBOLIFT_CREDENTIALS if set, otherwise turbolift/credentials.yaml in the user's config directory
+func CredentialsFile() string {
+	if filename := os.Getenv("TURBOLIFT_CREDENTIALS"); filename != "" {
+		return filename
+	}
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(configDir, "turbolift", "credentials.yaml")
+}
+
+// Credential is where to find the token for the repos on a host and in an org. Either may be left out to match any.
+// Exactly one of TokenEnv, TokenCommand and GhUser says where the token comes from.
+type Credential struct {
+	Host string `yaml:"host"`
+	Org  string `yaml:"org"`
+	// TokenEnv is an environment variable holding the token
+	TokenEnv string `yaml:"token-env"`
+	// TokenCommand is a shell command that prints the token, e.g. to read it from a keychain
+	TokenCommand string `yaml:"token-command"`
+	// GhUser is an account that gh is logged in to, with gh auth login, which gh can have several of per host
+	GhUser string `yaml:"gh-user"`
+}
+
+// Describe says which repos the credential is for
+func (c Credential) Describe() string {
+	host := c.Host
+	if host == "" {
+		host = "any host"
+	}
+	if c.Org == "" {
+		return host
+	}
+	return host + "/" + c.Org
+}
+
+func (c Credential) matches(host string, org string) bool {
+	return (c.Host == "" || strings.EqualFold(c.Host, host)) && (c.Org == "" || strings.EqualFold(c.Org, org))
+}
+
+// specificity ranks credentials for both a host and an org above those for an org, which rank above those for a host
+func (c Credential) specificity() int {
+	rank := 0
+	if c.Org != "" {
+		rank += 2
+	}
+	if c.Host != "" {
+		rank++
+	}
+	return rank
+}
+
+// Credentials are the GitHub credentials that the user has configured. Repos that no credential matches use whichever
+// account gh is logged in to, as they would without any configured.
+type Credentials struct {
+	Credentials []Credential `yaml:"credentials"`
+
+	// mu guards tokens, which caches each credential's token so that commands to get it are only run once
+	mu     sync.Mutex
+	tokens map[int]string
+}
+
+// ReadCredentials reads the credentials configured in a file. A missing file configures none.
+func ReadCredentials(filename string) (*Credentials, error) {
+	credentials := &Credentials{}
+	if filename == "" {
+		return credentials, nil
+	}
+
+	contents, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return credentials, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to read credentials file %s: %w", filename, err)
+	}
+
+	if err := yaml.Unmarshal(contents, credentials); err != nil {
+		return nil, fmt.Errorf("unable to parse credentials file %s: %w", filename, err)
+	}
+	for _, credential := range credentials.Credentials {
+		sources := 0
+		for _, source := range []string{credential.TokenEnv, credential.TokenCommand, credential.GhUser} {
+			if source != "" {
+				sources++
+			}
+		}
+		if sources != 1 {
+			return nil, fmt.Errorf("credentials for %s in %s must have exactly one of token-env, token-command or gh-user", credential.Describe(), filename)
+		}
+	}
+	return credentials, nil
+}
+
+// For finds the credential to use for repos on a host and in an org, preferring the most specific that matches, then
+// the first listed. It returns -1 if none match.
+func (c *Credentials) For(host string, org string) int {
+	found := -1
+	for i, credential := range c.Credentials {
+		if credential.matches(host, org) && (found < 0 || credential.specificity() > c.Credentials[found].specificity()) {
+			found = i
+		}
+	}
+	return found
+}
+
+// needHosts is true if any credential is only for some hosts, so that the host of each working copy must be found
+func (c *Credentials) needHosts() bool {
+	for _, credential := range c.Credentials {
+		if credential.Host != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// env returns the environment that makes gh use the credential for repos on a host and in an org, or nil if none match
+func (c *Credentials) env(ctx context.Context, output io.Writer, host string, org string) ([]string, error) {
+	i := c.For(host, org)
+	if i < 0 {
+		return nil, nil
+	}
+
+	token, err := c.token(ctx, output, i, host)
+	if err != nil {
+		return nil, err
+	}
+	// gh reads GH_TOKEN for github.com and GH_ENTERPRISE_TOKEN for GitHub Enterprise Server hosts
+	return []string{"GH_TOKEN=" + token, "GH_ENTERPRISE_TOKEN=" + token}, nil
+}
+
+func (c *Credentials) token(ctx context.Context, output io.Writer, i int, host string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if token, ok := c.tokens[i]; ok {
+		return token, nil
+	}
+
+	credential := c.Credentials[i]
+	// the commands print the token, which must not be kept in the audit log
+	ctx = audit.WithoutOutput(ctx)
+	var token string
+	var err error
+	switch {
+	case credential.TokenEnv != "":
+		token = os.Getenv(credential.TokenEnv)
+		if token == "" {
+			err = fmt.Errorf("%s is not set", credential.TokenEnv)
+		}
+	case credential.TokenCommand != "":
+		shell, shellArgs := executor.ShellCommand(credential.TokenCommand)
+		token, err = execInstance.ExecuteAndCapture(ctx, output, ".", shell, shellArgs...)
+	default:
+		token, err = execInstance.ExecuteAndCapture(ctx, output, ".", "gh", "auth", "token", "--hostname", host, "--user", credential.GhUser)
+	}
+	if err == nil && strings.TrimSpace(token) == "" {
+		err = errors.New("no token was found")
+	}
+	logging.AddSecret(token)
+	if err != nil {
+		return "", fmt.Errorf("unable to get the GitHub token for %s: %w", credential.Describe(), err)
+	}
+
+	if c.tokens == nil {
+		c.tokens = map[int]string{}
+	}
+	c.tokens[i] = strings.TrimSpace(token)
+	return c.tokens[i], nil
+}
+
+// hostOfRepo finds the host of a repo given as org/repo or host/org/repo
+func hostOfRepo(fullRepoName string) string {
+	if parts := strings.Split(fullRepoName, "/"); len(parts) == 3 {
+		return parts[0]
+	}
+	return defaultHost
+}
+
+// hostOfRemote finds the host of a git remote URL, given as https://host/org/repo, ssh://git@host/org/repo or
+// git@host:org/repo
+func hostOfRemote(remote string) string {
+	remote = strings.TrimSpace(remote)
+	if parsed, err := url.Parse(remote); err == nil && parsed.Host != "" {
+		return parsed.Hostname()
+	}
+	if at := strings.Index(remote, "@"); at >= 0 {
+		remote = remote[at+1:]
+	}
+	if colon := strings.Index(remote, ":"); colon >= 0 {
+		return remote[:colon]
+	}
+	return defaultHost
+}
@@ -0,0 +1,146 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package github
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/executor"
+	"github.com/skyscanner/turbolift/internal/testsupport"
+)
+
+func writeCredentials(t *testing.T, contents string) string {
+	filename := filepath.Join(t.TempDir(), "credentials.yaml")
+	assert.NoError(t, os.WriteFile(filename, []byte(contents), 0o644))
+	return filename
+}
+
+func pwd(t *testing.T) string {
+	dir, err := os.Getwd()
+	assert.NoError(t, err)
+	return dir
+}
+
+func TestItReadsNoCredentialsWithoutAFile(t *testing.T) {
+	credentials, err := ReadCredentials(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.NoError(t, err)
+	assert.Empty(t, credentials.Credentials)
+}
+
+func TestItRejectsCredentialsWithoutExactlyOneSource(t *testing.T) {
+	filename := writeCredentials(t, `
+credentials:
+  - org: acme
+    token-env: ACME_TOKEN
+    gh-user: acme-bot
+`)
+	_, err := ReadCredentials(filename)
+	assert.EqualError(t, err, "credentials for any host/acme in "+filename+" must have exactly one of token-env, token-command or gh-user")
+}
+
+func TestItPrefersTheMostSpecificCredentials(t *testing.T) {
+	credentials := &Credentials{Credentials: []Credential{
+		{TokenEnv: "DEFAULT_TOKEN"},
+		{Host: "github.example.com", TokenEnv: "GHE_TOKEN"},
+		{Org: "acme", TokenEnv: "ACME_TOKEN"},
+		{Host: "github.example.com", Org: "acme", TokenEnv: "GHE_ACME_TOKEN"},
+	}}
+
+	assert.Equal(t, 0, credentials.For("github.com", "other"))
+	assert.Equal(t, 1, credentials.For("github.example.com", "other"))
+	assert.Equal(t, 2, credentials.For("github.com", "ACME"))
+	assert.Equal(t, 3, credentials.For("github.example.com", "acme"))
+	assert.Equal(t, -1, (&Credentials{}).For("github.com", "acme"))
+}
+
+func TestItRunsGhWithTheCredentialsForTheRepo(t *testing.T) {
+	fakeExecutor := executor.NewAlwaysSucceedsFakeExecutor()
+	execInstance = fakeExecutor
+	testsupport.Setenv(t, "ACME_TOKEN", "acme-token")
+
+	gh := &RealGitHub{credentials: &Credentials{Credentials: []Credential{{Org: "acme", TokenEnv: "ACME_TOKEN"}}}}
+	assert.NoError(t, gh.Clone(context.Background(), &strings.Builder{}, "work/acme", "acme/repo1"))
+	assert.NoError(t, gh.Clone(context.Background(), &strings.Builder{}, "work/other", "other/repo1"))
+
+	fakeExecutor.AssertEnvCalledWith(t, [][]string{
+		{"GH_TOKEN=acme-token", "GH_ENTERPRISE_TOKEN=acme-token"},
+		nil,
+	})
+}
+
+func TestItGetsTokensFromCommandsAndGhAccountsOnce(t *testing.T) {
+	fakeExecutor := executor.NewFakeExecutor(func(s string, s2 string, s3 ...string) error {
+		return nil
+	}, func(workingDir string, name string, args ...string) (string, error) {
+		switch {
+		case name == "git":
+			return "git@github.example.com:acme/repo1.git\n", nil
+		case name == "gh" && args[0] == "auth":
+			return "personal-token\n", nil
+		case name == "gh":
+			return "{}", nil
+		default:
+			return "keychain-token\n", nil
+		}
+	})
+	execInstance = fakeExecutor
+
+	gh := &RealGitHub{credentials: &Credentials{Credentials: []Credential{
+		{Host: "github.example.com", Org: "acme", TokenCommand: "security find-generic-password -s acme-bot -w"},
+		{GhUser: "me"},
+	}}}
+	for i := 0; i < 2; i++ {
+		_, _ = gh.GetPR(context.Background(), &strings.Builder{}, "work/acme/repo1", "my-campaign")
+	}
+	_, err := gh.IsPushable(context.Background(), &strings.Builder{}, "other/repo1")
+	assert.NoError(t, err)
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/acme/repo1", "git", "remote", "get-url", "origin"},
+		{".", executor.Shell(), "-c", "security find-generic-password -s acme-bot -w"},
+		{"work/acme/repo1", "gh", "pr", "status", "--json", prStatusFields},
+		{"work/acme/repo1", "git", "remote", "get-url", "origin"},
+		{"work/acme/repo1", "gh", "pr", "status", "--json", prStatusFields},
+		{".", "gh", "auth", "token", "--hostname", "github.com", "--user", "me"},
+		{pwd(t), "gh", "repo", "view", "other/repo1", "--json", "viewerPermission"},
+	})
+	fakeExecutor.AssertEnvCalledWith(t, [][]string{
+		{"GH_TOKEN=keychain-token", "GH_ENTERPRISE_TOKEN=keychain-token"},
+		{"GH_TOKEN=keychain-token", "GH_ENTERPRISE_TOKEN=keychain-token"},
+		{"GH_TOKEN=personal-token", "GH_ENTERPRISE_TOKEN=personal-token"},
+	})
+}
+
+func TestItReportsMissingTokens(t *testing.T) {
+	execInstance = executor.NewAlwaysSucceedsFakeExecutor()
+	testsupport.Setenv(t, "ACME_TOKEN", "")
+
+	gh := &RealGitHub{credentials: &Credentials{Credentials: []Credential{{Org: "acme", TokenEnv: "ACME_TOKEN"}}}}
+	err := gh.Clone(context.Background(), &strings.Builder{}, "work/acme", "acme/repo1")
+	assert.EqualError(t, err, "unable to get the GitHub token for any host/acme: ACME_TOKEN is not set")
+}
+
+func TestItFindsTheHostOfARemote(t *testing.T) {
+	assert.Equal(t, "github.example.com", hostOfRemote("https://github.example.com/acme/repo1.git"))
+	assert.Equal(t, "github.example.com", hostOfRemote("ssh://git@github.example.com/acme/repo1.git"))
+	assert.Equal(t, "github.com", hostOfRemote("git@github.com:acme/repo1.git\n"))
+}
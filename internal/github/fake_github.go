@@ -16,7 +16,9 @@
 package github
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"io"
 	"testing"
 
@@ -30,51 +32,195 @@ const (
 	Clone
 	CreatePullRequest
 	ClosePullRequest
+	ReopenPullRequest
+	EnableAutoMerge
 	GetDefaultBranchName
 	UpdatePRDescription
 	IsPushable
+	ListOpenPRs
+	UpdatePRBranch
+	AddPRLabels
+	RemovePRLabels
+	CommentOnPR
+	RequestReviews
+	ListOrgRepos
 )
 
 type FakeGitHub struct {
 	handler          func(command Command, args []string) (bool, error)
 	returningHandler func(workingDir string) (interface{}, error)
 	calls            [][]string
+	openPRs          map[string][]OpenPR
+	orgRepos         map[string][]OrgRepo
+	rateLimits       map[string]*RateLimit
+	renames          map[string]string
+	goneRepos        map[string]bool
+	linearHistory    map[string]bool
+	protections      map[string]*BranchProtection
+	archivedRepos    map[string]bool
+	createdPRs       int
+	usage            ApiUsage
+	KnownPRs
 }
 
-func (f *FakeGitHub) CreatePullRequest(_ io.Writer, workingDir string, metadata PullRequest) (didCreate bool, err error) {
+// CreatePullRequest numbers the PRs that it creates in the order they are created, starting from 1
+func (f *FakeGitHub) CreatePullRequest(_ context.Context, _ io.Writer, workingDir string, metadata PullRequest) (*PrRef, error) {
+	f.usage.record(orgOfRepo(metadata.UpstreamRepo))
 	args := []string{"create_pull_request", workingDir, metadata.Title}
+	if metadata.BaseBranch != "" {
+		args = append(args, "--base", metadata.BaseBranch)
+	}
+	args = append(args, metadata.Labels...)
 	f.calls = append(f.calls, args)
-	return f.handler(CreatePullRequest, args)
+	didCreate, err := f.handler(CreatePullRequest, args)
+	if !didCreate {
+		return nil, err
+	}
+	f.createdPRs++
+	return &PrRef{Number: f.createdPRs, Url: fmt.Sprintf("https://github.com/%s/pull/%d", metadata.UpstreamRepo, f.createdPRs)}, err
 }
 
-func (f *FakeGitHub) ForkAndClone(_ io.Writer, workingDir string, fullRepoName string) error {
-	args := []string{"fork_and_clone", workingDir, fullRepoName}
+func (f *FakeGitHub) ForkAndClone(_ context.Context, _ io.Writer, workingDir string, fullRepoName string, gitArgs ...string) error {
+	f.usage.record(orgOfRepo(fullRepoName))
+	args := append([]string{"fork_and_clone", workingDir, fullRepoName}, gitArgs...)
 	f.calls = append(f.calls, args)
 	_, err := f.handler(ForkAndClone, args)
 	return err
 }
 
-func (f *FakeGitHub) Clone(_ io.Writer, workingDir string, fullRepoName string) error {
-	args := []string{"clone", workingDir, fullRepoName}
+func (f *FakeGitHub) Clone(_ context.Context, _ io.Writer, workingDir string, fullRepoName string, gitArgs ...string) error {
+	f.usage.record(orgOfRepo(fullRepoName))
+	args := append([]string{"clone", workingDir, fullRepoName}, gitArgs...)
 	f.calls = append(f.calls, args)
 	_, err := f.handler(Clone, args)
 	return err
 }
 
-func (f *FakeGitHub) IsPushable(_ io.Writer, repo string) (bool, error) {
+func (f *FakeGitHub) IsPushable(_ context.Context, _ io.Writer, repo string) (bool, error) {
+	f.usage.record(orgOfRepo(repo))
 	args := []string{"user_can_push", repo}
 	f.calls = append(f.calls, args)
 	return f.handler(IsPushable, args)
 }
 
-func (f *FakeGitHub) ClosePullRequest(_ io.Writer, workingDir string, branchName string) error {
+func (f *FakeGitHub) ClosePullRequest(_ context.Context, _ io.Writer, workingDir string, branchName string) error {
+	f.usage.record(orgOfWorkingCopy(workingDir))
 	args := []string{"close_pull_request", workingDir, branchName}
 	f.calls = append(f.calls, args)
 	_, err := f.handler(ClosePullRequest, args)
 	return err
 }
 
-func (f *FakeGitHub) GetPR(_ io.Writer, workingDir string, _ string) (*PrStatus, error) {
+func (f *FakeGitHub) ReopenPullRequest(_ context.Context, _ io.Writer, workingDir string, branchName string) error {
+	f.usage.record(orgOfWorkingCopy(workingDir))
+	args := []string{"reopen_pull_request", workingDir, branchName}
+	f.calls = append(f.calls, args)
+	_, err := f.handler(ReopenPullRequest, args)
+	return err
+}
+
+// IsArchived is true for the working copies set with WithArchivedRepos. Like RequiresLinearHistory, the call is not
+// recorded.
+func (f *FakeGitHub) IsArchived(_ context.Context, _ io.Writer, workingDir string) (bool, error) {
+	f.usage.record(orgOfWorkingCopy(workingDir))
+	return f.archivedRepos[workingDir], nil
+}
+
+// WithArchivedRepos sets the working copies whose repos have been archived
+func (f *FakeGitHub) WithArchivedRepos(workingDirs ...string) *FakeGitHub {
+	f.archivedRepos = map[string]bool{}
+	for _, workingDir := range workingDirs {
+		f.archivedRepos[workingDir] = true
+	}
+	return f
+}
+
+func (f *FakeGitHub) EnableAutoMerge(_ context.Context, _ io.Writer, workingDir string, branchName string, strategy string) error {
+	f.usage.record(orgOfWorkingCopy(workingDir))
+	args := []string{"enable_auto_merge", workingDir, branchName, strategy}
+	f.calls = append(f.calls, args)
+	_, err := f.handler(EnableAutoMerge, args)
+	return err
+}
+
+func (f *FakeGitHub) UpdatePRBranch(_ context.Context, _ io.Writer, workingDir string, branchName string, rebase bool) error {
+	f.usage.record(orgOfWorkingCopy(workingDir))
+	method := "merge"
+	if rebase {
+		method = "rebase"
+	}
+	args := []string{"update_pr_branch", workingDir, branchName, method}
+	f.calls = append(f.calls, args)
+	_, err := f.handler(UpdatePRBranch, args)
+	return err
+}
+
+func (f *FakeGitHub) AddPRLabels(_ context.Context, _ io.Writer, workingDir string, branchName string, labels []string) error {
+	f.usage.record(orgOfWorkingCopy(workingDir))
+	args := append([]string{"add_pr_labels", workingDir, branchName}, labels...)
+	f.calls = append(f.calls, args)
+	_, err := f.handler(AddPRLabels, args)
+	return err
+}
+
+func (f *FakeGitHub) RemovePRLabels(_ context.Context, _ io.Writer, workingDir string, branchName string, labels []string) error {
+	f.usage.record(orgOfWorkingCopy(workingDir))
+	args := append([]string{"remove_pr_labels", workingDir, branchName}, labels...)
+	f.calls = append(f.calls, args)
+	_, err := f.handler(RemovePRLabels, args)
+	return err
+}
+
+func (f *FakeGitHub) CommentOnPR(_ context.Context, _ io.Writer, workingDir string, branchName string, body string) error {
+	f.usage.record(orgOfWorkingCopy(workingDir))
+	args := []string{"comment_on_pr", workingDir, branchName, body}
+	f.calls = append(f.calls, args)
+	_, err := f.handler(CommentOnPR, args)
+	return err
+}
+
+func (f *FakeGitHub) RequestReviews(_ context.Context, _ io.Writer, workingDir string, branchName string, reviewers []string) error {
+	f.usage.record(orgOfWorkingCopy(workingDir))
+	args := append([]string{"request_reviews", workingDir, branchName}, reviewers...)
+	f.calls = append(f.calls, args)
+	_, err := f.handler(RequestReviews, args)
+	return err
+}
+
+// RequiresLinearHistory is true for the working copies set with WithLinearHistory. As it is checked before every merge,
+// the call is not recorded.
+func (f *FakeGitHub) RequiresLinearHistory(_ context.Context, _ io.Writer, workingDir string, _ string) (bool, error) {
+	f.usage.record(orgOfWorkingCopy(workingDir))
+	return f.linearHistory[workingDir], nil
+}
+
+// BranchProtection returns the protection set with WithBranchProtection for the working copy, or none. Like
+// RequiresLinearHistory, the call is not recorded.
+func (f *FakeGitHub) BranchProtection(_ context.Context, _ io.Writer, workingDir string, _ string) (*BranchProtection, error) {
+	f.usage.record(orgOfWorkingCopy(workingDir))
+	if protection, ok := f.protections[workingDir]; ok {
+		return protection, nil
+	}
+	return &BranchProtection{}, nil
+}
+
+// WithBranchProtection sets the branch protection of the working copies' repos
+func (f *FakeGitHub) WithBranchProtection(protections map[string]*BranchProtection) *FakeGitHub {
+	f.protections = protections
+	return f
+}
+
+// WithLinearHistory sets the working copies whose repos reject merge commits
+func (f *FakeGitHub) WithLinearHistory(workingDirs ...string) *FakeGitHub {
+	f.linearHistory = map[string]bool{}
+	for _, workingDir := range workingDirs {
+		f.linearHistory[workingDir] = true
+	}
+	return f
+}
+
+func (f *FakeGitHub) GetPR(_ context.Context, _ io.Writer, workingDir string, _ string) (*PrStatus, error) {
+	f.usage.record(orgOfWorkingCopy(workingDir))
 	f.calls = append(f.calls, []string{"get_pr", workingDir})
 	result, err := f.returningHandler(workingDir)
 	if result == nil {
@@ -83,20 +229,118 @@ func (f *FakeGitHub) GetPR(_ io.Writer, workingDir string, _ string) (*PrStatus,
 	return result.(*PrStatus), err
 }
 
-func (f *FakeGitHub) GetDefaultBranchName(_ io.Writer, workingDir string, fullRepoName string) (string, error) {
+// GetPRForBranch passes workingDir@branchName to the returning handler, so that fakes can tell branches apart
+func (f *FakeGitHub) GetPRForBranch(_ context.Context, _ io.Writer, workingDir string, branchName string) (*PrStatus, error) {
+	f.usage.record(orgOfWorkingCopy(workingDir))
+	f.calls = append(f.calls, []string{"get_pr_for_branch", workingDir, branchName})
+	result, err := f.returningHandler(workingDir + "@" + branchName)
+	if result == nil {
+		return nil, err
+	}
+	return result.(*PrStatus), err
+}
+
+func (f *FakeGitHub) GetDefaultBranchName(_ context.Context, _ io.Writer, workingDir string, fullRepoName string) (string, error) {
+	f.usage.record(orgOfRepo(fullRepoName))
 	args := []string{"get_default_branch", workingDir, fullRepoName}
 	f.calls = append(f.calls, args)
 	_, err := f.handler(GetDefaultBranchName, args)
 	return "main", err
 }
 
-func (f *FakeGitHub) UpdatePRDescription(_ io.Writer, workingDir string, title string, body string) error {
+func (f *FakeGitHub) UpdatePRDescription(_ context.Context, _ io.Writer, workingDir string, title string, body string) error {
+	f.usage.record(orgOfWorkingCopy(workingDir))
 	args := []string{"update_pr_description", workingDir, title, body}
 	f.calls = append(f.calls, args)
 	_, err := f.handler(UpdatePRDescription, args)
 	return err
 }
 
+func (f *FakeGitHub) ListOpenPRs(_ context.Context, _ io.Writer, workingDir string, fullRepoName string) ([]OpenPR, error) {
+	f.usage.record(orgOfRepo(fullRepoName))
+	args := []string{"list_open_prs", workingDir, fullRepoName}
+	f.calls = append(f.calls, args)
+	if _, err := f.handler(ListOpenPRs, args); err != nil {
+		return nil, err
+	}
+	return f.openPRs[fullRepoName], nil
+}
+
+// WithOpenPRs sets the PRs returned by ListOpenPRs, keyed by full repo name
+func (f *FakeGitHub) WithOpenPRs(prs map[string][]OpenPR) *FakeGitHub {
+	f.openPRs = prs
+	return f
+}
+
+func (f *FakeGitHub) ListOrgRepos(_ context.Context, _ io.Writer, host string, org string) ([]OrgRepo, error) {
+	f.usage.record(org)
+	args := []string{"list_org_repos", host, org}
+	f.calls = append(f.calls, args)
+	if _, err := f.handler(ListOrgRepos, args); err != nil {
+		return nil, err
+	}
+	return f.orgRepos[org], nil
+}
+
+// WithOrgRepos sets the repos returned by ListOrgRepos, keyed by org
+func (f *FakeGitHub) WithOrgRepos(repos map[string][]OrgRepo) *FakeGitHub {
+	f.orgRepos = repos
+	return f
+}
+
+// ResolveRepoName returns the new name set for the repo with WithRenames, or the name it is given. Like RateLimit,
+// the call is not recorded, as clone makes it for every repo.
+func (f *FakeGitHub) ResolveRepoName(_ context.Context, _ io.Writer, fullRepoName string) (string, error) {
+	f.usage.record(orgOfRepo(fullRepoName))
+	if f.goneRepos[fullRepoName] {
+		return "", &RepoGoneError{Repo: fullRepoName}
+	}
+	if name, ok := f.renames[fullRepoName]; ok {
+		return name, nil
+	}
+	return fullRepoName, nil
+}
+
+// WithRenames sets the repos that have been renamed, from their old names to their new ones
+func (f *FakeGitHub) WithRenames(renames map[string]string) *FakeGitHub {
+	f.renames = renames
+	return f
+}
+
+// WithGoneRepos sets the repos that have been deleted, or that the user no longer has access to
+func (f *FakeGitHub) WithGoneRepos(repos ...string) *FakeGitHub {
+	f.goneRepos = map[string]bool{}
+	for _, repo := range repos {
+		f.goneRepos[repo] = true
+	}
+	return f
+}
+
+// RateLimit returns the rate limit set for the host with WithRateLimits, or a full one. As every command that uses GitHub
+// checks its rate limits at the end, the call is not recorded.
+func (f *FakeGitHub) RateLimit(_ context.Context, _ io.Writer, host string) (*RateLimit, error) {
+	if limit, ok := f.rateLimits[host]; ok {
+		if limit == nil {
+			return nil, errors.New("synthetic error")
+		}
+		return limit, nil
+	}
+	return &RateLimit{
+		Core:    RateLimitResource{Limit: 5000, Remaining: 5000},
+		GraphQL: RateLimitResource{Limit: 5000, Remaining: 5000},
+	}, nil
+}
+
+// WithRateLimits sets the rate limits returned for each host. A nil limit makes RateLimit fail for the host.
+func (f *FakeGitHub) WithRateLimits(limits map[string]*RateLimit) *FakeGitHub {
+	f.rateLimits = limits
+	return f
+}
+
+func (f *FakeGitHub) Usage() *ApiUsage {
+	return &f.usage
+}
+
 func (f *FakeGitHub) AssertCalledWith(t *testing.T, expected [][]string) {
 	assert.Equal(t, expected, f.calls)
 }
@@ -113,7 +357,7 @@ func NewAlwaysSucceedsFakeGitHub() *FakeGitHub {
 	return NewFakeGitHub(func(command Command, args []string) (bool, error) {
 		return true, nil
 	}, func(workingDir string) (interface{}, error) {
-		return PrStatus{}, nil
+		return &PrStatus{}, nil
 	})
 }
 
@@ -138,7 +382,7 @@ func NewAlwaysReturnsFalseFakeGitHub() *FakeGitHub {
 	return NewFakeGitHub(func(command Command, args []string) (bool, error) {
 		return false, nil
 	}, func(workingDir string) (interface{}, error) {
-		return PrStatus{}, nil
+		return &PrStatus{}, nil
 	})
 }
 
@@ -149,6 +393,6 @@ func NewAlwaysFailsOnGetDefaultBranchFakeGitHub() *FakeGitHub {
 		}
 		return true, nil
 	}, func(workingDir string) (interface{}, error) {
-		return PrStatus{}, nil
+		return &PrStatus{}, nil
 	})
 }
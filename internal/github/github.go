@@ -16,16 +16,21 @@
 package github
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"regexp"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/skyscanner/turbolift/internal/executor"
 )
 
-var execInstance executor.Executor = executor.NewRealExecutor()
+var execInstance executor.Executor = executor.NewRetryingExecutor(executor.NewRealExecutor())
 
 type PullRequest struct {
 	Title          string
@@ -33,22 +38,62 @@ type PullRequest struct {
 	UpstreamRepo   string
 	IsDraft        bool
 	ReviewDecision string
+	Labels         []string
+	// BaseBranch is the branch that the PR is raised against, or the repo's default branch if empty
+	BaseBranch string
 }
 
 type GitHub interface {
-	ForkAndClone(output io.Writer, workingDir string, fullRepoName string) error
-	Clone(output io.Writer, workingDir string, fullRepoName string) error
-	CreatePullRequest(output io.Writer, workingDir string, metadata PullRequest) (didCreate bool, err error)
-	ClosePullRequest(output io.Writer, workingDir string, branchName string) error
-	UpdatePRDescription(output io.Writer, workingDir string, title string, body string) error
-	GetPR(output io.Writer, workingDir string, branchName string) (*PrStatus, error)
-	GetDefaultBranchName(output io.Writer, workingDir string, fullRepoName string) (string, error)
-	IsPushable(output io.Writer, repo string) (bool, error)
+	// ForkAndClone and Clone pass any gitArgs on to git clone, e.g. to make a sparse checkout
+	ForkAndClone(ctx context.Context, output io.Writer, workingDir string, fullRepoName string, gitArgs ...string) error
+	Clone(ctx context.Context, output io.Writer, workingDir string, fullRepoName string, gitArgs ...string) error
+	// CreatePullRequest returns the PR that was created, or nil if there was nothing to create one for
+	CreatePullRequest(ctx context.Context, output io.Writer, workingDir string, metadata PullRequest) (*PrRef, error)
+	ClosePullRequest(ctx context.Context, output io.Writer, workingDir string, branchName string) error
+	ReopenPullRequest(ctx context.Context, output io.Writer, workingDir string, branchName string) error
+	// IsArchived is true if the working copy's repo has been archived, and so is read-only
+	IsArchived(ctx context.Context, output io.Writer, workingDir string) (bool, error)
+	EnableAutoMerge(ctx context.Context, output io.Writer, workingDir string, branchName string, strategy string) error
+	UpdatePRBranch(ctx context.Context, output io.Writer, workingDir string, branchName string, rebase bool) error
+	AddPRLabels(ctx context.Context, output io.Writer, workingDir string, branchName string, labels []string) error
+	RemovePRLabels(ctx context.Context, output io.Writer, workingDir string, branchName string, labels []string) error
+	CommentOnPR(ctx context.Context, output io.Writer, workingDir string, branchName string, body string) error
+	RequestReviews(ctx context.Context, output io.Writer, workingDir string, branchName string, reviewers []string) error
+	RequiresLinearHistory(ctx context.Context, output io.Writer, workingDir string, baseBranch string) (bool, error)
+	// BranchProtection returns the reviews and checks that PRs against the base branch need before they can merge
+	BranchProtection(ctx context.Context, output io.Writer, workingDir string, baseBranch string) (*BranchProtection, error)
+	UpdatePRDescription(ctx context.Context, output io.Writer, workingDir string, title string, body string) error
+	GetPR(ctx context.Context, output io.Writer, workingDir string, branchName string) (*PrStatus, error)
+	GetPRForBranch(ctx context.Context, output io.Writer, workingDir string, branchName string) (*PrStatus, error)
+	GetDefaultBranchName(ctx context.Context, output io.Writer, workingDir string, fullRepoName string) (string, error)
+	IsPushable(ctx context.Context, output io.Writer, repo string) (bool, error)
+	ResolveRepoName(ctx context.Context, output io.Writer, fullRepoName string) (string, error)
+	ListOpenPRs(ctx context.Context, output io.Writer, workingDir string, fullRepoName string) ([]OpenPR, error)
+	// ListOrgRepos lists every repo in an org on a host, or on github.com if the host is empty
+	ListOrgRepos(ctx context.Context, output io.Writer, host string, org string) ([]OrgRepo, error)
+	RateLimit(ctx context.Context, output io.Writer, host string) (*RateLimit, error)
+	Usage() *ApiUsage
+	// RememberPR records the number of a branch's PR, such as one stored in the campaign's state, so that it is
+	// looked up by its number rather than found again from the branch
+	RememberPR(workingDir string, branchName string, number int)
 }
 
-type RealGitHub struct{}
+type RealGitHub struct {
+	usage ApiUsage
+	KnownPRs
 
-func (r *RealGitHub) CreatePullRequest(output io.Writer, workingDir string, pr PullRequest) (didCreate bool, err error) {
+	// credentials are read from CredentialsFile the first time that a gh command needs them
+	credentialsOnce sync.Once
+	credentials     *Credentials
+	credentialsErr  error
+}
+
+// Usage is the tally of gh commands run against each org so far
+func (r *RealGitHub) Usage() *ApiUsage {
+	return &r.usage
+}
+
+func (r *RealGitHub) CreatePullRequest(ctx context.Context, output io.Writer, workingDir string, pr PullRequest) (*PrRef, error) {
 	gh_args := []string{
 		"pr",
 		"create",
@@ -60,44 +105,450 @@ func (r *RealGitHub) CreatePullRequest(output io.Writer, workingDir string, pr P
 		pr.UpstreamRepo,
 	}
 
+	if pr.BaseBranch != "" {
+		gh_args = append(gh_args, "--base", pr.BaseBranch)
+	}
+
 	if pr.IsDraft {
 		gh_args = append(gh_args, "--draft")
 	}
 
-	execOutput, err := execInstance.ExecuteAndCapture(output, workingDir, "gh", gh_args...)
+	for _, label := range pr.Labels {
+		gh_args = append(gh_args, "--label", label)
+	}
+
+	env, err := r.repoEnv(ctx, output, pr.UpstreamRepo)
+	if err != nil {
+		return nil, err
+	}
+	r.usage.record(orgOfRepo(pr.UpstreamRepo))
+	execOutput, err := execInstance.ExecuteAndCaptureWithEnv(ctx, output, workingDir, env, "gh", gh_args...)
 	if strings.Contains(execOutput, "GraphQL error: No commits between") {
 		// no PR was created because there are no differences between remotes
-		return false, nil
+		return nil, nil
+	} else if match := prExistsRegexp.FindStringSubmatch(execOutput); err != nil && match != nil {
+		return nil, &PrExistsError{Repo: pr.UpstreamRepo, Url: match[1]}
 	} else if err != nil {
+		return nil, asRepoGone(execOutput, pr.UpstreamRepo, err)
+	}
+
+	// gh prints the new PR's URL once it is created
+	url := createdPrRegexp.FindString(execOutput)
+	return &PrRef{Number: PrNumberFromUrl(url), Url: url}, nil
+}
+
+func (r *RealGitHub) ForkAndClone(ctx context.Context, output io.Writer, workingDir string, fullRepoName string, gitArgs ...string) error {
+	env, err := r.repoEnv(ctx, output, fullRepoName)
+	if err != nil {
+		return err
+	}
+	r.usage.record(orgOfRepo(fullRepoName))
+	args := withGitArgs([]string{"repo", "fork", "--clone=true", fullRepoName}, gitArgs)
+	return execInstance.ExecuteWithEnv(ctx, output, workingDir, env, "gh", args...)
+}
+
+func (r *RealGitHub) Clone(ctx context.Context, output io.Writer, workingDir string, fullRepoName string, gitArgs ...string) error {
+	env, err := r.repoEnv(ctx, output, fullRepoName)
+	if err != nil {
+		return err
+	}
+	r.usage.record(orgOfRepo(fullRepoName))
+	args := withGitArgs([]string{"repo", "clone", fullRepoName}, gitArgs)
+	return execInstance.ExecuteWithEnv(ctx, output, workingDir, env, "gh", args...)
+}
+
+// withGitArgs adds flags for git clone to a gh command, after the -- that separates them from gh's own
+func withGitArgs(args []string, gitArgs []string) []string {
+	if len(gitArgs) == 0 {
+		return args
+	}
+	return append(append(args, "--"), gitArgs...)
+}
+
+func (r *RealGitHub) ClosePullRequest(ctx context.Context, output io.Writer, workingDir string, branchName string) error {
+	pr, err := r.GetPR(ctx, output, workingDir, branchName)
+	if err != nil {
+		return err
+	}
+
+	// merged and closed PRs have nothing to do, and those in archived repos cannot be changed
+	if err := RequireOpen(ctx, r, output, workingDir, pr); err != nil {
+		return err
+	}
+
+	env, err := r.workingCopyEnv(ctx, output, workingDir)
+	if err != nil {
+		return err
+	}
+	r.usage.record(orgOfWorkingCopy(workingDir))
+	return execInstance.ExecuteWithEnv(ctx, output, workingDir, env, "gh", "pr", "close", fmt.Sprint(pr.Number))
+}
+
+func (r *RealGitHub) ReopenPullRequest(ctx context.Context, output io.Writer, workingDir string, branchName string) error {
+	pr, err := r.GetPR(ctx, output, workingDir, branchName)
+	if err != nil {
+		return err
+	}
+
+	// merged PRs cannot be reopened, open ones have nothing to do, and those in archived repos cannot be changed
+	state, err := r.classifyPR(ctx, output, workingDir, pr)
+	if err != nil {
+		return err
+	}
+	switch state {
+	case PrRepoArchived:
+		return &RepoArchivedError{Repo: repoOfWorkingCopy(workingDir)}
+	case PrMerged, PrOpen:
+		return &PrNotClosedError{Path: workingDir, State: state}
+	}
+
+	env, err := r.workingCopyEnv(ctx, output, workingDir)
+	if err != nil {
+		return err
+	}
+	r.usage.record(orgOfWorkingCopy(workingDir))
+	return execInstance.ExecuteWithEnv(ctx, output, workingDir, env, "gh", "pr", "reopen", fmt.Sprint(pr.Number))
+}
+
+// IsArchived is true if the working copy's repo has been archived, and so is read-only
+func (r *RealGitHub) IsArchived(ctx context.Context, output io.Writer, workingDir string) (bool, error) {
+	env, err := r.workingCopyEnv(ctx, output, workingDir)
+	if err != nil {
 		return false, err
 	}
-	return true, nil
+	r.usage.record(orgOfWorkingCopy(workingDir))
+	archived, err := execInstance.ExecuteAndCaptureWithEnv(ctx, output, workingDir, env, "gh", "repo", "view", "--json", "isArchived", "--jq", ".isArchived")
+	if err != nil {
+		return false, asRepoGone(archived, repoOfWorkingCopy(workingDir), err)
+	}
+	return strings.Trim(archived, "\n") == "true", nil
+}
+
+// classifyPR classifies the working copy's PR, only checking whether its repo is archived for PRs that are not
+// merged, as they stay merged whatever happens to the repo
+func (r *RealGitHub) classifyPR(ctx context.Context, output io.Writer, workingDir string, pr *PrStatus) (string, error) {
+	if pr.State == PrMerged {
+		return PrMerged, nil
+	}
+	archived, err := r.IsArchived(ctx, output, workingDir)
+	if err != nil {
+		return "", err
+	}
+	return ClassifyPR(pr, archived), nil
+}
+
+// RequireOpen returns a PrNotOpenError for PRs that have been merged or closed, and a RepoArchivedError for PRs in
+// archived repos, as neither can be changed any more. Whether the repo is archived is only asked for PRs that are not
+// merged.
+func RequireOpen(ctx context.Context, forge GitHub, output io.Writer, workingDir string, pr *PrStatus) error {
+	archived := false
+	if pr.State != PrMerged {
+		var err error
+		if archived, err = forge.IsArchived(ctx, output, workingDir); err != nil {
+			return err
+		}
+	}
+	switch state := ClassifyPR(pr, archived); state {
+	case PrRepoArchived:
+		return &RepoArchivedError{Repo: repoOfWorkingCopy(workingDir)}
+	case PrMerged, PrClosed:
+		return &PrNotOpenError{Path: workingDir, State: state}
+	}
+	return nil
+}
+
+// MergeStrategies are the ways that GitHub can merge a PR, as accepted by EnableAutoMerge
+var MergeStrategies = []string{"merge", "squash", "rebase"}
+
+func IsMergeStrategy(strategy string) bool {
+	for _, s := range MergeStrategies {
+		if s == strategy {
+			return true
+		}
+	}
+	return false
+}
+
+// EnableAutoMerge turns on auto-merge for the PR, so that GitHub merges it using the given strategy once its
+// required reviews and checks have passed
+func (r *RealGitHub) EnableAutoMerge(ctx context.Context, output io.Writer, workingDir string, branchName string, strategy string) error {
+	pr, err := r.GetPR(ctx, output, workingDir, branchName)
+	if err != nil {
+		return err
+	}
+	if err := RequireOpen(ctx, r, output, workingDir, pr); err != nil {
+		return err
+	}
+
+	env, err := r.workingCopyEnv(ctx, output, workingDir)
+	if err != nil {
+		return err
+	}
+	r.usage.record(orgOfWorkingCopy(workingDir))
+	return execInstance.ExecuteWithEnv(ctx, output, workingDir, env, "gh", "pr", "merge", fmt.Sprint(pr.Number), "--auto", "--"+strategy)
 }
 
-func (r *RealGitHub) ForkAndClone(output io.Writer, workingDir string, fullRepoName string) error {
-	return execInstance.Execute(output, workingDir, "gh", "repo", "fork", "--clone=true", fullRepoName)
+// MergeStrategyFor returns the strategy to merge the working copy's PR with. Repos that require linear history reject
+// merge commits, so their PRs are squashed instead; the other strategies already keep the history linear.
+func MergeStrategyFor(ctx context.Context, forge GitHub, output io.Writer, workingDir string, baseBranch string, strategy string) (string, error) {
+	if strategy != "merge" {
+		return strategy, nil
+	}
+	linear, err := forge.RequiresLinearHistory(ctx, output, workingDir, baseBranch)
+	if err != nil {
+		return "", err
+	}
+	if linear {
+		return "squash", nil
+	}
+	return strategy, nil
 }
 
-func (r *RealGitHub) Clone(output io.Writer, workingDir string, fullRepoName string) error {
-	return execInstance.Execute(output, workingDir, "gh", "repo", "clone", fullRepoName)
+// UpdatePRBranch brings the PR's branch up to date with its base branch, by merging the base branch into it or,
+// if rebase is true, by rebasing it onto the base branch
+func (r *RealGitHub) UpdatePRBranch(ctx context.Context, output io.Writer, workingDir string, branchName string, rebase bool) error {
+	pr, err := r.GetPR(ctx, output, workingDir, branchName)
+	if err != nil {
+		return err
+	}
+	if err := RequireOpen(ctx, r, output, workingDir, pr); err != nil {
+		return err
+	}
+
+	env, err := r.workingCopyEnv(ctx, output, workingDir)
+	if err != nil {
+		return err
+	}
+	args := []string{"pr", "update-branch", fmt.Sprint(pr.Number)}
+	if rebase {
+		args = append(args, "--rebase")
+	}
+	r.usage.record(orgOfWorkingCopy(workingDir))
+	return execInstance.ExecuteWithEnv(ctx, output, workingDir, env, "gh", args...)
+}
+
+// AddPRLabels adds the labels to the PR, creating none: labels that do not exist in the repo make it fail
+func (r *RealGitHub) AddPRLabels(ctx context.Context, output io.Writer, workingDir string, branchName string, labels []string) error {
+	return r.editPRLabels(ctx, output, workingDir, branchName, "--add-label", labels)
+}
+
+// RemovePRLabels removes the labels from the PR. Labels that the PR does not have are ignored.
+func (r *RealGitHub) RemovePRLabels(ctx context.Context, output io.Writer, workingDir string, branchName string, labels []string) error {
+	return r.editPRLabels(ctx, output, workingDir, branchName, "--remove-label", labels)
+}
+
+func (r *RealGitHub) editPRLabels(ctx context.Context, output io.Writer, workingDir string, branchName string, flag string, labels []string) error {
+	pr, err := r.GetPR(ctx, output, workingDir, branchName)
+	if err != nil {
+		return err
+	}
+	if err := RequireOpen(ctx, r, output, workingDir, pr); err != nil {
+		return err
+	}
+
+	env, err := r.workingCopyEnv(ctx, output, workingDir)
+	if err != nil {
+		return err
+	}
+	r.usage.record(orgOfWorkingCopy(workingDir))
+	return execInstance.ExecuteWithEnv(ctx, output, workingDir, env, "gh", "pr", "edit", fmt.Sprint(pr.Number), flag, strings.Join(labels, ","))
+}
+
+// CommentOnPR adds a comment to the PR's conversation
+func (r *RealGitHub) CommentOnPR(ctx context.Context, output io.Writer, workingDir string, branchName string, body string) error {
+	pr, err := r.GetPR(ctx, output, workingDir, branchName)
+	if err != nil {
+		return err
+	}
+
+	env, err := r.workingCopyEnv(ctx, output, workingDir)
+	if err != nil {
+		return err
+	}
+	r.usage.record(orgOfWorkingCopy(workingDir))
+	return execInstance.ExecuteWithEnv(ctx, output, workingDir, env, "gh", "pr", "comment", fmt.Sprint(pr.Number), "--body", body)
 }
 
-func (r *RealGitHub) ClosePullRequest(output io.Writer, workingDir string, branchName string) error {
-	pr, err := r.GetPR(output, workingDir, branchName)
+// RequestReviews asks the reviewers, given as logins or org/team slugs, to review the PR. Reviewers who have already
+// been asked are notified again.
+func (r *RealGitHub) RequestReviews(ctx context.Context, output io.Writer, workingDir string, branchName string, reviewers []string) error {
+	pr, err := r.GetPR(ctx, output, workingDir, branchName)
 	if err != nil {
 		return err
 	}
 
-	return execInstance.Execute(output, workingDir, "gh", "pr", "close", fmt.Sprint(pr.Number))
+	env, err := r.workingCopyEnv(ctx, output, workingDir)
+	if err != nil {
+		return err
+	}
+	r.usage.record(orgOfWorkingCopy(workingDir))
+	return execInstance.ExecuteWithEnv(ctx, output, workingDir, env, "gh", "pr", "edit", fmt.Sprint(pr.Number), "--add-reviewer", strings.Join(reviewers, ","))
 }
 
-func (r *RealGitHub) UpdatePRDescription(output io.Writer, workingDir string, title string, body string) error {
-	return execInstance.Execute(output, workingDir, "gh", "pr", "edit", "--title", title, "--body", body)
+// RequiresLinearHistory reports whether merge commits are rejected on the given base branch of the working copy's
+// repo, or on its default branch if baseBranch is empty. Both rulesets and classic branch protection are checked,
+// but only repo admins can read the latter, so for everyone else it is assumed not to require linear history.
+func (r *RealGitHub) RequiresLinearHistory(ctx context.Context, output io.Writer, workingDir string, baseBranch string) (bool, error) {
+	env, err := r.workingCopyEnv(ctx, output, workingDir)
+	if err != nil {
+		return false, err
+	}
+	r.usage.record(orgOfWorkingCopy(workingDir))
+	baseBranch, err = baseBranchOf(ctx, output, workingDir, env, baseBranch)
+	if err != nil {
+		return false, err
+	}
+
+	rules, err := execInstance.ExecuteAndCaptureWithEnv(ctx, output, workingDir, env, "gh", "api", "repos/{owner}/{repo}/rules/branches/"+baseBranch, "--jq", ".[].type")
+	if err != nil {
+		return false, asRepoGone(rules, repoOfWorkingCopy(workingDir), err)
+	}
+	for _, rule := range strings.Split(rules, "\n") {
+		if rule == "required_linear_history" {
+			return true, nil
+		}
+	}
+
+	protection, err := execInstance.ExecuteAndCaptureWithEnv(ctx, output, workingDir, env, "gh", "api", "repos/{owner}/{repo}/branches/"+baseBranch+"/protection", "--jq", ".required_linear_history.enabled")
+	if err != nil {
+		return false, nil
+	}
+	return strings.Trim(protection, "\n") == "true", nil
+}
+
+// baseBranchOf returns the base branch, or the working copy's default branch if baseBranch is empty
+func baseBranchOf(ctx context.Context, output io.Writer, workingDir string, env []string, baseBranch string) (string, error) {
+	if baseBranch != "" {
+		return baseBranch, nil
+	}
+	defaultBranch, err := execInstance.ExecuteAndCaptureWithEnv(ctx, output, workingDir, env, "gh", "repo", "view", "--json", "defaultBranchRef", "--jq", ".defaultBranchRef.name")
+	if err != nil {
+		return "", asRepoGone(defaultBranch, repoOfWorkingCopy(workingDir), err)
+	}
+	return strings.Trim(defaultBranch, "\n"), nil
+}
+
+// BranchProtection is what PRs against a branch need before they can merge, combining its rulesets and classic
+// branch protection
+type BranchProtection struct {
+	RequiredApprovingReviews int
+	RequiresCodeOwnerReviews bool
+	// RequiredChecks are the names of the checks, or the contexts of the commit statuses, that must pass
+	RequiredChecks []string
+	// Partial is true if classic branch protection could not be read, which only repo admins can do, so that only
+	// the branch's rulesets are included
+	Partial bool
 }
 
-func (r *RealGitHub) GetDefaultBranchName(output io.Writer, workingDir string, fullRepoName string) (string, error) {
-	defaultBranch, err := execInstance.ExecuteAndCapture(output, workingDir, "gh", "repo", "view", fullRepoName, "--json", "defaultBranchRef", "--jq", ".defaultBranchRef.name")
-	return strings.Trim(defaultBranch, "\n"), err
+type branchRule struct {
+	Type       string `json:"type"`
+	Parameters struct {
+		RequiredApprovingReviewCount int  `json:"required_approving_review_count"`
+		RequireCodeOwnerReview       bool `json:"require_code_owner_review"`
+		RequiredStatusChecks         []struct {
+			Context string `json:"context"`
+		} `json:"required_status_checks"`
+	} `json:"parameters"`
+}
+
+type classicBranchProtection struct {
+	RequiredPullRequestReviews *struct {
+		RequiredApprovingReviewCount int  `json:"required_approving_review_count"`
+		RequireCodeOwnerReviews      bool `json:"require_code_owner_reviews"`
+	} `json:"required_pull_request_reviews"`
+	RequiredStatusChecks *struct {
+		Contexts []string `json:"contexts"`
+	} `json:"required_status_checks"`
+}
+
+// BranchProtection reads the rulesets that apply to the base branch of the working copy's repo, or to its default
+// branch if baseBranch is empty, along with its classic branch protection if the user is allowed to read it
+func (r *RealGitHub) BranchProtection(ctx context.Context, output io.Writer, workingDir string, baseBranch string) (*BranchProtection, error) {
+	env, err := r.workingCopyEnv(ctx, output, workingDir)
+	if err != nil {
+		return nil, err
+	}
+	r.usage.record(orgOfWorkingCopy(workingDir))
+	baseBranch, err = baseBranchOf(ctx, output, workingDir, env, baseBranch)
+	if err != nil {
+		return nil, err
+	}
+
+	rulesJson, err := execInstance.ExecuteAndCaptureWithEnv(ctx, output, workingDir, env, "gh", "api", "repos/{owner}/{repo}/rules/branches/"+baseBranch)
+	if err != nil {
+		return nil, asRepoGone(rulesJson, repoOfWorkingCopy(workingDir), err)
+	}
+	var rules []branchRule
+	if err := json.Unmarshal([]byte(rulesJson), &rules); err != nil {
+		return nil, fmt.Errorf("unable to parse the rules of branch %s: %w", baseBranch, err)
+	}
+
+	protection := &BranchProtection{}
+	for _, rule := range rules {
+		switch rule.Type {
+		case "pull_request":
+			protection.requireReviews(rule.Parameters.RequiredApprovingReviewCount, rule.Parameters.RequireCodeOwnerReview)
+		case "required_status_checks":
+			for _, check := range rule.Parameters.RequiredStatusChecks {
+				protection.requireCheck(check.Context)
+			}
+		}
+	}
+
+	classicJson, err := execInstance.ExecuteAndCaptureWithEnv(ctx, output, workingDir, env, "gh", "api", "repos/{owner}/{repo}/branches/"+baseBranch+"/protection")
+	if err != nil {
+		// branches without classic protection are reported as not found, even to admins
+		protection.Partial = !strings.Contains(classicJson, "Branch not protected")
+		return protection, nil
+	}
+	var classic classicBranchProtection
+	if err := json.Unmarshal([]byte(classicJson), &classic); err != nil {
+		return nil, fmt.Errorf("unable to parse the protection of branch %s: %w", baseBranch, err)
+	}
+	if reviews := classic.RequiredPullRequestReviews; reviews != nil {
+		protection.requireReviews(reviews.RequiredApprovingReviewCount, reviews.RequireCodeOwnerReviews)
+	}
+	if checks := classic.RequiredStatusChecks; checks != nil {
+		for _, check := range checks.Contexts {
+			protection.requireCheck(check)
+		}
+	}
+	return protection, nil
+}
+
+func (p *BranchProtection) requireReviews(approvals int, codeOwners bool) {
+	if approvals > p.RequiredApprovingReviews {
+		p.RequiredApprovingReviews = approvals
+	}
+	p.RequiresCodeOwnerReviews = p.RequiresCodeOwnerReviews || codeOwners
+}
+
+func (p *BranchProtection) requireCheck(name string) {
+	for _, check := range p.RequiredChecks {
+		if check == name {
+			return
+		}
+	}
+	p.RequiredChecks = append(p.RequiredChecks, name)
+}
+
+func (r *RealGitHub) UpdatePRDescription(ctx context.Context, output io.Writer, workingDir string, title string, body string) error {
+	env, err := r.workingCopyEnv(ctx, output, workingDir)
+	if err != nil {
+		return err
+	}
+	r.usage.record(orgOfWorkingCopy(workingDir))
+	return execInstance.ExecuteWithEnv(ctx, output, workingDir, env, "gh", "pr", "edit", "--title", title, "--body", body)
+}
+
+func (r *RealGitHub) GetDefaultBranchName(ctx context.Context, output io.Writer, workingDir string, fullRepoName string) (string, error) {
+	env, err := r.repoEnv(ctx, output, fullRepoName)
+	if err != nil {
+		return "", err
+	}
+	r.usage.record(orgOfRepo(fullRepoName))
+	defaultBranch, err := execInstance.ExecuteAndCaptureWithEnv(ctx, output, workingDir, env, "gh", "repo", "view", fullRepoName, "--json", "defaultBranchRef", "--jq", ".defaultBranchRef.name")
+	return strings.Trim(defaultBranch, "\n"), asRepoGone(defaultBranch, fullRepoName, err)
 }
 
 // the following is used internally to retrieve PRs from a given repository
@@ -109,19 +560,41 @@ type PrStatusResponse struct { // https://github.com/cli/cli/blob/4b415f80d79e57
 	NeedsReview   []*PrStatus `json:"needsReview"`
 }
 
+const prStatusFields = "baseRefName,body,closed,createdAt,headRefName,latestReviews,mergeStateStatus,mergeable,mergedAt,number,reactionGroups,reviewDecision,reviewRequests,state,statusCheckRollup,title,url"
+
 type PrStatus struct {
+	BaseRefName       string              `json:"baseRefName"`
+	Body              string              `json:"body"`
 	Closed            bool                `json:"closed"`
+	CreatedAt         time.Time           `json:"createdAt"`
 	HeadRefName       string              `json:"headRefName"`
+	LatestReviews     []Review            `json:"latestReviews"`
+	MergeStateStatus  string              `json:"mergeStateStatus"`
 	Mergeable         string              `json:"mergeable"`
+	MergedAt          time.Time           `json:"mergedAt"`
 	Number            int                 `json:"number"`
 	ReactionGroups    []ReactionGroup     `json:"reactionGroups"`
 	ReviewDecision    string              `json:"reviewDecision"`
+	ReviewRequests    []ReviewRequest     `json:"reviewRequests"`
 	State             string              `json:"state"`
 	StatusCheckRollup []StatusCheckRollup `json:"statusCheckRollup"`
 	Title             string              `json:"title"`
 	Url               string              `json:"url"`
 }
 
+// Unreviewed is true if nobody has reviewed the PR yet, whether or not its repo requires reviews
+func (p *PrStatus) Unreviewed() bool {
+	return len(p.LatestReviews) == 0 && p.ReviewDecision != "APPROVED" && p.ReviewDecision != "CHANGES_REQUESTED"
+}
+
+// Review is the latest review of a PR by one of its reviewers
+type Review struct {
+	Author struct {
+		Login string
+	}
+	State string
+}
+
 type ReactionGroupUsers struct {
 	TotalCount int
 }
@@ -131,8 +604,32 @@ type ReactionGroup struct {
 	Users   ReactionGroupUsers
 }
 
+// ReviewRequest is a user or team whose review of a PR has been requested. Users have a Login, and teams a Slug.
+type ReviewRequest struct {
+	Login string
+	Name  string
+	Slug  string
+}
+
+// Reviewer names the user or team that has been asked for a review
+func (r ReviewRequest) Reviewer() string {
+	if r.Slug != "" {
+		return r.Slug
+	}
+	if r.Login != "" {
+		return r.Login
+	}
+	return r.Name
+}
+
+// StatusCheckRollup is either a commit status, which has a State, or a check run, which has a Status and Conclusion
 type StatusCheckRollup struct {
-	State string
+	Name string
+	// Context is the name of a commit status, which is given in place of the name of a check run
+	Context    string
+	State      string
+	Status     string
+	Conclusion string
 }
 
 // GetPR is a helper function to retrieve the PR associated with the branch Name
@@ -145,11 +642,93 @@ func (e *NoPRFoundError) Error() string {
 	return fmt.Sprintf("no PR found for %s and branch %s", e.Path, e.BranchName)
 }
 
-func (r *RealGitHub) GetPR(output io.Writer, workingDir string, branchName string) (*PrStatus, error) {
-	s, err := execInstance.ExecuteAndCapture(output, workingDir, "gh", "pr", "status", "--json", "closed,headRefName,mergeable,number,reactionGroups,reviewDecision,state,statusCheckRollup,title,url")
+// PrExistsError is returned by CreatePullRequest when the branch already has an open PR
+type PrExistsError struct {
+	Repo string
+	// Url is the existing PR's, if it is known
+	Url string
+}
+
+func (e *PrExistsError) Error() string {
+	if e.Url == "" {
+		return fmt.Sprintf("a PR already exists for the branch in %s", e.Repo)
+	}
+	return fmt.Sprintf("a PR already exists for the branch in %s: %s", e.Repo, e.Url)
+}
+
+// prExistsRegexp matches gh's complaint that the branch already has a PR, capturing the PR's URL
+var prExistsRegexp = regexp.MustCompile(`a pull request for branch "[^"]*" into branch "[^"]*" already exists:\s*(\S+)`)
+
+// createdPrRegexp matches the URL that gh prints for a PR that it has created
+var createdPrRegexp = regexp.MustCompile(`https?://\S+/pull/\d+`)
+
+// RepoGoneError is returned when GitHub cannot find a repo, because it has been deleted or made private since it was
+// added to the campaign, or the user has otherwise lost access to it
+type RepoGoneError struct {
+	Repo string
+}
+
+func (e *RepoGoneError) Error() string {
+	return fmt.Sprintf("%s has been deleted or is no longer accessible", e.Repo)
+}
+
+// IsRepoGone is true if the error is, or wraps, a RepoGoneError
+func IsRepoGone(err error) bool {
+	var gone *RepoGoneError
+	return errors.As(err, &gone)
+}
+
+// asRepoGone turns the error from a gh command into a RepoGoneError if its output shows that the repo could not be found
+func asRepoGone(output string, repo string, err error) error {
+	if err != nil && strings.Contains(output, "Could not resolve to a Repository") {
+		return &RepoGoneError{Repo: repo}
+	}
+	return err
+}
+
+// PrNotOpenError is returned when asked to close a PR that has already been merged or closed
+type PrNotOpenError struct {
+	Path  string
+	State string
+}
+
+func (e *PrNotOpenError) Error() string {
+	return fmt.Sprintf("PR for %s is already %s", e.Path, strings.ToLower(e.State))
+}
+
+// RepoArchivedError is returned when asked to change a PR in a repo that has been archived, and so is read-only
+type RepoArchivedError struct {
+	Repo string
+}
+
+func (e *RepoArchivedError) Error() string {
+	return fmt.Sprintf("%s is archived, so its PR cannot be changed", e.Repo)
+}
+
+// PrNotClosedError is returned when asked to reopen a PR that is open or merged
+type PrNotClosedError struct {
+	Path  string
+	State string
+}
+
+func (e *PrNotClosedError) Error() string {
+	return fmt.Sprintf("PR for %s is %s, not closed", e.Path, strings.ToLower(e.State))
+}
+
+func (r *RealGitHub) GetPR(ctx context.Context, output io.Writer, workingDir string, branchName string) (*PrStatus, error) {
+	if number, ok := r.KnownPR(workingDir, branchName); ok {
+		return r.viewPR(ctx, output, workingDir, branchName, fmt.Sprint(number))
+	}
+
+	env, err := r.workingCopyEnv(ctx, output, workingDir)
 	if err != nil {
 		return nil, err
 	}
+	r.usage.record(orgOfWorkingCopy(workingDir))
+	s, err := execInstance.ExecuteAndCaptureWithEnv(ctx, output, workingDir, env, "gh", "pr", "status", "--json", prStatusFields)
+	if err != nil {
+		return nil, asRepoGone(s, repoOfWorkingCopy(workingDir), err)
+	}
 
 	var prr PrStatusResponse
 	if err := json.Unmarshal([]byte(s), &prr); err != nil {
@@ -172,21 +751,218 @@ func (r *RealGitHub) GetPR(output io.Writer, workingDir string, branchName strin
 	return nil, &NoPRFoundError{Path: workingDir, BranchName: branchName}
 }
 
-func (r *RealGitHub) IsPushable(output io.Writer, repo string) (bool, error) {
+// GetPRForBranch retrieves the PR for a branch that need not be checked out, such as one from an earlier iteration of the campaign
+func (r *RealGitHub) GetPRForBranch(ctx context.Context, output io.Writer, workingDir string, branchName string) (*PrStatus, error) {
+	if number, ok := r.KnownPR(workingDir, branchName); ok {
+		return r.viewPR(ctx, output, workingDir, branchName, fmt.Sprint(number))
+	}
+	return r.viewPR(ctx, output, workingDir, branchName, branchName)
+}
+
+// viewPR retrieves a branch's PR, given by its number or its branch
+func (r *RealGitHub) viewPR(ctx context.Context, output io.Writer, workingDir string, branchName string, pr string) (*PrStatus, error) {
+	env, err := r.workingCopyEnv(ctx, output, workingDir)
+	if err != nil {
+		return nil, err
+	}
+	r.usage.record(orgOfWorkingCopy(workingDir))
+	s, err := execInstance.ExecuteAndCaptureWithEnv(ctx, output, workingDir, env, "gh", "pr", "view", pr, "--json", prStatusFields)
+	if strings.Contains(s, "no pull requests found") {
+		return nil, &NoPRFoundError{Path: workingDir, BranchName: branchName}
+	} else if err != nil {
+		return nil, asRepoGone(s, repoOfWorkingCopy(workingDir), err)
+	}
+
+	var status PrStatus
+	if err := json.Unmarshal([]byte(s), &status); err != nil {
+		return nil, fmt.Errorf("unable to unmarshall the Pr View Output: %w", err)
+	}
+	return &status, nil
+}
+
+func (r *RealGitHub) IsPushable(ctx context.Context, output io.Writer, repo string) (bool, error) {
 	// The command can be run from any repo
 	// so we use the current repository.
 	currentDir, err := os.Getwd()
 	if err != nil {
 		return false, err
 	}
-	s, err := execInstance.ExecuteAndCapture(output, currentDir, "gh", "repo", "view", repo, "--json", "viewerPermission")
+	env, err := r.repoEnv(ctx, output, repo)
 	if err != nil {
 		return false, err
 	}
+	r.usage.record(orgOfRepo(repo))
+	s, err := execInstance.ExecuteAndCaptureWithEnv(ctx, output, currentDir, env, "gh", "repo", "view", repo, "--json", "viewerPermission")
+	if err != nil {
+		return false, asRepoGone(s, repo, err)
+	}
 
 	return userHasPushPermission(s)
 }
 
+// ResolveRepoName asks GitHub for the current name of a repo, which differs from the one given if the repo has been
+// renamed or transferred to another org. Repos on other hosts keep their host in the name returned.
+func (r *RealGitHub) ResolveRepoName(ctx context.Context, output io.Writer, fullRepoName string) (string, error) {
+	env, err := r.repoEnv(ctx, output, fullRepoName)
+	if err != nil {
+		return "", err
+	}
+	r.usage.record(orgOfRepo(fullRepoName))
+	s, err := execInstance.ExecuteAndCaptureWithEnv(ctx, output, ".", env, "gh", "repo", "view", fullRepoName, "--json", "nameWithOwner", "--jq", ".nameWithOwner")
+	if err != nil {
+		return "", asRepoGone(s, fullRepoName, err)
+	}
+
+	name := strings.TrimSpace(s)
+	if parts := strings.Split(fullRepoName, "/"); len(parts) == 3 {
+		name = parts[0] + "/" + name
+	}
+	// GitHub names are not case-sensitive, so a difference in case alone is not a rename
+	if strings.EqualFold(name, fullRepoName) {
+		return fullRepoName, nil
+	}
+	return name, nil
+}
+
+type OpenPR struct {
+	Number      int      `json:"number"`
+	Url         string   `json:"url"`
+	Body        string   `json:"body"`
+	HeadRefName string   `json:"headRefName"`
+	Files       []PrFile `json:"files"`
+}
+
+type PrFile struct {
+	Path string `json:"path"`
+}
+
+// Campaign returns the name of the turbolift campaign that generated the PR, if any
+func (pr OpenPR) Campaign() string {
+	return CampaignFromBody(pr.Body)
+}
+
+func (pr OpenPR) Paths() []string {
+	paths := []string{}
+	for _, file := range pr.Files {
+		paths = append(paths, file.Path)
+	}
+	return paths
+}
+
+// ListOpenPRs lists the open PRs of a repository, along with the files that each one touches
+func (r *RealGitHub) ListOpenPRs(ctx context.Context, output io.Writer, workingDir string, fullRepoName string) ([]OpenPR, error) {
+	env, err := r.repoEnv(ctx, output, fullRepoName)
+	if err != nil {
+		return nil, err
+	}
+	r.usage.record(orgOfRepo(fullRepoName))
+	s, err := execInstance.ExecuteAndCaptureWithEnv(ctx, output, workingDir, env, "gh", "pr", "list", "--repo", fullRepoName, "--state", "open", "--limit", "100", "--json", "number,url,body,headRefName,files")
+	if err != nil {
+		return nil, asRepoGone(s, fullRepoName, err)
+	}
+
+	var prs []OpenPR
+	if err := json.Unmarshal([]byte(s), &prs); err != nil {
+		return nil, fmt.Errorf("unable to unmarshall the PR list output: %w", err)
+	}
+	return prs, nil
+}
+
+// OrgRepo is a repo listed in an org, with the details that repos can be chosen by when expanding an org
+type OrgRepo struct {
+	NameWithOwner   string `json:"nameWithOwner"`
+	IsArchived      bool   `json:"isArchived"`
+	IsFork          bool   `json:"isFork"`
+	PrimaryLanguage struct {
+		Name string `json:"name"`
+	} `json:"primaryLanguage"`
+	RepositoryTopics []struct {
+		Name string `json:"name"`
+	} `json:"repositoryTopics"`
+}
+
+// Topics are the names of the repo's topics
+func (r OrgRepo) Topics() []string {
+	topics := []string{}
+	for _, topic := range r.RepositoryTopics {
+		topics = append(topics, topic.Name)
+	}
+	return topics
+}
+
+// orgRepoLimit is the most repos that are listed in an org, which is more than even the largest orgs have
+const orgRepoLimit = 10000
+
+func (r *RealGitHub) ListOrgRepos(ctx context.Context, output io.Writer, host string, org string) ([]OrgRepo, error) {
+	credentialsHost := host
+	if credentialsHost == "" {
+		credentialsHost = defaultHost
+	}
+	env, err := r.orgEnv(ctx, output, credentialsHost, org)
+	if err != nil {
+		return nil, err
+	}
+	// gh repo list has no --hostname flag, so the host is given in the environment
+	if host != "" {
+		env = append(env, "GH_HOST="+host)
+	}
+	r.usage.record(org)
+	s, err := execInstance.ExecuteAndCaptureWithEnv(ctx, output, ".", env, "gh", "repo", "list", org, "--limit", fmt.Sprint(orgRepoLimit), "--json", "nameWithOwner,isArchived,isFork,primaryLanguage,repositoryTopics")
+	if err != nil {
+		return nil, err
+	}
+
+	var repos []OrgRepo
+	if err := json.Unmarshal([]byte(s), &repos); err != nil {
+		return nil, fmt.Errorf("unable to unmarshall the repo list output: %w", err)
+	}
+	return repos, nil
+}
+
+// loadCredentials returns the credentials configured by the user, reading them the first time they are needed
+func (r *RealGitHub) loadCredentials() (*Credentials, error) {
+	r.credentialsOnce.Do(func() {
+		if r.credentials == nil {
+			r.credentials, r.credentialsErr = ReadCredentials(CredentialsFile())
+		}
+	})
+	return r.credentials, r.credentialsErr
+}
+
+// repoEnv returns the environment that gives gh the credentials configured for a repo, given as org/repo or
+// host/org/repo
+func (r *RealGitHub) repoEnv(ctx context.Context, output io.Writer, fullRepoName string) ([]string, error) {
+	return r.orgEnv(ctx, output, hostOfRepo(fullRepoName), orgOfRepo(fullRepoName))
+}
+
+// orgEnv returns the environment that gives gh the credentials configured for an org on a host
+func (r *RealGitHub) orgEnv(ctx context.Context, output io.Writer, host string, org string) ([]string, error) {
+	credentials, err := r.loadCredentials()
+	if err != nil {
+		return nil, err
+	}
+	return credentials.env(ctx, output, host, org)
+}
+
+// workingCopyEnv returns the environment that gives gh the credentials configured for the repo of a working copy.
+// Its host is only looked up, from its origin remote, if some credentials are for particular hosts.
+func (r *RealGitHub) workingCopyEnv(ctx context.Context, output io.Writer, workingDir string) ([]string, error) {
+	credentials, err := r.loadCredentials()
+	if err != nil || len(credentials.Credentials) == 0 {
+		return nil, err
+	}
+
+	host := defaultHost
+	if credentials.needHosts() {
+		remote, err := execInstance.ExecuteAndCapture(ctx, output, workingDir, "git", "remote", "get-url", "origin")
+		if err != nil {
+			return nil, fmt.Errorf("unable to find the host of %s: %w", workingDir, err)
+		}
+		host = hostOfRemote(remote)
+	}
+	return credentials.env(ctx, output, host, orgOfWorkingCopy(workingDir))
+}
+
 func NewRealGitHub() *RealGitHub {
 	return &RealGitHub{}
 }
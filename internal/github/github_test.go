@@ -16,6 +16,7 @@
 package github
 
 import (
+	"context"
 	"errors"
 	"strings"
 	"testing"
@@ -73,19 +74,47 @@ func TestItReturnsNilErrorOnSuccessfulClone(t *testing.T) {
 	})
 }
 
+func TestItPassesGitArgsToClones(t *testing.T) {
+	fakeExecutor := executor.NewAlwaysSucceedsFakeExecutor()
+	execInstance = fakeExecutor
+
+	err := NewRealGitHub().Clone(context.Background(), &strings.Builder{}, "work/org", "org/repo1", "--filter=blob:none", "--sparse")
+	assert.NoError(t, err)
+	err = NewRealGitHub().ForkAndClone(context.Background(), &strings.Builder{}, "work/org", "org/repo1", "--sparse")
+	assert.NoError(t, err)
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org", "gh", "repo", "clone", "org/repo1", "--", "--filter=blob:none", "--sparse"},
+		{"work/org", "gh", "repo", "fork", "--clone=true", "org/repo1", "--", "--sparse"},
+	})
+}
+
 func TestItReturnsErrorOnFailedCreatePr(t *testing.T) {
 	fakeExecutor := executor.NewAlwaysFailsFakeExecutor()
 	execInstance = fakeExecutor
 
-	didCreatePr, _, err := runCreatePrAndCaptureOutput()
+	createdPr, _, err := runCreatePrAndCaptureOutput()
 	assert.Error(t, err)
-	assert.False(t, didCreatePr)
+	assert.Nil(t, createdPr)
 
 	fakeExecutor.AssertCalledWith(t, [][]string{
 		{"work/org/repo1", "gh", "pr", "create", "--title", "some title", "--body", "some body", "--repo", "org/repo1"},
 	})
 }
 
+func TestItReturnsPrExistsErrorWhenTheBranchAlreadyHasAPr(t *testing.T) {
+	execInstance = executor.NewFakeExecutor(nil, func(workingDir string, name string, args ...string) (string, error) {
+		return "a pull request for branch \"my-campaign\" into branch \"main\" already exists:\nhttps://github.com/org/repo1/pull/12\n", errors.New("exit status 1")
+	})
+
+	createdPr, _, err := runCreatePrAndCaptureOutput()
+	assert.Nil(t, createdPr)
+	var existsErr *PrExistsError
+	assert.True(t, errors.As(err, &existsErr))
+	assert.Equal(t, "https://github.com/org/repo1/pull/12", existsErr.Url)
+	assert.Equal(t, "a PR already exists for the branch in org/repo1: https://github.com/org/repo1/pull/12", err.Error())
+}
+
 func TestItReturnsFalseAndNilErrorOnNoOpCreatePr(t *testing.T) {
 	fakeExecutor := executor.NewFakeExecutor(func(workingDir string, name string, args ...string) error {
 		return nil
@@ -94,9 +123,9 @@ func TestItReturnsFalseAndNilErrorOnNoOpCreatePr(t *testing.T) {
 	})
 	execInstance = fakeExecutor
 
-	didCreatePr, _, err := runCreatePrAndCaptureOutput()
+	createdPr, _, err := runCreatePrAndCaptureOutput()
 	assert.NoError(t, err)
-	assert.False(t, didCreatePr)
+	assert.Nil(t, createdPr)
 
 	fakeExecutor.AssertCalledWith(t, [][]string{
 		{"work/org/repo1", "gh", "pr", "create", "--title", "some title", "--body", "some body", "--repo", "org/repo1"},
@@ -107,22 +136,371 @@ func TestItSuccessfulCreatesADraftPr(t *testing.T) {
 	fakeExecutor := executor.NewAlwaysSucceedsFakeExecutor()
 	execInstance = fakeExecutor
 
-	didCreatePr, _, err := runCreateDraftPrAndCaptureOutput()
+	createdPr, _, err := runCreateDraftPrAndCaptureOutput()
 	assert.NoError(t, err)
-	assert.True(t, didCreatePr)
+	assert.NotNil(t, createdPr)
 
 	fakeExecutor.AssertCalledWith(t, [][]string{
 		{"work/org/repo1", "gh", "pr", "create", "--title", "some title", "--body", "some body", "--repo", "org/repo1", "--draft"},
 	})
 }
 
+func TestItCreatesAPrWithLabels(t *testing.T) {
+	fakeExecutor := executor.NewAlwaysSucceedsFakeExecutor()
+	execInstance = fakeExecutor
+
+	sb := strings.Builder{}
+	createdPr, err := NewRealGitHub().CreatePullRequest(context.Background(), &sb, "work/org/repo1", PullRequest{
+		Title:        "some title",
+		Body:         "some body",
+		UpstreamRepo: "org/repo1",
+		Labels:       []string{"ci", "docker"},
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, createdPr)
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", "gh", "pr", "create", "--title", "some title", "--body", "some body", "--repo", "org/repo1", "--label", "ci", "--label", "docker"},
+	})
+}
+
+func TestItCreatesAPrAgainstABaseBranch(t *testing.T) {
+	fakeExecutor := executor.NewAlwaysSucceedsFakeExecutor()
+	execInstance = fakeExecutor
+
+	_, err := NewRealGitHub().CreatePullRequest(context.Background(), &strings.Builder{}, "work/org/repo1", PullRequest{
+		Title:        "some title",
+		Body:         "some body",
+		UpstreamRepo: "org/repo1",
+		BaseBranch:   "develop",
+	})
+	assert.NoError(t, err)
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", "gh", "pr", "create", "--title", "some title", "--body", "some body", "--repo", "org/repo1", "--base", "develop"},
+	})
+}
+
+func TestItGetsThePrForABranch(t *testing.T) {
+	fakeExecutor := executor.NewFakeExecutor(func(s string, s2 string, s3 ...string) error {
+		return nil
+	}, func(s string, s2 string, s3 ...string) (string, error) {
+		return `{"number": 12, "state": "CLOSED", "headRefName": "my-campaign", "url": "https://github.com/org/repo1/pull/12"}`, nil
+	})
+	execInstance = fakeExecutor
+
+	pr, err := NewRealGitHub().GetPRForBranch(context.Background(), &strings.Builder{}, "work/org/repo1", "my-campaign")
+	assert.NoError(t, err)
+	assert.Equal(t, 12, pr.Number)
+	assert.Equal(t, "CLOSED", pr.State)
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", "gh", "pr", "view", "my-campaign", "--json", "baseRefName,body,closed,createdAt,headRefName,latestReviews,mergeStateStatus,mergeable,mergedAt,number,reactionGroups,reviewDecision,reviewRequests,state,statusCheckRollup,title,url"},
+	})
+}
+
+func TestItReturnsTheUrlAndNumberOfACreatedPr(t *testing.T) {
+	execInstance = executor.NewFakeExecutor(func(s string, s2 string, s3 ...string) error {
+		return nil
+	}, func(s string, s2 string, s3 ...string) (string, error) {
+		return "\nCreating pull request for my-campaign into main in org/repo1\n\nhttps://github.com/org/repo1/pull/42\n", nil
+	})
+
+	createdPr, _, err := runCreatePrAndCaptureOutput()
+	assert.NoError(t, err)
+	assert.Equal(t, &PrRef{Number: 42, Url: "https://github.com/org/repo1/pull/42"}, createdPr)
+}
+
+func TestItLooksUpARememberedPrByItsNumber(t *testing.T) {
+	fakeExecutor := executor.NewFakeExecutor(func(s string, s2 string, s3 ...string) error {
+		return nil
+	}, func(s string, s2 string, s3 ...string) (string, error) {
+		return `{"number": 12, "state": "OPEN", "headRefName": "my-campaign", "url": "https://github.com/org/repo1/pull/12"}`, nil
+	})
+	execInstance = fakeExecutor
+
+	gh := NewRealGitHub()
+	gh.RememberPR("work/org/repo1", "my-campaign", 12)
+	pr, err := gh.GetPR(context.Background(), &strings.Builder{}, "work/org/repo1", "my-campaign")
+	assert.NoError(t, err)
+	assert.Equal(t, 12, pr.Number)
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", "gh", "pr", "view", "12", "--json", "baseRefName,body,closed,createdAt,headRefName,latestReviews,mergeStateStatus,mergeable,mergedAt,number,reactionGroups,reviewDecision,reviewRequests,state,statusCheckRollup,title,url"},
+	})
+}
+
+func TestItReturnsNoPRFoundErrorForABranchWithoutAPr(t *testing.T) {
+	fakeExecutor := executor.NewFakeExecutor(func(s string, s2 string, s3 ...string) error {
+		return nil
+	}, func(s string, s2 string, s3 ...string) (string, error) {
+		return "no pull requests found for branch \"my-campaign\"", errors.New("exit status 1")
+	})
+	execInstance = fakeExecutor
+
+	_, err := NewRealGitHub().GetPRForBranch(context.Background(), &strings.Builder{}, "work/org/repo1", "my-campaign")
+	assert.IsType(t, &NoPRFoundError{}, err)
+}
+
+func TestItReturnsRepoGoneErrorForADeletedRepo(t *testing.T) {
+	fakeExecutor := executor.NewFakeExecutor(func(s string, s2 string, s3 ...string) error {
+		return nil
+	}, func(s string, s2 string, s3 ...string) (string, error) {
+		return "GraphQL: Could not resolve to a Repository with the name 'org/repo1'. (repository)", errors.New("exit status 1")
+	})
+	execInstance = fakeExecutor
+
+	_, err := NewRealGitHub().GetPR(context.Background(), &strings.Builder{}, "work/org/repo1", "my-campaign")
+	assert.EqualError(t, err, "org/repo1 has been deleted or is no longer accessible")
+	assert.True(t, IsRepoGone(err))
+
+	_, err = NewRealGitHub().IsPushable(context.Background(), &strings.Builder{}, "org/repo1")
+	assert.True(t, IsRepoGone(err))
+}
+
+func TestItEnablesAutoMergeForTheBranchPr(t *testing.T) {
+	fakeExecutor := executor.NewFakeExecutor(func(s string, s2 string, s3 ...string) error {
+		return nil
+	}, func(s string, s2 string, s3 ...string) (string, error) {
+		return `{"currentBranch": {"number": 7, "headRefName": "my-campaign"}}`, nil
+	})
+	execInstance = fakeExecutor
+
+	err := NewRealGitHub().EnableAutoMerge(context.Background(), &strings.Builder{}, "work/org/repo1", "my-campaign", "squash")
+	assert.NoError(t, err)
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", "gh", "pr", "status", "--json", "baseRefName,body,closed,createdAt,headRefName,latestReviews,mergeStateStatus,mergeable,mergedAt,number,reactionGroups,reviewDecision,reviewRequests,state,statusCheckRollup,title,url"},
+		{"work/org/repo1", "gh", "repo", "view", "--json", "isArchived", "--jq", ".isArchived"},
+		{"work/org/repo1", "gh", "pr", "merge", "7", "--auto", "--squash"},
+	})
+}
+
+func TestItClosesTheOpenPrForTheBranch(t *testing.T) {
+	fakeExecutor := executor.NewFakeExecutor(func(s string, s2 string, s3 ...string) error {
+		return nil
+	}, func(s string, s2 string, s3 ...string) (string, error) {
+		if s3[0] == "repo" {
+			return "false\n", nil
+		}
+		return `{"currentBranch": {"number": 7, "headRefName": "my-campaign", "state": "OPEN"}}`, nil
+	})
+	execInstance = fakeExecutor
+
+	err := NewRealGitHub().ClosePullRequest(context.Background(), &strings.Builder{}, "work/org/repo1", "my-campaign")
+	assert.NoError(t, err)
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", "gh", "pr", "status", "--json", "baseRefName,body,closed,createdAt,headRefName,latestReviews,mergeStateStatus,mergeable,mergedAt,number,reactionGroups,reviewDecision,reviewRequests,state,statusCheckRollup,title,url"},
+		{"work/org/repo1", "gh", "repo", "view", "--json", "isArchived", "--jq", ".isArchived"},
+		{"work/org/repo1", "gh", "pr", "close", "7"},
+	})
+}
+
+func TestItDoesNotCloseAMergedPr(t *testing.T) {
+	fakeExecutor := executor.NewFakeExecutor(func(s string, s2 string, s3 ...string) error {
+		return nil
+	}, func(s string, s2 string, s3 ...string) (string, error) {
+		return `{"currentBranch": {"number": 7, "headRefName": "my-campaign", "state": "MERGED"}}`, nil
+	})
+	execInstance = fakeExecutor
+
+	err := NewRealGitHub().ClosePullRequest(context.Background(), &strings.Builder{}, "work/org/repo1", "my-campaign")
+	var notOpenErr *PrNotOpenError
+	assert.ErrorAs(t, err, &notOpenErr)
+	assert.Equal(t, PrMerged, notOpenErr.State)
+
+	// merged PRs stay merged, so there is no need to ask whether the repo is archived
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", "gh", "pr", "status", "--json", "baseRefName,body,closed,createdAt,headRefName,latestReviews,mergeStateStatus,mergeable,mergedAt,number,reactionGroups,reviewDecision,reviewRequests,state,statusCheckRollup,title,url"},
+	})
+}
+
+func TestItDoesNotChangePrsInArchivedRepos(t *testing.T) {
+	fakeExecutor := executor.NewFakeExecutor(func(s string, s2 string, s3 ...string) error {
+		return nil
+	}, func(s string, s2 string, s3 ...string) (string, error) {
+		if s3[0] == "repo" {
+			return "true\n", nil
+		}
+		return `{"currentBranch": {"number": 7, "headRefName": "my-campaign", "state": "CLOSED"}}`, nil
+	})
+	execInstance = fakeExecutor
+
+	var archivedErr *RepoArchivedError
+	err := NewRealGitHub().ReopenPullRequest(context.Background(), &strings.Builder{}, "work/org/repo1", "my-campaign")
+	assert.ErrorAs(t, err, &archivedErr)
+	assert.Equal(t, "org/repo1", archivedErr.Repo)
+}
+
+func TestItDoesNotUpdateTheBranchesOrLabelsOfClosedPrs(t *testing.T) {
+	fakeExecutor := executor.NewFakeExecutor(func(s string, s2 string, s3 ...string) error {
+		return nil
+	}, func(s string, s2 string, s3 ...string) (string, error) {
+		if s3[0] == "repo" {
+			return "false\n", nil
+		}
+		return `{"currentBranch": {"number": 7, "headRefName": "my-campaign", "state": "CLOSED"}}`, nil
+	})
+	execInstance = fakeExecutor
+
+	var notOpenErr *PrNotOpenError
+	err := NewRealGitHub().UpdatePRBranch(context.Background(), &strings.Builder{}, "work/org/repo1", "my-campaign", false)
+	assert.ErrorAs(t, err, &notOpenErr)
+	err = NewRealGitHub().AddPRLabels(context.Background(), &strings.Builder{}, "work/org/repo1", "my-campaign", []string{"deadline-friday"})
+	assert.ErrorAs(t, err, &notOpenErr)
+	assert.Equal(t, PrClosed, notOpenErr.State)
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", "gh", "pr", "status", "--json", "baseRefName,body,closed,createdAt,headRefName,latestReviews,mergeStateStatus,mergeable,mergedAt,number,reactionGroups,reviewDecision,reviewRequests,state,statusCheckRollup,title,url"},
+		{"work/org/repo1", "gh", "repo", "view", "--json", "isArchived", "--jq", ".isArchived"},
+		{"work/org/repo1", "gh", "pr", "status", "--json", "baseRefName,body,closed,createdAt,headRefName,latestReviews,mergeStateStatus,mergeable,mergedAt,number,reactionGroups,reviewDecision,reviewRequests,state,statusCheckRollup,title,url"},
+		{"work/org/repo1", "gh", "repo", "view", "--json", "isArchived", "--jq", ".isArchived"},
+	})
+}
+
+func TestItClassifiesPrs(t *testing.T) {
+	assert.Equal(t, PrOpen, ClassifyPR(&PrStatus{State: "OPEN"}, false))
+	assert.Equal(t, PrClosed, ClassifyPR(&PrStatus{State: "CLOSED"}, false))
+	assert.Equal(t, PrMerged, ClassifyPR(&PrStatus{State: "MERGED"}, false))
+	assert.Equal(t, PrMerged, ClassifyPR(&PrStatus{State: "MERGED"}, true))
+	assert.Equal(t, PrRepoArchived, ClassifyPR(&PrStatus{State: "OPEN"}, true))
+	assert.Equal(t, PrRepoArchived, ClassifyPR(&PrStatus{State: "CLOSED"}, true))
+}
+
+func TestItRebasesThePrBranchOntoItsBase(t *testing.T) {
+	fakeExecutor := executor.NewFakeExecutor(func(s string, s2 string, s3 ...string) error {
+		return nil
+	}, func(s string, s2 string, s3 ...string) (string, error) {
+		return `{"currentBranch": {"number": 7, "headRefName": "my-campaign"}}`, nil
+	})
+	execInstance = fakeExecutor
+
+	err := NewRealGitHub().UpdatePRBranch(context.Background(), &strings.Builder{}, "work/org/repo1", "my-campaign", true)
+	assert.NoError(t, err)
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", "gh", "pr", "status", "--json", "baseRefName,body,closed,createdAt,headRefName,latestReviews,mergeStateStatus,mergeable,mergedAt,number,reactionGroups,reviewDecision,reviewRequests,state,statusCheckRollup,title,url"},
+		{"work/org/repo1", "gh", "repo", "view", "--json", "isArchived", "--jq", ".isArchived"},
+		{"work/org/repo1", "gh", "pr", "update-branch", "7", "--rebase"},
+	})
+}
+
+func TestItFindsLinearHistoryRequiredByARuleset(t *testing.T) {
+	fakeExecutor := executor.NewFakeExecutor(nil, func(s string, s2 string, s3 ...string) (string, error) {
+		if s3[0] == "repo" {
+			return "main\n", nil
+		}
+		return "pull_request\nrequired_linear_history\n", nil
+	})
+	execInstance = fakeExecutor
+
+	linear, err := NewRealGitHub().RequiresLinearHistory(context.Background(), &strings.Builder{}, "work/org/repo1", "")
+	assert.NoError(t, err)
+	assert.True(t, linear)
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", "gh", "repo", "view", "--json", "defaultBranchRef", "--jq", ".defaultBranchRef.name"},
+		{"work/org/repo1", "gh", "api", "repos/{owner}/{repo}/rules/branches/main", "--jq", ".[].type"},
+	})
+}
+
+func TestItFindsLinearHistoryRequiredByBranchProtection(t *testing.T) {
+	fakeExecutor := executor.NewFakeExecutor(nil, func(s string, s2 string, s3 ...string) (string, error) {
+		if strings.HasSuffix(s3[1], "/protection") {
+			return "true\n", nil
+		}
+		return "pull_request\n", nil
+	})
+	execInstance = fakeExecutor
+
+	linear, err := NewRealGitHub().RequiresLinearHistory(context.Background(), &strings.Builder{}, "work/org/repo1", "develop")
+	assert.NoError(t, err)
+	assert.True(t, linear)
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", "gh", "api", "repos/{owner}/{repo}/rules/branches/develop", "--jq", ".[].type"},
+		{"work/org/repo1", "gh", "api", "repos/{owner}/{repo}/branches/develop/protection", "--jq", ".required_linear_history.enabled"},
+	})
+}
+
+func TestItAssumesNoLinearHistoryWhenBranchProtectionCannotBeRead(t *testing.T) {
+	execInstance = executor.NewFakeExecutor(nil, func(s string, s2 string, s3 ...string) (string, error) {
+		if strings.HasSuffix(s3[1], "/protection") {
+			return "gh: Not Found (HTTP 404)", errors.New("exit status 1")
+		}
+		return "", nil
+	})
+
+	linear, err := NewRealGitHub().RequiresLinearHistory(context.Background(), &strings.Builder{}, "work/org/repo1", "main")
+	assert.NoError(t, err)
+	assert.False(t, linear)
+}
+
+func TestItCombinesRulesetsWithClassicBranchProtection(t *testing.T) {
+	fakeExecutor := executor.NewFakeExecutor(nil, func(s string, s2 string, s3 ...string) (string, error) {
+		if strings.HasSuffix(s3[1], "/protection") {
+			return `{"required_pull_request_reviews":{"required_approving_review_count":1,"require_code_owner_reviews":true},"required_status_checks":{"contexts":["build","ci/jenkins"]}}`, nil
+		}
+		return `[{"type":"pull_request","parameters":{"required_approving_review_count":2}},{"type":"required_status_checks","parameters":{"required_status_checks":[{"context":"build"}]}}]`, nil
+	})
+	execInstance = fakeExecutor
+
+	protection, err := NewRealGitHub().BranchProtection(context.Background(), &strings.Builder{}, "work/org/repo1", "main")
+	assert.NoError(t, err)
+	assert.Equal(t, &BranchProtection{RequiredApprovingReviews: 2, RequiresCodeOwnerReviews: true, RequiredChecks: []string{"build", "ci/jenkins"}}, protection)
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", "gh", "api", "repos/{owner}/{repo}/rules/branches/main"},
+		{"work/org/repo1", "gh", "api", "repos/{owner}/{repo}/branches/main/protection"},
+	})
+}
+
+func TestItReportsBranchProtectionAsPartialWhenClassicProtectionCannotBeRead(t *testing.T) {
+	execInstance = executor.NewFakeExecutor(nil, func(s string, s2 string, s3 ...string) (string, error) {
+		if strings.HasSuffix(s3[1], "/protection") {
+			return "gh: Not Found (HTTP 404)", errors.New("exit status 1")
+		}
+		return "[]", nil
+	})
+
+	protection, err := NewRealGitHub().BranchProtection(context.Background(), &strings.Builder{}, "work/org/repo1", "main")
+	assert.NoError(t, err)
+	assert.Equal(t, &BranchProtection{Partial: true}, protection)
+
+	execInstance = executor.NewFakeExecutor(nil, func(s string, s2 string, s3 ...string) (string, error) {
+		if strings.HasSuffix(s3[1], "/protection") {
+			return `{"message":"Branch not protected"}` + "\ngh: Branch not protected (HTTP 404)", errors.New("exit status 1")
+		}
+		return "[]", nil
+	})
+
+	protection, err = NewRealGitHub().BranchProtection(context.Background(), &strings.Builder{}, "work/org/repo1", "main")
+	assert.NoError(t, err)
+	assert.Equal(t, &BranchProtection{}, protection)
+}
+
+func TestItOnlyChangesTheMergeStrategyOfMergeCommits(t *testing.T) {
+	fakeGitHub := NewAlwaysSucceedsFakeGitHub().WithLinearHistory("work/org/repo1")
+
+	for strategy, expected := range map[string]string{"merge": "squash", "squash": "squash", "rebase": "rebase"} {
+		actual, err := MergeStrategyFor(context.Background(), fakeGitHub, &strings.Builder{}, "work/org/repo1", "", strategy)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, actual)
+	}
+
+	actual, err := MergeStrategyFor(context.Background(), fakeGitHub, &strings.Builder{}, "work/org/repo2", "", "merge")
+	assert.NoError(t, err)
+	assert.Equal(t, "merge", actual)
+}
+
 func TestItReturnsTrueAndNilErrorOnSuccessfulCreatePr(t *testing.T) {
 	fakeExecutor := executor.NewAlwaysSucceedsFakeExecutor()
 	execInstance = fakeExecutor
 
-	didCreatePr, _, err := runCreatePrAndCaptureOutput()
+	createdPr, _, err := runCreatePrAndCaptureOutput()
 	assert.NoError(t, err)
-	assert.True(t, didCreatePr)
+	assert.NotNil(t, createdPr)
 
 	fakeExecutor.AssertCalledWith(t, [][]string{
 		{"work/org/repo1", "gh", "pr", "create", "--title", "some title", "--body", "some body", "--repo", "org/repo1"},
@@ -177,51 +555,96 @@ func TestItReturnsNilErrorOnSuccessfulUpdatePrDescription(t *testing.T) {
 	})
 }
 
+func TestItListsOpenPRsWithCampaignAndPaths(t *testing.T) {
+	fakeExecutor := executor.NewFakeExecutor(nil, func(workingDir string, name string, args ...string) (string, error) {
+		return `[{"number":7,"url":"https://github.com/org/repo1/pull/7","body":"body\n\n<!-- turbolift:campaign=other -->","headRefName":"other","files":[{"path":"Dockerfile"}]}]`, nil
+	})
+	execInstance = fakeExecutor
+
+	prs, err := NewRealGitHub().ListOpenPRs(context.Background(), &strings.Builder{}, "work/org/repo1", "org/repo1")
+	assert.NoError(t, err)
+	assert.Len(t, prs, 1)
+	assert.Equal(t, "other", prs[0].Campaign())
+	assert.Equal(t, []string{"Dockerfile"}, prs[0].Paths())
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", "gh", "pr", "list", "--repo", "org/repo1", "--state", "open", "--limit", "100", "--json", "number,url,body,headRefName,files"},
+	})
+}
+
+func TestItListsTheReposOfAnOrg(t *testing.T) {
+	fakeExecutor := executor.NewFakeExecutor(nil, func(workingDir string, name string, args ...string) (string, error) {
+		return `[{"nameWithOwner":"acme/api","isArchived":false,"isFork":false,"primaryLanguage":{"name":"Go"},"repositoryTopics":[{"name":"payments"}]},{"nameWithOwner":"acme/old","isArchived":true,"isFork":false,"primaryLanguage":null,"repositoryTopics":null}]`, nil
+	})
+	execInstance = fakeExecutor
+
+	repos, err := NewRealGitHub().ListOrgRepos(context.Background(), &strings.Builder{}, "", "acme")
+	assert.NoError(t, err)
+	assert.Len(t, repos, 2)
+	assert.Equal(t, "acme/api", repos[0].NameWithOwner)
+	assert.Equal(t, "Go", repos[0].PrimaryLanguage.Name)
+	assert.Equal(t, []string{"payments"}, repos[0].Topics())
+	assert.True(t, repos[1].IsArchived)
+	assert.Equal(t, []string{}, repos[1].Topics())
+
+	_, err = NewRealGitHub().ListOrgRepos(context.Background(), &strings.Builder{}, "github.example.com", "acme")
+	assert.NoError(t, err)
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{".", "gh", "repo", "list", "acme", "--limit", "10000", "--json", "nameWithOwner,isArchived,isFork,primaryLanguage,repositoryTopics"},
+		{".", "gh", "repo", "list", "acme", "--limit", "10000", "--json", "nameWithOwner,isArchived,isFork,primaryLanguage,repositoryTopics"},
+	})
+	fakeExecutor.AssertEnvCalledWith(t, [][]string{
+		nil,
+		{"GH_HOST=github.example.com"},
+	})
+}
+
 func runForkAndCloneAndCaptureOutput() (string, error) {
 	sb := strings.Builder{}
-	err := NewRealGitHub().ForkAndClone(&sb, "work/org", "org/repo1")
+	err := NewRealGitHub().ForkAndClone(context.Background(), &sb, "work/org", "org/repo1")
 
 	return sb.String(), err
 }
 
 func runCloneAndCaptureOutput() (string, error) {
 	sb := strings.Builder{}
-	err := NewRealGitHub().Clone(&sb, "work/org", "org/repo1")
+	err := NewRealGitHub().Clone(context.Background(), &sb, "work/org", "org/repo1")
 
 	return sb.String(), err
 }
 
-func runCreatePrAndCaptureOutput() (bool, string, error) {
+func runCreatePrAndCaptureOutput() (*PrRef, string, error) {
 	sb := strings.Builder{}
-	didCreatePr, err := NewRealGitHub().CreatePullRequest(&sb, "work/org/repo1", PullRequest{
+	createdPr, err := NewRealGitHub().CreatePullRequest(context.Background(), &sb, "work/org/repo1", PullRequest{
 		Title:        "some title",
 		Body:         "some body",
 		UpstreamRepo: "org/repo1",
 	})
 
-	return didCreatePr, sb.String(), err
+	return createdPr, sb.String(), err
 }
 
-func runCreateDraftPrAndCaptureOutput() (bool, string, error) {
+func runCreateDraftPrAndCaptureOutput() (*PrRef, string, error) {
 	sb := strings.Builder{}
-	didCreatePr, err := NewRealGitHub().CreatePullRequest(&sb, "work/org/repo1", PullRequest{
+	createdPr, err := NewRealGitHub().CreatePullRequest(context.Background(), &sb, "work/org/repo1", PullRequest{
 		Title:        "some title",
 		Body:         "some body",
 		UpstreamRepo: "org/repo1",
 		IsDraft:      true,
 	})
 
-	return didCreatePr, sb.String(), err
+	return createdPr, sb.String(), err
 }
 
 func runGetDefaultBranchNameAndCaptureOutput() (string, string, error) {
 	sb := strings.Builder{}
-	defaultBranchName, err := NewRealGitHub().GetDefaultBranchName(&sb, "work/org1/repo1", "org1/repo1")
+	defaultBranchName, err := NewRealGitHub().GetDefaultBranchName(context.Background(), &sb, "work/org1/repo1", "org1/repo1")
 	return defaultBranchName, sb.String(), err
 }
 
 func runUpdatePrDescriptionAndCaptureOutput() (string, error) {
 	sb := strings.Builder{}
-	err := NewRealGitHub().UpdatePRDescription(&sb, "work/org/repo1", "new title", "new body")
+	err := NewRealGitHub().UpdatePRDescription(context.Background(), &sb, "work/org/repo1", "new title", "new body")
 	return sb.String(), err
 }
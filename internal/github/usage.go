@@ -0,0 +1,176 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skyscanner/turbolift/internal/logging"
+)
+
+// ApiUsage tallies the gh commands run against each org, as a measure of the GitHub API calls a run has used.
+// Some gh commands make more than one call, so the tally is a lower bound.
+type ApiUsage struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// OrgUsage is the number of gh commands run against an org
+type OrgUsage struct {
+	Org   string
+	Calls int
+}
+
+func (u *ApiUsage) record(org string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.counts == nil {
+		u.counts = map[string]int{}
+	}
+	u.counts[org]++
+}
+
+// ByOrg lists the usage of each org, busiest first
+func (u *ApiUsage) ByOrg() []OrgUsage {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	usage := []OrgUsage{}
+	for org, calls := range u.counts {
+		usage = append(usage, OrgUsage{Org: org, Calls: calls})
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].Calls != usage[j].Calls {
+			return usage[i].Calls > usage[j].Calls
+		}
+		return usage[i].Org < usage[j].Org
+	})
+	return usage
+}
+
+func (u *ApiUsage) Total() int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	total := 0
+	for _, calls := range u.counts {
+		total += calls
+	}
+	return total
+}
+
+// orgOfRepo finds the org of a repo given as org/repo or host/org/repo
+func orgOfRepo(fullRepoName string) string {
+	parts := strings.Split(fullRepoName, "/")
+	if len(parts) < 2 {
+		return fullRepoName
+	}
+	return parts[len(parts)-2]
+}
+
+// orgOfWorkingCopy finds the org of a working copy, which is cloned into work/org/repo
+func orgOfWorkingCopy(workingDir string) string {
+	return filepath.Base(filepath.Dir(workingDir))
+}
+
+// repoOfWorkingCopy finds the org/repo name of a working copy from its path, work/org/repo
+func repoOfWorkingCopy(workingDir string) string {
+	return orgOfWorkingCopy(workingDir) + "/" + filepath.Base(workingDir)
+}
+
+// RateLimit is what remains of the user's GitHub API rate limits on a host
+type RateLimit struct {
+	Core    RateLimitResource `json:"core"`
+	GraphQL RateLimitResource `json:"graphql"`
+}
+
+type RateLimitResource struct {
+	Limit     int   `json:"limit"`
+	Remaining int   `json:"remaining"`
+	Reset     int64 `json:"reset"`
+}
+
+// ResetTime is when the limit will next be reset
+func (r RateLimitResource) ResetTime() time.Time {
+	return time.Unix(r.Reset, 0)
+}
+
+func (r RateLimitResource) String() string {
+	return fmt.Sprintf("%d of %d", r.Remaining, r.Limit)
+}
+
+// RateLimit asks a host for the user's remaining rate limits, which does not itself count against them
+func (r *RealGitHub) RateLimit(ctx context.Context, output io.Writer, host string) (*RateLimit, error) {
+	credentials, err := r.loadCredentials()
+	if err != nil {
+		return nil, err
+	}
+	env, err := credentials.env(ctx, output, host, "")
+	if err != nil {
+		return nil, err
+	}
+	s, err := execInstance.ExecuteAndCaptureWithEnv(ctx, output, ".", env, "gh", "api", "rate_limit", "--hostname", host)
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Resources RateLimit `json:"resources"`
+	}
+	if err := json.Unmarshal([]byte(s), &response); err != nil {
+		return nil, fmt.Errorf("unable to unmarshall the rate limit output: %w", err)
+	}
+	return &response.Resources, nil
+}
+
+// LogApiUsage reports the gh commands a run has used against each org and what remains of the rate limits on each
+// host, to help schedule large campaigns without tripping GitHub's limits. Nothing is reported if gh was not used.
+func LogApiUsage(ctx context.Context, gh GitHub, logger *logging.Logger, hosts []string) {
+	usage := gh.Usage()
+	if usage.Total() == 0 {
+		return
+	}
+
+	orgs := []string{}
+	for _, org := range usage.ByOrg() {
+		orgs = append(orgs, fmt.Sprintf("%s: %d", org.Org, org.Calls))
+	}
+	logger.Printf("GitHub API usage: %d gh calls (%s)", usage.Total(), strings.Join(orgs, ", "))
+
+	// don't hold up a run that has been cancelled
+	if ctx.Err() != nil {
+		return
+	}
+	for _, host := range hosts {
+		activity := logger.StartActivity("Checking GitHub rate limits on %s", host)
+		limit, err := gh.RateLimit(ctx, activity.Writer(), host)
+		if err != nil {
+			activity.EndWithWarningf("Unable to check the rate limits: %s", err)
+			continue
+		}
+		activity.EndWithSuccess()
+		logger.Printf("%s: %s REST and %s GraphQL calls remaining, resetting at %s", host, limit.Core, limit.GraphQL, limit.Core.ResetTime().Format("15:04"))
+	}
+}
@@ -0,0 +1,44 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package github
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApiUsageListsTheBusiestOrgsFirst(t *testing.T) {
+	usage := ApiUsage{}
+	usage.record(orgOfRepo("org1/repo1"))
+	usage.record(orgOfRepo("mygitserver.com/org2/repo1"))
+	usage.record(orgOfWorkingCopy("work/org2/repo2"))
+	usage.record(orgOfRepo("org3/repo1"))
+
+	assert.Equal(t, []OrgUsage{
+		{Org: "org2", Calls: 2},
+		{Org: "org1", Calls: 1},
+		{Org: "org3", Calls: 1},
+	}, usage.ByOrg())
+	assert.Equal(t, 4, usage.Total())
+}
+
+func TestEmptyApiUsage(t *testing.T) {
+	usage := ApiUsage{}
+
+	assert.Empty(t, usage.ByOrg())
+	assert.Equal(t, 0, usage.Total())
+}
@@ -17,7 +17,16 @@
 
 package github
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+)
 
 type ViewerPermission struct {
 	ViewerPermission string `json:"viewerPermission"`
@@ -40,3 +49,166 @@ func userHasPushPermission(viewerPermissionOutput string) (bool, error) {
 		return false, nil
 	}
 }
+
+// ChecksAwaitingDeploymentApproval is the checks status of a PR whose checks are held by a deployment environment's protection rules
+const ChecksAwaitingDeploymentApproval = "AWAITING_DEPLOYMENT_APPROVAL"
+
+// ChecksStatus reduces a PR's status check rollup to a single overall state:
+// FAILURE if any check has failed, AWAITING_DEPLOYMENT_APPROVAL if any check run is waiting for an environment
+// to be approved, PENDING if any is still running, otherwise SUCCESS
+func ChecksStatus(rollup []StatusCheckRollup) string {
+	failedCheck := false
+	waitingCheck := false
+	pendingCheck := false
+	for _, check := range rollup {
+		if strings.Contains(check.State, "FAILURE") || check.Conclusion == "FAILURE" {
+			failedCheck = true
+		} else if check.Status == "WAITING" {
+			waitingCheck = true
+		} else if strings.Contains(check.State, "PENDING") {
+			pendingCheck = true
+		}
+	}
+
+	if failedCheck {
+		return "FAILURE"
+	} else if waitingCheck {
+		return ChecksAwaitingDeploymentApproval
+	} else if pendingCheck {
+		return "PENDING"
+	}
+	return "SUCCESS"
+}
+
+// The states that ClassifyPR sorts PRs into
+const (
+	PrOpen   = "OPEN"
+	PrClosed = "CLOSED"
+	PrMerged = "MERGED"
+	// PrRepoArchived is the state of unmerged PRs in repos that have since been archived, which can no longer change
+	PrRepoArchived = "ARCHIVED"
+)
+
+// ClassifyPR sorts a PR into one of the states that commands act on. Merged PRs stay merged when their repo is
+// archived, but any other PR in an archived repo is left as it is for good.
+func ClassifyPR(pr *PrStatus, repoArchived bool) string {
+	switch {
+	case pr.State == PrMerged:
+		return PrMerged
+	case repoArchived:
+		return PrRepoArchived
+	case pr.State == PrClosed:
+		return PrClosed
+	}
+	return PrOpen
+}
+
+// MergeBlockers lists what keeps an open PR from ever merging, however long it is left, under its base branch's
+// protection: required checks that are not reported on the PR at all, and required reviews that nobody has been asked
+// for. Anything returned needs a person to step in.
+func MergeBlockers(pr *PrStatus, protection *BranchProtection) []string {
+	if pr.State != PrOpen || protection == nil {
+		return nil
+	}
+
+	var blockers []string
+	reported := map[string]bool{}
+	for _, check := range pr.StatusCheckRollup {
+		reported[check.Name] = true
+		reported[check.Context] = true
+	}
+	for _, check := range protection.RequiredChecks {
+		if !reported[check] {
+			blockers = append(blockers, fmt.Sprintf("required check %s is not reported on the PR", check))
+		}
+	}
+
+	if pr.ReviewDecision == "APPROVED" || len(pr.ReviewRequests) > 0 {
+		return blockers
+	}
+	if protection.RequiredApprovingReviews > 0 && len(pr.LatestReviews) == 0 {
+		blockers = append(blockers, fmt.Sprintf("approving reviews are required (%d), but nobody has been asked to review", protection.RequiredApprovingReviews))
+	} else if protection.RequiresCodeOwnerReviews {
+		blockers = append(blockers, "a code owner review is required, but no code owner has been asked to review")
+	}
+	return blockers
+}
+
+var campaignMarkerRegexp = regexp.MustCompile(`<!-- turbolift:campaign=(\S+) -->`)
+
+// WithCampaignMarker appends a hidden marker to a PR body identifying the campaign that generated it, so that
+// PRs from sibling campaigns can be recognised later. Bodies that already carry a marker are left unchanged.
+func WithCampaignMarker(body string, campaignName string) string {
+	if campaignMarkerRegexp.MatchString(body) {
+		return body
+	}
+	return fmt.Sprintf("%s\n\n<!-- turbolift:campaign=%s -->", body, campaignName)
+}
+
+// CampaignFromBody returns the name of the campaign that generated a PR, as recorded by WithCampaignMarker,
+// or an empty string if the PR was not generated by turbolift
+func CampaignFromBody(body string) string {
+	match := campaignMarkerRegexp.FindStringSubmatch(body)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+// PrRef identifies a PR that has been created. Its URL is empty if the forge did not report one.
+type PrRef struct {
+	Number int
+	Url    string
+}
+
+var prNumberRegexp = regexp.MustCompile(`/(?:pull|pull-requests)/(\d+)`)
+
+// PrNumberFromUrl returns the number of the PR at a GitHub or Bitbucket URL, or 0 if the URL is not a PR's
+func PrNumberFromUrl(url string) int {
+	match := prNumberRegexp.FindStringSubmatch(url)
+	if match == nil {
+		return 0
+	}
+	number, _ := strconv.Atoi(match[1])
+	return number
+}
+
+// KnownPRs holds the numbers of PRs that have already been recorded for working copies, so that they can be
+// looked up directly rather than found again from their branch
+type KnownPRs struct {
+	mu      sync.Mutex
+	numbers map[string]int
+}
+
+// RememberPR records the number of the PR from a branch of a working copy
+func (k *KnownPRs) RememberPR(workingDir string, branchName string, number int) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.numbers == nil {
+		k.numbers = map[string]int{}
+	}
+	k.numbers[workingDir+"@"+branchName] = number
+}
+
+// KnownPR returns the number of the PR remembered for a branch of a working copy, if there is one
+func (k *KnownPRs) KnownPR(workingDir string, branchName string) (int, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	number, ok := k.numbers[workingDir+"@"+branchName]
+	return number, ok
+}
+
+// RememberTrackedPRs has the forges look up the PRs recorded in the campaign state by their number, rather than
+// finding them from their branch
+func RememberTrackedPRs(forges ...GitHub) error {
+	state, err := campaign.ReadState()
+	if err != nil {
+		return err
+	}
+	for _, pr := range state.PullRequests {
+		for _, forge := range forges {
+			forge.RememberPR(pr.FullRepoPath(), pr.Branch, pr.Number)
+		}
+	}
+	return nil
+}
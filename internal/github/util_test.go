@@ -63,3 +63,67 @@ func TestuserHasPushPermissionReturnsErrorForInvalidJSON(t *testing.T) {
 		assert.Error(t, err)
 	}
 }
+
+func TestChecksStatus(t *testing.T) {
+	testCases := []struct {
+		TestName string
+		Input    []StatusCheckRollup
+		Expected string
+	}{
+		{"no checks", []StatusCheckRollup{}, "SUCCESS"},
+		{"all passing", []StatusCheckRollup{{State: "SUCCESS"}, {State: "SUCCESS"}}, "SUCCESS"},
+		{"one pending", []StatusCheckRollup{{State: "SUCCESS"}, {State: "PENDING"}}, "PENDING"},
+		{"failure wins over pending", []StatusCheckRollup{{State: "PENDING"}, {State: "FAILURE"}}, "FAILURE"},
+		{"failed check run", []StatusCheckRollup{{Status: "COMPLETED", Conclusion: "FAILURE"}}, "FAILURE"},
+		{"check run waiting for an environment", []StatusCheckRollup{{State: "PENDING"}, {Status: "WAITING"}}, "AWAITING_DEPLOYMENT_APPROVAL"},
+		{"failure wins over waiting", []StatusCheckRollup{{Status: "WAITING"}, {State: "FAILURE"}}, "FAILURE"},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.TestName, func(t *testing.T) {
+			assert.Equal(t, testCase.Expected, ChecksStatus(testCase.Input))
+		})
+	}
+}
+
+func TestMergeBlockers(t *testing.T) {
+	protection := &BranchProtection{RequiredApprovingReviews: 2, RequiredChecks: []string{"build", "ci/jenkins", "deploy-preview"}}
+	pr := &PrStatus{
+		State:             PrOpen,
+		ReviewDecision:    "REVIEW_REQUIRED",
+		StatusCheckRollup: []StatusCheckRollup{{Name: "build", State: "SUCCESS"}, {Context: "ci/jenkins", State: "PENDING"}},
+	}
+
+	assert.Equal(t, []string{
+		"required check deploy-preview is not reported on the PR",
+		"approving reviews are required (2), but nobody has been asked to review",
+	}, MergeBlockers(pr, protection))
+
+	pr.ReviewRequests = []ReviewRequest{{Slug: "org/team"}}
+	assert.Equal(t, []string{"required check deploy-preview is not reported on the PR"}, MergeBlockers(pr, protection))
+
+	pr.ReviewRequests = nil
+	pr.LatestReviews = []Review{{State: "COMMENTED"}}
+	assert.Empty(t, MergeBlockers(pr, &BranchProtection{RequiredApprovingReviews: 1}))
+	assert.Equal(t, []string{"a code owner review is required, but no code owner has been asked to review"}, MergeBlockers(pr, &BranchProtection{RequiredApprovingReviews: 1, RequiresCodeOwnerReviews: true}))
+
+	pr.State = PrMerged
+	assert.Empty(t, MergeBlockers(pr, protection))
+}
+
+func TestCampaignMarkerRoundTrips(t *testing.T) {
+	body := WithCampaignMarker("some body", "my-campaign")
+
+	assert.Equal(t, "some body\n\n<!-- turbolift:campaign=my-campaign -->", body)
+	assert.Equal(t, "my-campaign", CampaignFromBody(body))
+	assert.Equal(t, body, WithCampaignMarker(body, "my-campaign"), "the marker should only be added once")
+}
+
+func TestCampaignFromBodyWithoutMarker(t *testing.T) {
+	assert.Equal(t, "", CampaignFromBody("a PR raised by hand"))
+}
+
+func TestItFindsTheNumberOfAPrFromItsUrl(t *testing.T) {
+	assert.Equal(t, 12, PrNumberFromUrl("https://github.com/org/repo1/pull/12"))
+	assert.Equal(t, 3, PrNumberFromUrl("https://bitbucket.example.com/projects/PROJ/repos/repo1/pull-requests/3"))
+	assert.Equal(t, 0, PrNumberFromUrl("https://github.com/org/repo1"))
+}
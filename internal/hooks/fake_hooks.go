@@ -0,0 +1,60 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package hooks
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+)
+
+type FakeHooks struct {
+	handler func(hook string, workingDir string) error
+	calls   [][]string
+}
+
+func (f *FakeHooks) Run(_ context.Context, _ io.Writer, workingDir string, hook string, _ *campaign.Campaign, repo campaign.Repo) error {
+	f.calls = append(f.calls, []string{hook, workingDir, repo.FullRepoName})
+	return f.handler(hook, workingDir)
+}
+
+func (f *FakeHooks) AssertCalledWith(t *testing.T, expected [][]string) {
+	assert.Equal(t, expected, f.calls)
+}
+
+func NewFakeHooks(h func(hook string, workingDir string) error) *FakeHooks {
+	return &FakeHooks{
+		handler: h,
+		calls:   [][]string{},
+	}
+}
+
+func NewAlwaysSucceedsFakeHooks() *FakeHooks {
+	return NewFakeHooks(func(string, string) error {
+		return nil
+	})
+}
+
+func NewAlwaysFailsFakeHooks() *FakeHooks {
+	return NewFakeHooks(func(string, string) error {
+		return errors.New("synthetic error")
+	})
+}
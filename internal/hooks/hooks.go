@@ -0,0 +1,70 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package hooks
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/executor"
+	"github.com/skyscanner/turbolift/internal/logging"
+)
+
+var execInstance executor.Executor = executor.NewRealExecutor()
+
+type Hooks interface {
+	Run(ctx context.Context, output io.Writer, workingDir string, hook string, dir *campaign.Campaign, repo campaign.Repo) error
+}
+
+type RealHooks struct{}
+
+// Run executes the script configured for the hook through the user's shell, with metadata about the repo and
+// campaign exposed as TURBOLIFT_* environment variables.
+func (r *RealHooks) Run(ctx context.Context, output io.Writer, workingDir string, hook string, dir *campaign.Campaign, repo campaign.Repo) error {
+	script := dir.Hook(hook)
+	if script == "" {
+		return nil
+	}
+	shell, shellArgs := executor.ShellCommand(script)
+	return execInstance.ExecuteWithEnv(ctx, output, workingDir, hookEnv(hook, dir, repo), shell, shellArgs...)
+}
+
+func hookEnv(hook string, dir *campaign.Campaign, repo campaign.Repo) []string {
+	return append([]string{"TURBOLIFT_HOOK=" + hook}, executor.RepoEnv(dir, repo)...)
+}
+
+// RunAsActivity runs the hook, if one is configured, as its own activity in the logger's output.
+// It returns an error if the hook was run and failed, in which case the caller should treat the repo as errored.
+func RunAsActivity(ctx context.Context, h Hooks, logger *logging.Logger, workingDir string, hook string, dir *campaign.Campaign, repo campaign.Repo) error {
+	if dir.Hook(hook) == "" {
+		return nil
+	}
+
+	hookActivity := logger.StartActivity("Running %s hook for %s", hook, repo.FullRepoName)
+	if err := h.Run(ctx, hookActivity.Writer(), workingDir, hook, dir, repo); err != nil {
+		err = fmt.Errorf("%s hook failed: %w", hook, err)
+		hookActivity.EndWithFailure(err)
+		return err
+	}
+	hookActivity.EndWithSuccess()
+	return nil
+}
+
+func NewRealHooks() *RealHooks {
+	return &RealHooks{}
+}
@@ -0,0 +1,90 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package hooks
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/executor"
+)
+
+var repo = campaign.Repo{
+	Host:         "github.com",
+	OrgName:      "org",
+	RepoName:     "repo1",
+	FullRepoName: "github.com/org/repo1",
+}
+
+func TestItRunsConfiguredHookWithRepoMetadata(t *testing.T) {
+	fakeExecutor := executor.NewAlwaysSucceedsFakeExecutor()
+	execInstance = fakeExecutor
+
+	dir := &campaign.Campaign{
+		Name:   "my-campaign",
+		Config: campaign.Config{Hooks: map[string]string{campaign.PostCloneHook: "make deps"}},
+	}
+
+	err := NewRealHooks().Run(context.Background(), &strings.Builder{}, "work/org/repo1", campaign.PostCloneHook, dir, repo)
+	assert.NoError(t, err)
+
+	repoDir, _ := filepath.Abs("work/org/repo1")
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", executor.Shell(), "-c", "make deps"},
+	})
+	fakeExecutor.AssertEnvCalledWith(t, [][]string{
+		{
+			"TURBOLIFT_HOOK=post-clone",
+			"TURBOLIFT_CAMPAIGN=my-campaign",
+			"TURBOLIFT_REPO=github.com/org/repo1",
+			"TURBOLIFT_HOST=github.com",
+			"TURBOLIFT_ORG=org",
+			"TURBOLIFT_REPO_NAME=repo1",
+			"TURBOLIFT_REPO_DIR=" + repoDir,
+			"TURBOLIFT_DEFAULT_BRANCH=",
+		},
+	})
+}
+
+func TestItDoesNothingForUnconfiguredHook(t *testing.T) {
+	fakeExecutor := executor.NewAlwaysFailsFakeExecutor()
+	execInstance = fakeExecutor
+
+	dir := &campaign.Campaign{Name: "my-campaign"}
+
+	err := NewRealHooks().Run(context.Background(), &strings.Builder{}, "work/org/repo1", campaign.PreCommitHook, dir, repo)
+	assert.NoError(t, err)
+
+	fakeExecutor.AssertCalledWith(t, [][]string{})
+}
+
+func TestItReturnsErrorWhenHookFails(t *testing.T) {
+	fakeExecutor := executor.NewAlwaysFailsFakeExecutor()
+	execInstance = fakeExecutor
+
+	dir := &campaign.Campaign{
+		Name:   "my-campaign",
+		Config: campaign.Config{Hooks: map[string]string{campaign.PreCommitHook: "exit 1"}},
+	}
+
+	err := NewRealHooks().Run(context.Background(), &strings.Builder{}, "work/org/repo1", campaign.PreCommitHook, dir, repo)
+	assert.Error(t, err)
+}
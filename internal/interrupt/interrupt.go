@@ -0,0 +1,102 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package interrupt lets a run be stopped in two steps: first gracefully, once the repo being worked on is finished,
+// and then straight away, cancelling any commands that are still running.
+package interrupt
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+type stoppingKey struct{}
+
+type gracefulStop struct {
+	stopping    context.Context
+	requestStop context.CancelFunc
+}
+
+// WithGracefulStop returns a copy of ctx that carries a request for a graceful stop, along with the function that makes
+// the request. The returned context is not itself cancelled by the request, so running commands are left to finish.
+// A graceful stop already requested of ctx also stops the returned context.
+func WithGracefulStop(ctx context.Context) (context.Context, context.CancelFunc) {
+	stopping, requestStop := context.WithCancel(Stopping(ctx))
+	return context.WithValue(ctx, stoppingKey{}, gracefulStop{stopping: stopping, requestStop: requestStop}), requestStop
+}
+
+// Stopping returns a context that is done once a graceful stop has been requested, or ctx itself is done
+func Stopping(ctx context.Context) context.Context {
+	if stop, ok := ctx.Value(stoppingKey{}).(gracefulStop); ok {
+		return stop.stopping
+	}
+	return ctx
+}
+
+// RequestStop asks for a graceful stop, as the first interrupt does. It returns false if ctx cannot be stopped gracefully.
+func RequestStop(ctx context.Context) bool {
+	if stop, ok := ctx.Value(stoppingKey{}).(gracefulStop); ok {
+		stop.requestStop()
+		return true
+	}
+	return false
+}
+
+// Requested is true once a graceful stop has been requested, or ctx is done, so no more repos should be started
+func Requested(ctx context.Context) bool {
+	return Stopping(ctx).Err() != nil
+}
+
+// HandleSignals returns a context that responds to SIGINT and SIGTERM. The first asks for a graceful stop, and the
+// second cancels the context, stopping any commands still running. Commands run in their own process group, so a
+// Ctrl-C in the terminal does not reach them: it is only passed on to them by the second, when the context is
+// cancelled. After that, signals are handled as normal. The returned function stops handling signals, and should be
+// called once the run is over.
+func HandleSignals(parent context.Context, w io.Writer) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(parent)
+	ctx, requestStop := WithGracefulStop(ctx)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case <-signals:
+		case <-ctx.Done():
+			return
+		}
+		_, _ = fmt.Fprintln(w, "\nStopping once the current repo is finished - interrupt again to stop straight away")
+		requestStop()
+
+		select {
+		case <-signals:
+		case <-ctx.Done():
+			return
+		}
+		_, _ = fmt.Fprintln(w, "\nStopping straight away")
+		signal.Stop(signals)
+		cancel()
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		requestStop()
+		cancel()
+	}
+}
@@ -0,0 +1,62 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package interrupt
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGracefulStopIsRequestedWithoutCancellingTheContext(t *testing.T) {
+	ctx, requestStop := WithGracefulStop(context.Background())
+	assert.False(t, Requested(ctx))
+
+	requestStop()
+	assert.True(t, Requested(ctx))
+	assert.NoError(t, ctx.Err())
+
+	select {
+	case <-Stopping(ctx).Done():
+	default:
+		assert.Fail(t, "expected Stopping to be done")
+	}
+}
+
+func TestCancelledContextCountsAsStopRequested(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.False(t, Requested(ctx))
+
+	cancel()
+	assert.True(t, Requested(ctx))
+
+	ctx, cancel = context.WithCancel(context.Background())
+	ctx, requestStop := WithGracefulStop(ctx)
+	defer requestStop()
+	cancel()
+	assert.True(t, Requested(ctx))
+}
+
+func TestStopCanBeRequestedFromTheContext(t *testing.T) {
+	assert.False(t, RequestStop(context.Background()))
+
+	ctx, requestStop := WithGracefulStop(context.Background())
+	defer requestStop()
+	assert.True(t, RequestStop(ctx))
+	assert.True(t, Requested(ctx))
+	assert.NoError(t, ctx.Err())
+}
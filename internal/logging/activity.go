@@ -21,6 +21,8 @@ import (
 	"github.com/skyscanner/turbolift/internal/colors"
 	"io"
 	"strings"
+	"sync"
+	"time"
 )
 
 // Activity is a buffered logger associated with an on-screen spinner.
@@ -30,12 +32,21 @@ type Activity struct {
 	name    string
 	logs    []string
 	spinner *spinner.Spinner
-	writer  io.Writer
-	verbose bool
+	// animated is false if the spinner was never started, as the theme has none
+	animated bool
+	writer   io.Writer
+	verbose  bool
+	// mu guards logs, which commands may write to from another goroutine while the heartbeat reads them
+	mu            sync.Mutex
+	stopHeartbeat func()
+	// failed is called once the activity has ended with a failure
+	failed func()
 }
 
 func (a *Activity) Log(message string) {
-	a.logs = append(a.logs, message)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.logs = append(a.logs, Redact(message))
 }
 
 func (a *Activity) Logf(format string, args ...interface{}) {
@@ -51,9 +62,19 @@ func (a *Activity) emitLogs(colourTransform func(...interface{}) string) {
 	}
 }
 
-func (a *Activity) EndWithSuccess() {
-	a.spinner.FinalMSG = fmt.Sprintf("%s %s", colors.Pass("  OK  "), a.name)
+// stopSpinner replaces the spinner with the final message of the activity
+func (a *Activity) stopSpinner(finalMessage string) {
+	if !a.animated {
+		_, _ = fmt.Fprint(a.writer, finalMessage)
+		return
+	}
+	a.spinner.FinalMSG = finalMessage
 	a.spinner.Stop()
+}
+
+func (a *Activity) EndWithSuccess() {
+	a.stopHeartbeat()
+	a.stopSpinner(fmt.Sprintf("%s %s", colors.Pass("  OK  "), a.name))
 	_, _ = fmt.Fprintln(a.writer)
 
 	if a.verbose {
@@ -62,16 +83,16 @@ func (a *Activity) EndWithSuccess() {
 }
 
 func (a *Activity) EndWithSuccessAndEmitLogs() {
-	a.spinner.FinalMSG = fmt.Sprintf("%s %s", colors.Pass("  OK  "), a.name)
-	a.spinner.Stop()
+	a.stopHeartbeat()
+	a.stopSpinner(fmt.Sprintf("%s %s", colors.Pass("  OK  "), a.name))
 	_, _ = fmt.Fprintln(a.writer)
 
 	a.emitLogs(colors.White)
 }
 
 func (a *Activity) EndWithWarning(message interface{}) {
-	a.spinner.FinalMSG = fmt.Sprintf(colors.Warn(" WARN ")+colors.Yellow(" %s: %s"), a.name, message)
-	a.spinner.Stop()
+	a.stopHeartbeat()
+	a.stopSpinner(fmt.Sprintf(colors.Warn(" WARN ")+colors.Yellow(" %s: %s"), a.name, Redact(fmt.Sprint(message))))
 	_, _ = fmt.Fprintln(a.writer)
 
 	a.emitLogs(colors.Yellow)
@@ -82,11 +103,14 @@ func (a *Activity) EndWithWarningf(format string, args ...interface{}) {
 }
 
 func (a *Activity) EndWithFailure(message interface{}) {
-	a.spinner.FinalMSG = fmt.Sprintf(colors.Fail(" FAIL ")+colors.Red(" %s: %s"), a.name, message)
-	a.spinner.Stop()
+	a.stopHeartbeat()
+	a.stopSpinner(fmt.Sprintf(colors.Fail(" FAIL ")+colors.Red(" %s: %s"), a.name, Redact(fmt.Sprint(message))))
 	_, _ = fmt.Fprintln(a.writer)
 
 	a.emitLogs(colors.Red)
+	if a.failed != nil {
+		a.failed()
+	}
 }
 
 func (a *Activity) EndWithFailuref(format string, args ...interface{}) {
@@ -112,5 +136,81 @@ func (a *Activity) Writer() io.Writer {
 }
 
 func (a *Activity) Logs() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 	return strings.Join(a.logs, "\n")
 }
+
+// startHeartbeat reports every interval that the activity is still running, along with the last line of output it
+// produced, so that slow commands can be told apart from hung ones, and CI systems do not give up on a silent job
+func (a *Activity) startHeartbeat(interval time.Duration) {
+	if interval <= 0 {
+		a.stopHeartbeat = func() {}
+		return
+	}
+
+	started := time.Now()
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				a.beat(time.Since(started))
+			}
+		}
+	}()
+
+	var once sync.Once
+	a.stopHeartbeat = func() {
+		once.Do(func() {
+			close(stop)
+			<-stopped
+		})
+	}
+}
+
+func (a *Activity) beat(elapsed time.Duration) {
+	message := fmt.Sprintf("%s: still running after %s", a.name, elapsed.Round(time.Second))
+	if last := a.lastOutputLine(); last != "" {
+		message = fmt.Sprintf("%s (last output: %s)", message, last)
+	}
+
+	// the heartbeat is written on its own line above the spinner, which carries on below it
+	a.spinner.Lock()
+	defer a.spinner.Unlock()
+	lineStart := ""
+	if a.animated {
+		lineStart = "\r"
+	}
+	_, _ = fmt.Fprintf(a.writer, "%s%s %s\n", lineStart, colors.Normal(" .... "), message)
+}
+
+// maxHeartbeatOutput is the longest the last line of output can be in a heartbeat before it is cut short
+const maxHeartbeatOutput = 100
+
+// lastOutputLine finds the last non-blank line logged, treating carriage returns as line breaks, as progress output
+// from git and other tools uses them to redraw the same line
+func (a *Activity) lastOutputLine() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i := len(a.logs) - 1; i >= 0; i-- {
+		lines := strings.FieldsFunc(a.logs[i], func(r rune) bool { return r == '\n' || r == '\r' })
+		for j := len(lines) - 1; j >= 0; j-- {
+			line := strings.TrimSpace(lines[j])
+			if line == "" {
+				continue
+			}
+			if runes := []rune(line); len(runes) > maxHeartbeatOutput {
+				line = string(runes[:maxHeartbeatOutput]) + "..."
+			}
+			return line
+		}
+	}
+	return ""
+}
@@ -0,0 +1,95 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package logging
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/cmd/flags"
+	"github.com/skyscanner/turbolift/internal/colors"
+)
+
+func init() {
+	// disable output colouring so that strings we want to do 'Contains' checks on do not have ANSI escape sequences in it
+	_ = os.Setenv("NO_COLOR", "1")
+}
+
+func TestActivityReportsHeartbeatWithLastOutput(t *testing.T) {
+	a, out := startActivity(20*time.Millisecond, "Cloning org/repo")
+	a.Log("Cloning into 'repo'...")
+	a.Log("Receiving objects:  10% (1/10)\rReceiving objects:  50% (5/10)\r")
+	time.Sleep(100 * time.Millisecond)
+	a.EndWithSuccess()
+
+	assert.Contains(t, out.String(), "Cloning org/repo: still running after")
+	assert.Contains(t, out.String(), "(last output: Receiving objects:  50% (5/10))")
+}
+
+func TestActivityHeartbeatCanBeTurnedOff(t *testing.T) {
+	a, out := startActivity(0, "Cloning org/repo")
+	time.Sleep(50 * time.Millisecond)
+	a.EndWithSuccess()
+
+	assert.NotContains(t, out.String(), "still running")
+}
+
+func TestActivityStopsHeartbeatWhenEnded(t *testing.T) {
+	a, out := startActivity(20*time.Millisecond, "Cloning org/repo")
+	a.EndWithFailure("synthetic error")
+	ended := out.String()
+	time.Sleep(100 * time.Millisecond)
+
+	assert.Equal(t, ended, out.String())
+	assert.NotContains(t, ended, "still running")
+}
+
+func TestActivityShowsOnlyItsOutcomeWhenTheThemeHasNoSpinner(t *testing.T) {
+	assert.NoError(t, colors.SetTheme(colors.AsciiTheme, false))
+	defer func() { _ = colors.SetTheme(colors.DefaultTheme, false) }()
+
+	a, out := startActivity(20*time.Millisecond, "Cloning org/repo")
+	time.Sleep(50 * time.Millisecond)
+	a.EndWithWarning("already cloned")
+
+	assert.Contains(t, out.String(), " ....  Cloning org/repo: still running after")
+	assert.Contains(t, out.String(), " WARN  Cloning org/repo: already cloned\n")
+	assert.NotContains(t, out.String(), "\r")
+	assert.NotContains(t, out.String(), "\033")
+}
+
+func TestLastOutputLineIsCutShort(t *testing.T) {
+	a := &Activity{}
+	a.Log(string(bytes.Repeat([]byte("x"), maxHeartbeatOutput+10)))
+	a.Log("   \n")
+
+	assert.Equal(t, string(bytes.Repeat([]byte("x"), maxHeartbeatOutput))+"...", a.lastOutputLine())
+}
+
+func startActivity(heartbeat time.Duration, name string) (*Activity, *bytes.Buffer) {
+	flags.Heartbeat = heartbeat
+	defer func() { flags.Heartbeat = 0 }()
+
+	out := bytes.NewBufferString("")
+	c := &cobra.Command{}
+	c.SetOut(out)
+	return NewLogger(c).StartActivity("%s", name), out
+}
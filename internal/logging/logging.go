@@ -16,38 +16,63 @@
 package logging
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"sync"
 	"time"
 
 	"github.com/briandowns/spinner"
 	"github.com/skyscanner/turbolift/cmd/flags"
 	"github.com/skyscanner/turbolift/internal/colors"
+	"github.com/skyscanner/turbolift/internal/exitcode"
+	"github.com/skyscanner/turbolift/internal/interrupt"
 	"github.com/spf13/cobra"
 )
 
 // Logger is a facade for CLI logging.
 type Logger struct {
-	writer  io.Writer
-	verbose bool
+	writer    io.Writer
+	verbose   bool
+	heartbeat time.Duration
+	// ctx is stopped gracefully after the first failure, if failFast is set
+	ctx      context.Context
+	failFast bool
+	// outputMu is held while PrefixWriters write their lines, so that lines from different writers are not mixed up
+	outputMu *sync.Mutex
+	// embedded is set for loggers used outside the CLI, which show no spinners and leave the exit code alone
+	embedded bool
 }
 
 // NewLogger creates a Logger associated with a particular *cobra.Command instance.
 // Logs will be delivered to the command's stdout writer.
 func NewLogger(c *cobra.Command) *Logger {
 	return &Logger{
-		writer:  c.OutOrStdout(),
-		verbose: flags.Verbose,
+		writer:    c.OutOrStdout(),
+		verbose:   flags.Verbose,
+		heartbeat: flags.Heartbeat,
+		ctx:       c.Context(),
+		failFast:  flags.FailFast,
+		outputMu:  &sync.Mutex{},
+	}
+}
+
+// NewEmbeddedLogger creates a Logger that writes to w, for running operations from programs other than the CLI.
+// Activities are not animated, and failures do not count towards the exit code.
+func NewEmbeddedLogger(w io.Writer) *Logger {
+	return &Logger{
+		writer:   w,
+		outputMu: &sync.Mutex{},
+		embedded: true,
 	}
 }
 
 func (log *Logger) Printf(s string, args ...interface{}) {
-	_, _ = fmt.Fprintf(log.writer, s, args...)
-	_, _ = fmt.Fprintln(log.writer)
+	_, _ = fmt.Fprintln(log.writer, Redact(fmt.Sprintf(s, args...)))
 }
 
 func (log *Logger) Println(s ...interface{}) {
-	_, _ = fmt.Fprintln(log.writer, s...)
+	_, _ = fmt.Fprint(log.writer, Redact(fmt.Sprintln(s...)))
 }
 
 func (log *Logger) Successf(format string, args ...interface{}) {
@@ -63,6 +88,21 @@ func (log *Logger) Warnf(format string, args ...interface{}) {
 func (log *Logger) Errorf(format string, args ...interface{}) {
 	prefixedFormat := fmt.Sprint(colors.Warn("  ERR "), " ", colors.Red(format))
 	log.Printf(prefixedFormat, args...)
+	log.failed()
+}
+
+// failed notes a failure for the exit code, and with --fail-fast stops the run once the current repo is finished
+func (log *Logger) failed() {
+	if log.embedded {
+		return
+	}
+	exitcode.Fail()
+	if !log.failFast || log.ctx == nil || interrupt.Requested(log.ctx) {
+		return
+	}
+	if interrupt.RequestStop(log.ctx) {
+		log.Warnf("Stopping after the first failure, as --fail-fast is set")
+	}
 }
 
 // StartActivity creates and starts an *Activity with an associated spinner.
@@ -70,19 +110,31 @@ func (log *Logger) Errorf(format string, args ...interface{}) {
 // is performed using this Logger.
 func (log *Logger) StartActivity(format string, args ...interface{}) *Activity {
 	name := fmt.Sprintf(format, args...)
-	s := spinner.New(spinner.CharSets[11], 100*time.Millisecond) // Build our new spinner
+	// themes without a spinner only show the outcome of the activity, once it ends
+	frames := colors.Spinner()
+	animated := frames != nil && !log.embedded
+	if !animated {
+		frames = spinner.CharSets[9]
+	}
+	s := spinner.New(frames, 100*time.Millisecond) // Build our new spinner
 	s.Suffix = fmt.Sprintf("  %s", name)
 	s.Writer = log.writer
 	s.HideCursor = true
-	s.Start()
+	if animated {
+		s.Start()
+	}
 
-	return &Activity{
-		name:    name,
-		logs:    []string{},
-		spinner: s,
-		writer:  log.writer,
-		verbose: log.verbose,
+	a := &Activity{
+		name:     name,
+		logs:     []string{},
+		spinner:  s,
+		animated: animated,
+		writer:   log.writer,
+		verbose:  log.verbose,
+		failed:   log.failed,
 	}
+	a.startHeartbeat(log.heartbeat)
+	return a
 }
 
 func (log *Logger) Writer() io.Writer {
@@ -0,0 +1,95 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/skyscanner/turbolift/internal/colors"
+)
+
+// PrefixWriter tags each line written to it with a prefix, such as the repo it came from, so that output from commands
+// running side by side can be told apart. Lines are only ever written whole, so those from different writers sharing a
+// Logger do not get mixed up with each other.
+type PrefixWriter struct {
+	out    io.Writer
+	mu     *sync.Mutex
+	prefix string
+	// buffered holds back every line until Flush, so that all of a command's output is written together
+	buffered bool
+	partial  []byte
+	lines    []string
+}
+
+// PrefixWriter creates a PrefixWriter onto the Logger's output. Lines are streamed as soon as they are complete, unless
+// buffered is set, in which case they are held back until Flush.
+func (log *Logger) PrefixWriter(prefix string, buffered bool) *PrefixWriter {
+	return &PrefixWriter{
+		out:      log.writer,
+		mu:       log.outputMu,
+		prefix:   prefix,
+		buffered: buffered,
+	}
+}
+
+func (w *PrefixWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	var complete []string
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		complete = append(complete, strings.TrimRight(string(w.partial[:i]), "\r"))
+		w.partial = w.partial[i+1:]
+	}
+
+	if w.buffered {
+		w.lines = append(w.lines, complete...)
+	} else {
+		w.writeLines(complete)
+	}
+	return len(p), nil
+}
+
+// Flush writes out any held back lines, along with the last line if it did not end with a newline
+func (w *PrefixWriter) Flush() {
+	lines := w.lines
+	if len(w.partial) > 0 {
+		lines = append(lines, strings.TrimRight(string(w.partial), "\r"))
+	}
+	w.lines = nil
+	w.partial = nil
+	w.writeLines(lines)
+}
+
+func (w *PrefixWriter) writeLines(lines []string) {
+	if len(lines) == 0 {
+		return
+	}
+	var b strings.Builder
+	for _, line := range lines {
+		_, _ = fmt.Fprintf(&b, "%s %s\n", colors.Cyan("["+w.prefix+"]"), Redact(line))
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, _ = io.WriteString(w.out, b.String())
+}
@@ -0,0 +1,69 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package logging
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrefixWriterStreamsWholeLinesTaggedWithTheirPrefix(t *testing.T) {
+	logger, out := newTestLogger()
+	repo1 := logger.PrefixWriter("org/repo1", false)
+	repo2 := logger.PrefixWriter("org/repo2", false)
+
+	_, _ = repo1.Write([]byte("first line\nsecond "))
+	_, _ = repo2.Write([]byte("other line\r\n"))
+	_, _ = repo1.Write([]byte("line\n"))
+
+	assert.Equal(t, "[org/repo1] first line\n[org/repo2] other line\n[org/repo1] second line\n", out.String())
+}
+
+func TestPrefixWriterFlushesAnUnfinishedLastLine(t *testing.T) {
+	logger, out := newTestLogger()
+	w := logger.PrefixWriter("org/repo1", false)
+
+	_, _ = w.Write([]byte("no newline"))
+	assert.Equal(t, "", out.String())
+
+	w.Flush()
+	assert.Equal(t, "[org/repo1] no newline\n", out.String())
+}
+
+func TestBufferedPrefixWriterHoldsLinesBackUntilFlushed(t *testing.T) {
+	logger, out := newTestLogger()
+	repo1 := logger.PrefixWriter("org/repo1", true)
+	repo2 := logger.PrefixWriter("org/repo2", true)
+
+	_, _ = repo1.Write([]byte("one\n"))
+	_, _ = repo2.Write([]byte("other\n"))
+	_, _ = repo1.Write([]byte("two\n"))
+	assert.Equal(t, "", out.String())
+
+	repo2.Flush()
+	repo1.Flush()
+	assert.Equal(t, "[org/repo2] other\n[org/repo1] one\n[org/repo1] two\n", out.String())
+}
+
+func newTestLogger() (*Logger, *bytes.Buffer) {
+	out := bytes.NewBufferString("")
+	c := &cobra.Command{}
+	c.SetOut(out)
+	return NewLogger(c), out
+}
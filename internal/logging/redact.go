@@ -0,0 +1,131 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package logging
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Redacted is what secrets are replaced with in logged output
+const Redacted = "[REDACTED]"
+
+type secretPattern struct {
+	regexp      *regexp.Regexp
+	replacement string
+}
+
+// builtinSecretPatterns match the secrets most likely to turn up in the output of the commands turbolift runs. Only
+// the secret itself is replaced, so that it is still clear what was there.
+var builtinSecretPatterns = []secretPattern{
+	{regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}`), Redacted},
+	{regexp.MustCompile(`\bgithub_pat_[A-Za-z0-9_]{22,}`), Redacted},
+	{regexp.MustCompile(`(?i)(authorization:\s*(?:(?:bearer|token|basic)\s+)?)[^\s"']+`), "${1}" + Redacted},
+	{regexp.MustCompile(`(https?://[^/\s:@]+:)[^/\s@]+@`), "${1}" + Redacted + "@"},
+	{regexp.MustCompile(`(https://hooks\.slack\.com/services/)[^\s"']+`), "${1}" + Redacted},
+}
+
+// secretVariables are the environment variables holding tokens that turbolift, gh and git may use, whose values are
+// redacted wherever they appear
+var secretVariables = []string{
+	"GH_TOKEN", "GITHUB_TOKEN", "GH_ENTERPRISE_TOKEN", "GITHUB_ENTERPRISE_TOKEN",
+	"TURBOLIFT_BITBUCKET_TOKEN", "TURBOLIFT_NOTIFY_TOKEN", "TURBOLIFT_REGISTRY_TOKEN",
+}
+
+// minSecretLength is the shortest value that is treated as a secret, so that short values such as "1" or "true" given
+// in a token variable by mistake do not redact every occurrence of them
+const minSecretLength = 8
+
+var (
+	redactMu sync.RWMutex
+	// userPatterns are the patterns configured for the campaign, which are redacted in full
+	userPatterns []*regexp.Regexp
+	secrets      = map[string]bool{}
+	readSecrets  sync.Once
+)
+
+// SetSecretPatterns redacts anything matching the given regular expressions, as configured for the campaign, as well
+// as the usual secrets
+func SetSecretPatterns(patterns []string) error {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("unable to parse redact pattern %s: %w", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	redactMu.Lock()
+	defer redactMu.Unlock()
+	userPatterns = compiled
+	return nil
+}
+
+// AddSecret redacts a secret, such as a token that turbolift has fetched, wherever it appears
+func AddSecret(secret string) {
+	secret = strings.TrimSpace(secret)
+	if len(secret) < minSecretLength {
+		return
+	}
+	redactMu.Lock()
+	defer redactMu.Unlock()
+	secrets[secret] = true
+}
+
+// Redact masks any secrets in s
+func Redact(s string) string {
+	readSecrets.Do(func() {
+		for _, variable := range secretVariables {
+			AddSecret(os.Getenv(variable))
+		}
+	})
+
+	redactMu.RLock()
+	defer redactMu.RUnlock()
+	for _, secret := range sortedSecrets() {
+		s = strings.ReplaceAll(s, secret, Redacted)
+	}
+	for _, pattern := range builtinSecretPatterns {
+		s = pattern.regexp.ReplaceAllString(s, pattern.replacement)
+	}
+	for _, pattern := range userPatterns {
+		s = pattern.ReplaceAllLiteralString(s, Redacted)
+	}
+	return s
+}
+
+// RedactAll masks any secrets in each of ss, such as the arguments of a command
+func RedactAll(ss []string) []string {
+	redacted := make([]string, len(ss))
+	for i, s := range ss {
+		redacted[i] = Redact(s)
+	}
+	return redacted
+}
+
+// sortedSecrets lists the secrets longest first, so that a secret containing another is redacted whole
+func sortedSecrets() []string {
+	sorted := make([]string, 0, len(secrets))
+	for secret := range secrets {
+		sorted = append(sorted, secret)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	return sorted
+}
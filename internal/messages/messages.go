@@ -0,0 +1,177 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package messages holds the user-facing text that campaigns can customise, such as confirmation prompts, the sections
+// turbolift adds to PR descriptions, and the summaries printed at the end of a run. Each message is a Go template,
+// which may use the fields listed for it and the red, green and yellow functions to colour its output.
+package messages
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/skyscanner/turbolift/internal/colors"
+)
+
+// The keys of the messages that can be overridden in the messages section of turbolift.yaml
+const (
+	ConfirmClosePrs         = "confirm-close-prs"
+	ConfirmReopenPrs        = "confirm-reopen-prs"
+	ConfirmEnableAutoMerge  = "confirm-enable-auto-merge"
+	ConfirmUpdateBranch     = "confirm-update-branch"
+	ConfirmAddLabels        = "confirm-add-labels"
+	ConfirmRemoveLabels     = "confirm-remove-labels"
+	ConfirmNag              = "confirm-nag"
+	ConfirmRequestReview    = "confirm-request-review"
+	ConfirmAmendDescription = "confirm-amend-description"
+	ConfirmClean            = "confirm-clean"
+	ConfirmDeleteBranches   = "confirm-delete-branches"
+	ConfirmDropGoneRepos    = "confirm-drop-gone-repos"
+	ConfirmIncludeDiff      = "confirm-include-diff"
+	ConfirmCommit           = "confirm-commit"
+	ConfirmByCampaignName   = "confirm-by-campaign-name"
+	ChecklistHeading        = "checklist-heading"
+	FilesChangedHeading     = "files-changed-heading"
+	ChangesHeading          = "changes-heading"
+	DiffTruncated           = "diff-truncated"
+	NagComment              = "nag-comment"
+	SummaryCompleted        = "summary-completed"
+	SummaryWithErrors       = "summary-completed-with-errors"
+	SummaryInterrupted      = "summary-interrupted"
+)
+
+type message struct {
+	text string
+	// fields are the names of the fields that the message is given
+	fields []string
+}
+
+var defaults = map[string]message{
+	ConfirmClosePrs:         {"Close {{ .Campaign }} campaign PRs for all repos in {{ .ReposFile }}?", []string{"Campaign", "ReposFile"}},
+	ConfirmReopenPrs:        {"Reopen closed {{ .Campaign }} campaign PRs for all repos in {{ .ReposFile }}?", []string{"Campaign", "ReposFile"}},
+	ConfirmEnableAutoMerge:  {"Enable auto-merge ({{ .Strategy }}) on {{ .Campaign }} campaign PRs for all repos in {{ .ReposFile }}?", []string{"Campaign", "ReposFile", "Strategy"}},
+	ConfirmUpdateBranch:     {"Update {{ .Campaign }} campaign PR branches with the latest changes from their base branches for all repos in {{ .ReposFile }}?", []string{"Campaign", "ReposFile"}},
+	ConfirmAddLabels:        {"Add the labels {{ .Labels }} to {{ .Campaign }} campaign PRs for all repos in {{ .ReposFile }}?", []string{"Campaign", "ReposFile", "Labels"}},
+	ConfirmRemoveLabels:     {"Remove the labels {{ .Labels }} from {{ .Campaign }} campaign PRs for all repos in {{ .ReposFile }}?", []string{"Campaign", "ReposFile", "Labels"}},
+	ConfirmNag:              {"Remind reviewers of {{ .Campaign }} campaign PRs that have been waiting for a review for more than {{ .Days }} days, for all repos in {{ .ReposFile }}?", []string{"Campaign", "ReposFile", "Days"}},
+	ConfirmRequestReview:    {"Request reviews from {{ .Reviewers }} on {{ .Campaign }} campaign PRs for all repos in {{ .ReposFile }}?", []string{"Campaign", "ReposFile", "Reviewers"}},
+	ConfirmAmendDescription: {"Update {{ .Campaign }} campaign PR titles and descriptions for all repos listed in {{ .ReposFile }}?", []string{"Campaign", "ReposFile"}},
+	ConfirmClean:            {"Discard all changes and commits on branch {{ .Branch }} for all repos in {{ .ReposFile }}?", []string{"Branch", "ReposFile"}},
+	ConfirmDeleteBranches:   {"Delete the {{ .Branch }} branches of closed and merged {{ .Campaign }} campaign PRs for all repos in {{ .ReposFile }}?", []string{"Branch", "Campaign", "ReposFile"}},
+	ConfirmDropGoneRepos:    {"Drop these repos from {{ .ReposFile }}?", []string{"ReposFile"}},
+	ConfirmIncludeDiff:      {"Include {{ .Repo }} in the PRs ({{ .Reason }})?", []string{"Repo", "Reason"}},
+	ConfirmCommit:           {"Commit these changes to {{ .Repo }}?", []string{"Repo"}},
+	ConfirmByCampaignName:   {"{{ .Question }} This affects {{ .Repos }} repos, so type the campaign name ({{ .Campaign }}) to confirm", []string{"Question", "Repos", "Campaign"}},
+	ChecklistHeading:        {"### Reviewer checklist", nil},
+	FilesChangedHeading:     {"### Files changed ({{ .Count }})", []string{"Count"}},
+	ChangesHeading:          {"### Changes", nil},
+	DiffTruncated:           {"_The diff has been truncated to {{ .Lines }} lines; see the Files changed tab for the rest._", []string{"Lines"}},
+	NagComment:              {"This PR from the {{ .Campaign }} campaign has been waiting for a review for {{ .Days }} days. Could somebody take a look, please?", []string{"Campaign", "Days"}},
+	SummaryCompleted:        {"turbolift {{ .Command }} completed", []string{"Command"}},
+	SummaryWithErrors:       {`turbolift {{ .Command }} completed with {{ red "errors" }}`, []string{"Command"}},
+	SummaryInterrupted:      {`turbolift {{ .Command }} was {{ red "interrupted" }}`, []string{"Command"}},
+}
+
+var funcs = template.FuncMap{
+	"red":    colors.Red,
+	"green":  colors.Green,
+	"yellow": colors.Yellow,
+}
+
+// Fields are the values that a message is rendered with
+type Fields map[string]interface{}
+
+// Catalog is the set of messages used by a campaign: the defaults, with any overrides from its configuration
+type Catalog struct {
+	templates map[string]*template.Template
+}
+
+// New creates a catalog from the default messages and the given overrides, which are checked to only use known keys
+// and the fields given to each message
+func New(overrides map[string]string) (*Catalog, error) {
+	c := &Catalog{templates: map[string]*template.Template{}}
+	for key, m := range defaults {
+		c.templates[key] = template.Must(parse(key, m.text))
+	}
+
+	for key, text := range overrides {
+		m, ok := defaults[key]
+		if !ok {
+			return nil, fmt.Errorf("unknown message %s: must be one of %s", key, strings.Join(Keys(), ", "))
+		}
+		parsed, err := parse(key, text)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse message %s: %w", key, err)
+		}
+		sample := Fields{}
+		for _, field := range m.fields {
+			sample[field] = ""
+		}
+		if err := parsed.Execute(&strings.Builder{}, sample); err != nil {
+			return nil, fmt.Errorf("unable to render message %s, %s: %w", key, describeFields(m.fields), err)
+		}
+		c.templates[key] = parsed
+	}
+	return c, nil
+}
+
+func parse(key string, text string) (*template.Template, error) {
+	return template.New(key).Option("missingkey=error").Funcs(funcs).Parse(text)
+}
+
+func describeFields(fields []string) string {
+	if len(fields) == 0 {
+		return "which takes no fields"
+	}
+	return "which may use ." + strings.Join(fields, ", .")
+}
+
+// Keys lists the keys of all the messages that can be overridden, in alphabetical order
+func Keys() []string {
+	var keys []string
+	for key := range defaults {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Format renders a message with the given fields. A nil catalog renders the default messages.
+func (c *Catalog) Format(key string, fields Fields) string {
+	tmpl, ok := c.template(key)
+	if !ok {
+		return key
+	}
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, fields); err != nil {
+		// overrides are checked when the catalog is created, so this only happens if a caller leaves out a field
+		return defaults[key].text
+	}
+	return sb.String()
+}
+
+func (c *Catalog) template(key string) (*template.Template, bool) {
+	if c != nil {
+		tmpl, ok := c.templates[key]
+		return tmpl, ok
+	}
+	m, ok := defaults[key]
+	if !ok {
+		return nil, false
+	}
+	return template.Must(parse(key, m.text)), true
+}
@@ -0,0 +1,73 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package messages
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	// disable colors in tests
+	_ = os.Setenv("NO_COLOR", "1")
+}
+
+func TestItRendersDefaultMessages(t *testing.T) {
+	catalog, err := New(nil)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "Drop these repos from repos.txt?", catalog.Format(ConfirmDropGoneRepos, Fields{"ReposFile": "repos.txt"}))
+	assert.Equal(t, "turbolift commit was interrupted", catalog.Format(SummaryInterrupted, Fields{"Command": "commit"}))
+	assert.Equal(t, "### Files changed (2)", catalog.Format(FilesChangedHeading, Fields{"Count": 2}))
+}
+
+func TestANilCatalogRendersDefaultMessages(t *testing.T) {
+	var catalog *Catalog
+	assert.Equal(t, "### Reviewer checklist", catalog.Format(ChecklistHeading, nil))
+	assert.Equal(t, "turbolift clone completed", catalog.Format(SummaryCompleted, Fields{"Command": "clone"}))
+}
+
+func TestItOverridesMessages(t *testing.T) {
+	catalog, err := New(map[string]string{
+		ChecklistHeading: "## Bitte prüfen",
+		ConfirmClean:     "Reset {{ .Branch }} everywhere? There is no going back",
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, "## Bitte prüfen", catalog.Format(ChecklistHeading, nil))
+	assert.Equal(t, "Reset my-branch everywhere? There is no going back", catalog.Format(ConfirmClean, Fields{"Branch": "my-branch", "ReposFile": "repos.txt"}))
+	assert.Equal(t, "### Changes", catalog.Format(ChangesHeading, nil))
+}
+
+func TestItRejectsUnknownMessages(t *testing.T) {
+	_, err := New(map[string]string{"confirm-everything": "Sure?"})
+	assert.Contains(t, err.Error(), "unknown message confirm-everything: must be one of changes-heading, checklist-heading,")
+}
+
+func TestItRejectsMessagesUsingUnknownFields(t *testing.T) {
+	_, err := New(map[string]string{ConfirmClean: "Reset {{ .Campaign }}?"})
+	assert.Contains(t, err.Error(), "unable to render message confirm-clean, which may use .Branch, .ReposFile")
+
+	_, err = New(map[string]string{ChangesHeading: "{{ .Count }} changes"})
+	assert.Contains(t, err.Error(), "which takes no fields")
+}
+
+func TestItRejectsMessagesThatDoNotParse(t *testing.T) {
+	_, err := New(map[string]string{ChangesHeading: "{{ .Count "})
+	assert.Contains(t, err.Error(), "unable to parse message changes-heading")
+}
@@ -0,0 +1,165 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package notify posts the summary of a run to Slack or to any HTTP endpoint when it finishes, for campaigns whose
+// long runs are started from CI or left running unattended.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/summary"
+)
+
+// maxListedRepos is how many of the repos that errored are named in a notification
+const maxListedRepos = 10
+
+// Client is used to post notifications
+var Client = http.DefaultClient
+
+// Target is somewhere that notifications are posted
+type Target struct {
+	// Name describes the target in messages, without giving away its URL
+	Name string
+	Url  string
+	// slack targets are sent the text of the notification in the form that Slack incoming webhooks expect
+	slack bool
+}
+
+// Targets returns where the notification for a command should be posted, which is nowhere unless the campaign
+// configures somewhere or the slackWebhook or webhook flags are given. Flags take the place of the configured URLs.
+func Targets(config campaign.NotifyConfig, command string, slackWebhook string, webhook string) []Target {
+	if slackWebhook == "" && webhook == "" {
+		if !config.NotifiesFor(command) {
+			return nil
+		}
+		slackWebhook = os.ExpandEnv(config.SlackWebhook)
+		webhook = os.ExpandEnv(config.Webhook)
+	}
+
+	var targets []Target
+	if slackWebhook != "" {
+		targets = append(targets, Target{Name: "Slack", Url: slackWebhook, slack: true})
+	}
+	if webhook != "" {
+		targets = append(targets, Target{Name: "the webhook", Url: webhook})
+	}
+	return targets
+}
+
+// Notification is what is posted to a webhook that is not Slack's
+type Notification struct {
+	Campaign string `json:"campaign"`
+	// Text is the notification as it would be posted to Slack
+	Text string `json:"text"`
+	// ErroredByCategory counts the repos that errored with each category of error
+	ErroredByCategory map[string]int   `json:"errored-by-category"`
+	Summary           *summary.Summary `json:"summary"`
+}
+
+// NewNotification describes the outcome of a run over a campaign
+func NewNotification(campaignName string, sum *summary.Summary) Notification {
+	notification := Notification{Campaign: campaignName, ErroredByCategory: map[string]int{}, Summary: sum}
+	var erroredRepos []string
+	for _, result := range sum.Repos {
+		if result.Outcome == summary.Errored {
+			notification.ErroredByCategory[result.Category]++
+			erroredRepos = append(erroredRepos, result.Repo)
+		}
+	}
+
+	outcome := "finished"
+	if sum.Interrupted {
+		outcome = "was interrupted"
+	} else if sum.Errored > 0 {
+		outcome = "finished with errors"
+	}
+	duration := (time.Duration(sum.DurationSeconds) * time.Second).String()
+	lines := []string{fmt.Sprintf("turbolift %s %s for campaign %s: %d OK, %d skipped, %d errored (took %s)",
+		sum.Command, outcome, campaignName, sum.Done, sum.Skipped, sum.Errored, duration)}
+
+	if len(notification.ErroredByCategory) > 0 {
+		var categories []string
+		for category, count := range notification.ErroredByCategory {
+			categories = append(categories, fmt.Sprintf("%d %s", count, category))
+		}
+		sort.Strings(categories)
+		lines = append(lines, "Errors: "+strings.Join(categories, ", "))
+	}
+	if len(erroredRepos) > maxListedRepos {
+		more := len(erroredRepos) - maxListedRepos
+		erroredRepos = append(erroredRepos[:maxListedRepos], fmt.Sprintf("and %d more", more))
+	}
+	if len(erroredRepos) > 0 {
+		lines = append(lines, "Errored repos: "+strings.Join(erroredRepos, ", "))
+	}
+	for _, err := range sum.Errors {
+		lines = append(lines, "Error: "+err)
+	}
+
+	notification.Text = strings.Join(lines, "\n")
+	return notification
+}
+
+// Send posts the notification to the target. If TURBOLIFT_NOTIFY_TOKEN is set, it is sent to webhooks other than
+// Slack's as a bearer token.
+func Send(ctx context.Context, target Target, notification Notification) error {
+	var payload interface{} = notification
+	if target.slack {
+		payload = map[string]string{"text": notification.Text}
+	}
+	contents, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, target.Url, bytes.NewReader(contents))
+	if err != nil {
+		return fmt.Errorf("the URL of %s is not valid", target.Name)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if token := os.Getenv("TURBOLIFT_NOTIFY_TOKEN"); token != "" && !target.slack {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	response, err := Client.Do(request)
+	if err != nil {
+		// the error would otherwise include the URL, which for webhooks is as good as a password
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return fmt.Errorf("unable to reach %s: %w", target.Name, urlErr.Err)
+		}
+		return err
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %s", target.Name, response.Status)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/git"
+	"github.com/skyscanner/turbolift/internal/summary"
+)
+
+func TestItOnlyNotifiesForConfiguredCommands(t *testing.T) {
+	_ = os.Setenv("TEST_SLACK_WEBHOOK", "https://hooks.slack.example.com/secret")
+	defer func() {
+		_ = os.Unsetenv("TEST_SLACK_WEBHOOK")
+	}()
+	config := campaign.NotifyConfig{SlackWebhook: "${TEST_SLACK_WEBHOOK}"}
+
+	targets := Targets(config, "create-prs", "", "")
+	assert.Len(t, targets, 1)
+	assert.Equal(t, "https://hooks.slack.example.com/secret", targets[0].Url)
+	assert.Empty(t, Targets(config, "update-prs", "", ""))
+
+	config.Commands = []string{"update-prs"}
+	assert.Len(t, Targets(config, "update-prs", "", ""), 1)
+	assert.Empty(t, Targets(config, "create-prs", "", ""))
+
+	assert.Empty(t, Targets(campaign.NotifyConfig{}, "clone", "", ""))
+	targets = Targets(campaign.NotifyConfig{}, "commit", "", "https://example.com/hook")
+	assert.Equal(t, []Target{{Name: "the webhook", Url: "https://example.com/hook"}}, targets)
+}
+
+func TestItDescribesTheOutcomeOfTheRun(t *testing.T) {
+	notification := NewNotification("my-campaign", exampleSummary())
+
+	assert.Equal(t, "turbolift clone finished with errors for campaign my-campaign: 1 OK, 1 skipped, 2 errored (took 1h0m0s)\n"+
+		"Errors: 1 other, 1 push-rejected\n"+
+		"Errored repos: org/repo3, org/repo4", notification.Text)
+	assert.Equal(t, map[string]int{"other": 1, "push-rejected": 1}, notification.ErroredByCategory)
+}
+
+func TestItPostsTheTextToSlack(t *testing.T) {
+	var received map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "", r.Header.Get("Authorization"))
+		_ = json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer server.Close()
+
+	err := Send(context.Background(), Target{Name: "Slack", Url: server.URL, slack: true}, NewNotification("my-campaign", exampleSummary()))
+	assert.NoError(t, err)
+	assert.Len(t, received, 1)
+	assert.Contains(t, received["text"], "turbolift clone finished with errors for campaign my-campaign")
+}
+
+func TestItPostsTheSummaryToWebhooks(t *testing.T) {
+	_ = os.Setenv("TURBOLIFT_NOTIFY_TOKEN", "s3cret")
+	defer func() {
+		_ = os.Unsetenv("TURBOLIFT_NOTIFY_TOKEN")
+	}()
+	var received Notification
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer s3cret", r.Header.Get("Authorization"))
+		_ = json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer server.Close()
+
+	err := Send(context.Background(), Target{Name: "the webhook", Url: server.URL}, NewNotification("my-campaign", exampleSummary()))
+	assert.NoError(t, err)
+	assert.Equal(t, "my-campaign", received.Campaign)
+	assert.Equal(t, 2, received.Summary.Errored)
+	assert.Len(t, received.Summary.Repos, 4)
+	assert.Equal(t, 1, received.ErroredByCategory["push-rejected"])
+}
+
+func TestItDoesNotGiveAwayTheUrlWhenItCannotBeReached(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := server.URL + "/secret-token"
+	server.Close()
+
+	err := Send(context.Background(), Target{Name: "Slack", Url: url, slack: true}, NewNotification("my-campaign", exampleSummary()))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unable to reach Slack")
+	assert.NotContains(t, err.Error(), "secret-token")
+}
+
+func TestItReportsWebhooksThatRejectTheNotification(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	err := Send(context.Background(), Target{Name: "the webhook", Url: server.URL}, NewNotification("my-campaign", exampleSummary()))
+	assert.EqualError(t, err, "the webhook returned 403 Forbidden")
+}
+
+func exampleSummary() *summary.Summary {
+	sum := summary.New("clone")
+	sum.RecordDone(campaign.Repo{FullRepoName: "org/repo1"})
+	sum.RecordSkipped(campaign.Repo{FullRepoName: "org/repo2"}, "already cloned")
+	sum.RecordErrored(campaign.Repo{FullRepoName: "org/repo3"}, &git.PushRejectedError{})
+	sum.RecordErrored(campaign.Repo{FullRepoName: "org/repo4"}, errors.New("synthetic error"))
+	sum.DurationSeconds = 3600
+	return sum
+}
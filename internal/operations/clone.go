@@ -0,0 +1,277 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package operations
+
+import (
+	"context"
+	"os"
+	"path"
+	"time"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/forge"
+	"github.com/skyscanner/turbolift/internal/git"
+	"github.com/skyscanner/turbolift/internal/github"
+	"github.com/skyscanner/turbolift/internal/hooks"
+	"github.com/skyscanner/turbolift/internal/logging"
+)
+
+// CloneOptions sets how Clone clones a repo. The zero value forks only the repos that cannot be pushed to.
+type CloneOptions struct {
+	// Fork forks the repo even if it can be pushed to
+	Fork bool
+	// SyncFork fast-forwards the default branch of a fork from upstream before the campaign branch is created
+	SyncFork bool
+	// NewIteration gives working copies that already exist the campaign branch, rather than skipping them, for
+	// campaign branches that have not been cloned before
+	NewIteration bool
+}
+
+// CloneOutcome is what Clone did to a repo
+type CloneOutcome struct {
+	Outcome
+	// Rename is set if the repo turned out to have been renamed, in which case it was cloned under its new name
+	Rename *campaign.Rename
+	// Existed is true if the repo's working copy was already there
+	Existed bool
+}
+
+// NewIteration is true if the campaign's branch has not been cloned before, but the campaign has been run on another
+// branch, so that working copies that already exist need the new branch
+func NewIteration(state *campaign.State, dir *campaign.Campaign) bool {
+	return !state.HasBranch(dir.BranchName) && (len(state.Branches) > 0 || dir.BranchName != dir.Name)
+}
+
+// Clone clones a repo into its working copy, forking it if it cannot be pushed to, and creates the campaign branch,
+// running the pre-clone and post-clone hooks around it. Repos that have been renamed are cloned under their new name.
+func Clone(ctx context.Context, logger *logging.Logger, clients Clients, dir *campaign.Campaign, repo campaign.Repo, options CloneOptions) CloneOutcome {
+	var outcome CloneOutcome
+	client := forge.For(repo, clients.GitHub, clients.Bitbucket)
+	if name, err := client.ResolveRepoName(ctx, logger.Writer(), repo.FullRepoName); github.IsRepoGone(err) {
+		logger.Warnf("Skipping %s: %s", repo.FullRepoName, err)
+		outcome.Outcome = skipped(err.Error())
+		return outcome
+	} else if err != nil {
+		logger.Warnf("Unable to check whether %s has been renamed: %s", repo.FullRepoName, err)
+	} else if name != repo.FullRepoName {
+		renamed, err := followRename(logger, repo, name)
+		if err != nil {
+			logger.Errorf("Unable to follow the rename of %s to %s: %s", repo.FullRepoName, name, err)
+			outcome.Outcome = errored(err)
+			return outcome
+		}
+		outcome.Rename = &campaign.Rename{From: repo.FullRepoName, To: name, Noticed: time.Now()}
+		repo = renamed
+	}
+	if _, err := os.Stat(repo.FullRepoPath()); !os.IsNotExist(err) {
+		outcome.Existed = true
+	}
+	outcome.Outcome = clone(ctx, logger, clients, dir, repo, options)
+	return outcome
+}
+
+func clone(ctx context.Context, logger *logging.Logger, clients Clients, dir *campaign.Campaign, repo campaign.Repo, options CloneOptions) Outcome {
+	client := forge.For(repo, clients.GitHub, clients.Bitbucket)
+	orgDirPath := path.Join("work", repo.OrgName)       // i.e. work/org
+	repoDirPath := path.Join(orgDirPath, repo.RepoName) // i.e. work/org/repo
+
+	// Determine whether we need to fork or clone
+	fork := options.Fork
+	if !fork {
+		res, err := client.IsPushable(ctx, logger.Writer(), repo.FullRepoName)
+		if err != nil {
+			logger.Warnf("Unable to determine if we can push to %s: %s", repo.FullRepoName, err)
+			fork = true
+		} else {
+			fork = !res
+		}
+	}
+
+	// the pre-clone hook runs from the campaign directory, as there is no working copy yet
+	if _, err := os.Stat(repoDirPath); os.IsNotExist(err) {
+		if err := hooks.RunAsActivity(ctx, clients.Hooks, logger, ".", campaign.PreCloneHook, dir, repo); err != nil {
+			return errored(err)
+		}
+	}
+
+	var cloneActivity *logging.Activity
+	if fork {
+		cloneActivity = logger.StartActivity("Forking and cloning %s into %s/%s", repo.FullRepoName, orgDirPath, repo.RepoName)
+	} else {
+		cloneActivity = logger.StartActivity("Cloning %s into %s/%s", repo.FullRepoName, orgDirPath, repo.RepoName)
+	}
+
+	if err := os.MkdirAll(orgDirPath, os.ModeDir|0o755); err != nil {
+		cloneActivity.EndWithFailuref("Unable to create org directory: %s", err)
+		return errored(err)
+	}
+
+	// skip if the working copy is already cloned, unless it needs a branch for a new iteration of the campaign
+	cloned := false
+	if _, err := os.Stat(repoDirPath); !os.IsNotExist(err) {
+		if !options.NewIteration {
+			cloneActivity.EndWithWarningf("Directory already exists")
+			return skipped("directory already exists")
+		}
+		cloneActivity.EndWithWarningf("Directory already exists, so only creating branch %s", dir.BranchName)
+	} else {
+		// repos scoped to a path start with nothing checked out, and only fetch the files under the path
+		var gitArgs []string
+		if repo.Path != "" {
+			gitArgs = git.SparseCloneArgs
+		}
+		if fork {
+			err = client.ForkAndClone(ctx, cloneActivity.Writer(), orgDirPath, repo.FullRepoName, gitArgs...)
+		} else {
+			err = client.Clone(ctx, cloneActivity.Writer(), orgDirPath, repo.FullRepoName, gitArgs...)
+		}
+		if err != nil {
+			cloneActivity.EndWithFailure(err)
+			return errored(err)
+		}
+
+		cloneActivity.EndWithSuccess()
+		cloned = true
+
+		if repo.Path != "" {
+			if err := sparseCheckout(ctx, logger, clients, repoDirPath, repo); err != nil {
+				return errored(err)
+			}
+		}
+	}
+
+	if fork && options.SyncFork {
+		if err := syncForkFromUpstream(ctx, logger, clients, repoDirPath, repo); err != nil {
+			return errored(err)
+		}
+	}
+
+	createBranchActivity := logger.StartActivity("Creating branch %s in %s", dir.BranchName, repo.FullRepoName)
+	if err := clients.Git.Checkout(ctx, createBranchActivity.Writer(), repoDirPath, dir.BranchName); err != nil {
+		createBranchActivity.EndWithFailure(err)
+		return errored(err)
+	}
+	createBranchActivity.EndWithSuccess()
+
+	if !cloned {
+		return done()
+	}
+
+	if fork {
+		pullFromUpstreamActivity := logger.StartActivity("Pulling latest changes from %s", repo.FullRepoName)
+		defaultBranch := repo.DefaultBranch
+		if defaultBranch == "" {
+			var err error
+			defaultBranch, err = client.GetDefaultBranchName(ctx, pullFromUpstreamActivity.Writer(), repoDirPath, repo.FullRepoName)
+			if err != nil {
+				pullFromUpstreamActivity.EndWithFailure(err)
+				return errored(err)
+			}
+		}
+		if err := clients.Git.Pull(ctx, pullFromUpstreamActivity.Writer(), repoDirPath, "upstream", defaultBranch); err != nil {
+			pullFromUpstreamActivity.EndWithFailure(err)
+			logger.Printf("\nWe weren't able to pull the latest upstream changes into your fork of %s. This is probably because you have a pre-existing fork with commits ahead of upstream. Please change this or delete your fork, and try again.\n", repo.FullRepoName)
+			return errored(err)
+		}
+		pullFromUpstreamActivity.EndWithSuccess()
+	}
+
+	if err := hooks.RunAsActivity(ctx, clients.Hooks, logger, repoDirPath, campaign.PostCloneHook, dir, repo); err != nil {
+		return errored(err)
+	}
+	return done()
+}
+
+// RecordClones notes in the campaign state that the campaign's branch has been cloned, and which repos were found to
+// have been renamed, then saves it
+func RecordClones(state *campaign.State, dir *campaign.Campaign, existed bool, renames []campaign.Rename) error {
+	// campaigns cloned before branches were recorded used the campaign name as their branch
+	if len(state.Branches) == 0 && existed && dir.BranchName != dir.Name {
+		state.AddBranch(dir.Name)
+	}
+	state.AddBranch(dir.BranchName)
+	for _, rename := range renames {
+		state.RecordRename(rename)
+	}
+	return state.Save()
+}
+
+// sparseCheckout checks out only the campaign's path within the repo
+func sparseCheckout(ctx context.Context, logger *logging.Logger, clients Clients, repoDirPath string, repo campaign.Repo) error {
+	sparseActivity := logger.StartActivity("Checking out %s in %s", repo.Path, repo.FullRepoName)
+	if err := clients.Git.SparseCheckout(ctx, sparseActivity.Writer(), repoDirPath, repo.Path); err != nil {
+		sparseActivity.EndWithFailure(err)
+		return err
+	}
+	if _, err := os.Stat(repo.WorkingDir()); os.IsNotExist(err) {
+		sparseActivity.EndWithWarningf("%s does not exist in %s", repo.Path, repo.FullRepoName)
+		return nil
+	}
+	sparseActivity.EndWithSuccess()
+	return nil
+}
+
+// syncForkFromUpstream brings the fork's default branch up to date with upstream, so that the campaign branch does not
+// start from a stale fork. It returns an error if this was not possible.
+func syncForkFromUpstream(ctx context.Context, logger *logging.Logger, clients Clients, repoDirPath string, repo campaign.Repo) error {
+	syncActivity := logger.StartActivity("Syncing fork of %s from upstream", repo.FullRepoName)
+
+	defaultBranch := repo.DefaultBranch
+	if defaultBranch == "" {
+		var err error
+		defaultBranch, err = forge.For(repo, clients.GitHub, clients.Bitbucket).GetDefaultBranchName(ctx, syncActivity.Writer(), repoDirPath, repo.FullRepoName)
+		if err != nil {
+			syncActivity.EndWithFailure(err)
+			return err
+		}
+	}
+
+	if err := clients.Git.SyncFork(ctx, syncActivity.Writer(), repoDirPath, defaultBranch); err != nil {
+		syncActivity.EndWithFailuref("Unable to fast-forward %s from upstream, probably because the fork has commits that upstream does not: %s", defaultBranch, err)
+		return err
+	}
+	syncActivity.EndWithSuccess()
+	return nil
+}
+
+// followRename switches to the new name of a repo that has been renamed or transferred to another org, moving any
+// working copy cloned under its old name to match. Git remotes keep the old name, which GitHub redirects.
+func followRename(logger *logging.Logger, repo campaign.Repo, name string) (campaign.Repo, error) {
+	renamed, err := repo.Renamed(name)
+	if err != nil {
+		return campaign.Repo{}, err
+	}
+
+	oldPath := repo.FullRepoPath()
+	newPath := renamed.FullRepoPath()
+	if _, err := os.Stat(oldPath); os.IsNotExist(err) || oldPath == newPath {
+		logger.Warnf("%s has been renamed to %s, so using its new name", repo.FullRepoName, name)
+		return renamed, nil
+	}
+	if _, err := os.Stat(newPath); !os.IsNotExist(err) {
+		logger.Warnf("%s has been renamed to %s, which is already cloned into %s, so leaving %s alone", repo.FullRepoName, name, newPath, oldPath)
+		return renamed, nil
+	}
+
+	if err := os.MkdirAll(path.Dir(newPath), os.ModeDir|0o755); err != nil {
+		return campaign.Repo{}, err
+	}
+	if err := os.Rename(oldPath, newPath); err != nil {
+		return campaign.Repo{}, err
+	}
+	logger.Warnf("%s has been renamed to %s, so moved its working copy to %s", repo.FullRepoName, name, newPath)
+	return renamed, nil
+}
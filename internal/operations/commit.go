@@ -0,0 +1,153 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package operations
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/git"
+	"github.com/skyscanner/turbolift/internal/hooks"
+	"github.com/skyscanner/turbolift/internal/logging"
+)
+
+// What to do with the changes in a repo, once they have been reviewed
+const (
+	ReviewCommit = iota
+	ReviewSkip
+	ReviewAbort
+)
+
+// CommitOptions sets how Commit commits the changes in a repo
+type CommitOptions struct {
+	// Message is the commit message, which may be empty when amending, to keep the message of the last commit
+	Message string
+	// Git sets how the commit is signed and attributed, and whether it amends the last campaign commit
+	Git git.CommitOptions
+	// Review is asked what to do with the changes, if set, once they have been found. It is given the activity that
+	// found them to end.
+	Review func(activity *logging.Activity) int
+}
+
+// GitCommitOptions combines the campaign's commit configuration with any overrides, which win if given
+func GitCommitOptions(config campaign.CommitConfig, overrides git.CommitOptions) git.CommitOptions {
+	return git.CommitOptions{
+		GpgSign:        overrides.GpgSign || config.GpgSign,
+		Signoff:        overrides.Signoff || config.Signoff,
+		AuthorName:     firstNonEmpty(overrides.AuthorName, config.AuthorName),
+		AuthorEmail:    firstNonEmpty(overrides.AuthorEmail, config.AuthorEmail),
+		CommitterName:  firstNonEmpty(overrides.CommitterName, config.CommitterName),
+		CommitterEmail: firstNonEmpty(overrides.CommitterEmail, config.CommitterEmail),
+		Amend:          overrides.Amend,
+	}
+}
+
+// Commit commits the changes in a repo's working copy, running the pre-commit and post-commit hooks around it. Repos
+// with no changes are skipped, as are repos that already have a commit with the message on the campaign branch.
+func Commit(ctx context.Context, logger *logging.Logger, clients Clients, dir *campaign.Campaign, repo campaign.Repo, options CommitOptions) Outcome {
+	repoDirPath := repo.FullRepoPath()
+	amend := options.Git.Amend
+
+	// the pre-commit hook may well introduce changes itself, so it runs before checking for them
+	if _, err := os.Stat(repoDirPath); err == nil {
+		if err := hooks.RunAsActivity(ctx, clients.Hooks, logger, repoDirPath, campaign.PreCommitHook, dir, repo); err != nil {
+			return errored(err)
+		}
+	}
+
+	activityFormat := "Committing changes in %s"
+	if options.Review != nil {
+		activityFormat = "Checking for changes in %s"
+	}
+	commitActivity := logger.StartActivity(activityFormat, repo.FullRepoName)
+
+	// skip if the working copy does not exist
+	if _, err := os.Stat(repoDirPath); os.IsNotExist(err) {
+		commitActivity.EndWithWarningf("Directory %s does not exist - has it been cloned?", repoDirPath)
+		return skipped("not cloned")
+	}
+
+	isChanged, err := clients.Git.IsRepoChanged(ctx, commitActivity.Writer(), repoDirPath)
+	if err != nil {
+		commitActivity.EndWithFailure(err)
+		return errored(err)
+	}
+
+	// rewording the last commit needs no changes, but keeping its message would leave it as it was
+	if !isChanged && (!amend || options.Message == "") {
+		commitActivity.EndWithWarning("No changes - skipping commit")
+		return skipped("no changes")
+	}
+
+	commits, err := clients.Git.CampaignCommits(ctx, commitActivity.Writer(), repoDirPath, repo.DefaultBranch)
+	if err != nil {
+		commitActivity.EndWithFailure(err)
+		return errored(err)
+	}
+	// amending with nothing committed yet would rewrite the last commit on the default branch
+	if amend && len(commits) == 0 {
+		commitActivity.EndWithWarning("No campaign commit to amend - skipping commit")
+		return skipped("nothing to amend")
+	}
+	if !amend && hasCommit(commits, options.Message) {
+		commitActivity.EndWithWarning("A commit with this message is already on the branch - give each commit its own message, or use --amend to add to it")
+		return skipped("duplicate commit message")
+	}
+
+	if options.Review != nil {
+		switch options.Review(commitActivity) {
+		case ReviewSkip:
+			return skipped("skipped in review")
+		case ReviewAbort:
+			return Outcome{Aborted: true}
+		}
+		commitActivity = logger.StartActivity("Committing changes in %s", repo.FullRepoName)
+	}
+
+	err = clients.Git.Commit(ctx, commitActivity.Writer(), repoDirPath, options.Message, options.Git)
+	if err != nil {
+		commitActivity.EndWithFailure(err)
+		return errored(err)
+	}
+	commitActivity.EndWithSuccess()
+
+	if err := hooks.RunAsActivity(ctx, clients.Hooks, logger, repoDirPath, campaign.PostCommitHook, dir, repo); err != nil {
+		return errored(err)
+	}
+	return done()
+}
+
+// hasCommit is true if one of the commits has the subject of the message, i.e. its first line
+func hasCommit(subjects []string, message string) bool {
+	subject := strings.SplitN(message, "\n", 2)[0]
+	for _, s := range subjects {
+		if s == strings.TrimSpace(subject) {
+			return true
+		}
+	}
+	return false
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
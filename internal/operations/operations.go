@@ -0,0 +1,107 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package operations holds what turbolift does to each of a campaign's repos, so that the commands of the CLI and
+// programs that use pkg/turbolift clone, commit and raise PRs in the same way. Each operation logs its progress as
+// activities and returns its outcome, leaving the campaign-level concerns of a run, such as resuming, prompting and
+// summarising, to its caller.
+package operations
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/executor"
+	"github.com/skyscanner/turbolift/internal/git"
+	"github.com/skyscanner/turbolift/internal/github"
+	"github.com/skyscanner/turbolift/internal/hooks"
+	"github.com/skyscanner/turbolift/internal/summary"
+)
+
+// Clients are what operations use to work on repos. Commands pass in their own, so that their tests can substitute
+// fakes.
+type Clients struct {
+	GitHub    github.GitHub
+	Bitbucket github.GitHub
+	Git       git.Git
+	Hooks     hooks.Hooks
+	Executor  executor.Executor
+}
+
+// Outcome is what an operation did to a repo
+type Outcome struct {
+	// Skipped says why the repo was left alone, if it was, and SkippedAs is the category it is counted under, if any
+	Skipped   string
+	SkippedAs string
+	// Err is why the operation failed for the repo, if it did
+	Err error
+	// Finished is true if a resumed run need not do the repo again, which may be so even if it was skipped or errored
+	Finished bool
+	// Aborted is true if the run was stopped before the operation finished with the repo, so that nothing is recorded
+	// for it, and the remaining repos should be left alone too
+	Aborted bool
+}
+
+func done() Outcome {
+	return Outcome{Finished: true}
+}
+
+func skipped(reason string) Outcome {
+	return Outcome{Skipped: reason}
+}
+
+func skippedAs(category string, reason string) Outcome {
+	return Outcome{Skipped: reason, SkippedAs: category}
+}
+
+func errored(err error) Outcome {
+	return Outcome{Err: err}
+}
+
+// Record notes the outcome for the repo in the summary
+func (o Outcome) Record(sum *summary.Summary, repo campaign.Repo) {
+	switch {
+	case o.Aborted:
+	case o.Err != nil:
+		sum.RecordErrored(repo, o.Err)
+	case o.SkippedAs != "":
+		sum.RecordSkippedAs(repo, o.SkippedAs, o.Skipped)
+	case o.Skipped != "":
+		sum.RecordSkipped(repo, o.Skipped)
+	default:
+		sum.RecordDone(repo)
+	}
+}
+
+// SkippedAs returns the category to count a repo under when an action on its PR failed because there was nothing to
+// do, such as there being no PR or the PR already being merged, and false for other errors
+func SkippedAs(err error) (string, bool) {
+	var noPRFoundErr *github.NoPRFoundError
+	var notOpenErr *github.PrNotOpenError
+	var notClosedErr *github.PrNotClosedError
+	var archivedErr *github.RepoArchivedError
+	switch {
+	case errors.As(err, &noPRFoundErr):
+		return summary.SkippedNoPR, true
+	case errors.As(err, &archivedErr):
+		return summary.SkippedArchived, true
+	case errors.As(err, &notOpenErr):
+		return strings.ToLower(notOpenErr.State), true
+	case errors.As(err, &notClosedErr):
+		return strings.ToLower(notClosedErr.State), true
+	}
+	return "", false
+}
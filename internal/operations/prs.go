@@ -0,0 +1,567 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package operations
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/forge"
+	"github.com/skyscanner/turbolift/internal/git"
+	"github.com/skyscanner/turbolift/internal/github"
+	"github.com/skyscanner/turbolift/internal/hooks"
+	"github.com/skyscanner/turbolift/internal/interrupt"
+	"github.com/skyscanner/turbolift/internal/logging"
+	"github.com/skyscanner/turbolift/internal/messages"
+	"github.com/skyscanner/turbolift/internal/prbody"
+	"github.com/skyscanner/turbolift/internal/summary"
+)
+
+// PushRetryDelay is how long to wait before pushing again, when a push was rejected for what looks like a temporary
+// reason
+var PushRetryDelay = 10 * time.Second
+
+// the longest diff, in lines, that is included in a PR description with a diff summary
+const maxDiffSummaryLines = 200
+
+// The summaries of a repo's changes that can be appended to its PR description
+const (
+	DiffSummaryFiles = "files"
+	DiffSummaryDiff  = "diff"
+)
+
+// CreatePROptions sets how CreatePR raises a repo's PR. The zero value raises a PR that is ready for review.
+type CreatePROptions struct {
+	// Pipeline is the chain of processors that the PR description is passed through
+	Pipeline *prbody.Pipeline
+	Draft    bool
+	// ForceWithLease force-pushes the campaign branch, unless someone else has pushed to it since it was last fetched
+	ForceWithLease bool
+	// DiffSummary appends a summary of the changes to the PR description, if it is DiffSummaryFiles or DiffSummaryDiff
+	DiffSummary string
+	// AutoMerge turns on auto-merge for the PR with this merge strategy, if not empty
+	AutoMerge string
+	// UpdateExisting brings the title and description of a PR that already exists up to date, rather than skipping it
+	UpdateExisting bool
+	// Siblings are the other campaigns that target the repo, whose open PRs are checked for changes to the same files
+	Siblings []string
+}
+
+// CreatePROutcome is what CreatePR did to a repo
+type CreatePROutcome struct {
+	Outcome
+	// Url is the PR that was created or found, if the forge said which it was
+	Url string
+	// PR is the PR to record in the campaign state, if there is one and the forge gave its number
+	PR *campaign.TrackedPR
+	// Rejection is why the remote rejected the push, if it did
+	Rejection *git.Rejection
+	// Conflicting is true if open PRs of sibling campaigns touch the same files
+	Conflicting bool
+}
+
+// WithBaseBranch has the PR of a repo that is not given a default-branch in the repos file raised against the base
+// branch instead, if there is one. Everything from the push to auto-merge then treats it as the repo's default branch.
+func WithBaseBranch(repo campaign.Repo, baseBranch string) campaign.Repo {
+	if repo.DefaultBranch == "" {
+		repo.DefaultBranch = baseBranch
+	}
+	return repo
+}
+
+// CreatePR pushes the campaign branch of a repo and raises a PR for it, with the campaign's title and description,
+// running the pre-create-pr and post-create-pr hooks around it. Repos with nothing committed are skipped, as are repos
+// that already have a PR, unless it is to be updated.
+func CreatePR(ctx context.Context, logger *logging.Logger, clients Clients, dir *campaign.Campaign, repo campaign.Repo, options CreatePROptions) CreatePROutcome {
+	var outcome CreatePROutcome
+	client := forge.For(repo, clients.GitHub, clients.Bitbucket)
+	repoDirPath := repo.FullRepoPath()
+
+	if _, err := os.Stat(repoDirPath); err == nil {
+		if err := hooks.RunAsActivity(ctx, clients.Hooks, logger, repoDirPath, campaign.PreCreatePrHook, dir, repo); err != nil {
+			outcome.Outcome = errored(err)
+			return outcome
+		}
+	}
+
+	pushActivity := logger.StartActivity("Pushing changes in %s to origin", repo.FullRepoName)
+	// skip if the working copy does not exist
+	if _, err := os.Stat(repoDirPath); os.IsNotExist(err) {
+		pushActivity.EndWithWarningf("Directory %s does not exist - has it been cloned?", repoDirPath)
+		outcome.Outcome = skipped("not cloned")
+		return outcome
+	}
+
+	// pushing the campaign branch would then change the branch that its PR is meant to be merged into
+	if repo.DefaultBranch == dir.BranchName {
+		err := fmt.Errorf("the campaign branch %s is the base branch of %s, so PRs cannot be raised from it", dir.BranchName, repo.FullRepoName)
+		pushActivity.EndWithFailure(err)
+		outcome.Outcome = errored(err)
+		return outcome
+	}
+
+	// scripts often leave some repos unchanged, which should not get empty PRs
+	ahead, aheadErr := clients.Git.IsAheadOfDefaultBranch(ctx, pushActivity.Writer(), repoDirPath, repo.DefaultBranch)
+	if aheadErr != nil {
+		pushActivity.Logf("Unable to check for committed changes, so pushing anyway: %s", aheadErr)
+	} else if !ahead {
+		pushActivity.EndWithWarning("No changes committed - skipping push and PR")
+		outcome.Outcome = skipped("no changes committed")
+		return outcome
+	}
+
+	retried, err := push(ctx, pushActivity, clients, repoDirPath, dir.BranchName, options.ForceWithLease)
+	if rejectedErr, ok := git.AsPushRejected(err); ok {
+		rejection := rejectedErr.Classify()
+		outcome.Rejection = &rejection
+		pushActivity.EndWithFailuref("%s\n  Rejected because: %s\n  Fix: %s", err, rejection.Reason, rejection.Hint)
+		outcome.Outcome = errored(err)
+		return outcome
+	} else if err != nil {
+		pushActivity.EndWithFailure(err)
+		outcome.Outcome = errored(err)
+		return outcome
+	}
+	if aheadErr != nil || retried {
+		pushActivity.EndWithSuccessAndEmitLogs()
+	} else {
+		pushActivity.EndWithSuccess()
+	}
+
+	if len(options.Siblings) > 0 {
+		outcome.Conflicting = checkForConflicts(ctx, logger, clients, repoDirPath, repo, options.Siblings)
+	}
+
+	var renderOutput bytes.Buffer
+	title, body, renderErr := renderPrDescription(ctx, &renderOutput, options.Pipeline, dir, repo)
+	var checklist []string
+	if renderErr == nil {
+		checklist, renderErr = dir.ChecklistForRepo(repo)
+	}
+
+	var labels []string
+	if len(dir.Config.Labels) > 0 && renderErr == nil {
+		labels = categoriseChanges(ctx, logger, clients, repoDirPath, repo, dir)
+	}
+	if options.DiffSummary != "" && renderErr == nil {
+		body = withDiffSummary(ctx, logger, clients, dir.Messages, repoDirPath, repo, body, options.DiffSummary)
+	}
+
+	var createPrActivity *logging.Activity
+	if options.Draft {
+		createPrActivity = logger.StartActivity("Creating Draft PR in %s", repo.FullRepoName)
+	} else {
+		createPrActivity = logger.StartActivity("Creating PR in %s", repo.FullRepoName)
+	}
+
+	if renderErr != nil {
+		_, _ = renderOutput.WriteTo(createPrActivity.Writer())
+		createPrActivity.EndWithFailure(renderErr)
+		outcome.Outcome = errored(renderErr)
+		return outcome
+	}
+
+	pullRequest := github.PullRequest{
+		Title:        title,
+		Body:         github.WithCampaignMarker(github.WithChecklist(body, dir.Messages.Format(messages.ChecklistHeading, nil), checklist, ""), dir.Name),
+		UpstreamRepo: repo.FullRepoName,
+		IsDraft:      options.Draft,
+		Labels:       labels,
+		BaseBranch:   repo.DefaultBranch,
+	}
+
+	created, err := client.CreatePullRequest(ctx, createPrActivity.Writer(), repoDirPath, pullRequest)
+
+	var existsErr *github.PrExistsError
+	if errors.As(err, &existsErr) {
+		outcome.Url = existsErr.Url
+		if number := github.PrNumberFromUrl(existsErr.Url); number > 0 {
+			outcome.PR = trackedPR(repo, dir.BranchName, number, existsErr.Url)
+		}
+	} else if err == nil && created != nil {
+		outcome.Url = created.Url
+		if created.Number > 0 {
+			outcome.PR = trackedPR(repo, dir.BranchName, created.Number, created.Url)
+		}
+	}
+
+	switch {
+	case github.IsRepoGone(err):
+		createPrActivity.EndWithWarning(err)
+		outcome.Outcome = skipped(err.Error())
+	case errors.As(err, &existsErr) && !options.UpdateExisting:
+		createPrActivity.EndWithWarningf("%s - use --update-existing to update its title and description", err)
+		outcome.Outcome = skipped(err.Error())
+		outcome.Finished = true
+	case errors.As(err, &existsErr):
+		pullRequest.Body = github.WithCampaignMarker(withTickedChecklist(ctx, createPrActivity.Writer(), client, repoDirPath, dir, body, checklist), dir.Name)
+		if err := updateExistingPr(ctx, createPrActivity, client, repoDirPath, pullRequest, existsErr); err != nil {
+			outcome.Outcome = errored(err)
+			return outcome
+		}
+		if options.AutoMerge != "" {
+			enableAutoMerge(ctx, logger, client, repoDirPath, repo, dir, options.AutoMerge)
+		}
+		outcome.Outcome = done()
+	case err != nil:
+		createPrActivity.EndWithFailure(err)
+		outcome.Outcome = errored(err)
+	case created == nil:
+		createPrActivity.EndWithWarningf("No PR created in %s", repo.FullRepoName)
+		outcome.Outcome = skipped("no PR created")
+	default:
+		createPrActivity.EndWithSuccess()
+		if options.AutoMerge != "" {
+			enableAutoMerge(ctx, logger, client, repoDirPath, repo, dir, options.AutoMerge)
+		}
+		// the PR exists now, so a resumed run must not try to create it again, even if the hook fails
+		outcome.Outcome = done()
+		if err := hooks.RunAsActivity(ctx, clients.Hooks, logger, repoDirPath, campaign.PostCreatePrHook, dir, repo); err != nil {
+			outcome.Err = err
+		}
+	}
+	return outcome
+}
+
+// UpdatePRDescription sets the title and description of a repo's PR to the campaign's current ones, keeping the
+// checklist items that reviewers have already ticked. Repos with no PR, or whose PR has been merged or closed, are
+// skipped.
+func UpdatePRDescription(ctx context.Context, logger *logging.Logger, clients Clients, dir *campaign.Campaign, repo campaign.Repo, pipeline *prbody.Pipeline) Outcome {
+	client := forge.For(repo, clients.GitHub, clients.Bitbucket)
+	updatePrActivity := logger.StartActivity("Updating PR description in %s", repo.FullRepoName)
+
+	// skip if the working copy does not exist
+	if _, err := os.Stat(repo.FullRepoPath()); os.IsNotExist(err) {
+		updatePrActivity.EndWithWarningf("Directory %s does not exist - has it been cloned?", repo.FullRepoPath())
+		return skippedAs(summary.SkippedNotCloned, "not cloned")
+	}
+
+	title, body, err := renderPrDescription(ctx, updatePrActivity.Writer(), pipeline, dir, repo)
+	if err != nil {
+		updatePrActivity.EndWithFailure(err)
+		return errored(err)
+	}
+	checklist, err := dir.ChecklistForRepo(repo)
+	if err != nil {
+		updatePrActivity.EndWithFailure(err)
+		return errored(err)
+	}
+
+	pr, err := client.GetPR(ctx, updatePrActivity.Writer(), repo.FullRepoPath(), dir.BranchName)
+	if err == nil {
+		// merged and closed PRs, and those in archived repos, are left as they were
+		err = github.RequireOpen(ctx, client, updatePrActivity.Writer(), repo.FullRepoPath(), pr)
+	}
+	if category, ok := SkippedAs(err); ok {
+		updatePrActivity.EndWithWarning(err)
+		return skippedForGood(category, err.Error())
+	} else if err != nil {
+		updatePrActivity.EndWithFailure(err)
+		return errored(err)
+	}
+
+	// keep the items that reviewers have already ticked
+	body = github.WithChecklist(body, dir.Messages.Format(messages.ChecklistHeading, nil), checklist, pr.Body)
+	err = client.UpdatePRDescription(ctx, updatePrActivity.Writer(), repo.FullRepoPath(), title, github.WithCampaignMarker(body, dir.Name))
+	if err != nil {
+		updatePrActivity.EndWithFailure(err)
+		return errored(err)
+	}
+	updatePrActivity.EndWithSuccess()
+	return done()
+}
+
+// withTickedChecklist adds the checklist to the body of a PR that already exists, keeping the items that reviewers have
+// already ticked in its description
+func withTickedChecklist(ctx context.Context, output io.Writer, client github.GitHub, repoDirPath string, dir *campaign.Campaign, body string, checklist []string) string {
+	if len(checklist) == 0 {
+		return body
+	}
+	previousBody := ""
+	if pr, err := client.GetPR(ctx, output, repoDirPath, dir.BranchName); err == nil {
+		previousBody = pr.Body
+	}
+	return github.WithChecklist(body, dir.Messages.Format(messages.ChecklistHeading, nil), checklist, previousBody)
+}
+
+// ClosePR closes a repo's campaign PR. Repos with no PR, or whose PR has already been merged or closed, are skipped.
+func ClosePR(ctx context.Context, logger *logging.Logger, clients Clients, dir *campaign.Campaign, repo campaign.Repo) Outcome {
+	closeActivity := logger.StartActivity("Closing PR in %s", repo.FullRepoName)
+	// skip if the working copy does not exist
+	if _, err := os.Stat(repo.FullRepoPath()); os.IsNotExist(err) {
+		closeActivity.EndWithWarningf("Directory %s does not exist - has it been cloned?", repo.FullRepoPath())
+		return skippedAs(summary.SkippedNotCloned, "not cloned")
+	}
+
+	err := forge.For(repo, clients.GitHub, clients.Bitbucket).ClosePullRequest(ctx, closeActivity.Writer(), repo.FullRepoPath(), dir.BranchName)
+	if category, ok := SkippedAs(err); ok {
+		closeActivity.EndWithWarning(err)
+		return skippedForGood(category, err.Error())
+	} else if err != nil {
+		closeActivity.EndWithFailure(err)
+		return errored(err)
+	}
+	closeActivity.EndWithSuccess()
+	return done()
+}
+
+// ReopenPR reopens a repo's campaign PR that was closed without being merged. Repos with no PR, or whose PR is open or
+// has been merged, are skipped.
+func ReopenPR(ctx context.Context, logger *logging.Logger, clients Clients, dir *campaign.Campaign, repo campaign.Repo) Outcome {
+	reopenActivity := logger.StartActivity("Reopening PR in %s", repo.FullRepoName)
+	// skip if the working copy does not exist
+	if _, err := os.Stat(repo.FullRepoPath()); os.IsNotExist(err) {
+		reopenActivity.EndWithWarningf("Directory %s does not exist - has it been cloned?", repo.FullRepoPath())
+		return skippedAs(summary.SkippedNotCloned, "not cloned")
+	}
+
+	err := forge.For(repo, clients.GitHub, clients.Bitbucket).ReopenPullRequest(ctx, reopenActivity.Writer(), repo.FullRepoPath(), dir.BranchName)
+	if category, ok := SkippedAs(err); ok {
+		reopenActivity.EndWithWarning(err)
+		return skippedForGood(category, err.Error())
+	} else if err != nil {
+		reopenActivity.EndWithFailure(err)
+		return errored(err)
+	}
+	reopenActivity.EndWithSuccess()
+	return done()
+}
+
+// skippedForGood skips a repo whose PR is already as the operation would leave it, or can no longer be changed, so that
+// a resumed run need not look at it again. Repos with no PR are looked at again, in case one is raised in the meantime.
+func skippedForGood(category string, reason string) Outcome {
+	outcome := skippedAs(category, reason)
+	outcome.Finished = category != summary.SkippedNoPR
+	return outcome
+}
+
+// RecordPRs stores the PRs that were created or found in the campaign state, so that later commands and
+// turbolift list-prs can look them up by their number rather than from their branch
+func RecordPRs(prs []campaign.TrackedPR) error {
+	if len(prs) == 0 {
+		return nil
+	}
+	state, err := campaign.ReadState()
+	if err != nil {
+		return err
+	}
+	for _, pr := range prs {
+		state.RecordPR(pr)
+	}
+	return state.Save()
+}
+
+func trackedPR(repo campaign.Repo, branch string, number int, url string) *campaign.TrackedPR {
+	return &campaign.TrackedPR{Repo: repo.FullRepoName, Branch: branch, Number: number, Url: url, Created: time.Now()}
+}
+
+// push pushes the branch to origin, trying once more if the push was rejected for what looks like a temporary reason,
+// such as a hook timing out. It returns true if it pushed again.
+func push(ctx context.Context, activity *logging.Activity, clients Clients, repoDirPath string, branch string, forceWithLease bool) (bool, error) {
+	pushBranch := clients.Git.Push
+	if forceWithLease {
+		pushBranch = clients.Git.ForcePush
+	}
+	err := pushBranch(ctx, activity.Writer(), repoDirPath, "origin", branch)
+	rejectedErr, ok := git.AsPushRejected(err)
+	if !ok || !rejectedErr.Classify().Retryable {
+		return false, err
+	}
+
+	activity.Logf("Push rejected (%s), so trying again in %s", rejectedErr.Classify().Reason, PushRetryDelay)
+	select {
+	case <-interrupt.Stopping(ctx).Done():
+		return false, err
+	case <-time.After(PushRetryDelay):
+	}
+	return true, pushBranch(ctx, activity.Writer(), repoDirPath, "origin", branch)
+}
+
+// updateExistingPr brings the title and description of a PR created by an earlier run up to date. The post-create-pr
+// hook is not run again, as the PR was not created this time.
+func updateExistingPr(ctx context.Context, activity *logging.Activity, client github.GitHub, repoDirPath string, pr github.PullRequest, existsErr *github.PrExistsError) error {
+	activity.Logf("%s, so updating its title and description", existsErr)
+	if err := client.UpdatePRDescription(ctx, activity.Writer(), repoDirPath, pr.Title, pr.Body); err != nil {
+		activity.EndWithFailure(err)
+		return err
+	}
+	activity.EndWithSuccessAndEmitLogs()
+	return nil
+}
+
+// enableAutoMerge turns on auto-merge for a newly created PR. Failing to do so, for example because the repo does not
+// allow auto-merge, does not stop the PR from being created, so is only a warning.
+func enableAutoMerge(ctx context.Context, logger *logging.Logger, client github.GitHub, repoDirPath string, repo campaign.Repo, dir *campaign.Campaign, autoMerge string) {
+	autoMergeActivity := logger.StartActivity("Enabling auto-merge (%s) for PR in %s", autoMerge, repo.FullRepoName)
+	strategy, err := github.MergeStrategyFor(ctx, client, autoMergeActivity.Writer(), repoDirPath, repo.DefaultBranch, autoMerge)
+	if err != nil {
+		autoMergeActivity.EndWithWarningf("Unable to check whether %s requires linear history: %s", repo.FullRepoName, err)
+		return
+	}
+	if strategy != autoMerge {
+		autoMergeActivity.Logf("%s requires linear history, so the PR will be merged with %s rather than %s", repo.FullRepoName, strategy, autoMerge)
+	}
+	if err := client.EnableAutoMerge(ctx, autoMergeActivity.Writer(), repoDirPath, dir.BranchName, strategy); err != nil {
+		autoMergeActivity.EndWithWarningf("Unable to enable auto-merge: %s", err)
+		return
+	}
+	if strategy != autoMerge {
+		autoMergeActivity.EndWithSuccessAndEmitLogs()
+		return
+	}
+	autoMergeActivity.EndWithSuccess()
+}
+
+// checkForConflicts warns about open PRs from the sibling campaigns that touch any of the files changed in this repo.
+// It returns true if any were found.
+func checkForConflicts(ctx context.Context, logger *logging.Logger, clients Clients, repoDirPath string, repo campaign.Repo, siblings []string) bool {
+	conflictActivity := logger.StartActivity("Checking for conflicting campaign PRs in %s", repo.FullRepoName)
+
+	changedFiles, err := clients.Git.ChangedFiles(ctx, conflictActivity.Writer(), repoDirPath, repo.DefaultBranch)
+	if err != nil {
+		conflictActivity.EndWithWarningf("Unable to list changed files: %s", err)
+		return false
+	}
+	openPRs, err := forge.For(repo, clients.GitHub, clients.Bitbucket).ListOpenPRs(ctx, conflictActivity.Writer(), repoDirPath, repo.FullRepoName)
+	if err != nil {
+		conflictActivity.EndWithWarningf("Unable to list open PRs: %s", err)
+		return false
+	}
+
+	changed := map[string]bool{}
+	for _, file := range changedFiles {
+		changed[file] = true
+	}
+	isSibling := map[string]bool{}
+	for _, sibling := range siblings {
+		isSibling[sibling] = true
+	}
+
+	var conflicts []string
+	for _, pr := range openPRs {
+		if !isSibling[pr.Campaign()] {
+			continue
+		}
+		for _, path := range pr.Paths() {
+			if changed[path] {
+				conflicts = append(conflicts, fmt.Sprintf("%s already has an open campaign PR touching %s from campaign %s (%s)", repo.FullRepoName, path, pr.Campaign(), pr.Url))
+			}
+		}
+	}
+
+	if len(conflicts) == 0 {
+		conflictActivity.EndWithSuccess()
+		return false
+	}
+	for _, conflict := range conflicts {
+		conflictActivity.Log(conflict)
+	}
+	conflictActivity.EndWithWarningf("%d possible conflicts with other campaigns", len(conflicts))
+	return true
+}
+
+// renderPrDescription fills in any template actions in the PR title with the repo's details, and passes the body
+// through the campaign's pipeline of processors
+func renderPrDescription(ctx context.Context, output io.Writer, pipeline *prbody.Pipeline, dir *campaign.Campaign, repo campaign.Repo) (string, string, error) {
+	title, err := dir.RenderTextForRepo(dir.PrTitle, repo)
+	if err != nil {
+		return "", "", err
+	}
+	body, err := pipeline.Render(ctx, output, dir.PrBody, repo)
+	if err != nil {
+		return "", "", err
+	}
+	return title, body, nil
+}
+
+// categoriseChanges works out which of the campaign's configured labels apply to the files changed in this repo
+func categoriseChanges(ctx context.Context, logger *logging.Logger, clients Clients, repoDirPath string, repo campaign.Repo, dir *campaign.Campaign) []string {
+	labelActivity := logger.StartActivity("Categorising changes in %s", repo.FullRepoName)
+
+	changedFiles, err := clients.Git.ChangedFiles(ctx, labelActivity.Writer(), repoDirPath, repo.DefaultBranch)
+	if err != nil {
+		labelActivity.EndWithWarningf("Unable to list changed files, so the PR will not be labelled: %s", err)
+		return nil
+	}
+
+	labels := dir.LabelsFor(changedFiles)
+	if len(labels) == 0 {
+		labelActivity.EndWithSuccess()
+		return labels
+	}
+	labelActivity.Logf("Labelling PR with %s", strings.Join(labels, ", "))
+	labelActivity.EndWithSuccessAndEmitLogs()
+	return labels
+}
+
+// withDiffSummary appends a summary of this repo's changes to the PR body, so that reviewers of simple changes need not open the diff.
+// If the changes cannot be read, the body is returned unchanged.
+func withDiffSummary(ctx context.Context, logger *logging.Logger, clients Clients, catalog *messages.Catalog, repoDirPath string, repo campaign.Repo, body string, diffSummary string) string {
+	summaryActivity := logger.StartActivity("Summarising changes in %s", repo.FullRepoName)
+
+	var summary string
+	if diffSummary == DiffSummaryFiles {
+		changedFiles, err := clients.Git.ChangedFiles(ctx, summaryActivity.Writer(), repoDirPath, repo.DefaultBranch)
+		if err != nil {
+			summaryActivity.EndWithWarningf("Unable to list changed files, so the PR description will not include them: %s", err)
+			return body
+		}
+		summary = filesChangedSummary(catalog, changedFiles)
+	} else {
+		diff, err := clients.Git.Diff(ctx, summaryActivity.Writer(), repoDirPath, repo.DefaultBranch)
+		if err != nil {
+			summaryActivity.EndWithWarningf("Unable to read the diff, so the PR description will not include it: %s", err)
+			return body
+		}
+		summary = diffHunksSummary(catalog, diff)
+	}
+
+	summaryActivity.EndWithSuccess()
+	return strings.TrimRight(body, "\n") + "\n\n" + summary
+}
+
+func filesChangedSummary(catalog *messages.Catalog, files []string) string {
+	var sb strings.Builder
+	sb.WriteString(catalog.Format(messages.FilesChangedHeading, messages.Fields{"Count": len(files)}) + "\n\n")
+	for _, file := range files {
+		sb.WriteString(fmt.Sprintf("- `%s`\n", file))
+	}
+	return sb.String()
+}
+
+func diffHunksSummary(catalog *messages.Catalog, diff string) string {
+	lines := strings.Split(strings.TrimRight(diff, "\n"), "\n")
+	truncated := len(lines) > maxDiffSummaryLines
+	if truncated {
+		lines = lines[:maxDiffSummaryLines]
+	}
+
+	var sb strings.Builder
+	sb.WriteString(catalog.Format(messages.ChangesHeading, nil) + "\n\n```diff\n")
+	sb.WriteString(strings.Join(lines, "\n"))
+	sb.WriteString("\n```\n")
+	if truncated {
+		sb.WriteString("\n" + catalog.Format(messages.DiffTruncated, messages.Fields{"Lines": maxDiffSummaryLines}) + "\n")
+	}
+	return sb.String()
+}
@@ -0,0 +1,36 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package operations
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilesChangedSummary(t *testing.T) {
+	assert.Equal(t, "### Files changed (2)\n\n- `go.mod`\n- `go.sum`\n", filesChangedSummary(nil, []string{"go.mod", "go.sum"}))
+}
+
+func TestDiffHunksSummaryTruncatesLongDiffs(t *testing.T) {
+	assert.Equal(t, "### Changes\n\n```diff\n-old\n+new\n```\n", diffHunksSummary(nil, "-old\n+new\n"))
+
+	longDiff := strings.Repeat("+line\n", maxDiffSummaryLines+10)
+	summary := diffHunksSummary(nil, longDiff)
+	assert.Equal(t, maxDiffSummaryLines, strings.Count(summary, "+line"))
+	assert.Contains(t, summary, "The diff has been truncated to 200 lines")
+}
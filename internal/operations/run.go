@@ -0,0 +1,45 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package operations
+
+import (
+	"context"
+	"io"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/executor"
+)
+
+// RunOptions sets how Run runs a command in a repo. The zero value runs it on the local machine.
+type RunOptions struct {
+	// Container is where the command is run, if not on the local machine
+	Container *executor.Container
+	// Stdout is also given what the command prints to stdout, if set
+	Stdout io.Writer
+}
+
+// Run runs a command in a repo's working copy, or the campaign's path within it, with the repo's details in
+// TURBOLIFT_* environment variables
+func Run(ctx context.Context, output io.Writer, clients Clients, dir *campaign.Campaign, repo campaign.Repo, options RunOptions, commandName string, commandArgs ...string) error {
+	env := executor.RepoEnv(dir, repo)
+	if options.Container != nil {
+		commandName, commandArgs = options.Container.Command(repo, env, commandName, commandArgs...)
+	}
+	if options.Stdout == nil {
+		return clients.Executor.ExecuteWithEnv(ctx, output, repo.WorkingDir(), env, commandName, commandArgs...)
+	}
+	return clients.Executor.ExecuteCapturingStdoutWithEnv(ctx, output, options.Stdout, repo.WorkingDir(), env, commandName, commandArgs...)
+}
@@ -0,0 +1,123 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package parallel
+
+import (
+	"context"
+	"strings"
+
+	"github.com/skyscanner/turbolift/internal/interrupt"
+)
+
+// Controller adapts the number of jobs run at once to how well they are going. It starts at the maximum, and uses
+// additive increase and multiplicative decrease: a rate limit halves the number of workers, any other failure removes
+// one, and after as many successes in a row as there are workers, one is added back, up to the maximum.
+type Controller struct {
+	max       int
+	current   int
+	successes int
+}
+
+func NewController(max int) *Controller {
+	if max < 1 {
+		max = 1
+	}
+	return &Controller{max: max, current: max}
+}
+
+// Limit is the number of jobs that should currently be running at once
+func (c *Controller) Limit() int {
+	return c.current
+}
+
+// Record adjusts the limit after a job has finished, given its error and output. It returns true if the limit changed.
+func (c *Controller) Record(err error, output string) bool {
+	previous := c.current
+
+	switch {
+	case err != nil && IsRateLimited(output+err.Error()):
+		c.current /= 2
+		c.successes = 0
+	case err != nil:
+		c.current--
+		c.successes = 0
+	default:
+		c.successes++
+		if c.successes >= c.current {
+			c.current++
+			c.successes = 0
+		}
+	}
+
+	if c.current < 1 {
+		c.current = 1
+	} else if c.current > c.max {
+		c.current = c.max
+	}
+	return c.current != previous
+}
+
+var rateLimitMessages = []string{
+	"rate limit",
+	"abuse detection",
+	"HTTP 429",
+	"too many requests",
+}
+
+// IsRateLimited checks whether output from git or gh shows that GitHub has started rate limiting requests
+func IsRateLimited(output string) bool {
+	lower := strings.ToLower(output)
+	for _, message := range rateLimitMessages {
+		if strings.Contains(lower, strings.ToLower(message)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Run runs count jobs, with no more running at once than the controller's limit, which is adjusted as jobs finish.
+// Each job is given its index and returns its output and error. done is called for each job as it finishes, from the
+// calling goroutine, so it need not be safe for concurrent use; it is also given whether the limit changed as a result.
+// Once ctx is done no more jobs are started, but Run still waits for those already running to finish.
+func Run(ctx context.Context, count int, controller *Controller, job func(i int) (string, error), done func(i int, output string, err error, limitChanged bool)) {
+	type result struct {
+		i      int
+		output string
+		err    error
+	}
+	results := make(chan result)
+
+	next := 0
+	running := 0
+	for (next < count && !interrupt.Requested(ctx)) || running > 0 {
+		for next < count && running < controller.Limit() && !interrupt.Requested(ctx) {
+			go func(i int) {
+				output, err := job(i)
+				results <- result{i: i, output: output, err: err}
+			}(next)
+			next++
+			running++
+		}
+
+		if running == 0 {
+			break
+		}
+		r := <-results
+		running--
+		changed := controller.Record(r.err, r.output)
+		done(r.i, r.output, r.err, changed)
+	}
+}
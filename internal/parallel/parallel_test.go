@@ -0,0 +1,125 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package parallel
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestControllerHalvesWorkersWhenRateLimited(t *testing.T) {
+	c := NewController(8)
+
+	assert.True(t, c.Record(errors.New("exit status 1"), "API rate limit exceeded for user"))
+	assert.Equal(t, 4, c.Limit())
+	assert.True(t, c.Record(errors.New("HTTP 429: Too Many Requests"), ""))
+	assert.Equal(t, 2, c.Limit())
+}
+
+func TestControllerRemovesAWorkerOnOtherFailures(t *testing.T) {
+	c := NewController(3)
+
+	assert.True(t, c.Record(errors.New("exit status 1"), "fatal: not a git repository"))
+	assert.Equal(t, 2, c.Limit())
+	c.Record(errors.New("exit status 1"), "")
+	c.Record(errors.New("exit status 1"), "")
+	assert.Equal(t, 1, c.Limit(), "there is always at least one worker")
+}
+
+func TestControllerRampsBackUpWhenHealthy(t *testing.T) {
+	c := NewController(4)
+	c.Record(errors.New("secondary rate limit"), "")
+	assert.Equal(t, 2, c.Limit())
+
+	assert.False(t, c.Record(nil, ""))
+	assert.True(t, c.Record(nil, ""))
+	assert.Equal(t, 3, c.Limit())
+
+	for i := 0; i < 10; i++ {
+		c.Record(nil, "")
+	}
+	assert.Equal(t, 4, c.Limit(), "the number of workers never exceeds the maximum")
+}
+
+func TestRunNeverExceedsTheLimit(t *testing.T) {
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	release := make(chan struct{})
+
+	c := NewController(3)
+	finished := []int{}
+	go func() {
+		for i := 0; i < 10; i++ {
+			release <- struct{}{}
+		}
+	}()
+	Run(context.Background(), 10, c, func(i int) (string, error) {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+
+		<-release
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return "", nil
+	}, func(i int, output string, err error, limitChanged bool) {
+		finished = append(finished, i)
+	})
+
+	assert.Len(t, finished, 10)
+	assert.LessOrEqual(t, maxRunning, 3)
+}
+
+func TestRunReducesConcurrencyAfterFailures(t *testing.T) {
+	c := NewController(4)
+	var changes []int
+	Run(context.Background(), 4, c, func(i int) (string, error) {
+		return "You have exceeded a secondary rate limit", errors.New("exit status 1")
+	}, func(i int, output string, err error, limitChanged bool) {
+		if limitChanged {
+			changes = append(changes, c.Limit())
+		}
+	})
+
+	assert.Equal(t, []int{2, 1}, changes)
+}
+
+func TestRunStartsNoMoreJobsOnceCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := NewController(1)
+	started := []int{}
+	Run(ctx, 5, c, func(i int) (string, error) {
+		started = append(started, i)
+		return "", nil
+	}, func(i int, output string, err error, limitChanged bool) {
+		if i == 1 {
+			cancel()
+		}
+	})
+
+	assert.Equal(t, []int{0, 1}, started)
+}
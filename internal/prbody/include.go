@@ -0,0 +1,168 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package prbody
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+)
+
+var includeRegexp = regexp.MustCompile(`\{\{\s*include\s+"([^"]+)"(?:\s+"([^"]+)")?\s*\}\}`)
+
+// maxIncludeDepth limits how deeply snippets may include other snippets, which stops a snippet including itself forever
+const maxIncludeDepth = 10
+
+// includeCacheDirectory is where snippets fetched from URLs are kept, so that they are not fetched again for every repo
+var includeCacheDirectory = filepath.Join(campaign.StateDirectory, "includes")
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// include replaces each {{ include "path" }} directive with the contents of the file, relative to the campaign directory,
+// or of the URL if the path is one. A directive may pin the snippet to a checksum, as in
+// {{ include "https://example.com/footer.md" "sha256:<hex digest>" }}, to fail if it has changed unexpectedly.
+// Included snippets may include others in turn.
+func include(cacheFor time.Duration) processor {
+	return func(ctx context.Context, output io.Writer, body string, _ campaign.Repo) (string, error) {
+		return includeSnippets(ctx, output, cacheFor, body, 0)
+	}
+}
+
+func includeSnippets(ctx context.Context, output io.Writer, cacheFor time.Duration, body string, depth int) (string, error) {
+	var includeErr error
+	result := includeRegexp.ReplaceAllStringFunc(body, func(directive string) string {
+		if includeErr != nil {
+			return directive
+		}
+		match := includeRegexp.FindStringSubmatch(directive)
+		path, checksum := match[1], match[2]
+		if depth >= maxIncludeDepth {
+			includeErr = fmt.Errorf("unable to include %s: snippets are included more than %d deep", path, maxIncludeDepth)
+			return directive
+		}
+
+		var contents []byte
+		var err error
+		if isUrl(path) {
+			contents, err = fetchSnippet(ctx, output, path, checksum, cacheFor)
+		} else {
+			contents, err = os.ReadFile(path)
+			if err == nil {
+				err = verifyChecksum(contents, checksum)
+			}
+		}
+		if err != nil {
+			includeErr = fmt.Errorf("unable to include %s: %w", path, err)
+			return directive
+		}
+
+		snippet, err := includeSnippets(ctx, output, cacheFor, strings.TrimRight(string(contents), "\n"), depth+1)
+		if err != nil {
+			includeErr = err
+			return directive
+		}
+		return snippet
+	})
+	return result, includeErr
+}
+
+func isUrl(path string) bool {
+	return strings.HasPrefix(path, "https://") || strings.HasPrefix(path, "http://")
+}
+
+// fetchSnippet returns the snippet at the URL, from the cache if it was fetched recently enough, or if it matches its
+// pinned checksum, which means it cannot have changed. A snippet that cannot be fetched falls back to any cached copy.
+func fetchSnippet(ctx context.Context, output io.Writer, url string, checksum string, cacheFor time.Duration) ([]byte, error) {
+	cacheFile := filepath.Join(includeCacheDirectory, cacheKey(url))
+	cached, cacheErr := os.ReadFile(cacheFile)
+	if cacheErr == nil {
+		if checksum != "" {
+			if verifyChecksum(cached, checksum) == nil {
+				return cached, nil
+			}
+		} else if info, err := os.Stat(cacheFile); err == nil && time.Since(info.ModTime()) < cacheFor {
+			return cached, nil
+		}
+	}
+
+	contents, err := fetch(ctx, url)
+	if err != nil {
+		if cacheErr != nil || checksum != "" {
+			return nil, err
+		}
+		_, _ = fmt.Fprintf(output, "Unable to fetch %s, so using the cached copy: %s\n", url, err)
+		return cached, nil
+	}
+	if err := verifyChecksum(contents, checksum); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(includeCacheDirectory, os.ModeDir|0o755); err != nil {
+		return nil, fmt.Errorf("unable to create %s: %w", includeCacheDirectory, err)
+	}
+	if err := os.WriteFile(cacheFile, contents, 0o644); err != nil {
+		return nil, fmt.Errorf("unable to cache snippet: %w", err)
+	}
+	return contents, nil
+}
+
+func fetch(ctx context.Context, url string) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching it returned %s", response.Status)
+	}
+	return io.ReadAll(response.Body)
+}
+
+// verifyChecksum checks the contents against a checksum given as sha256:<hex digest>. An empty checksum is not pinned
+// and always matches.
+func verifyChecksum(contents []byte, checksum string) error {
+	if checksum == "" {
+		return nil
+	}
+	if !strings.HasPrefix(checksum, "sha256:") {
+		return fmt.Errorf("unsupported checksum %s: must be given as sha256:<hex digest>", checksum)
+	}
+	digest := sha256.Sum256(contents)
+	if actual := hex.EncodeToString(digest[:]); !strings.EqualFold(actual, strings.TrimPrefix(checksum, "sha256:")) {
+		return fmt.Errorf("its checksum is sha256:%s, not the pinned %s", actual, checksum)
+	}
+	return nil
+}
+
+func cacheKey(url string) string {
+	digest := sha256.Sum256([]byte(url))
+	return hex.EncodeToString(digest[:])
+}
@@ -0,0 +1,145 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package prbody
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/testsupport"
+)
+
+var includeConfig = campaign.PrBodyConfig{Processors: []string{"include"}}
+
+// serveSnippet serves the snippet, counting how many times it was fetched
+func serveSnippet(t *testing.T, snippet *string, fetches *int) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*fetches++
+		if *snippet == "" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(*snippet))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func sha256Of(s string) string {
+	digest := sha256.Sum256([]byte(s))
+	return "sha256:" + hex.EncodeToString(digest[:])
+}
+
+func TestItIncludesSnippetsFromUrlsAndCachesThem(t *testing.T) {
+	testsupport.PrepareTempCampaign(false)
+	snippet, fetches := "Report security issues to #security.\n", 0
+	server := serveSnippet(t, &snippet, &fetches)
+
+	for i := 0; i < 2; i++ {
+		body, err := render(t, includeConfig, `Body {{ include "`+server.URL+`/footer.md" }}`)
+		assert.NoError(t, err)
+		assert.Equal(t, "Body Report security issues to #security.", body)
+	}
+	assert.Equal(t, 1, fetches)
+}
+
+func TestItFetchesSnippetsAgainOnceTheCacheHasExpired(t *testing.T) {
+	testsupport.PrepareTempCampaign(false)
+	snippet, fetches := "old footer", 0
+	server := serveSnippet(t, &snippet, &fetches)
+	directive := `{{ include "` + server.URL + `/footer.md" }}`
+
+	_, err := render(t, includeConfig, directive)
+	assert.NoError(t, err)
+
+	snippet = "new footer"
+	expired := time.Now().Add(-2 * time.Hour)
+	_ = os.Chtimes(filepath.Join(includeCacheDirectory, cacheKey(server.URL+"/footer.md")), expired, expired)
+
+	body, err := render(t, includeConfig, directive)
+	assert.NoError(t, err)
+	assert.Equal(t, "new footer", body)
+	assert.Equal(t, 2, fetches)
+}
+
+func TestItFallsBackToTheCachedSnippetWhenItCannotBeFetched(t *testing.T) {
+	testsupport.PrepareTempCampaign(false)
+	snippet, fetches := "footer", 0
+	server := serveSnippet(t, &snippet, &fetches)
+	directive := `{{ include "` + server.URL + `/footer.md" }}`
+	config := campaign.PrBodyConfig{Processors: []string{"include"}, IncludeCacheFor: time.Nanosecond}
+
+	_, err := render(t, config, directive)
+	assert.NoError(t, err)
+
+	snippet = ""
+	body, err := render(t, config, directive)
+	assert.NoError(t, err)
+	assert.Equal(t, "footer", body)
+	assert.Equal(t, 2, fetches)
+}
+
+func TestItChecksPinnedSnippets(t *testing.T) {
+	testsupport.PrepareTempCampaign(false)
+	snippet, fetches := "approved footer", 0
+	server := serveSnippet(t, &snippet, &fetches)
+	url := server.URL + "/footer.md"
+
+	body, err := render(t, includeConfig, `{{ include "`+url+`" "`+sha256Of("approved footer")+`" }}`)
+	assert.NoError(t, err)
+	assert.Equal(t, "approved footer", body)
+
+	snippet = "tampered footer"
+	_, err = render(t, includeConfig, `{{ include "`+url+`" "`+sha256Of("approved footer")+`" }}`)
+	assert.NoError(t, err, "the pinned snippet is still cached, so is not fetched again")
+	assert.Equal(t, 1, fetches)
+
+	_, err = render(t, includeConfig, `{{ include "`+url+`" "`+sha256Of("newer footer")+`" }}`)
+	assert.Contains(t, err.Error(), "unable to include "+url+": its checksum is "+sha256Of("tampered footer")+", not the pinned "+sha256Of("newer footer"))
+
+	_, err = render(t, includeConfig, `{{ include "`+url+`" "md5:1234" }}`)
+	assert.Contains(t, err.Error(), "unsupported checksum md5:1234")
+}
+
+func TestItChecksPinnedLocalSnippets(t *testing.T) {
+	testsupport.PrepareTempCampaign(false)
+	_ = os.WriteFile("footer.md", []byte("local footer\n"), 0o644)
+
+	body, err := render(t, includeConfig, `{{ include "footer.md" "`+sha256Of("local footer\n")+`" }}`)
+	assert.NoError(t, err)
+	assert.Equal(t, "local footer", body)
+
+	_, err = render(t, includeConfig, `{{ include "footer.md" "`+sha256Of("other")+`" }}`)
+	assert.Contains(t, err.Error(), "unable to include footer.md: its checksum is")
+}
+
+func TestItReportsSnippetsThatCannotBeFetched(t *testing.T) {
+	testsupport.PrepareTempCampaign(false)
+	snippet, fetches := "", 0
+	server := serveSnippet(t, &snippet, &fetches)
+
+	_, err := render(t, includeConfig, `{{ include "`+server.URL+`/missing.md" }}`)
+	assert.Contains(t, err.Error(), "fetching it returned 404 Not Found")
+}
@@ -0,0 +1,177 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package prbody passes a campaign's PR description through the chain of processors configured for it, so that
+// standard boilerplate can be kept in shared snippets and included by reference
+package prbody
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/executor"
+)
+
+var execInstance executor.Executor = executor.NewRealExecutor()
+
+// processor transforms a PR body for a repo
+type processor func(ctx context.Context, output io.Writer, body string, repo campaign.Repo) (string, error)
+
+// Pipeline is the chain of processors that a campaign's PR body is passed through for each repo
+type Pipeline struct {
+	names      []string
+	processors []processor
+}
+
+// ForCampaign builds the pipeline configured for the campaign in turbolift.yaml
+func ForCampaign(dir *campaign.Campaign) *Pipeline {
+	config := dir.Config.PrBody
+	p := &Pipeline{}
+	for _, name := range dir.Config.BodyProcessors() {
+		p.names = append(p.names, name)
+		switch name {
+		case campaign.IncludeProcessor:
+			p.processors = append(p.processors, include(config.IncludeCacheDuration()))
+		case campaign.TemplateProcessor:
+			p.processors = append(p.processors, expandTemplate)
+		case campaign.TocProcessor:
+			p.processors = append(p.processors, tableOfContents)
+		case campaign.LinksProcessor:
+			p.processors = append(p.processors, rewriteLinks(config.Links))
+		case campaign.CommandProcessor:
+			p.processors = append(p.processors, runCommand(config.Command))
+		}
+	}
+	return p
+}
+
+// Render passes the body through each processor in turn. Any output from commands run along the way goes to output.
+func (p *Pipeline) Render(ctx context.Context, output io.Writer, body string, repo campaign.Repo) (string, error) {
+	for i, process := range p.processors {
+		var err error
+		body, err = process(ctx, output, body, repo)
+		if err != nil {
+			return "", fmt.Errorf("pr-body %s processor failed: %w", p.names[i], err)
+		}
+	}
+	return body, nil
+}
+
+func expandTemplate(_ context.Context, _ io.Writer, body string, repo campaign.Repo) (string, error) {
+	return campaign.RenderForRepo(body, repo)
+}
+
+// tocMarker is replaced with the table of contents
+const tocMarker = "<!-- toc -->"
+
+var (
+	headingRegexp   = regexp.MustCompile(`^(#{2,3})\s+(.+?)\s*#*\s*$`)
+	anchorRemovable = regexp.MustCompile(`[^\p{L}\p{N}\s_-]`)
+)
+
+// tableOfContents replaces a <!-- toc --> line with a list of links to the body's second- and third-level headings,
+// using the same anchors as GitHub. Bodies without the marker are left unchanged.
+func tableOfContents(_ context.Context, _ io.Writer, body string, _ campaign.Repo) (string, error) {
+	if !strings.Contains(body, tocMarker) {
+		return body, nil
+	}
+
+	var toc []string
+	anchors := map[string]int{}
+	inCodeBlock := false
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inCodeBlock = !inCodeBlock
+			continue
+		}
+		match := headingRegexp.FindStringSubmatch(line)
+		if inCodeBlock || match == nil {
+			continue
+		}
+
+		anchor := headingAnchor(match[2])
+		if seen := anchors[anchor]; seen > 0 {
+			anchors[anchor]++
+			anchor = fmt.Sprintf("%s-%d", anchor, seen)
+		} else {
+			anchors[anchor] = 1
+		}
+		indent := strings.Repeat("  ", len(match[1])-2)
+		toc = append(toc, fmt.Sprintf("%s- [%s](#%s)", indent, match[2], anchor))
+	}
+
+	return strings.Replace(body, tocMarker, strings.Join(toc, "\n"), 1), nil
+}
+
+func headingAnchor(heading string) string {
+	anchor := anchorRemovable.ReplaceAllString(strings.ToLower(heading), "")
+	return strings.ReplaceAll(anchor, " ", "-")
+}
+
+var linkTargetRegexp = regexp.MustCompile(`(\]\(|\]:\s*|<)([^)\s>]+)`)
+
+// rewriteLinks changes the start of link targets that begin with one of the prefixes, trying the longest first
+func rewriteLinks(prefixes map[string]string) processor {
+	var from []string
+	for prefix := range prefixes {
+		from = append(from, prefix)
+	}
+	sort.Slice(from, func(i, j int) bool { return len(from[i]) > len(from[j]) })
+
+	return func(_ context.Context, _ io.Writer, body string, _ campaign.Repo) (string, error) {
+		return linkTargetRegexp.ReplaceAllStringFunc(body, func(link string) string {
+			match := linkTargetRegexp.FindStringSubmatch(link)
+			for _, prefix := range from {
+				if strings.HasPrefix(match[2], prefix) {
+					return match[1] + prefixes[prefix] + strings.TrimPrefix(match[2], prefix)
+				}
+			}
+			return link
+		}), nil
+	}
+}
+
+// runCommand runs a shell command to process the body, passing it the path of a file holding the body as $1 and
+// the repo's name as $2. Whatever the command prints becomes the new body.
+func runCommand(command string) processor {
+	return func(ctx context.Context, output io.Writer, body string, repo campaign.Repo) (string, error) {
+		file, err := os.CreateTemp("", "turbolift-pr-body-*.md")
+		if err != nil {
+			return "", err
+		}
+		defer func() { _ = os.Remove(file.Name()) }()
+		if _, err := file.WriteString(body); err != nil {
+			_ = file.Close()
+			return "", err
+		}
+		if err := file.Close(); err != nil {
+			return "", err
+		}
+
+		var stdout bytes.Buffer
+		shell, shellArgs := executor.ShellCommand(command, file.Name(), repo.FullRepoName)
+		if err := execInstance.ExecuteCapturingStdout(ctx, output, &stdout, ".", shell, shellArgs...); err != nil {
+			return "", err
+		}
+		return strings.TrimRight(stdout.String(), "\n"), nil
+	}
+}
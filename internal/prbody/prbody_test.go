@@ -0,0 +1,108 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package prbody
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/executor"
+	"github.com/skyscanner/turbolift/internal/testsupport"
+)
+
+var repo = campaign.Repo{OrgName: "org", RepoName: "repo1", FullRepoName: "org/repo1"}
+
+func render(t *testing.T, config campaign.PrBodyConfig, body string) (string, error) {
+	dir := &campaign.Campaign{Config: campaign.Config{PrBody: config}}
+	return ForCampaign(dir).Render(context.Background(), &strings.Builder{}, body, repo)
+}
+
+func TestItOnlyExpandsTemplatesByDefaultWhenTemplatingIsOn(t *testing.T) {
+	dir := &campaign.Campaign{Config: campaign.Config{Templating: true}}
+	body, err := ForCampaign(dir).Render(context.Background(), &strings.Builder{}, "Changes to {{ .RepoName }}\n<!-- toc -->", repo)
+	assert.NoError(t, err)
+	assert.Equal(t, "Changes to repo1\n<!-- toc -->", body)
+}
+
+func TestItLeavesTheBodyAsWrittenByDefault(t *testing.T) {
+	workflow := "Adds a workflow step:\n\n```yaml\nenv:\n  TOKEN: ${{ secrets.TOKEN }}\n```"
+	body, err := render(t, campaign.PrBodyConfig{}, workflow)
+	assert.NoError(t, err)
+	assert.Equal(t, workflow, body)
+}
+
+func TestItIncludesSnippetsBeforeExpandingTemplates(t *testing.T) {
+	testsupport.PrepareTempCampaign(false)
+	_ = os.MkdirAll("snippets", 0o755)
+	_ = os.WriteFile("snippets/footer.md", []byte("Questions? Ask the owners of {{ .RepoName }}.\n{{ include \"snippets/contact.md\" }}\n"), 0o644)
+	_ = os.WriteFile("snippets/contact.md", []byte("#platform-help\n"), 0o644)
+
+	body, err := render(t, campaign.PrBodyConfig{Processors: []string{"include", "template"}}, "Body\n\n{{ include \"snippets/footer.md\" }}")
+	assert.NoError(t, err)
+	assert.Equal(t, "Body\n\nQuestions? Ask the owners of repo1.\n#platform-help", body)
+}
+
+func TestItReportsMissingAndRecursiveSnippets(t *testing.T) {
+	testsupport.PrepareTempCampaign(false)
+	_ = os.WriteFile("loop.md", []byte(`{{ include "loop.md" }}`), 0o644)
+
+	_, err := render(t, campaign.PrBodyConfig{Processors: []string{"include"}}, `{{ include "missing.md" }}`)
+	assert.Contains(t, err.Error(), "pr-body include processor failed: unable to include missing.md")
+
+	_, err = render(t, campaign.PrBodyConfig{Processors: []string{"include"}}, `{{ include "loop.md" }}`)
+	assert.Contains(t, err.Error(), "unable to include loop.md: snippets are included more than 10 deep")
+}
+
+func TestItGeneratesATableOfContents(t *testing.T) {
+	body := "Intro\n\n<!-- toc -->\n\n## Why now?\n\n### Rollout plan\n\n```\n## not a heading\n```\n\n## Why now?\n"
+	rendered, err := render(t, campaign.PrBodyConfig{Processors: []string{"toc"}}, body)
+	assert.NoError(t, err)
+	assert.Equal(t, "Intro\n\n- [Why now?](#why-now)\n  - [Rollout plan](#rollout-plan)\n- [Why now?](#why-now-1)\n\n## Why now?\n\n### Rollout plan\n\n```\n## not a heading\n```\n\n## Why now?\n", rendered)
+}
+
+func TestItRewritesLinksWithTheLongestMatchingPrefix(t *testing.T) {
+	config := campaign.PrBodyConfig{
+		Processors: []string{"links"},
+		Links: map[string]string{
+			"https://wiki.old.example.com/":      "https://wiki.example.com/",
+			"https://wiki.old.example.com/team/": "https://teams.example.com/",
+		},
+	}
+	body := "See [the guide](https://wiki.old.example.com/guide), [us](https://wiki.old.example.com/team/platform), " +
+		"<https://wiki.old.example.com/faq> and [elsewhere](https://example.org/old).\n\n[ref]: https://wiki.old.example.com/ref"
+
+	rendered, err := render(t, config, body)
+	assert.NoError(t, err)
+	assert.Equal(t, "See [the guide](https://wiki.example.com/guide), [us](https://teams.example.com/platform), "+
+		"<https://wiki.example.com/faq> and [elsewhere](https://example.org/old).\n\n[ref]: https://wiki.example.com/ref", rendered)
+}
+
+func TestItRunsACommandToProcessTheBody(t *testing.T) {
+	fakeExecutor := executor.NewFakeExecutor(nil, func(_ string, _ string, args ...string) (string, error) {
+		contents, err := os.ReadFile(args[3])
+		return strings.ToUpper(string(contents)) + "\nfor " + args[4] + "\n", err
+	})
+	execInstance = fakeExecutor
+
+	rendered, err := render(t, campaign.PrBodyConfig{Processors: []string{"template", "command"}, Command: "./add-footer.sh"}, "body of {{ .RepoName }}")
+	assert.NoError(t, err)
+	assert.Equal(t, "BODY OF REPO1\nfor org/repo1", rendered)
+}
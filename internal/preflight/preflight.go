@@ -0,0 +1,136 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// preflight checks that PRs could be raised for each repo before create-prs starts pushing, so that missing
+// permissions are found up front rather than part of the way through a run
+
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/git"
+	"github.com/skyscanner/turbolift/internal/interrupt"
+	"github.com/skyscanner/turbolift/internal/logging"
+)
+
+// RepoCheck is what the checks found for one repo
+type RepoCheck struct {
+	Repo campaign.Repo
+	// Skipped gives why the repo was not checked, e.g. because it has not been cloned, or is empty if it was
+	Skipped string
+	// Problems are what would stop create-prs from raising a PR for the repo, each with how to fix it
+	Problems []string
+}
+
+// Ready is true if create-prs should be able to raise a PR for the repo
+func (c RepoCheck) Ready() bool {
+	return c.Skipped == "" && len(c.Problems) == 0
+}
+
+// Check works out whether create-prs could push the campaign branch of the repo's working copy and raise a PR for it
+func Check(ctx context.Context, g git.Git, output io.Writer, repo campaign.Repo, branchName string) RepoCheck {
+	check := RepoCheck{Repo: repo}
+	repoDirPath := repo.FullRepoPath()
+
+	if _, err := os.Stat(repoDirPath); os.IsNotExist(err) {
+		check.Skipped = "not cloned"
+		return check
+	}
+
+	onBranch, err := g.IsOnBranch(ctx, output, repoDirPath, branchName)
+	if err != nil {
+		check.Problems = append(check.Problems, fmt.Sprintf("unable to determine the current branch: %s", err))
+	} else if !onBranch {
+		check.Problems = append(check.Problems, fmt.Sprintf("not on branch %s, so use turbolift verify-clones --repair to switch to it", branchName))
+	}
+	if repo.DefaultBranch == branchName {
+		check.Problems = append(check.Problems, fmt.Sprintf("the campaign branch %s is the base branch of %s, so PRs cannot be raised from it", branchName, repo.FullRepoName))
+	}
+
+	// create-prs skips repos with nothing committed, so whether they could be pushed does not matter
+	if ahead, err := g.IsAheadOfDefaultBranch(ctx, output, repoDirPath, repo.DefaultBranch); err == nil && !ahead && len(check.Problems) == 0 {
+		check.Skipped = "no changes committed"
+		return check
+	}
+
+	isFork, err := g.HasRemote(ctx, output, repoDirPath, "upstream")
+	if err != nil {
+		check.Problems = append(check.Problems, fmt.Sprintf("unable to list remotes: %s", err))
+		return check
+	}
+
+	if err := g.CanPush(ctx, output, repoDirPath, "origin", branchName); err != nil {
+		if isFork {
+			check.Problems = append(check.Problems, fmt.Sprintf("unable to push to your fork: %s - check that the fork still exists and that you can push to it", describePushError(err)))
+		} else {
+			check.Problems = append(check.Problems, fmt.Sprintf("unable to push to %s: %s - ask for write access, or move the working copy aside and clone again, which forks repos that you cannot push to", repo.FullRepoName, describePushError(err)))
+		}
+	}
+
+	// PRs are raised against the repo itself, which for forks is the upstream remote
+	if repo.DefaultBranch != "" {
+		remote := "origin"
+		if isFork {
+			remote = "upstream"
+		}
+		exists, err := g.HasRemoteBranch(ctx, output, repoDirPath, remote, repo.DefaultBranch)
+		if err != nil {
+			check.Problems = append(check.Problems, fmt.Sprintf("unable to check for base branch %s: %s", repo.DefaultBranch, err))
+		} else if !exists {
+			check.Problems = append(check.Problems, fmt.Sprintf("base branch %s does not exist in %s, so fix default-branch in the repos file", repo.DefaultBranch, repo.FullRepoName))
+		}
+	}
+	return check
+}
+
+// describePushError gives the reason that a push would be rejected, if git gave one
+func describePushError(err error) string {
+	if rejectedErr, ok := git.AsPushRejected(err); ok {
+		return rejectedErr.Classify().Reason + " (" + strings.Join(rejectedErr.Messages, " / ") + ")"
+	}
+	return err.Error()
+}
+
+// Run checks each repo in turn, logging what it finds
+func Run(ctx context.Context, g git.Git, logger *logging.Logger, repos []campaign.Repo, branchName string) []RepoCheck {
+	var checks []RepoCheck
+	for i, repo := range repos {
+		if interrupt.Requested(ctx) {
+			logger.Warnf("Interrupted, so not checking the remaining %d repos", len(repos)-i)
+			break
+		}
+
+		checkActivity := logger.StartActivity("Checking that a PR can be raised for %s", repo.FullRepoName)
+		check := Check(ctx, g, checkActivity.Writer(), repo, branchName)
+		switch {
+		case len(check.Problems) > 0:
+			checkActivity.EndWithFailure(strings.Join(check.Problems, "; "))
+		case check.Skipped == "not cloned":
+			checkActivity.EndWithWarningf("Directory %s does not exist - has it been cloned?", repo.FullRepoPath())
+		case check.Skipped != "":
+			checkActivity.EndWithWarningf("No changes committed - create-prs will skip it")
+		default:
+			checkActivity.EndWithSuccess()
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
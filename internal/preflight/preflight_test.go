@@ -0,0 +1,90 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package preflight
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/git"
+	"github.com/skyscanner/turbolift/internal/testsupport"
+)
+
+func TestItFindsNoProblemsWhenTheBranchCanBePushed(t *testing.T) {
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+	fakeGit := git.NewFakeGit(func(_ io.Writer, call []string) (bool, error) {
+		return call[0] != "hasRemote", nil
+	})
+
+	check := Check(context.Background(), fakeGit, &strings.Builder{}, campaign.Repo{OrgName: "org", RepoName: "repo1", FullRepoName: "org/repo1"}, "my-campaign")
+	assert.True(t, check.Ready())
+
+	fakeGit.AssertCalledWith(t, [][]string{
+		{"isOnBranch", "work/org/repo1", "my-campaign"},
+		{"isAheadOfDefaultBranch", "work/org/repo1"},
+		{"hasRemote", "work/org/repo1", "upstream"},
+		{"canPush", "work/org/repo1", "origin", "my-campaign"},
+	})
+}
+
+func TestItReportsPushAccessAndMissingBaseBranches(t *testing.T) {
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+	fakeGit := git.NewFakeGit(func(_ io.Writer, call []string) (bool, error) {
+		switch call[0] {
+		case "canPush":
+			return false, errors.New("remote: Permission to org/repo1.git denied to octocat")
+		case "hasRemote", "hasRemoteBranch":
+			return false, nil
+		}
+		return true, nil
+	})
+
+	repo := campaign.Repo{OrgName: "org", RepoName: "repo1", FullRepoName: "org/repo1", DefaultBranch: "release"}
+	check := Check(context.Background(), fakeGit, &strings.Builder{}, repo, "my-campaign")
+	assert.False(t, check.Ready())
+	assert.Equal(t, []string{
+		"unable to push to org/repo1: remote: Permission to org/repo1.git denied to octocat - ask for write access, or move the working copy aside and clone again, which forks repos that you cannot push to",
+		"base branch release does not exist in org/repo1, so fix default-branch in the repos file",
+	}, check.Problems)
+}
+
+func TestItReportsCampaignBranchesThatAreTheBaseBranch(t *testing.T) {
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+	fakeGit := git.NewAlwaysSucceedsFakeGit()
+
+	repo := campaign.Repo{OrgName: "org", RepoName: "repo1", FullRepoName: "org/repo1", DefaultBranch: "my-campaign"}
+	check := Check(context.Background(), fakeGit, &strings.Builder{}, repo, "my-campaign")
+	assert.Equal(t, []string{"the campaign branch my-campaign is the base branch of org/repo1, so PRs cannot be raised from it"}, check.Problems)
+}
+
+func TestItSkipsReposThatCreatePrsWouldSkip(t *testing.T) {
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+	fakeGit := git.NewFakeGit(func(_ io.Writer, call []string) (bool, error) {
+		return call[0] != "isAheadOfDefaultBranch", nil
+	})
+
+	check := Check(context.Background(), fakeGit, &strings.Builder{}, campaign.Repo{OrgName: "org", RepoName: "repo1", FullRepoName: "org/repo1"}, "my-campaign")
+	assert.Equal(t, "no changes committed", check.Skipped)
+
+	check = Check(context.Background(), fakeGit, &strings.Builder{}, campaign.Repo{OrgName: "org", RepoName: "repo2", FullRepoName: "org/repo2"}, "my-campaign")
+	assert.Equal(t, "not cloned", check.Skipped)
+}
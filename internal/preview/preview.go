@@ -0,0 +1,109 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package preview sizes up the changes made in each repo, so that they can be checked before PRs are opened
+package preview
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/fatih/color"
+	"github.com/rodaine/table"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/git"
+	"github.com/skyscanner/turbolift/internal/interrupt"
+	"github.com/skyscanner/turbolift/internal/logging"
+)
+
+// DefaultLargeDiffLines is how many lines a diff can insert or delete before it looks suspiciously large
+const DefaultLargeDiffLines = 1000
+
+// RepoDiff is the size of the changes made in one repo
+type RepoDiff struct {
+	Repo campaign.Repo
+	Stat git.DiffStat
+}
+
+// Empty is true if nothing has been changed in the repo, so a PR would have nothing in it
+func (d RepoDiff) Empty() bool {
+	return d.Stat.FilesChanged == 0
+}
+
+// Large is true if the diff inserts or deletes more lines than a reviewer could be expected to check
+func (d RepoDiff) Large(largeDiffLines int) bool {
+	return largeDiffLines > 0 && d.Stat.Lines() > largeDiffLines
+}
+
+// Describe explains why a diff has been flagged, or returns an empty string if it has not
+func (d RepoDiff) Describe(largeDiffLines int) string {
+	if d.Empty() {
+		return "empty diff"
+	}
+	if d.Large(largeDiffLines) {
+		return fmt.Sprintf("over %d lines changed", largeDiffLines)
+	}
+	return ""
+}
+
+// Collect measures the changes in each repo's working copy, skipping repos that have not been cloned or cannot be
+// measured
+func Collect(ctx context.Context, g git.Git, logger *logging.Logger, repos []campaign.Repo) []RepoDiff {
+	var diffs []RepoDiff
+	for i, repo := range repos {
+		if interrupt.Requested(ctx) {
+			logger.Warnf("Interrupted, so not measuring the changes in the remaining %d repos", len(repos)-i)
+			break
+		}
+		if _, err := os.Stat(repo.FullRepoPath()); os.IsNotExist(err) {
+			continue
+		}
+
+		diffActivity := logger.StartActivity("Measuring the changes in %s", repo.FullRepoName)
+		stat, err := g.DiffStat(ctx, diffActivity.Writer(), repo.FullRepoPath(), repo.DefaultBranch)
+		if err != nil {
+			diffActivity.EndWithFailure(err)
+			continue
+		}
+		diffActivity.EndWithSuccess()
+		diffs = append(diffs, RepoDiff{Repo: repo, Stat: stat})
+	}
+	return diffs
+}
+
+// Print shows the size of each repo's changes in a table, flagging those that look wrong
+func Print(logger *logging.Logger, diffs []RepoDiff, largeDiffLines int) {
+	diffTable := table.New("Repository", "Files changed", "Insertions", "Deletions", "")
+	diffTable.WithHeaderFormatter(color.New(color.Underline).SprintfFunc())
+	diffTable.WithFirstColumnFormatter(color.New(color.FgCyan).SprintfFunc())
+	diffTable.WithWriter(logger.Writer())
+
+	flagged := 0
+	for _, diff := range diffs {
+		description := diff.Describe(largeDiffLines)
+		if description != "" {
+			flagged++
+		}
+		diffTable.AddRow(diff.Repo.FullRepoName, diff.Stat.FilesChanged, diff.Stat.Insertions, diff.Stat.Deletions, description)
+	}
+
+	diffTable.Print()
+	logger.Println()
+	if flagged > 0 {
+		logger.Warnf("%d of %d repos have an empty or suspiciously large diff", flagged, len(diffs))
+	}
+}
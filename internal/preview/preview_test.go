@@ -0,0 +1,31 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package preview
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/git"
+)
+
+func TestItFlagsEmptyAndLargeDiffs(t *testing.T) {
+	assert.Equal(t, "empty diff", RepoDiff{}.Describe(100))
+	assert.Equal(t, "", RepoDiff{Stat: git.DiffStat{FilesChanged: 1, Insertions: 60, Deletions: 40}}.Describe(100))
+	assert.Equal(t, "over 100 lines changed", RepoDiff{Stat: git.DiffStat{FilesChanged: 1, Insertions: 60, Deletions: 41}}.Describe(100))
+	assert.Equal(t, "", RepoDiff{Stat: git.DiffStat{FilesChanged: 1, Insertions: 6000}}.Describe(0))
+}
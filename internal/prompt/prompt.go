@@ -1,7 +1,9 @@
 package prompt
 
 import (
+	"fmt"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/manifoldco/promptui"
@@ -10,10 +12,53 @@ import (
 
 type Prompt interface {
 	AskConfirm(string) bool
+	// AskTyped asks the question, and is only true if the answer is exactly the expected text
+	AskTyped(question string, expected string) bool
+	// AskChoice asks the question, and returns the choice picked, or an empty string if the prompt was cancelled
+	AskChoice(question string, choices []string) string
 }
 
 type RealPrompt struct{}
 
+// NonInteractive stops RealPrompt from ever prompting, for running unattended. Every question is answered no, as if
+// the prompt had been cancelled, and the first is kept as a NonInteractiveError for the run to fail with.
+var NonInteractive bool
+
+var (
+	refusedMu sync.Mutex
+	refused   error
+)
+
+// NonInteractiveError is a question that would have been asked if prompts were not turned off
+type NonInteractiveError struct {
+	Question string
+	// Hint says how to go ahead without being asked
+	Hint string
+}
+
+func (e *NonInteractiveError) Error() string {
+	return fmt.Sprintf("turbolift needed %q to be answered, but prompts are turned off with --non-interactive - %s", e.Question, e.Hint)
+}
+
+// refuse answers a question that cannot be asked, keeping it if it is the first
+func refuse(question string, hint string) {
+	refusedMu.Lock()
+	defer refusedMu.Unlock()
+	if refused == nil {
+		refused = &NonInteractiveError{Question: question, Hint: hint}
+	}
+}
+
+// TakeRefusal returns the first question that was not asked because of NonInteractive, as a NonInteractiveError, and
+// forgets it. It is nil if every question could be asked.
+func TakeRefusal() error {
+	refusedMu.Lock()
+	defer refusedMu.Unlock()
+	err := refused
+	refused = nil
+	return err
+}
+
 // NewRealPrompt is the factory builder for RealPrompt
 func NewRealPrompt() *RealPrompt {
 	return &RealPrompt{}
@@ -21,6 +66,10 @@ func NewRealPrompt() *RealPrompt {
 
 // AskConfirm will use promptui to provide a confirmation
 func (r *RealPrompt) AskConfirm(confirm string) bool {
+	if NonInteractive {
+		refuse(confirm, "give --yes to go ahead without asking")
+		return false
+	}
 	p := promptui.Prompt{
 		Label:     confirm,
 		IsConfirm: true,
@@ -39,6 +88,40 @@ func (r *RealPrompt) AskConfirm(confirm string) bool {
 	}
 }
 
+// AskTyped will use promptui to read an answer, which must match the expected text
+func (r *RealPrompt) AskTyped(question string, expected string) bool {
+	if NonInteractive {
+		// --yes does not stand in for typing the answer
+		refuse(question, "confirm it once by running interactively, or set confirm: destructive: never in turbolift.yaml")
+		return false
+	}
+	p := promptui.Prompt{
+		Label: question,
+	}
+	res, err := p.Run()
+	if err != nil {
+		return false
+	}
+	return strings.TrimSpace(res) == expected
+}
+
+// AskChoice will use promptui to have one of the choices picked from a list
+func (r *RealPrompt) AskChoice(question string, choices []string) string {
+	if NonInteractive {
+		refuse(question, "leave out the option that asks for choices, such as --interactive")
+		return ""
+	}
+	p := promptui.Select{
+		Label: question,
+		Items: choices,
+	}
+	_, res, err := p.Run()
+	if err != nil {
+		return ""
+	}
+	return res
+}
+
 // Mock Prompt that always returns true
 type FakePromptYes struct{}
 
@@ -50,6 +133,15 @@ func (f FakePromptYes) AskConfirm(_ string) bool {
 	return true
 }
 
+func (f FakePromptYes) AskTyped(_ string, _ string) bool {
+	return true
+}
+
+// AskChoice picks the first choice, which is the one that goes ahead
+func (f FakePromptYes) AskChoice(_ string, choices []string) string {
+	return choices[0]
+}
+
 // Mock Prompt that always returns false
 type FakePromptNo struct {
 	call string
@@ -64,6 +156,50 @@ func (f *FakePromptNo) AskConfirm(question string) bool {
 	return false
 }
 
+func (f *FakePromptNo) AskTyped(question string, _ string) bool {
+	f.call = question
+	return false
+}
+
+func (f *FakePromptNo) AskChoice(question string, _ []string) string {
+	f.call = question
+	return ""
+}
+
 func (f *FakePromptNo) AssertCalledWith(t *testing.T, expected string) {
 	assert.Equal(t, expected, f.call)
 }
+
+// Mock Prompt that gives each of its answers to AskChoice in turn, and cancels once they run out
+type FakePromptChoices struct {
+	answers []string
+	calls   []string
+}
+
+func NewFakePromptChoices(answers ...string) *FakePromptChoices {
+	return &FakePromptChoices{answers: answers}
+}
+
+func (f *FakePromptChoices) AskConfirm(question string) bool {
+	f.calls = append(f.calls, question)
+	return true
+}
+
+func (f *FakePromptChoices) AskTyped(question string, _ string) bool {
+	f.calls = append(f.calls, question)
+	return true
+}
+
+func (f *FakePromptChoices) AskChoice(question string, _ []string) string {
+	f.calls = append(f.calls, question)
+	if len(f.answers) == 0 {
+		return ""
+	}
+	answer := f.answers[0]
+	f.answers = f.answers[1:]
+	return answer
+}
+
+func (f *FakePromptChoices) AssertCalledWith(t *testing.T, expected ...string) {
+	assert.Equal(t, expected, f.calls)
+}
@@ -0,0 +1,52 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package prompt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestItRefusesEveryQuestionWhenNonInteractive(t *testing.T) {
+	NonInteractive = true
+	defer func() {
+		NonInteractive = false
+	}()
+	p := NewRealPrompt()
+
+	assert.False(t, p.AskConfirm("Close all the PRs?"))
+	assert.False(t, p.AskTyped("Type the campaign name", "campaign"))
+	assert.Equal(t, "", p.AskChoice("Commit these changes?", []string{"commit", "skip"}))
+
+	err := TakeRefusal()
+	assert.EqualError(t, err, `turbolift needed "Close all the PRs?" to be answered, but prompts are turned off with --non-interactive - give --yes to go ahead without asking`)
+	assert.NoError(t, TakeRefusal())
+}
+
+func TestItSaysHowToGoAheadWhenATypedAnswerIsRefused(t *testing.T) {
+	NonInteractive = true
+	defer func() {
+		NonInteractive = false
+	}()
+
+	assert.False(t, NewRealPrompt().AskTyped("Type the campaign name", "campaign"))
+
+	var refusal *NonInteractiveError
+	assert.ErrorAs(t, TakeRefusal(), &refusal)
+	assert.Equal(t, "Type the campaign name", refusal.Question)
+	assert.Contains(t, refusal.Hint, "confirm: destructive: never")
+}
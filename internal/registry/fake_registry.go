@@ -0,0 +1,55 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package registry
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// FakeRegistry keeps published entries in memory
+type FakeRegistry struct {
+	Entries   []Entry
+	published []Entry
+}
+
+func (f *FakeRegistry) Publish(_ context.Context, _ io.Writer, entry Entry) error {
+	f.published = append(f.published, entry)
+	return nil
+}
+
+func (f *FakeRegistry) List(_ context.Context, _ io.Writer) ([]Entry, error) {
+	return f.Entries, nil
+}
+
+func (f *FakeRegistry) AssertPublished(t *testing.T, expected []Entry) {
+	assert.Equal(t, expected, f.published)
+}
+
+// Published returns the entries published so far, for assertions on individual fields
+func (f *FakeRegistry) Published() []Entry {
+	return f.published
+}
+
+func NewFakeRegistry(entries ...Entry) *FakeRegistry {
+	return &FakeRegistry{
+		Entries:   entries,
+		published: []Entry{},
+	}
+}
@@ -0,0 +1,208 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/executor"
+)
+
+var execInstance executor.Executor = executor.NewRealExecutor()
+
+// Entry is the metadata published about a campaign so that other teams can discover it
+type Entry struct {
+	Name      string    `json:"name"`
+	Owner     string    `json:"owner"`
+	Status    string    `json:"status"`
+	ReportUrl string    `json:"reportUrl,omitempty"`
+	Repos     []string  `json:"repos"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type Registry interface {
+	Publish(ctx context.Context, output io.Writer, entry Entry) error
+	List(ctx context.Context, output io.Writer) ([]Entry, error)
+}
+
+var ErrNoRegistryConfigured = errors.New("no registry is configured: set registry.url or registry.path in turbolift.yaml")
+
+// NewRegistry returns the Registry backend selected by the campaign's configuration
+func NewRegistry(config campaign.RegistryConfig) (Registry, error) {
+	switch {
+	case config.Url != "":
+		return &HttpRegistry{Url: config.Url, Client: http.DefaultClient}, nil
+	case config.Path != "":
+		return &RepoRegistry{Path: config.Path}, nil
+	default:
+		return nil, ErrNoRegistryConfigured
+	}
+}
+
+// RepoRegistry stores one JSON file per campaign in a local checkout of a registry repository.
+// If the checkout is a git repository, changes are pulled before reading and committed and pushed after publishing.
+type RepoRegistry struct {
+	Path string
+}
+
+func (r *RepoRegistry) isGitRepo() bool {
+	_, err := os.Stat(filepath.Join(r.Path, ".git"))
+	return err == nil
+}
+
+func (r *RepoRegistry) Publish(ctx context.Context, output io.Writer, entry Entry) error {
+	if r.isGitRepo() {
+		if err := execInstance.Execute(ctx, output, r.Path, "git", "pull", "--ff-only"); err != nil {
+			return err
+		}
+	}
+
+	contents, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+	filename := entry.Name + ".json"
+	if err := os.WriteFile(filepath.Join(r.Path, filename), append(contents, '\n'), 0o644); err != nil {
+		return fmt.Errorf("unable to write registry entry: %w", err)
+	}
+
+	if !r.isGitRepo() {
+		return nil
+	}
+	if err := execInstance.Execute(ctx, output, r.Path, "git", "add", filename); err != nil {
+		return err
+	}
+	if err := execInstance.Execute(ctx, output, r.Path, "git", "commit", "--message", fmt.Sprintf("Update campaign %s", entry.Name)); err != nil {
+		return err
+	}
+	return execInstance.Execute(ctx, output, r.Path, "git", "push")
+}
+
+func (r *RepoRegistry) List(ctx context.Context, output io.Writer) ([]Entry, error) {
+	if r.isGitRepo() {
+		if err := execInstance.Execute(ctx, output, r.Path, "git", "pull", "--ff-only"); err != nil {
+			return nil, err
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(r.Path, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []Entry{}
+	for _, file := range files {
+		contents, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read registry entry %s: %w", file, err)
+		}
+		var entry Entry
+		if err := json.Unmarshal(contents, &entry); err != nil {
+			return nil, fmt.Errorf("unable to parse registry entry %s: %w", file, err)
+		}
+		entries = append(entries, entry)
+	}
+
+	sortEntries(entries)
+	return entries, nil
+}
+
+// HttpRegistry publishes entries by POSTing them to an endpoint, and lists them with a GET of the same endpoint.
+// If TURBOLIFT_REGISTRY_TOKEN is set, it is sent as a bearer token.
+type HttpRegistry struct {
+	Url    string
+	Client *http.Client
+}
+
+func (r *HttpRegistry) newRequest(ctx context.Context, method string, body io.Reader) (*http.Request, error) {
+	request, err := http.NewRequestWithContext(ctx, method, r.Url, body)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if token := os.Getenv("TURBOLIFT_REGISTRY_TOKEN"); token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	return request, nil
+}
+
+func (r *HttpRegistry) Publish(ctx context.Context, _ io.Writer, entry Entry) error {
+	contents, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	request, err := r.newRequest(ctx, http.MethodPost, bytes.NewReader(contents))
+	if err != nil {
+		return err
+	}
+	response, err := r.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("registry returned %s", response.Status)
+	}
+	return nil
+}
+
+func (r *HttpRegistry) List(ctx context.Context, _ io.Writer) ([]Entry, error) {
+	request, err := r.newRequest(ctx, http.MethodGet, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := r.Client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	if response.StatusCode >= 300 {
+		return nil, fmt.Errorf("registry returned %s", response.Status)
+	}
+
+	entries := []Entry{}
+	if err := json.NewDecoder(response.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("unable to parse registry response: %w", err)
+	}
+
+	sortEntries(entries)
+	return entries, nil
+}
+
+func sortEntries(entries []Entry) {
+	sort.Slice(entries, func(i, j int) bool {
+		return strings.ToLower(entries[i].Name) < strings.ToLower(entries[j].Name)
+	})
+}
@@ -0,0 +1,135 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/executor"
+	"github.com/skyscanner/turbolift/internal/testsupport"
+)
+
+var someTime = time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func TestItSelectsBackendFromConfig(t *testing.T) {
+	r, err := NewRegistry(campaign.RegistryConfig{Url: "https://registry.example.com"})
+	assert.NoError(t, err)
+	assert.IsType(t, &HttpRegistry{}, r)
+
+	r, err = NewRegistry(campaign.RegistryConfig{Path: "../registry"})
+	assert.NoError(t, err)
+	assert.IsType(t, &RepoRegistry{}, r)
+
+	_, err = NewRegistry(campaign.RegistryConfig{})
+	assert.ErrorIs(t, err, ErrNoRegistryConfigured)
+}
+
+func TestRepoRegistryPublishesAndListsEntries(t *testing.T) {
+	fakeExecutor := executor.NewAlwaysFailsFakeExecutor()
+	execInstance = fakeExecutor
+
+	dir := testsupport.CreateAndEnterTempDirectory()
+	r := &RepoRegistry{Path: dir}
+
+	err := r.Publish(context.Background(), &strings.Builder{}, Entry{Name: "zebra", Owner: "team-z", Status: "open", Repos: []string{"org/repo1"}, UpdatedAt: someTime})
+	assert.NoError(t, err)
+	err = r.Publish(context.Background(), &strings.Builder{}, Entry{Name: "aardvark", Owner: "team-a", Status: "done", Repos: []string{"org/repo2"}, UpdatedAt: someTime})
+	assert.NoError(t, err)
+	assert.FileExists(t, filepath.Join(dir, "zebra.json"))
+
+	entries, err := r.List(context.Background(), &strings.Builder{})
+	assert.NoError(t, err)
+	assert.Equal(t, []Entry{
+		{Name: "aardvark", Owner: "team-a", Status: "done", Repos: []string{"org/repo2"}, UpdatedAt: someTime},
+		{Name: "zebra", Owner: "team-z", Status: "open", Repos: []string{"org/repo1"}, UpdatedAt: someTime},
+	}, entries)
+
+	// not a git repo, so no git commands should have been run
+	fakeExecutor.AssertCalledWith(t, [][]string{})
+}
+
+func TestRepoRegistryCommitsAndPushesInGitCheckout(t *testing.T) {
+	fakeExecutor := executor.NewAlwaysSucceedsFakeExecutor()
+	execInstance = fakeExecutor
+
+	dir := testsupport.CreateAndEnterTempDirectory()
+	_ = os.Mkdir(filepath.Join(dir, ".git"), 0o755)
+	r := &RepoRegistry{Path: dir}
+
+	err := r.Publish(context.Background(), &strings.Builder{}, Entry{Name: "my-campaign", UpdatedAt: someTime})
+	assert.NoError(t, err)
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{dir, "git", "pull", "--ff-only"},
+		{dir, "git", "add", "my-campaign.json"},
+		{dir, "git", "commit", "--message", "Update campaign my-campaign"},
+		{dir, "git", "push"},
+	})
+}
+
+func TestHttpRegistryPublishesAndListsEntries(t *testing.T) {
+	var published Entry
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		switch r.Method {
+		case http.MethodPost:
+			_ = json.NewDecoder(r.Body).Decode(&published)
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodGet:
+			_ = json.NewEncoder(w).Encode([]Entry{{Name: "other-campaign", Owner: "team-b"}, {Name: "another-campaign"}})
+		}
+	}))
+	defer server.Close()
+	testsupport.Setenv(t, "TURBOLIFT_REGISTRY_TOKEN", "secret")
+
+	r := &HttpRegistry{Url: server.URL, Client: server.Client()}
+
+	err := r.Publish(context.Background(), &strings.Builder{}, Entry{Name: "my-campaign", Owner: "team-a", UpdatedAt: someTime})
+	assert.NoError(t, err)
+	assert.Equal(t, Entry{Name: "my-campaign", Owner: "team-a", UpdatedAt: someTime}, published)
+	assert.Equal(t, "Bearer secret", authorization)
+
+	entries, err := r.List(context.Background(), &strings.Builder{})
+	assert.NoError(t, err)
+	assert.Equal(t, []Entry{{Name: "another-campaign"}, {Name: "other-campaign", Owner: "team-b"}}, entries)
+}
+
+func TestHttpRegistryReturnsErrorOnFailedResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	r := &HttpRegistry{Url: server.URL, Client: server.Client()}
+
+	err := r.Publish(context.Background(), &strings.Builder{}, Entry{Name: "my-campaign"})
+	assert.EqualError(t, err, "registry returned 403 Forbidden")
+
+	_, err = r.List(context.Background(), &strings.Builder{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,155 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package status collects how far each repo in a campaign has got: whether it has been cloned, whether changes have
+// been committed to it, and the state of its PR
+package status
+
+import (
+	"context"
+	"os"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/forge"
+	"github.com/skyscanner/turbolift/internal/git"
+	"github.com/skyscanner/turbolift/internal/github"
+	"github.com/skyscanner/turbolift/internal/logging"
+)
+
+// Row is the status of one repo
+type Row struct {
+	Repository   string
+	Cloned       bool
+	Committed    bool
+	PrState      string
+	ChecksStatus string
+	Url          string
+	// Note is the note attached to the repo with turbolift note, if any
+	Note string
+	// Blockers are what keep an open PR from ever merging under its base branch's protection, so that it needs
+	// escalating
+	Blockers []string
+}
+
+// Summary counts the repos in each state
+type Summary struct {
+	Merged    int
+	Open      int
+	Closed    int
+	NoPR      int
+	NotCloned int
+	// Gone counts repos that have been deleted, or that the user no longer has access to
+	Gone int
+	// WontDo counts repos marked won't do with turbolift note, which are not in scope
+	WontDo int
+	// Blocked counts the open PRs that can never merge under their base branch's protection
+	Blocked int
+}
+
+// Completion is the percentage of repos whose PRs have been merged. Gone repos can never be completed, so they are
+// left out, as are repos marked won't do.
+func (s Summary) Completion() int {
+	inScope := s.Merged + s.Open + s.Closed + s.NoPR + s.NotCloned
+	if inScope == 0 {
+		return 0
+	}
+	return s.Merged * 100 / inScope
+}
+
+// Collect checks the status of every repo in the campaign, asking the forge that each is hosted on, and logging an
+// activity for each
+func Collect(ctx context.Context, logger *logging.Logger, gh github.GitHub, bb github.GitHub, g git.Git, dir *campaign.Campaign) ([]Row, Summary) {
+	var rows []Row
+	summary := Summary{WontDo: len(dir.WontDo)}
+
+	if err := github.RememberTrackedPRs(gh, bb); err != nil {
+		logger.Warnf("Unable to read the PRs recorded in the campaign state: %s", err)
+	}
+	state, err := campaign.ReadState()
+	if err != nil {
+		logger.Warnf("Unable to read the notes recorded in the campaign state: %s", err)
+		state = &campaign.State{}
+	}
+
+	for _, repo := range dir.Repos {
+		repoDirPath := repo.FullRepoPath()
+		row := Row{Repository: repo.FullRepoName}
+		if note, ok := state.Note(repo.FullRepoName); ok {
+			row.Note = note.Note
+		}
+
+		checkStatusActivity := logger.StartActivity("Collecting status for %s", repo.FullRepoName)
+
+		// working copies that have not been cloned can have neither commits nor PRs
+		if _, err := os.Stat(repoDirPath); os.IsNotExist(err) {
+			checkStatusActivity.EndWithWarningf("Directory %s does not exist - has it been cloned?", repoDirPath)
+			summary.NotCloned++
+			rows = append(rows, row)
+			continue
+		}
+		row.Cloned = true
+
+		var err error
+		row.Committed, err = g.IsAheadOfDefaultBranch(ctx, checkStatusActivity.Writer(), repoDirPath, repo.DefaultBranch)
+		if err != nil {
+			checkStatusActivity.Logf("Unable to determine whether changes have been committed: %v", err)
+		}
+
+		client := forge.For(repo, gh, bb)
+		prStatus, err := client.GetPR(ctx, checkStatusActivity.Writer(), repoDirPath, dir.BranchName)
+		if github.IsRepoGone(err) {
+			checkStatusActivity.EndWithWarning(err)
+			row.PrState = "GONE"
+			summary.Gone++
+			rows = append(rows, row)
+			continue
+		}
+		if err != nil {
+			checkStatusActivity.EndWithWarningf("No PR found: %v", err)
+			summary.NoPR++
+			rows = append(rows, row)
+			continue
+		}
+
+		row.PrState = prStatus.State
+		row.ChecksStatus = github.ChecksStatus(prStatus.StatusCheckRollup)
+		row.Url = prStatus.Url
+		if prStatus.State == github.PrOpen {
+			protection, err := client.BranchProtection(ctx, checkStatusActivity.Writer(), repoDirPath, repo.DefaultBranch)
+			if err != nil {
+				checkStatusActivity.Logf("Unable to read the branch protection: %v", err)
+			} else {
+				row.Blockers = github.MergeBlockers(prStatus, protection)
+			}
+			if len(row.Blockers) > 0 {
+				summary.Blocked++
+			}
+		}
+		rows = append(rows, row)
+
+		switch prStatus.State {
+		case "MERGED":
+			summary.Merged++
+		case "OPEN":
+			summary.Open++
+		case "CLOSED":
+			summary.Closed++
+		}
+
+		checkStatusActivity.EndWithSuccess()
+	}
+
+	return rows, summary
+}
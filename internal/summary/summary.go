@@ -0,0 +1,269 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package summary records what a command did to each of the campaign's repos, so that the outcome of a run can be
+// rendered by the CLI, written out for pipelines, or inspected by programs that run turbolift.
+package summary
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/executor"
+	"github.com/skyscanner/turbolift/internal/exitcode"
+	"github.com/skyscanner/turbolift/internal/git"
+	"github.com/skyscanner/turbolift/internal/interrupt"
+	"github.com/skyscanner/turbolift/internal/tools"
+)
+
+// The outcomes of a command for a repo
+const (
+	Done    = "done"
+	Skipped = "skipped"
+	Errored = "errored"
+)
+
+// The categories that the errors of repos are sorted into, so that callers can tell which are worth retrying
+const (
+	CategoryInterrupted  = "interrupted"
+	CategoryTimeout      = "timeout"
+	CategoryMissingTool  = "missing-tool"
+	CategoryPushRejected = "push-rejected"
+	CategoryConflict     = "conflict"
+	CategoryTransient    = "transient"
+	CategoryOther        = "other"
+)
+
+// The categories that skipped repos are counted under, when commands can tell why they had nothing to do
+const (
+	SkippedNotCloned = "not-cloned"
+	SkippedNoPR      = "no-pr"
+	SkippedOpen      = "open"
+	SkippedMerged    = "merged"
+	SkippedClosed    = "closed"
+	SkippedArchived  = "archived"
+)
+
+var skippedCategories = []string{SkippedNotCloned, SkippedNoPR, SkippedOpen, SkippedMerged, SkippedClosed, SkippedArchived}
+
+// RepoResult is the outcome of a command for one repo
+type RepoResult struct {
+	Repo    string `json:"repo"`
+	Outcome string `json:"outcome"`
+	// Reason says why the repo was skipped or errored
+	Reason string `json:"reason,omitempty"`
+	// Category is the kind of error, for repos that errored, or why the command had nothing to do, for repos that
+	// were skipped with RecordSkippedAs
+	Category        string  `json:"category,omitempty"`
+	DurationSeconds float64 `json:"duration-seconds"`
+}
+
+// Summary is the outcome of a command over the campaign's repos
+type Summary struct {
+	Command         string    `json:"command"`
+	Started         time.Time `json:"started"`
+	DurationSeconds float64   `json:"duration-seconds"`
+	Interrupted     bool      `json:"interrupted"`
+	Done            int       `json:"done"`
+	Skipped         int       `json:"skipped"`
+	// SkippedAs counts the skipped repos in each category that commands sorted them into
+	SkippedAs map[string]int `json:"skipped-as,omitempty"`
+	// Errored counts the repos that errored, along with any errors that were not with any one repo
+	Errored int `json:"errored"`
+	// Repos are in the order they were finished with
+	Repos []RepoResult `json:"repos"`
+	// Errors are the problems the command had that were not with any one repo
+	Errors []string `json:"errors,omitempty"`
+
+	mu           sync.Mutex
+	started      map[string]time.Time
+	lastFinished time.Time
+}
+
+// New starts the summary of a command
+func New(command string) *Summary {
+	now := time.Now()
+	return &Summary{Command: command, Started: now, Repos: []RepoResult{}, started: map[string]time.Time{}, lastFinished: now}
+}
+
+// Start notes that work on a repo has begun, so that the time taken over it can be recorded. Repos that are not
+// started are timed from when the previous repo was finished with, which suits repos dealt with one at a time.
+func (s *Summary) Start(repo campaign.Repo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.started[repo.ListedName()] = time.Now()
+}
+
+// RecordDone notes that the command did what it does to a repo
+func (s *Summary) RecordDone(repo campaign.Repo) {
+	s.record(repo, RepoResult{Outcome: Done})
+}
+
+// RecordSkipped notes that the command left a repo alone, and why
+func (s *Summary) RecordSkipped(repo campaign.Repo, reason string) {
+	s.record(repo, RepoResult{Outcome: Skipped, Reason: reason})
+}
+
+// RecordSkippedAs notes that the command left a repo alone, and why, counting it under one of the skipped categories
+func (s *Summary) RecordSkippedAs(repo campaign.Repo, category string, reason string) {
+	s.record(repo, RepoResult{Outcome: Skipped, Reason: reason, Category: category})
+}
+
+// RecordErrored notes that the command failed for a repo. The error may be nil if the failure has already been
+// reported without one.
+func (s *Summary) RecordErrored(repo campaign.Repo, err error) {
+	result := RepoResult{Outcome: Errored, Category: Categorise(err)}
+	if err != nil {
+		result.Reason = err.Error()
+	}
+	s.record(repo, result)
+}
+
+func (s *Summary) record(repo campaign.Repo, result RepoResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	name := repo.ListedName()
+	started, ok := s.started[name]
+	if !ok {
+		started = s.lastFinished
+	}
+	delete(s.started, name)
+	s.lastFinished = now
+
+	result.Repo = name
+	result.DurationSeconds = now.Sub(started).Seconds()
+	s.Repos = append(s.Repos, result)
+	switch result.Outcome {
+	case Done:
+		s.Done++
+	case Skipped:
+		s.Skipped++
+		if result.Category != "" {
+			if s.SkippedAs == nil {
+				s.SkippedAs = map[string]int{}
+			}
+			s.SkippedAs[result.Category]++
+		}
+	case Errored:
+		s.Errored++
+	}
+}
+
+// DescribeSkipped lists how many repos were skipped in each category, e.g. "2 merged, 1 archived", or returns an
+// empty string if none were counted under one
+func (s *Summary) DescribeSkipped() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var counts []string
+	for _, category := range skippedCategories {
+		if count := s.SkippedAs[category]; count > 0 {
+			counts = append(counts, fmt.Sprintf("%d %s", count, category))
+		}
+	}
+	return strings.Join(counts, ", ")
+}
+
+// RecordError notes a problem the command had that was not with any one repo, such as being unable to save the
+// campaign state afterwards. The run counts as having errored.
+func (s *Summary) RecordError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Errors = append(s.Errors, err.Error())
+	s.Errored++
+}
+
+// Finish notes that the command has finished with the repos, and whether it was interrupted before it did, and sets the
+// exit code of the run from the outcome. The summary is then given to the collector of the context, if it has one.
+func (s *Summary) Finish(ctx context.Context) {
+	interrupted := interrupt.Requested(ctx)
+	s.mu.Lock()
+	s.DurationSeconds = time.Since(s.Started).Seconds()
+	s.Interrupted = interrupted
+	skipped, errored := s.Skipped, s.Errored
+	s.mu.Unlock()
+
+	exitcode.Record(interrupted, skipped, errored)
+
+	if collector, ok := ctx.Value(collectorKey{}).(*Collector); ok {
+		collector.mu.Lock()
+		defer collector.mu.Unlock()
+		collector.summary = s
+	}
+}
+
+// Categorise sorts an error into one of the categories of errors
+func Categorise(err error) string {
+	var missingErr *tools.MissingError
+	var rejectedErr *git.PushRejectedError
+	var conflictErr *git.PatchConflictError
+	switch {
+	case err == nil:
+		return CategoryOther
+	case errors.Is(err, context.Canceled):
+		return CategoryInterrupted
+	case errors.Is(err, context.DeadlineExceeded):
+		return CategoryTimeout
+	case errors.As(err, &missingErr):
+		return CategoryMissingTool
+	case errors.As(err, &rejectedErr):
+		return CategoryPushRejected
+	case errors.As(err, &conflictErr):
+		return CategoryConflict
+	case executor.IsTransient(err, ""):
+		return CategoryTransient
+	}
+	return CategoryOther
+}
+
+type collectorKey struct{}
+
+// Collector holds the summary of the command run with its context, so that whatever ran the command can see what it
+// did without sharing the summary with other commands run at the same time
+type Collector struct {
+	mu      sync.Mutex
+	summary *Summary
+}
+
+// WithCollector returns a context for running a command with, and the collector that its summary is given to
+func WithCollector(ctx context.Context) (context.Context, *Collector) {
+	collector := &Collector{}
+	return context.WithValue(ctx, collectorKey{}, collector), collector
+}
+
+// Summary returns the summary of the command, or nil if it has not finished with the campaign's repos
+func (c *Collector) Summary() *Summary {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.summary
+}
+
+// WriteFile writes the summary to a file as JSON
+func (s *Summary) WriteFile(filename string) error {
+	s.mu.Lock()
+	contents, err := json.MarshalIndent(s, "", "  ")
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append(contents, '\n'), 0o644)
+}
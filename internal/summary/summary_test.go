@@ -0,0 +1,133 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package summary
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/exitcode"
+	"github.com/skyscanner/turbolift/internal/git"
+	"github.com/skyscanner/turbolift/internal/tools"
+)
+
+var (
+	repo1 = campaign.Repo{OrgName: "org", RepoName: "repo1", FullRepoName: "org/repo1"}
+	repo2 = campaign.Repo{OrgName: "org", RepoName: "repo2", FullRepoName: "org/repo2", Path: "services/api"}
+	repo3 = campaign.Repo{OrgName: "org", RepoName: "repo3", FullRepoName: "org/repo3"}
+)
+
+func TestItRecordsTheOutcomeOfEachRepo(t *testing.T) {
+	sum := New("clone")
+	sum.RecordDone(repo1)
+	sum.RecordSkipped(repo2, "not cloned")
+	sum.RecordErrored(repo3, fmt.Errorf("git clone failed: %w", context.DeadlineExceeded))
+
+	assert.Equal(t, 1, sum.Done)
+	assert.Equal(t, 1, sum.Skipped)
+	assert.Equal(t, 1, sum.Errored)
+	assert.Len(t, sum.Repos, 3)
+	assert.Equal(t, RepoResult{Repo: "org/repo1", Outcome: Done, DurationSeconds: sum.Repos[0].DurationSeconds}, sum.Repos[0])
+	assert.Equal(t, RepoResult{Repo: "org/repo2//services/api", Outcome: Skipped, Reason: "not cloned", DurationSeconds: sum.Repos[1].DurationSeconds}, sum.Repos[1])
+	assert.Equal(t, Errored, sum.Repos[2].Outcome)
+	assert.Equal(t, CategoryTimeout, sum.Repos[2].Category)
+	assert.Equal(t, "git clone failed: context deadline exceeded", sum.Repos[2].Reason)
+}
+
+func TestItCountsSkippedReposInEachCategory(t *testing.T) {
+	sum := New("update-prs")
+	sum.RecordSkippedAs(repo1, SkippedArchived, "org/repo1 is archived, so its PR cannot be changed")
+	sum.RecordSkippedAs(repo2, SkippedMerged, "PR for work/org/repo2 is already merged")
+	sum.RecordSkippedAs(repo3, SkippedMerged, "PR for work/org/repo3 is already merged")
+	sum.RecordSkipped(repo1, "nothing to do")
+
+	assert.Equal(t, 4, sum.Skipped)
+	assert.Equal(t, map[string]int{SkippedArchived: 1, SkippedMerged: 2}, sum.SkippedAs)
+	assert.Equal(t, SkippedArchived, sum.Repos[0].Category)
+	assert.Equal(t, "2 merged, 1 archived", sum.DescribeSkipped())
+	assert.Equal(t, "", New("clone").DescribeSkipped())
+}
+
+func TestItCategorisesErrors(t *testing.T) {
+	assert.Equal(t, CategoryOther, Categorise(nil))
+	assert.Equal(t, CategoryOther, Categorise(errors.New("exit status 1")))
+	assert.Equal(t, CategoryInterrupted, Categorise(fmt.Errorf("stopped: %w", context.Canceled)))
+	assert.Equal(t, CategoryTimeout, Categorise(context.DeadlineExceeded))
+	assert.Equal(t, CategoryMissingTool, Categorise(&tools.MissingError{Name: "gh"}))
+	assert.Equal(t, CategoryPushRejected, Categorise(&git.PushRejectedError{Err: errors.New("exit status 1")}))
+	assert.Equal(t, CategoryConflict, Categorise(&git.PatchConflictError{Patch: "fix.patch", Files: []string{"a.txt"}}))
+	assert.Equal(t, CategoryTransient, Categorise(errors.New("fatal: the remote end hung up unexpectedly")))
+}
+
+func TestFinishingSetsTheExitCodeAndGivesTheSummaryToTheCollector(t *testing.T) {
+	exitcode.Reset()
+	ctx, collector := WithCollector(context.Background())
+	assert.Nil(t, collector.Summary())
+
+	sum := New("commit")
+	sum.RecordDone(repo1)
+	sum.RecordErrored(repo3, nil)
+	sum.Finish(ctx)
+
+	assert.Equal(t, exitcode.Errored, exitcode.Code())
+	assert.False(t, sum.Interrupted)
+	assert.Same(t, sum, collector.Summary())
+	assert.Equal(t, CategoryOther, sum.Repos[1].Category)
+	assert.Empty(t, sum.Repos[1].Reason)
+}
+
+func TestErrorsNotWithAnyRepoCountAsErrored(t *testing.T) {
+	exitcode.Reset()
+
+	sum := New("remotes rewrite")
+	sum.RecordDone(repo1)
+	sum.RecordError(errors.New("unable to save the campaign state"))
+	sum.Finish(context.Background())
+
+	assert.Equal(t, 1, sum.Errored)
+	assert.Equal(t, []string{"unable to save the campaign state"}, sum.Errors)
+	assert.Equal(t, exitcode.Errored, exitcode.Code())
+}
+
+func TestItWritesTheSummaryAsJson(t *testing.T) {
+	sum := New("foreach")
+	sum.Start(repo1)
+	sum.RecordDone(repo1)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	sum.Finish(ctx)
+
+	filename := filepath.Join(t.TempDir(), "summary.json")
+	assert.NoError(t, sum.WriteFile(filename))
+
+	contents, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	var written map[string]interface{}
+	assert.NoError(t, json.Unmarshal(contents, &written))
+	assert.Equal(t, "foreach", written["command"])
+	assert.Equal(t, true, written["interrupted"])
+	assert.Equal(t, float64(1), written["done"])
+	assert.Equal(t, "org/repo1", written["repos"].([]interface{})[0].(map[string]interface{})["repo"])
+	assert.NotContains(t, written, "errors")
+}
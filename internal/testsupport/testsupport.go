@@ -22,6 +22,7 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"testing"
 )
 
 var originalPrTitleTodo = "TODO: Title of Pull Request"
@@ -96,3 +97,25 @@ func UsePrTitleTodoOnly() {
 func UsePrBodyTodoOnly() {
 	CreateOrUpdatePrDescriptionFile("README.md", "updated PR title", originalPrBodyTodo)
 }
+
+func CreateConfigFile(contents string) {
+	err := os.WriteFile("turbolift.yaml", []byte(contents), os.ModePerm|0o644)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Setenv sets an environment variable for the rest of the test, restoring its previous value once the test finishes
+func Setenv(t *testing.T, key string, value string) {
+	previous, wasSet := os.LookupEnv(key)
+	if err := os.Setenv(key, value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if wasSet {
+			_ = os.Setenv(key, previous)
+		} else {
+			_ = os.Unsetenv(key)
+		}
+	})
+}
@@ -0,0 +1,82 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Command checksumgen writes checksums.txt, listing the checksum of each asset of the tool releases that turbolift
+// pins, as published with the releases. It is run by go generate ./internal/tools whenever a pinned version changes,
+// so that the checksums are reviewed once as part of that change rather than trusted afresh on every download.
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/skyscanner/turbolift/internal/tools"
+)
+
+// platforms are the GOOS and GOARCH combinations that turbolift is built for
+var platforms = [][2]string{
+	{"darwin", "amd64"},
+	{"darwin", "arm64"},
+	{"linux", "amd64"},
+	{"linux", "arm64"},
+	{"windows", "amd64"},
+	{"windows", "arm64"},
+}
+
+func main() {
+	ctx := context.Background()
+	lines := []string{}
+	for _, name := range tools.Downloadable() {
+		release := tools.Known[name].Release
+		assets := map[string]bool{}
+		for _, platform := range platforms {
+			if asset := release.Asset(platform[0], platform[1]); asset != "" {
+				assets[asset] = true
+			}
+		}
+		for asset := range assets {
+			sum, err := tools.PublishedChecksum(ctx, os.Stderr, release, asset)
+			if err != nil {
+				log.Fatalf("Unable to find the checksum of %s: %s", asset, err)
+			}
+			lines = append(lines, fmt.Sprintf("%s  %s@%s/%s", sum, name, release.Version, asset))
+		}
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		return strings.Fields(lines[i])[1] < strings.Fields(lines[j])[1]
+	})
+
+	// the comments at the top of the file are kept as they are
+	existing, err := ioutil.ReadFile("checksums.txt")
+	if err != nil {
+		log.Fatalf("Unable to read checksums.txt: %s", err)
+	}
+	var comments []string
+	for _, line := range strings.Split(string(existing), "\n") {
+		if strings.HasPrefix(line, "#") {
+			comments = append(comments, line)
+		}
+	}
+
+	contents := strings.Join(append(comments, lines...), "\n") + "\n"
+	if err := ioutil.WriteFile("checksums.txt", []byte(contents), 0o644); err != nil {
+		log.Fatalf("Unable to write checksums.txt: %s", err)
+	}
+}
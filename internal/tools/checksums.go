@@ -0,0 +1,45 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package tools
+
+import (
+	"bufio"
+	_ "embed"
+	"strings"
+)
+
+//go:generate go run ./checksumgen
+
+// checksumsFile lists the SHA-256 checksum of each asset of the releases that turbolift pins, one per line as
+// "<checksum>  <tool>@<version>/<asset>", with comments starting with #
+//
+//go:embed checksums.txt
+var checksumsFile string
+
+// pinnedChecksums returns the checksums pinned for the assets of a release of a tool, by asset name
+func pinnedChecksums(tool string, version string) map[string]string {
+	checksums := map[string]string{}
+	prefix := tool + "@" + version + "/"
+	scanner := bufio.NewScanner(strings.NewReader(checksumsFile))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || strings.HasPrefix(fields[0], "#") || !strings.HasPrefix(fields[1], prefix) {
+			continue
+		}
+		checksums[strings.TrimPrefix(fields[1], prefix)] = strings.ToLower(fields[0])
+	}
+	return checksums
+}
@@ -0,0 +1,236 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package tools
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// downloadTimeout is how long downloading a release asset may take, so that a stalled download cannot hang doctor or
+// tools install
+const downloadTimeout = 5 * time.Minute
+
+var httpClient = &http.Client{Timeout: downloadTimeout}
+
+// Install downloads the pinned release of a tool for the current platform, checks it against the checksum that
+// turbolift pins for it, and puts its binary in BinDirectory. It returns the path of the binary.
+func (t Tool) Install(ctx context.Context, output io.Writer) (string, error) {
+	return t.installFor(ctx, output, BinDirectory(), runtime.GOOS, runtime.GOARCH)
+}
+
+// InstallInto downloads the tool's release like Install, but puts its binary in binDir
+func (t Tool) InstallInto(ctx context.Context, output io.Writer, binDir string) (string, error) {
+	return t.installFor(ctx, output, binDir, runtime.GOOS, runtime.GOARCH)
+}
+
+func (t Tool) installFor(ctx context.Context, output io.Writer, binDir string, goos string, goarch string) (string, error) {
+	if t.Release == nil {
+		return "", fmt.Errorf("turbolift is unable to download %s - to install it, %s", t.Name, hintFor(t.Name, goos, goarch))
+	}
+	asset := t.Release.Asset(goos, goarch)
+	if asset == "" {
+		return "", fmt.Errorf("%s %s is not published for %s/%s", t.Name, t.Release.Version, goos, goarch)
+	}
+	if binDir == "" {
+		return "", fmt.Errorf("unable to find a cache directory to download %s into", t.Name)
+	}
+
+	expected, ok := t.Release.Sha256[asset]
+	if !ok {
+		return "", fmt.Errorf("turbolift has no pinned checksum for %s %s (%s) to check the download against, so it has not been installed - use a version that turbolift pins, or %s", t.Name, t.Release.Version, asset, hintFor(t.Name, goos, goarch))
+	}
+
+	archive, err := download(ctx, output, t.Release.BaseUrl+"/"+asset)
+	if err != nil {
+		return "", err
+	}
+	actual := sha256.Sum256(archive)
+	if hex.EncodeToString(actual[:]) != expected {
+		return "", fmt.Errorf("the checksum of %s does not match the one pinned for it, so it has not been installed", asset)
+	}
+
+	binary, err := extract(archive, asset, t.Release.Binary(goos, goarch))
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(binDir, os.ModeDir|0o755); err != nil {
+		return "", fmt.Errorf("unable to create %s: %w", binDir, err)
+	}
+	binaryName := t.Name
+	if goos == "windows" {
+		binaryName += ".exe"
+	}
+	filename := filepath.Join(binDir, binaryName)
+	// write alongside then rename, so that an interrupted download never leaves a broken binary on the PATH
+	temp, err := ioutil.TempFile(binDir, "."+t.Name+"-")
+	if err != nil {
+		return "", fmt.Errorf("unable to write %s: %w", filename, err)
+	}
+	_, err = temp.Write(binary)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(temp.Name(), 0o755)
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), filename)
+	}
+	if err != nil {
+		_ = os.Remove(temp.Name())
+		return "", fmt.Errorf("unable to write %s: %w", filename, err)
+	}
+	_, _ = fmt.Fprintf(output, "Installed %s %s into %s\n", t.Name, t.Release.Version, filename)
+	return filename, nil
+}
+
+// PublishedChecksum downloads the checksum that a release publishes for one of its assets. It is only for generating
+// checksums.txt, as a checksum published with a release vouches for nothing that the release itself does not.
+func PublishedChecksum(ctx context.Context, output io.Writer, release *Release, asset string) (string, error) {
+	checksums, err := download(ctx, output, release.BaseUrl+"/"+release.Checksums)
+	if err != nil {
+		return "", err
+	}
+	var sum string
+	if release.HashesOrder == "" {
+		sum, err = checksumOf(checksums, asset)
+	} else {
+		var hashesOrder []byte
+		hashesOrder, err = download(ctx, output, release.BaseUrl+"/"+release.HashesOrder)
+		if err != nil {
+			return "", err
+		}
+		sum, err = orderedChecksumOf(checksums, hashesOrder, asset)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w in %s", err, release.Checksums)
+	}
+	return sum, nil
+}
+
+func download(ctx context.Context, output io.Writer, url string) ([]byte, error) {
+	_, _ = fmt.Fprintln(output, "Downloading", url)
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("unable to download %s: %w", url, err)
+	}
+	defer func() { _ = response.Body.Close() }()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to download %s: %s", url, response.Status)
+	}
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to download %s: %w", url, err)
+	}
+	return body, nil
+}
+
+// checksumOf finds the checksum of an asset in a list of checksums in the format output by sha256sum
+func checksumOf(checksums []byte, asset string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == asset {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("no checksum for %s", asset)
+}
+
+// orderedChecksumOf finds the SHA-256 checksum of an asset in a list of checksums where each line is the name of an
+// asset followed by several kinds of hash, in the order given one per line by hashesOrder
+func orderedChecksumOf(checksums []byte, hashesOrder []byte, asset string) (string, error) {
+	column := -1
+	for i, hash := range strings.Fields(string(hashesOrder)) {
+		if strings.EqualFold(hash, "SHA-256") {
+			column = i + 1
+		}
+	}
+	if column < 0 {
+		return "", fmt.Errorf("no SHA-256 checksums for %s", asset)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > column && fields[0] == asset {
+			return strings.ToLower(fields[column]), nil
+		}
+	}
+	return "", fmt.Errorf("no checksum for %s", asset)
+}
+
+// extract returns the contents of a file in a .tar.gz or .zip archive
+func extract(archive []byte, asset string, binaryPath string) ([]byte, error) {
+	if strings.HasSuffix(asset, ".zip") {
+		reader, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s: %w", asset, err)
+		}
+		for _, file := range reader.File {
+			if file.Name != binaryPath {
+				continue
+			}
+			contents, err := file.Open()
+			if err != nil {
+				return nil, fmt.Errorf("unable to read %s from %s: %w", binaryPath, asset, err)
+			}
+			defer func() { _ = contents.Close() }()
+			return ioutil.ReadAll(contents)
+		}
+		return nil, fmt.Errorf("%s does not contain %s", asset, binaryPath)
+	}
+
+	gzipReader, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", asset, err)
+	}
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s does not contain %s", asset, binaryPath)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s: %w", asset, err)
+		}
+		if path.Clean(header.Name) == path.Clean(binaryPath) {
+			return ioutil.ReadAll(tarReader)
+		}
+	}
+}
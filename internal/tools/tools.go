@@ -0,0 +1,311 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package tools helps with the command line tools that turbolift relies on, such as gh and git: it says how to install
+// them on the current platform, and can download pinned copies of those published as static binaries into a directory
+// that turbolift manages.
+package tools
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// Tool is a command that turbolift runs
+type Tool struct {
+	Name string
+	// Website is where to find out how to install the tool on platforms without a hint
+	Website string
+	// Hints say how to install the tool, by GOOS
+	Hints map[string]string
+	// Release is the pinned release that turbolift can download, for tools published as static binaries
+	Release *Release
+	// releaseAt describes any other release of the tool, given its version
+	releaseAt func(version string) *Release
+}
+
+// Release is a published release of a tool, whose assets are listed with their SHA-256 checksums
+type Release struct {
+	Version string
+	// BaseUrl is where the release's assets are downloaded from
+	BaseUrl string
+	// Checksums is the name of the asset listing the checksum of each of the others, as output by sha256sum, which
+	// checksums.txt is generated from
+	Checksums string
+	// HashesOrder is the name of the asset listing the hashes in each line of Checksums, for releases whose checksums
+	// file gives every asset's name followed by several kinds of hash rather than following sha256sum
+	HashesOrder string
+	// Asset is the name of the archive holding the binary for a GOOS and GOARCH, or empty if there is none
+	Asset func(goos string, goarch string) string
+	// Binary is the path of the binary within the archive
+	Binary func(goos string, goarch string) string
+	// Sha256 is the checksum that turbolift has pinned for each of the release's assets, by name. Assets without one
+	// are not installed, as checksums downloaded from the release could have been replaced along with the assets.
+	Sha256 map[string]string
+}
+
+// AtVersion returns the tool with its pinned release replaced by another version
+func (t Tool) AtVersion(version string) (Tool, error) {
+	if t.releaseAt == nil {
+		return Tool{}, fmt.Errorf("turbolift is unable to download %s - to install it, %s", t.Name, Hint(t.Name))
+	}
+	t.Release = t.releaseAt(strings.TrimPrefix(version, "v"))
+	return t, nil
+}
+
+// The versions of the tools that turbolift downloads, unless a campaign pins others
+const (
+	ghVersion       = "2.40.1"
+	yqVersion       = "4.40.5"
+	gitleaksVersion = "8.18.1"
+)
+
+func ghRelease(version string) *Release {
+	return &Release{
+		Version:   version,
+		BaseUrl:   "https://github.com/cli/cli/releases/download/v" + version,
+		Checksums: fmt.Sprintf("gh_%s_checksums.txt", version),
+		Sha256:    pinnedChecksums("gh", version),
+		Asset: func(goos string, goarch string) string {
+			switch goos {
+			case "linux":
+				return fmt.Sprintf("gh_%s_linux_%s.tar.gz", version, goarch)
+			case "darwin":
+				return fmt.Sprintf("gh_%s_macOS_%s.zip", version, goarch)
+			case "windows":
+				return fmt.Sprintf("gh_%s_windows_%s.zip", version, goarch)
+			}
+			return ""
+		},
+		Binary: func(goos string, goarch string) string {
+			switch goos {
+			case "darwin":
+				return fmt.Sprintf("gh_%s_macOS_%s/bin/gh", version, goarch)
+			case "windows":
+				return "bin/gh.exe"
+			}
+			return fmt.Sprintf("gh_%s_%s_%s/bin/gh", version, goos, goarch)
+		},
+	}
+}
+
+func yqRelease(version string) *Release {
+	return &Release{
+		Version:     version,
+		BaseUrl:     "https://github.com/mikefarah/yq/releases/download/v" + version,
+		Checksums:   "checksums",
+		HashesOrder: "checksums_hashes_order",
+		Sha256:      pinnedChecksums("yq", version),
+		Asset: func(goos string, goarch string) string {
+			switch goos {
+			case "linux", "darwin":
+				return fmt.Sprintf("yq_%s_%s.tar.gz", goos, goarch)
+			case "windows":
+				return fmt.Sprintf("yq_windows_%s.zip", goarch)
+			}
+			return ""
+		},
+		Binary: func(goos string, goarch string) string {
+			if goos == "windows" {
+				return fmt.Sprintf("yq_windows_%s.exe", goarch)
+			}
+			return fmt.Sprintf("./yq_%s_%s", goos, goarch)
+		},
+	}
+}
+
+func gitleaksRelease(version string) *Release {
+	archs := map[string]string{"amd64": "x64", "arm64": "arm64"}
+	return &Release{
+		Version:   version,
+		BaseUrl:   "https://github.com/gitleaks/gitleaks/releases/download/v" + version,
+		Checksums: fmt.Sprintf("gitleaks_%s_checksums.txt", version),
+		Sha256:    pinnedChecksums("gitleaks", version),
+		Asset: func(goos string, goarch string) string {
+			arch, ok := archs[goarch]
+			if !ok {
+				return ""
+			}
+			switch goos {
+			case "linux", "darwin":
+				return fmt.Sprintf("gitleaks_%s_%s_%s.tar.gz", version, goos, arch)
+			case "windows":
+				return fmt.Sprintf("gitleaks_%s_windows_%s.zip", version, arch)
+			}
+			return ""
+		},
+		Binary: func(goos string, goarch string) string {
+			if goos == "windows" {
+				return "gitleaks.exe"
+			}
+			return "gitleaks"
+		},
+	}
+}
+
+// Known are the tools that turbolift runs, by name
+var Known = map[string]Tool{
+	"gh": {
+		Name:    "gh",
+		Website: "https://cli.github.com/",
+		Hints: map[string]string{
+			"darwin":  "run brew install gh",
+			"linux":   "install gh with your package manager, as described at https://github.com/cli/cli/blob/trunk/docs/install_linux.md",
+			"windows": "run winget install --id GitHub.cli",
+		},
+		Release:   ghRelease(ghVersion),
+		releaseAt: ghRelease,
+	},
+	"git": {
+		Name:    "git",
+		Website: "https://git-scm.com/downloads",
+		Hints: map[string]string{
+			"darwin":  "run xcode-select --install, or brew install git",
+			"linux":   "install git with your package manager, e.g. sudo apt-get install git or sudo dnf install git",
+			"windows": "run winget install --id Git.Git -e --source winget",
+		},
+	},
+	"yq": {
+		Name:    "yq",
+		Website: "https://github.com/mikefarah/yq#install",
+		Hints: map[string]string{
+			"darwin":  "run brew install yq",
+			"windows": "run winget install --id MikeFarah.yq",
+		},
+		Release:   yqRelease(yqVersion),
+		releaseAt: yqRelease,
+	},
+	"gitleaks": {
+		Name:    "gitleaks",
+		Website: "https://github.com/gitleaks/gitleaks#installing",
+		Hints: map[string]string{
+			"darwin": "run brew install gitleaks",
+		},
+		Release:   gitleaksRelease(gitleaksVersion),
+		releaseAt: gitleaksRelease,
+	},
+}
+
+// Downloadable lists the names of the tools that turbolift can download, in alphabetical order
+func Downloadable() []string {
+	var names []string
+	for name, tool := range Known {
+		if tool.Release != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Hint says how to install a tool on the current platform
+func Hint(name string) string {
+	return hintFor(name, runtime.GOOS, runtime.GOARCH)
+}
+
+func hintFor(name string, goos string, goarch string) string {
+	tool, ok := Known[name]
+	if !ok {
+		return fmt.Sprintf("install %s and make sure it is on the PATH", name)
+	}
+	hint, ok := tool.Hints[goos]
+	if !ok {
+		hint = fmt.Sprintf("install %s from %s", name, tool.Website)
+	}
+	if tool.Release != nil && tool.Release.Asset(goos, goarch) != "" {
+		// turbolift doctor only installs gh, which every campaign needs
+		if name == "gh" {
+			hint += fmt.Sprintf(", or run turbolift doctor --install-missing to download %s %s for turbolift to use", name, tool.Release.Version)
+		} else {
+			hint += fmt.Sprintf(", or run turbolift tools install %s to download %s %s for the campaign", name, name, tool.Release.Version)
+		}
+	}
+	return hint
+}
+
+// MissingError is returned when a tool is not installed, and says how to install it
+type MissingError struct {
+	Name string
+	Err  error
+}
+
+func (e *MissingError) Error() string {
+	return fmt.Sprintf("%s is not installed, or is not on the PATH - to install it, %s", e.Name, Hint(e.Name))
+}
+
+func (e *MissingError) Unwrap() error {
+	return e.Err
+}
+
+// Directory is where turbolift keeps the tools it downloads: $TURBOLIFT_TOOLS_DIR if set, otherwise turbolift/tools in
+// the user's cache directory
+func Directory() string {
+	if dir := os.Getenv("TURBOLIFT_TOOLS_DIR"); dir != "" {
+		return dir
+	}
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(cacheDir, "turbolift", "tools")
+}
+
+// BinDirectory is where the binaries of downloaded tools are kept
+func BinDirectory() string {
+	dir := Directory()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "bin")
+}
+
+// CampaignDirectory is where a campaign keeps the tools installed for it by turbolift tools install, relative to the
+// campaign directory. Its tools are used in preference to any others while working on the campaign.
+const CampaignDirectory = ".turbolift-tools"
+
+// CampaignBinDirectory is the absolute path of the directory holding the binaries of the campaign's tools, if turbolift
+// is run in a campaign directory
+func CampaignBinDirectory() string {
+	dir, err := filepath.Abs(filepath.Join(CampaignDirectory, "bin"))
+	if err != nil {
+		return ""
+	}
+	return dir
+}
+
+// AddToPath puts the tools that turbolift has downloaded at the front of the PATH, so that they are used by every
+// command that turbolift runs, with those of the current campaign ahead of the others. It does nothing if no tools
+// have been downloaded.
+func AddToPath() {
+	prependToPath(BinDirectory())
+	prependToPath(CampaignBinDirectory())
+}
+
+func prependToPath(binDir string) {
+	if info, err := os.Stat(binDir); binDir == "" || err != nil || !info.IsDir() {
+		return
+	}
+	path := os.Getenv("PATH")
+	for _, entry := range filepath.SplitList(path) {
+		if entry == binDir {
+			return
+		}
+	}
+	_ = os.Setenv("PATH", strings.Join([]string{binDir, path}, string(os.PathListSeparator)))
+}
@@ -0,0 +1,280 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package tools
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/testsupport"
+)
+
+func TestItGivesPlatformSpecificInstallHints(t *testing.T) {
+	assert.Equal(t, "run brew install gh, or run turbolift doctor --install-missing to download gh 2.40.1 for turbolift to use", hintFor("gh", "darwin", "arm64"))
+	assert.Contains(t, hintFor("gh", "linux", "amd64"), "install_linux.md")
+	assert.Equal(t, "install gh from https://cli.github.com/", hintFor("gh", "plan9", "amd64"))
+	assert.Equal(t, "run winget install --id Git.Git -e --source winget", hintFor("git", "windows", "amd64"))
+	assert.Equal(t, "install glab and make sure it is on the PATH", hintFor("glab", "linux", "amd64"))
+	assert.Equal(t, "run brew install yq, or run turbolift tools install yq to download yq 4.40.5 for the campaign", hintFor("yq", "darwin", "arm64"))
+}
+
+func TestItNamesThePinnedGhAssets(t *testing.T) {
+	release := Known["gh"].Release
+	assert.Equal(t, "gh_2.40.1_linux_arm64.tar.gz", release.Asset("linux", "arm64"))
+	assert.Equal(t, "gh_2.40.1_linux_arm64/bin/gh", release.Binary("linux", "arm64"))
+	assert.Equal(t, "gh_2.40.1_macOS_amd64.zip", release.Asset("darwin", "amd64"))
+	assert.Equal(t, "gh_2.40.1_macOS_amd64/bin/gh", release.Binary("darwin", "amd64"))
+	assert.Equal(t, "bin/gh.exe", release.Binary("windows", "amd64"))
+}
+
+func TestItNamesTheAssetsOfOtherVersions(t *testing.T) {
+	gh, err := Known["gh"].AtVersion("v2.39.0")
+	assert.NoError(t, err)
+	assert.Equal(t, "2.39.0", gh.Release.Version)
+	assert.Equal(t, "https://github.com/cli/cli/releases/download/v2.39.0", gh.Release.BaseUrl)
+	assert.Equal(t, "gh_2.39.0_linux_amd64.tar.gz", gh.Release.Asset("linux", "amd64"))
+	assert.Equal(t, "2.40.1", Known["gh"].Release.Version, "the pinned release should be left alone")
+
+	yq, err := Known["yq"].AtVersion("4.35.1")
+	assert.NoError(t, err)
+	assert.Equal(t, "yq_darwin_arm64.tar.gz", yq.Release.Asset("darwin", "arm64"))
+	assert.Equal(t, "./yq_darwin_arm64", yq.Release.Binary("darwin", "arm64"))
+
+	gitleaks := Known["gitleaks"].Release
+	assert.Equal(t, "gitleaks_8.18.1_linux_x64.tar.gz", gitleaks.Asset("linux", "amd64"))
+	assert.Equal(t, "", gitleaks.Asset("linux", "386"))
+
+	_, err = Known["git"].AtVersion("2.40.0")
+	assert.Error(t, err)
+}
+
+func TestItFindsChecksumsListedWithOtherHashes(t *testing.T) {
+	checksums := []byte("yq_darwin_amd64.tar.gz 1111 2222 3333\nyq_linux_amd64.tar.gz 4444 AAAA 5555\n")
+	hashesOrder := []byte("CRC32\nSHA-256\nSHA-512\n")
+
+	sum, err := orderedChecksumOf(checksums, hashesOrder, "yq_linux_amd64.tar.gz")
+	assert.NoError(t, err)
+	assert.Equal(t, "aaaa", sum)
+
+	_, err = orderedChecksumOf(checksums, []byte("CRC32\nMD5\n"), "yq_linux_amd64.tar.gz")
+	assert.Error(t, err)
+}
+
+func TestItInstallsABinaryIntoTheGivenDirectory(t *testing.T) {
+	binDir := filepath.Join(t.TempDir(), "bin")
+	archive := tarball(t, "./tool_linux_amd64", "tool binary")
+	tool := fakeTool(t, "tool_1.0_linux_amd64.tar.gz", archive, checksum(archive))
+	tool.Release.Binary = func(goos string, goarch string) string {
+		return "tool_linux_amd64"
+	}
+
+	filename, err := tool.installFor(context.Background(), &bytes.Buffer{}, binDir, "linux", "amd64")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(binDir, "tool"), filename, "the binary should be named after the tool")
+}
+
+func TestItInstallsABinaryFromATarball(t *testing.T) {
+	testsupport.Setenv(t, "TURBOLIFT_TOOLS_DIR", t.TempDir())
+	archive := tarball(t, "tool_1.0_linux_amd64/bin/tool", "#!/bin/sh\necho tool\n")
+	tool := fakeTool(t, "tool_1.0_linux_amd64.tar.gz", archive, checksum(archive))
+
+	filename, err := tool.installFor(context.Background(), &bytes.Buffer{}, BinDirectory(), "linux", "amd64")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(BinDirectory(), "tool"), filename)
+
+	contents, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "#!/bin/sh\necho tool\n", string(contents))
+	info, _ := os.Stat(filename)
+	assert.Equal(t, os.FileMode(0o755), info.Mode().Perm())
+}
+
+func TestItInstallsABinaryFromAZip(t *testing.T) {
+	testsupport.Setenv(t, "TURBOLIFT_TOOLS_DIR", t.TempDir())
+	archive := zipFile(t, "tool_1.0_linux_amd64/bin/tool", "tool binary")
+	tool := fakeTool(t, "tool_1.0_linux_amd64.zip", archive, checksum(archive))
+
+	filename, err := tool.installFor(context.Background(), &bytes.Buffer{}, BinDirectory(), "linux", "amd64")
+	assert.NoError(t, err)
+	contents, _ := os.ReadFile(filename)
+	assert.Equal(t, "tool binary", string(contents))
+}
+
+func TestItRefusesToInstallABinaryWhoseChecksumDoesNotMatch(t *testing.T) {
+	testsupport.Setenv(t, "TURBOLIFT_TOOLS_DIR", t.TempDir())
+	archive := tarball(t, "tool_1.0_linux_amd64/bin/tool", "tampered")
+	tool := fakeTool(t, "tool_1.0_linux_amd64.tar.gz", archive, checksum([]byte("the real archive")))
+
+	_, err := tool.installFor(context.Background(), &bytes.Buffer{}, BinDirectory(), "linux", "amd64")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "does not match")
+	assert.NoFileExists(t, filepath.Join(BinDirectory(), "tool"))
+}
+
+func TestItRefusesToInstallABinaryWithoutAPinnedChecksum(t *testing.T) {
+	testsupport.Setenv(t, "TURBOLIFT_TOOLS_DIR", t.TempDir())
+	archive := tarball(t, "tool_1.0_linux_amd64/bin/tool", "tool binary")
+	tool := fakeTool(t, "tool_1.0_linux_amd64.tar.gz", archive, checksum(archive))
+	tool.Release.Sha256 = map[string]string{}
+
+	_, err := tool.installFor(context.Background(), &bytes.Buffer{}, BinDirectory(), "linux", "amd64")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "no pinned checksum for tool 1.0 (tool_1.0_linux_amd64.tar.gz)")
+	assert.NoFileExists(t, filepath.Join(BinDirectory(), "tool"))
+}
+
+func TestItFindsTheChecksumsPinnedForARelease(t *testing.T) {
+	original := checksumsFile
+	t.Cleanup(func() { checksumsFile = original })
+	checksumsFile = "# a comment\n" +
+		"AAAA  yq@4.40.5/yq_linux_amd64.tar.gz\n" +
+		"bbbb  yq@4.40.5/yq_windows_amd64.zip\n" +
+		"cccc  yq@4.35.1/yq_linux_amd64.tar.gz\n" +
+		"dddd  gh@4.40.5/gh_4.40.5_linux_amd64.tar.gz\n"
+
+	assert.Equal(t, map[string]string{
+		"yq_linux_amd64.tar.gz": "aaaa",
+		"yq_windows_amd64.zip":  "bbbb",
+	}, pinnedChecksums("yq", "4.40.5"))
+	assert.Empty(t, pinnedChecksums("gitleaks", "8.18.1"))
+}
+
+func TestItFindsTheChecksumPublishedForAnAsset(t *testing.T) {
+	archive := tarball(t, "tool_1.0_linux_amd64/bin/tool", "tool binary")
+	tool := fakeTool(t, "tool_1.0_linux_amd64.tar.gz", archive, "")
+
+	sum, err := PublishedChecksum(context.Background(), &bytes.Buffer{}, tool.Release, "tool_1.0_linux_amd64.tar.gz")
+	assert.NoError(t, err)
+	assert.Equal(t, checksum(archive), sum)
+}
+
+func TestEmbeddedChecksumsAreWellFormed(t *testing.T) {
+	for _, line := range strings.Split(checksumsFile, "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		assert.Len(t, fields, 2, line)
+		assert.Regexp(t, "^[0-9a-f]{64}$", fields[0], line)
+		assert.Regexp(t, "^[a-z]+@[0-9.]+/.+$", fields[1], line)
+	}
+}
+
+func TestItRefusesToInstallToolsThatAreNotPublishedAsBinaries(t *testing.T) {
+	_, err := Known["git"].installFor(context.Background(), &bytes.Buffer{}, BinDirectory(), "linux", "amd64")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unable to download git")
+}
+
+func TestItAddsDownloadedToolsToThePath(t *testing.T) {
+	testsupport.Setenv(t, "TURBOLIFT_TOOLS_DIR", t.TempDir())
+	testsupport.Setenv(t, "PATH", "/usr/bin")
+
+	AddToPath()
+	assert.Equal(t, "/usr/bin", os.Getenv("PATH"), "the PATH should be left alone until a tool has been downloaded")
+
+	assert.NoError(t, os.MkdirAll(BinDirectory(), 0o755))
+	AddToPath()
+	AddToPath()
+	assert.Equal(t, BinDirectory()+string(os.PathListSeparator)+"/usr/bin", os.Getenv("PATH"))
+}
+
+func TestItPutsTheCampaignsToolsFirstOnThePath(t *testing.T) {
+	testsupport.Setenv(t, "TURBOLIFT_TOOLS_DIR", t.TempDir())
+	testsupport.Setenv(t, "PATH", "/usr/bin")
+	wd, _ := os.Getwd()
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+	assert.NoError(t, os.Chdir(t.TempDir()))
+
+	assert.NoError(t, os.MkdirAll(BinDirectory(), 0o755))
+	assert.NoError(t, os.MkdirAll(filepath.Join(CampaignDirectory, "bin"), 0o755))
+	AddToPath()
+
+	assert.Equal(t, strings.Join([]string{CampaignBinDirectory(), BinDirectory(), "/usr/bin"}, string(os.PathListSeparator)), os.Getenv("PATH"))
+}
+
+func fakeTool(t *testing.T, asset string, archive []byte, sum string) Tool {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1.0/checksums.txt":
+			// a release whose assets have been replaced could well publish their checksums too
+			_, _ = fmt.Fprintf(w, "%s  %s\n", checksum(archive), asset)
+		case "/v1.0/" + asset:
+			_, _ = w.Write(archive)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return Tool{
+		Name: "tool",
+		Release: &Release{
+			Version:   "1.0",
+			BaseUrl:   server.URL + "/v1.0",
+			Checksums: "checksums.txt",
+			Asset: func(goos string, goarch string) string {
+				return asset
+			},
+			Binary: func(goos string, goarch string) string {
+				return fmt.Sprintf("tool_1.0_%s_%s/bin/tool", goos, goarch)
+			},
+			Sha256: map[string]string{asset: sum},
+		},
+	}
+}
+
+func checksum(contents []byte) string {
+	sum := sha256.Sum256(contents)
+	return hex.EncodeToString(sum[:])
+}
+
+func tarball(t *testing.T, name string, contents string) []byte {
+	var buffer bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buffer)
+	tarWriter := tar.NewWriter(gzipWriter)
+	assert.NoError(t, tarWriter.WriteHeader(&tar.Header{Name: "README.md", Mode: 0o644, Size: 6}))
+	_, _ = tarWriter.Write([]byte("readme"))
+	assert.NoError(t, tarWriter.WriteHeader(&tar.Header{Name: name, Mode: 0o755, Size: int64(len(contents))}))
+	_, _ = tarWriter.Write([]byte(contents))
+	assert.NoError(t, tarWriter.Close())
+	assert.NoError(t, gzipWriter.Close())
+	return buffer.Bytes()
+}
+
+func zipFile(t *testing.T, name string, contents string) []byte {
+	var buffer bytes.Buffer
+	zipWriter := zip.NewWriter(&buffer)
+	file, err := zipWriter.Create(name)
+	assert.NoError(t, err)
+	_, _ = file.Write([]byte(contents))
+	assert.NoError(t, zipWriter.Close())
+	return buffer.Bytes()
+}
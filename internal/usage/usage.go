@@ -0,0 +1,171 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package usage keeps local statistics of which turbolift commands are run, for users who opt in, so that they can
+// share them with whoever maintains turbolift for their organisation. Nothing is ever sent anywhere by turbolift: the
+// statistics are only written to a file, and only leave it when exported and shared by the user.
+package usage
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// EnableVariable is the environment variable that opts in to recording usage statistics, when set to 1 or true
+const EnableVariable = "TURBOLIFT_USAGE_STATS"
+
+// Entry is one run of a turbolift command. It is anonymous: it holds no names of campaigns, repos, hosts or users,
+// and records which flags were given but not their values.
+type Entry struct {
+	Command string   `json:"command"`
+	Flags   []string `json:"flags,omitempty"`
+	// Date is the day the command was run on, with no time of day
+	Date            string  `json:"date"`
+	DurationSeconds float64 `json:"duration-seconds"`
+	Repos           int     `json:"repos"`
+	ExitCode        int     `json:"exit-code"`
+	Version         string  `json:"version"`
+}
+
+// Enabled is true if the user has opted in to recording usage statistics
+func Enabled() bool {
+	value := os.Getenv(EnableVariable)
+	return value == "1" || value == "true"
+}
+
+// File is where usage statistics are kept: $TURBOLIFT_USAGE_FILE if set, otherwise turbolift/usage.jsonl in the
+// user's config directory
+func File() string {
+	if filename := os.Getenv("TURBOLIFT_USAGE_FILE"); filename != "" {
+		return filename
+	}
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(configDir, "turbolift", "usage.jsonl")
+}
+
+// NewEntry describes a command that started at the given time and has just finished
+func NewEntry(command string, flags []string, started time.Time, repos int, exitCode int, version string) Entry {
+	return Entry{
+		Command:         command,
+		Flags:           flags,
+		Date:            started.UTC().Format("2006-01-02"),
+		DurationSeconds: time.Since(started).Round(time.Millisecond).Seconds(),
+		Repos:           repos,
+		ExitCode:        exitCode,
+		Version:         version,
+	}
+}
+
+// Append adds an entry to the end of the usage file, one JSON object per line
+func Append(entry Entry) error {
+	filename := File()
+	if filename == "" {
+		return fmt.Errorf("unable to find a config directory to record usage statistics in")
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), os.ModeDir|0o755); err != nil {
+		return fmt.Errorf("unable to create %s: %w", filepath.Dir(filename), err)
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("unable to open %s: %w", filename, err)
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("unable to write to %s: %w", filename, err)
+	}
+	return file.Close()
+}
+
+// Read returns every entry recorded so far, oldest first. There are none if nothing has been recorded yet.
+func Read() ([]Entry, error) {
+	filename := File()
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) || filename == "" {
+		return []Entry{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to open %s: %w", filename, err)
+	}
+	defer func() { _ = file.Close() }()
+
+	entries := []Entry{}
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("unable to parse line %d of %s: %w", lineNumber, filename, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", filename, err)
+	}
+	return entries, nil
+}
+
+// CommandSummary totals up the runs of one command
+type CommandSummary struct {
+	Command         string  `json:"command"`
+	Runs            int     `json:"runs"`
+	Failures        int     `json:"failures"`
+	Repos           int     `json:"repos"`
+	DurationSeconds float64 `json:"duration-seconds"`
+}
+
+// Summarise totals up the entries for each command, with the most run commands first
+func Summarise(entries []Entry) []CommandSummary {
+	byCommand := map[string]*CommandSummary{}
+	for _, entry := range entries {
+		summary, ok := byCommand[entry.Command]
+		if !ok {
+			summary = &CommandSummary{Command: entry.Command}
+			byCommand[entry.Command] = summary
+		}
+		summary.Runs++
+		if entry.ExitCode != 0 {
+			summary.Failures++
+		}
+		summary.Repos += entry.Repos
+		summary.DurationSeconds += entry.DurationSeconds
+	}
+
+	summaries := []CommandSummary{}
+	for _, summary := range byCommand {
+		summaries = append(summaries, *summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].Runs != summaries[j].Runs {
+			return summaries[i].Runs > summaries[j].Runs
+		}
+		return summaries[i].Command < summaries[j].Command
+	})
+	return summaries
+}
@@ -0,0 +1,82 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package usage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/testsupport"
+)
+
+func TestItIsOnlyEnabledWhenOptedIn(t *testing.T) {
+	testsupport.Setenv(t, EnableVariable, "")
+	assert.False(t, Enabled())
+
+	testsupport.Setenv(t, EnableVariable, "0")
+	assert.False(t, Enabled())
+
+	testsupport.Setenv(t, EnableVariable, "1")
+	assert.True(t, Enabled())
+}
+
+func TestItAppendsAndReadsEntries(t *testing.T) {
+	testsupport.Setenv(t, "TURBOLIFT_USAGE_FILE", filepath.Join(t.TempDir(), "turbolift", "usage.jsonl"))
+
+	entries, err := Read()
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	started := time.Date(2026, 3, 2, 23, 30, 0, 0, time.UTC)
+	assert.NoError(t, Append(NewEntry("clone", nil, started, 12, 0, "1.2.3")))
+	assert.NoError(t, Append(NewEntry("foreach", []string{"repos"}, started, 3, 2, "1.2.3")))
+
+	entries, err = Read()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "clone", entries[0].Command)
+	assert.Equal(t, "2026-03-02", entries[0].Date)
+	assert.Equal(t, 12, entries[0].Repos)
+	assert.Equal(t, []string{"repos"}, entries[1].Flags)
+	assert.Equal(t, 2, entries[1].ExitCode)
+}
+
+func TestItFailsToReadACorruptFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "usage.jsonl")
+	testsupport.Setenv(t, "TURBOLIFT_USAGE_FILE", filename)
+	assert.NoError(t, os.WriteFile(filename, []byte("{\"command\": \"clone\"}\nnot json\n"), 0o644))
+
+	_, err := Read()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "line 2")
+}
+
+func TestItSummarisesEntriesByCommand(t *testing.T) {
+	summaries := Summarise([]Entry{
+		{Command: "clone", Repos: 10, DurationSeconds: 60},
+		{Command: "foreach", Repos: 10, DurationSeconds: 5},
+		{Command: "foreach", Repos: 8, DurationSeconds: 4, ExitCode: 2},
+	})
+
+	assert.Equal(t, []CommandSummary{
+		{Command: "foreach", Runs: 2, Failures: 1, Repos: 18, DurationSeconds: 9},
+		{Command: "clone", Runs: 1, Repos: 10, DurationSeconds: 60},
+	}, summaries)
+}
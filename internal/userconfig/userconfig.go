@@ -0,0 +1,80 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package userconfig
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The roles that a user can have
+const (
+	// RoleMaintainer users run campaigns. It is the role of users who do not give one.
+	RoleMaintainer = "maintainer"
+	// RoleObserver users only look at campaigns that others run, so turbolift is always in read-only mode for them
+	RoleObserver = "observer"
+)
+
+// File is where the user's own settings are kept, whichever campaign they are working on:
+// $TURBOLIFT_USER_CONFIG if set, otherwise turbolift/config.yaml in the user's config directory
+func File() string {
+	if filename := os.Getenv("TURBOLIFT_USER_CONFIG"); filename != "" {
+		return filename
+	}
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(configDir, "turbolift", "config.yaml")
+}
+
+type Config struct {
+	// Role is how the user works with campaigns: RoleMaintainer or RoleObserver
+	Role string `yaml:"role"`
+	// Theme is the theme that output is styled with, such as ascii for CI log viewers
+	Theme string `yaml:"theme"`
+}
+
+// Read reads the user's settings from a file. A missing file has none set.
+func Read(filename string) (*Config, error) {
+	config := &Config{}
+	if filename == "" {
+		return config, nil
+	}
+
+	contents, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return config, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to read user config %s: %w", filename, err)
+	}
+
+	if err := yaml.Unmarshal(contents, config); err != nil {
+		return nil, fmt.Errorf("unable to parse user config %s: %w", filename, err)
+	}
+	if config.Role != "" && config.Role != RoleMaintainer && config.Role != RoleObserver {
+		return nil, fmt.Errorf("unknown role %s in user config %s: must be one of %s, %s", config.Role, filename, RoleMaintainer, RoleObserver)
+	}
+	return config, nil
+}
+
+// ReadOnly is true if the user's role only lets them look at campaigns
+func (c *Config) ReadOnly() bool {
+	return c.Role == RoleObserver
+}
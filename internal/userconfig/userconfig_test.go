@@ -0,0 +1,56 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package userconfig
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/testsupport"
+)
+
+func TestItHasNoRoleWithoutAConfigFile(t *testing.T) {
+	testsupport.CreateAndEnterTempDirectory()
+
+	config, err := Read("config.yaml")
+	assert.NoError(t, err)
+	assert.Equal(t, "", config.Role)
+	assert.False(t, config.ReadOnly())
+}
+
+func TestItIsReadOnlyForObservers(t *testing.T) {
+	testsupport.CreateAndEnterTempDirectory()
+	assert.NoError(t, os.WriteFile("config.yaml", []byte("role: observer\n"), 0o644))
+
+	config, err := Read("config.yaml")
+	assert.NoError(t, err)
+	assert.True(t, config.ReadOnly())
+
+	assert.NoError(t, os.WriteFile("config.yaml", []byte("role: maintainer\n"), 0o644))
+	config, err = Read("config.yaml")
+	assert.NoError(t, err)
+	assert.False(t, config.ReadOnly())
+}
+
+func TestItRejectsUnknownRoles(t *testing.T) {
+	testsupport.CreateAndEnterTempDirectory()
+	assert.NoError(t, os.WriteFile("config.yaml", []byte("role: admin\n"), 0o644))
+
+	_, err := Read("config.yaml")
+	assert.EqualError(t, err, "unknown role admin in user config config.yaml: must be one of maintainer, observer")
+}
@@ -0,0 +1,153 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package githubtest
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Remote hosts bare git repositories in a temporary directory, which can be cloned from and pushed to using the
+// file:// URLs returned by URL
+type Remote struct {
+	dir string
+}
+
+// NewRemote creates an empty remote. Call Close to delete it and its repos.
+func NewRemote() (*Remote, error) {
+	dir, err := ioutil.TempDir("", "githubtest-remote")
+	if err != nil {
+		return nil, err
+	}
+	return &Remote{dir: dir}, nil
+}
+
+func (r *Remote) Close() {
+	_ = os.RemoveAll(r.dir)
+}
+
+// AddRepo creates a bare repo, given as owner/name, whose main branch has a single commit containing the given
+// files, keyed by path
+func (r *Remote) AddRepo(fullName string, files map[string]string) error {
+	bare := r.path(fullName)
+	if err := os.MkdirAll(bare, 0o755); err != nil {
+		return err
+	}
+	if _, err := git(bare, "init", "--bare"); err != nil {
+		return err
+	}
+	if _, err := git(bare, "symbolic-ref", "HEAD", "refs/heads/main"); err != nil {
+		return err
+	}
+
+	seed, err := ioutil.TempDir("", "githubtest-seed")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.RemoveAll(seed)
+	}()
+
+	if _, err := git(seed, "init"); err != nil {
+		return err
+	}
+	if _, err := git(seed, "checkout", "-b", "main"); err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		files = map[string]string{"README.md": fmt.Sprintf("# %s\n", fullName)}
+	}
+	for path, contents := range files {
+		filename := filepath.Join(seed, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filename, []byte(contents), 0o644); err != nil {
+			return err
+		}
+	}
+
+	for _, args := range [][]string{
+		{"add", "-A"},
+		{"commit", "-m", "Initial commit"},
+		{"push", bare, "main"},
+	} {
+		if _, err := git(seed, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// URL is the URL to clone the repo from
+func (r *Remote) URL(fullName string) string {
+	return "file://" + filepath.ToSlash(r.path(fullName))
+}
+
+// RejectPushes makes the repo refuse every push, with the given message shown to the pusher
+func (r *Remote) RejectPushes(fullName string, message string) error {
+	hook := fmt.Sprintf("#!/bin/sh\necho %q >&2\nexit 1\n", message)
+	return ioutil.WriteFile(filepath.Join(r.path(fullName), "hooks", "pre-receive"), []byte(hook), 0o755)
+}
+
+// AcceptPushes removes any rejection set up by RejectPushes
+func (r *Remote) AcceptPushes(fullName string) error {
+	err := os.Remove(filepath.Join(r.path(fullName), "hooks", "pre-receive"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Branches lists the branches that have been pushed to the repo, sorted
+func (r *Remote) Branches(fullName string) ([]string, error) {
+	output, err := git(r.path(fullName), "for-each-ref", "--format=%(refname:short)", "refs/heads")
+	if err != nil {
+		return nil, err
+	}
+
+	branches := strings.Fields(output)
+	sort.Strings(branches)
+	return branches, nil
+}
+
+// File returns the contents of a file as of the given branch or other revision
+func (r *Remote) File(fullName string, revision string, path string) (string, error) {
+	return git(r.path(fullName), "show", revision+":"+path)
+}
+
+func (r *Remote) path(fullName string) string {
+	return filepath.Join(r.dir, filepath.FromSlash(fullName)+".git")
+}
+
+func git(dir string, args ...string) (string, error) {
+	// use a fixed identity so that commits work regardless of the user's configuration
+	cmd := exec.Command("git", append([]string{"-c", "user.name=githubtest", "-c", "user.email=githubtest@example.com", "-c", "commit.gpgsign=false"}, args...)...)
+	cmd.Dir = dir
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s failed: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
@@ -0,0 +1,91 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package githubtest
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestItServesReposThatCanBeClonedAndPushedTo(t *testing.T) {
+	remote := newTestRemote(t)
+	assert.NoError(t, remote.AddRepo("org/repo1", map[string]string{"docs/file.txt": "contents\n"}))
+
+	workingCopy := cloneRepo(t, remote, "org/repo1")
+	_, err := git(workingCopy, "checkout", "-b", "campaign")
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(workingCopy, "docs", "file.txt"), []byte("changed\n"), 0o644))
+	_, err = git(workingCopy, "commit", "-am", "Change file")
+	assert.NoError(t, err)
+	_, err = git(workingCopy, "push", "origin", "campaign")
+	assert.NoError(t, err)
+
+	branches, err := remote.Branches("org/repo1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"campaign", "main"}, branches)
+
+	contents, err := remote.File("org/repo1", "campaign", "docs/file.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "changed\n", contents)
+}
+
+func TestItCanBeScriptedToRejectPushes(t *testing.T) {
+	remote := newTestRemote(t)
+	assert.NoError(t, remote.AddRepo("org/repo1", nil))
+	assert.NoError(t, remote.RejectPushes("org/repo1", "branch protection says no"))
+
+	workingCopy := cloneRepo(t, remote, "org/repo1")
+	_, _ = git(workingCopy, "checkout", "-b", "campaign")
+	_, err := git(workingCopy, "push", "origin", "campaign")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "branch protection says no")
+
+	assert.NoError(t, remote.AcceptPushes("org/repo1"))
+	_, err = git(workingCopy, "push", "origin", "campaign")
+	assert.NoError(t, err)
+}
+
+func newTestRemote(t *testing.T) *Remote {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	remote, err := NewRemote()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(remote.Close)
+	return remote
+}
+
+func cloneRepo(t *testing.T, remote *Remote, fullName string) string {
+	dir, err := ioutil.TempDir("", "githubtest-clone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+
+	if _, err := git(dir, "clone", remote.URL(fullName), "."); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
@@ -0,0 +1,495 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package githubtest provides fakes of GitHub and of git remotes, for testing turbolift commands, and tools built on
+// turbolift, end-to-end without network access.
+//
+// Server is an HTTPS server implementing the subset of the GitHub REST and GraphQL APIs that turbolift relies on.
+// Env returns the environment variables that point gh at it. Remote serves bare git repositories from a temporary
+// directory, and can be scripted to reject pushes.
+package githubtest
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Token is the token that the server accepts, and that Env configures gh to send
+const Token = "githubtest-token"
+
+type Repo struct {
+	Owner            string
+	Name             string
+	DefaultBranch    string
+	ViewerPermission string
+	Archived         bool
+	PullRequests     []*PullRequest
+}
+
+func (r *Repo) FullName() string {
+	return r.Owner + "/" + r.Name
+}
+
+type PullRequest struct {
+	Number int
+	Title  string
+	Body   string
+	Head   string
+	Base   string
+	// State is OPEN, CLOSED or MERGED
+	State  string
+	Draft  bool
+	Labels []string
+}
+
+type Server struct {
+	server   *httptest.Server
+	certFile string
+
+	mu       sync.Mutex
+	repos    map[string]*Repo
+	requests []string
+}
+
+// NewServer starts a fake GitHub server. Call Close when finished with it.
+func NewServer() *Server {
+	s := &Server{repos: map[string]*Repo{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/graphql", s.handleGraphQL)
+	mux.HandleFunc("/api/v3/rate_limit", s.handleRateLimit)
+	mux.HandleFunc("/api/v3/", s.handleREST)
+	s.server = httptest.NewTLSServer(s.authenticated(mux))
+
+	return s
+}
+
+func (s *Server) Close() {
+	s.server.Close()
+	if s.certFile != "" {
+		_ = os.RemoveAll(filepath.Dir(s.certFile))
+	}
+}
+
+// URL is the base URL of the server, such as https://127.0.0.1:54321
+func (s *Server) URL() string {
+	return s.server.URL
+}
+
+// Host is the host and port of the server, as used for GH_HOST
+func (s *Server) Host() string {
+	return strings.TrimPrefix(s.server.URL, "https://")
+}
+
+// Client returns an HTTP client that trusts the server's certificate
+func (s *Server) Client() *http.Client {
+	return s.server.Client()
+}
+
+// Env returns the environment variables that make gh use this server as a GitHub Enterprise host and trust its
+// certificate, in the form expected by exec.Cmd
+func (s *Server) Env() ([]string, error) {
+	if s.certFile == "" {
+		dir, err := ioutil.TempDir("", "githubtest")
+		if err != nil {
+			return nil, err
+		}
+		certFile := filepath.Join(dir, "cert.pem")
+		cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.server.Certificate().Raw})
+		if err := ioutil.WriteFile(certFile, cert, 0o600); err != nil {
+			return nil, err
+		}
+		s.certFile = certFile
+	}
+
+	return []string{
+		"GH_HOST=" + s.Host(),
+		"GH_ENTERPRISE_TOKEN=" + Token,
+		"GH_PROMPT_DISABLED=1",
+		"SSL_CERT_FILE=" + s.certFile,
+	}, nil
+}
+
+// AddRepo adds a repo, given as owner/name, with a default branch of main and write permission for the viewer
+func (s *Server) AddRepo(fullName string) *Repo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	owner, name := splitFullName(fullName)
+	repo := &Repo{Owner: owner, Name: name, DefaultBranch: "main", ViewerPermission: "WRITE"}
+	s.repos[fullName] = repo
+	return repo
+}
+
+// Repo returns the repo with the given owner/name, or nil if it has not been added
+func (s *Server) Repo(fullName string) *Repo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.repos[fullName]
+}
+
+// Requests lists the requests the server has received, as "METHOD /path", or "POST /api/graphql OPERATION" for
+// GraphQL requests
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string{}, s.requests...)
+}
+
+func (s *Server) authenticated(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token "+Token {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "Bad credentials"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) record(request string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, request)
+}
+
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// handleGraphQL answers repository and viewer queries. Rather than evaluating selection sets, it returns every field
+// it knows about, which is enough for clients that decode the fields they asked for into structs.
+func (s *Server) handleGraphQL(w http.ResponseWriter, r *http.Request) {
+	var request graphQLRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": "Problems parsing JSON"})
+		return
+	}
+	s.record(strings.TrimSpace("POST /api/graphql " + request.OperationName))
+
+	data := map[string]interface{}{}
+	if strings.Contains(request.Query, "viewer") {
+		data["viewer"] = map[string]interface{}{"login": "githubtest"}
+	}
+	if strings.Contains(request.Query, "repository") {
+		owner, _ := request.Variables["owner"].(string)
+		name, _ := request.Variables["name"].(string)
+		if name == "" {
+			name, _ = request.Variables["repo"].(string)
+		}
+
+		repo := s.Repo(owner + "/" + name)
+		if repo == nil {
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"data":   map[string]interface{}{"repository": nil},
+				"errors": []map[string]interface{}{{"type": "NOT_FOUND", "message": fmt.Sprintf("Could not resolve to a Repository with the name '%s/%s'.", owner, name)}},
+			})
+			return
+		}
+		data["repository"] = s.repositoryObject(repo)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": data})
+}
+
+func (s *Server) repositoryObject(repo *Repo) map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var prs []map[string]interface{}
+	for _, pr := range repo.PullRequests {
+		prs = append(prs, s.pullRequestObject(repo, pr))
+	}
+
+	return map[string]interface{}{
+		"id":               repo.FullName(),
+		"name":             repo.Name,
+		"nameWithOwner":    repo.FullName(),
+		"owner":            map[string]interface{}{"login": repo.Owner},
+		"url":              s.server.URL + "/" + repo.FullName(),
+		"isArchived":       repo.Archived,
+		"viewerPermission": repo.ViewerPermission,
+		"defaultBranchRef": map[string]interface{}{"name": repo.DefaultBranch},
+		"pullRequests": map[string]interface{}{
+			"totalCount": len(prs),
+			"nodes":      prs,
+			"pageInfo":   map[string]interface{}{"hasNextPage": false},
+		},
+	}
+}
+
+func (s *Server) pullRequestObject(repo *Repo, pr *PullRequest) map[string]interface{} {
+	var labels []map[string]interface{}
+	for _, label := range pr.Labels {
+		labels = append(labels, map[string]interface{}{"name": label})
+	}
+
+	return map[string]interface{}{
+		"number":      pr.Number,
+		"title":       pr.Title,
+		"body":        pr.Body,
+		"state":       pr.State,
+		"closed":      pr.State != "OPEN",
+		"isDraft":     pr.Draft,
+		"headRefName": pr.Head,
+		"baseRefName": pr.Base,
+		"url":         fmt.Sprintf("%s/%s/pull/%d", s.server.URL, repo.FullName(), pr.Number),
+		"labels":      map[string]interface{}{"nodes": labels},
+	}
+}
+
+// handleREST implements the repos endpoints: getting a repo, forking it, and listing, creating and updating its PRs
+func (s *Server) handleREST(w http.ResponseWriter, r *http.Request) {
+	s.record(r.Method + " " + r.URL.Path)
+
+	// /api/v3/repos/OWNER/NAME[/forks|/pulls[/NUMBER]]
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v3/"), "/"), "/")
+	if len(parts) < 3 || parts[0] != "repos" {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+		return
+	}
+	repo := s.Repo(parts[1] + "/" + parts[2])
+	if repo == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+		return
+	}
+
+	switch {
+	case len(parts) == 3 && r.Method == http.MethodGet:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		writeJSON(w, http.StatusOK, restRepo(repo))
+	case len(parts) == 4 && parts[3] == "forks" && r.Method == http.MethodPost:
+		fork := s.fork(repo)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		writeJSON(w, http.StatusAccepted, restRepo(fork))
+	case len(parts) == 4 && parts[3] == "pulls" && r.Method == http.MethodGet:
+		s.listPullRequests(w, r, repo)
+	case len(parts) == 4 && parts[3] == "pulls" && r.Method == http.MethodPost:
+		s.createPullRequest(w, r, repo)
+	case len(parts) == 5 && parts[3] == "pulls" && r.Method == http.MethodPatch:
+		s.updatePullRequest(w, r, repo, parts[4])
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+	}
+}
+
+// handleRateLimit reports full rate limits, as the server does not enforce any
+func (s *Server) handleRateLimit(w http.ResponseWriter, r *http.Request) {
+	s.record(r.Method + " " + r.URL.Path)
+
+	limit := map[string]interface{}{"limit": 5000, "remaining": 5000, "reset": time.Now().Add(time.Hour).Unix()}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"resources": map[string]interface{}{"core": limit, "graphql": limit},
+	})
+}
+
+func (s *Server) fork(repo *Repo) *Repo {
+	fullName := "githubtest/" + repo.Name
+	if fork := s.Repo(fullName); fork != nil {
+		return fork
+	}
+	fork := s.AddRepo(fullName)
+	fork.DefaultBranch = repo.DefaultBranch
+	fork.ViewerPermission = "ADMIN"
+	return fork
+}
+
+func (s *Server) listPullRequests(w http.ResponseWriter, r *http.Request, repo *Repo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := r.URL.Query().Get("state")
+	if state == "" {
+		state = "open"
+	}
+	// head may be given as owner:branch
+	head := r.URL.Query().Get("head")
+	if i := strings.Index(head, ":"); i >= 0 {
+		head = head[i+1:]
+	}
+
+	prs := []map[string]interface{}{}
+	for _, pr := range repo.PullRequests {
+		if head != "" && pr.Head != head {
+			continue
+		}
+		if state != "all" && restState(pr) != state {
+			continue
+		}
+		prs = append(prs, s.restPullRequest(repo, pr))
+	}
+	writeJSON(w, http.StatusOK, prs)
+}
+
+type pullRequestInput struct {
+	Title *string `json:"title"`
+	Body  *string `json:"body"`
+	Head  string  `json:"head"`
+	Base  string  `json:"base"`
+	Draft bool    `json:"draft"`
+	State *string `json:"state"`
+}
+
+func (s *Server) createPullRequest(w http.ResponseWriter, r *http.Request, repo *Repo) {
+	var input pullRequestInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.Title == nil || input.Head == "" {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"message": "Validation Failed"})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	base := input.Base
+	if base == "" {
+		base = repo.DefaultBranch
+	}
+	body := ""
+	if input.Body != nil {
+		body = *input.Body
+	}
+	pr := &PullRequest{
+		Number: len(repo.PullRequests) + 1,
+		Title:  *input.Title,
+		Body:   body,
+		Head:   input.Head,
+		Base:   base,
+		State:  "OPEN",
+		Draft:  input.Draft,
+	}
+	repo.PullRequests = append(repo.PullRequests, pr)
+	writeJSON(w, http.StatusCreated, s.restPullRequest(repo, pr))
+}
+
+func (s *Server) updatePullRequest(w http.ResponseWriter, r *http.Request, repo *Repo, number string) {
+	var input pullRequestInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"message": "Validation Failed"})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, _ := strconv.Atoi(number)
+	for _, pr := range repo.PullRequests {
+		if pr.Number != n {
+			continue
+		}
+		if input.Title != nil {
+			pr.Title = *input.Title
+		}
+		if input.Body != nil {
+			pr.Body = *input.Body
+		}
+		if input.State != nil {
+			pr.State = strings.ToUpper(*input.State)
+		}
+		writeJSON(w, http.StatusOK, s.restPullRequest(repo, pr))
+		return
+	}
+	writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+}
+
+func (s *Server) restPullRequest(repo *Repo, pr *PullRequest) map[string]interface{} {
+	labels := []map[string]interface{}{}
+	for _, label := range pr.Labels {
+		labels = append(labels, map[string]interface{}{"name": label})
+	}
+
+	return map[string]interface{}{
+		"number":   pr.Number,
+		"title":    pr.Title,
+		"body":     pr.Body,
+		"state":    restState(pr),
+		"merged":   pr.State == "MERGED",
+		"draft":    pr.Draft,
+		"head":     map[string]interface{}{"ref": pr.Head},
+		"base":     map[string]interface{}{"ref": pr.Base},
+		"labels":   labels,
+		"html_url": fmt.Sprintf("%s/%s/pull/%d", s.server.URL, repo.FullName(), pr.Number),
+	}
+}
+
+func restRepo(repo *Repo) map[string]interface{} {
+	permissions := map[string]bool{"pull": true}
+	switch repo.ViewerPermission {
+	case "ADMIN":
+		permissions["admin"] = true
+		fallthrough
+	case "MAINTAIN", "WRITE":
+		permissions["push"] = true
+	}
+
+	return map[string]interface{}{
+		"name":           repo.Name,
+		"full_name":      repo.FullName(),
+		"owner":          map[string]interface{}{"login": repo.Owner},
+		"default_branch": repo.DefaultBranch,
+		"archived":       repo.Archived,
+		"permissions":    permissions,
+	}
+}
+
+// restState is the PR state as the REST API reports it, where merged PRs are closed
+func restState(pr *PullRequest) string {
+	if pr.State == "OPEN" {
+		return "open"
+	}
+	return "closed"
+}
+
+// FullNames lists the owner/name of every repo on the server, sorted
+func (s *Server) FullNames() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := []string{}
+	for name := range s.repos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func splitFullName(fullName string) (string, string) {
+	if i := strings.Index(fullName, "/"); i >= 0 {
+		return fullName[:i], fullName[i+1:]
+	}
+	return "", fullName
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
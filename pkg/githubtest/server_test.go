@@ -0,0 +1,174 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package githubtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestItAnswersRepositoryQueries(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	repo := server.AddRepo("org/repo1")
+	repo.DefaultBranch = "trunk"
+
+	var response struct {
+		Data struct {
+			Repository struct {
+				ViewerPermission string
+				DefaultBranchRef struct {
+					Name string
+				}
+			}
+		}
+	}
+	status := post(t, server, "/api/graphql", map[string]interface{}{
+		"query":     "query RepositoryInfo($owner: String!, $name: String!) { repository(owner: $owner, name: $name) { viewerPermission, defaultBranchRef { name } } }",
+		"variables": map[string]string{"owner": "org", "name": "repo1"},
+	}, &response)
+
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "WRITE", response.Data.Repository.ViewerPermission)
+	assert.Equal(t, "trunk", response.Data.Repository.DefaultBranchRef.Name)
+}
+
+func TestItReportsUnknownRepositories(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+
+	var response struct {
+		Errors []struct {
+			Type string
+		}
+	}
+	post(t, server, "/api/graphql", map[string]interface{}{
+		"query":     "query { repository(owner: $owner, name: $name) { id } }",
+		"variables": map[string]string{"owner": "org", "name": "missing"},
+	}, &response)
+
+	assert.Equal(t, "NOT_FOUND", response.Errors[0].Type)
+}
+
+func TestItCreatesListsAndUpdatesPullRequests(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.AddRepo("org/repo1")
+
+	var created map[string]interface{}
+	status := post(t, server, "/api/v3/repos/org/repo1/pulls", map[string]interface{}{"title": "A title", "body": "A body", "head": "campaign"}, &created)
+	assert.Equal(t, http.StatusCreated, status)
+	assert.Equal(t, float64(1), created["number"])
+
+	var listed []map[string]interface{}
+	request(t, server, http.MethodGet, "/api/v3/repos/org/repo1/pulls?head=org:campaign", nil, &listed)
+	assert.Len(t, listed, 1)
+
+	request(t, server, http.MethodPatch, "/api/v3/repos/org/repo1/pulls/1", map[string]interface{}{"state": "closed"}, nil)
+	request(t, server, http.MethodGet, "/api/v3/repos/org/repo1/pulls", nil, &listed)
+	assert.Empty(t, listed)
+
+	pr := server.Repo("org/repo1").PullRequests[0]
+	assert.Equal(t, "A title", pr.Title)
+	assert.Equal(t, "main", pr.Base)
+	assert.Equal(t, "CLOSED", pr.State)
+
+	assert.Equal(t, []string{
+		"POST /api/v3/repos/org/repo1/pulls",
+		"GET /api/v3/repos/org/repo1/pulls",
+		"PATCH /api/v3/repos/org/repo1/pulls/1",
+		"GET /api/v3/repos/org/repo1/pulls",
+	}, server.Requests())
+}
+
+func TestItForksRepos(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.AddRepo("org/repo1")
+
+	status := post(t, server, "/api/v3/repos/org/repo1/forks", map[string]interface{}{}, nil)
+	assert.Equal(t, http.StatusAccepted, status)
+	assert.Equal(t, []string{"githubtest/repo1", "org/repo1"}, server.FullNames())
+}
+
+func TestItReportsFullRateLimits(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+
+	var limits struct {
+		Resources map[string]struct {
+			Limit     int `json:"limit"`
+			Remaining int `json:"remaining"`
+		} `json:"resources"`
+	}
+	status := request(t, server, http.MethodGet, "/api/v3/rate_limit", nil, &limits)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, 5000, limits.Resources["core"].Remaining)
+	assert.Equal(t, 5000, limits.Resources["graphql"].Remaining)
+}
+
+func TestItRejectsRequestsWithoutTheToken(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+
+	response, err := server.Client().Get(server.URL() + "/api/v3/repos/org/repo1")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+}
+
+func TestItProvidesAnEnvironmentForGh(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+
+	env, err := server.Env()
+	assert.NoError(t, err)
+	assert.Contains(t, env, "GH_HOST="+server.Host())
+	assert.Contains(t, env, "GH_ENTERPRISE_TOKEN="+Token)
+	assert.True(t, strings.HasPrefix(env[3], "SSL_CERT_FILE="))
+}
+
+func post(t *testing.T, server *Server, path string, body interface{}, result interface{}) int {
+	return request(t, server, http.MethodPost, path, body, result)
+}
+
+func request(t *testing.T, server *Server, method string, path string, body interface{}, result interface{}) int {
+	var buffer bytes.Buffer
+	if body != nil {
+		_ = json.NewEncoder(&buffer).Encode(body)
+	}
+	req, _ := http.NewRequest(method, server.URL()+path, &buffer)
+	req.Header.Set("Authorization", "token "+Token)
+
+	response, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	if result != nil {
+		if err := json.NewDecoder(response.Body).Decode(result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return response.StatusCode
+}
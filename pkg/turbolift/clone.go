@@ -0,0 +1,66 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package turbolift
+
+import (
+	"context"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/logging"
+	"github.com/skyscanner/turbolift/internal/operations"
+)
+
+// CloneOptions controls how Clone clones repos. The zero value forks only the repos that cannot be pushed to.
+type CloneOptions struct {
+	// Fork forks every repo, even those that can be pushed to
+	Fork bool
+	// SyncFork brings the default branch of existing forks up to date with upstream before branching from it
+	SyncFork bool
+}
+
+// Clone clones each repo into its working copy, forking it if needed, and creates the campaign branch. Repos that are
+// already cloned are skipped, unless the campaign's branch is new to them. Repos that have been renamed are cloned
+// under their new name, which is written back to the repos file.
+func (c *Campaign) Clone(ctx context.Context, options CloneOptions) Results {
+	state, err := campaign.ReadState()
+	if err != nil {
+		return c.erroredForAll(err)
+	}
+	cloneOptions := operations.CloneOptions{
+		Fork:         options.Fork,
+		SyncFork:     options.SyncFork,
+		NewIteration: operations.NewIteration(state, c.dir),
+	}
+
+	existed := false
+	var renames []campaign.Rename
+	return c.forEachRepo(ctx, "Clone", false, func(logger *logging.Logger, repo campaign.Repo) Result {
+		outcome := operations.Clone(ctx, logger, c.clients(), c.dir, repo, cloneOptions)
+		existed = existed || outcome.Existed
+		if outcome.Rename != nil {
+			renames = append(renames, *outcome.Rename)
+		}
+		return resultOf(outcome.Outcome)
+	}, func(Results) error {
+		if err := operations.RecordClones(state, c.dir, existed, renames); err != nil {
+			return err
+		}
+		if len(renames) == 0 {
+			return nil
+		}
+		return campaign.RenameReposInFile(c.reposFile, renames)
+	})
+}
@@ -0,0 +1,43 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package turbolift
+
+import (
+	"context"
+	"errors"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/git"
+	"github.com/skyscanner/turbolift/internal/logging"
+	"github.com/skyscanner/turbolift/internal/operations"
+)
+
+// Commit commits the changes in each cloned repo with the message, signing and attributing the commits as the
+// campaign's configuration says. Repos with no changes are skipped, as are repos that already have a commit with the
+// message on the campaign branch.
+func (c *Campaign) Commit(ctx context.Context, message string) Results {
+	if message == "" {
+		return c.erroredForAll(errors.New("a commit message is required"))
+	}
+
+	options := operations.CommitOptions{
+		Message: message,
+		Git:     operations.GitCommitOptions(c.dir.Config.Commit, git.CommitOptions{}),
+	}
+	return c.forEachRepo(ctx, "Commit", true, func(logger *logging.Logger, repo campaign.Repo) Result {
+		return resultOf(operations.Commit(ctx, logger, c.clients(), c.dir, repo, options))
+	}, nil)
+}
@@ -0,0 +1,92 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package turbolift
+
+import (
+	"context"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/logging"
+	"github.com/skyscanner/turbolift/internal/operations"
+	"github.com/skyscanner/turbolift/internal/prbody"
+)
+
+// The ways that CreatePROptions.DiffSummary can summarise the changes of each PR in its description
+const (
+	DiffSummaryFiles = operations.DiffSummaryFiles
+	DiffSummaryDiff  = operations.DiffSummaryDiff
+)
+
+// CreatePROptions controls how CreatePRs raises PRs. The zero value raises PRs that are ready for review against each
+// repo's default branch.
+type CreatePROptions struct {
+	Draft bool
+	// BaseBranch is the branch to raise PRs against in repos that are not given a default-branch in the repos file
+	BaseBranch string
+	// ForceWithLease pushes with --force-with-lease, for campaign branches whose history has been rewritten
+	ForceWithLease bool
+	// AutoMerge enables auto-merge on each PR with the merge strategy, one of merge, squash or rebase, if not empty
+	AutoMerge string
+	// DiffSummary adds a summary of the changes to each PR's description, DiffSummaryFiles or DiffSummaryDiff
+	DiffSummary string
+	// UpdateExisting updates the title and description of PRs that already exist, rather than skipping them
+	UpdateExisting bool
+}
+
+// CreatePRs pushes the campaign branch of each cloned repo and raises a PR for it, with the title and description of
+// the campaign. Repos with nothing committed are skipped, as are repos that already have a PR, whose URL is still
+// given in their result. The PRs are recorded in the campaign state, as the CLI does.
+func (c *Campaign) CreatePRs(ctx context.Context, options CreatePROptions) Results {
+	prOptions := operations.CreatePROptions{
+		Pipeline:       prbody.ForCampaign(c.dir),
+		Draft:          options.Draft,
+		ForceWithLease: options.ForceWithLease,
+		DiffSummary:    options.DiffSummary,
+		AutoMerge:      options.AutoMerge,
+		UpdateExisting: options.UpdateExisting,
+	}
+
+	var tracked []campaign.TrackedPR
+	return c.forEachRepo(ctx, "CreatePRs", true, func(logger *logging.Logger, repo campaign.Repo) Result {
+		repo = operations.WithBaseBranch(repo, options.BaseBranch)
+		outcome := operations.CreatePR(ctx, logger, c.clients(), c.dir, repo, prOptions)
+		if outcome.PR != nil {
+			tracked = append(tracked, *outcome.PR)
+		}
+		result := resultOf(outcome.Outcome)
+		result.PrUrl = outcome.Url
+		return result
+	}, func(Results) error {
+		return operations.RecordPRs(tracked)
+	})
+}
+
+// UpdatePRDescriptions sets the title and description of each repo's PR to the campaign's current ones, keeping the
+// checklist items that reviewers have ticked. Repos with no PR are skipped.
+func (c *Campaign) UpdatePRDescriptions(ctx context.Context) Results {
+	pipeline := prbody.ForCampaign(c.dir)
+	return c.forEachRepo(ctx, "UpdatePRDescriptions", true, func(logger *logging.Logger, repo campaign.Repo) Result {
+		return resultOf(operations.UpdatePRDescription(ctx, logger, c.clients(), c.dir, repo, pipeline))
+	}, nil)
+}
+
+// ClosePRs closes each repo's PR. Repos whose PR has already been merged or closed are skipped, as are repos with no
+// PR and archived repos.
+func (c *Campaign) ClosePRs(ctx context.Context) Results {
+	return c.forEachRepo(ctx, "ClosePRs", true, func(logger *logging.Logger, repo campaign.Repo) Result {
+		return resultOf(operations.ClosePR(ctx, logger, c.clients(), c.dir, repo))
+	}, nil)
+}
@@ -0,0 +1,43 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package turbolift
+
+import (
+	"context"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/executor"
+	"github.com/skyscanner/turbolift/internal/logging"
+	"github.com/skyscanner/turbolift/internal/operations"
+)
+
+// Run runs a command in each cloned repo, from its working copy or the campaign's path within it, with the repo's
+// details in TURBOLIFT_* environment variables. Repos where the command fails are errored.
+func (c *Campaign) Run(ctx context.Context, command string, args ...string) Results {
+	return c.forEachRepo(ctx, "Run", true, func(logger *logging.Logger, repo campaign.Repo) Result {
+		// the command's output is what callers are after, so it is kept as it was written rather than as an activity
+		if err := operations.Run(ctx, logger.Writer(), c.clients(), c.dir, repo, operations.RunOptions{}, command, args...); err != nil {
+			return errored(err)
+		}
+		return done()
+	}, nil)
+}
+
+// RunShell runs a shell command line in each cloned repo, as Run does
+func (c *Campaign) RunShell(ctx context.Context, commandLine string) Results {
+	command, args := executor.ShellCommand(commandLine)
+	return c.Run(ctx, command, args...)
+}
@@ -0,0 +1,241 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package turbolift runs the operations of a turbolift campaign from Go: cloning its repos, running a command in
+// each, committing the changes, and creating, updating and closing PRs. It is for automation that embeds turbolift
+// rather than running the CLI. Each operation goes through every repo of the campaign, stopping early if its context
+// is done, and returns the outcome for each repo rather than logging it.
+//
+// The operations are the ones that the CLI runs, so the campaign's hooks are run and renamed repos are followed. Each
+// takes the campaign lock while it runs, as the CLI's commands do. There are no prompts, so operations go ahead as if
+// --yes had been given.
+package turbolift
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/skyscanner/turbolift/internal/bitbucket"
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/executor"
+	"github.com/skyscanner/turbolift/internal/git"
+	"github.com/skyscanner/turbolift/internal/github"
+	"github.com/skyscanner/turbolift/internal/hooks"
+	"github.com/skyscanner/turbolift/internal/logging"
+	"github.com/skyscanner/turbolift/internal/operations"
+)
+
+var (
+	gh   github.GitHub     = github.NewRealGitHub()
+	bb   github.GitHub     = bitbucket.NewBitbucketServer()
+	g    git.Git           = git.NewRealGit()
+	hk   hooks.Hooks       = hooks.NewRealHooks()
+	exec executor.Executor = executor.NewRealExecutor()
+)
+
+// Options chooses the campaign to open. The zero value opens the campaign with its usual files and branch.
+type Options struct {
+	// ReposFile lists the repos of the campaign, repos.txt by default
+	ReposFile string
+	// PrDescriptionFile holds the title and body of the campaign's PRs, README.md by default
+	PrDescriptionFile string
+	// Branch overrides the campaign's branch, if not empty
+	Branch string
+	// Force takes over the campaign lock from any command that holds it, as --force does
+	Force bool
+}
+
+// Campaign is a campaign that operations can be run on. Like the turbolift CLI, it works on the campaign in the
+// current directory, and its working copies are under work/ there.
+type Campaign struct {
+	// Name is the campaign's name, which is the name of its directory
+	Name string
+	// Branch is the branch that changes are made on in each repo
+	Branch string
+	// Repos are the campaign's repos, apart from any marked won't do
+	Repos []Repo
+
+	dir       *campaign.Campaign
+	reposFile string
+	force     bool
+}
+
+// Repo is one of the campaign's repos
+type Repo struct {
+	// FullName is the repo's name as org/repo, or host/org/repo for repos not on github.com
+	FullName string
+	// WorkingCopy is where the repo is cloned to, i.e. work/org/repo
+	WorkingCopy string
+
+	repo campaign.Repo
+}
+
+// The outcomes of an operation on a repo
+const (
+	Done    = "done"
+	Skipped = "skipped"
+	Errored = "errored"
+)
+
+// Result is the outcome of an operation on one repo
+type Result struct {
+	Repo string
+	// Outcome is Done, Skipped or Errored
+	Outcome string
+	// Reason says why the repo was skipped, e.g. "not cloned"
+	Reason string
+	// Err is why the repo errored
+	Err error
+	// Output is everything logged for the repo, including what the commands run for it wrote
+	Output string
+	// PrUrl is the PR that CreatePRs created or found for the repo, if the forge reported one
+	PrUrl string
+}
+
+// Results are the outcomes of an operation on each of the campaign's repos, in the campaign's order
+type Results []Result
+
+// Count is the number of repos with the given outcome
+func (r Results) Count(outcome string) int {
+	count := 0
+	for _, result := range r {
+		if result.Outcome == outcome {
+			count++
+		}
+	}
+	return count
+}
+
+// Err joins the errors of the repos that errored, or is nil if none did
+func (r Results) Err() error {
+	var messages []string
+	for _, result := range r {
+		if result.Outcome == Errored {
+			messages = append(messages, result.Repo+": "+result.Err.Error())
+		}
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(messages, "\n"))
+}
+
+// Open reads the campaign in the current directory
+func Open(options Options) (*Campaign, error) {
+	campaignOptions := campaign.NewCampaignOptions()
+	if options.ReposFile != "" {
+		campaignOptions.RepoFilename = options.ReposFile
+	}
+	if options.PrDescriptionFile != "" {
+		campaignOptions.PrDescriptionFilename = options.PrDescriptionFile
+	}
+	campaignOptions.BranchName = options.Branch
+
+	dir, err := campaign.OpenCampaign(campaignOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	repos := make([]Repo, 0, len(dir.Repos))
+	for _, repo := range dir.Repos {
+		repos = append(repos, Repo{FullName: repo.FullRepoName, WorkingCopy: repo.FullRepoPath(), repo: repo})
+	}
+	return &Campaign{
+		Name:      dir.Name,
+		Branch:    dir.BranchName,
+		Repos:     repos,
+		dir:       dir,
+		reposFile: campaignOptions.RepoFilename,
+		force:     options.Force,
+	}, nil
+}
+
+// forEachRepo applies the operation to each repo in turn, holding the campaign lock, until the context is done. Repos
+// that are left when it is done are skipped, as are repos that have not been cloned if the operation needs them to be.
+// Campaign-level work, such as recording what was done in the campaign state, is done by finish before the lock is
+// given up, and any error from it is added to the results against the campaign.
+func (c *Campaign) forEachRepo(ctx context.Context, name string, needsWorkingCopy bool, operation func(logger *logging.Logger, repo campaign.Repo) Result, finish func(results Results) error) Results {
+	lock, err := campaign.AcquireLock("turbolift."+name, c.force)
+	if err != nil {
+		return c.erroredForAll(err)
+	}
+
+	results := make(Results, 0, len(c.Repos))
+	for _, repo := range c.Repos {
+		if ctx.Err() != nil {
+			results = append(results, Result{Repo: repo.FullName, Outcome: Skipped, Reason: "cancelled"})
+			continue
+		}
+		if _, err := os.Stat(repo.WorkingCopy); needsWorkingCopy && os.IsNotExist(err) {
+			results = append(results, Result{Repo: repo.FullName, Outcome: Skipped, Reason: "not cloned"})
+			continue
+		}
+
+		var output bytes.Buffer
+		result := operation(logging.NewEmbeddedLogger(&output), repo.repo)
+		result.Repo = repo.FullName
+		result.Output = output.String()
+		results = append(results, result)
+	}
+
+	if finish != nil {
+		if err := finish(results); err != nil {
+			results = append(results, Result{Repo: c.Name, Outcome: Errored, Err: err})
+		}
+	}
+	if err := lock.Release(); err != nil {
+		results = append(results, Result{Repo: c.Name, Outcome: Errored, Err: err})
+	}
+	return results
+}
+
+// erroredForAll fails every repo for the same reason, for operations that cannot be started
+func (c *Campaign) erroredForAll(err error) Results {
+	results := make(Results, 0, len(c.Repos))
+	for _, repo := range c.Repos {
+		results = append(results, Result{Repo: repo.FullName, Outcome: Errored, Err: err})
+	}
+	return results
+}
+
+func (c *Campaign) clients() operations.Clients {
+	return operations.Clients{GitHub: gh, Bitbucket: bb, Git: g, Hooks: hk, Executor: exec}
+}
+
+// resultOf is the result for an outcome of one of the shared operations
+func resultOf(outcome operations.Outcome) Result {
+	switch {
+	case outcome.Err != nil:
+		return errored(outcome.Err)
+	case outcome.Skipped != "":
+		return skipped(outcome.Skipped)
+	}
+	return done()
+}
+
+func done() Result {
+	return Result{Outcome: Done}
+}
+
+func skipped(reason string) Result {
+	return Result{Outcome: Skipped, Reason: reason}
+}
+
+func errored(err error) Result {
+	return Result{Outcome: Errored, Err: err}
+}
@@ -0,0 +1,279 @@
+/*
+ * Copyright 2021 Skyscanner Limited.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ * https://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package turbolift
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skyscanner/turbolift/internal/campaign"
+	"github.com/skyscanner/turbolift/internal/executor"
+	"github.com/skyscanner/turbolift/internal/git"
+	"github.com/skyscanner/turbolift/internal/github"
+	"github.com/skyscanner/turbolift/internal/hooks"
+	"github.com/skyscanner/turbolift/internal/testsupport"
+)
+
+func TestItOpensTheCampaignInTheCurrentDirectory(t *testing.T) {
+	tempDir := testsupport.PrepareTempCampaign(false, "org/repo1", "org/repo2")
+
+	c, err := Open(Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Base(tempDir), c.Name)
+	assert.Equal(t, filepath.Base(tempDir), c.Branch)
+	assert.Equal(t, []string{"org/repo1", "org/repo2"}, repoNames(c))
+	assert.Equal(t, "work/org/repo1", c.Repos[0].WorkingCopy)
+
+	c, err = Open(Options{Branch: "other-branch"})
+	assert.NoError(t, err)
+	assert.Equal(t, "other-branch", c.Branch)
+}
+
+func TestItClonesReposThatAreNotAlreadyCloned(t *testing.T) {
+	fakeGitHub := github.NewFakeGitHub(func(command github.Command, args []string) (bool, error) {
+		// only org/repo1 can be pushed to
+		return command != github.IsPushable || args[1] == "org/repo1", nil
+	}, nil)
+	gh = fakeGitHub
+	fakeGit := git.NewAlwaysSucceedsFakeGit()
+	g = fakeGit
+	hk = hooks.NewAlwaysSucceedsFakeHooks()
+
+	tempDir := testsupport.PrepareTempCampaign(false, "org/repo1", "org/repo2", "org/repo3")
+	branch := filepath.Base(tempDir)
+	assert.NoError(t, os.MkdirAll("work/org/repo3", 0o755))
+	c := openCampaign(t)
+
+	results := c.Clone(context.Background(), CloneOptions{})
+	assert.NoError(t, results.Err())
+	assert.Equal(t, 2, results.Count(Done))
+	assert.Equal(t, "org/repo3: skipped (directory already exists)", outcomes(results)[2])
+
+	fakeGitHub.AssertCalledWith(t, [][]string{
+		{"user_can_push", "org/repo1"},
+		{"clone", "work/org", "org/repo1"},
+		{"user_can_push", "org/repo2"},
+		{"fork_and_clone", "work/org", "org/repo2"},
+		{"get_default_branch", "work/org/repo2", "org/repo2"},
+		{"user_can_push", "org/repo3"},
+	})
+	fakeGit.AssertCalledWith(t, [][]string{
+		{"checkout", "work/org/repo1", branch},
+		{"checkout", "work/org/repo2", branch},
+		{"pull", "--ff-only", "work/org/repo2", "upstream", "main"},
+	})
+}
+
+func TestItRunsCommandsInEachClonedRepo(t *testing.T) {
+	fakeExecutor := executor.NewFakeExecutor(nil, func(workingDir string, _ string, _ ...string) (string, error) {
+		if workingDir == "work/org/repo2" {
+			return "", errors.New("synthetic error")
+		}
+		return "output from " + workingDir, nil
+	})
+	exec = fakeExecutor
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+	c := openCampaign(t)
+
+	results := c.Run(context.Background(), "some", "command")
+	assert.Equal(t, Done, results[0].Outcome)
+	assert.Equal(t, "output from work/org/repo1", results[0].Output)
+	assert.Equal(t, Errored, results[1].Outcome)
+	assert.EqualError(t, results.Err(), "org/repo2: synthetic error")
+
+	fakeExecutor.AssertCalledWith(t, [][]string{
+		{"work/org/repo1", "some", "command"},
+		{"work/org/repo2", "some", "command"},
+	})
+}
+
+func TestItCommitsOnlyWhereThereAreChanges(t *testing.T) {
+	fakeGit := git.NewFakeGit(func(_ io.Writer, call []string) (bool, error) {
+		if call[0] == "isRepoChanged" {
+			return call[1] == "work/org/repo1", nil
+		}
+		return true, nil
+	})
+	g = fakeGit
+	hk = hooks.NewAlwaysSucceedsFakeHooks()
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+	testsupport.CreateAnotherRepoFile("more-repos.txt", "org/repo1", "org/repo2", "org/repo3")
+	c, err := Open(Options{ReposFile: "more-repos.txt"})
+	assert.NoError(t, err)
+
+	results := c.Commit(context.Background(), "Change things")
+	assert.NoError(t, results.Err())
+	assert.Equal(t, []string{
+		"org/repo1: done",
+		"org/repo2: skipped (no changes)",
+		"org/repo3: skipped (not cloned)",
+	}, outcomes(results))
+
+	fakeGit.AssertCalledWith(t, [][]string{
+		{"isRepoChanged", "work/org/repo1"},
+		{"campaignCommits", "work/org/repo1"},
+		{"commit", "work/org/repo1", "Change things"},
+		{"isRepoChanged", "work/org/repo2"},
+	})
+}
+
+func TestItCreatesPrsAndRecordsThem(t *testing.T) {
+	fakeGitHub := github.NewAlwaysSucceedsFakeGitHub()
+	gh = fakeGitHub
+	fakeGit := git.NewFakeGit(func(_ io.Writer, call []string) (bool, error) {
+		if call[0] == "isAheadOfDefaultBranch" {
+			return call[1] == "work/org/repo1", nil
+		}
+		return true, nil
+	})
+	g = fakeGit
+	hk = hooks.NewAlwaysSucceedsFakeHooks()
+
+	tempDir := testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+	branch := filepath.Base(tempDir)
+	c := openCampaign(t)
+
+	results := c.CreatePRs(context.Background(), CreatePROptions{})
+	assert.NoError(t, results.Err())
+	assert.Equal(t, "https://github.com/org/repo1/pull/1", results[0].PrUrl)
+	assert.Equal(t, "org/repo2: skipped (no changes committed)", outcomes(results)[1])
+
+	fakeGit.AssertCalledWith(t, [][]string{
+		{"isAheadOfDefaultBranch", "work/org/repo1"},
+		{"push", "work/org/repo1", branch},
+		{"isAheadOfDefaultBranch", "work/org/repo2"},
+	})
+	fakeGitHub.AssertCalledWith(t, [][]string{
+		{"create_pull_request", "work/org/repo1", "PR title"},
+	})
+
+	state, err := campaign.ReadState()
+	assert.NoError(t, err)
+	assert.Len(t, state.PullRequests, 1)
+	assert.Equal(t, 1, state.PullRequests[0].Number)
+}
+
+func TestItSkipsPrsThatCannotBeClosed(t *testing.T) {
+	fakeGitHub := github.NewFakeGitHub(func(command github.Command, args []string) (bool, error) {
+		switch args[1] {
+		case "work/org/merged":
+			return false, &github.PrNotOpenError{Path: args[1], State: github.PrMerged}
+		case "work/org/archived":
+			return false, &github.RepoArchivedError{Repo: "org/archived"}
+		default:
+			return true, nil
+		}
+	}, nil)
+	gh = fakeGitHub
+
+	testsupport.PrepareTempCampaign(true, "org/open", "org/merged", "org/archived")
+	c := openCampaign(t)
+
+	results := c.ClosePRs(context.Background())
+	assert.NoError(t, results.Err())
+	assert.Equal(t, []string{
+		"org/open: done",
+		"org/merged: skipped (PR for work/org/merged is already merged)",
+		"org/archived: skipped (org/archived is archived, so its PR cannot be changed)",
+	}, outcomes(results))
+}
+
+func TestItUpdatesPrDescriptions(t *testing.T) {
+	fakeGitHub := github.NewFakeGitHub(nil, func(workingDir string) (interface{}, error) {
+		return nil, &github.NoPRFoundError{Path: workingDir, BranchName: "my-campaign"}
+	})
+	gh = fakeGitHub
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+	c := openCampaign(t)
+
+	results := c.UpdatePRDescriptions(context.Background())
+	assert.NoError(t, results.Err())
+	assert.Equal(t, []string{"org/repo1: skipped (no PR found for work/org/repo1 and branch my-campaign)"}, outcomes(results))
+}
+
+func TestItSkipsTheRemainingReposOnceCancelled(t *testing.T) {
+	fakeGit := git.NewAlwaysSucceedsFakeGit()
+	g = fakeGit
+
+	testsupport.PrepareTempCampaign(true, "org/repo1", "org/repo2")
+	c := openCampaign(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results := c.Commit(ctx, "Change things")
+	assert.Equal(t, 2, results.Count(Skipped))
+	assert.Equal(t, "cancelled", results[0].Reason)
+	fakeGit.AssertCalledWith(t, [][]string{})
+}
+
+func TestItWaitsForTheCampaignLockUnlessForced(t *testing.T) {
+	fakeGit := git.NewAlwaysSucceedsFakeGit()
+	g = fakeGit
+	hk = hooks.NewAlwaysSucceedsFakeHooks()
+
+	testsupport.PrepareTempCampaign(true, "org/repo1")
+	lock, err := campaign.AcquireLock("turbolift foreach", false)
+	assert.NoError(t, err)
+	defer func() { _ = lock.Release() }()
+
+	results := openCampaign(t).Commit(context.Background(), "Change things")
+	var lockedErr *campaign.LockedError
+	assert.Equal(t, 1, results.Count(Errored))
+	assert.True(t, errors.As(results[0].Err, &lockedErr))
+	fakeGit.AssertCalledWith(t, [][]string{})
+
+	c, err := Open(Options{Force: true})
+	assert.NoError(t, err)
+	results = c.Commit(context.Background(), "Change things")
+	assert.NoError(t, results.Err())
+	assert.Equal(t, []string{"org/repo1: done"}, outcomes(results))
+}
+
+func openCampaign(t *testing.T) *Campaign {
+	c, err := Open(Options{})
+	assert.NoError(t, err)
+	return c
+}
+
+// outcomes describes each result as "org/repo: outcome (reason)", leaving out what was logged for it
+func outcomes(results Results) []string {
+	described := []string{}
+	for _, result := range results {
+		description := result.Repo + ": " + result.Outcome
+		if result.Reason != "" {
+			description += " (" + result.Reason + ")"
+		}
+		described = append(described, description)
+	}
+	return described
+}
+
+func repoNames(c *Campaign) []string {
+	names := []string{}
+	for _, repo := range c.Repos {
+		names = append(names, repo.FullName)
+	}
+	return names
+}